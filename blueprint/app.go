@@ -2,18 +2,73 @@ package blueprint
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/lib/pq"
+	"github.com/samber/lo"
 )
 
+// AppKeys are the keys of an app. SecretKey is only set when a new secret key was just created, secret keys are not
+// stored and cannot be fetched.
 type AppKeys struct {
 	PublicKey    string `json:"public_key,omitempty" db:"public_key"`
-	SecretKey    string `json:"secret_key,omitempty" db:"secret_key"`
+	SecretKey    string `json:"secret_key,omitempty"`
 	VerifySecret string `json:"verify_secret,omitempty" db:"verify_token"`
 	DeezerState  string `json:"deezer_state,omitempty" db:"deezer_state"`
 }
 
+// AppKey is a secret key of an app. The key itself is not stored, only its hash; Hint is enough of it to recognize it.
+type AppKey struct {
+	UID        uuid.UUID  `json:"id" db:"uuid"`
+	App        uuid.UUID  `json:"app" db:"app"`
+	Name       string     `json:"name" db:"name"`
+	Hint       string     `json:"hint" db:"hint"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at" db:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at" db:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at" db:"revoked_at"`
+	// Scopes are the permissions of the key. A key with no scope is unrestricted.
+	Scopes pq.StringArray `json:"scopes" db:"scopes"`
+	// Mode is AppKeyModeLive or AppKeyModeTest.
+	Mode string `json:"mode" db:"mode"`
+}
+
+// HasScopes returns true if the key has all the scopes. Unrestricted keys have every scope.
+func (k *AppKey) HasScopes(scopes ...string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range scopes {
+		if !lo.Contains(k.Scopes, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// NewAppKey is a secret key that was just created. Key is only ever returned here.
+type NewAppKey struct {
+	AppKey
+	Key string `json:"key"`
+}
+
+// CreateAppKeyData is the body of the request to create a secret key.
+type CreateAppKeyData struct {
+	Name      string     `json:"name"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Scopes restrict what the key can do, see AppKeyScopes. The key is unrestricted if none is passed.
+	Scopes []string `json:"scopes,omitempty"`
+	// Mode is live (the default) or test.
+	Mode string `json:"mode,omitempty"`
+}
+
+// RotateAppKeyData is the body of the request to rotate secret keys. The replaced keys keep working for the grace
+// period; the server default is used when it is not passed.
+type RotateAppKeyData struct {
+	GracePeriodSeconds *int `json:"grace_period_seconds,omitempty"`
+}
+
 type Organization struct {
 	ID          int       `json:"id,omitempty" db:"id"`
 	UID         uuid.UUID `json:"uid,omitempty" db:"uuid"`
@@ -110,6 +165,16 @@ type UserAppAndPlatformInfo struct {
 	ExpiresIn    sql.NullString `json:"expires_in" db:"expires_in"`
 }
 
+// UserAppTokens are the OAuth tokens of a user app. RefreshToken is encrypted.
+type UserAppTokens struct {
+	UUID         string       `db:"uuid"`
+	RefreshToken []byte       `db:"refresh_token"`
+	AccessToken  string       `db:"access_token"`
+	ExpiresIn    sql.NullTime `db:"expires_in"`
+	// ReauthRequiredAt is set when the platform rejected the tokens, until the user connects the platform again.
+	ReauthRequiredAt sql.NullTime `db:"reauth_required_at"`
+}
+
 type User struct {
 	Email               string    `json:"email" db:"email"`
 	ID                  int       `json:"id,omitempty" db:"id"`
@@ -190,7 +255,6 @@ type DeveloperApp struct {
 	Name                  string    `json:"name,omitempty" db:"name"`
 	Description           string    `json:"description,omitempty" db:"description"`
 	Developer             uuid.UUID `json:"developer,omitempty" db:"developer"`
-	SecretKey             []byte    `json:"secret_key,omitempty" db:"secret_key"`
 	PublicKey             uuid.UUID `json:"public_key,omitempty" db:"public_key"`
 	RedirectURL           string    `json:"redirect_url,omitempty" db:"redirect_url"`
 	WebhookURL            string    `json:"webhook_url,omitempty" db:"webhook_url"`
@@ -205,6 +269,13 @@ type DeveloperApp struct {
 	TidalCredentials      []byte    `json:"tidal_credentials,omitempty" db:"tidal_credentials"`
 	DeezerState           string    `json:"deezer_state,omitempty" db:"deezer_state,omitempty"`
 	WebhookAppID          string    `json:"webhook_app_id,omitempty" db:"webhook_app_id,omitempty"`
+	// the batching of the playlist conversion track webhook events. 0 means the server default is used.
+	WebhookBatchSize       int `json:"webhook_batch_size,omitempty" db:"webhook_batch_size"`
+	WebhookBatchIntervalMs int `json:"webhook_batch_interval_ms,omitempty" db:"webhook_batch_interval_ms"`
+	// Plan sets the rate limits and quotas of the app.
+	Plan string `json:"plan,omitempty" db:"plan"`
+	// Sandbox is set for the requests made with a test key of the app. It is not stored.
+	Sandbox bool `json:"-" db:"-"`
 }
 
 type UpdateDeveloperAppData struct {
@@ -277,4 +348,8 @@ type Webhook struct {
 
 type CreateNewDevAppResponse struct {
 	AppId string `json:"app_id"`
+	// SecretKey and TestSecretKey are the first live and test secret keys of the app. They are only returned when the
+	// app is created.
+	SecretKey     string `json:"secret_key"`
+	TestSecretKey string `json:"test_secret_key"`
 }
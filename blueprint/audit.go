@@ -0,0 +1,76 @@
+package blueprint
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// The actions recorded in the audit log.
+const (
+	AuditAppCreate            = "app.create"
+	AuditAppUpdate            = "app.update"
+	AuditAppDelete            = "app.delete"
+	AuditAppEnable            = "app.enable"
+	AuditAppDisable           = "app.disable"
+	AuditAppCredentialsDelete = "app.credentials.delete"
+	AuditAppKeysRevoke        = "app.keys.revoke"
+	AuditSecretKeyCreate      = "app.secret_key.create"
+	AuditSecretKeyRevoke      = "app.secret_key.revoke"
+	AuditSecretKeyRotate      = "app.secret_key.rotate"
+	AuditOrgUpdate            = "org.update"
+	AuditOrgDelete            = "org.delete"
+	AuditOrgTransfer          = "org.transfer"
+	AuditOrgMemberUpdate      = "org.member.update"
+	AuditOrgMemberRemove      = "org.member.remove"
+	AuditOrgInviteCreate      = "org.invite.create"
+	AuditOrgInviteDelete      = "org.invite.delete"
+	AuditOrgInviteAccept      = "org.invite.accept"
+)
+
+// The types of the targets of the audit log entries.
+const (
+	AuditTargetApp       = "app"
+	AuditTargetSecretKey = "secret_key"
+	AuditTargetOrg       = "org"
+	AuditTargetMember    = "member"
+	AuditTargetInvite    = "invite"
+)
+
+// AuditLogEntry is a change made to an org or one of its apps. Before and After are the fields that changed, with the
+// secrets redacted; Before is null for creations and After for deletions.
+type AuditLogEntry struct {
+	ID         int64          `json:"-" db:"id"`
+	UID        uuid.UUID      `json:"id" db:"uuid"`
+	Org        uuid.UUID      `json:"org_id" db:"org"`
+	Actor      *uuid.UUID     `json:"actor" db:"actor"`
+	ActorEmail string         `json:"actor_email,omitempty" db:"actor_email"`
+	Action     string         `json:"action" db:"action"`
+	TargetType string         `json:"target_type" db:"target_type"`
+	TargetID   string         `json:"target_id" db:"target_id"`
+	Before     types.JSONText `json:"before" db:"before"`
+	After      types.JSONText `json:"after" db:"after"`
+	IP         string         `json:"ip" db:"ip"`
+	RequestID  string         `json:"request_id" db:"request_id"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+// AuditLogFilter filters the audit log of an org. Empty fields are not filtered on. Cursor is the cursor of the page,
+// from the previous page.
+type AuditLogFilter struct {
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	From       *time.Time
+	To         *time.Time
+	Cursor     int64
+	Limit      int
+}
+
+// AuditLogPage is a page of the audit log, the most recent entries first. NextCursor is empty on the last page.
+type AuditLogPage struct {
+	Entries    []AuditLogEntry `json:"entries"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
@@ -8,6 +8,8 @@ import (
 type LoginToOrgData struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	// OrgID is the organization to log into, for users that are members of several. Defaults to the one they own.
+	OrgID string `json:"org_id,omitempty"`
 }
 
 type LoginOrgToken struct {
@@ -25,10 +27,11 @@ type AppJWT struct {
 	OrgID       string `json:"organization_id"`
 }
 
+// AuthMiddlewareUserInfo is the user of a user action request. The user's tokens are fetched with the token manager
+// (services/tokens).
 type AuthMiddlewareUserInfo struct {
-	Platform     string `json:"platform"`
-	PlatformID   string `json:"platform_id"`
-	RefreshToken string `json:"refresh_token"`
+	Platform   string `json:"platform"`
+	PlatformID string `json:"platform_id"`
 }
 
 // AppAuthToken is the token generated after a user tries to authorize an app. This is the one passed to the state in the platform's redirect URL for plaforms
@@ -75,6 +78,8 @@ type OrchdioLoginUserResponse struct {
 	Description string     `json:"description"`
 	Token       string     `json:"token"`
 	Apps        *[]AppInfo `json:"apps"`
+	// Role is the role of the user in the organization.
+	Role string `json:"role,omitempty"`
 }
 
 type UserAuthInfoForRequests struct {
@@ -1,6 +1,7 @@
 package blueprint
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 
@@ -21,12 +22,16 @@ const (
 	PlaylistConversionTaskTypePattern = "playlist_conversion_"
 	SendResetPasswordTaskPattern      = "send_reset_password_email"
 	SendWelcomeEmailTaskPattern       = "send_welcome_email"
+	SendOrgInviteEmailTaskPattern     = "send_org_invite_email"
+	WebhookDeliveryTaskTypePattern    = "webhook_delivery"
+	FollowTaskTypePattern             = "follow_sync_"
 )
 
 const (
 	PlaylistConversionQueueName = "playlist_conversion"
 	EmailQueueName              = "email"
 	DefaultQueueName            = "default"
+	WebhookDeliveryQueueName    = "webhooks"
 )
 
 // PlaylistConversionMetadataEvent is the event emitted when the meta of a playlist conversion is done.
@@ -37,6 +42,8 @@ var (
 	PlaylistConversionTrackEvent        = "playlist_conversion_track"
 	PlaylistConversionDoneEvent         = "playlist_conversion_done"
 	PlaylistConversionMissingTrackEvent = "playlist_conversion_missing_track"
+	UserPlatformDisconnectedEvent       = "user_platform_disconnected"
+	UserPlatformAuthRevokedEvent        = "user_platform_auth_revoked"
 )
 
 const (
@@ -46,6 +53,38 @@ const (
 	DeezerStateType = "deezer_state"
 )
 
+// SecretKeyPrefix is the prefix of the live secret keys of the apps, so that leaked keys are easy to recognize.
+const SecretKeyPrefix = "orch_sk_live_"
+
+// TestSecretKeyPrefix is the prefix of the test secret keys of the apps.
+const TestSecretKeyPrefix = "orch_sk_test_"
+
+// The modes of the secret keys. The requests made with a test key run in the sandbox: the platforms are replaced by
+// fake ones with a fixed catalogue, so that apps can be integrated without real platform credentials.
+const (
+	AppKeyModeLive = "live"
+	AppKeyModeTest = "test"
+)
+
+// DefaultAppKeyName is the name of the secret key created with an app.
+const DefaultAppKeyName = "default"
+
+// The permission scopes of the secret keys. A key with no scope has them all.
+const (
+	ScopeConvertTrack    = "convert:track"
+	ScopeConvertPlaylist = "convert:playlist"
+	ScopeTasksRead       = "tasks:read"
+	ScopeLibraryRead     = "library:read"
+	ScopeLibraryWrite    = "library:write"
+	ScopeFollowWrite     = "follow:write"
+	ScopeAccountRead     = "account:read"
+	ScopeAccountWrite    = "account:write"
+)
+
+// AppKeyScopes are all the scopes a secret key can be given.
+var AppKeyScopes = []string{ScopeConvertTrack, ScopeConvertPlaylist, ScopeTasksRead, ScopeLibraryRead, ScopeLibraryWrite,
+	ScopeFollowWrite, ScopeAccountRead, ScopeAccountWrite}
+
 var ValidUserIdentifiers = []string{"email", "id"}
 
 // perhaps have a different Error type declarations somewhere. For now, be here
@@ -69,6 +108,16 @@ var (
 	ErrInvalidPlatform    = errors.New("invalid platform")
 	ErrNoCredentials      = errors.New("no credentials")
 	ErrBadCredentials     = errors.New("bad credentials")
+	// ErrUserAuthRevoked is returned when a platform rejects the tokens of a user, e.g. because the user removed the app
+	// from their platform account. The user has to connect the platform again.
+	ErrUserAuthRevoked = errors.New("user authorization revoked")
+	// ErrAccessTokenRejected is returned when a platform rejects the access token of a user. The token is refreshed and
+	// the request retried: the authorization of the user is only revoked if the refresh token is rejected too.
+	ErrAccessTokenRejected = errors.New("access token rejected")
+	// ErrInviteNotPending is returned when an invitation to an org was accepted (or deleted) in the meantime.
+	ErrInviteNotPending = errors.New("invitation not pending")
+	// ErrUserExists is returned when a user is created with the email of a user created in the meantime.
+	ErrUserExists = errors.New("user already exists")
 
 	// possible auth errors from each of the streaming platforms
 
@@ -88,6 +137,11 @@ const (
 	TaskStatusFailed    = "failed"
 )
 
+const (
+	NotificationStatusUnread = "unread"
+	NotificationStatusRead   = "read"
+)
+
 type UserProfile struct {
 	Email     string      `json:"email" db:"email"`
 	Usernames interface{} `json:"usernames" db:"usernames"`
@@ -140,6 +194,8 @@ type LinkInfo struct {
 	App            string `json:"app,omitempty"`
 	Developer      string `json:"developer,omitempty"`
 	TaskID         string `json:"task_id,omitempty"`
+	// Sandbox is true if the conversion was requested with a test key, see AppKeyModeTest.
+	Sandbox bool `json:"sandbox,omitempty"`
 
 	// uniqueID stands for the shortURL (some places in the code) and short_id (as stored in the DB)
 	// this is what we end up sending to the user to be able to access a conversion (playlist or track) data.
@@ -234,6 +290,41 @@ type FollowTaskData struct {
 	//Subscribers interface{} `json:"subscribers"`
 }
 
+// Notification represents a notification record for a user, in the db. data holds the payload of the
+// notification, for example the updated playlist for a follow notification.
+type Notification struct {
+	UID       uuid.UUID       `json:"id" db:"uuid"`
+	User      uuid.UUID       `json:"user" db:"user"`
+	App       uuid.UUID       `json:"app" db:"app"`
+	Status    string          `json:"status" db:"status"`
+	Data      json.RawMessage `json:"data" db:"data"`
+	CreatedAt time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at" db:"updated_at"`
+	ReadAt    *time.Time      `json:"read_at,omitempty" db:"read_at"`
+}
+
+// NotificationFilter holds the (optional) filters and pagination for fetching a user's notifications.
+type NotificationFilter struct {
+	Status string
+	Since  *time.Time
+	Limit  int
+	Offset int
+}
+
+// PaginatedNotifications is the response returned when fetching a user's notifications.
+type PaginatedNotifications struct {
+	Notifications []Notification `json:"notifications"`
+	Total         int            `json:"total"`
+	Limit         int            `json:"limit"`
+	Offset        int            `json:"offset"`
+}
+
+// MarkNotificationsReadBody is the body for marking notifications as read in bulk. if no ids
+// are passed, all the unread notifications of the user are marked as read.
+type MarkNotificationsReadBody struct {
+	IDs []string `json:"ids"`
+}
+
 type WebhookVerificationResponse struct {
 	VerifyToken     string `json:"verify_token"`
 	VerifyChallenge string `json:"verify_challenge"`
@@ -252,6 +343,8 @@ type EmailTaskData struct {
 	TaskID     string                 `json:"task_id"`
 	TemplateID int                    `json:"template_id"`
 	Subject    string                 `json:"subject,omitempty"`
+	// RequestID is the id of the request that sent the email, logged with the records of the task.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 type ExtractedTitleInfo struct {
@@ -260,21 +353,8 @@ type ExtractedTitleInfo struct {
 	Subtitle string   `json:"subtitle"`
 }
 
-type OrchdioLoggerOptions struct {
-	Component            string      `json:"component"`
-	RequestID            string      `json:"request_id"`
-	Timestamp            string      `json:"timestamp"`
-	ApplicationPublicKey string      `json:"application_public_key"`
-	AppID                string      `json:"app_id"`
-	Platform             string      `json:"platform"`
-	Entity               interface{} `json:"entity"`
-	Error                interface{} `json:"error"`
-	Message              string      `json:"message"`
-	AddTrace             bool        `json:"add_trace"`
-}
-
+// PlaylistConversionDoneEventMetadata is the data of the playlist_conversion_done webhook event.
 type PlaylistConversionDoneEventMetadata struct {
-	EventType string `json:"event_type" default:"playlist_conversion_done"`
 	// the unique (orchdio internal) task id for this conversion
 	TaskID string `json:"task_id,omitempty"`
 	// the PlaylistID of the playlist that was converted
@@ -286,16 +366,40 @@ type PlaylistConversionDoneEventMetadata struct {
 	UniqueID string `json:"unique_id,omitempty"`
 }
 
+// UserPlatformDisconnectedEventPayload is the data of the event sent when a user disconnects a platform from an app.
+type UserPlatformDisconnectedEventPayload struct {
+	UserID   string `json:"user_id"`
+	Platform string `json:"platform"`
+	// Revoked is true if the token was revoked on the platform too. When it is false, the user has to remove the app from
+	// their platform account settings to revoke its access.
+	Revoked        bool      `json:"revoked"`
+	DisconnectedAt time.Time `json:"disconnected_at"`
+}
+
+// UserPlatformAuthRevokedEventPayload is the data of the event sent when a platform rejects the tokens of a user. It is
+// also the error of the 401 responses of the requests that failed because of it.
+type UserPlatformAuthRevokedEventPayload struct {
+	UserID   string `json:"user_id"`
+	Platform string `json:"platform"`
+	// ReconnectURL is the Orchdio connect URL of the platform (GET, with the app's public key header) that returns the
+	// platform authorization URL to send the user to.
+	ReconnectURL string    `json:"reconnect_url"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
 type MissingTrackMeta struct {
 	Platform        string            `json:"platform"`
 	MissingPlatform string            `json:"missing_platform"`
 	Item            TrackSearchResult `json:"item"`
 }
 
+// MissingTrackEventPayload is the data of the playlist_conversion_missing_track webhook event.
 type MissingTrackEventPayload struct {
-	EventType string           `json:"event_type" default:"playlist_conversion_missing_track"`
-	TaskID    string           `json:"task_id"`
-	TrackMeta MissingTrackMeta `json:"meta"`
+	TaskID string `json:"task_id"`
+	// TrackMeta is the first missing track of the event. It is kept for backwards compatibility, Tracks has all
+	// the missing tracks of the event when the events are batched.
+	TrackMeta MissingTrackMeta   `json:"meta"`
+	Tracks    []MissingTrackMeta `json:"tracks,omitempty"`
 }
 
 type PlaylistConversionTrackItem struct {
@@ -0,0 +1,90 @@
+package blueprint
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The roles of the members of an organization, from the most to the least privileged. Owners can do everything,
+// including deleting the org and transferring it; admins manage the org, its members and its apps; developers create
+// and manage apps; viewers can only read.
+const (
+	OrgRoleOwner     = "owner"
+	OrgRoleAdmin     = "admin"
+	OrgRoleDeveloper = "developer"
+	OrgRoleViewer    = "viewer"
+)
+
+// OrgRoles are the roles of the members of an organization.
+var OrgRoles = []string{OrgRoleOwner, OrgRoleAdmin, OrgRoleDeveloper, OrgRoleViewer}
+
+var orgRoleRanks = map[string]int{
+	OrgRoleOwner:     4,
+	OrgRoleAdmin:     3,
+	OrgRoleDeveloper: 2,
+	OrgRoleViewer:    1,
+}
+
+// IsValidOrgRole returns true if the role is one of OrgRoles.
+func IsValidOrgRole(role string) bool {
+	_, ok := orgRoleRanks[role]
+	return ok
+}
+
+// OrgRoleAtLeast returns true if the role is the min role or a more privileged one. Unknown roles have no privilege.
+func OrgRoleAtLeast(role, min string) bool {
+	rank, ok := orgRoleRanks[role]
+	return ok && rank >= orgRoleRanks[min]
+}
+
+// OrgMember is a member of an organization.
+type OrgMember struct {
+	Org       uuid.UUID `json:"org_id" db:"org"`
+	User      uuid.UUID `json:"user_id" db:"user"`
+	Email     string    `json:"email" db:"email"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// UserOrganization is an organization a user is a member of, with the role of the user.
+type UserOrganization struct {
+	Organization
+	Role string `json:"role" db:"role"`
+}
+
+// OrgInvite is an invitation to join an organization. The invitation token is only sent by email.
+type OrgInvite struct {
+	UID        uuid.UUID  `json:"id" db:"uuid"`
+	Org        uuid.UUID  `json:"org_id" db:"org"`
+	Email      string     `json:"email" db:"email"`
+	Role       string     `json:"role" db:"role"`
+	InvitedBy  *uuid.UUID `json:"invited_by" db:"invited_by"`
+	ExpiresAt  time.Time  `json:"expires_at" db:"expires_at"`
+	AcceptedAt *time.Time `json:"accepted_at" db:"accepted_at"`
+	CreatedAt  time.Time  `json:"created_at" db:"created_at"`
+}
+
+// InviteOrgMemberData is the body of the request to invite someone to an organization.
+type InviteOrgMemberData struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+// UpdateOrgMemberData is the body of the request to change the role of a member.
+type UpdateOrgMemberData struct {
+	Role string `json:"role"`
+}
+
+// AcceptOrgInviteData is the body of the request to accept an invitation. Password is the password of the account of
+// the invited email; it is set if the account has none yet.
+type AcceptOrgInviteData struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// TransferOrgData is the body of the request to transfer an organization to another of its members.
+type TransferOrgData struct {
+	UserID string `json:"user_id"`
+}
@@ -64,19 +64,20 @@ type PlaylistMetadata struct {
 	ID          string `json:"id"`
 }
 
+// PlaylistConversionEventMetadata is the data of the playlist_conversion_metadata webhook event.
 type PlaylistConversionEventMetadata struct {
-	EventType string            `json:"event_type" default:"playlist_conversion_metadata"`
-	Platform  string            `json:"platform"`
-	TaskId    string            `json:"task_id"`
-	UniqueID  string            `json:"unique_id"`
-	Meta      *PlaylistMetadata `json:"meta"`
+	Platform string            `json:"platform"`
+	TaskId   string            `json:"task_id"`
+	UniqueID string            `json:"unique_id"`
+	Meta     *PlaylistMetadata `json:"meta"`
 }
 
 type PlaylistConversionEventTrack struct {
-	EventType string             `json:"event_type" default:"playlist_conversion_track"`
-	Platform  string             `json:"platform"`
-	TaskId    string             `json:"task_id"`
-	Track     *TrackSearchResult `json:"track"`
+	Platform string             `json:"platform"`
+	TaskId   string             `json:"task_id"`
+	Track    *TrackSearchResult `json:"track"`
+	// App is the developer app of the conversion, sent in the event envelope.
+	App string `json:"-"`
 }
 
 //	{
@@ -118,10 +119,10 @@ type PlaylistConversionEventTrack struct {
 // ]
 // }
 // PlaylistTrackConversionEventResponse represents the event response sent to webhook when a track in a playlist is converted.
+// It is the data of the playlist_conversion_track webhook event.
 type PlaylistTrackConversionEventResponse struct {
-	TaskID    string                                `json:"task_id"`
-	EventType string                                `json:"event_type"`
-	Tracks    []PlaylistTrackConversionEventPayload `json:"tracks"`
+	TaskID string                                `json:"task_id"`
+	Tracks []PlaylistTrackConversionEventPayload `json:"tracks"`
 }
 
 type PlaylistTrackConversionEventPayload struct {
@@ -172,6 +173,8 @@ type PlaylistTaskData struct {
 	App      *DeveloperApp `json:"app"`
 	TaskID   string        `json:"task_id"`
 	ShortURL string        `json:"short_url"`
+	// RequestID is the id of the request that queued the conversion, logged with the records of the task.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 type AddPlaylistToAccountData struct {
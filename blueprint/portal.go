@@ -0,0 +1,72 @@
+package blueprint
+
+// The types of the messages sent by clients on the portal websocket.
+const (
+	// PortalMessageConvert converts the track or playlist in the url of the message to the target platform.
+	PortalMessageConvert = "convert"
+	// PortalMessageSubscribe subscribes the client to the events of the task in the message.
+	PortalMessageSubscribe = "subscribe"
+	// PortalMessageUnsubscribe unsubscribes the client from the events of the task in the message.
+	PortalMessageUnsubscribe = "unsubscribe"
+	// PortalMessagePing is answered with a PortalMessagePong. Clients that do not send any message for a while are
+	// disconnected, so idle clients should send a ping every heartbeat_interval seconds (sent in the
+	// PortalMessageConnected message).
+	PortalMessagePing = "ping"
+)
+
+// The types of the messages sent by the server on the portal websocket.
+const (
+	PortalMessageConnected    = "connected"
+	PortalMessagePong         = "pong"
+	PortalMessageHeartbeat    = "heartbeat"
+	PortalMessageConversion   = "conversion"
+	PortalMessageTask         = "task"
+	PortalMessageSubscribed   = "subscribed"
+	PortalMessageUnsubscribed = "unsubscribed"
+	PortalMessageEvent        = "event"
+	PortalMessageProgress     = "progress"
+	PortalMessageError        = "error"
+)
+
+// PortalRequest is a message sent by a client on the portal websocket.
+type PortalRequest struct {
+	Type string `json:"type"`
+	// ID is set by the client to match the responses to the message. It is sent back in the responses.
+	ID             string `json:"id,omitempty"`
+	URL            string `json:"url,omitempty"`
+	TargetPlatform string `json:"target_platform,omitempty"`
+	TaskID         string `json:"task_id,omitempty"`
+	// LastEventID is the id of the last event of the task the client got, to resume a subscription.
+	LastEventID int64 `json:"last_event_id,omitempty"`
+}
+
+// PortalResponse is a message sent by the server on the portal websocket.
+type PortalResponse struct {
+	Type    string      `json:"type"`
+	ID      string      `json:"id,omitempty"`
+	TaskID  string      `json:"task_id,omitempty"`
+	EventID int64       `json:"event_id,omitempty"`
+	Data    interface{} `json:"data,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// PortalConnected is the data of the PortalMessageConnected message, sent once a client is connected.
+type PortalConnected struct {
+	ID                string `json:"id"`
+	App               string `json:"app"`
+	HeartbeatInterval int    `json:"heartbeat_interval"`
+}
+
+// PortalSubscription is the data of the PortalMessageSubscribed message.
+type PortalSubscription struct {
+	TaskID string `json:"task_id"`
+	Status string `json:"status"`
+}
+
+// PortalTaskProgress is the data of the PortalMessageProgress message, sent after each event of a playlist
+// conversion that changes its progress. Total is the number of tracks in the playlist.
+type PortalTaskProgress struct {
+	Total     int `json:"total"`
+	Converted int `json:"converted"`
+	Missing   int `json:"missing"`
+}
@@ -0,0 +1,46 @@
+package blueprint
+
+// The usage events recorded for the apps.
+const (
+	UsageTrackConversion    = "track_conversion"
+	UsagePlaylistConversion = "playlist_conversion"
+	UsageLibraryRead        = "library_read"
+	UsageLibraryWrite       = "library_write"
+)
+
+// The granularities of the usage reports.
+const (
+	UsageGranularityDay   = "day"
+	UsageGranularityMonth = "month"
+)
+
+// UsageEvent is something an app used, e.g. a track conversion. Tracks is the number of tracks it involved, if any.
+type UsageEvent struct {
+	App      string
+	Event    string
+	Platform string
+	Endpoint string
+	Count    int64
+	Tracks   int64
+}
+
+// AppUsage is the usage of an app in a period (a day, YYYY-MM-DD, or a month, YYYY-MM) for an event, platform and
+// endpoint.
+type AppUsage struct {
+	Period   string `json:"period" db:"period"`
+	App      string `json:"app_id" db:"app"`
+	AppName  string `json:"app_name" db:"app_name"`
+	Event    string `json:"event" db:"event"`
+	Platform string `json:"platform" db:"platform"`
+	Endpoint string `json:"endpoint" db:"endpoint"`
+	Count    int64  `json:"count" db:"count"`
+	Tracks   int64  `json:"tracks" db:"tracks"`
+}
+
+// UsageReport is the usage of the apps of an organization between two days.
+type UsageReport struct {
+	From        string     `json:"from"`
+	To          string     `json:"to"`
+	Granularity string     `json:"granularity"`
+	Usage       []AppUsage `json:"usage"`
+}
@@ -0,0 +1,165 @@
+package blueprint
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// WebhookEventVersion is the current version of the webhook event envelope and the event payloads (data). It is bumped
+// whenever a breaking change is made to either.
+const WebhookEventVersion = 1
+
+const (
+	WebhookMessageStatusPending   = "pending"
+	WebhookMessageStatusDelivered = "delivered"
+	// WebhookMessageStatusFailed is the (dead-letter) status of a message that has exhausted all its delivery attempts
+	WebhookMessageStatusFailed = "failed"
+)
+
+// WebhookEndpoint represents an endpoint record for the built-in webhook sender, in the db.
+type WebhookEndpoint struct {
+	UID          uuid.UUID      `json:"id" db:"uuid"`
+	WebhookAppID string         `json:"webhook_app_id" db:"webhook_app_id"`
+	EndpointUID  string         `json:"uid" db:"uid"`
+	URL          string         `json:"url" db:"url"`
+	FilterTypes  pq.StringArray `json:"filter_types" db:"filter_types"`
+	Disabled     bool           `json:"disabled" db:"disabled"`
+	CreatedAt    time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// WebhookMessageRecord represents a single event to be delivered to a webhook endpoint by the built-in webhook sender.
+type WebhookMessageRecord struct {
+	UID          uuid.UUID       `json:"id" db:"uuid"`
+	EventID      uuid.UUID       `json:"event_id" db:"event_id"`
+	WebhookAppID string          `json:"webhook_app_id" db:"webhook_app_id"`
+	Endpoint     uuid.UUID       `json:"endpoint" db:"endpoint"`
+	EventType    string          `json:"event_type" db:"event_type"`
+	Payload      json.RawMessage `json:"payload" db:"payload"`
+	Status       string          `json:"status" db:"status"`
+	Attempts     int             `json:"attempts" db:"attempts"`
+	LastError    string          `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt    time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time       `json:"updated_at" db:"updated_at"`
+	DeliveredAt  *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+// WebhookMessageDelivery holds the information needed to deliver a webhook message. It's the message, the
+// url of its endpoint and the verify token of the app, used to sign the payload.
+type WebhookMessageDelivery struct {
+	WebhookMessageRecord
+	URL              string `db:"url"`
+	EndpointDisabled bool   `db:"endpoint_disabled"`
+	VerifyToken      string `db:"verify_token"`
+}
+
+// WebhookMessageAttempt represents a delivery attempt of a webhook message.
+type WebhookMessageAttempt struct {
+	UID            uuid.UUID `json:"id" db:"uuid"`
+	Message        uuid.UUID `json:"message" db:"message"`
+	URL            string    `json:"url" db:"url"`
+	ResponseStatus int       `json:"response_status" db:"response_status"`
+	Response       string    `json:"response" db:"response"`
+	Error          string    `json:"error,omitempty" db:"error"`
+	DurationMs     int64     `json:"duration_ms" db:"duration_ms"`
+	CreatedAt      time.Time `json:"created_at" db:"created_at"`
+}
+
+// WebhookDeliveryTaskData is the payload of the queue task that delivers a webhook message.
+type WebhookDeliveryTaskData struct {
+	MessageID string `json:"message_id"`
+}
+
+// WebhookEvent is the (versioned) envelope of every webhook event sent to developer apps. Data holds the payload
+// of the event type.
+type WebhookEvent struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	App       string    `json:"app,omitempty"`
+	TaskID    string    `json:"task_id,omitempty"`
+	// Test is true for the synthetic events fired to test an endpoint.
+	Test bool        `json:"test,omitempty"`
+	Data interface{} `json:"data"`
+}
+
+// NewWebhookEvent returns a new webhook event envelope for the event type.
+func NewWebhookEvent(eventType, app, taskId string, data interface{}) *WebhookEvent {
+	return &WebhookEvent{
+		ID:        uuid.NewString(),
+		Type:      eventType,
+		Version:   WebhookEventVersion,
+		CreatedAt: time.Now().UTC(),
+		App:       app,
+		TaskID:    taskId,
+		Data:      data,
+	}
+}
+
+// ToWebhookEvent returns the payload passed as a webhook event envelope. If the payload is already an envelope,
+// it is returned as is, otherwise it is wrapped in a new envelope for the event type.
+func ToWebhookEvent(eventType string, payload interface{}) *WebhookEvent {
+	if event, ok := payload.(*WebhookEvent); ok {
+		return event
+	}
+	return NewWebhookEvent(eventType, "", "", payload)
+}
+
+// NewTrackEvent returns the playlist_conversion_track event envelope for a single converted track.
+func NewTrackEvent(app string, out *PlaylistConversionEventTrack) *WebhookEvent {
+	return NewWebhookEvent(PlaylistConversionTrackEvent, app, out.TaskId, &PlaylistTrackConversionEventResponse{
+		TaskID: out.TaskId,
+		Tracks: []PlaylistTrackConversionEventPayload{
+			{
+				Platform: out.Platform,
+				Track:    out.Track,
+			},
+		},
+	})
+}
+
+// UpdateWebhookEventTypesData is the request body to update the event types the webhook endpoint of an app is subscribed to.
+// An empty list subscribes the endpoint to all event types.
+type UpdateWebhookEventTypesData struct {
+	EventTypes []string `json:"event_types"`
+}
+
+// WebhookMessageFilter is the filter used to list the webhook messages sent to an app. Messages are listed from the most
+// recent and Iterator is the id of the last message of the previous page.
+type WebhookMessageFilter struct {
+	Limit      int
+	Iterator   string
+	Before     *time.Time
+	After      *time.Time
+	EventTypes []string
+}
+
+// ReplayWebhookMessagesData is the request body to replay the failed webhook messages of an app sent within a time window.
+type ReplayWebhookMessagesData struct {
+	Since time.Time  `json:"since"`
+	Until *time.Time `json:"until,omitempty"`
+}
+
+// TestWebhookEventData is the request body to fire a test webhook event.
+type TestWebhookEventData struct {
+	EventType string `json:"event_type"`
+}
+
+const (
+	// MaxWebhookBatchSize is the max number of tracks that can be sent in a single playlist conversion track (or missing track) event.
+	MaxWebhookBatchSize = 100
+	// MaxWebhookBatchIntervalMs is the max time tracks can be held before a playlist conversion track (or missing track) event is sent.
+	MaxWebhookBatchIntervalMs = 60000
+)
+
+// WebhookBatchSettings is the batching of the playlist conversion track (and missing track) webhook events of an app.
+// Tracks are sent once Size tracks have been converted or IntervalMs has passed since the last event, whichever comes
+// first. A size of 1 sends an event per track. 0 means the server default is used.
+type WebhookBatchSettings struct {
+	Size       int `json:"size"`
+	IntervalMs int `json:"interval_ms"`
+}
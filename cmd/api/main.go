@@ -0,0 +1,41 @@
+// The API of Orchdio. The tasks it enqueues are processed by the worker (cmd/worker), and the database must be migrated
+// beforehand (cmd/migrate): the API is not ready until it is.
+package main
+
+import (
+	"log"
+	"orchdio/health"
+	"orchdio/wiring"
+	"os"
+)
+
+func main() {
+	deps, err := wiring.Setup()
+	if err != nil {
+		log.Fatalf("⛔ %v", err)
+	}
+	defer deps.Close()
+
+	checker := deps.NewChecker(append(deps.Checks(), health.Platforms(deps.Config.Platforms)...)...)
+	app := wiring.NewAPI(deps, checker)
+
+	httpDrainTimeout := deps.Config.HTTP.ShutdownTimeout
+	serverShutdown := make(chan struct{})
+	go func() {
+		wiring.WaitForSignal()
+		log.Printf("[api] [info] - ❗🚂 Shutting down server")
+		if sErr := app.ShutdownWithTimeout(httpDrainTimeout); sErr != nil {
+			log.Printf("[api] [warning] - ⛔ Requests still in flight after %v: %v", httpDrainTimeout, sErr)
+		}
+		close(serverShutdown)
+	}()
+
+	port := deps.Address()
+	log.Printf("✅ 🚀 API is up and running on port: %s", port)
+	if err = app.Listen(port); err != nil {
+		log.Printf("⛔ 🚂 Error starting server: %v\n", err)
+		os.Exit(1)
+	}
+	<-serverShutdown
+	log.Printf("[api] [info] - 🚧 🧹 API shut down.")
+}
@@ -0,0 +1,34 @@
+// Migrates the database of Orchdio to the latest migration, as a step of the deployments. The process exits with a
+// non-zero status if the migrations fail.
+package main
+
+import (
+	"log"
+	"orchdio/config"
+	"orchdio/db"
+	"orchdio/logger"
+	"orchdio/wiring"
+)
+
+func main() {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("⛔ %v", err)
+	}
+	appLogger, err := logger.Init(cfg.Log)
+	if err != nil {
+		log.Fatalf("⛔ Could not set up the logger: %v", err)
+	}
+	defer func() { _ = appLogger.Sync() }()
+
+	dbase, err := db.ConnectDB(cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("⛔ Could not connect to the database: %v", err)
+	}
+	defer func() { _ = dbase.Close() }()
+
+	if err = wiring.Migrate(dbase); err != nil {
+		_ = appLogger.Sync()
+		log.Fatalf("⛔ Could not migrate the database: %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+// The scheduler of the background jobs of Orchdio (see wiring.NewScheduler). The jobs run on every scheduler, so a
+// single scheduler must be deployed. It serves the probes on PORT.
+package main
+
+import (
+	"log"
+	"orchdio/wiring"
+	"os"
+)
+
+func main() {
+	deps, err := wiring.Setup()
+	if err != nil {
+		log.Fatalf("⛔ %v", err)
+	}
+	defer deps.Close()
+
+	scheduler, err := wiring.NewScheduler(deps)
+	if err != nil {
+		log.Fatalf("⛔ Error setting up the scheduler: %v", err)
+	}
+	scheduler.Start()
+
+	probes := wiring.NewProbes(deps, deps.NewChecker(deps.Checks()...))
+	schedulerShutdown := make(chan struct{})
+	go func() {
+		wiring.WaitForSignal()
+		log.Printf("[scheduler] [info] - ❗⏲️ Shutting down scheduler")
+		scheduler.Stop(deps.Config.HTTP.ShutdownTimeout)
+		_ = probes.Shutdown()
+		close(schedulerShutdown)
+	}()
+
+	port := deps.Address()
+	log.Printf("✅ ⏲️ Scheduler is up and running, probes on port: %s", port)
+	if err = probes.Listen(port); err != nil {
+		log.Printf("⛔ Error starting the probes server: %v\n", err)
+		os.Exit(1)
+	}
+	<-schedulerShutdown
+	log.Printf("[scheduler] [info] - 🚧 🧹 Scheduler shut down.")
+}
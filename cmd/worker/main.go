@@ -0,0 +1,42 @@
+// The queue worker of Orchdio: it processes the playlist conversions, the emails, the webhook deliveries and the
+// follows enqueued by the API and the scheduler. It serves the probes and the metrics of the queues on PORT.
+package main
+
+import (
+	"log"
+	"orchdio/wiring"
+	"os"
+)
+
+func main() {
+	deps, err := wiring.Setup()
+	if err != nil {
+		log.Fatalf("⛔ %v", err)
+	}
+	defer deps.Close()
+
+	worker := wiring.NewWorker(deps)
+	if err = worker.Start(deps); err != nil {
+		log.Fatalf("⛔ Error starting the worker: %v", err)
+	}
+
+	probes := wiring.NewProbes(deps, deps.NewChecker(append(deps.Checks(), worker.Checks(deps)...)...))
+	workerShutdown := make(chan struct{})
+	go func() {
+		wiring.WaitForSignal()
+		log.Printf("[worker] [info] - ❗🏭 Shutting down worker")
+		worker.Shutdown()
+		_ = probes.Shutdown()
+		close(workerShutdown)
+	}()
+
+	port := deps.Address()
+	log.Printf("✅ 🏭 Worker is up and running, probes on port: %s", port)
+	if err = probes.Listen(port); err != nil {
+		log.Printf("⛔ Error starting the probes server: %v\n", err)
+		worker.Shutdown()
+		os.Exit(1)
+	}
+	<-workerShutdown
+	log.Printf("[worker] [info] - 🚧 🧹 Worker shut down.")
+}
@@ -0,0 +1,437 @@
+// Package config is the configuration of Orchdio. It is loaded once at startup from the env (and the env file of the
+// environment, see Load), with the defaults of the values that are not set, and validated: a configuration with values
+// missing or invalid is reported at once, and the process does not start.
+//
+// The tracing is configured with the standard OpenTelemetry variables (OTEL_*), read by the tracing package.
+package config
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"orchdio/blueprint"
+	"orchdio/logger"
+	"orchdio/services/ratelimit"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/joho/godotenv"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// EnvProduction is the production environment. The env file is not loaded in production.
+	EnvProduction = "production"
+	// WebhookProviderSvix sends the webhooks with svix.
+	WebhookProviderSvix = "svix"
+	// WebhookProviderOrchdio sends the webhooks with the built-in webhook sender.
+	WebhookProviderOrchdio = "orchdio"
+)
+
+// Config is the configuration of Orchdio.
+type Config struct {
+	// Env is the environment (ORCHDIO_ENV), e.g. development or production.
+	Env string
+	// AppName is the name of the fiber app (APP_NAME).
+	AppName string
+	// AppURL is the public URL of the API (APP_URL), the OAuth redirects point to it.
+	AppURL string
+	// DashboardURL is the URL of the dashboard (ORCHDIO_DASHBOARD_URL), the links of the emails point to it.
+	DashboardURL string
+	// Port is the port the components listen on (PORT).
+	Port string
+	// DatabaseURL is the URL of the Postgres database (DATABASE_URL). The SSL mode is disabled outside production.
+	DatabaseURL string
+	// RedisURL is the URL of redis (REDISCLOUD_URL).
+	RedisURL string
+	// MetricsToken is the bearer token the scrapes of /metrics must send (METRICS_TOKEN). It is required in production,
+	// elsewhere /metrics is public without it.
+	MetricsToken string
+
+	Auth          Auth
+	Encryption    Encryption
+	Platforms     Platforms
+	Email         Email
+	Webhooks      Webhooks
+	Queue         Queue
+	HTTP          HTTP
+	RateLimit     RateLimit
+	Cache         Cache
+	Follow        Follow
+	Notifications Notifications
+	Log           logger.Config
+}
+
+// Auth is the configuration of the authentication.
+type Auth struct {
+	// JWTSecret signs the tokens of the users (JWT_SECRET).
+	JWTSecret string
+	// SecretKeyGracePeriod is how long a rotated secret key keeps working when no grace period is passed
+	// (SECRET_KEY_GRACE_PERIOD_SECONDS). A day by default.
+	SecretKeyGracePeriod time.Duration
+}
+
+// Encryption is the configuration of the encryption of the secrets stored, see internal/encryption.
+type Encryption struct {
+	// Secret is the master key when there is no key file, and decrypts the records encrypted before the envelope
+	// encryption (ENCRYPTION_SECRET).
+	Secret string
+	// KeysFile is the path of the key file of the versioned master keys (ENCRYPTION_KEYS_FILE).
+	KeysFile string
+}
+
+// Platforms is the configuration of the clients of the platforms.
+type Platforms struct {
+	DeezerAPIBase  string
+	SpotifyAPIBase string
+	TidalAPIBase   string
+	YTMusicAPIBase string
+	// AppleMusicAPIKey is the developer token of Apple Music (APPLE_MUSIC_API_KEY). Apple Music is not available without it.
+	AppleMusicAPIKey string
+}
+
+// Email is the configuration of the emails.
+type Email struct {
+	// SendinblueAPIKey is the API key of Sendinblue, the emails are sent with it (SENDINBLUE_API_KEY).
+	SendinblueAPIKey string
+	// AlertEmail is the sender of the emails (ALERT_EMAIL).
+	AlertEmail string
+}
+
+// Webhooks is the configuration of the webhooks.
+type Webhooks struct {
+	// Provider is the sender of the webhooks, svix or orchdio (WEBHOOK_PROVIDER). svix by default.
+	Provider string
+	// SvixAPIKey is the API key of svix (SVIX_API_KEY), required with the svix provider.
+	SvixAPIKey string
+	// MaxRetries is the max number of retries of a message of the built-in sender (WEBHOOK_MAX_RETRIES). The sender
+	// default if 0.
+	MaxRetries int
+	// TrackBatch is the batching of the playlist conversion track events (WEBHOOK_TRACK_BATCH_SIZE and
+	// WEBHOOK_TRACK_BATCH_INTERVAL_MS). The apps can override it.
+	TrackBatch blueprint.WebhookBatchSettings
+}
+
+// Queue is the configuration of the queue worker.
+type Queue struct {
+	// Concurrency is the max number of tasks processed at once by a worker (QUEUE_CONCURRENCY).
+	Concurrency int
+	// Weights are the priorities of the queues (QUEUE_WEIGHTS, a list of queue:weight separated by commas). The queues
+	// not listed keep their default weight.
+	Weights map[string]int
+	// DrainTimeout is how long the tasks in flight have to finish on shutdown (QUEUE_DRAIN_TIMEOUT_SECONDS).
+	DrainTimeout time.Duration
+	// HandlerGracePeriod is how long the handlers of the tasks not done after DrainTimeout have to stop once they are
+	// cancelled (QUEUE_HANDLER_GRACE_SECONDS).
+	HandlerGracePeriod time.Duration
+}
+
+// HTTP is the configuration of the HTTP servers.
+type HTTP struct {
+	ReadTimeout time.Duration
+	// WriteTimeout is how long the responses have to be written (HTTP_WRITE_TIMEOUT_SECONDS). The streams of task
+	// events are ended a few seconds before it, so it is at least 20 seconds.
+	WriteTimeout time.Duration
+	// ShutdownTimeout is how long the requests in flight have to finish on shutdown (SHUTDOWN_TIMEOUT_SECONDS).
+	ShutdownTimeout time.Duration
+	// HealthCheckTimeout is how long each check of the readiness has to answer (HEALTH_CHECK_TIMEOUT_SECONDS).
+	HealthCheckTimeout time.Duration
+}
+
+// RateLimit is the configuration of the rate limits.
+type RateLimit struct {
+	// IPMax is the max number of requests of an IP address per IPWindow (RATE_LIMIT_IP_MAX and
+	// RATE_LIMIT_IP_WINDOW_SECONDS).
+	IPMax    int
+	IPWindow time.Duration
+	// Plans are the limits of the apps, by plan (RATE_LIMIT_PLANS). ratelimit.DefaultPlans by default.
+	Plans map[string]ratelimit.Plan
+}
+
+// Cache is the configuration of the cache of the platforms.
+type Cache struct {
+	// TrackTTL is how long the tracks fetched from the platforms are cached (PLATFORM_CACHE_TTL_HOURS).
+	TrackTTL time.Duration
+}
+
+// Follow is the configuration of the follows of the playlists.
+type Follow struct {
+	// SubscriberLimit is the max number of subscribers of a follow (FOLLOW_SUBSCRIBER_LIMIT).
+	SubscriberLimit int
+}
+
+// Notifications is the configuration of the cleanup of the notifications.
+type Notifications struct {
+	// ReadRetention is how long the read notifications are kept (NOTIFICATION_READ_RETENTION_DAYS).
+	ReadRetention time.Duration
+	// UnreadRetention is how long the unread notifications are kept (NOTIFICATION_RETENTION_DAYS).
+	UnreadRetention time.Duration
+}
+
+// DefaultQueueWeights are the priorities of the queues, unless set by QUEUE_WEIGHTS.
+var DefaultQueueWeights = map[string]int{
+	blueprint.PlaylistConversionQueueName: 5,
+	blueprint.EmailQueueName:              2,
+	blueprint.DefaultQueueName:            1,
+	blueprint.WebhookDeliveryQueueName:    3,
+}
+
+// Error is the report of the values of the configuration missing or invalid.
+type Error struct {
+	Problems []string
+}
+
+func (e *Error) Error() string {
+	return "invalid configuration:\n - " + strings.Join(e.Problems, "\n - ")
+}
+
+// Load loads the env file of the environment (.env.<ORCHDIO_ENV>), except in production where the env is set by the
+// deployment, and returns the configuration read from the env. The variables set in the env take precedence over the
+// env file. The error is an *Error if values are missing or invalid.
+func Load() (*Config, error) {
+	env := os.Getenv("ORCHDIO_ENV")
+	log.Printf("Environment: %s", env)
+	if env != EnvProduction {
+		if err := godotenv.Load(".env." + env); err != nil {
+			return nil, fmt.Errorf("failed to load .env file for environment: %s, error: %v", env, err)
+		}
+		log.Printf("Loaded .env file for environment: %s", env)
+	}
+	return FromEnv()
+}
+
+// FromEnv returns the configuration read from the env, with the defaults of the values not set. The configuration is
+// returned with an *Error if values are missing or invalid, with the defaults in place of the invalid values.
+func FromEnv() (*Config, error) {
+	r := &reader{}
+	cfg := &Config{
+		Env:          r.string("ORCHDIO_ENV", ""),
+		AppName:      r.string("APP_NAME", ""),
+		AppURL:       r.string("APP_URL", ""),
+		DashboardURL: r.string("ORCHDIO_DASHBOARD_URL", ""),
+		Port:         r.string("PORT", "52800"),
+		DatabaseURL:  r.required("DATABASE_URL"),
+		RedisURL:     r.required("REDISCLOUD_URL"),
+		MetricsToken: r.string("METRICS_TOKEN", ""),
+		Auth: Auth{
+			JWTSecret:            r.required("JWT_SECRET"),
+			SecretKeyGracePeriod: r.seconds("SECRET_KEY_GRACE_PERIOD_SECONDS", 24*time.Hour, 0),
+		},
+		Encryption: Encryption{
+			Secret:   r.string("ENCRYPTION_SECRET", ""),
+			KeysFile: r.string("ENCRYPTION_KEYS_FILE", ""),
+		},
+		Platforms: Platforms{
+			DeezerAPIBase:    r.url("DEEZER_API_BASE"),
+			SpotifyAPIBase:   r.url("SPOTIFY_API_BASE"),
+			TidalAPIBase:     r.url("TIDAL_API_BASE"),
+			YTMusicAPIBase:   r.url("YTMUSIC_API_BASE"),
+			AppleMusicAPIKey: r.string("APPLE_MUSIC_API_KEY", ""),
+		},
+		Email: Email{
+			SendinblueAPIKey: r.string("SENDINBLUE_API_KEY", ""),
+			AlertEmail:       r.string("ALERT_EMAIL", ""),
+		},
+		Webhooks: Webhooks{
+			Provider:   strings.ToLower(r.string("WEBHOOK_PROVIDER", WebhookProviderSvix)),
+			SvixAPIKey: r.string("SVIX_API_KEY", ""),
+			MaxRetries: r.int("WEBHOOK_MAX_RETRIES", 0, 0),
+			TrackBatch: blueprint.WebhookBatchSettings{
+				Size:       r.int("WEBHOOK_TRACK_BATCH_SIZE", 1, 1),
+				IntervalMs: r.int("WEBHOOK_TRACK_BATCH_INTERVAL_MS", 0, 0),
+			},
+		},
+		Queue: Queue{
+			Concurrency:        r.int("QUEUE_CONCURRENCY", 10, 1),
+			Weights:            r.queueWeights("QUEUE_WEIGHTS"),
+			DrainTimeout:       r.seconds("QUEUE_DRAIN_TIMEOUT_SECONDS", 30*time.Second, 1),
+			HandlerGracePeriod: r.seconds("QUEUE_HANDLER_GRACE_SECONDS", 5*time.Second, 1),
+		},
+		HTTP: HTTP{
+			ReadTimeout:        r.seconds("HTTP_READ_TIMEOUT_SECONDS", 45*time.Second, 1),
+			WriteTimeout:       r.seconds("HTTP_WRITE_TIMEOUT_SECONDS", 45*time.Second, 20),
+			ShutdownTimeout:    r.seconds("SHUTDOWN_TIMEOUT_SECONDS", 30*time.Second, 1),
+			HealthCheckTimeout: r.seconds("HEALTH_CHECK_TIMEOUT_SECONDS", 5*time.Second, 1),
+		},
+		RateLimit: RateLimit{
+			IPMax:    r.int("RATE_LIMIT_IP_MAX", 100, 1),
+			IPWindow: r.seconds("RATE_LIMIT_IP_WINDOW_SECONDS", 30*time.Second, 1),
+			Plans:    r.plans("RATE_LIMIT_PLANS"),
+		},
+		Cache: Cache{
+			TrackTTL: time.Duration(r.int("PLATFORM_CACHE_TTL_HOURS", 24, 1)) * time.Hour,
+		},
+		Follow: Follow{
+			SubscriberLimit: r.int("FOLLOW_SUBSCRIBER_LIMIT", 20, 1),
+		},
+		Notifications: Notifications{
+			ReadRetention:   time.Duration(r.int("NOTIFICATION_READ_RETENTION_DAYS", 30, 1)) * 24 * time.Hour,
+			UnreadRetention: time.Duration(r.int("NOTIFICATION_RETENTION_DAYS", 90, 1)) * 24 * time.Hour,
+		},
+		Log: logger.Config{
+			Level:     strings.ToLower(r.string("LOG_LEVEL", "info")),
+			Format:    strings.ToLower(r.string("LOG_FORMAT", "")),
+			SentryDSN: r.string("SENTRY_DSN", ""),
+		},
+	}
+
+	if cfg.Env != EnvProduction && cfg.DatabaseURL != "" {
+		cfg.DatabaseURL += "?sslmode=disable"
+	}
+	if cfg.RedisURL != "" {
+		if _, err := redis.ParseURL(cfg.RedisURL); err != nil {
+			r.invalid("REDISCLOUD_URL is not a valid redis URL: %v", err)
+		}
+	}
+	if cfg.Env == EnvProduction && cfg.MetricsToken == "" {
+		r.invalid("METRICS_TOKEN is required in production")
+	}
+	if cfg.Encryption.Secret == "" && cfg.Encryption.KeysFile == "" {
+		r.invalid("ENCRYPTION_SECRET or ENCRYPTION_KEYS_FILE is required")
+	}
+	switch cfg.Webhooks.Provider {
+	case WebhookProviderSvix:
+		if cfg.Webhooks.SvixAPIKey == "" {
+			r.invalid("SVIX_API_KEY is required with the svix webhook provider")
+		}
+	case WebhookProviderOrchdio:
+	default:
+		r.invalid("WEBHOOK_PROVIDER must be svix or orchdio, got %q", cfg.Webhooks.Provider)
+	}
+	if _, err := zapcore.ParseLevel(cfg.Log.Level); err != nil {
+		r.invalid("LOG_LEVEL must be debug, info, warn or error, got %q", cfg.Log.Level)
+	}
+	switch cfg.Log.Format {
+	case "":
+		cfg.Log.Format = "console"
+		if cfg.Env == EnvProduction {
+			cfg.Log.Format = "json"
+		}
+	case "json", "console":
+	default:
+		r.invalid("LOG_FORMAT must be json or console, got %q", cfg.Log.Format)
+	}
+
+	if len(r.problems) > 0 {
+		return cfg, &Error{Problems: r.problems}
+	}
+	return cfg, nil
+}
+
+var current atomic.Pointer[Config]
+
+// Set makes the configuration the one returned by Get, for the tests.
+func Set(cfg *Config) {
+	current.Store(cfg)
+}
+
+// Get returns the configuration of the tests, set with Set. Without one, it is read from the env once, and the values
+// missing or invalid are not an error. It is for the tests only: the components are passed the configuration loaded at
+// startup (see wiring.Deps), so that a configuration with values missing or invalid fails the startup.
+func Get() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
+	}
+	cfg, _ := FromEnv()
+	current.CompareAndSwap(nil, cfg)
+	return current.Load()
+}
+
+// reader reads the values of the env, and records the values missing or invalid.
+type reader struct {
+	problems []string
+}
+
+func (r *reader) invalid(format string, args ...interface{}) {
+	r.problems = append(r.problems, fmt.Sprintf(format, args...))
+}
+
+func (r *reader) string(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+func (r *reader) required(key string) string {
+	v := r.string(key, "")
+	if v == "" {
+		r.invalid("%s is required", key)
+	}
+	return v
+}
+
+func (r *reader) url(key string) string {
+	v := r.required(key)
+	if v == "" {
+		return v
+	}
+	if u, err := url.Parse(v); err != nil || u.Scheme == "" || u.Host == "" {
+		r.invalid("%s must be a URL, got %q", key, v)
+	}
+	return strings.TrimSuffix(v, "/")
+}
+
+// int returns the value of key, an integer of at least minimum, or def if it is not set.
+func (r *reader) int(key string, def, minimum int) int {
+	v := r.string(key, "")
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < minimum {
+		r.invalid("%s must be an integer of at least %d, got %q", key, minimum, v)
+		return def
+	}
+	return n
+}
+
+// seconds returns the value of key, a number of seconds of at least minimum, or def if it is not set.
+func (r *reader) seconds(key string, def time.Duration, minimum int) time.Duration {
+	return time.Duration(r.int(key, int(def/time.Second), minimum)) * time.Second
+}
+
+func (r *reader) queueWeights(key string) map[string]int {
+	weights := make(map[string]int, len(DefaultQueueWeights))
+	for name, weight := range DefaultQueueWeights {
+		weights[name] = weight
+	}
+	v := r.string(key, "")
+	if v == "" {
+		return weights
+	}
+	for _, pair := range strings.Split(v, ",") {
+		name, w, found := strings.Cut(strings.TrimSpace(pair), ":")
+		weight, err := strconv.Atoi(strings.TrimSpace(w))
+		name = strings.TrimSpace(name)
+		if _, known := DefaultQueueWeights[name]; !found || !known || err != nil || weight < 1 {
+			r.invalid("%s must be a list of queue:weight with a weight of at least 1 and a queue among %s, got %q", key, queueNames(), pair)
+			continue
+		}
+		weights[name] = weight
+	}
+	return weights
+}
+
+func queueNames() string {
+	names := make([]string, 0, len(DefaultQueueWeights))
+	for name := range DefaultQueueWeights {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (r *reader) plans(key string) map[string]ratelimit.Plan {
+	plans, err := ratelimit.LoadPlans(r.string(key, ""))
+	if err != nil {
+		r.invalid("%s is invalid: %v", key, err)
+		return ratelimit.DefaultPlans
+	}
+	return plans
+}
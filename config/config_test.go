@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"orchdio/blueprint"
+	"orchdio/services/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setRequired(t *testing.T) {
+	t.Setenv("ORCHDIO_ENV", "test")
+	t.Setenv("DATABASE_URL", "postgres://localhost:5432/orchdio")
+	t.Setenv("REDISCLOUD_URL", "redis://localhost:6379")
+	t.Setenv("JWT_SECRET", "secret")
+	t.Setenv("ENCRYPTION_SECRET", "super-secure-secret-something-ff")
+	t.Setenv("DEEZER_API_BASE", "https://api.deezer.com")
+	t.Setenv("SPOTIFY_API_BASE", "https://api.spotify.com/v1")
+	t.Setenv("TIDAL_API_BASE", "https://listen.tidal.com/v1")
+	t.Setenv("YTMUSIC_API_BASE", "https://music.youtube.com")
+	t.Setenv("SVIX_API_KEY", "svix-key")
+}
+
+func TestFromEnvDefaults(t *testing.T) {
+	setRequired(t)
+
+	cfg, err := FromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, "postgres://localhost:5432/orchdio?sslmode=disable", cfg.DatabaseURL)
+	assert.Equal(t, "52800", cfg.Port)
+	assert.Equal(t, 10, cfg.Queue.Concurrency)
+	assert.Equal(t, DefaultQueueWeights, cfg.Queue.Weights)
+	assert.Equal(t, 30*time.Second, cfg.Queue.DrainTimeout)
+	assert.Equal(t, 5*time.Second, cfg.Queue.HandlerGracePeriod)
+	assert.Equal(t, 45*time.Second, cfg.HTTP.ReadTimeout)
+	assert.Equal(t, 100, cfg.RateLimit.IPMax)
+	assert.Equal(t, ratelimit.DefaultPlans, cfg.RateLimit.Plans)
+	assert.Equal(t, 24*time.Hour, cfg.Cache.TrackTTL)
+	assert.Equal(t, 20, cfg.Follow.SubscriberLimit)
+	assert.Equal(t, 90*24*time.Hour, cfg.Notifications.UnreadRetention)
+	assert.Equal(t, WebhookProviderSvix, cfg.Webhooks.Provider)
+	assert.Equal(t, blueprint.WebhookBatchSettings{Size: 1, IntervalMs: 0}, cfg.Webhooks.TrackBatch)
+	assert.Equal(t, "info", cfg.Log.Level)
+	assert.Equal(t, "console", cfg.Log.Format)
+}
+
+func TestFromEnvOverrides(t *testing.T) {
+	setRequired(t)
+	t.Setenv("QUEUE_CONCURRENCY", "25")
+	t.Setenv("QUEUE_WEIGHTS", "playlist_conversion:8, webhooks:4")
+	t.Setenv("FOLLOW_SUBSCRIBER_LIMIT", "50")
+	t.Setenv("WEBHOOK_PROVIDER", "orchdio")
+	t.Setenv("SVIX_API_KEY", "")
+
+	cfg, err := FromEnv()
+	require.NoError(t, err)
+	assert.Equal(t, 25, cfg.Queue.Concurrency)
+	assert.Equal(t, map[string]int{
+		blueprint.PlaylistConversionQueueName: 8,
+		blueprint.EmailQueueName:              2,
+		blueprint.DefaultQueueName:            1,
+		blueprint.WebhookDeliveryQueueName:    4,
+	}, cfg.Queue.Weights)
+	assert.Equal(t, 50, cfg.Follow.SubscriberLimit)
+	assert.Equal(t, WebhookProviderOrchdio, cfg.Webhooks.Provider)
+}
+
+func TestFromEnvReportsEveryProblem(t *testing.T) {
+	setRequired(t)
+	t.Setenv("DATABASE_URL", "")
+	t.Setenv("JWT_SECRET", "")
+	t.Setenv("ENCRYPTION_SECRET", "")
+	t.Setenv("TIDAL_API_BASE", "listen.tidal.com")
+	t.Setenv("QUEUE_CONCURRENCY", "ten")
+	t.Setenv("QUEUE_WEIGHTS", "conversions:5")
+	t.Setenv("RATE_LIMIT_PLANS", "{")
+	t.Setenv("LOG_FORMAT", "text")
+	t.Setenv("HTTP_WRITE_TIMEOUT_SECONDS", "10")
+
+	cfg, err := FromEnv()
+	var cfgErr *Error
+	require.True(t, errors.As(err, &cfgErr))
+	assert.ElementsMatch(t, []string{
+		"DATABASE_URL is required",
+		"JWT_SECRET is required",
+		`TIDAL_API_BASE must be a URL, got "listen.tidal.com"`,
+		`QUEUE_CONCURRENCY must be an integer of at least 1, got "ten"`,
+		`QUEUE_WEIGHTS must be a list of queue:weight with a weight of at least 1 and a queue among default, email, playlist_conversion, webhooks, got "conversions:5"`,
+		"RATE_LIMIT_PLANS is invalid: unexpected end of JSON input",
+		"ENCRYPTION_SECRET or ENCRYPTION_KEYS_FILE is required",
+		`LOG_FORMAT must be json or console, got "text"`,
+		`HTTP_WRITE_TIMEOUT_SECONDS must be an integer of at least 20, got "10"`,
+	}, cfgErr.Problems)
+	// the invalid values are replaced by their default.
+	assert.Equal(t, 10, cfg.Queue.Concurrency)
+}
+
+func TestFromEnvRequiresMetricsTokenInProduction(t *testing.T) {
+	setRequired(t)
+	t.Setenv("ORCHDIO_ENV", EnvProduction)
+
+	_, err := FromEnv()
+	var cfgErr *Error
+	require.True(t, errors.As(err, &cfgErr))
+	assert.Equal(t, []string{"METRICS_TOKEN is required in production"}, cfgErr.Problems)
+
+	t.Setenv("METRICS_TOKEN", "token")
+	_, err = FromEnv()
+	assert.NoError(t, err)
+}
@@ -0,0 +1,79 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/services/tokens"
+	"orchdio/util"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// DisconnectUserPlatform disconnects a platform of a user from the app making the request. The token is revoked on the
+// platform where it is supported and the tokens and scopes of the user are wiped. The app is then sent a
+// user_platform_disconnected webhook event.
+func (u *UserController) DisconnectUserPlatform(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	userId := ctx.Params("userId")
+	platform := ctx.Params("platform")
+	log.Printf("[controller][user][DisconnectUserPlatform] - disconnecting %s of user %s\n", platform, userId)
+
+	if !util.IsValidUUID(userId) {
+		log.Printf("[controller][user][DisconnectUserPlatform] - invalid user id %s\n", userId)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user id. Please pass a valid Orchdio user id")
+	}
+
+	revoked, err := u.Tokens.Disconnect(ctx.Context(), userId, app, platform)
+	if err != nil {
+		if errors.Is(err, tokens.ErrNotConnected) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "User has not connected this platform to the app")
+		}
+		log.Printf("[controller][user][DisconnectUserPlatform] - error disconnecting %s of user %s: %v\n", platform, userId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	payload := &blueprint.UserPlatformDisconnectedEventPayload{
+		UserID:         userId,
+		Platform:       platform,
+		Revoked:        revoked,
+		DisconnectedAt: time.Now().UTC(),
+	}
+	event := blueprint.NewWebhookEvent(blueprint.UserPlatformDisconnectedEvent, app.UID.String(), "", payload)
+	if _, whErr := u.WebhookSender.SendEvent(app.WebhookAppID, blueprint.UserPlatformDisconnectedEvent, event); whErr != nil {
+		// the platform is disconnected already, the request does not fail because the app could not be notified.
+		log.Printf("[controller][user][DisconnectUserPlatform] - error sending disconnect event of user %s: %v\n", userId, whErr)
+	}
+
+	log.Printf("[controller][user][DisconnectUserPlatform] - disconnected %s of user %s. Revoked on platform: %v\n", platform, userId, revoked)
+	return util.SuccessResponse(ctx, http.StatusOK, payload)
+}
+
+// DeleteUserData erases all the data of a user for the app making the request: their platform connections, tasks, follows
+// and notifications. The user is deleted too if they have not connected any other app.
+func (u *UserController) DeleteUserData(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	userId := ctx.Params("userId")
+	log.Printf("[controller][user][DeleteUserData] - erasing data of user %s for app %s\n", userId, app.UID.String())
+
+	if !util.IsValidUUID(userId) {
+		log.Printf("[controller][user][DeleteUserData] - invalid user id %s\n", userId)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user id. Please pass a valid Orchdio user id")
+	}
+
+	database := db.NewDB{DB: u.DB}
+	err := database.DeleteUserAppData(userId, app.UID.String())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "User not found")
+		}
+		log.Printf("[controller][user][DeleteUserData] - error erasing data of user %s: %v\n", userId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	log.Printf("[controller][user][DeleteUserData] - erased data of user %s\n", userId)
+	return util.SuccessResponse(ctx, http.StatusOK, "success")
+}
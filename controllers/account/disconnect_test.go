@@ -0,0 +1,151 @@
+package account
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/db/queries"
+	"orchdio/internal/encryption"
+	"orchdio/services/tokens"
+	"orchdio/services/ytmusic"
+	svixwebhook "orchdio/webhooks/svix"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	svix "github.com/svix/svix-webhooks/go"
+)
+
+var testEnvelope *encryption.Envelope
+
+func TestMain(m *testing.M) {
+	config.Set(&config.Config{Encryption: config.Encryption{Secret: "super-secure-secret-something-ff"}})
+	envelope, err := encryption.FromConfig(config.Get().Encryption)
+	if err != nil {
+		panic(err)
+	}
+	testEnvelope = envelope
+	os.Exit(m.Run())
+}
+
+// recordingSender records the events sent through it.
+type recordingSender struct {
+	svixwebhook.SvixInterface
+	events []*blueprint.WebhookEvent
+}
+
+func (r *recordingSender) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
+	r.events = append(r.events, blueprint.ToWebhookEvent(eventType, payload))
+	return &svix.MessageOut{}, nil
+}
+
+// newTestUserController returns the account routes of the app passed, on a mock database.
+func newTestUserController(t *testing.T, app *blueprint.DeveloperApp) (*fiber.App, sqlmock.Sqlmock, *recordingSender) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	sender := &recordingSender{}
+	controller := NewUserController(sqlx.NewDb(conn, "postgres"), nil, nil, sender, testEnvelope, config.Get())
+
+	server := fiber.New()
+	server.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("app", app)
+		return ctx.Next()
+	})
+	server.Delete("/v1/account/:userId/:platform", controller.DisconnectUserPlatform)
+	server.Delete("/v1/account/:userId", controller.DeleteUserData)
+	return server, mock, sender
+}
+
+func TestDisconnectUserPlatformRevokeFailure(t *testing.T) {
+	// google is down: the token cannot be revoked.
+	google := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer google.Close()
+	defer func(revokeURL string) { tokens.GoogleRevokeURL = revokeURL }(tokens.GoogleRevokeURL)
+	tokens.GoogleRevokeURL = google.URL
+
+	app := &blueprint.DeveloperApp{UID: uuid.New(), WebhookAppID: "wh_app"}
+	userId := uuid.NewString()
+	server, mock, sender := newTestUserController(t, app)
+
+	refreshToken, err := testEnvelope.Encrypt([]byte("refresh-token"))
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchUserAppTokens)).WithArgs(userId, app.UID.String(), ytmusic.IDENTIFIER).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "refresh_token", "access_token", "expires_in", "reauth_required_at"}).
+			AddRow(uuid.NewString(), refreshToken, "", nil, nil))
+	// the tokens are wiped anyway.
+	mock.ExpectQuery(regexp.QuoteMeta(queries.DisconnectUserApp)).WithArgs(userId, app.UID.String(), ytmusic.IDENTIFIER).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}).AddRow(uuid.NewString()))
+
+	res, err := server.Test(httptest.NewRequest(http.MethodDelete, "/v1/account/"+userId+"/"+ytmusic.IDENTIFIER, nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	var body struct {
+		Data blueprint.UserPlatformDisconnectedEventPayload `json:"data"`
+	}
+	raw, _ := io.ReadAll(res.Body)
+	require.NoError(t, json.Unmarshal(raw, &body))
+	assert.False(t, body.Data.Revoked)
+	require.Len(t, sender.events, 1)
+	assert.Equal(t, blueprint.UserPlatformDisconnectedEvent, sender.events[0].Type)
+	assert.False(t, sender.events[0].Data.(*blueprint.UserPlatformDisconnectedEventPayload).Revoked)
+}
+
+func TestDisconnectUserPlatformNotConnected(t *testing.T) {
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+	userId := uuid.NewString()
+	server, mock, sender := newTestUserController(t, app)
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchUserAppTokens)).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "refresh_token", "access_token", "expires_in", "reauth_required_at"}).
+			AddRow(uuid.NewString(), nil, "", time.Time{}, nil))
+
+	res, err := server.Test(httptest.NewRequest(http.MethodDelete, "/v1/account/"+userId+"/"+ytmusic.IDENTIFIER, nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.Empty(t, sender.events)
+}
+
+func TestDeleteUserData(t *testing.T) {
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+	userId := uuid.NewString()
+	server, mock, _ := newTestUserController(t, app)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteUserApps)).WithArgs(userId, app.UID.String()).WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteUserAppTasks)).WithArgs(userId, app.UID.String()).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta(queries.RemoveUserFromAppFollows)).WithArgs(userId, app.UID.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteUnsubscribedAppFollows)).WithArgs(app.UID.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteUserAppNotifications)).WithArgs(userId, app.UID.String()).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteOrphanUser)).WithArgs(userId).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	res, err := server.Test(httptest.NewRequest(http.MethodDelete, "/v1/account/"+userId, nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// nothing is erased for a user without a user app for the app.
+	server, mock, _ = newTestUserController(t, app)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteUserApps)).WithArgs(userId, app.UID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	res, err = server.Test(httptest.NewRequest(http.MethodDelete, "/v1/account/"+userId, nil))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,447 @@
+package account
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/db/queries"
+	"orchdio/logger"
+	"orchdio/services/audit"
+	"orchdio/util"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// orgInviteTTL is how long an invitation to an org can be accepted.
+const orgInviteTTL = 7 * 24 * time.Hour
+
+// FetchOrgMembers returns the members of the org and their role.
+func (u *UserController) FetchOrgMembers(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	database := db.NewDB{DB: u.DB}
+	members, err := database.FetchOrgMembers(orgId)
+	if err != nil {
+		log.Printf("[controller][account][FetchOrgMembers] - error fetching members of org %s: %v", orgId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not fetch the members of the organization")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, members)
+}
+
+// UpdateOrgMember changes the role of a member of the org. Members can only manage the members with a lower role than
+// theirs and give roles lower than theirs, except for the owner who manages everybody. The owner role is given by
+// transferring the org.
+func (u *UserController) UpdateOrgMember(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	actor := ctx.Locals("org_member").(*blueprint.OrgMember)
+
+	var body blueprint.UpdateOrgMemberData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controller][account][UpdateOrgMember] - error parsing body: %v", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not update member. Invalid body passed")
+	}
+	if !blueprint.IsValidOrgRole(body.Role) || body.Role == blueprint.OrgRoleOwner {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid role. Please pass admin, developer or viewer. To make a member the owner, transfer the organization")
+	}
+
+	member, rErr := u.fetchManagedMember(ctx, actor)
+	if member == nil {
+		return rErr
+	}
+	if !canManageRole(actor, body.Role) {
+		return util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", fmt.Sprintf("You can not give the %s role", body.Role))
+	}
+
+	database := db.NewDB{DB: u.DB}
+	if err := database.UpdateOrgMemberRole(orgId, member.User.String(), body.Role); err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not update member")
+	}
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        orgId,
+		Action:     blueprint.AuditOrgMemberUpdate,
+		TargetType: blueprint.AuditTargetMember,
+		TargetID:   member.User.String(),
+		Before:     map[string]string{"role": member.Role},
+		After:      map[string]string{"role": body.Role},
+	})
+	member.Role = body.Role
+	log.Printf("[controller][account][UpdateOrgMember] - user %s is now a %s of org %s", member.User, body.Role, orgId)
+	return util.SuccessResponse(ctx, http.StatusOK, member)
+}
+
+// RemoveOrgMember removes a member from the org. Members can leave the org by removing themselves, except for the
+// owner who has to transfer it first.
+func (u *UserController) RemoveOrgMember(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	actor := ctx.Locals("org_member").(*blueprint.OrgMember)
+
+	var member *blueprint.OrgMember
+	if ctx.Params("userId") == actor.User.String() {
+		if actor.Role == blueprint.OrgRoleOwner {
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "The owner can not leave the organization. Please transfer it first")
+		}
+		member = actor
+	} else {
+		if !blueprint.OrgRoleAtLeast(actor.Role, blueprint.OrgRoleAdmin) {
+			return util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", fmt.Sprintf("This action needs the %s role in the organization", blueprint.OrgRoleAdmin))
+		}
+		var rErr error
+		member, rErr = u.fetchManagedMember(ctx, actor)
+		if member == nil {
+			return rErr
+		}
+	}
+
+	database := db.NewDB{DB: u.DB}
+	if err := database.RemoveOrgMember(orgId, member.User.String()); err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not remove member")
+	}
+	log.Printf("[controller][account][RemoveOrgMember] - user %s removed from org %s by %s", member.User, orgId, actor.User)
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        orgId,
+		Action:     blueprint.AuditOrgMemberRemove,
+		TargetType: blueprint.AuditTargetMember,
+		TargetID:   member.User.String(),
+		Before:     member,
+	})
+	return util.SuccessResponse(ctx, http.StatusOK, "success")
+}
+
+// TransferOrg makes another member the owner of the org. The current owner becomes an admin.
+func (u *UserController) TransferOrg(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	actor := ctx.Locals("org_member").(*blueprint.OrgMember)
+
+	var body blueprint.TransferOrgData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controller][account][TransferOrg] - error parsing body: %v", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not transfer organization. Invalid body passed")
+	}
+	if !util.IsValidUUID(body.UserID) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user_id. Please pass the id of a member of the organization")
+	}
+	if body.UserID == actor.User.String() {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "You already own this organization")
+	}
+
+	database := db.NewDB{DB: u.DB}
+	if _, err := database.FetchOrgMember(orgId, body.UserID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Member not found. The organization can only be transferred to one of its members")
+		}
+		log.Printf("[controller][account][TransferOrg] - error fetching member %s of org %s: %v", body.UserID, orgId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not transfer organization")
+	}
+	if err := database.TransferOrg(orgId, actor.User.String(), body.UserID); err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not transfer organization")
+	}
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        orgId,
+		Action:     blueprint.AuditOrgTransfer,
+		TargetType: blueprint.AuditTargetOrg,
+		TargetID:   orgId,
+		Before:     map[string]string{"owner": actor.User.String()},
+		After:      map[string]string{"owner": body.UserID},
+	})
+	return util.SuccessResponse(ctx, http.StatusOK, "success")
+}
+
+// InviteOrgMember invites someone to join the org with a role, by email. The invitation can be accepted for 7 days.
+func (u *UserController) InviteOrgMember(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	actor := ctx.Locals("org_member").(*blueprint.OrgMember)
+
+	var body blueprint.InviteOrgMemberData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controller][account][InviteOrgMember] - error parsing body: %v", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not invite member. Invalid body passed")
+	}
+	email, err := mail.ParseAddress(body.Email)
+	if err != nil || email.Address != body.Email {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Email is invalid. Please pass a valid email")
+	}
+	if body.Role == "" {
+		body.Role = blueprint.OrgRoleDeveloper
+	}
+	if !blueprint.IsValidOrgRole(body.Role) || body.Role == blueprint.OrgRoleOwner {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid role. Please pass admin, developer or viewer")
+	}
+	if !canManageRole(actor, body.Role) {
+		return util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", fmt.Sprintf("You can not invite members with the %s role", body.Role))
+	}
+
+	database := db.NewDB{DB: u.DB}
+	org, err := database.FetchUserOrgByID(actor.User.String(), orgId)
+	if err != nil {
+		log.Printf("[controller][account][InviteOrgMember] - error fetching org %s: %v", orgId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not invite member")
+	}
+
+	user, err := database.FindUserByEmail(body.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not invite member")
+	}
+	if user != nil {
+		if _, mErr := database.FetchOrgMember(orgId, user.UUID.String()); mErr == nil {
+			return util.ErrorResponse(ctx, http.StatusConflict, "conflict", "This user is already a member of the organization")
+		}
+	}
+
+	token, err := generateInviteToken()
+	if err != nil {
+		log.Printf("[controller][account][InviteOrgMember] - error generating invite token: %v", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not invite member")
+	}
+	invite, err := database.CreateOrgInvite(orgId, body.Email, body.Role, hashInviteToken(token), actor.User.String(), time.Now().Add(orgInviteTTL))
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not invite member")
+	}
+
+	if mailErr := u.SendOrgInviteEmail(logger.Context(ctx), body.Email, org.Name, body.Role, token); mailErr != nil {
+		log.Printf("[controller][account][InviteOrgMember] - error sending invite email: %v", mailErr)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not send the invitation email")
+	}
+	log.Printf("[controller][account][InviteOrgMember] - %s invited to org %s as %s", body.Email, orgId, body.Role)
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        orgId,
+		Action:     blueprint.AuditOrgInviteCreate,
+		TargetType: blueprint.AuditTargetInvite,
+		TargetID:   invite.UID.String(),
+		After:      invite,
+	})
+	return util.SuccessResponse(ctx, http.StatusCreated, invite)
+}
+
+// FetchOrgInvites returns the invitations to the org that were neither accepted nor expired.
+func (u *UserController) FetchOrgInvites(ctx *fiber.Ctx) error {
+	database := db.NewDB{DB: u.DB}
+	invites, err := database.FetchPendingOrgInvites(ctx.Params("orgId"))
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not fetch the invitations of the organization")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, invites)
+}
+
+// DeleteOrgInvite cancels an invitation to the org.
+func (u *UserController) DeleteOrgInvite(ctx *fiber.Ctx) error {
+	inviteId := ctx.Params("inviteId")
+	if !util.IsValidUUID(inviteId) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid invite id")
+	}
+	database := db.NewDB{DB: u.DB}
+	deleted, err := database.DeleteOrgInvite(inviteId, ctx.Params("orgId"))
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not delete invitation")
+	}
+	if !deleted {
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Invitation not found")
+	}
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        ctx.Params("orgId"),
+		Action:     blueprint.AuditOrgInviteDelete,
+		TargetType: blueprint.AuditTargetInvite,
+		TargetID:   inviteId,
+	})
+	return util.SuccessResponse(ctx, http.StatusOK, "success")
+}
+
+// AcceptOrgInvite adds the invited user to the org and logs them into it. Users that don't have an account yet, or
+// have one without a password, set their password; the others confirm theirs.
+func (u *UserController) AcceptOrgInvite(ctx *fiber.Ctx) error {
+	var body blueprint.AcceptOrgInviteData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controller][account][AcceptOrgInvite] - error parsing body: %v", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not accept invitation. Invalid body passed")
+	}
+	if body.Token == "" || body.Password == "" {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Please pass the invitation token and a password")
+	}
+
+	database := db.NewDB{DB: u.DB}
+	invite, err := database.FetchPendingOrgInviteByToken(hashInviteToken(strings.TrimSpace(body.Token)))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Invitation not found. It may have expired or already been accepted")
+		}
+		log.Printf("[controller][account][AcceptOrgInvite] - error fetching invite: %v", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not accept invitation")
+	}
+
+	var user blueprint.User
+	err = u.DB.QueryRowx(queries.FetchOrgUserByEmail, invite.Email).StructScan(&user)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[controller][account][AcceptOrgInvite] - error fetching user %s: %v", invite.Email, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not accept invitation")
+	}
+
+	// the user is created, or their password set, alongside the acceptance of the invitation.
+	newUser := errors.Is(err, sql.ErrNoRows)
+	var passwordHash string
+	if user.Password != "" {
+		if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password)) != nil {
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "Invalid login", "Could not accept invitation. Password is incorrect")
+		}
+	} else {
+		hashedPass, bErr := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if bErr != nil {
+			log.Printf("[controller][account][AcceptOrgInvite] - error hashing password: %v", bErr)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not accept invitation")
+		}
+		passwordHash = string(hashedPass)
+		if newUser {
+			user.UUID = uuid.New()
+		}
+	}
+
+	if err = database.AcceptOrgInvite(invite, user.UUID.String(), invite.Email, passwordHash, newUser); err != nil {
+		if errors.Is(err, blueprint.ErrInviteNotPending) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Invitation not found. It may have expired or already been accepted")
+		}
+		if errors.Is(err, blueprint.ErrUserExists) {
+			return util.ErrorResponse(ctx, http.StatusConflict, "conflict", "Could not accept invitation. The account was created in the meantime, please try again")
+		}
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not accept invitation")
+	}
+	// the request has no JWT, the actor is the user that accepted the invitation.
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        invite.Org.String(),
+		Actor:      user.UUID.String(),
+		Action:     blueprint.AuditOrgInviteAccept,
+		TargetType: blueprint.AuditTargetInvite,
+		TargetID:   invite.UID.String(),
+		After:      map[string]string{"user": user.UUID.String(), "email": invite.Email, "role": invite.Role},
+	})
+
+	org, err := database.FetchUserOrgByID(user.UUID.String(), invite.Org.String())
+	if err != nil {
+		log.Printf("[controller][account][AcceptOrgInvite] - error fetching org %s: %v", invite.Org, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not accept invitation")
+	}
+	token, err := util.SignOrgLoginJWT(&blueprint.AppJWT{
+		OrgID:       org.UID.String(),
+		DeveloperID: user.UUID.String(),
+	}, u.Config.Auth.JWTSecret)
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not accept invitation")
+	}
+	apps, err := database.FetchApps(org.UID.String())
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[controller][account][AcceptOrgInvite] - error getting apps: %v", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not get apps")
+	}
+
+	log.Printf("[controller][account][AcceptOrgInvite] - %s joined org %s as %s", invite.Email, org.UID, org.Role)
+	return util.SuccessResponse(ctx, http.StatusOK, &blueprint.OrchdioLoginUserResponse{
+		OrgID:       org.UID.String(),
+		Name:        org.Name,
+		Description: org.Description,
+		Token:       string(token),
+		Apps:        apps,
+		Role:        org.Role,
+	})
+}
+
+// SendOrgInviteEmail sends the invitation to join the org, with the link to accept it on the dashboard. ctx is the
+// context of the request that sent the invitation, whose id and trace are carried by the task.
+func (u *UserController) SendOrgInviteEmail(ctx context.Context, email, orgName, role, token string) error {
+	taskID := uuid.NewString()
+	taskData := &blueprint.EmailTaskData{
+		From: u.Config.Email.AlertEmail,
+		To:   email,
+		Payload: map[string]interface{}{
+			"ORGNAME":    orgName,
+			"ROLE":       role,
+			"INVITELINK": fmt.Sprintf("%s/accept-invite?token=%s", u.Config.DashboardURL, token),
+		},
+		// todo: move this to a configuration, to make it easier to override
+		Subject:    fmt.Sprintf("You have been invited to join %s on Orchdio", orgName),
+		TaskID:     taskID,
+		TemplateID: 5,
+		RequestID:  logger.RequestIDFromContext(ctx),
+	}
+
+	serializedEmailData, sErr := json.Marshal(taskData)
+	if sErr != nil {
+		log.Printf("[controller][account][SendOrgInviteEmail] - error serializing email data: %v", sErr)
+		return sErr
+	}
+
+	sendMail, zErr := u.Queue.NewTask(ctx, fmt.Sprintf("%s:%s", blueprint.SendOrgInviteEmailTaskPattern, taskID), blueprint.EmailQueueName, 2, serializedEmailData)
+	if zErr != nil {
+		log.Printf("[controller][account][SendOrgInviteEmail] - error creating task: %v", zErr)
+		return zErr
+	}
+
+	err := u.Queue.EnqueueTask(ctx, sendMail, blueprint.EmailQueueName, taskID, time.Second*2)
+	if err != nil {
+		log.Printf("[controller][account][SendOrgInviteEmail] - error enqueuing task: %v", err)
+	}
+	return err
+}
+
+// fetchManagedMember fetches the member of the userId param and makes sure the actor can manage them: the owner manages
+// everybody else, the other members only the members with a lower role than theirs. If they can't, the error response
+// is written and a nil member is returned alongside the result of writing it.
+func (u *UserController) fetchManagedMember(ctx *fiber.Ctx, actor *blueprint.OrgMember) (*blueprint.OrgMember, error) {
+	userId := ctx.Params("userId")
+	if !util.IsValidUUID(userId) {
+		return nil, util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user id")
+	}
+	if userId == actor.User.String() {
+		return nil, util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "You can not change your own role")
+	}
+
+	database := db.NewDB{DB: u.DB}
+	member, err := database.FetchOrgMember(actor.Org.String(), userId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Member not found")
+		}
+		log.Printf("[controller][account][fetchManagedMember] - error fetching member %s: %v", userId, err)
+		return nil, util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	if !canManageRole(actor, member.Role) {
+		return nil, util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", fmt.Sprintf("You can not manage members with the %s role", member.Role))
+	}
+	return member, nil
+}
+
+// canManageRole returns true if the member can manage (give, or change the role of) members with the role: the owner
+// can manage every role but owner, the others only the roles lower than theirs.
+func canManageRole(member *blueprint.OrgMember, role string) bool {
+	if !blueprint.IsValidOrgRole(role) || role == blueprint.OrgRoleOwner {
+		return false
+	}
+	if member.Role == blueprint.OrgRoleOwner {
+		return true
+	}
+	return blueprint.OrgRoleAtLeast(member.Role, role) && member.Role != role
+}
+
+// generateInviteToken generates the token of an invitation. Only its hash is stored.
+func generateInviteToken() (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(random), nil
+}
+
+// hashInviteToken returns the hex SHA-256 hash of an invitation token, which is what is stored and looked up.
+func hashInviteToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
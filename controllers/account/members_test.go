@@ -0,0 +1,82 @@
+package account
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/db/queries"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCanManageRole(t *testing.T) {
+	owner := &blueprint.OrgMember{Role: blueprint.OrgRoleOwner}
+	admin := &blueprint.OrgMember{Role: blueprint.OrgRoleAdmin}
+	developer := &blueprint.OrgMember{Role: blueprint.OrgRoleDeveloper}
+
+	assert.True(t, canManageRole(owner, blueprint.OrgRoleAdmin))
+	assert.False(t, canManageRole(owner, blueprint.OrgRoleOwner))
+	assert.False(t, canManageRole(admin, blueprint.OrgRoleAdmin))
+	assert.True(t, canManageRole(admin, blueprint.OrgRoleDeveloper))
+	assert.True(t, canManageRole(admin, blueprint.OrgRoleViewer))
+	assert.True(t, canManageRole(developer, blueprint.OrgRoleViewer))
+	assert.False(t, canManageRole(developer, blueprint.OrgRoleDeveloper))
+	assert.False(t, canManageRole(admin, "superuser"))
+
+	assert.True(t, blueprint.OrgRoleAtLeast(blueprint.OrgRoleOwner, blueprint.OrgRoleViewer))
+	assert.False(t, blueprint.OrgRoleAtLeast(blueprint.OrgRoleViewer, blueprint.OrgRoleDeveloper))
+	assert.False(t, blueprint.OrgRoleAtLeast("", blueprint.OrgRoleViewer))
+}
+
+// acceptOrgInvite accepts an invitation to the org for a user without an account, with the invitation and the creation
+// of the user returning the rows affected passed.
+func acceptOrgInvite(t *testing.T, userRows, inviteRows int64) (*http.Response, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	controller := NewUserController(sqlx.NewDb(conn, "postgres"), nil, nil, nil, testEnvelope, config.Get())
+
+	invite := uuid.NewString()
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchPendingOrgInviteByToken)).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "org", "email", "role"}).AddRow(invite, uuid.NewString(), "dev@orchdio.com", blueprint.OrgRoleDeveloper))
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchOrgUserByEmail)).WithArgs("dev@orchdio.com").WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries.CreateNewOrgUser)).WillReturnResult(sqlmock.NewResult(0, userRows))
+	if userRows == 1 {
+		mock.ExpectExec(regexp.QuoteMeta(queries.AcceptOrgInvite)).WithArgs(invite).WillReturnResult(sqlmock.NewResult(0, inviteRows))
+	}
+	mock.ExpectRollback()
+
+	server := fiber.New()
+	server.Post("/v1/org/invites/accept", controller.AcceptOrgInvite)
+	req := httptest.NewRequest(http.MethodPost, "/v1/org/invites/accept", strings.NewReader(`{"token":"token","password":"password"}`))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	res, err := server.Test(req)
+	require.NoError(t, err)
+	return res, mock
+}
+
+func TestAcceptOrgInvite(t *testing.T) {
+	t.Run("an invitation accepted in the meantime", func(t *testing.T) {
+		res, mock := acceptOrgInvite(t, 1, 0)
+		// the user is not created and is not added to the org.
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a user created in the meantime", func(t *testing.T) {
+		res, mock := acceptOrgInvite(t, 0, 0)
+		assert.Equal(t, http.StatusConflict, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
@@ -0,0 +1,149 @@
+package account
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/util"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
+)
+
+const (
+	defaultNotificationsLimit = 20
+	maxNotificationsLimit     = 100
+	// maxBulkReadNotifications is the max number of notification ids that can be marked as read in one request
+	maxBulkReadNotifications = 100
+)
+
+// FetchUserNotifications fetches the notifications (e.g. playlist follow updates) of a user for the app making the request.
+// The notifications can be filtered by status (read or unread) and by the time they were created (since, RFC3339) and are
+// paginated using limit and offset.
+func (u *UserController) FetchUserNotifications(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	userId := ctx.Params("userId")
+	log.Printf("[controller][user][FetchUserNotifications] - fetching notifications for user %s\n", userId)
+
+	if !util.IsValidUUID(userId) {
+		log.Printf("[controller][user][FetchUserNotifications] - invalid user id %s\n", userId)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user id. Please pass a valid Orchdio user id")
+	}
+
+	filter := blueprint.NotificationFilter{
+		Status: ctx.Query("status"),
+		Limit:  ctx.QueryInt("limit", defaultNotificationsLimit),
+		Offset: ctx.QueryInt("offset", 0),
+	}
+
+	if filter.Status != "" && !lo.Contains([]string{blueprint.NotificationStatusUnread, blueprint.NotificationStatusRead}, filter.Status) {
+		log.Printf("[controller][user][FetchUserNotifications] - invalid status filter %s\n", filter.Status)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid status. Status must be either 'read' or 'unread'")
+	}
+
+	if filter.Limit <= 0 || filter.Limit > maxNotificationsLimit {
+		log.Printf("[controller][user][FetchUserNotifications] - invalid limit %d\n", filter.Limit)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid limit. Limit must be between 1 and 100")
+	}
+
+	if filter.Offset < 0 {
+		log.Printf("[controller][user][FetchUserNotifications] - invalid offset %d\n", filter.Offset)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid offset. Offset cannot be negative")
+	}
+
+	if since := ctx.Query("since"); since != "" {
+		sinceTime, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			log.Printf("[controller][user][FetchUserNotifications] - invalid since filter %s: %v\n", since, err)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid since. Since must be a RFC3339 timestamp")
+		}
+		filter.Since = &sinceTime
+	}
+
+	database := db.NewDB{DB: u.DB}
+	notifications, total, err := database.FetchUserNotifications(userId, app.UID.String(), &filter)
+	if err != nil {
+		log.Printf("[controller][user][FetchUserNotifications] - error fetching notifications: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	response := blueprint.PaginatedNotifications{
+		Notifications: notifications,
+		Total:         total,
+		Limit:         filter.Limit,
+		Offset:        filter.Offset,
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, response)
+}
+
+// MarkNotificationAsRead marks a single notification of a user as read.
+func (u *UserController) MarkNotificationAsRead(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	userId := ctx.Params("userId")
+	notificationId := ctx.Params("notificationId")
+	log.Printf("[controller][user][MarkNotificationAsRead] - marking notification %s as read for user %s\n", notificationId, userId)
+
+	if !util.IsValidUUID(userId) || !util.IsValidUUID(notificationId) {
+		log.Printf("[controller][user][MarkNotificationAsRead] - invalid user or notification id\n")
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user or notification id")
+	}
+
+	database := db.NewDB{DB: u.DB}
+	err := database.MarkNotificationAsRead(notificationId, userId, app.UID.String())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[controller][user][MarkNotificationAsRead] - notification %s not found\n", notificationId)
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Notification not found")
+		}
+		log.Printf("[controller][user][MarkNotificationAsRead] - error marking notification as read: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, nil)
+}
+
+// MarkNotificationsAsRead marks the notifications passed in the body as read. If no notification ids are passed,
+// all the unread notifications of the user are marked as read.
+func (u *UserController) MarkNotificationsAsRead(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	userId := ctx.Params("userId")
+	log.Printf("[controller][user][MarkNotificationsAsRead] - marking notifications as read for user %s\n", userId)
+
+	if !util.IsValidUUID(userId) {
+		log.Printf("[controller][user][MarkNotificationsAsRead] - invalid user id %s\n", userId)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid user id. Please pass a valid Orchdio user id")
+	}
+
+	var body blueprint.MarkNotificationsReadBody
+	if len(ctx.Body()) > 0 {
+		err := ctx.BodyParser(&body)
+		if err != nil {
+			log.Printf("[controller][user][MarkNotificationsAsRead] - error parsing body: %v\n", err)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid request body")
+		}
+	}
+
+	if len(body.IDs) > maxBulkReadNotifications {
+		log.Printf("[controller][user][MarkNotificationsAsRead] - too many notification ids passed: %d\n", len(body.IDs))
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Too many notification ids. Maximum is 100")
+	}
+
+	for _, id := range body.IDs {
+		if !util.IsValidUUID(id) {
+			log.Printf("[controller][user][MarkNotificationsAsRead] - invalid notification id %s\n", id)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid notification id present. Please make sure all ids are uuid format")
+		}
+	}
+
+	database := db.NewDB{DB: u.DB}
+	count, err := database.MarkNotificationsAsRead(userId, app.UID.String(), body.IDs)
+	if err != nil {
+		log.Printf("[controller][user][MarkNotificationsAsRead] - error marking notifications as read: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	return util.SuccessResponse(ctx, http.StatusOK, map[string]int64{"updated": count})
+}
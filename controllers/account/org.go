@@ -1,6 +1,7 @@
 package account
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"errors"
@@ -11,8 +12,9 @@ import (
 	"orchdio/blueprint"
 	"orchdio/db"
 	"orchdio/db/queries"
+	"orchdio/logger"
+	"orchdio/services/audit"
 	"orchdio/util"
-	"os"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -126,7 +128,7 @@ func (u *UserController) CreateOrg(ctx *fiber.Ctx) error {
 			appToken, sErr := util.SignOrgLoginJWT(&blueprint.AppJWT{
 				OrgID:       string(uid),
 				DeveloperID: userId,
-			})
+			}, u.Config.Auth.JWTSecret)
 
 			if sErr != nil {
 				log.Printf("[controller][account][CreateOrg] - error signing app token: %v", sErr)
@@ -140,7 +142,7 @@ func (u *UserController) CreateOrg(ctx *fiber.Ctx) error {
 				"token":       string(appToken),
 			}
 
-			mailErr := u.SendAdminWelcomeEmail(body.OwnerEmail)
+			mailErr := u.SendAdminWelcomeEmail(logger.Context(ctx), body.OwnerEmail)
 			if mailErr != nil {
 				log.Printf("[controller][account][LoginUserToOrg] - error sending welcome email: %v", mailErr)
 			}
@@ -153,7 +155,7 @@ func (u *UserController) CreateOrg(ctx *fiber.Ctx) error {
 	appToken, err := util.SignOrgLoginJWT(&blueprint.AppJWT{
 		OrgID:       org.UID.String(),
 		DeveloperID: userId,
-	})
+	}, u.Config.Auth.JWTSecret)
 
 	res := &blueprint.OrchdioOrgCreateResponse{
 		OrgID:       org.UID.String(),
@@ -162,7 +164,7 @@ func (u *UserController) CreateOrg(ctx *fiber.Ctx) error {
 		Token:       string(appToken),
 	}
 
-	mailErr := u.SendAdminWelcomeEmail(body.OwnerEmail)
+	mailErr := u.SendAdminWelcomeEmail(logger.Context(ctx), body.OwnerEmail)
 	if mailErr != nil {
 		log.Printf("[controller][account][LoginUserToOrg] - error sending welcome email: %v", mailErr)
 	}
@@ -189,20 +191,31 @@ func (u *UserController) DeleteOrg(ctx *fiber.Ctx) error {
 	}
 
 	database := db.NewDB{DB: u.DB}
-	err := database.DeleteOrg(orgId, claims.DeveloperID)
+	org, err := database.FetchUserOrgByID(claims.DeveloperID, orgId)
+	if err != nil {
+		log.Printf("[controller][account][DeleteOrg] - error fetching org: %v", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not delete organization")
+	}
+	err = database.DeleteOrg(orgId, claims.DeveloperID)
 	if err != nil {
 		log.Printf("[controller][account][DeleteOrg] - error deleting org: %v", err)
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not delete organization")
 	}
+	audit.Record(ctx, u.DB, &audit.Entry{
+		Org:        orgId,
+		Action:     blueprint.AuditOrgDelete,
+		TargetType: blueprint.AuditTargetOrg,
+		TargetID:   orgId,
+		Before:     &org.Organization,
+	})
 
 	log.Printf("[controller][account][DeleteOrg] - org deleted with unique id: %s", orgId)
 	return util.SuccessResponse(ctx, http.StatusOK, "success")
 }
 
-// UpdateOrg updates an org belonging to the user.
+// UpdateOrg updates an org the user is an admin of.
 func (u *UserController) UpdateOrg(ctx *fiber.Ctx) error {
 	log.Printf("[controller][account][UpdateOrg] - updating org")
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT)
 
 	orgId := ctx.Params("orgId")
 	var updateData blueprint.UpdateOrganizationData
@@ -222,8 +235,14 @@ func (u *UserController) UpdateOrg(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Org ID is invalid. Please pass a valid Org ID")
 	}
 
+	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT)
 	database := db.NewDB{DB: u.DB}
-	err = database.UpdateOrg(orgId, claims.DeveloperID, &updateData)
+	before, err := database.FetchUserOrgByID(claims.DeveloperID, orgId)
+	if err != nil {
+		log.Printf("[controller][account][UpdateOrg] - error fetching org: %v", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not update organization")
+	}
+	err = database.UpdateOrg(orgId, &updateData)
 	if err != nil {
 		log.Printf("[controller][account][UpdateOrg] - error updating org: %v", err)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -232,16 +251,31 @@ func (u *UserController) UpdateOrg(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not update organization")
 	}
 
+	after, err := database.FetchUserOrgByID(claims.DeveloperID, orgId)
+	if err != nil {
+		log.Printf("[controller][account][UpdateOrg] - error fetching updated org: %v", err)
+	}
+	entry := &audit.Entry{
+		Org:        orgId,
+		Action:     blueprint.AuditOrgUpdate,
+		TargetType: blueprint.AuditTargetOrg,
+		TargetID:   orgId,
+		Before:     &before.Organization,
+	}
+	if after != nil {
+		entry.After = &after.Organization
+	}
+	audit.Record(ctx, u.DB, entry)
 	return util.SuccessResponse(ctx, http.StatusOK, "success")
 }
 
-// FetchUserOrgs returns all orgs belonging to the user.
+// FetchUserOrgs returns all orgs the user is a member of, with their role in each.
 func (u *UserController) FetchUserOrgs(ctx *fiber.Ctx) error {
 	log.Printf("[controller][account][GetOrgs] - getting orgs")
 	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT)
 
 	database := db.NewDB{DB: u.DB}
-	orgs, err := database.FetchOrg(claims.DeveloperID)
+	orgs, err := database.FetchUserOrgs(claims.DeveloperID)
 	if err != nil {
 		log.Printf("[controller][account][GetOrgs] - error getting orgs: %v", err)
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not get organizations")
@@ -296,9 +330,29 @@ func (u *UserController) LoginUserToOrg(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "Invalid login", "Could not login to organization. Password or email is incorrect.")
 	}
 
-	org, err := database.FetchOrg(user.UUID.String())
+	// users can be members of several orgs. They log into the one passed or, by default, the one they own.
+	var org *blueprint.UserOrganization
+	if body.OrgID != "" {
+		if !util.IsValidUUID(body.OrgID) {
+			log.Printf("[controller][account][LoginUserToOrg] - error: org id is invalid")
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Org ID is invalid. Please pass a valid Org ID")
+		}
+		org, err = database.FetchUserOrgByID(user.UUID.String(), body.OrgID)
+	} else {
+		var orgs []blueprint.UserOrganization
+		orgs, err = database.FetchUserOrgs(user.UUID.String())
+		if err == nil && len(orgs) == 0 {
+			err = sql.ErrNoRows
+		}
+		if err == nil {
+			org = &orgs[0]
+		}
+	}
 	if err != nil {
 		log.Printf("[controller][account][LoginUserToOrg] - error getting orgs: %v", err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Could not login to organization. You are not a member of this organization")
+		}
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not get organizations")
 	}
 
@@ -306,9 +360,9 @@ func (u *UserController) LoginUserToOrg(ctx *fiber.Ctx) error {
 	token, err := util.SignOrgLoginJWT(&blueprint.AppJWT{
 		OrgID:       org.UID.String(),
 		DeveloperID: user.UUID.String(),
-	})
+	}, u.Config.Auth.JWTSecret)
 
-	apps, err := database.FetchApps(user.UUID.String(), org.UID.String())
+	apps, err := database.FetchApps(org.UID.String())
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		log.Printf("[controller][account][LoginUserToOrg] - error getting apps: %v", err)
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not get apps")
@@ -320,24 +374,26 @@ func (u *UserController) LoginUserToOrg(ctx *fiber.Ctx) error {
 		Description: org.Description,
 		Token:       string(token),
 		Apps:        apps,
+		Role:        org.Role,
 	}
 
 	// return a single org for now.
 	return util.SuccessResponse(ctx, http.StatusOK, result)
 }
 
-func (u *UserController) SendAdminWelcomeEmail(email string) error {
+func (u *UserController) SendAdminWelcomeEmail(ctx context.Context, email string) error {
 	// prepare welcome email
 	taskID := uuid.NewString()
 	// orchdioQueue := queue.NewOrchdioQueue(u.AsynqClient, u.DB, u.Redis, u.AsynqServer)
 	taskData := &blueprint.EmailTaskData{
-		From:    os.Getenv("ALERT_EMAIL"),
+		From:    u.Config.Email.AlertEmail,
 		To:      email,
 		Payload: nil,
 		// todo: move this to a configuration, to make it easier to override
 		Subject:    "Welcome to Orchdio",
 		TaskID:     taskID,
 		TemplateID: 3,
+		RequestID:  logger.RequestIDFromContext(ctx),
 	}
 
 	serializedEmailData, sErr := json.Marshal(taskData)
@@ -346,13 +402,13 @@ func (u *UserController) SendAdminWelcomeEmail(email string) error {
 		return sErr
 	}
 
-	sendMail, zErr := u.Queue.NewTask(fmt.Sprintf("%s:%s", blueprint.SendWelcomeEmailTaskPattern, taskID), blueprint.EmailQueueName, 2, serializedEmailData)
+	sendMail, zErr := u.Queue.NewTask(ctx, fmt.Sprintf("%s:%s", blueprint.SendWelcomeEmailTaskPattern, taskID), blueprint.EmailQueueName, 2, serializedEmailData)
 	if zErr != nil {
 		log.Printf("[controller][account][CreateOrg] - error creating task: %v", zErr)
 		return zErr
 	}
 
-	err := u.Queue.EnqueueTask(sendMail, blueprint.EmailQueueName, taskID, time.Second*2)
+	err := u.Queue.EnqueueTask(ctx, sendMail, blueprint.EmailQueueName, taskID, time.Second*2)
 	if err != nil {
 		log.Printf("[controller][account][CreateOrg] - error enqueuing task: %v", err)
 	}
@@ -10,17 +10,21 @@ import (
 	"net/mail"
 	"net/url"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
 	"orchdio/db/queries"
+	"orchdio/internal/encryption"
+	"orchdio/logger"
 	"orchdio/queue"
 	"orchdio/services"
 	"orchdio/services/deezer"
 	orchdioFollow "orchdio/services/follow"
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
+	"orchdio/services/tokens"
 	"orchdio/universal"
 	"orchdio/util"
-	"os"
+	svixwebhook "orchdio/webhooks/svix"
 	"strings"
 	"time"
 
@@ -33,18 +37,28 @@ import (
 )
 
 type UserController struct {
-	DB    *sqlx.DB
-	Redis *redis.Client
-	Queue queue.QueueService
+	DB     *sqlx.DB
+	Redis  *redis.Client
+	Queue  queue.QueueService
+	Tokens *tokens.Manager
+	// WebhookSender sends the user events (e.g. user_platform_disconnected) to the apps.
+	WebhookSender svixwebhook.SvixInterface
+	// Envelope decrypts the integration credentials of the apps.
+	Envelope *encryption.Envelope
+	Config   *config.Config
 	// AsynqClient *asynq.Client
 	// AsynqServer *asynq.ServeMux
 }
 
-func NewUserController(db *sqlx.DB, r *redis.Client, q queue.QueueService) *UserController {
+func NewUserController(db *sqlx.DB, r *redis.Client, q queue.QueueService, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) *UserController {
 	return &UserController{
-		DB:    db,
-		Redis: r,
-		Queue: q,
+		DB:            db,
+		Redis:         r,
+		Queue:         q,
+		Tokens:        tokens.NewManager(db, r, webhookSender, envelope),
+		WebhookSender: webhookSender,
+		Envelope:      envelope,
+		Config:        cfg,
 		// AsynqClient: asynqClient,
 		// AsynqServer: asynqServer,
 	}
@@ -158,9 +172,9 @@ func (u *UserController) FollowPlaylist(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, err, "Could not follow playlist. Invalid body passed")
 	}
 
-	if len(subscriberBody.Users) > 20 {
-		log.Printf("[controller][follow][FollowPlaylist] - too many subscribers. Max is 20")
-		return util.ErrorResponse(ctx, http.StatusBadRequest, "large subscriber body", "too many subscribers. Maximum is 20")
+	if limit := u.Config.Follow.SubscriberLimit; len(subscriberBody.Users) > limit {
+		log.Printf("[controller][follow][FollowPlaylist] - too many subscribers. Max is %d", limit)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "large subscriber body", fmt.Sprintf("too many subscribers. Maximum is %d", limit))
 	}
 	for _, subscriber := range subscriberBody.Users {
 		if !util.IsValidUUID(subscriber) {
@@ -169,7 +183,7 @@ func (u *UserController) FollowPlaylist(ctx *fiber.Ctx) error {
 		}
 	}
 
-	linkInfo, err := services.ExtractLinkInfo(subscriberBody.Url)
+	linkInfo, err := services.ExtractLinkInfo(subscriberBody.Url, u.Config.Platforms)
 	if err != nil {
 		log.Printf("[controller][follow][FollowPlaylist] - error extracting link info: %v", err)
 		return util.ErrorResponse(ctx, http.StatusBadRequest, err, "Could not extract link information.")
@@ -186,7 +200,7 @@ func (u *UserController) FollowPlaylist(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "not a playlist", "It seems your didnt pass a playlist url. Please check your url again")
 	}
 
-	follow := orchdioFollow.NewFollow(u.DB, u.Redis)
+	follow := orchdioFollow.NewFollow(u.DB, u.Redis, u.Config)
 
 	followId, err := follow.FollowPlaylist(user.UUID.String(), app.UID.String(), subscriberBody.Url, linkInfo, subscriberBody.Users)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
@@ -251,51 +265,41 @@ func (u *UserController) FetchUserInfoByIdentifier(ctx *fiber.Ctx) error {
 		userInfo.Email = user.Email
 		userInfo.ID = user.UserID
 
-		var refreshToken string
-		// if the user refresh token is nil, the user has not connected this platform to Orchdio.
-		// this is because everytime a user connects a platform to Orchdio, the refresh token is updated for the platform the user connected
-		if user.RefreshToken == nil {
-			log.Printf("[platforms][FetchUserPlatformsInfo] error - user's refresh token is empty %v\n", err)
-			return util.ErrorResponse(ctx, http.StatusUnauthorized, "no access", "User has not connected this platform to Orchdio")
-		}
-
-		if user.RefreshToken != nil {
-			// decrypt the user's access token
-			r, err := util.Decrypt(user.RefreshToken, []byte(os.Getenv("ENCRYPTION_SECRET")))
-			if err != nil {
-				log.Printf("[platforms][FetchUserPlatformsInfo] error - error decrypting access token %v\n", err)
-				return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
+		token, tErr := u.Tokens.Token(ctx.Context(), user.UserID, app, user.Platform)
+		if tErr != nil {
+			log.Printf("[platforms][FetchUserPlatformsInfo] error - could not get the user's %s token %v\n", user.Platform, tErr)
+			if errors.Is(tErr, tokens.ErrNotConnected) {
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, "no access", "User has not connected this platform to Orchdio")
+			}
+			if errors.Is(tErr, blueprint.ErrUserAuthRevoked) {
+				revoked, rErr := u.Tokens.ReportAuthRevoked(user.UserID, app, user.Platform, ctx.Hostname())
+				if rErr != nil {
+					log.Printf("[platforms][FetchUserPlatformsInfo] error - could not report revoked %s authorization: %v\n", user.Platform, rErr)
+					return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
+				}
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, revoked, "User revoked the access of this app on the platform. Please ask the user to connect the platform again")
 			}
-			refreshToken = string(r)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
 		}
 
 		authInfo := blueprint.UserAuthInfoForRequests{
-			RefreshToken: refreshToken,
-			AccessToken:  user.AccessToken,
-			// ExpiresIn:    expiresInString,
-			Platform: user.Platform,
-			UserID:   user.UserID,
-		}
-
-		if user.ExpiresIn.String != "" {
-			t, pErr := time.Parse("2006-01-02 15:04:05-07", user.ExpiresIn.String)
-			if pErr != nil {
-				log.Printf("[platforms][FetchUserPlatformsInfo] - error: could not parse expires in for platform %s - %v", user.Platform, pErr)
-			}
-			expiresInString := t.Format(time.RFC3339)
-			authInfo.ExpiresIn = expiresInString
+			RefreshToken: token.RefreshToken,
+			AccessToken:  token.AccessToken,
+			ExpiresIn:    token.Expiry.Format(time.RFC3339),
+			Platform:     user.Platform,
+			UserID:       user.UserID,
 		}
 
 		var info *blueprint.UserPlatformInfo
 		if user.Platform == tidal.IDENTIFIER {
-			info1, err := universal.FetchUserPlatformsInfo(authInfo, app.UID.String(), u.DB, u.Redis)
+			info1, err := universal.FetchUserPlatformsInfo(authInfo, app.UID.String(), u.DB, u.Redis, u.WebhookSender, u.Envelope, u.Config)
 			if err != nil {
 				log.Println("Error fetching the user information from TIDAL.. could be token issue")
 			} else {
 				info = info1
 			}
 		}
-		info, err := universal.FetchUserPlatformsInfo(authInfo, app.UID.String(), u.DB, u.Redis)
+		info, err := universal.FetchUserPlatformsInfo(authInfo, app.UID.String(), u.DB, u.Redis, u.WebhookSender, u.Envelope, u.Config)
 		if err != nil {
 			log.Printf("[platforms][FetchUserPlatformsInfo] error - could not fetch user info on %s platform", user.Platform)
 		}
@@ -388,13 +392,14 @@ func (u *UserController) ResetPassword(ctx *fiber.Ctx) error {
 	// then send the email....
 	// orchdioQueue := queue.NewOrchdioQueue(u.Queue.AsynqClient, u.DB, u.Redis, u.Queue.AsynqRouter)
 	taskData := &blueprint.EmailTaskData{
-		From: os.Getenv("ALERT_EMAIL"),
+		From: u.Config.Email.AlertEmail,
 		To:   body.Email,
 		Payload: map[string]any{
-			"RESETLINK": fmt.Sprintf("%s/change-password?token=%s", os.Getenv("ORCHDIO_DASHBOARD_URL"), resetToken),
+			"RESETLINK": fmt.Sprintf("%s/change-password?token=%s", u.Config.DashboardURL, resetToken),
 		},
 		TaskID:     taskID,
 		TemplateID: 4,
+		RequestID:  logger.RequestID(ctx),
 		Subject:    "Password Reset",
 	}
 
@@ -404,13 +409,13 @@ func (u *UserController) ResetPassword(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not serialize email data")
 	}
 
-	sendMail, qErr := u.Queue.NewTask(fmt.Sprintf("%s_%s", blueprint.SendResetPasswordTaskPattern, taskID), blueprint.SendResetPasswordTaskPattern, 2, serializedEmailData)
+	sendMail, qErr := u.Queue.NewTask(logger.Context(ctx), fmt.Sprintf("%s_%s", blueprint.SendResetPasswordTaskPattern, taskID), blueprint.SendResetPasswordTaskPattern, 2, serializedEmailData)
 	if qErr != nil {
 		log.Printf("[controller][user][ResetPassword] - error creating send email task: %v", qErr)
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, qErr, "Could not create send email task")
 	}
 
-	err = u.Queue.EnqueueTask(sendMail, blueprint.EmailQueueName, taskID, time.Second*2)
+	err = u.Queue.EnqueueTask(logger.Context(ctx), sendMail, blueprint.EmailQueueName, taskID, time.Second*2)
 	if err != nil {
 		log.Printf("[controller][user][ResetPassword] - error enqueuing send email task: %v", err)
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not enqueue send email task")
@@ -485,9 +490,9 @@ func (u *UserController) ChangePassword(ctx *fiber.Ctx) error {
 	token, err := util.SignOrgLoginJWT(&blueprint.AppJWT{
 		OrgID:       userOrg.UID.String(),
 		DeveloperID: user.UUID.String(),
-	})
+	}, u.Config.Auth.JWTSecret)
 
-	apps, er := DB.FetchApps(userOrg.UID.String(), user.UUID.String())
+	apps, er := DB.FetchApps(userOrg.UID.String())
 	if er != nil && !errors.Is(er, sql.ErrNoRows) {
 		log.Printf("[controller][user][ChangePassword] - error fetching user apps: %v", er)
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, er, "Could not fetch user apps")
@@ -12,8 +12,10 @@ import (
 	"net/http"
 	"net/url"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
 	"orchdio/db/queries"
+	"orchdio/internal/encryption"
 	logger2 "orchdio/logger"
 	"orchdio/queue"
 	"orchdio/services"
@@ -22,7 +24,6 @@ import (
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
 	"orchdio/util"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -43,10 +44,13 @@ type Controller struct {
 	AsynqServer *asynq.Server
 	AsynqRouter *asynq.ServeMux
 	Redis       *redis.Client
+	// Envelope encrypts the refresh tokens of the users and decrypts the integration credentials of the apps.
+	Envelope *encryption.Envelope
+	Config   *config.Config
 }
 
-func NewAuthController(db *sqlx.DB, asynqClient *asynq.Client, asynqServer *asynq.Server, asyqRouter *asynq.ServeMux, r *redis.Client) *Controller {
-	return &Controller{DB: db, AsyncClient: asynqClient, AsynqServer: asynqServer, AsynqRouter: asyqRouter, Redis: r}
+func NewAuthController(db *sqlx.DB, asynqClient *asynq.Client, asynqServer *asynq.Server, asyqRouter *asynq.ServeMux, r *redis.Client, envelope *encryption.Envelope, cfg *config.Config) *Controller {
+	return &Controller{DB: db, AsyncClient: asynqClient, AsynqServer: asynqServer, AsynqRouter: asyqRouter, Redis: r, Envelope: envelope, Config: cfg}
 }
 
 // AppAuthRedirect is called when an application performs authorization and authentication for a specific platform.
@@ -59,13 +63,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 	appScopes := ctx.Query("scopes")
 	developerPubKey := ctx.Locals("app_pub_key")
 
-	// fixme: this seems redundant in most cases i am using it. remove where unnecessary and consider the possible useful cases.
-	loggerOpts := &blueprint.OrchdioLoggerOptions{
-		ApplicationPublicKey: zap.String("pubkey:", developerPubKey.(string)).String,
-		Platform:             platform,
-	}
-
-	logger := logger2.NewZapSentryLogger(loggerOpts)
+	logger := logger2.FromFiber(ctx).With(zap.String("public_key", developerPubKey.(string)))
 	if pubKey == "" {
 		logger.Error("[controllers][AppAuthRedirect] developer -  error: no app id provided\n")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "App ID is not present. please pass your app id as a header")
@@ -164,7 +162,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 			return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "Spotify integration is not enabled for this app. Please make sure you update the app with your Spotify credentials")
 		}
 
-		credentials, decErr := util.Decrypt(developerApp.SpotifyCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+		credentials, decErr := a.Envelope.Decrypt(developerApp.SpotifyCredentials)
 		if decErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to decrypt spotify integrationCredentials", zap.Error(decErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -177,7 +175,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to deserialize spotify integrationCredentials", zap.Error(serErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 		}
-		encryptedToken, sErr := util.SignAuthJwt(&redirectToken)
+		encryptedToken, sErr := util.SignAuthJwt(&redirectToken, a.Config.Auth.JWTSecret)
 		if sErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to sign spotify auth jwt", zap.Error(sErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -197,7 +195,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 		}
 
-		credentials, decErr := util.Decrypt(developerApp.TidalCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+		credentials, decErr := a.Envelope.Decrypt(developerApp.TidalCredentials)
 		if decErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to decrypt tidal integrationCredentials", zap.Error(decErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -209,7 +207,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 		}
 
-		encryptedToken, sErr := util.SignAuthJwt(&redirectToken)
+		encryptedToken, sErr := util.SignAuthJwt(&redirectToken, a.Config.Auth.JWTSecret)
 		if sErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to sign tidal auth jwt", zap.Error(sErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -233,7 +231,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 
 		var decryptedCredentials blueprint.IntegrationCredentials
 		// decrypt the app integration integrationCredentials
-		credentials, decErr := util.Decrypt(developerApp.DeezerCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+		credentials, decErr := a.Envelope.Decrypt(developerApp.DeezerCredentials)
 		if decErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to decrypt deezer integrationCredentials", zap.Error(decErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -263,7 +261,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 		}
 
 		var decryptedAppleCredentials blueprint.IntegrationCredentials
-		credentials, decErr := util.Decrypt(developerApp.AppleMusicCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+		credentials, decErr := a.Envelope.Decrypt(developerApp.AppleMusicCredentials)
 		if decErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to decrypt apple music integrationCredentials", zap.Error(decErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -275,7 +273,7 @@ func (a *Controller) AppAuthRedirect(ctx *fiber.Ctx) error {
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 		}
 
-		encryptedToken, sErr := util.SignAuthJwt(&redirectToken)
+		encryptedToken, sErr := util.SignAuthJwt(&redirectToken, a.Config.Auth.JWTSecret)
 		if sErr != nil {
 			logger.Error("[controllers][AppAuthRedirect] developer -  error: unable to sign apple music auth jwt", zap.Error(sErr))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -301,16 +299,8 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 	}{}
 
 	developerPubKey := ctx.Locals("app_pub_key")
-	platform := ctx.Locals("platform").(string)
-
-	reqId := ctx.Get("x-orchdio-request-id")
-	loggerOpts := &blueprint.OrchdioLoggerOptions{
-		RequestID:            reqId,
-		ApplicationPublicKey: zap.String("pubkey:", developerPubKey.(string)).String,
-		Platform:             platform,
-	}
 
-	logger := logger2.NewZapSentryLogger(loggerOpts)
+	logger := logger2.FromFiber(ctx).With(zap.String("public_key", developerPubKey.(string)))
 	database := db.NewDB{DB: a.DB}
 
 	// if the verb is POST, it means that the auth is most likely Apple Music auth, so we'll handle it differently
@@ -319,7 +309,6 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 		// apple music auth flow
 		logger.Info("[controllers][HandleAppAuthRedirect] developer -  handling apple music auth flow")
 		uniqueID := uuid.NewString()
-		encryptionSecret := os.Getenv("ENCRYPTION_SECRET")
 		// todo: add a new "verified_email" column in the db
 		// and use the result here (if present) to determine
 		// apple music auth verification. if the email is not verified,
@@ -352,7 +341,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 		if body.FirstName != "" {
 			displayName = fmt.Sprintf("%v %v", body.FirstName, body.LastName)
 		}
-		encryptedRefreshToken, err := util.Encrypt([]byte(body.MusicToken), []byte(encryptionSecret))
+		encryptedRefreshToken, err := a.Envelope.Encrypt([]byte(body.MusicToken))
 		if err != nil {
 			logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to encrypt apple music user token", zap.Error(err))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -403,7 +392,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 			LastAuthedPlatform: applemusic.IDENTIFIER,
 		}
 
-		authToken, err := util.SignJwt(&t)
+		authToken, err := util.SignJwt(&t, a.Config.Auth.JWTSecret)
 		if err != nil {
 			logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to sign apple music auth jwt", zap.Error(err))
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -452,7 +441,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 
 		var emailTaskData = &blueprint.EmailTaskData{
 			App:  taskApp,
-			From: os.Getenv("ALERT_EMAIL"),
+			From: a.Config.Email.AlertEmail,
 			To:   authedUserEmail,
 			Payload: map[string]interface{}{
 				"APP_NAME": newUserApp.Name,
@@ -463,7 +452,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 			TemplateID: 2,
 		}
 		// schedule a job to send notification email
-		_ = queue.NewOrchdioQueue(a.AsyncClient, a.DB, a.Redis, a.AsynqRouter)
+		_ = queue.NewOrchdioQueue(a.AsyncClient, a.DB, a.Redis, a.AsynqRouter, a.Envelope, a.Config)
 		// serialize the task data
 		_, err = json.Marshal(emailTaskData)
 		if err != nil {
@@ -498,7 +487,6 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 		ctxPlatform := ctx.Params("platform")
 		// this is for the error code that may be returned for deezer (only)
 		errorCode := ctx.Query("error")
-		encryptionSecretKey := os.Getenv("ENCRYPTION_SECRET")
 		if state == "" && ctxPlatform != applemusic.IDENTIFIER {
 			logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: no state present. please pass a state")
 			if ctxPlatform == deezer.IDENTIFIER {
@@ -514,7 +502,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 		// and as for deezer, the state has to be passed statically in the redirect url as deezer does not support
 		// state in the redirect url
 		if !lo.Contains([]string{applemusic.IDENTIFIER, deezer.IDENTIFIER}, ctxPlatform) {
-			dec, err := util.DecodeAuthJwt(state)
+			dec, err := util.DecodeAuthJwt(state, a.Config.Auth.JWTSecret)
 			// decode state
 			if err != nil {
 				if errors.Is(err, jwt.ErrTokenExpired) {
@@ -564,7 +552,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				decodedState = &blueprint.AppAuthToken{
 					RegisteredClaims: jwt.RegisteredClaims{},
 					App:              devApp.UID.String(),
-					RedirectURL:      fmt.Sprintf("%s/v1/auth/deezer?state=%s", os.Getenv("APP_URL"), dState),
+					RedirectURL:      fmt.Sprintf("%s/v1/auth/deezer?state=%s", a.Config.AppURL, dState),
 					Platform:         deezer.IDENTIFIER,
 					Action: blueprint.Action{
 						Payload: nil,
@@ -636,7 +624,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 			}
 
 			// decrypt the app's integration credentials
-			decryptedIntegrationCredentials, dErr := util.Decrypt(app.SpotifyCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+			decryptedIntegrationCredentials, dErr := a.Envelope.Decrypt(app.SpotifyCredentials)
 			if dErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to decrypt spotify credentials", zap.Error(dErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -673,7 +661,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 			}
 
-			encryptedRefreshToken, rErr := util.Encrypt([]byte(oauthToken.RefreshToken), []byte(encryptionSecretKey))
+			encryptedRefreshToken, rErr := a.Envelope.Encrypt([]byte(oauthToken.RefreshToken))
 			if rErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to encrypt spotify refresh token", zap.Error(rErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -723,7 +711,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				LastAuthedPlatform: spotify.IDENTIFIER,
 			}
 
-			authT, sErr := util.SignJwt(&t)
+			authT, sErr := util.SignJwt(&t, a.Config.Auth.JWTSecret)
 			if sErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to sign spotify auth jwt", zap.Error(sErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -744,7 +732,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 			}
 
-			decryptedIntegrationCredentials, dErr := util.Decrypt(app.TidalCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+			decryptedIntegrationCredentials, dErr := a.Envelope.Decrypt(app.TidalCredentials)
 			if dErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to decrypt tidal credentials", zap.Error(dErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -770,7 +758,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 			}
 
-			encryptedRefreshToken, rErr := util.Encrypt([]byte(oauthToken.RefreshToken), []byte(encryptionSecretKey))
+			encryptedRefreshToken, rErr := a.Envelope.Encrypt([]byte(oauthToken.RefreshToken))
 			if rErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to encrypt tidal refresh token", zap.Error(rErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -820,7 +808,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				LastAuthedPlatform: spotify.IDENTIFIER,
 			}
 
-			authT, sErr := util.SignJwt(&t)
+			authT, sErr := util.SignJwt(&t, a.Config.Auth.JWTSecret)
 			if sErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to sign tidal auth jwt", zap.Error(sErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -845,7 +833,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 			}
 
 			var deezerCredentials blueprint.IntegrationCredentials
-			creds, credErr := util.Decrypt(app.DeezerCredentials, []byte(encryptionSecretKey))
+			creds, credErr := a.Envelope.Decrypt(app.DeezerCredentials)
 			if credErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to decrypt deezer credentials", zap.Error(credErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -880,7 +868,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
 			}
 
-			encryptedRefreshToken, encErr := util.Encrypt(deezerToken, []byte(encryptionSecretKey))
+			encryptedRefreshToken, encErr := a.Envelope.Encrypt(deezerToken)
 			if encErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to encrypt deezer refresh token", zap.Error(encErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -919,7 +907,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 				LastAuthedPlatform: deezer.IDENTIFIER,
 			}
 
-			authToken, sErr := util.SignJwt(&t)
+			authToken, sErr := util.SignJwt(&t, a.Config.Auth.JWTSecret)
 			if sErr != nil {
 				logger.Error("[controllers][HandleAppAuthRedirect] developer -  error: unable to sign deezer auth jwt", zap.Error(sErr))
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
@@ -983,7 +971,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 		// https://orchdio.com/my-data and deleting their data.
 		var _ = &blueprint.EmailTaskData{
 			App:  taskApp,
-			From: os.Getenv("ALERT_EMAIL"),
+			From: a.Config.Email.AlertEmail,
 			To:   authedUserEmail,
 			Payload: map[string]interface{}{
 				"APP_NAME": app.Name,
@@ -994,7 +982,7 @@ func (a *Controller) HandleAppAuthRedirect(ctx *fiber.Ctx) error {
 			TemplateID: 2,
 		}
 		// schedule a job to send notification email
-		_ = queue.NewOrchdioQueue(a.AsyncClient, a.DB, a.Redis, a.AsynqRouter)
+		_ = queue.NewOrchdioQueue(a.AsyncClient, a.DB, a.Redis, a.AsynqRouter, a.Envelope, a.Config)
 		logger.Info("[controllers][HandleAppAuthRedirect] developer -  user authorization and authentication done. Redirecting")
 		return ctx.Redirect(redirectURL, fiber.StatusTemporaryRedirect)
 	}
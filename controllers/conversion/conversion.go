@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
 	"orchdio/util"
 
@@ -24,10 +25,11 @@ type Controller struct {
 	Asynq       *asynq.Client
 	AsynqServer *asynq.Server
 	AsynqMux    *asynq.ServeMux
+	Config      *config.Config
 }
 
 // NewConversionController creates a new conversion controller.
-func NewConversionController(db *sqlx.DB, red *redis.Client, asynqClient *asynq.Client, asynqserver *asynq.Server, mux *asynq.ServeMux) *Controller {
+func NewConversionController(db *sqlx.DB, red *redis.Client, asynqClient *asynq.Client, asynqserver *asynq.Server, mux *asynq.ServeMux, cfg *config.Config) *Controller {
 
 	res := &Controller{
 		DB:          db,
@@ -35,6 +37,7 @@ func NewConversionController(db *sqlx.DB, red *redis.Client, asynqClient *asynq.
 		Asynq:       asynqClient,
 		AsynqServer: asynqserver,
 		AsynqMux:    mux,
+		Config:      cfg,
 	}
 
 	// create a new instance of the queue factory
@@ -0,0 +1,309 @@
+package conversion
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/taskevents"
+	"orchdio/util"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	// streamHeartbeatInterval is how often a comment is sent to keep the connection open. the task status is also
+	// checked then, in case the task ended without its final event being sent.
+	streamHeartbeatInterval = 15 * time.Second
+	// streamWriteMargin is how long before the server write timeout a stream is ended, so that it is ended cleanly.
+	// clients (e.g. EventSource) reconnect and resume with the Last-Event-ID header.
+	streamWriteMargin = 5 * time.Second
+	streamRetryMs     = 1000
+)
+
+// maxStreamDuration is how long a stream is kept open: until streamWriteMargin before the server write timeout.
+func (c *Controller) maxStreamDuration() time.Duration {
+	return c.Config.HTTP.WriteTimeout - streamWriteMargin
+}
+
+// StreamPlaylistTaskEvents streams the events of a playlist conversion task, as Server-Sent Events. The events are the same
+// as the webhook events sent for the conversion (metadata, track, missing track and done), in the order they happened.
+// Clients that cannot set headers authenticate with a stream token instead, see CreateTaskStreamToken.
+// Clients can resume a stream by passing the id of the last event they got in the Last-Event-ID header (or the
+// last_event_id query param). The stream is closed once the task is done or has failed.
+func (c *Controller) StreamPlaylistTaskEvents(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	taskId := ctx.Params("taskId")
+	if !util.IsValidUUID(taskId) {
+		log.Printf("[controller][conversion][StreamPlaylistTaskEvents] - invalid task id %s", taskId)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid task id")
+	}
+
+	lastEventId := ctx.Get("Last-Event-ID", ctx.Query("last_event_id"))
+	var after int64
+	if lastEventId != "" {
+		parsed, err := strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil || parsed < 0 {
+			log.Printf("[controller][conversion][StreamPlaylistTaskEvents] - invalid last event id %s", lastEventId)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid Last-Event-ID")
+		}
+		after = parsed
+	}
+
+	task, err := c.fetchAppTask(app, taskId)
+	if err != nil {
+		return taskErrorResponse(ctx, err)
+	}
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	ctx.Set(fiber.HeaderConnection, "keep-alive")
+	// disable response buffering by proxies (e.g. nginx)
+	ctx.Set("X-Accel-Buffering", "no")
+
+	ctx.Context().SetBodyStreamWriter(fasthttp.StreamWriter(func(w *bufio.Writer) {
+		c.streamTaskEvents(w, taskId, after, isFinalTaskStatus(task.Status))
+	}))
+	return nil
+}
+
+// CreateTaskStreamToken returns a token to open the stream of events of a task, for the clients that cannot set the
+// headers of the stream requests (e.g. EventSource in browsers). The token is passed in the token query param, it is
+// only valid for the task and expires after taskevents.StreamTokenTTL.
+func (c *Controller) CreateTaskStreamToken(ctx *fiber.Ctx) error {
+	app := ctx.Locals("app").(*blueprint.DeveloperApp)
+	taskId := ctx.Params("taskId")
+	if !util.IsValidUUID(taskId) {
+		log.Printf("[controller][conversion][CreateTaskStreamToken] - invalid task id %s", taskId)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid task id")
+	}
+	if _, err := c.fetchAppTask(app, taskId); err != nil {
+		return taskErrorResponse(ctx, err)
+	}
+
+	// the key the token is created with is checked again each time the token is used.
+	streamToken := &taskevents.StreamToken{App: app.UID.String()}
+	if appKey, ok := ctx.Locals("app_key").(*blueprint.AppKey); ok && appKey != nil {
+		streamToken.Key = appKey.UID.String()
+	} else {
+		streamToken.PublicKey = app.PublicKey.String()
+	}
+	token, err := taskevents.NewStreamToken(ctx.Context(), c.Red, streamToken, taskId)
+	if err != nil {
+		log.Printf("[controller][conversion][CreateTaskStreamToken] - could not create stream token: %v", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "could not create stream token")
+	}
+	return util.SuccessResponse(ctx, http.StatusCreated, fiber.Map{
+		"token":      token,
+		"expires_at": time.Now().Add(taskevents.StreamTokenTTL),
+	})
+}
+
+// AuthenticateStream authenticates the requests to the stream of a task that pass a stream token (see
+// CreateTaskStreamToken) in the token query param. The other requests are authenticated by next, with the keys of the app
+// in the headers. A token stops working once the app is disabled, or the key it was created with is revoked, expires or
+// is rotated; the scopes of the key are checked again by the middlewares that follow.
+func (c *Controller) AuthenticateStream(next fiber.Handler) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		token := ctx.Query("token")
+		if token == "" {
+			return next(ctx)
+		}
+
+		streamToken, err := taskevents.FetchStreamToken(ctx.Context(), c.Red, ctx.Params("taskId"), token)
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				log.Printf("[controller][conversion][AuthenticateStream] - unknown or expired stream token for task %s", ctx.Params("taskId"))
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, "unauthorized", "Invalid or expired stream token")
+			}
+			log.Printf("[controller][conversion][AuthenticateStream] - could not fetch stream token: %v", err)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An internal error occurred")
+		}
+
+		database := db.NewDB{DB: c.DB}
+		app, err := database.FetchAppByAppId(streamToken.App)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, "unauthorized", "Invalid or expired stream token")
+			}
+			log.Printf("[controller][conversion][AuthenticateStream] - could not fetch app %s: %v", streamToken.App, err)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An internal error occurred")
+		}
+		if !app.Authorized {
+			log.Printf("[controller][conversion][AuthenticateStream] - app %s of the stream token is disabled", streamToken.App)
+			return util.ErrorResponse(ctx, http.StatusUnauthorized, "unauthorized", "Invalid or expired stream token")
+		}
+
+		if streamToken.Key == "" {
+			// the token was created with the public key, which changes when the keys of the app are rotated.
+			if app.PublicKey.String() != streamToken.PublicKey {
+				log.Printf("[controller][conversion][AuthenticateStream] - public key of the stream token of app %s was rotated", streamToken.App)
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, "unauthorized", "Invalid or expired stream token")
+			}
+			ctx.Locals("app", app)
+			return ctx.Next()
+		}
+
+		appKey, err := database.FetchActiveAppSecretKeyByID(streamToken.Key, streamToken.App)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				log.Printf("[controller][conversion][AuthenticateStream] - key %s of the stream token is revoked or expired", streamToken.Key)
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, "unauthorized", "Invalid or expired stream token")
+			}
+			log.Printf("[controller][conversion][AuthenticateStream] - could not fetch key %s: %v", streamToken.Key, err)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An internal error occurred")
+		}
+		// the requests made with a test key run in the sandbox.
+		app.Sandbox = appKey.Mode == blueprint.AppKeyModeTest
+		ctx.Locals("app", app)
+		ctx.Locals("app_key", appKey)
+		return ctx.Next()
+	}
+}
+
+// errTaskNotFound is returned by fetchAppTask when the task does not exist or belongs to another app.
+var errTaskNotFound = errors.New("404: task not found")
+
+// fetchAppTask returns the task if it belongs to the app.
+func (c *Controller) fetchAppTask(app *blueprint.DeveloperApp, taskId string) (*blueprint.TaskRecord, error) {
+	database := db.NewDB{DB: c.DB}
+	task, err := database.FetchTask(taskId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errTaskNotFound
+		}
+		log.Printf("[controller][conversion][fetchAppTask] - error fetching task: %v", err)
+		return nil, err
+	}
+
+	if task.App != "" && task.App != app.UID.String() {
+		log.Printf("[controller][conversion][fetchAppTask] - task %s does not belong to app %s", taskId, app.UID.String())
+		return nil, errTaskNotFound
+	}
+	return task, nil
+}
+
+func taskErrorResponse(ctx *fiber.Ctx, err error) error {
+	if errors.Is(err, errTaskNotFound) {
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "task not found")
+	}
+	return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "error fetching task")
+}
+
+// streamTaskEvents writes the events of the task after the event id passed, then the live events, until the task
+// ends, the client goes away or the stream has been open for maxStreamDuration.
+func (c *Controller) streamTaskEvents(w *bufio.Writer, taskId string, after int64, taskEnded bool) {
+	streamCtx, cancel := context.WithTimeout(context.Background(), c.maxStreamDuration())
+	defer cancel()
+
+	// subscribe before reading the history, so that no event is missed in between. events sent both live and in the
+	// history are only written once.
+	subscription := taskevents.Subscribe(streamCtx, c.Red, taskId)
+	defer subscription.Close()
+	if _, err := subscription.Receive(streamCtx); err != nil {
+		log.Printf("[controller][conversion][streamTaskEvents] - could not subscribe to task events: %v", err)
+		return
+	}
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", streamRetryMs); err != nil {
+		return
+	}
+
+	lastId := after
+	// writeHistory writes the events after the last one written. it returns false if the stream should be closed.
+	writeHistory := func() bool {
+		events, err := taskevents.History(streamCtx, c.Red, taskId, lastId)
+		if err != nil {
+			log.Printf("[controller][conversion][streamTaskEvents] - could not fetch task events: %v", err)
+			return false
+		}
+		for i := range events {
+			if !writeTaskEvent(w, &events[i]) {
+				return false
+			}
+			lastId = events[i].ID
+			if events[i].IsFinal() {
+				return false
+			}
+		}
+		return true
+	}
+
+	if !writeHistory() || taskEnded {
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatInterval)
+	defer heartbeat.Stop()
+	messages := subscription.Channel()
+	for {
+		select {
+		case <-streamCtx.Done():
+			return
+		case <-heartbeat.C:
+			if _, err := w.WriteString(": keep-alive\n\n"); err != nil || w.Flush() != nil {
+				return
+			}
+			database := db.NewDB{DB: c.DB}
+			task, err := database.FetchTask(taskId)
+			if err == nil && isFinalTaskStatus(task.Status) {
+				writeHistory()
+				return
+			}
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			event, err := taskevents.ParseMessage(message)
+			if err != nil {
+				log.Printf("[controller][conversion][streamTaskEvents] - could not deserialize task event: %v", err)
+				continue
+			}
+			if event.ID <= lastId {
+				continue
+			}
+			// an event was missed (e.g. it was published before the history was read). catch up from the history.
+			if event.ID > lastId+1 {
+				if !writeHistory() {
+					return
+				}
+				continue
+			}
+			if !writeTaskEvent(w, event) {
+				return
+			}
+			lastId = event.ID
+			if event.IsFinal() {
+				return
+			}
+		}
+	}
+}
+
+// writeTaskEvent writes the event as a Server-Sent Event. It returns false if the event could not be written (i.e. the
+// client has gone away).
+func writeTaskEvent(w *bufio.Writer, event *taskevents.Event) bool {
+	data, err := json.Marshal(event.Event)
+	if err != nil {
+		log.Printf("[controller][conversion][writeTaskEvent] - could not serialize task event: %v", err)
+		return true
+	}
+	if _, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Event.Type, data); err != nil {
+		return false
+	}
+	return w.Flush() == nil
+}
+
+func isFinalTaskStatus(status string) bool {
+	return status == blueprint.TaskStatusCompleted || status == blueprint.TaskStatusFailed
+}
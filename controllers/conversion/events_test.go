@@ -0,0 +1,167 @@
+package conversion
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/taskevents"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStream returns the stream route with the auth of the api, where the requests authenticated with the headers
+// belong to headerApp.
+func newTestStream(t *testing.T, headerApp *blueprint.DeveloperApp) (*fiber.App, *Controller, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	red := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	controller := NewConversionController(sqlx.NewDb(conn, "postgres"), red, nil, nil, nil, &config.Config{HTTP: config.HTTP{WriteTimeout: 45 * time.Second}})
+
+	byHeaders := func(ctx *fiber.Ctx) error {
+		if ctx.Get("x-orchdio-public-key") == "" {
+			return ctx.SendStatus(http.StatusBadRequest)
+		}
+		ctx.Locals("app", headerApp)
+		return ctx.Next()
+	}
+	app := fiber.New()
+	app.Get("/v1/task/:taskId/events", controller.AuthenticateStream(byHeaders), controller.StreamPlaylistTaskEvents)
+	return app, controller, mock
+}
+
+func expectTask(mock sqlmock.Sqlmock, taskId, app string) {
+	mock.ExpectQuery("FROM tasks").WithArgs(taskId).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "status", "app"}).AddRow(taskId, blueprint.TaskStatusCompleted, app))
+}
+
+func TestStreamPlaylistTaskEventsAuth(t *testing.T) {
+	owner := &blueprint.DeveloperApp{UID: uuid.New(), PublicKey: uuid.New()}
+	other := &blueprint.DeveloperApp{UID: uuid.New()}
+	taskId := uuid.NewString()
+	path := "/v1/task/" + taskId + "/events"
+
+	t.Run("without a key or a token", func(t *testing.T) {
+		app, _, _ := newTestStream(t, owner)
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+
+	t.Run("the task of another app", func(t *testing.T) {
+		app, _, mock := newTestStream(t, other)
+		expectTask(mock, taskId, owner.UID.String())
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("x-orchdio-public-key", uuid.NewString())
+		res, err := app.Test(req)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNotFound, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("an unknown token", func(t *testing.T) {
+		app, _, _ := newTestStream(t, owner)
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token=unknown", nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("the token of another task", func(t *testing.T) {
+		app, controller, _ := newTestStream(t, owner)
+		token, err := taskevents.NewStreamToken(context.Background(), controller.Red, publicKeyToken(owner), uuid.NewString())
+		require.NoError(t, err)
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+	})
+
+	t.Run("the token of the task", func(t *testing.T) {
+		app, controller, mock := newTestStream(t, other)
+		token, err := taskevents.NewStreamToken(context.Background(), controller.Red, publicKeyToken(owner), taskId)
+		require.NoError(t, err)
+		expectApp(mock, owner, owner.PublicKey, true)
+		expectTask(mock, taskId, owner.UID.String())
+
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/event-stream", res.Header.Get(fiber.HeaderContentType))
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("the token of a disabled app", func(t *testing.T) {
+		app, controller, mock := newTestStream(t, other)
+		token, err := taskevents.NewStreamToken(context.Background(), controller.Red, publicKeyToken(owner), taskId)
+		require.NoError(t, err)
+		expectApp(mock, owner, owner.PublicKey, false)
+
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("the token of a rotated public key", func(t *testing.T) {
+		app, controller, mock := newTestStream(t, other)
+		token, err := taskevents.NewStreamToken(context.Background(), controller.Red, publicKeyToken(owner), taskId)
+		require.NoError(t, err)
+		expectApp(mock, owner, uuid.New(), true)
+
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("the token of a revoked secret key", func(t *testing.T) {
+		app, controller, mock := newTestStream(t, other)
+		keyId := uuid.NewString()
+		token, err := taskevents.NewStreamToken(context.Background(), controller.Red, &taskevents.StreamToken{App: owner.UID.String(), Key: keyId}, taskId)
+		require.NoError(t, err)
+		expectApp(mock, owner, owner.PublicKey, true)
+		mock.ExpectQuery(regexp.QuoteMeta("FROM app_keys")).WithArgs(keyId, owner.UID.String()).WillReturnError(sql.ErrNoRows)
+
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("the token of an active secret key", func(t *testing.T) {
+		app, controller, mock := newTestStream(t, other)
+		keyId := uuid.NewString()
+		token, err := taskevents.NewStreamToken(context.Background(), controller.Red, &taskevents.StreamToken{App: owner.UID.String(), Key: keyId}, taskId)
+		require.NoError(t, err)
+		expectApp(mock, owner, owner.PublicKey, true)
+		mock.ExpectQuery(regexp.QuoteMeta("FROM app_keys")).WithArgs(keyId, owner.UID.String()).
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "app", "mode"}).AddRow(keyId, owner.UID.String(), blueprint.AppKeyModeLive))
+		expectTask(mock, taskId, owner.UID.String())
+
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path+"?token="+token, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func publicKeyToken(app *blueprint.DeveloperApp) *taskevents.StreamToken {
+	return &taskevents.StreamToken{App: app.UID.String(), PublicKey: app.PublicKey.String()}
+}
+
+func expectApp(mock sqlmock.Sqlmock, app *blueprint.DeveloperApp, publicKey uuid.UUID, authorized bool) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM apps")).WithArgs(app.UID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "public_key", "authorized"}).AddRow(app.UID.String(), publicKey.String(), authorized))
+}
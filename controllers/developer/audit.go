@@ -0,0 +1,104 @@
+package developer
+
+import (
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/services/audit"
+	"orchdio/util"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 100
+)
+
+// FetchOrgAuditLog returns the audit log of the org, the most recent entries first. The entries can be filtered by
+// actor, action (exact, or a prefix like "app.secret_key"), target_type, target_id and time (from and to, RFC3339) and
+// are paginated using limit and the next_cursor of the previous page.
+func (d *Controller) FetchOrgAuditLog(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	filter := &blueprint.AuditLogFilter{
+		Actor:      ctx.Query("actor"),
+		Action:     ctx.Query("action"),
+		TargetType: ctx.Query("target_type"),
+		TargetID:   ctx.Query("target_id"),
+		Limit:      ctx.QueryInt("limit", defaultAuditLogLimit),
+	}
+	if filter.Limit <= 0 || filter.Limit > maxAuditLogLimit {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid limit. Limit must be between 1 and 100")
+	}
+	if filter.Actor != "" && !util.IsValidUUID(filter.Actor) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid actor. Please pass the id of a user")
+	}
+	if cursor := ctx.Query("cursor"); cursor != "" {
+		id, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || id <= 0 {
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid cursor")
+		}
+		filter.Cursor = id
+	}
+	var err error
+	if filter.From, err = parseTimeQuery(ctx, "from"); err != nil {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid from. Please pass an RFC3339 timestamp")
+	}
+	if filter.To, err = parseTimeQuery(ctx, "to"); err != nil {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid to. Please pass an RFC3339 timestamp")
+	}
+
+	// one more entry is fetched to know if there is a next page.
+	limit := filter.Limit
+	filter.Limit++
+	database := db.NewDB{DB: d.DB}
+	entries, err := database.FetchAuditLog(orgId, filter)
+	if err != nil {
+		log.Printf("[controllers][FetchOrgAuditLog] developer -  error: could not fetch audit log of organization %s: %v\n", orgId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	page := &blueprint.AuditLogPage{Entries: entries}
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = strconv.FormatInt(page.Entries[limit-1].ID, 10)
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, page)
+}
+
+// recordAppChange records a change of the app in the audit log: the app before the change, and as it is after it
+// (none once deleted).
+func (d *Controller) recordAppChange(ctx *fiber.Ctx, action string, before *blueprint.DeveloperApp) {
+	var after *blueprint.DeveloperApp
+	if action != blueprint.AuditAppDelete {
+		database := db.NewDB{DB: d.DB}
+		app, err := database.FetchAppByAppId(before.UID.String())
+		if err != nil {
+			log.Printf("[controllers][recordAppChange] developer -  error: could not fetch app %s after %s: %v\n", before.UID, action, err)
+		}
+		after = app
+	}
+	audit.Record(ctx, d.DB, &audit.Entry{
+		Org:        before.Organization,
+		Action:     action,
+		TargetType: blueprint.AuditTargetApp,
+		TargetID:   before.UID.String(),
+		Before:     before,
+		After:      after,
+	})
+}
+
+// recordSecretKeyChange records a change of a secret key of the app in the audit log. For rotations, the key is the
+// rotated key and after the key that replaces it.
+func (d *Controller) recordSecretKeyChange(ctx *fiber.Ctx, action string, app *blueprint.DeveloperApp, keyId string, before, after *blueprint.AppKey) {
+	audit.Record(ctx, d.DB, &audit.Entry{
+		Org:        app.Organization,
+		Action:     action,
+		TargetType: blueprint.AuditTargetSecretKey,
+		TargetID:   keyId,
+		Before:     before,
+		After:      after,
+	})
+}
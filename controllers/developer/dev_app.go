@@ -7,16 +7,19 @@ import (
 	"fmt"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
+	"orchdio/internal/encryption"
 	"orchdio/services/applemusic"
+	"orchdio/services/audit"
 	"orchdio/services/deezer"
+	"orchdio/services/ratelimit"
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
 	"orchdio/services/ytmusic"
 	"orchdio/util"
 	svixwebhook "orchdio/webhooks/svix"
-	"os"
-	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -28,10 +31,15 @@ import (
 type Controller struct {
 	DB          *sqlx.DB
 	SvixService svixwebhook.SvixInterface
+	// Limiter reports the rate limits and quotas of the apps.
+	Limiter *ratelimit.Limiter
+	// Envelope encrypts and decrypts the integration credentials of the apps.
+	Envelope *encryption.Envelope
+	Config   *config.Config
 }
 
-func NewDeveloperController(db *sqlx.DB, webhookInterface svixwebhook.SvixInterface) *Controller {
-	return &Controller{DB: db, SvixService: webhookInterface}
+func NewDeveloperController(db *sqlx.DB, webhookInterface svixwebhook.SvixInterface, limiter *ratelimit.Limiter, envelope *encryption.Envelope, cfg *config.Config) *Controller {
+	return &Controller{DB: db, SvixService: webhookInterface, Limiter: limiter, Envelope: envelope, Config: cfg}
 }
 
 // CreateApp creates a new app for the developer. An app is a way to access the API, there can be multiple apps per developer.
@@ -52,7 +60,6 @@ func (d *Controller) CreateApp(ctx *fiber.Ctx) error {
 	}
 
 	pubKey := uuid.NewString()
-	secretKey := uuid.NewString()
 	verifySecret := uuid.NewString()
 	deezerState := string(util.GenerateShortID())
 
@@ -61,6 +68,12 @@ func (d *Controller) CreateApp(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "Organization is empty. Please pass a valid organization")
 	}
 
+	// the role of the developer is checked in the org of the route, so the app can only be created in that org.
+	if body.Organization != orgId {
+		log.Printf("[controllers][CreateApp] developer -  error: organization %s is not the organization of the route %s\n", body.Organization, orgId)
+		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "Organization does not match the organization ID. Please pass the same organization")
+	}
+
 	if body.IntegrationAppId == "" {
 		log.Printf("[controllers][CreateApp] developer -  error: app id is empty\n")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "Integration App ID is empty. Please pass a valid app id")
@@ -104,7 +117,7 @@ func (d *Controller) CreateApp(ctx *fiber.Ctx) error {
 	}
 
 	//encrypt the app data
-	encryptedAppData, err := util.Encrypt(serializedAppData, []byte(os.Getenv("ENCRYPTION_SECRET")))
+	encryptedAppData, err := d.Envelope.Encrypt(serializedAppData)
 	if err != nil {
 		log.Printf("[controllers][CreateApp] developer -  error: could not encrypt app data: %v\n", err)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not create developer app.")
@@ -112,7 +125,7 @@ func (d *Controller) CreateApp(ctx *fiber.Ctx) error {
 
 	// create new developer app
 	database := db.NewDB{DB: d.DB}
-	uid, err := database.CreateNewApp(body.Name, body.Description, body.RedirectURL, body.WebhookURL, pubKey, claims.DeveloperID, secretKey, verifySecret, body.Organization, deezerState)
+	uid, err := database.CreateNewApp(body.Name, body.Description, body.RedirectURL, body.WebhookURL, pubKey, claims.DeveloperID, verifySecret, body.Organization, deezerState)
 	if err != nil {
 		log.Printf("[controllers][CreateApp] developer -  error: could not create new developer app: %v\n", err)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not create developer app.")
@@ -136,15 +149,46 @@ func (d *Controller) CreateApp(ctx *fiber.Ctx) error {
 
 	updErr := database.UpdateWebhookAppID(string(uid), whResponse.Id)
 	if updErr != nil {
-		log.Printf("[controllers][CreateApp] developer -  error: could not update developer app: %v\n", err)
+		log.Printf("[controllers][CreateApp] developer -  error: could not update developer app: %v\n", updErr)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, updErr, "An internal error occurred and could not update developer app.")
 	}
 
 	err = database.UpdateIntegrationCredentials(encryptedAppData, string(uid), body.IntegrationPlatform, body.RedirectURL, body.WebhookURL, whResponse.Id)
+	if err != nil {
+		log.Printf("[controllers][CreateApp] developer -  error: could not save the integration credentials of the developer app: %v\n", err)
+		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not create developer app.")
+	}
+
+	// the secret keys are only returned here. Only their hash is stored so they can not be shown again.
+	secretKey, err := createDefaultAppKey(&database, string(uid), blueprint.AppKeyModeLive)
+	if err != nil {
+		log.Printf("[controllers][CreateApp] developer -  error: could not create secret key: %v\n", err)
+		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not create developer app.")
+	}
+	testSecretKey, err := createDefaultAppKey(&database, string(uid), blueprint.AppKeyModeTest)
+	if err != nil {
+		log.Printf("[controllers][CreateApp] developer -  error: could not create test secret key: %v\n", err)
+		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not create developer app.")
+	}
 	log.Printf("[controllers][CreateApp] developer -  new app created: %s\n", body.Name)
+	audit.Record(ctx, d.DB, &audit.Entry{
+		Org:        body.Organization,
+		Action:     blueprint.AuditAppCreate,
+		TargetType: blueprint.AuditTargetApp,
+		TargetID:   string(uid),
+		After: map[string]string{
+			"name":                 body.Name,
+			"description":          body.Description,
+			"redirect_url":         body.RedirectURL,
+			"webhook_url":          body.WebhookURL,
+			"integration_platform": body.IntegrationPlatform,
+		},
+	})
 
 	res := &blueprint.CreateNewDevAppResponse{
-		AppId: string(uid),
+		AppId:         string(uid),
+		SecretKey:     secretKey,
+		TestSecretKey: testSecretKey,
 	}
 	return util.SuccessResponse(ctx, fiber.StatusCreated, res)
 }
@@ -153,7 +197,8 @@ func (d *Controller) CreateApp(ctx *fiber.Ctx) error {
 func (d *Controller) UpdateApp(ctx *fiber.Ctx) error {
 	// TODO: implement transferring organizations
 	log.Printf("[controllers][UpdateApp] developer -  updating app\n")
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT) // THIS WILL MOST LIKELY CRASH
+	// the app is fetched, and the role of the user in its org checked, by the RequireAppRole middleware.
+	app := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
 
 	if ctx.Params("appId") == "" {
 		log.Printf("[controllers][UpdateApp] developer -  error: App ID is empty\n")
@@ -183,8 +228,8 @@ func (d *Controller) UpdateApp(ctx *fiber.Ctx) error {
 	}
 
 	// update the app
-	database := db.NewDB{DB: d.DB}
-	updatedApp, err := database.UpdateApp(ctx.Params("appId"), body.IntegrationPlatform, claims.DeveloperID, body)
+	database := db.NewDB{DB: d.DB, Envelope: d.Envelope}
+	updatedApp, err := database.UpdateApp(ctx.Params("appId"), body.IntegrationPlatform, app.Developer.String(), body)
 	if err != nil {
 		log.Printf("[controllers][UpdateApp] developer -  error: could not update app in Database: %v\n", err)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -192,6 +237,7 @@ func (d *Controller) UpdateApp(ctx *fiber.Ctx) error {
 		}
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "Could not update developer app")
 	}
+	d.recordAppChange(ctx, blueprint.AuditAppUpdate, app)
 
 	// todo: finalize webhook name format standard.
 	webhookName := fmt.Sprintf("%s-orchdio-%s", updatedApp.Name, updatedApp.UID.String())
@@ -245,7 +291,7 @@ func (d *Controller) UpdateApp(ctx *fiber.Ctx) error {
 	if epErr != nil {
 		log.Printf("[controllers][updateApp] developer -  error: could not get existing svix app: %v\n", epErr)
 		log.Printf("WH error: %v\n", epErr.Error())
-		if strings.Contains(epErr.Error(), "404") {
+		if isWebhookNotFound(epErr) {
 			// create a new endpoint.
 			_, cErr := d.SvixService.CreateEndpoint(updatedApp.WebhookAppID, endpointUniqID, updatedApp.WebhookURL)
 			if cErr != nil {
@@ -270,7 +316,8 @@ func (d *Controller) UpdateApp(ctx *fiber.Ctx) error {
 
 func (d *Controller) DeletePlatformIntegrationCredentials(ctx *fiber.Ctx) error {
 	log.Printf("[controllers][DeletePlatformIntegrationCredentials] developer -  deleting platform integration credentials\n")
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT) // THIS WILL MOST LIKELY CRASH
+	// the app is fetched, and the role of the user in its org checked, by the RequireAppRole middleware.
+	app := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
 
 	if ctx.Params("appId") == "" {
 		log.Printf("[controllers][DeletePlatformIntegrationCredentials] developer -  error: appId is empty\n")
@@ -284,20 +331,22 @@ func (d *Controller) DeletePlatformIntegrationCredentials(ctx *fiber.Ctx) error
 
 	// delete the app
 	database := db.NewDB{DB: d.DB}
-	err := database.DeletePlatformIntegrationCredentials(ctx.Params("appId"), ctx.Params("platform"), claims.DeveloperID)
+	err := database.DeletePlatformIntegrationCredentials(ctx.Params("appId"), ctx.Params("platform"), app.Developer.String())
 	if err != nil {
 		log.Printf("[controllers][DeletePlatformIntegrationCredentials] developer -  error: could not delete platform integration credentials in Database: %v\n", err)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "Could not delete platform integration credentials")
 	}
 
 	log.Printf("[controllers][DeletePlatformIntegrationCredentials] developer -  platform integration credentials deleted: %s\n", ctx.Params("appId"))
+	d.recordAppChange(ctx, blueprint.AuditAppCredentialsDelete, app)
 	return util.SuccessResponse(ctx, fiber.StatusOK, "Platform integration credentials deleted successfully")
 }
 
 func (d *Controller) DeleteApp(ctx *fiber.Ctx) error {
 	log.Printf("[controllers][DeleteApp] developer -  deleting app\n")
 
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT) // THIS WILL MOST LIKELY CRASH
+	// the app is fetched, and the role of the user in its org checked, by the RequireAppRole middleware.
+	app := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
 	if ctx.Params("appId") == "" {
 		log.Printf("[controllers][DeleteApp] developer -  error: appId is empty\n")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "App ID is empty. Please pass a valid app ID")
@@ -305,12 +354,13 @@ func (d *Controller) DeleteApp(ctx *fiber.Ctx) error {
 
 	// delete the app
 	database := db.NewDB{DB: d.DB}
-	err := database.DeleteApp(ctx.Params("appId"), claims.DeveloperID)
+	err := database.DeleteApp(ctx.Params("appId"), app.Developer.String())
 	if err != nil {
 		log.Printf("[controllers][DeleteApp] developer -  error: could not delete app in Database: %v\n", err)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occured")
 	}
 	log.Printf("[controllers][DeleteApp] developer -  app deleted: %s\n", ctx.Params("appId"))
+	d.recordAppChange(ctx, blueprint.AuditAppDelete, app)
 	return util.SuccessResponse(ctx, fiber.StatusOK, "App deleted successfully")
 }
 
@@ -345,7 +395,7 @@ func (d *Controller) FetchApp(ctx *fiber.Ctx) error {
 	for k, v := range credK {
 		log.Printf("[controllers][FetchApp] developer -  decrypting %s credentials\n", k)
 		if len(v) > 0 {
-			outBytes, decErr := util.Decrypt(v, []byte(os.Getenv("ENCRYPTION_SECRET")))
+			outBytes, decErr := d.Envelope.Decrypt(v)
 			if decErr != nil {
 				log.Printf("[controllers][FetchApp] developer -  error: could not decrypt %s credentials: %v\n", k, decErr)
 				return util.ErrorResponse(ctx, fiber.StatusInternalServerError, decErr, "An internal error occurred. Could not decrypt credentials")
@@ -362,14 +412,17 @@ func (d *Controller) FetchApp(ctx *fiber.Ctx) error {
 		}
 	}
 
-	// create a new app portal that lives long enough
-	svixWebhook := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	// get app portal
-	portalAccess, err := svixWebhook.CreateAppPortal(app.WebhookAppID)
+	// create a new app portal that lives long enough. the app portal is only available when webhooks are sent through svix.
+	var portalURL string
+	if svixWebhook, ok := d.SvixService.(*svixwebhook.SvixWebhook); ok {
+		// get app portal
+		portalAccess, err := svixWebhook.CreateAppPortal(app.WebhookAppID)
 
-	if err != nil {
-		log.Print("Error fetching app portal...", err)
-		return util.SuccessResponse(ctx, fiber.StatusInternalServerError, "Could not fetch Dev app due to internal errors")
+		if err != nil {
+			log.Print("Error fetching app portal...", err)
+			return util.SuccessResponse(ctx, fiber.StatusInternalServerError, "Could not fetch Dev app due to internal errors")
+		}
+		portalURL = portalAccess.Url
 	}
 
 	info := &blueprint.AppInfo{
@@ -382,7 +435,7 @@ func (d *Controller) FetchApp(ctx *fiber.Ctx) error {
 		Authorized:       app.Authorized,
 		Credentials:      creds,
 		DeezerState:      app.DeezerState,
-		WebhookPortalURL: portalAccess.Url,
+		WebhookPortalURL: portalURL,
 	}
 	return util.SuccessResponse(ctx, fiber.StatusOK, info)
 }
@@ -390,7 +443,8 @@ func (d *Controller) FetchApp(ctx *fiber.Ctx) error {
 func (d *Controller) DisableApp(ctx *fiber.Ctx) error {
 	log.Printf("[controllers][DisableApp] developer -  disabling app\n")
 	appId := ctx.Params("appId")
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT) // THIS WILL MOST LIKELY CRASH
+	// the app is fetched, and the role of the user in its org checked, by the RequireAppRole middleware.
+	app := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
 	if appId == "" {
 		log.Printf("[controllers][DisableApp] developer -  error: appId is empty\n")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "appId is empty")
@@ -398,19 +452,21 @@ func (d *Controller) DisableApp(ctx *fiber.Ctx) error {
 
 	// disable the app
 	database := db.NewDB{DB: d.DB}
-	err := database.DisableApp(appId, claims.DeveloperID)
+	err := database.DisableApp(appId, app.Developer.String())
 	if err != nil {
 		log.Printf("[controllers][DisableApp] developer -  error: could not disable app in Database: %v\n", err)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred.")
 	}
 
 	log.Printf("[controllers][DisableApp] developer -  app disabled: %s\n", appId)
+	d.recordAppChange(ctx, blueprint.AuditAppDisable, app)
 	return util.SuccessResponse(ctx, fiber.StatusOK, "App disabled successfully")
 }
 
 func (d *Controller) EnableApp(ctx *fiber.Ctx) error {
 	log.Printf("[controllers][EnableApp] developer -  enabling app\n")
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT) // THIS WILL MOST LIKELY CRASH
+	// the app is fetched, and the role of the user in its org checked, by the RequireAppRole middleware.
+	app := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
 	appId := ctx.Params("appId")
 	if appId == "" {
 		log.Printf("[controllers][EnableApp] developer -  error: appId is empty\n")
@@ -419,19 +475,21 @@ func (d *Controller) EnableApp(ctx *fiber.Ctx) error {
 
 	// enable the app
 	database := db.NewDB{DB: d.DB}
-	err := database.EnableApp(appId, claims.DeveloperID)
+	err := database.EnableApp(appId, app.Developer.String())
 	if err != nil {
 		log.Printf("[controllers][EnableApp] developer -  error: could not enable app in Database: %v\n", err)
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred")
 	}
 
 	log.Printf("[controllers][EnableApp] developer -  app enabled: %s\n", appId)
+	d.recordAppChange(ctx, blueprint.AuditAppEnable, app)
 	return util.SuccessResponse(ctx, fiber.StatusOK, "App enabled successfully")
 }
 
 func (d *Controller) FetchKeys(ctx *fiber.Ctx) error {
 	log.Printf("[controllers][FetchKeys] developer -  fetching keys\n")
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT) // THIS WILL MOST LIKELY CRASH
+	// the app is fetched, and the role of the user in its org checked, by the RequireAppRole middleware.
+	app := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
 
 	appId := ctx.Params("appId")
 	if appId == "" {
@@ -441,7 +499,7 @@ func (d *Controller) FetchKeys(ctx *fiber.Ctx) error {
 
 	// fetch the app
 	database := db.NewDB{DB: d.DB}
-	keys, err := database.FetchAppKeys(appId, claims.DeveloperID)
+	keys, err := database.FetchAppKeys(appId, app.Developer.String())
 	if err != nil {
 		log.Printf("[controllers][FetchKeys] developer -  error: could not fetch app keys from the Database: %v\n", err)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -455,13 +513,11 @@ func (d *Controller) FetchKeys(ctx *fiber.Ctx) error {
 func (d *Controller) FetchAllDeveloperApps(ctx *fiber.Ctx) error {
 	log.Printf("[controllers][FetchAllDeveloperApps] developer -  fetching all apps\n")
 
-	// get the developer from the context
-	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT)
 	orgID := ctx.Params("orgId")
 
-	// fetch the apps
+	// fetch the apps of the org, whichever member created them
 	database := db.NewDB{DB: d.DB}
-	apps, err := database.FetchApps(claims.DeveloperID, orgID)
+	apps, err := database.FetchApps(orgID)
 	if err != nil {
 		log.Printf("[controllers][FetchAllDeveloperApps] developer -  error: could not fetch apps in Database: %v\n", err)
 		if errors.Is(err, sql.ErrNoRows) {
@@ -482,6 +538,8 @@ func (d *Controller) RevokeAppKeys(ctx *fiber.Ctx) error {
 	// todo: move this into a proper type
 	reqBody := struct {
 		KeyType string `json:"key_type"`
+		// GracePeriodSeconds is how long the current secret keys keep working, when revoking secret keys.
+		GracePeriodSeconds *int `json:"grace_period_seconds"`
 	}{}
 
 	if err := ctx.BodyParser(&reqBody); err != nil {
@@ -497,14 +555,25 @@ func (d *Controller) RevokeAppKeys(ctx *fiber.Ctx) error {
 	database := db.NewDB{DB: d.DB}
 	updatedKeys := &blueprint.AppKeys{}
 
+	// the secret keys are rotated: a new key is issued and the current keys keep working for the grace period, so that
+	// they can be replaced without downtime.
 	if reqBody.KeyType == blueprint.SecretKeyType {
-		privateKey := uuid.NewString()
-		err := database.RevokeSecretKey(appId, privateKey)
+		gracePeriod, gErr := secretKeyGracePeriod(reqBody.GracePeriodSeconds, d.Config.Auth.SecretKeyGracePeriod)
+		if gErr != nil {
+			return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", gErr.Error())
+		}
+		privateKey, err := util.GenerateSecretKey(blueprint.AppKeyModeLive)
+		if err != nil {
+			log.Printf("-  error: could not generate secret key: %v\n", err)
+			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not revoke secret key")
+		}
+		appKey, err := database.RotateAppSecretKeys(appId, blueprint.DefaultAppKeyName, util.SecretKeyHint(privateKey), util.HashSecretKey(privateKey), time.Now().Add(gracePeriod))
 		if err != nil {
 			log.Printf("-  error: could not revoke secret key in Database: %v\n", err)
 			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred and could not revoke secret key")
 		}
 		updatedKeys.SecretKey = privateKey
+		d.recordSecretKeyChange(ctx, blueprint.AuditAppKeysRevoke, ctx.Locals("developer_app").(*blueprint.DeveloperApp), appKey.UID.String(), nil, appKey)
 	}
 
 	if reqBody.KeyType == blueprint.VerifyKeyType {
@@ -537,5 +606,8 @@ func (d *Controller) RevokeAppKeys(ctx *fiber.Ctx) error {
 	}
 
 	log.Printf("-  app keys revoked and new credentials generated: %s\n", appId)
+	if reqBody.KeyType != blueprint.SecretKeyType {
+		d.recordAppChange(ctx, blueprint.AuditAppKeysRevoke, ctx.Locals("developer_app").(*blueprint.DeveloperApp))
+	}
 	return util.SuccessResponse(ctx, fiber.StatusOK, updatedKeys)
 }
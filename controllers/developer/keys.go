@@ -0,0 +1,190 @@
+package developer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/util"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
+)
+
+// maxSecretKeyGracePeriod is the longest a rotated secret key can keep working.
+const maxSecretKeyGracePeriod = 30 * 24 * time.Hour
+
+// FetchAppSecretKeys fetches the secret keys of an app. The keys themselves are never returned, only their hints.
+func (d *Controller) FetchAppSecretKeys(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	database := db.NewDB{DB: d.DB}
+	keys, err := database.FetchAppSecretKeys(app.UID.String())
+	if err != nil {
+		log.Printf("[controllers][FetchAppSecretKeys] developer -  error: could not fetch secret keys: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, keys)
+}
+
+// CreateAppSecretKey creates a new secret key for an app, optionally restricted to scopes. The key is only returned in
+// this response.
+func (d *Controller) CreateAppSecretKey(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	var body blueprint.CreateAppKeyData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controllers][CreateAppSecretKey] developer -  error: could not deserialize request body: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not deserialize request body")
+	}
+
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Name is empty. Please pass a name for the key")
+	}
+	if body.ExpiresAt != nil && !body.ExpiresAt.After(time.Now()) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid expires_at. It must be in the future")
+	}
+	if invalid := lo.Without(body.Scopes, blueprint.AppKeyScopes...); len(invalid) > 0 {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", fmt.Sprintf("Invalid scopes: %s. Valid scopes are %s", strings.Join(invalid, ", "), strings.Join(blueprint.AppKeyScopes, ", ")))
+	}
+
+	if body.Mode == "" {
+		body.Mode = blueprint.AppKeyModeLive
+	}
+	if body.Mode != blueprint.AppKeyModeLive && body.Mode != blueprint.AppKeyModeTest {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid mode. Please pass live or test")
+	}
+
+	key, err := util.GenerateSecretKey(body.Mode)
+	if err != nil {
+		log.Printf("[controllers][CreateAppSecretKey] developer -  error: could not generate secret key: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	database := db.NewDB{DB: d.DB}
+	appKey, err := database.CreateAppKey(app.UID.String(), body.Name, util.SecretKeyHint(key), util.HashSecretKey(key), body.Mode, body.ExpiresAt, lo.Uniq(body.Scopes))
+	if err != nil {
+		log.Printf("[controllers][CreateAppSecretKey] developer -  error: could not create secret key: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	d.recordSecretKeyChange(ctx, blueprint.AuditSecretKeyCreate, app, appKey.UID.String(), nil, appKey)
+	return util.SuccessResponse(ctx, http.StatusCreated, &blueprint.NewAppKey{AppKey: *appKey, Key: key})
+}
+
+// RevokeAppSecretKey revokes a secret key of an app. The key stops working right away.
+func (d *Controller) RevokeAppSecretKey(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	keyId := ctx.Params("keyId")
+	if !util.IsValidUUID(keyId) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid key id")
+	}
+
+	database := db.NewDB{DB: d.DB}
+	key, err := database.RevokeAppSecretKey(keyId, app.UID.String())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Key not found or already revoked")
+		}
+		log.Printf("[controllers][RevokeAppSecretKey] developer -  error: could not revoke secret key: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	activeKey := *key
+	activeKey.RevokedAt = nil
+	d.recordSecretKeyChange(ctx, blueprint.AuditSecretKeyRevoke, app, keyId, &activeKey, key)
+	return util.SuccessResponse(ctx, http.StatusOK, key)
+}
+
+// RotateAppSecretKey replaces a secret key of an app with a new key of the same name and scopes. The replaced key keeps
+// working for the grace period so that it can be swapped without downtime. The new key is only returned in this
+// response.
+func (d *Controller) RotateAppSecretKey(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	keyId := ctx.Params("keyId")
+	if !util.IsValidUUID(keyId) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid key id")
+	}
+
+	var body blueprint.RotateAppKeyData
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&body); err != nil {
+			log.Printf("[controllers][RotateAppSecretKey] developer -  error: could not deserialize request body: %v\n", err)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not deserialize request body")
+		}
+	}
+
+	gracePeriod, err := secretKeyGracePeriod(body.GracePeriodSeconds, d.Config.Auth.SecretKeyGracePeriod)
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", err.Error())
+	}
+
+	// the new key is of the mode of the key it replaces.
+	database := db.NewDB{DB: d.DB}
+	previous, err := database.FetchAppSecretKey(keyId, app.UID.String())
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Key not found. Only active keys can be rotated")
+		}
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	key, err := util.GenerateSecretKey(previous.Mode)
+	if err != nil {
+		log.Printf("[controllers][RotateAppSecretKey] developer -  error: could not generate secret key: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	appKey, err := database.RotateAppSecretKey(keyId, app.UID.String(), util.SecretKeyHint(key), util.HashSecretKey(key), time.Now().Add(gracePeriod))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Key not found. Only active keys can be rotated")
+		}
+		log.Printf("[controllers][RotateAppSecretKey] developer -  error: could not rotate secret key: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	d.recordSecretKeyChange(ctx, blueprint.AuditSecretKeyRotate, app, keyId, nil, appKey)
+	return util.SuccessResponse(ctx, http.StatusCreated, &blueprint.NewAppKey{AppKey: *appKey, Key: key})
+}
+
+// createDefaultAppKey creates the default, unrestricted, secret key of the mode for a new app and returns it.
+func createDefaultAppKey(database *db.NewDB, appId, mode string) (string, error) {
+	key, err := util.GenerateSecretKey(mode)
+	if err != nil {
+		return "", err
+	}
+	if _, err = database.CreateAppKey(appId, blueprint.DefaultAppKeyName, util.SecretKeyHint(key), util.HashSecretKey(key), mode, nil, nil); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+// secretKeyGracePeriod returns how long rotated secret keys keep working. The requested grace period is used if passed,
+// else the default grace period of the configuration (see config.Auth).
+func secretKeyGracePeriod(requestedSeconds *int, defaultGracePeriod time.Duration) (time.Duration, error) {
+	if requestedSeconds != nil {
+		gracePeriod := time.Duration(*requestedSeconds) * time.Second
+		if gracePeriod < 0 || gracePeriod > maxSecretKeyGracePeriod {
+			return 0, fmt.Errorf("invalid grace_period_seconds. It must be between 0 and %d", int(maxSecretKeyGracePeriod.Seconds()))
+		}
+		return gracePeriod, nil
+	}
+	return min(defaultGracePeriod, maxSecretKeyGracePeriod), nil
+}
@@ -0,0 +1,29 @@
+package developer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretKeyGracePeriod(t *testing.T) {
+	// without a requested grace period, the default of the configuration is used, up to the max.
+	gracePeriod, err := secretKeyGracePeriod(nil, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, gracePeriod)
+
+	gracePeriod, err = secretKeyGracePeriod(nil, 365*24*time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, maxSecretKeyGracePeriod, gracePeriod)
+
+	requested := 60
+	gracePeriod, err = secretKeyGracePeriod(&requested, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, gracePeriod)
+
+	requested = -1
+	_, err = secretKeyGracePeriod(&requested, time.Hour)
+	assert.Error(t, err)
+}
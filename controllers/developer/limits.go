@@ -0,0 +1,35 @@
+package developer
+
+import (
+	"log"
+	"net/http"
+	"orchdio/services/ratelimit"
+	"orchdio/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// AppRateLimits are the plan of an app and how much of its budgets is left. Budgets with a limit of 0 are unlimited.
+type AppRateLimits struct {
+	Plan    string             `json:"plan"`
+	Budgets []ratelimit.Result `json:"budgets"`
+}
+
+// FetchAppRateLimits fetches the plan of an app and how much of its rate limits and quotas is left.
+func (d *Controller) FetchAppRateLimits(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	plan := app.Plan
+	if _, ok := d.Limiter.Plans[plan]; !ok {
+		plan = ratelimit.PlanFree
+	}
+	budgets, err := d.Limiter.Usage(ctx.Context(), app.UID.String(), plan)
+	if err != nil {
+		log.Printf("[controllers][FetchAppRateLimits] developer -  error: could not fetch rate limits of app %s: %v\n", app.UID.String(), err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, &AppRateLimits{Plan: plan, Budgets: budgets})
+}
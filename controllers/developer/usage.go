@@ -0,0 +1,107 @@
+package developer
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/util"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxUsageReportDays is the longest period a usage report can cover.
+const maxUsageReportDays = 366
+
+// FetchOrgUsage fetches the usage of all the apps of an organization, by app, event, platform and endpoint, for each day or
+// month between from and to (YYYY-MM-DD, inclusive; the current month by default). It is exported as CSV with
+// format=csv.
+func (d *Controller) FetchOrgUsage(ctx *fiber.Ctx) error {
+	orgId := ctx.Params("orgId")
+	if !util.IsValidUUID(orgId) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid organization id")
+	}
+
+	now := time.Now().UTC()
+	from, err := parseDateQuery(ctx, "from", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid from. Please pass a date as YYYY-MM-DD")
+	}
+	to, err := parseDateQuery(ctx, "to", now)
+	if err != nil {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid to. Please pass a date as YYYY-MM-DD")
+	}
+	if to.Before(from) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid period. to must not be before from")
+	}
+	if to.Sub(from) > maxUsageReportDays*24*time.Hour {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", fmt.Sprintf("Invalid period. It can be %d days at most", maxUsageReportDays))
+	}
+
+	granularity := ctx.Query("granularity", blueprint.UsageGranularityDay)
+	if granularity != blueprint.UsageGranularityDay && granularity != blueprint.UsageGranularityMonth {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid granularity. Please pass day or month")
+	}
+
+	database := db.NewDB{DB: d.DB}
+	usage, err := database.FetchOrgUsage(orgId, from, to, granularity)
+	if err != nil {
+		log.Printf("[controllers][FetchOrgUsage] developer -  error: could not fetch usage of organization %s: %v\n", orgId, err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	report := &blueprint.UsageReport{
+		From:        from.Format(time.DateOnly),
+		To:          to.Format(time.DateOnly),
+		Granularity: granularity,
+		Usage:       usage,
+	}
+	if ctx.Query("format") == "csv" {
+		body, cErr := usageCSV(report.Usage)
+		if cErr != nil {
+			log.Printf("[controllers][FetchOrgUsage] developer -  error: could not write usage CSV: %v\n", cErr)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+		}
+		ctx.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+		ctx.Set(fiber.HeaderContentDisposition, fmt.Sprintf("attachment; filename=\"usage-%s-%s.csv\"", report.From, report.To))
+		return ctx.Status(http.StatusOK).Send(body)
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, report)
+}
+
+// usageCSV writes the usage as CSV, with a header row.
+func usageCSV(usage []blueprint.AppUsage) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	_ = w.Write([]string{"period", "app_id", "app_name", "event", "platform", "endpoint", "count", "tracks"})
+	for _, u := range usage {
+		_ = w.Write([]string{csvCell(u.Period), csvCell(u.App), csvCell(u.AppName), csvCell(u.Event), csvCell(u.Platform),
+			csvCell(u.Endpoint), strconv.FormatInt(u.Count, 10), strconv.FormatInt(u.Tracks, 10)})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// csvCell escapes the text cells that spreadsheets would run as formulas (e.g. an app named "=HYPERLINK(...)") by
+// prefixing them with a quote.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsRune("=+-@\t\r", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
+
+// parseDateQuery parses the YYYY-MM-DD date in the query param. It returns def if the param is not passed.
+func parseDateQuery(ctx *fiber.Ctx, key string, def time.Time) (time.Time, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return time.Date(def.Year(), def.Month(), def.Day(), 0, 0, 0, 0, time.UTC), nil
+	}
+	return time.Parse(time.DateOnly, value)
+}
@@ -0,0 +1,27 @@
+package developer
+
+import (
+	"orchdio/blueprint"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUsageCSV(t *testing.T) {
+	body, err := usageCSV([]blueprint.AppUsage{
+		{Period: "2024-06", App: "app-id", AppName: "Zoove, web", Event: blueprint.UsagePlaylistConversion, Platform: "spotify", Count: 3, Tracks: 120},
+		{Period: "2024-06", App: "app-id", AppName: "Zoove, web", Event: blueprint.UsageTrackConversion, Platform: "deezer", Endpoint: "/v1/track/convert", Count: 10},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "period,app_id,app_name,event,platform,endpoint,count,tracks\n"+
+		"2024-06,app-id,\"Zoove, web\",playlist_conversion,spotify,,3,120\n"+
+		"2024-06,app-id,\"Zoove, web\",track_conversion,deezer,/v1/track/convert,10,0\n", string(body))
+
+	// the cells that would be run as formulas are escaped.
+	body, err = usageCSV([]blueprint.AppUsage{
+		{Period: "2024-06", App: "app-id", AppName: "=HYPERLINK(\"https://evil.example\")", Event: "+cmd", Platform: "-1", Endpoint: "@SUM(A1)", Count: 1},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "period,app_id,app_name,event,platform,endpoint,count,tracks\n"+
+		"2024-06,app-id,\"'=HYPERLINK(\"\"https://evil.example\"\")\",'+cmd,'-1,'@SUM(A1),1,0\n", string(body))
+}
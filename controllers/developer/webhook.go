@@ -0,0 +1,375 @@
+package developer
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/util"
+	"orchdio/webhooks/events"
+	orchdiowebhook "orchdio/webhooks/orchdio"
+	svixwebhook "orchdio/webhooks/svix"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
+	svix "github.com/svix/svix-webhooks/go"
+)
+
+const (
+	defaultWebhookMessagesLimit = 20
+	maxWebhookMessagesLimit     = 100
+)
+
+// FetchWebhookEventTypes returns the catalogue of the webhook event types that can be sent to apps.
+func (d *Controller) FetchWebhookEventTypes(ctx *fiber.Ctx) error {
+	return util.SuccessResponse(ctx, http.StatusOK, events.Catalogue)
+}
+
+// FetchWebhookEventTypeSchema returns the JSON Schema of a webhook event type.
+func (d *Controller) FetchWebhookEventTypeSchema(ctx *fiber.Ctx) error {
+	eventType := ctx.Params("eventType")
+	if !events.IsValid(eventType) {
+		log.Printf("[controllers][FetchWebhookEventTypeSchema] developer -  error: unknown event type %s\n", eventType)
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Unknown event type")
+	}
+
+	schema, err := events.Schema(eventType)
+	if err != nil {
+		log.Printf("[controllers][FetchWebhookEventTypeSchema] developer -  error: could not read event type schema: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, schema)
+}
+
+// FetchAppWebhookEventTypes returns the event types the webhook endpoint of an app is subscribed to. An empty list
+// means the endpoint is subscribed to all event types.
+func (d *Controller) FetchAppWebhookEventTypes(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	endpoint, err := d.SvixService.GetEndpoint(app.WebhookAppID, svixwebhook.FormatSvixEndpointUID(app.UID.String()))
+	if err != nil {
+		if isWebhookNotFound(err) {
+			log.Printf("[controllers][FetchAppWebhookEventTypes] developer -  error: app has no webhook endpoint\n")
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook url. Please add a webhook url first")
+		}
+		log.Printf("[controllers][FetchAppWebhookEventTypes] developer -  error: could not fetch webhook endpoint: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	eventTypes := make([]string, 0)
+	if endpoint != nil && endpoint.FilterTypes != nil {
+		eventTypes = endpoint.FilterTypes
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, map[string][]string{"event_types": eventTypes})
+}
+
+// UpdateAppWebhookEventTypes updates the event types the webhook endpoint of an app is subscribed to. Passing an
+// empty list subscribes the endpoint to all event types.
+func (d *Controller) UpdateAppWebhookEventTypes(ctx *fiber.Ctx) error {
+	var body blueprint.UpdateWebhookEventTypesData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controllers][UpdateAppWebhookEventTypes] developer -  error: could not deserialize request body: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not deserialize request body")
+	}
+
+	eventTypes := lo.Uniq(body.EventTypes)
+	for _, eventType := range eventTypes {
+		if !events.IsValid(eventType) {
+			log.Printf("[controllers][UpdateAppWebhookEventTypes] developer -  error: unknown event type %s\n", eventType)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Unknown event type: "+eventType)
+		}
+	}
+
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	endpoint, err := d.SvixService.UpdateEndpointEventTypes(app.WebhookAppID, svixwebhook.FormatSvixEndpointUID(app.UID.String()), eventTypes)
+	if err != nil {
+		if isWebhookNotFound(err) {
+			log.Printf("[controllers][UpdateAppWebhookEventTypes] developer -  error: app has no webhook endpoint\n")
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook url. Please add a webhook url first")
+		}
+		log.Printf("[controllers][UpdateAppWebhookEventTypes] developer -  error: could not update webhook endpoint: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	updatedEventTypes := make([]string, 0)
+	if endpoint != nil && endpoint.FilterTypes != nil {
+		updatedEventTypes = endpoint.FilterTypes
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, map[string][]string{"event_types": updatedEventTypes})
+}
+
+// FetchAppWebhookMessages lists the recent webhook messages sent to an app, most recent first. The messages can be
+// filtered by event type (comma separated) and by time (before and after, RFC3339) and are paginated using limit and
+// the iterator returned with the previous page.
+func (d *Controller) FetchAppWebhookMessages(ctx *fiber.Ctx) error {
+	filter := blueprint.WebhookMessageFilter{
+		Limit:    ctx.QueryInt("limit", defaultWebhookMessagesLimit),
+		Iterator: ctx.Query("iterator"),
+	}
+
+	if filter.Limit <= 0 || filter.Limit > maxWebhookMessagesLimit {
+		log.Printf("[controllers][FetchAppWebhookMessages] developer -  error: invalid limit %d\n", filter.Limit)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid limit. Limit must be between 1 and 100")
+	}
+
+	if eventTypes := ctx.Query("event_type"); eventTypes != "" {
+		filter.EventTypes = strings.Split(eventTypes, ",")
+		for _, eventType := range filter.EventTypes {
+			if !events.IsValid(eventType) {
+				log.Printf("[controllers][FetchAppWebhookMessages] developer -  error: unknown event type %s\n", eventType)
+				return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Unknown event type: "+eventType)
+			}
+		}
+	}
+
+	var err error
+	filter.Before, err = parseTimeQuery(ctx, "before")
+	if err != nil {
+		log.Printf("[controllers][FetchAppWebhookMessages] developer -  error: invalid before filter: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid before. Before must be a RFC3339 timestamp")
+	}
+	filter.After, err = parseTimeQuery(ctx, "after")
+	if err != nil {
+		log.Printf("[controllers][FetchAppWebhookMessages] developer -  error: invalid after filter: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid after. After must be a RFC3339 timestamp")
+	}
+
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+	if app.WebhookAppID == "" {
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook configured")
+	}
+
+	// the iterators of the built-in sender are message ids. svix has its own format.
+	if _, builtIn := d.SvixService.(*orchdiowebhook.OrchdioWebhook); builtIn && filter.Iterator != "" && !util.IsValidUUID(filter.Iterator) {
+		log.Printf("[controllers][FetchAppWebhookMessages] developer -  error: invalid iterator %s\n", filter.Iterator)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid iterator")
+	}
+
+	messages, err := d.SvixService.ListMessages(app.WebhookAppID, &filter)
+	if err != nil {
+		if errors.Is(err, orchdiowebhook.ErrInvalidIterator) {
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid iterator")
+		}
+		log.Printf("[controllers][FetchAppWebhookMessages] developer -  error: could not list webhook messages: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, messages)
+}
+
+// FetchAppWebhookMessageAttempts lists the delivery attempts of a webhook message sent to an app, most recent first.
+func (d *Controller) FetchAppWebhookMessageAttempts(ctx *fiber.Ctx) error {
+	messageId := ctx.Params("messageId")
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+	if app.WebhookAppID == "" {
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook configured")
+	}
+
+	attempts, err := d.SvixService.ListMessageAttempts(app.WebhookAppID, messageId)
+	if err != nil {
+		if isWebhookNotFound(err) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Webhook message not found")
+		}
+		log.Printf("[controllers][FetchAppWebhookMessageAttempts] developer -  error: could not list webhook message attempts: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, attempts)
+}
+
+// ReplayAppWebhookMessage sends a webhook message to the webhook endpoint of the app again.
+func (d *Controller) ReplayAppWebhookMessage(ctx *fiber.Ctx) error {
+	messageId := ctx.Params("messageId")
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+	if app.WebhookAppID == "" {
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook configured")
+	}
+
+	err := d.SvixService.ResendMessage(app.WebhookAppID, messageId, svixwebhook.FormatSvixEndpointUID(app.UID.String()))
+	if err != nil {
+		if isWebhookNotFound(err) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Webhook message not found")
+		}
+		log.Printf("[controllers][ReplayAppWebhookMessage] developer -  error: could not resend webhook message: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusAccepted, nil)
+}
+
+// ReplayAppWebhookMessages sends the webhook messages that failed to be delivered to the webhook endpoint of the app
+// within a time window again. Messages that were delivered, even after failing initially, are not sent again.
+func (d *Controller) ReplayAppWebhookMessages(ctx *fiber.Ctx) error {
+	var body blueprint.ReplayWebhookMessagesData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controllers][ReplayAppWebhookMessages] developer -  error: could not deserialize request body: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not deserialize request body")
+	}
+
+	if body.Since.IsZero() {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Since is required. Please pass a RFC3339 timestamp")
+	}
+	if body.Until != nil && !body.Until.After(body.Since) {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid time window. Until must be after since")
+	}
+
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+	if app.WebhookAppID == "" {
+		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook configured")
+	}
+
+	recoverOut, err := d.SvixService.RecoverFailedMessages(app.WebhookAppID, svixwebhook.FormatSvixEndpointUID(app.UID.String()), body.Since, body.Until)
+	if err != nil {
+		if isWebhookNotFound(err) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook url. Please add a webhook url first")
+		}
+		log.Printf("[controllers][ReplayAppWebhookMessages] developer -  error: could not recover failed webhook messages: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusAccepted, recoverOut)
+}
+
+// FireTestWebhookEvent sends a test event of the event type passed to the webhook endpoint of the app. The event
+// has example data and "test" is set to true in the envelope.
+func (d *Controller) FireTestWebhookEvent(ctx *fiber.Ctx) error {
+	var body blueprint.TestWebhookEventData
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controllers][FireTestWebhookEvent] developer -  error: could not deserialize request body: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not deserialize request body")
+	}
+
+	if !events.IsValid(body.EventType) {
+		log.Printf("[controllers][FireTestWebhookEvent] developer -  error: unknown event type %s\n", body.EventType)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Unknown event type")
+	}
+
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+
+	_, err := d.SvixService.GetEndpoint(app.WebhookAppID, svixwebhook.FormatSvixEndpointUID(app.UID.String()))
+	if err != nil {
+		if isWebhookNotFound(err) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App has no webhook url. Please add a webhook url first")
+		}
+		log.Printf("[controllers][FireTestWebhookEvent] developer -  error: could not fetch webhook endpoint: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	data, err := events.Example(body.EventType)
+	if err != nil {
+		log.Printf("[controllers][FireTestWebhookEvent] developer -  error: no example for event type: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	event := blueprint.NewWebhookEvent(body.EventType, app.UID.String(), "", data)
+	event.Test = true
+	message, err := d.SvixService.SendEvent(app.WebhookAppID, body.EventType, event)
+	if err != nil {
+		log.Printf("[controllers][FireTestWebhookEvent] developer -  error: could not send test event: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusAccepted, message)
+}
+
+// FetchAppWebhookBatchSettings returns the batching of the playlist conversion track webhook events of an app. 0 means
+// the server default is used.
+func (d *Controller) FetchAppWebhookBatchSettings(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, blueprint.WebhookBatchSettings{
+		Size:       app.WebhookBatchSize,
+		IntervalMs: app.WebhookBatchIntervalMs,
+	})
+}
+
+// UpdateAppWebhookBatchSettings updates the batching of the playlist conversion track webhook events of an app. Passing
+// 0 for either setting resets it to the server default.
+func (d *Controller) UpdateAppWebhookBatchSettings(ctx *fiber.Ctx) error {
+	app, rErr := d.fetchDeveloperApp(ctx)
+	if app == nil {
+		return rErr
+	}
+	var body blueprint.WebhookBatchSettings
+	if err := ctx.BodyParser(&body); err != nil {
+		log.Printf("[controllers][UpdateAppWebhookBatchSettings] developer -  error: could not deserialize request body: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Could not deserialize request body")
+	}
+
+	if body.Size < 0 || body.Size > blueprint.MaxWebhookBatchSize {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", fmt.Sprintf("Invalid size. Size must be between 0 and %d", blueprint.MaxWebhookBatchSize))
+	}
+	if body.IntervalMs < 0 || body.IntervalMs > blueprint.MaxWebhookBatchIntervalMs {
+		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", fmt.Sprintf("Invalid interval. Interval must be between 0 and %d", blueprint.MaxWebhookBatchIntervalMs))
+	}
+
+	database := db.NewDB{DB: d.DB}
+	err := database.UpdateAppWebhookBatchSettings(app.UID.String(), app.Developer.String(), body.Size, body.IntervalMs)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App not found")
+		}
+		log.Printf("[controllers][UpdateAppWebhookBatchSettings] developer -  error: could not update webhook batch settings: %v\n", err)
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+	return util.SuccessResponse(ctx, http.StatusOK, body)
+}
+
+// fetchDeveloperApp returns the app in the request params, fetched by the RequireAppRole middleware once it made sure
+// the developer making the request has the role for it in the org of the app. If there is no app, the error response
+// is written and a nil app is returned alongside the result of writing it.
+func (d *Controller) fetchDeveloperApp(ctx *fiber.Ctx) (*blueprint.DeveloperApp, error) {
+	app, ok := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
+	if !ok || app == nil {
+		log.Printf("[controllers][fetchDeveloperApp] developer -  error: no app for %s %s\n", ctx.Method(), ctx.Path())
+		return nil, util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App not found")
+	}
+	return app, nil
+}
+
+// parseTimeQuery parses the RFC3339 timestamp in the query param. It returns nil if the param is not passed.
+func parseTimeQuery(ctx *fiber.Ctx, param string) (*time.Time, error) {
+	value := ctx.Query(param)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// isWebhookNotFound returns true if the webhook sender did not find the endpoint or the message: the built-in sender
+// returns orchdiowebhook.ErrEndpointNotFound or orchdiowebhook.ErrMessageNotFound, and Svix an error with the status 404.
+func isWebhookNotFound(err error) bool {
+	if errors.Is(err, orchdiowebhook.ErrEndpointNotFound) || errors.Is(err, orchdiowebhook.ErrMessageNotFound) {
+		return true
+	}
+	var svixErr *svix.Error
+	return errors.As(err, &svixErr) && svixErr.Status() == http.StatusNotFound
+}
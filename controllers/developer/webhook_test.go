@@ -0,0 +1,17 @@
+package developer
+
+import (
+	"errors"
+	"fmt"
+	orchdiowebhook "orchdio/webhooks/orchdio"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsWebhookNotFound(t *testing.T) {
+	assert.True(t, isWebhookNotFound(orchdiowebhook.ErrEndpointNotFound))
+	assert.True(t, isWebhookNotFound(fmt.Errorf("could not fetch message: %w", orchdiowebhook.ErrMessageNotFound)))
+	// errors that only mention a 404 are not taken for one.
+	assert.False(t, isWebhookNotFound(errors.New("dial tcp 10.0.0.404:443: connection refused")))
+}
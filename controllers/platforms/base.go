@@ -1,18 +1,24 @@
 package platforms
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
+	"orchdio/internal/encryption"
+	"orchdio/logger"
 	"orchdio/queue"
+	"orchdio/services/sandbox"
+	"orchdio/services/tidal"
+	"orchdio/services/tokens"
 	"orchdio/universal"
 	"orchdio/util"
 	svixwebhook "orchdio/webhooks/svix"
-	"os"
 	"strings"
 	"time"
 
@@ -20,19 +26,115 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
 )
 
 // Platforms represents the structure for the platforms
 type Platforms struct {
 	Redis         *redis.Client
 	DB            *sqlx.DB
-	Logger        *blueprint.OrchdioLoggerOptions
 	Queue         queue.QueueService
 	WebhookSender svixwebhook.SvixInterface
+	Tokens        *tokens.Manager
+	// Envelope decrypts the integration credentials of the apps.
+	Envelope *encryption.Envelope
+	Config   *config.Config
 }
 
-func NewPlatform(r *redis.Client, db *sqlx.DB, queue queue.QueueService, webhookSender svixwebhook.SvixInterface) *Platforms {
-	return &Platforms{Redis: r, DB: db, Queue: queue, WebhookSender: webhookSender}
+var (
+	// ErrTrackNotConverted is returned when the conversion of a track has no result.
+	ErrTrackNotConverted = errors.New("track not converted")
+	// ErrTaskNotCreated is returned when the task of a conversion could not be saved.
+	ErrTaskNotCreated = errors.New("could not create task record")
+)
+
+func NewPlatform(r *redis.Client, db *sqlx.DB, queue queue.QueueService, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) *Platforms {
+	return &Platforms{Redis: r, DB: db, Queue: queue, WebhookSender: webhookSender, Tokens: tokens.NewManager(db, r, webhookSender, envelope),
+		Envelope: envelope, Config: cfg}
+}
+
+// userAccessToken returns a valid access token of the user on the platform. The tidal library endpoints use the
+// app's own tidal account, so users that have not connected tidal get an empty token instead of an error.
+func (p *Platforms) userAccessToken(ctx *fiber.Ctx, userId string, app *blueprint.DeveloperApp, platform string) (string, error) {
+	accessToken, err := p.Tokens.AccessToken(ctx.Context(), userId, app, platform)
+	if errors.Is(err, tokens.ErrNotConnected) && platform == tidal.IDENTIFIER {
+		return "", nil
+	}
+	return accessToken, err
+}
+
+// libraryUser returns the id of the user of the app on the platform and a valid access token of theirs. It returns an
+// empty id and the error response if the user can not be found or their token can not be had. The apps in the sandbox
+// have no platform users: every user id gets the library of the sandbox user.
+func (p *Platforms) libraryUser(ctx *fiber.Ctx, userId string, app *blueprint.DeveloperApp, platform string) (string, string, error) {
+	if app.Sandbox {
+		return userId, sandbox.AccessToken, nil
+	}
+
+	database := db.NewDB{DB: p.DB}
+	user, err := database.FetchPlatformAndUserInfoByIdentifier(userId, app.UID.String(), platform)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			logger.FromFiber(ctx).Warn("[platforms][libraryUser] error - user not found", zap.String("user_id", userId))
+			return "", "", util.ErrorResponse(ctx, http.StatusNotFound, "not found", "User not found")
+		}
+		logger.FromFiber(ctx).Error("[platforms][libraryUser] error - could not fetch the user", zap.String("user_id", userId), zap.Error(err))
+		return "", "", util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
+	}
+
+	accessToken, err := p.userAccessToken(ctx, user.UserID, app, platform)
+	if err != nil {
+		logger.FromFiber(ctx).Warn("[platforms][libraryUser] error - could not get the user's access token", zap.String("user_id", userId), zap.Error(err))
+		return "", "", p.tokenErrorResponse(ctx, user.UserID, app, platform, err)
+	}
+	return user.UserID, accessToken, nil
+}
+
+// withAccessToken calls fetch with the access token of the user. When the platform rejects the access token before its
+// expiry (blueprint.ErrAccessTokenRejected), the token is refreshed and fetch is called again, once. The error is
+// blueprint.ErrUserAuthRevoked only when the platform rejects the tokens for good: the refresh token was rejected, or the
+// platform has no refresh tokens.
+func (p *Platforms) withAccessToken(ctx *fiber.Ctx, userId string, app *blueprint.DeveloperApp, platform, accessToken string, fetch func(accessToken string) error) error {
+	err := fetch(accessToken)
+	if !errors.Is(err, blueprint.ErrAccessTokenRejected) || app.Sandbox {
+		return err
+	}
+	if !tokens.Refreshable(platform) {
+		return fmt.Errorf("%w: %v", blueprint.ErrUserAuthRevoked, err)
+	}
+
+	logger.FromFiber(ctx).Warn("[platforms][withAccessToken] the platform rejected the access token of the user. Refreshing it", zap.String("user_id", userId))
+	token, rErr := p.Tokens.Refresh(ctx.Context(), userId, app, platform, accessToken)
+	if rErr != nil {
+		if errors.Is(rErr, blueprint.ErrUserAuthRevoked) {
+			return rErr
+		}
+		logger.FromFiber(ctx).Error("[platforms][withAccessToken] error - could not refresh the rejected access token", zap.String("user_id", userId), zap.Error(rErr))
+		return err
+	}
+	return fetch(token.AccessToken)
+}
+
+// tokenErrorResponse responds with the error returned when fetching the access token of a user.
+func (p *Platforms) tokenErrorResponse(ctx *fiber.Ctx, userId string, app *blueprint.DeveloperApp, platform string, err error) error {
+	if errors.Is(err, tokens.ErrNotConnected) {
+		return util.ErrorResponse(ctx, http.StatusUnauthorized, "no access", "User has not connected this platform to Orchdio")
+	}
+	if errors.Is(err, blueprint.ErrUserAuthRevoked) {
+		return p.authRevokedResponse(ctx, userId, app, platform)
+	}
+	return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
+}
+
+// authRevokedResponse marks the user app as needing re-auth, notifies the app and responds with a 401 that has the URL
+// to connect the platform again.
+func (p *Platforms) authRevokedResponse(ctx *fiber.Ctx, userId string, app *blueprint.DeveloperApp, platform string) error {
+	revoked, err := p.Tokens.ReportAuthRevoked(userId, app, platform, ctx.Hostname())
+	if err != nil {
+		logger.FromFiber(ctx).Error("[controllers][platforms][authRevokedResponse] error - could not report the revoked authorization of the user", zap.String("user_id", userId), zap.Error(err))
+		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
+	}
+	return util.ErrorResponse(ctx, http.StatusUnauthorized, revoked, "User revoked the access of this app on the platform. Please ask the user to connect the platform again")
 }
 
 func (p *Platforms) ConvertTrack(ctx *fiber.Ctx) error {
@@ -41,71 +143,91 @@ func (p *Platforms) ConvertTrack(ctx *fiber.Ctx) error {
 
 	targetPlatform := linkInfo.TargetPlatform
 	if targetPlatform == "" {
-		log.Printf("\n[controllers][platforms] No target platform found in linkInfo\n")
+		logger.FromFiber(ctx).Warn("[controllers][platforms][ConvertTrack] error - no target platform found in linkInfo")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "target platform not specified", "Target platform not specified.")
 	}
 
 	if strings.Contains(linkInfo.Entity, "track") {
-		conversion, conversionError := universal.ConvertTrack(linkInfo, p.Redis, p.DB, p.WebhookSender)
-		if conversionError != nil {
-			if errors.Is(conversionError, blueprint.ErrNotImplemented) {
-				log.Printf("\n[controllers][platforms][deezer][ConvertTrack] error - %v\n", "Not implemented")
+		response, err := p.TrackConversion(logger.Context(ctx), linkInfo, app)
+		if err != nil {
+			if errors.Is(err, blueprint.ErrNotImplemented) {
 				return util.ErrorResponse(ctx, http.StatusNotImplemented, "not supported", "Not implemented")
 			}
-
-			log.Printf("\n[controllers][platforms][%s —— %s][ConvertTrack] error - %v\n", linkInfo.Platform, linkInfo.TargetPlatform, conversionError.Error())
-
-			if strings.Contains(conversionError.Error(), "credentials not provided") {
-				log.Printf("\n[controllers][platforms][%s —— %s][ConvertTrack] - %v\n", linkInfo.Platform, linkInfo.TargetPlatform, "Credentials missing")
-				return util.ErrorResponse(ctx, http.StatusUnauthorized, "credentials missing", fmt.Sprintf("%s. Please update your app with the missing platform's credentials.", conversionError.Error()))
+			if strings.Contains(err.Error(), "credentials not provided") {
+				return util.ErrorResponse(ctx, http.StatusUnauthorized, "credentials missing", fmt.Sprintf("%s. Please update your app with the missing platform's credentials.", err.Error()))
 			}
-
-			log.Printf("\n[controllers][platforms][%s —— %s][ConvertTrack] - Could not convert track: error — %v", linkInfo.TargetPlatform, linkInfo.Platform, conversionError.Error())
-			return util.ErrorResponse(ctx, http.StatusInternalServerError, conversionError, "An internal error occurred")
+			if errors.Is(err, ErrTrackNotConverted) {
+				return util.ErrorResponse(ctx, http.StatusNotFound, err, "An internal error occurred")
+			}
+			if errors.Is(err, ErrTaskNotCreated) {
+				return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred and could not create task record.")
+			}
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred")
 		}
+		return util.SuccessResponse(ctx, http.StatusOK, response)
+	}
 
-		log.Printf("\n[controllers][platforms][ConvertTrack] - converted %v with URL %v\n", linkInfo.Entity, linkInfo.TargetLink)
-
-		// HACK: insert a new task in the DB directly and return the ID as part of the
-		// conversion response. We are saving directly because for playlists, we run them in asynq job queue
-		// and for tracks, we want to return the full result of the track conversion (since it takes less time).
-		// we use this task info to implement being able to share a conversion page
-		// (for example on Zoove) with a link because it carries data unique to the operation.
-		database := db.NewDB{DB: p.DB}
-		uniqueId, _ := uuid.NewUUID()
-		// generate a URL friendly short ID. this is what we're going to send to the API response
-		// and user can use it in the conversion URL.
-		shortURL := util.GenerateShortID()
-		serialized, err := json.Marshal(conversion)
-		if conversion == nil {
-			log.Printf("\n[controllers][platforms][controllers][platforms][%s —— %s][ConvertTrack] - conversion is nil %v\n", linkInfo.Platform, linkInfo.TargetPlatform, err)
-			return util.ErrorResponse(ctx, http.StatusNotFound, err, "An internal error occurred")
-		}
+	return util.ErrorResponse(ctx, http.StatusNotImplemented, "not supported", "Not implemented")
+}
 
-		// add the task ID to the conversion response
-		conversion.UniqueID = string(shortURL)
-		if err != nil {
-			log.Printf("[db][CreateTrackTaskRecord] error serializing result. %v\n", err)
-			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred. Could not deserialize result")
+// TrackConversion converts the track in the link info and saves the conversion as a task of the app. It is used by
+// ConvertTrack and by the portal websocket.
+func (p *Platforms) TrackConversion(ctx context.Context, linkInfo *blueprint.LinkInfo, app *blueprint.DeveloperApp) (*blueprint.TrackConversion, error) {
+	l := logger.FromContext(ctx).With(zap.String(logger.FieldPlatform, linkInfo.Platform), zap.String("target_platform", linkInfo.TargetPlatform))
+	conversion, conversionError := universal.ConvertTrack(ctx, linkInfo, p.Redis, p.DB, p.WebhookSender, p.Envelope, p.Config)
+	if conversionError != nil {
+		if errors.Is(conversionError, blueprint.ErrNotImplemented) {
+			l.Warn("[controllers][platforms][TrackConversion] error - not implemented")
+			return nil, conversionError
 		}
 
-		_, err = database.CreateTrackTaskRecord(uniqueId.String(), string(shortURL), linkInfo.EntityID, app.UID.String(), serialized)
-		if err != nil {
-			log.Printf("\n[controllers][platforms][ConvertTrack] - Could not create task record")
-			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred and could not create task record.")
+		if strings.Contains(conversionError.Error(), "credentials not provided") {
+			l.Warn("[controllers][platforms][TrackConversion] error - credentials missing", zap.Error(conversionError))
+			return nil, conversionError
 		}
 
-		response := &blueprint.TrackConversion{
-			Entity:         "track",
-			Platforms:      conversion.Platforms,
-			UniqueID:       conversion.UniqueID,
-			SourcePlatform: linkInfo.Platform,
-			TargetPlatform: linkInfo.TargetPlatform,
-		}
-		return util.SuccessResponse(ctx, http.StatusOK, response)
+		l.Error("[controllers][platforms][TrackConversion] error - could not convert track", zap.Error(conversionError))
+		return nil, conversionError
 	}
 
-	return util.ErrorResponse(ctx, http.StatusNotImplemented, "not supported", "Not implemented")
+	l.Info("[controllers][platforms][TrackConversion] - converted track", zap.String("url", linkInfo.TargetLink))
+
+	// HACK: insert a new task in the DB directly and return the ID as part of the
+	// conversion response. We are saving directly because for playlists, we run them in asynq job queue
+	// and for tracks, we want to return the full result of the track conversion (since it takes less time).
+	// we use this task info to implement being able to share a conversion page
+	// (for example on Zoove) with a link because it carries data unique to the operation.
+	database := db.NewDB{DB: p.DB}
+	uniqueId, _ := uuid.NewUUID()
+	// generate a URL friendly short ID. this is what we're going to send to the API response
+	// and user can use it in the conversion URL.
+	shortURL := util.GenerateShortID()
+	if conversion == nil {
+		l.Warn("[controllers][platforms][TrackConversion] - conversion is nil")
+		return nil, ErrTrackNotConverted
+	}
+
+	// add the task ID to the conversion response
+	conversion.UniqueID = string(shortURL)
+	serialized, err := json.Marshal(conversion)
+	if err != nil {
+		l.Error("[controllers][platforms][TrackConversion] error serializing result", zap.Error(err))
+		return nil, err
+	}
+
+	_, err = database.CreateTrackTaskRecord(uniqueId.String(), string(shortURL), linkInfo.EntityID, app.UID.String(), serialized)
+	if err != nil {
+		l.Error("[controllers][platforms][TrackConversion] - could not create task record", zap.Error(err))
+		return nil, ErrTaskNotCreated
+	}
+
+	return &blueprint.TrackConversion{
+		Entity:         "track",
+		Platforms:      conversion.Platforms,
+		UniqueID:       conversion.UniqueID,
+		SourcePlatform: linkInfo.Platform,
+		TargetPlatform: linkInfo.TargetPlatform,
+	}, nil
 }
 
 // ConvertPlaylist returns the link to a track on several platforms
@@ -118,64 +240,68 @@ func (p *Platforms) ConvertPlaylist(ctx *fiber.Ctx) error {
 	// to make the conversion
 	targetPlatform := linkInfo.TargetPlatform
 	if targetPlatform == "" {
-		log.Printf("\n[controllers][platforms][ConvertPlaylist] error - %v\n", "Target platform not specified")
+		logger.FromFiber(ctx).Warn("[controllers][platforms][ConvertPlaylist] error - target platform not specified")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "target platform not specified", "Target platform not specified")
 	}
 	// make sure we're actually handling for playlist alone, not track.
 	if strings.Contains(linkInfo.Entity, "playlist") {
-		uniqueId := uuid.New().String()
-		shortURL := util.GenerateShortID()
-		taskData := &blueprint.PlaylistTaskData{
-			LinkInfo: linkInfo,
-			App:      app,
-			TaskID:   uniqueId,
-			ShortURL: string(shortURL),
-		}
-
-		// orchdioQueue := queue.NewOrchdioQueue(p.AsynqClient, p.DB, p.Redis, p.AsynqMux)
-
-		// create new task and set the handler. the handler will create or update a new task in the db
-		// in the case where the conversion fails, it sets the status to failed and ditto for success
-		// serialize linkInfo
-		ser, err := json.Marshal(&taskData)
+		res, err := p.QueuePlaylistConversion(logger.Context(ctx), linkInfo, app)
 		if err != nil {
-			log.Printf("[controller][conversion][EchoConversion] - error marshalling link info: %v", err)
-			return ctx.Status(http.StatusInternalServerError).JSON("error marshalling link info")
-		}
-		// create new task
-		conversionTask, err := p.Queue.NewTask(fmt.Sprintf("%s_%s", blueprint.PlaylistConversionTaskTypePattern, taskData.TaskID), blueprint.PlaylistConversionTaskTypePattern, 1, ser)
-		enqErr := p.Queue.EnqueueTask(conversionTask, blueprint.PlaylistConversionQueueName, taskData.TaskID, time.Second*1)
-		if enqErr != nil {
-			log.Printf("[controller][conversion][EchoConversion] - error enqueuing task: %v", enqErr)
-			return ctx.Status(http.StatusInternalServerError).JSON("error enqueuing task")
+			return ctx.Status(http.StatusInternalServerError).JSON(err.Error())
 		}
+		return util.SuccessResponse(ctx, http.StatusCreated, res)
+	}
 
-		_, err = redis.ParseURL(os.Getenv("REDISCLOUD_URL"))
-		if err != nil {
-			log.Printf("[controller][conversion][EchoConversion] - error parsing redis url: %v", err)
-			return ctx.Status(http.StatusInternalServerError).JSON("error parsing redis url")
-		}
-		database := db.NewDB{DB: p.DB}
+	logger.FromFiber(ctx).Warn("[controllers][platforms][ConvertPlaylist] error - it is not a playlist or track URL")
+	return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid URL")
+}
 
-		// we were saving the task developer as user before but now we save the app
-		_taskId, dbErr := database.CreateOrUpdateTask(uniqueId, string(shortURL), app.UID.String(), linkInfo.EntityID)
-		if dbErr != nil {
-			log.Printf("[controller][conversion][EchoConversion] - error creating task: %v", dbErr)
-			return ctx.Status(http.StatusInternalServerError).JSON("error creating task")
-		}
+// QueuePlaylistConversion queues the conversion of the playlist in the link info and creates its task. The task is
+// pending until the worker converts the playlist. It is used by ConvertPlaylist and by the portal websocket. The id of
+// the request in ctx is passed to the task, so that the logs of the worker can be joined with the request.
+func (p *Platforms) QueuePlaylistConversion(ctx context.Context, linkInfo *blueprint.LinkInfo, app *blueprint.DeveloperApp) (*blueprint.PlaylistTaskResponse, error) {
+	uniqueId := uuid.New().String()
+	shortURL := util.GenerateShortID()
+	taskData := &blueprint.PlaylistTaskData{
+		LinkInfo:  linkInfo,
+		App:       app,
+		TaskID:    uniqueId,
+		ShortURL:  string(shortURL),
+		RequestID: logger.RequestIDFromContext(ctx),
+	}
+	l := logger.FromContext(ctx).With(zap.String(logger.FieldTaskID, uniqueId))
 
-		// TrackConversion task response to be polled later
-		res := &blueprint.PlaylistTaskResponse{
-			TaskID:   string(_taskId),
-			UniqueID: string(shortURL),
-			Payload:  nil,
-			Status:   "pending",
-		}
+	// create new task and set the handler. the handler will create or update a new task in the db
+	// in the case where the conversion fails, it sets the status to failed and ditto for success
+	// serialize linkInfo
+	ser, err := json.Marshal(&taskData)
+	if err != nil {
+		l.Error("[controller][conversion][QueuePlaylistConversion] - error marshalling link info", zap.Error(err))
+		return nil, errors.New("error marshalling link info")
+	}
+	// create new task
+	conversionTask, err := p.Queue.NewTask(ctx, fmt.Sprintf("%s_%s", blueprint.PlaylistConversionTaskTypePattern, taskData.TaskID), blueprint.PlaylistConversionTaskTypePattern, 1, ser)
+	enqErr := p.Queue.EnqueueTask(ctx, conversionTask, blueprint.PlaylistConversionQueueName, taskData.TaskID, time.Second*1)
+	if enqErr != nil {
+		l.Error("[controller][conversion][QueuePlaylistConversion] - error enqueuing task", zap.Error(enqErr))
+		return nil, errors.New("error enqueuing task")
+	}
 
-		log.Printf("[controller][conversion][EchoConversion] - task handler attached")
-		return util.SuccessResponse(ctx, http.StatusCreated, res)
+	database := db.NewDB{DB: p.DB}
+
+	// we were saving the task developer as user before but now we save the app
+	_taskId, dbErr := database.CreateOrUpdateTask(uniqueId, string(shortURL), app.UID.String(), linkInfo.EntityID)
+	if dbErr != nil {
+		l.Error("[controller][conversion][QueuePlaylistConversion] - error creating task", zap.Error(dbErr))
+		return nil, errors.New("error creating task")
 	}
 
-	log.Printf("\n[controllers][platforms][ConvertPlaylist] error - %v\n", "It is not a playlist or track URL")
-	return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid URL")
+	l.Info("[controller][conversion][QueuePlaylistConversion] - task queued")
+	// TrackConversion task response to be polled later
+	return &blueprint.PlaylistTaskResponse{
+		TaskID:   string(_taskId),
+		UniqueID: string(shortURL),
+		Payload:  nil,
+		Status:   "pending",
+	}, nil
 }
@@ -1,25 +1,24 @@
 package platforms
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
 	"orchdio/blueprint"
 	"orchdio/db"
+	"orchdio/logger"
 	"orchdio/services/applemusic"
 	"orchdio/services/deezer"
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
 	"orchdio/universal"
 	"orchdio/util"
-	svixwebhook "orchdio/webhooks/svix"
-	"os"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
 	"golang.org/x/oauth2"
 
 	spotify2 "github.com/zmb3/spotify/v2"
@@ -29,12 +28,13 @@ import (
 func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 	// get the platform they want to add the playlist to
 	platform := ctx.Params("platform")
+	l := logger.FromFiber(ctx)
 	if platform == "" {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error - %v\n", "No platform in context")
+		l.Warn("[controllers][platforms][AddPlaylistToAccount] error - no platform in context")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Platform not found")
 	}
 
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
+	webhookSender := p.WebhookSender
 
 	app := ctx.Locals("app").(*blueprint.DeveloperApp)
 
@@ -47,17 +47,17 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 
 	err := ctx.BodyParser(&createBodyData)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error - %v\n", err)
+		l.Warn("[controllers][platforms][AddPlaylistToAccount] error - invalid request body", zap.Error(err))
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid request body. Please make sure the body is valid.")
 	}
 
 	if len(createBodyData.Tracks) == 0 {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error - %v\n", "No tracks in playlist")
+		l.Warn("[controllers][platforms][AddPlaylistToAccount] error - no tracks in playlist")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "No tracks to insert into playlist. please add tracks to the playlist")
 	}
 
 	if createBodyData.Title == "" {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error - %v\n", "No title in playlist")
+		l.Warn("[controllers][platforms][AddPlaylistToAccount] error - no title in playlist")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "No title to insert into playlist. please add title to the playlist")
 	}
 
@@ -66,19 +66,18 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 	user, err := database.FetchPlatformAndUserInfoByIdentifier(createBodyData.User, app.UID.String(), platform)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error - %v\n", "App not found")
+			l.Warn("[controllers][platforms][AddPlaylistToAccount] error - user not found", zap.String("user_id", createBodyData.User))
 			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "User has not authorized this app. Please authorize the app to continue.")
 		}
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error - %v\n", err)
+		l.Error("[controllers][platforms][AddPlaylistToAccount] error - could not fetch the user", zap.Error(err))
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred.")
 	}
 
 	// get the user's access token
-	t, err := util.Decrypt(user.RefreshToken, []byte(os.Getenv("ENCRYPTION_SECRET")))
-
+	accessToken, err := p.userAccessToken(ctx, user.UserID, app, platform)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error decrypting user refresh token - %v\n", err)
-		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred while decrypting refresh token")
+		l.Error("[controllers][platforms][AddPlaylistToAccount] error - could not get the user's access token", zap.Error(err))
+		return p.tokenErrorResponse(ctx, user.UserID, app, platform, err)
 	}
 
 	var credentialsBytes []byte
@@ -93,15 +92,15 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 		credentialsBytes = app.AppleMusicCredentials
 	}
 
-	cred, err := util.Decrypt(credentialsBytes, []byte(os.Getenv("ENCRYPTION_SECRET")))
+	cred, err := p.Envelope.Decrypt(credentialsBytes)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error unmarshalling credentials - could not decrypt integration credentials for platform %s: %v\n", platform, err)
+		l.Error("[controllers][platforms][AddPlaylistToAccount] error - could not decrypt integration credentials", zap.Error(err))
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred while unmarshalling credentials")
 	}
 	var credentials blueprint.IntegrationCredentials
 	err = json.Unmarshal(cred, &credentials)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error unmarshalling credentials - could not unmarshal integration credentials for platform %s: %v\n", platform, err)
+		l.Error("[controllers][platforms][AddPlaylistToAccount] error - could not unmarshal integration credentials", zap.Error(err))
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred while unmarshalling credentials")
 	}
 
@@ -111,21 +110,31 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 	switch platform {
 	// todo: fix this, dont use magic string, similar to other platforms
 	case spotify.IDENTIFIER:
-		spotifyService := spotify.NewService(&credentials, p.DB, p.Redis, app, webhookSender)
-		client := spotifyService.NewClient(context.Background(), &oauth2.Token{
-			RefreshToken: string(t),
+		spotifyService := spotify.NewService(&credentials, p.DB, p.Redis, app, webhookSender, p.Config)
+		var client *spotify2.Client
+		var createdPlaylist *spotify2.FullPlaylist
+		pErr := p.withAccessToken(ctx, user.UserID, app, platform, accessToken, func(accessToken string) error {
+			client = spotifyService.NewClient(ctx.UserContext(), &oauth2.Token{
+				AccessToken: accessToken,
+				TokenType:   "Bearer",
+			})
+			var cErr error
+			createdPlaylist, cErr = client.CreatePlaylistForUser(ctx.UserContext(), user.PlatformID, createBodyData.Title, description, true, false)
+			return spotify.AuthError(cErr)
 		})
 
-		createdPlaylist, pErr := client.CreatePlaylistForUser(context.Background(), user.PlatformID, createBodyData.Title, description, true, false)
-
 		if pErr != nil {
-			log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error creating new playlist for user - %v\n", pErr.Error())
+			l.Error("[controllers][platforms][AddPlaylistToAccount] error creating new playlist for user", zap.Error(pErr))
+			if errors.Is(pErr, blueprint.ErrUserAuthRevoked) {
+				return p.authRevokedResponse(ctx, user.UserID, app, platform)
+			}
 			if strings.Contains(pErr.Error(), "oauth2: cannot fetch token: 400 Bad Request") {
 				return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid client. The user or developer app's credentials might be invalid")
 			}
 			if strings.Contains(pErr.Error(), "This request requires user authentication") {
 				return util.ErrorResponse(ctx, http.StatusInternalServerError, blueprint.ErrUnAuthorized, "Please reauthenticate this app with the permission to read playlists and try again.")
 			}
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "Could not create a new playlist for user")
 		}
 
 		var trackIds []spotify2.ID
@@ -136,9 +145,9 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 		}
 
 		// update playlist with the tracks
-		updated, cErr := client.AddTracksToPlaylist(context.Background(), createdPlaylist.ID, trackIds...)
+		updated, cErr := client.AddTracksToPlaylist(ctx.UserContext(), createdPlaylist.ID, trackIds...)
 		if cErr != nil {
-			log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error adding new track to playlist - %v\n", cErr)
+			l.Error("[controllers][platforms][AddPlaylistToAccount] error adding new track to playlist", zap.Error(cErr))
 			if cErr.Error() == "No tracks specified." {
 				return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", cErr.Error())
 			}
@@ -147,42 +156,45 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 
 		playlistlink = createdPlaylist.ExternalURLs["spotify"]
 
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] - created playlist %v\n", updated)
+		l.Info("[controllers][platforms][AddPlaylistToAccount] - created playlist", zap.String("snapshot_id", updated))
 
 	case deezer.IDENTIFIER:
 
-		deezerService := deezer.NewService(&credentials, p.DB, p.Redis, app, webhookSender)
-		id, err := deezerService.CreateNewPlaylist(createBodyData.Title, user.PlatformID, string(t), createBodyData.Tracks)
+		deezerService := deezer.NewService(&credentials, p.DB, p.Redis, app, webhookSender, p.Config)
+		id, err := deezerService.CreateNewPlaylist(createBodyData.Title, user.PlatformID, accessToken, createBodyData.Tracks)
 		if err != nil {
-			log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error creating new playlist - %v\n", err)
+			l.Error("[controllers][platforms][AddPlaylistToAccount] error creating new playlist", zap.Error(err))
+			if errors.Is(err, blueprint.ErrUserAuthRevoked) {
+				return p.authRevokedResponse(ctx, user.UserID, app, platform)
+			}
 			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "Could not create a new playlist for user")
 		}
 
 		playlistlink = fmt.Sprintf("https://www.deezer.com/en/playlist/%s", id)
 
-		log.Printf("\n[controllers][platforms][AddPlaylistToAccount] - created playlist %v\n", createBodyData.Title)
+		l.Info("[controllers][platforms][AddPlaylistToAccount] - created playlist", zap.ByteString("playlist_id", id))
 
 	case applemusic.IDENTIFIER:
-		applemusicService := applemusic.NewService(&credentials, p.DB, p.Redis, app)
-		pl, err := applemusicService.CreateNewPlaylist(createBodyData.Title, description, string(t), createBodyData.Tracks)
+		applemusicService := applemusic.NewService(&credentials, p.DB, p.Redis, app, p.Config)
+		pl, err := applemusicService.CreateNewPlaylist(createBodyData.Title, description, accessToken, createBodyData.Tracks)
 		playlistlink = string(pl)
 		if err != nil {
-			log.Printf("\n[controllers][platforms][AddPlaylistToAccount][error] - an error occurred while adding playlist to user platform account - %v\n", err)
+			l.Error("[controllers][platforms][AddPlaylistToAccount][error] - an error occurred while adding playlist to user platform account", zap.Error(err))
 			if err == blueprint.ErrForbidden {
-				log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error creating new playlist - %v\n", err)
+				l.Error("[controllers][platforms][AddPlaylistToAccount] error creating new playlist", zap.Error(err))
 				return util.ErrorResponse(ctx, http.StatusForbidden, err, "Could not create new playlist for user. Access has not been granted by user")
 			}
 			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred.")
 		}
 
 	case tidal.IDENTIFIER:
-		tidalService := tidal.NewService(&credentials, p.DB, p.Redis, app, webhookSender)
-		pl, err := tidalService.CreateNewPlaylist(createBodyData.Title, description, string(t), createBodyData.Tracks)
+		tidalService := tidal.NewService(&credentials, p.DB, p.Redis, app, webhookSender, p.Config)
+		pl, err := tidalService.CreateNewPlaylist(createBodyData.Title, description, accessToken, createBodyData.Tracks)
 		playlistlink = string(pl)
 		if err != nil {
-			log.Printf("\n[controllers][platforms][AddPlaylistToAccount][error] - an error occurred while adding playlist to user platform account - %v\n", err)
+			l.Error("[controllers][platforms][AddPlaylistToAccount][error] - an error occurred while adding playlist to user platform account", zap.Error(err))
 			if err == blueprint.ErrForbidden {
-				log.Printf("\n[controllers][platforms][AddPlaylistToAccount] error creating new playlist - %v\n", err)
+				l.Error("[controllers][platforms][AddPlaylistToAccount] error creating new playlist", zap.Error(err))
 				return util.ErrorResponse(ctx, http.StatusForbidden, err, "Could not create new playlist for user. Access has not been granted by user")
 			}
 		}
@@ -192,58 +204,39 @@ func (p *Platforms) AddPlaylistToAccount(ctx *fiber.Ctx) error {
 
 // FetchPlatformPlaylists fetches the all the playlists on a user's platform library
 func (p *Platforms) FetchPlatformPlaylists(ctx *fiber.Ctx) error {
-	log.Printf("[platforms][FetchPlatformPlaylists] fetching platform playlists")
 	app := ctx.Locals("app").(*blueprint.DeveloperApp)
 	userId := ctx.Params("userId")
 	platform := ctx.Params("platform")
 
 	if userId == "" {
-		log.Printf("[platforms][FetchPlatformPlaylists] error - userId is empty")
+		logger.FromFiber(ctx).Warn("[platforms][FetchPlatformPlaylists] error - userId is empty")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "userId is empty")
 	}
 	if platform == "" {
-		log.Printf("[platforms][FetchPlatformPlaylists] error - targetPlatform is empty")
+		logger.FromFiber(ctx).Warn("[platforms][FetchPlatformPlaylists] error - targetPlatform is empty")
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "targetPlatform is empty")
 	}
 
-	log.Printf("[platforms][FetchPlatformPlaylists] App %s is trying to fetch user %s's %s playlists\n", app.Name, userId, strings.ToUpper(platform))
+	logger.FromFiber(ctx).Info("[platforms][FetchPlatformPlaylists] fetching the library playlists of the user", zap.String("user_id", userId))
 
 	// get the user via the id to make sure the user exists
-	database := db.NewDB{DB: p.DB}
-	//user, err := database.FindUserByUUID(userId, targetPlatform)
-	user, err := database.FetchPlatformAndUserInfoByIdentifier(userId, app.UID.String(), platform)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			log.Printf("[platforms][FetchPlatformPlaylists] error - user not found %v\n", err)
-			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "User not found")
-		}
-		log.Printf("[platforms][FetchPlatformPlaylists] error - error fetching user %v\n", err)
-		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
-	}
-	log.Printf("[platforms][FetchPlatformPlaylists] user found. Target platform is %v %s\n", user.Username, platform)
-
-	var refreshToken string
-	// if the user refresh token is nil, the user has not connected this platform to Orchdio.
-	// this is because everytime a user connects a platform to Orchdio, the refresh token is updated for the platform the user connected
-	if user.RefreshToken == nil && platform != "tidal" {
-		log.Printf("[platforms][FetchPlatformPlaylists] error - user's refresh token is empty %v\n", err)
-		return util.ErrorResponse(ctx, http.StatusUnauthorized, "no access", "User has not connected this platform to Orchdio")
+	platformUserId, accessToken, rErr := p.libraryUser(ctx, userId, app, platform)
+	if platformUserId == "" {
+		return rErr
 	}
 
-	if user.RefreshToken != nil {
-		// decrypt the user's access token
-		r, err := util.Decrypt(user.RefreshToken, []byte(os.Getenv("ENCRYPTION_SECRET")))
-		if err != nil {
-			log.Printf("[platforms][FetchPlatformPlaylists] error - error decrypting access token %v\n", err)
-			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occured")
-		}
-		refreshToken = string(r)
-	}
-
-	libraryPlaylists, err := universal.FetchLibraryPlaylists(platform, refreshToken, app.UID.String(), p.DB, p.Redis)
+	var libraryPlaylists []blueprint.UserPlaylist
+	err := p.withAccessToken(ctx, platformUserId, app, platform, accessToken, func(accessToken string) error {
+		var fErr error
+		libraryPlaylists, fErr = universal.FetchLibraryPlaylists(platform, accessToken, app, p.DB, p.Redis, p.WebhookSender, p.Envelope, p.Config)
+		return fErr
+	})
 
 	if err != nil {
-		log.Printf("\n[controllers][platforms][%s][FetchLibraryPlaylists] error - %v\n", platform, err)
+		logger.FromFiber(ctx).Error("[controllers][platforms][FetchPlatformPlaylists] error - could not fetch the library playlists", zap.Error(err))
+		if errors.Is(err, blueprint.ErrUserAuthRevoked) {
+			return p.authRevokedResponse(ctx, platformUserId, app, platform)
+		}
 		return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", fmt.Sprintf("Could not fetch user library albums on platform %s", platform))
 	}
 
@@ -0,0 +1,108 @@
+package platforms
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/db/queries"
+	"orchdio/internal/encryption"
+	"orchdio/services/deezer"
+	"orchdio/services/spotify"
+	"orchdio/services/tokens"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testEnvelope *encryption.Envelope
+
+func TestMain(m *testing.M) {
+	config.Set(&config.Config{Encryption: config.Encryption{Secret: "super-secure-secret-something-ff"}})
+	envelope, err := encryption.FromConfig(config.Get().Encryption)
+	if err != nil {
+		panic(err)
+	}
+	testEnvelope = envelope
+	os.Exit(m.Run())
+}
+
+// callWithAccessToken calls withAccessToken in a request, with a fetch that rejects the access tokens in rejected. It
+// returns the access tokens fetch was called with and the error.
+func callWithAccessToken(t *testing.T, p *Platforms, app *blueprint.DeveloperApp, platform string, rejected ...string) ([]string, error) {
+	var err error
+	var calls []string
+	server := fiber.New()
+	server.Get("/", func(ctx *fiber.Ctx) error {
+		err = p.withAccessToken(ctx, "user", app, platform, "rejected", func(accessToken string) error {
+			calls = append(calls, accessToken)
+			for _, token := range rejected {
+				if token == accessToken {
+					return blueprint.ErrAccessTokenRejected
+				}
+			}
+			return nil
+		})
+		return nil
+	})
+	_, tErr := server.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+	require.NoError(t, tErr)
+	return calls, err
+}
+
+func expectUserAppTokens(t *testing.T, mock sqlmock.Sqlmock, accessToken string, reauthRequiredAt interface{}) {
+	refreshToken, err := testEnvelope.Encrypt([]byte("refresh-token"))
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchUserAppTokens)).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "refresh_token", "access_token", "expires_in", "reauth_required_at"}).
+			AddRow(uuid.NewString(), refreshToken, accessToken, time.Now().Add(time.Hour), reauthRequiredAt))
+}
+
+func TestWithAccessToken(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+	database := sqlx.NewDb(conn, "postgres")
+	p := &Platforms{DB: database, Tokens: tokens.NewManager(database, nil, nil, testEnvelope)}
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+
+	// the token was refreshed by another request: the request is retried with it.
+	expectUserAppTokens(t, mock, "refreshed", nil)
+	calls, err := callWithAccessToken(t, p, app, spotify.IDENTIFIER, "rejected")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"rejected", "refreshed"}, calls)
+
+	// the refreshed token is rejected too: the request is not retried again and the user app is not marked.
+	expectUserAppTokens(t, mock, "refreshed", nil)
+	calls, err = callWithAccessToken(t, p, app, spotify.IDENTIFIER, "rejected", "refreshed")
+	assert.ErrorIs(t, err, blueprint.ErrAccessTokenRejected)
+	assert.NotErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+	assert.Len(t, calls, 2)
+
+	// the refresh token was rejected before.
+	expectUserAppTokens(t, mock, "refreshed", time.Now())
+	calls, err = callWithAccessToken(t, p, app, spotify.IDENTIFIER, "rejected")
+	assert.ErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+	assert.Len(t, calls, 1)
+
+	// the refresh fails for another reason: the rejection is returned as is.
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchUserAppTokens)).WillReturnError(errors.New("connection reset"))
+	_, err = callWithAccessToken(t, p, app, spotify.IDENTIFIER, "rejected")
+	assert.ErrorIs(t, err, blueprint.ErrAccessTokenRejected)
+	assert.NotErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+
+	// deezer tokens cannot be refreshed.
+	calls, err = callWithAccessToken(t, p, app, deezer.IDENTIFIER, "rejected")
+	assert.ErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+	assert.Len(t, calls, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
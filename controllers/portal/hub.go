@@ -0,0 +1,257 @@
+package portal
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"orchdio/blueprint"
+	"orchdio/taskevents"
+	"sync"
+
+	"github.com/antoniodipinto/ikisocket"
+	"github.com/go-redis/redis/v8"
+)
+
+// hub sends the events of tasks to the sockets subscribed to them. The events are published on redis by the worker
+// converting the task (see taskevents), and each instance subscribes to the tasks its sockets are subscribed to, so a
+// socket gets the events of a task whichever instance it is connected to.
+type hub struct {
+	red    *redis.Client
+	pubsub *redis.PubSub
+
+	mu sync.Mutex
+	// task id -> socket id -> subscription
+	tasks map[string]map[string]*subscription
+}
+
+// subscription is the subscription of a socket to a task. lastEventId is the id of the last event sent to the socket.
+type subscription struct {
+	mu          sync.Mutex
+	socketId    string
+	taskId      string
+	lastEventId int64
+	progress    blueprint.PortalTaskProgress
+	// started is set once the history of the task has been read for the subscription.
+	started bool
+	closed  bool
+}
+
+func newHub(red *redis.Client) *hub {
+	return &hub{
+		red:    red,
+		pubsub: red.Subscribe(context.Background()),
+		tasks:  map[string]map[string]*subscription{},
+	}
+}
+
+// run sends the events published on the channels of the tasks subscribed to, until the hub is closed.
+func (h *hub) run() {
+	for message := range h.pubsub.Channel() {
+		event, err := taskevents.ParseMessage(message)
+		if err != nil {
+			log.Printf("[controllers][portal][hub] error - could not parse event on channel %s: %v\n", message.Channel, err)
+			continue
+		}
+
+		h.mu.Lock()
+		subscriptions := make([]*subscription, 0, len(h.tasks[event.Event.TaskID]))
+		for _, sub := range h.tasks[event.Event.TaskID] {
+			subscriptions = append(subscriptions, sub)
+		}
+		h.mu.Unlock()
+
+		for _, sub := range subscriptions {
+			h.send(sub, event)
+		}
+	}
+}
+
+// subscribe subscribes the socket to the task and sends it the events of the task after the event id passed.
+func (h *hub) subscribe(socketId, taskId string, after int64) {
+	sub := &subscription{socketId: socketId, taskId: taskId, lastEventId: after}
+
+	h.mu.Lock()
+	if _, ok := h.tasks[taskId]; !ok {
+		h.tasks[taskId] = map[string]*subscription{}
+		// subscribe before reading the history, so that no event is missed in between.
+		if err := h.pubsub.Subscribe(context.Background(), taskevents.Channel(taskId)); err != nil {
+			log.Printf("[controllers][portal][hub][subscribe] error - could not subscribe to task %s: %v\n", taskId, err)
+		}
+	}
+	if previous, ok := h.tasks[taskId][socketId]; ok {
+		// subscribing again replaces the subscription, e.g. to resume from another event.
+		defer previous.close()
+	}
+	h.tasks[taskId][socketId] = sub
+	h.mu.Unlock()
+
+	h.send(sub, nil)
+}
+
+// unsubscribe unsubscribes the socket from the task.
+func (h *hub) unsubscribe(socketId, taskId string) {
+	h.mu.Lock()
+	sub, ok := h.tasks[taskId][socketId]
+	if ok {
+		h.remove(sub)
+	}
+	h.mu.Unlock()
+
+	if ok {
+		sub.close()
+	}
+}
+
+// unsubscribeAll unsubscribes the socket from all its tasks, when it is disconnected.
+func (h *hub) unsubscribeAll(socketId string) {
+	var subscriptions []*subscription
+	h.mu.Lock()
+	for _, task := range h.tasks {
+		if sub, ok := task[socketId]; ok {
+			subscriptions = append(subscriptions, sub)
+			h.remove(sub)
+		}
+	}
+	h.mu.Unlock()
+
+	for _, sub := range subscriptions {
+		sub.close()
+	}
+}
+
+// drop removes the subscription from the hub.
+func (h *hub) drop(sub *subscription) {
+	h.mu.Lock()
+	h.remove(sub)
+	h.mu.Unlock()
+}
+
+// remove removes the subscription from the hub and unsubscribes from the task channel if it was the last subscription
+// to the task. h.mu must be held.
+func (h *hub) remove(sub *subscription) {
+	if h.tasks[sub.taskId][sub.socketId] != sub {
+		return
+	}
+	delete(h.tasks[sub.taskId], sub.socketId)
+	if len(h.tasks[sub.taskId]) == 0 {
+		delete(h.tasks, sub.taskId)
+		if err := h.pubsub.Unsubscribe(context.Background(), taskevents.Channel(sub.taskId)); err != nil {
+			log.Printf("[controllers][portal][hub][remove] error - could not unsubscribe from task %s: %v\n", sub.taskId, err)
+		}
+	}
+}
+
+// send sends the event to the socket of the subscription. If the event is nil, or events were missed before it (e.g. they
+// were published while the history was read), the events after the last event sent are read from the task stream.
+// Events already sent are skipped.
+func (h *hub) send(sub *subscription, event *taskevents.Event) {
+	sub.mu.Lock()
+	defer sub.mu.Unlock()
+	if sub.closed || (event != nil && event.ID <= sub.lastEventId) {
+		return
+	}
+
+	events := []taskevents.Event{}
+	if sub.started && event != nil && event.ID == sub.lastEventId+1 {
+		events = append(events, *event)
+	} else {
+		after := sub.lastEventId
+		if !sub.started {
+			// the whole history is read the first time, so that the progress includes the events the client already got.
+			after = 0
+		}
+		history, err := taskevents.History(context.Background(), h.red, sub.taskId, after)
+		if err != nil {
+			log.Printf("[controllers][portal][hub][send] error - could not fetch events of task %s: %v\n", sub.taskId, err)
+			return
+		}
+		sub.started = true
+		events = history
+	}
+
+	for i := range events {
+		e := &events[i]
+		if e.ID <= sub.lastEventId {
+			updateProgress(&sub.progress, e.Event)
+			continue
+		}
+		sub.lastEventId = e.ID
+
+		err := emit(sub.socketId, &blueprint.PortalResponse{Type: blueprint.PortalMessageEvent, TaskID: sub.taskId, EventID: e.ID, Data: e.Event})
+		if err != nil {
+			// the socket is gone, it is removed from the hub when its disconnect event is handled.
+			sub.closed = true
+			return
+		}
+		if updateProgress(&sub.progress, e.Event) {
+			progress := sub.progress
+			_ = emit(sub.socketId, &blueprint.PortalResponse{Type: blueprint.PortalMessageProgress, TaskID: sub.taskId, EventID: e.ID, Data: &progress})
+		}
+
+		if e.IsFinal() {
+			sub.closed = true
+			// the lock order is hub then subscription, so the subscription is removed from the hub after it is unlocked.
+			go h.drop(sub)
+			return
+		}
+	}
+}
+
+func (s *subscription) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+}
+
+// updateProgress updates the progress of a playlist conversion with the event. It returns false if the event does not
+// change the progress.
+func updateProgress(progress *blueprint.PortalTaskProgress, event *blueprint.WebhookEvent) bool {
+	switch event.Type {
+	case blueprint.PlaylistConversionMetadataEvent:
+		var data blueprint.PlaylistConversionEventMetadata
+		if decodeEventData(event, &data) && data.Meta != nil {
+			progress.Total = data.Meta.NBTracks
+			return true
+		}
+	case blueprint.PlaylistConversionTrackEvent:
+		var data blueprint.PlaylistTrackConversionEventResponse
+		if decodeEventData(event, &data) {
+			// each converted track is sent as a pair: the track on the source platform, then on the target platform.
+			progress.Converted += len(data.Tracks) / 2
+			return true
+		}
+	case blueprint.PlaylistConversionMissingTrackEvent:
+		var data blueprint.MissingTrackEventPayload
+		if decodeEventData(event, &data) {
+			progress.Missing += max(len(data.Tracks), 1)
+			return true
+		}
+	}
+	return false
+}
+
+// decodeEventData decodes the data of an event read from redis (a map) into the payload type of the event.
+func decodeEventData(event *blueprint.WebhookEvent, data interface{}) bool {
+	serialized, err := json.Marshal(event.Data)
+	if err != nil {
+		return false
+	}
+	if err = json.Unmarshal(serialized, data); err != nil {
+		log.Printf("[controllers][portal][decodeEventData] error - could not decode data of event %s: %v\n", event.ID, err)
+		return false
+	}
+	return true
+}
+
+// emitTo sends a message to the socket with the id passed. It is replaced in the tests.
+var emitTo = ikisocket.EmitTo
+
+// emit sends a message to the socket with the id passed, if it is connected to this instance.
+func emit(socketId string, response *blueprint.PortalResponse) error {
+	serialized, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("[controllers][portal][emit] error - could not serialize %s message: %v\n", response.Type, err)
+		return err
+	}
+	return emitTo(socketId, serialized)
+}
@@ -0,0 +1,168 @@
+package portal
+
+import (
+	"context"
+	"encoding/json"
+	"orchdio/blueprint"
+	"orchdio/taskevents"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fromRedis returns the event as it is read from a task stream, with its data deserialized into a map.
+func fromRedis(t *testing.T, event *blueprint.WebhookEvent) *blueprint.WebhookEvent {
+	serialized, err := json.Marshal(event)
+	assert.NoError(t, err)
+	var deserialized blueprint.WebhookEvent
+	assert.NoError(t, json.Unmarshal(serialized, &deserialized))
+	return &deserialized
+}
+
+func TestUpdateProgress(t *testing.T) {
+	progress := blueprint.PortalTaskProgress{}
+
+	changed := updateProgress(&progress, fromRedis(t, blueprint.NewWebhookEvent(blueprint.PlaylistConversionMetadataEvent, "app", "task",
+		&blueprint.PlaylistConversionEventMetadata{TaskId: "task", Meta: &blueprint.PlaylistMetadata{NBTracks: 4}})))
+	assert.True(t, changed)
+
+	updateProgress(&progress, fromRedis(t, blueprint.NewWebhookEvent(blueprint.PlaylistConversionTrackEvent, "app", "task",
+		&blueprint.PlaylistTrackConversionEventResponse{TaskID: "task", Tracks: []blueprint.PlaylistTrackConversionEventPayload{
+			{Platform: "spotify"}, {Platform: "deezer"}, {Platform: "spotify"}, {Platform: "deezer"},
+		}})))
+	updateProgress(&progress, fromRedis(t, blueprint.NewWebhookEvent(blueprint.PlaylistConversionMissingTrackEvent, "app", "task",
+		&blueprint.MissingTrackEventPayload{TaskID: "task"})))
+	assert.Equal(t, blueprint.PortalTaskProgress{Total: 4, Converted: 2, Missing: 1}, progress)
+
+	changed = updateProgress(&progress, fromRedis(t, blueprint.NewWebhookEvent(blueprint.PlaylistConversionDoneEvent, "app", "task", nil)))
+	assert.False(t, changed)
+}
+
+// sentEvents records the ids of the events sent to each socket.
+type sentEvents struct {
+	mu     sync.Mutex
+	events map[string][]int64
+}
+
+func (s *sentEvents) of(socketId string) []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int64{}, s.events[socketId]...)
+}
+
+// newTestHub returns a hub on a miniredis server. The messages sent to the sockets are recorded instead.
+func newTestHub(t *testing.T) (*hub, *miniredis.Miniredis, *sentEvents) {
+	server := miniredis.RunT(t)
+	red := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	h := newHub(red)
+	t.Cleanup(func() {
+		_ = h.pubsub.Close()
+		_ = red.Close()
+	})
+
+	sent := &sentEvents{events: map[string][]int64{}}
+	previous := emitTo
+	t.Cleanup(func() { emitTo = previous })
+	emitTo = func(socketId string, message []byte, _ ...int) error {
+		var response blueprint.PortalResponse
+		require.NoError(t, json.Unmarshal(message, &response))
+		if response.Type == blueprint.PortalMessageEvent {
+			sent.mu.Lock()
+			sent.events[socketId] = append(sent.events[socketId], response.EventID)
+			sent.mu.Unlock()
+		}
+		return nil
+	}
+	return h, server, sent
+}
+
+// publish adds an event of the type to the stream of the task and returns it as it is read from the stream.
+func publish(t *testing.T, h *hub, taskId, eventType string) *taskevents.Event {
+	require.NoError(t, taskevents.Publish(h.red, blueprint.NewWebhookEvent(eventType, "app", taskId, nil)))
+	history, err := taskevents.History(context.Background(), h.red, taskId, 0)
+	require.NoError(t, err)
+	return &history[len(history)-1]
+}
+
+// subscribed returns true if the hub has a subscription of the socket to the task.
+func subscribed(h *hub, socketId, taskId string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.tasks[taskId][socketId]
+	return ok
+}
+
+func TestHubSendCatchesUpGaps(t *testing.T) {
+	h, _, sent := newTestHub(t)
+	publish(t, h, "task", blueprint.PlaylistConversionMetadataEvent)
+	h.subscribe("socket", "task", 0)
+	assert.Equal(t, []int64{1}, sent.of("socket"))
+
+	// event 2 was missed, e.g. published while the history was read: it is read from the stream before event 3.
+	publish(t, h, "task", blueprint.PlaylistConversionTrackEvent)
+	event := publish(t, h, "task", blueprint.PlaylistConversionTrackEvent)
+	h.send(h.tasks["task"]["socket"], event)
+	assert.Equal(t, []int64{1, 2, 3}, sent.of("socket"))
+}
+
+func TestHubSubscribeResumes(t *testing.T) {
+	h, _, sent := newTestHub(t)
+	publish(t, h, "task", blueprint.PlaylistConversionMetadataEvent)
+	publish(t, h, "task", blueprint.PlaylistConversionTrackEvent)
+	publish(t, h, "task", blueprint.PlaylistConversionTrackEvent)
+
+	// the client already got the events up to the 2nd one.
+	h.subscribe("socket", "task", 2)
+	assert.Equal(t, []int64{3}, sent.of("socket"))
+}
+
+func TestHubSendSkipsDuplicates(t *testing.T) {
+	h, _, sent := newTestHub(t)
+	first := publish(t, h, "task", blueprint.PlaylistConversionMetadataEvent)
+	second := publish(t, h, "task", blueprint.PlaylistConversionTrackEvent)
+	h.subscribe("socket", "task", 0)
+
+	// the events read in the history are published live too.
+	sub := h.tasks["task"]["socket"]
+	h.send(sub, first)
+	h.send(sub, second)
+	h.send(sub, second)
+	assert.Equal(t, []int64{1, 2}, sent.of("socket"))
+}
+
+func TestHubUnsubscribesOnFinalEvent(t *testing.T) {
+	h, server, sent := newTestHub(t)
+	go h.run()
+	h.subscribe("socket", "task", 0)
+	require.Eventually(t, func() bool { return server.PubSubNumSub(taskevents.Channel("task"))[taskevents.Channel("task")] == 1 },
+		time.Second, 10*time.Millisecond)
+
+	publish(t, h, "task", blueprint.PlaylistConversionMetadataEvent)
+	publish(t, h, "task", blueprint.PlaylistConversionDoneEvent)
+	// the subscription ends with the task, and so does the subscription of the instance to the channel of the task.
+	require.Eventually(t, func() bool { return !subscribed(h, "socket", "task") }, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return len(server.PubSubChannels(taskevents.Channel("task"))) == 0 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []int64{1, 2}, sent.of("socket"))
+}
+
+func TestHubUnsubscribe(t *testing.T) {
+	h, server, _ := newTestHub(t)
+	channel := taskevents.Channel("task")
+	h.subscribe("first", "task", 0)
+	h.subscribe("second", "task", 0)
+	require.Eventually(t, func() bool { return server.PubSubNumSub(channel)[channel] == 1 }, time.Second, 10*time.Millisecond)
+
+	// the channel of the task is only unsubscribed from once no socket is subscribed to the task.
+	h.unsubscribe("first", "task")
+	assert.False(t, subscribed(h, "first", "task"))
+	assert.Equal(t, 1, server.PubSubNumSub(channel)[channel])
+
+	h.unsubscribeAll("second")
+	assert.False(t, subscribed(h, "second", "task"))
+	require.Eventually(t, func() bool { return server.PubSubNumSub(channel)[channel] == 0 }, time.Second, 10*time.Millisecond)
+}
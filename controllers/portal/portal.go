@@ -0,0 +1,295 @@
+// Package portal is the real time conversion channel of the /portal websocket. Clients connect with the public key of
+// an app (or a secret key, whose scopes are checked for each message), convert tracks and playlists and subscribe to
+// the events of their playlist conversion tasks. The messages are
+// JSON, with the types in blueprint (PortalMessage*).
+package portal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"orchdio/blueprint"
+	"orchdio/controllers/platforms"
+	"orchdio/db"
+	"orchdio/logger"
+	"orchdio/middleware"
+	"orchdio/services/ratelimit"
+	"orchdio/util"
+	"strings"
+	"time"
+
+	"github.com/antoniodipinto/ikisocket"
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeartbeatInterval is how often a heartbeat message is sent to the clients, and how often clients should send a
+	// ping when they have nothing else to send.
+	HeartbeatInterval = 25 * time.Second
+	// IdleTimeout is how long a client can go without sending any message before it is disconnected.
+	IdleTimeout = 3 * HeartbeatInterval
+)
+
+// Portal is the controller of the portal websocket.
+type Portal struct {
+	DB        *sqlx.DB
+	Redis     *redis.Client
+	Platforms *platforms.Platforms
+	// Limiter limits the conversions of the apps on their plan, the same as the http routes of the conversions.
+	Limiter *ratelimit.Limiter
+	hub     *hub
+}
+
+func NewPortal(db *sqlx.DB, red *redis.Client, platformsController *platforms.Platforms, limiter *ratelimit.Limiter) *Portal {
+	return &Portal{DB: db, Redis: red, Platforms: platformsController, Limiter: limiter, hub: newHub(red)}
+}
+
+// Listen registers the handlers of the messages of the portal sockets and starts sending the events of the tasks
+// subscribed to. It must be called once.
+func (p *Portal) Listen() {
+	ikisocket.On(ikisocket.EventMessage, p.HandleMessage)
+	ikisocket.On(ikisocket.EventDisconnect, p.HandleDisconnect)
+	go p.hub.run()
+}
+
+// Connect is called when a client connects. The app is set on the request by the auth middleware, from the public key or
+// a secret key. The scopes of secret keys are checked for each message, see allowed.
+func (p *Portal) Connect(kws *ikisocket.Websocket) {
+	app, ok := kws.Locals("app").(*blueprint.DeveloperApp)
+	if !ok || app == nil {
+		log.Printf("[controllers][portal][Connect] error - no app for client %s. Closing connection\n", kws.UUID)
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, Error: "unauthorized"})
+		kws.Close()
+		return
+	}
+
+	log.Printf("[controllers][portal][Connect] - client %s of app %s connected\n", kws.UUID, app.UID.String())
+	kws.SetAttribute("app", app)
+	if key, ok := kws.Locals("app_key").(*blueprint.AppKey); ok && key != nil {
+		kws.SetAttribute("app_key", key)
+	}
+	kws.SetAttribute("last_seen", time.Now())
+	p.reply(kws, &blueprint.PortalResponse{
+		Type: blueprint.PortalMessageConnected,
+		Data: &blueprint.PortalConnected{ID: kws.UUID, App: app.UID.String(), HeartbeatInterval: int(HeartbeatInterval.Seconds())},
+	})
+	go p.keepAlive(kws)
+}
+
+// keepAlive sends a heartbeat to the client every HeartbeatInterval and closes the connection when the client has been
+// idle for longer than IdleTimeout.
+func (p *Portal) keepAlive(kws *ikisocket.Websocket) {
+	ticker := time.NewTicker(HeartbeatInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if !kws.IsAlive() {
+			return
+		}
+		lastSeen, _ := kws.GetAttribute("last_seen").(time.Time)
+		if time.Since(lastSeen) > IdleTimeout {
+			log.Printf("[controllers][portal][keepAlive] - client %s is idle. Closing connection\n", kws.UUID)
+			p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, Error: "idle timeout"})
+			kws.Close()
+			return
+		}
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageHeartbeat})
+	}
+}
+
+// HandleMessage handles a message sent by a client.
+func (p *Portal) HandleMessage(ep *ikisocket.EventPayload) {
+	kws := ep.Kws
+	app, ok := kws.GetAttribute("app").(*blueprint.DeveloperApp)
+	if !ok {
+		return
+	}
+	kws.SetAttribute("last_seen", time.Now())
+
+	var request blueprint.PortalRequest
+	if err := json.Unmarshal(ep.Data, &request); err != nil {
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, Error: "invalid message. messages must be JSON"})
+		return
+	}
+
+	switch request.Type {
+	case blueprint.PortalMessagePing:
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessagePong, ID: request.ID})
+	case blueprint.PortalMessageConvert:
+		// conversions can take a while, the socket keeps reading messages in the meantime.
+		go p.convert(kws, app, &request)
+	case blueprint.PortalMessageSubscribe:
+		if !p.allowed(kws, &request, blueprint.ScopeTasksRead) {
+			return
+		}
+		p.subscribe(kws, app, &request)
+	case blueprint.PortalMessageUnsubscribe:
+		p.hub.unsubscribe(kws.UUID, request.TaskID)
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageUnsubscribed, ID: request.ID, TaskID: request.TaskID})
+	default:
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, Error: fmt.Sprintf("unknown message type %q", request.Type)})
+	}
+}
+
+// HandleDisconnect unsubscribes a client from its tasks when it is disconnected.
+func (p *Portal) HandleDisconnect(ep *ikisocket.EventPayload) {
+	log.Printf("[controllers][portal][HandleDisconnect] - client %s disconnected\n", ep.SocketUUID)
+	p.hub.unsubscribeAll(ep.SocketUUID)
+}
+
+// convert converts the track in the message, or queues the conversion of the playlist in the message and subscribes the
+// client to the events of the conversion task.
+func (p *Portal) convert(kws *ikisocket.Websocket, app *blueprint.DeveloperApp, request *blueprint.PortalRequest) {
+	linkInfo, bodyErr := middleware.LinkInfoFromConversionBody(app, &blueprint.ConversionBody{URL: request.URL, TargetPlatform: request.TargetPlatform}, p.Platforms.Config.Platforms)
+	if bodyErr != nil {
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, Error: bodyErr.Message})
+		return
+	}
+
+	// the id of the request that opened the socket is used for the logs of its conversions.
+	requestId, _ := kws.Locals(logger.RequestIDKey).(string)
+	ctx := logger.WithRequest(context.Background(), requestId, logger.L().With(zap.String(logger.FieldRequestID, requestId),
+		zap.String(logger.FieldAppID, app.UID.String()), zap.String("socket", kws.UUID)))
+
+	switch {
+	case strings.Contains(linkInfo.Entity, "track"):
+		if !p.allowed(kws, request, blueprint.ScopeConvertTrack) || !p.withinLimits(ctx, kws, app, request, ratelimit.BudgetTrackConversions) {
+			return
+		}
+		conversion, err := p.Platforms.TrackConversion(ctx, linkInfo, app)
+		if err != nil {
+			log.Printf("[controllers][portal][convert] error - could not convert track %s: %v\n", request.URL, err)
+			p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, Error: conversionErrorMessage(err)})
+			return
+		}
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageConversion, ID: request.ID, Data: conversion})
+		// the conversions in the sandbox are not usage of the app.
+		if app.Sandbox {
+			return
+		}
+		database := db.NewDB{DB: p.DB}
+		_ = database.RecordAppUsage(&blueprint.UsageEvent{
+			App:      app.UID.String(),
+			Event:    blueprint.UsageTrackConversion,
+			Platform: linkInfo.TargetPlatform,
+			Endpoint: "/portal",
+			Count:    1,
+		})
+
+	case strings.Contains(linkInfo.Entity, "playlist"):
+		if !p.allowed(kws, request, blueprint.ScopeConvertPlaylist) ||
+			!p.withinLimits(ctx, kws, app, request, ratelimit.BudgetPlaylistConversions, ratelimit.BudgetTracksConverted) {
+			return
+		}
+		task, err := p.Platforms.QueuePlaylistConversion(ctx, linkInfo, app)
+		if err != nil {
+			p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, Error: err.Error()})
+			return
+		}
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageTask, ID: request.ID, TaskID: task.TaskID, Data: task})
+		p.hub.subscribe(kws.UUID, task.TaskID, 0)
+
+	default:
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, Error: "Invalid URL"})
+	}
+}
+
+// subscribe subscribes the client to the events of the task in the message, if the task belongs to the app.
+func (p *Portal) subscribe(kws *ikisocket.Websocket, app *blueprint.DeveloperApp, request *blueprint.PortalRequest) {
+	if !util.IsValidUUID(request.TaskID) {
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, TaskID: request.TaskID, Error: "invalid task id"})
+		return
+	}
+
+	database := db.NewDB{DB: p.DB}
+	task, err := database.FetchTask(request.TaskID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("[controllers][portal][subscribe] error - could not fetch task %s: %v\n", request.TaskID, err)
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, TaskID: request.TaskID, Error: "An internal error occurred"})
+		return
+	}
+	if err != nil || (task.App != "" && task.App != app.UID.String()) {
+		p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, TaskID: request.TaskID, Error: "task not found"})
+		return
+	}
+
+	p.reply(kws, &blueprint.PortalResponse{
+		Type:   blueprint.PortalMessageSubscribed,
+		ID:     request.ID,
+		TaskID: request.TaskID,
+		Data:   &blueprint.PortalSubscription{TaskID: request.TaskID, Status: task.Status},
+	})
+	p.hub.subscribe(kws.UUID, request.TaskID, request.LastEventID)
+}
+
+// allowed returns true if the key the client connected with has the scopes the message needs, the same as the http
+// routes of the message (see middleware.RequireScopes). Otherwise, the client is sent an error.
+func (p *Portal) allowed(kws *ikisocket.Websocket, request *blueprint.PortalRequest, scopes ...string) bool {
+	key, _ := kws.GetAttribute("app_key").(*blueprint.AppKey)
+	missing := middleware.MissingScopes(key, scopes...)
+	if len(missing) == 0 {
+		return true
+	}
+	log.Printf("[controllers][portal][allowed] key %s is missing scopes %v for a %s message\n", key.UID, missing, request.Type)
+	p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, TaskID: request.TaskID,
+		Error: fmt.Sprintf("This key is missing the scopes: %s", strings.Join(missing, ", "))})
+	return false
+}
+
+// withinLimits counts the message against the budgets of the app, see limitExceeded. If a budget refuses it, the client
+// is sent an error with the budget and when it resets.
+func (p *Portal) withinLimits(ctx context.Context, kws *ikisocket.Websocket, app *blueprint.DeveloperApp, request *blueprint.PortalRequest, budgets ...string) bool {
+	result := p.limitExceeded(ctx, app, budgets...)
+	if result == nil {
+		return true
+	}
+	p.reply(kws, &blueprint.PortalResponse{Type: blueprint.PortalMessageError, ID: request.ID, Data: result,
+		Error: fmt.Sprintf("Rate limit exceeded for %s. Try again after %s", result.Budget, result.Reset.Format(time.RFC3339))})
+	return false
+}
+
+// limitExceeded counts a message of the app against its requests budget and the budgets passed, the same as the http
+// routes of the message (see middleware.RateLimitMiddleware), and returns the result of the budget that refuses it, if
+// any. If Redis fails, the message is let through.
+func (p *Portal) limitExceeded(ctx context.Context, app *blueprint.DeveloperApp, budgets ...string) *ratelimit.Result {
+	appId := app.UID.String()
+	results, err := p.Limiter.Allow(ctx, ratelimit.SandboxID(appId, app.Sandbox), app.Plan, append([]string{ratelimit.BudgetRequests}, budgets...), "")
+	if err != nil {
+		log.Printf("[controllers][portal][limitExceeded] error - could not check the budgets of app %s: %v\n", appId, err)
+		return nil
+	}
+	for _, result := range results {
+		if !result.Allowed {
+			log.Printf("[controllers][portal][limitExceeded] app %s is over its %s budget of %d. It resets at %s\n", appId, result.Budget, result.Limit, result.Reset.Format(time.RFC3339))
+			return result
+		}
+	}
+	return nil
+}
+
+func (p *Portal) reply(kws *ikisocket.Websocket, response *blueprint.PortalResponse) {
+	serialized, err := json.Marshal(response)
+	if err != nil {
+		log.Printf("[controllers][portal][reply] error - could not serialize %s message: %v\n", response.Type, err)
+		return
+	}
+	kws.Emit(serialized)
+}
+
+// conversionErrorMessage returns the message sent to the client for an error of a track conversion.
+func conversionErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, blueprint.ErrNotImplemented):
+		return "Not implemented"
+	case strings.Contains(err.Error(), "credentials not provided"):
+		return fmt.Sprintf("%s. Please update your app with the missing platform's credentials.", err.Error())
+	case errors.Is(err, platforms.ErrTrackNotConverted):
+		return "Track not found"
+	default:
+		return "An internal error occurred"
+	}
+}
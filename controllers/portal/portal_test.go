@@ -0,0 +1,33 @@
+package portal
+
+import (
+	"context"
+	"orchdio/blueprint"
+	"orchdio/services/ratelimit"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitExceeded(t *testing.T) {
+	server := miniredis.RunT(t)
+	p := &Portal{Limiter: ratelimit.NewLimiter(redis.NewClient(&redis.Options{Addr: server.Addr()}), map[string]ratelimit.Plan{
+		ratelimit.PlanFree: {ratelimit.BudgetRequests: 10, ratelimit.BudgetTrackConversions: 1},
+	})}
+	app := &blueprint.DeveloperApp{UID: uuid.New(), Plan: ratelimit.PlanFree}
+
+	assert.Nil(t, p.limitExceeded(context.Background(), app, ratelimit.BudgetTrackConversions))
+	result := p.limitExceeded(context.Background(), app, ratelimit.BudgetTrackConversions)
+	require.NotNil(t, result)
+	assert.Equal(t, ratelimit.BudgetTrackConversions, result.Budget)
+	assert.False(t, result.Reset.IsZero())
+
+	// the refused message did not use the requests budget up: only the first message and this check are counted.
+	results, err := p.Limiter.Allow(context.Background(), app.UID.String(), app.Plan, []string{ratelimit.BudgetRequests}, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), results[0].Remaining)
+}
@@ -0,0 +1,40 @@
+package db
+
+import (
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+// CreateAuditLogEntry appends an entry to the audit log.
+func (d *NewDB) CreateAuditLogEntry(entry *blueprint.AuditLogEntry) error {
+	_, err := d.DB.Exec(queries.CreateAuditLogEntry, entry.UID, entry.Org, entry.Actor, entry.Action, entry.TargetType,
+		entry.TargetID, nullableJSON(entry.Before), nullableJSON(entry.After), entry.IP, entry.RequestID)
+	if err != nil {
+		log.Printf("[db][CreateAuditLogEntry] error - could not record %s of %s %s: %v\n", entry.Action, entry.TargetType, entry.TargetID, err)
+		return err
+	}
+	return nil
+}
+
+// FetchAuditLog fetches a page of the audit log of an org, the most recent entries first.
+func (d *NewDB) FetchAuditLog(orgId string, filter *blueprint.AuditLogFilter) ([]blueprint.AuditLogEntry, error) {
+	entries := make([]blueprint.AuditLogEntry, 0)
+	err := d.DB.Select(&entries, queries.FetchAuditLog, orgId, filter.Actor, filter.Action, filter.TargetType, filter.TargetID,
+		filter.From, filter.To, filter.Cursor, filter.Limit)
+	if err != nil {
+		log.Printf("[db][FetchAuditLog] error - could not fetch the audit log of org %s: %v\n", orgId, err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+// nullableJSON returns the JSON as a query argument, nil (NULL) if it is empty.
+func nullableJSON(j types.JSONText) interface{} {
+	if len(j) == 0 {
+		return nil
+	}
+	return string(j)
+}
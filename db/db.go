@@ -7,20 +7,26 @@ import (
 	"log"
 	"orchdio/blueprint"
 	"orchdio/db/queries"
+	"orchdio/internal/encryption"
 	"orchdio/util"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"github.com/samber/lo"
 )
 
 // NewDB represents a new DB layer struct for performing DB related operations
 type NewDB struct {
 	DB *sqlx.DB
+	// Envelope encrypts and decrypts the integration credentials of the apps (see UpdateApp).
+	Envelope *encryption.Envelope
 }
 
+// ConnectDB opens the database. If the database does not answer, the database is returned with the error, so that the
+// caller can start and report it (see health.Postgres): the connections are opened again when it answers.
 func ConnectDB(dbURL string) (*sqlx.DB, error) {
 	// "postgres://kauffman@localhost:5432/orchdio_test"
 	dbase, err := sqlx.Open("postgres", dbURL)
@@ -33,7 +39,7 @@ func ConnectDB(dbURL string) (*sqlx.DB, error) {
 	err = dbase.Ping()
 	if err != nil {
 		log.Print("COULD NOT PING DATABASE")
-		return nil, err
+		return dbase, err
 	}
 
 	return dbase, nil
@@ -427,16 +433,89 @@ func (d *NewDB) FetchFollowByEntityID(entityId string) (*blueprint.FollowTask, e
 	return &res, nil
 }
 
-func (d *NewDB) CreateFollowNotification(user, followID string, data interface{}) error {
-	_, execErr := d.DB.Exec(queries.CreateFollowNotification, user, followID, data)
+func (d *NewDB) CreateFollowNotification(user, app, notificationID string, data interface{}) error {
+	_, execErr := d.DB.NamedExec(queries.CreateFollowNotification, map[string]interface{}{
+		"subscriber":      user,
+		"notification_id": notificationID,
+		"app":             app,
+		"data":            data,
+	})
 	if execErr != nil {
 		log.Printf("[db][CreateNewFollowNotification] error creating new follow notification. %v\n", execErr)
 		return execErr
 	}
-	log.Printf("[db][CreateNewFollowNotification] created new follow notification for playlist %s and subscriber %s\n", followID, user)
+	log.Printf("[db][CreateNewFollowNotification] created new follow notification %s for subscriber %s\n", notificationID, user)
 	return nil
 }
 
+// FetchUserNotifications fetches the notifications of a user for an app, matching the filter passed. It returns the
+// page of notifications and the total number of notifications that match the filter.
+func (d *NewDB) FetchUserNotifications(user, app string, filter *blueprint.NotificationFilter) ([]blueprint.Notification, int, error) {
+	var total int
+	err := d.DB.Get(&total, queries.CountUserNotifications, user, app, filter.Status, filter.Since)
+	if err != nil {
+		log.Printf("[db][FetchUserNotifications] error counting user notifications. %v\n", err)
+		return nil, 0, err
+	}
+
+	notifications := make([]blueprint.Notification, 0)
+	err = d.DB.Select(&notifications, queries.FetchUserNotifications, user, app, filter.Status, filter.Since, filter.Limit, filter.Offset)
+	if err != nil {
+		log.Printf("[db][FetchUserNotifications] error fetching user notifications. %v\n", err)
+		return nil, 0, err
+	}
+	log.Printf("[db][FetchUserNotifications] fetched %d of %d notifications for user %s\n", len(notifications), total, user)
+	return notifications, total, nil
+}
+
+// MarkNotificationAsRead marks a single notification of a user as read. It returns sql.ErrNoRows if the
+// notification does not exist for the user and app.
+func (d *NewDB) MarkNotificationAsRead(notificationID, user, app string) error {
+	var res string
+	err := d.DB.QueryRowx(queries.MarkNotificationAsRead, notificationID, user, app).Scan(&res)
+	if err != nil {
+		log.Printf("[db][MarkNotificationAsRead] error marking notification as read. %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// MarkNotificationsAsRead marks the notifications passed as read. If no notification ids are passed, all the
+// unread notifications of the user are marked as read. It returns the number of notifications updated.
+func (d *NewDB) MarkNotificationsAsRead(user, app string, notificationIDs []string) (int64, error) {
+	if notificationIDs == nil {
+		notificationIDs = []string{}
+	}
+	r, err := d.DB.Exec(queries.MarkNotificationsAsRead, user, app, pq.Array(notificationIDs))
+	if err != nil {
+		log.Printf("[db][MarkNotificationsAsRead] error marking notifications as read. %v\n", err)
+		return 0, err
+	}
+	count, err := r.RowsAffected()
+	if err != nil {
+		log.Printf("[db][MarkNotificationsAsRead] error fetching number of updated notifications. %v\n", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteStaleNotifications deletes the read notifications created before readBefore and every notification created before
+// allBefore. It is used to clean up old notifications and returns the number of notifications deleted.
+func (d *NewDB) DeleteStaleNotifications(readBefore, allBefore time.Time) (int64, error) {
+	r, err := d.DB.Exec(queries.DeleteStaleNotifications, readBefore, allBefore)
+	if err != nil {
+		log.Printf("[db][DeleteStaleNotifications] error deleting stale notifications. %v\n", err)
+		return 0, err
+	}
+	count, err := r.RowsAffected()
+	if err != nil {
+		log.Printf("[db][DeleteStaleNotifications] error fetching number of deleted notifications. %v\n", err)
+		return 0, err
+	}
+	log.Printf("[db][DeleteStaleNotifications] deleted %d stale notifications\n", count)
+	return count, nil
+}
+
 // UpdateFollowSubscriber adds a subscriber to a follow task if they already haven't been added
 func (d *NewDB) UpdateFollowSubscriber(subscriber, entityId string) ([]byte, error) {
 	r := d.DB.QueryRowx(queries.UpdateFollowSubscriber, subscriber, entityId)
@@ -506,18 +585,34 @@ func (d *NewDB) AlreadyInWaitList(user string) bool {
 	return true
 }
 
-// CreateOrg creates a new org in the database
+// CreateOrg creates a new org in the database, with the owner as its first member.
 func (d *NewDB) CreateOrg(uid, name, description, owner string) ([]byte, error) {
-	r := d.DB.QueryRowx(queries.CreateNewOrg, uid, name, description, owner)
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][CreateOrg] error starting transaction. %v\n", err)
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	r := tx.QueryRowx(queries.CreateNewOrg, uid, name, description, owner)
 	var res string
 
 	// note that Scan is for single fields, in this case just the newly created org id.
 	// structScan is for structs/json objects.
-	err := r.Scan(&res)
+	err = r.Scan(&res)
 	if err != nil {
 		log.Printf("[db][CreateOrg] error creating new org. %v\n", err)
 		return nil, err
 	}
+	_, err = tx.Exec(queries.AddOrgMember, res, owner, blueprint.OrgRoleOwner)
+	if err != nil {
+		log.Printf("[db][CreateOrg] error adding the owner to the new org. %v\n", err)
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Printf("[db][CreateOrg] error committing new org. %v\n", err)
+		return nil, err
+	}
 	log.Printf("[db][CreateOrg] created new org %s\n", res)
 	return []byte(res), nil
 }
@@ -534,8 +629,8 @@ func (d *NewDB) DeleteOrg(uid, owner string) error {
 }
 
 // UpdateOrg updates an org in the database
-func (d *NewDB) UpdateOrg(appId, owner string, data *blueprint.UpdateOrganizationData) error {
-	_, err := d.DB.Exec(queries.UpdateOrg, data.Description, data.Name, appId, owner)
+func (d *NewDB) UpdateOrg(appId string, data *blueprint.UpdateOrganizationData) error {
+	_, err := d.DB.Exec(queries.UpdateOrg, data.Description, data.Name, appId)
 	if err != nil {
 		log.Printf("[db][UpdateOrg] error updating org. %v\n", err)
 		return err
@@ -544,7 +639,7 @@ func (d *NewDB) UpdateOrg(appId, owner string, data *blueprint.UpdateOrganizatio
 	return nil
 }
 
-// FetchOrg fetches the org belonging to a user. Orgs are limited to 1 for now, for each user.
+// FetchOrg fetches the org of a user: the one they own or, if they own none, the last one they joined.
 func (d *NewDB) FetchOrg(owner string) (*blueprint.Organization, error) {
 	row := d.DB.QueryRowx(queries.FetchUserOrg, owner)
 	var res blueprint.Organization
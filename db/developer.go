@@ -8,21 +8,21 @@ import (
 	"orchdio/blueprint"
 	"orchdio/constants"
 	"orchdio/db/queries"
-	"orchdio/util"
-	"os"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 	"github.com/samber/lo"
 )
 
 // CreateNewApp creates a new app for the developer and returns a uuid of the newly created app
-func (d *NewDB) CreateNewApp(name, description, redirectURL, webhookURL, publicKey, developerId, secretKey, verifySecret, orgID, deezerState string) ([]byte, error) {
+func (d *NewDB) CreateNewApp(name, description, redirectURL, webhookURL, publicKey, developerId, verifySecret, orgID, deezerState string) ([]byte, error) {
 	log.Printf("[db][CreateNewApp] developer -  creating new app: %s\n", name)
 	// create a new app
 	uid := uuid.NewString()
 	_, err := d.DB.Exec(queries.CreateNewApp, uid,
 		name, description, redirectURL, webhookURL, publicKey,
-		developerId, secretKey, verifySecret, orgID, deezerState)
+		developerId, verifySecret, orgID, deezerState)
 	if err != nil {
 		log.Printf("[db][CreateNewApp] developer -  error: could not create new developer app: %v\n", err)
 		return nil, err
@@ -96,23 +96,6 @@ func (d *NewDB) FetchAppByPublicKeyWithoutDevId(pubKey string) (*blueprint.Devel
 	return &app, nil
 }
 
-// FetchAppBySecretKey fetches an app by its secret key, the secret key is the api key, as its called for clients. In the backend, we simply call it secret key (much more nuanced)
-func (d *NewDB) FetchAppBySecretKey(secretKey []byte) (*blueprint.DeveloperApp, error) {
-	log.Printf("[db][FetchAppByDeveloperId] developer -  fetching apps by developer id:\n")
-	var app blueprint.DeveloperApp
-	err := d.DB.QueryRowx(queries.FetchAppBySecretKey, secretKey).StructScan(&app)
-	if err != nil {
-		log.Printf("[db][FetchAppByDeveloperId] developer -  error: could not fetch apps by developer id: %v\n", err)
-		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("[db][FetchAppByDeveloperId] developer - App does not exist")
-			return nil, sql.ErrNoRows
-		}
-		return nil, err
-	}
-	log.Printf("[db][FetchAppByDeveloperId] developer -  apps fetched: %s\n", app.Name)
-	return &app, nil
-}
-
 // UpdateApp updates an app with the passed data. It does an upsert and fields that want to be updated need to be passed.
 func (d *NewDB) UpdateApp(appId, platform, developer string, app blueprint.UpdateDeveloperAppData) (*blueprint.DeveloperApp, error) {
 	log.Printf("[db][UpdateApp] developer -  updating app: %s\n", appId)
@@ -146,7 +129,7 @@ func (d *NewDB) UpdateApp(appId, platform, developer string, app blueprint.Updat
 	if string(outByte) != "" {
 		log.Printf("[db][UpdateApp] developer  - No integration credentials found for app for platform %s %s\n", platform, appId)
 		// decrypt the credentials
-		decryptedData, decryptErr := util.Decrypt(outByte, []byte(os.Getenv("ENCRYPTION_SECRET")))
+		decryptedData, decryptErr := d.Envelope.Decrypt(outByte)
 		if decryptErr != nil {
 			log.Printf("[db][UpdateApp] developer -  error: could not update app. Could not decrypt existing credentials for platform %s: %v\n", err, platform)
 			return nil, decryptErr
@@ -194,7 +177,7 @@ func (d *NewDB) UpdateApp(appId, platform, developer string, app blueprint.Updat
 		return nil, err
 	}
 
-	encryptedData, encryptErr := util.Encrypt(credentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+	encryptedData, encryptErr := d.Envelope.Encrypt(credentials)
 	if encryptErr != nil {
 		log.Printf("[db][UpdateApp] developer -  error: could not update app: could not encrypt the credentials %v\n", err)
 		return nil, encryptErr
@@ -233,15 +216,15 @@ func (d *NewDB) DeleteApp(appId, developer string) error {
 	return nil
 }
 
-// FetchDeveloperAppWithSecretKey fetches a developer for an authorized app, meaning the app is active.
-func (d *NewDB) FetchDeveloperAppWithSecretKey(secretKey string) (*blueprint.User, error) {
-	log.Printf("[db][FetchAuthorizedDeveloperApp] developer -  fetching authorized developer app by secret key:\n")
+// FetchAuthorizedAppDeveloper fetches the developer of an authorized app, meaning the app is active.
+func (d *NewDB) FetchAuthorizedAppDeveloper(appId string) (*blueprint.User, error) {
+	log.Printf("[db][FetchAuthorizedDeveloperApp] developer -  fetching developer of authorized app: %s\n", appId)
 	var developer blueprint.User
-	err := d.DB.QueryRowx(queries.FetchAuthorizedAppDeveloperBySecretKey, secretKey).StructScan(&developer)
+	err := d.DB.QueryRowx(queries.FetchAuthorizedAppDeveloperByAppID, appId).StructScan(&developer)
 	if err != nil {
 		log.Printf("[db][FetchAuthorizedDeveloperApp] developer -  error: could not fetch authorized developer app: %v\n", err)
 		if errors.Is(err, sql.ErrNoRows) {
-			log.Printf("[db][FetchAuthorizedDeveloperApp][fetchbysecret] developer - App does not exist %v\n", err)
+			log.Printf("[db][FetchAuthorizedDeveloperApp] developer - App does not exist %v\n", err)
 			return nil, sql.ErrNoRows
 		}
 		return nil, err
@@ -304,11 +287,11 @@ func (d *NewDB) FetchAppKeys(appId, developer string) (*blueprint.AppKeys, error
 	return &keys, nil
 }
 
-// FetchApps fetches all the apps that belong to a developer.
-func (d *NewDB) FetchApps(developerId, orgID string) (*[]blueprint.AppInfo, error) {
-	log.Printf("[db][FetchAppKeys] developer - fetching apps that belong to developer and org: %s %s\n", developerId, orgID)
+// FetchApps fetches all the apps of an org, whichever of its members created them.
+func (d *NewDB) FetchApps(orgID string) (*[]blueprint.AppInfo, error) {
+	log.Printf("[db][FetchAppKeys] developer - fetching apps that belong to org: %s\n", orgID)
 	var apps []blueprint.AppInfo
-	rows, err := d.DB.Queryx(queries.FetchAppsByDeveloper, developerId, orgID)
+	rows, err := d.DB.Queryx(queries.FetchAppsByOrganization, orgID)
 	if err != nil {
 		log.Printf("[db][FetchAppKeys] developer - error: could not fetch apps that belong to developer: %v\n", err)
 		return nil, err
@@ -335,18 +318,19 @@ func (d *NewDB) FetchApps(developerId, orgID string) (*[]blueprint.AppInfo, erro
 	}
 
 	if len(apps) == 0 {
-		log.Printf("[db][FetchAppKeys] developer - error: no apps found for developer: %s\n", developerId)
+		log.Printf("[db][FetchAppKeys] developer - error: no apps found for org: %s\n", orgID)
 		return nil, sql.ErrNoRows
 	}
 
-	log.Printf("[db][FetchAppKeys] developer - apps fetched: %s\n", developerId)
+	log.Printf("[db][FetchAppKeys] developer - apps fetched: %s\n", orgID)
 	return &apps, nil
 }
 
-// UpdateAppKeys updates the public and secret keys associated with an app. It also updates the verify secret key for webhook verification
-func (d *NewDB) UpdateAppKeys(publicKey, secretKey, verifySecret, appId, deezerState string) error {
+// UpdateAppKeys updates the public key associated with an app. It also updates the verify secret key for webhook verification.
+// Secret keys are managed separately, see CreateAppKey.
+func (d *NewDB) UpdateAppKeys(publicKey, verifySecret, appId, deezerState string) error {
 	log.Printf("[db][UpdateAppKeys] developer - updating app keys: %s\n", publicKey)
-	_, err := d.DB.Exec(queries.UpdateAppKeys, publicKey, secretKey, verifySecret, deezerState, appId)
+	_, err := d.DB.Exec(queries.UpdateAppKeys, publicKey, verifySecret, deezerState, appId)
 	if err != nil {
 		log.Printf("[db][UpdateAppKeys] developer - error: could not update app keys: %v\n", err)
 		return err
@@ -355,17 +339,6 @@ func (d *NewDB) UpdateAppKeys(publicKey, secretKey, verifySecret, appId, deezerS
 	return nil
 }
 
-func (d *NewDB) RevokeSecretKey(appId, newSecret string) error {
-	log.Printf("[db][RevokeSecretKey] developer - revoking secret key: %s\n", appId)
-	_, err := d.DB.Exec(queries.RevokeSecretKey, appId, newSecret)
-	if err != nil {
-		log.Printf("[db][RevokeSecretKey] developer - error: could not revoke secret key: %v\n", err)
-		return err
-	}
-	log.Printf("[db][RevokeSecretKey] developer - secret key revoked: %s\n", appId)
-	return nil
-}
-
 func (d *NewDB) RevokeVerifySecret(appId, newVerifyToken string) error {
 	log.Printf("[db][RevokeVerifySecret] developer - revoking verify secret: %s\n", appId)
 	_, err := d.DB.Exec(queries.RevokeVerifySecret, appId, newVerifyToken)
@@ -449,15 +422,109 @@ func (d *NewDB) UpdateWebhookAppID(devAppId, webhookAppId string) error {
 	return nil
 }
 
-func (d *NewDB) UpdateUserAppAuthTokens(userId, accessToken, expiry, platform string, encryptedRefreshToken []byte) error {
-	_, err := d.DB.Exec(queries.UpdateOAuthTokens, encryptedRefreshToken, expiry, accessToken, userId, platform)
+// FetchUserAppTokens fetches the OAuth tokens of the user on the platform, for the app.
+func (d *NewDB) FetchUserAppTokens(userId, appId, platform string) (*blueprint.UserAppTokens, error) {
+	var tokens blueprint.UserAppTokens
+	err := d.DB.QueryRowx(queries.FetchUserAppTokens, userId, appId, platform).StructScan(&tokens)
+	if err != nil {
+		log.Printf("[db][FetchUserAppTokens] developer - error: could not fetch %s tokens of user %s: %v\n", platform, userId, err)
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+// RotateUserAppTokens saves the refreshed tokens of a user app, if its refresh token is still previousRefreshToken. It
+// returns false if the tokens were changed in the meantime (e.g. the user authorized the app again).
+func (d *NewDB) RotateUserAppTokens(userAppId string, previousRefreshToken, refreshToken []byte, accessToken string, expiresIn time.Time) (bool, error) {
+	res, err := d.DB.Exec(queries.RotateUserAppTokens, refreshToken, accessToken, expiresIn, userAppId, previousRefreshToken)
+	if err != nil {
+		log.Printf("[db][RotateUserAppTokens] developer - error: could not update tokens of user app %s: %v\n", userAppId, err)
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("[db][RotateUserAppTokens] developer - error: could not get the updated rows of user app %s: %v\n", userAppId, err)
+		return false, err
+	}
+	return count == 1, nil
+}
+
+// MarkUserAppReauthRequired marks the user app of the user on the platform, for the app, as needing the user to connect
+// the platform again. It returns the scopes of the user app and whether it was already marked.
+func (d *NewDB) MarkUserAppReauthRequired(userId, appId, platform string) ([]string, bool, error) {
+	var res struct {
+		Scopes        pq.StringArray `db:"scopes"`
+		AlreadyMarked bool           `db:"already_marked"`
+	}
+	err := d.DB.QueryRowx(queries.MarkUserAppReauthRequired, userId, appId, platform).StructScan(&res)
+	if err != nil {
+		log.Printf("[db][MarkUserAppReauthRequired] developer - error: could not mark %s of user %s as needing re-auth: %v\n", platform, userId, err)
+		return nil, false, err
+	}
+	return res.Scopes, res.AlreadyMarked, nil
+}
+
+// DisconnectUserApp wipes the tokens and scopes of the user on the platform, for the app. It returns sql.ErrNoRows if
+// the user has not connected the platform.
+func (d *NewDB) DisconnectUserApp(userId, appId, platform string) error {
+	var userAppId string
+	err := d.DB.QueryRowx(queries.DisconnectUserApp, userId, appId, platform).Scan(&userAppId)
+	if err != nil {
+		log.Printf("[db][DisconnectUserApp] developer - error: could not disconnect %s of user %s: %v\n", platform, userId, err)
+		return err
+	}
+	log.Printf("[db][DisconnectUserApp] developer - disconnected user app %s\n", userAppId)
+	return nil
+}
+
+// DeleteUserAppData erases the data of the user for the app: their tasks, follows, notifications and user apps. The user
+// is deleted too if they have not connected any other app. It returns sql.ErrNoRows if the user has no user app for
+// the app.
+func (d *NewDB) DeleteUserAppData(userId, appId string) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][DeleteUserAppData] developer - error: could not start transaction: %v\n", err)
+		return err
+	}
+	// rollback is a no-op after the transaction is committed.
+	defer tx.Rollback()
 
+	res, err := tx.Exec(queries.DeleteUserApps, userId, appId)
+	if err != nil {
+		log.Printf("[db][DeleteUserAppData] developer - error: could not delete user apps of user %s: %v\n", userId, err)
+		return err
+	}
+	count, err := res.RowsAffected()
 	if err != nil {
-		log.Println("Could not update refresh token in DB")
+		log.Printf("[db][DeleteUserAppData] developer - error: could not get the deleted user apps of user %s: %v\n", userId, err)
 		return err
 	}
+	if count == 0 {
+		return sql.ErrNoRows
+	}
 
-	log.Println("DEBUG::: updated user app auth tokens")
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{queries.DeleteUserAppTasks, []interface{}{userId, appId}},
+		{queries.RemoveUserFromAppFollows, []interface{}{userId, appId}},
+		{queries.DeleteUnsubscribedAppFollows, []interface{}{appId}},
+		{queries.DeleteUserAppNotifications, []interface{}{userId, appId}},
+		{queries.DeleteOrphanUser, []interface{}{userId}},
+	}
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement.query, statement.args...); err != nil {
+			log.Printf("[db][DeleteUserAppData] developer - error: could not erase data of user %s: %v\n", userId, err)
+			return err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[db][DeleteUserAppData] developer - error: could not commit erasure of user %s: %v\n", userId, err)
+		return err
+	}
+	log.Printf("[db][DeleteUserAppData] developer - erased data of user %s for app %s\n", userId, appId)
 	return nil
 }
 
@@ -470,3 +537,17 @@ func (d *NewDB) UpdateUserAppAuthTokens(userId, accessToken, expiry, platform st
 
 // 	return nil
 // }
+
+// UpdateAppWebhookBatchSettings updates the batching of the playlist conversion track webhook events of an app.
+func (d *NewDB) UpdateAppWebhookBatchSettings(appId, developer string, size, intervalMs int) error {
+	log.Printf("[db][UpdateAppWebhookBatchSettings] developer - updating webhook batch settings for app: %s\n", appId)
+	res, err := d.DB.Exec(queries.UpdateAppWebhookBatchSettings, appId, size, intervalMs, developer)
+	if err != nil {
+		log.Printf("[db][UpdateAppWebhookBatchSettings] developer - error: could not update webhook batch settings: %v\n", err)
+		return err
+	}
+	if count, _ := res.RowsAffected(); count == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+)
+
+// FetchAppsCredentialsPage returns up to limit apps after the app with the id after (an empty string for the first
+// page), ordered by id, with only their id and encrypted credentials.
+func (d *NewDB) FetchAppsCredentialsPage(after string, limit int) ([]blueprint.DeveloperApp, error) {
+	if after == "" {
+		after = "00000000-0000-0000-0000-000000000000"
+	}
+	apps := make([]blueprint.DeveloperApp, 0)
+	err := d.DB.Select(&apps, queries.FetchAppsCredentialsPage, after, limit)
+	if err != nil {
+		log.Printf("[db][FetchAppsCredentialsPage] developer - error: could not fetch the credentials of the apps after %s: %v\n", after, err)
+		return nil, err
+	}
+	return apps, nil
+}
+
+// ReencryptAppCredentials replaces the encrypted credentials of the app on the platform with credentials, if they are
+// still previous. It returns false if they were changed in the meantime.
+func (d *NewDB) ReencryptAppCredentials(appId, platform string, previous, credentials []byte) (bool, error) {
+	res, err := d.DB.Exec(queries.ReencryptAppCredentials, appId, platform, credentials, previous)
+	if err != nil {
+		log.Printf("[db][ReencryptAppCredentials] developer - error: could not update the %s credentials of app %s: %v\n", platform, appId, err)
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
+
+// FetchUserAppRefreshTokensPage returns up to limit user apps with a refresh token after the user app with the id after
+// (an empty string for the first page), ordered by id, with only their id and encrypted refresh token.
+func (d *NewDB) FetchUserAppRefreshTokensPage(after string, limit int) ([]blueprint.UserAppTokens, error) {
+	if after == "" {
+		after = "00000000-0000-0000-0000-000000000000"
+	}
+	tokens := make([]blueprint.UserAppTokens, 0)
+	err := d.DB.Select(&tokens, queries.FetchUserAppRefreshTokensPage, after, limit)
+	if err != nil {
+		log.Printf("[db][FetchUserAppRefreshTokensPage] developer - error: could not fetch the refresh tokens of the user apps after %s: %v\n", after, err)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// ReencryptUserAppRefreshToken replaces the encrypted refresh token of the user app with refreshToken, if it is still
+// previous. It returns false if it was changed in the meantime.
+func (d *NewDB) ReencryptUserAppRefreshToken(userAppId string, previous, refreshToken []byte) (bool, error) {
+	res, err := d.DB.Exec(queries.ReencryptUserAppRefreshToken, userAppId, refreshToken, previous)
+	if err != nil {
+		log.Printf("[db][ReencryptUserAppRefreshToken] developer - error: could not update the refresh token of user app %s: %v\n", userAppId, err)
+		return false, err
+	}
+	count, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return count == 1, nil
+}
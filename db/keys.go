@@ -0,0 +1,152 @@
+package db
+
+import (
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// CreateAppKey creates a secret key for the app, of the mode (live or test). Only the hash of the key and its hint are
+// stored. The key is unrestricted if scopes is empty.
+func (d *NewDB) CreateAppKey(appId, name, hint, hash, mode string, expiresAt *time.Time, scopes []string) (*blueprint.AppKey, error) {
+	var key blueprint.AppKey
+	err := d.DB.QueryRowx(queries.CreateAppKey, uuid.NewString(), appId, name, hint, hash, expiresAt, pq.Array(scopes), mode).StructScan(&key)
+	if err != nil {
+		log.Printf("[db][CreateAppKey] error - could not create secret key for app %s: %v\n", appId, err)
+		return nil, err
+	}
+	log.Printf("[db][CreateAppKey] created secret key %s for app %s\n", key.UID, appId)
+	return &key, nil
+}
+
+// FetchAppSecretKeys fetches the secret keys of the app, the active ones first.
+func (d *NewDB) FetchAppSecretKeys(appId string) ([]blueprint.AppKey, error) {
+	keys := make([]blueprint.AppKey, 0)
+	err := d.DB.Select(&keys, queries.FetchAppKeys, appId)
+	if err != nil {
+		log.Printf("[db][FetchAppSecretKeys] error - could not fetch secret keys of app %s: %v\n", appId, err)
+		return nil, err
+	}
+	return keys, nil
+}
+
+// FetchAppSecretKey fetches a secret key of the app. It returns sql.ErrNoRows if the app has no such key.
+func (d *NewDB) FetchAppSecretKey(keyId, appId string) (*blueprint.AppKey, error) {
+	var key blueprint.AppKey
+	err := d.DB.QueryRowx(queries.FetchAppKey, keyId, appId).StructScan(&key)
+	if err != nil {
+		log.Printf("[db][FetchAppSecretKey] error - could not fetch secret key %s of app %s: %v\n", keyId, appId, err)
+		return nil, err
+	}
+	return &key, nil
+}
+
+// FetchActiveAppSecretKeyByID fetches the secret key of the app, if it has not been revoked and has not expired. It
+// returns sql.ErrNoRows otherwise.
+func (d *NewDB) FetchActiveAppSecretKeyByID(keyId, appId string) (*blueprint.AppKey, error) {
+	var key blueprint.AppKey
+	err := d.DB.QueryRowx(queries.FetchActiveAppKey, keyId, appId).StructScan(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// FetchActiveAppSecretKey fetches the secret key with the hash, if it has not been revoked and has not expired. It
+// returns sql.ErrNoRows otherwise.
+func (d *NewDB) FetchActiveAppSecretKey(hash string) (*blueprint.AppKey, error) {
+	var key blueprint.AppKey
+	err := d.DB.QueryRowx(queries.FetchActiveAppKeyByHash, hash).StructScan(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// TouchAppSecretKey updates the last time the secret key was used.
+func (d *NewDB) TouchAppSecretKey(keyId string) error {
+	_, err := d.DB.Exec(queries.TouchAppKey, keyId)
+	if err != nil {
+		log.Printf("[db][TouchAppSecretKey] error - could not update last use of secret key %s: %v\n", keyId, err)
+		return err
+	}
+	return nil
+}
+
+// RevokeAppSecretKey revokes a secret key of the app. It stops working right away. It returns sql.ErrNoRows if the app
+// has no such key or it is already revoked.
+func (d *NewDB) RevokeAppSecretKey(keyId, appId string) (*blueprint.AppKey, error) {
+	var key blueprint.AppKey
+	err := d.DB.QueryRowx(queries.RevokeAppKey, keyId, appId).StructScan(&key)
+	if err != nil {
+		log.Printf("[db][RevokeAppSecretKey] error - could not revoke secret key %s of app %s: %v\n", keyId, appId, err)
+		return nil, err
+	}
+	log.Printf("[db][RevokeAppSecretKey] revoked secret key %s of app %s\n", keyId, appId)
+	return &key, nil
+}
+
+// RotateAppSecretKey replaces an active secret key of the app with a new key of the same name, scopes and mode. The replaced
+// key keeps working until graceEnd. It returns sql.ErrNoRows if the app has no such active key.
+func (d *NewDB) RotateAppSecretKey(keyId, appId, hint, hash string, graceEnd time.Time) (*blueprint.AppKey, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][RotateAppSecretKey] error - could not start transaction: %v\n", err)
+		return nil, err
+	}
+	// rollback is a no-op after the transaction is committed.
+	defer tx.Rollback()
+
+	var previous blueprint.AppKey
+	if err = tx.QueryRowx(queries.ExpireAppKey, keyId, appId, graceEnd).StructScan(&previous); err != nil {
+		log.Printf("[db][RotateAppSecretKey] error - could not expire secret key %s of app %s: %v\n", keyId, appId, err)
+		return nil, err
+	}
+
+	var key blueprint.AppKey
+	if err = tx.QueryRowx(queries.CreateAppKey, uuid.NewString(), appId, previous.Name, hint, hash, nil, pq.Array(previous.Scopes), previous.Mode).StructScan(&key); err != nil {
+		log.Printf("[db][RotateAppSecretKey] error - could not create secret key for app %s: %v\n", appId, err)
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[db][RotateAppSecretKey] error - could not commit rotation of secret key %s: %v\n", keyId, err)
+		return nil, err
+	}
+	log.Printf("[db][RotateAppSecretKey] rotated secret key %s of app %s. It expires at %s\n", keyId, appId, graceEnd.Format(time.RFC3339))
+	return &key, nil
+}
+
+// RotateAppSecretKeys creates a new, unrestricted, live secret key for the app and makes all its active live keys stop
+// working at graceEnd. The test keys are left as they are.
+func (d *NewDB) RotateAppSecretKeys(appId, name, hint, hash string, graceEnd time.Time) (*blueprint.AppKey, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][RotateAppSecretKeys] error - could not start transaction: %v\n", err)
+		return nil, err
+	}
+	// rollback is a no-op after the transaction is committed.
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(queries.ExpireActiveAppKeys, appId, graceEnd, blueprint.AppKeyModeLive); err != nil {
+		log.Printf("[db][RotateAppSecretKeys] error - could not expire secret keys of app %s: %v\n", appId, err)
+		return nil, err
+	}
+
+	var key blueprint.AppKey
+	if err = tx.QueryRowx(queries.CreateAppKey, uuid.NewString(), appId, name, hint, hash, nil, pq.Array([]string{}), blueprint.AppKeyModeLive).StructScan(&key); err != nil {
+		log.Printf("[db][RotateAppSecretKeys] error - could not create secret key for app %s: %v\n", appId, err)
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[db][RotateAppSecretKeys] error - could not commit rotation of the secret keys of app %s: %v\n", appId, err)
+		return nil, err
+	}
+	log.Printf("[db][RotateAppSecretKeys] rotated the secret keys of app %s. They expire at %s\n", appId, graceEnd.Format(time.RFC3339))
+	return &key, nil
+}
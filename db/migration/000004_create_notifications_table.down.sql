@@ -0,0 +1,3 @@
+drop index if exists public.notifications_user_app_created_at_idx;
+
+drop table if exists public.notifications;
@@ -0,0 +1,36 @@
+-- Notifications table. holds the follow (and other) notifications created for the users of an app,
+-- so that apps without webhooks configured can still surface them to their users.
+create table if not exists public.notifications
+(
+    id         integer generated always as identity
+        constraint notifications_pk
+            primary key,
+    uuid       uuid
+        constraint notifications_unique_key
+            unique,
+    "user"     uuid
+        constraint notifications_user_fk
+            references public.users (uuid)
+            on update cascade on delete cascade,
+    app        uuid
+        constraint notifications_app_fk
+            references public.apps (uuid)
+            on update cascade on delete cascade,
+    status     text                     default 'unread'::text,
+    data       json,
+    created_at timestamp with time zone default now(),
+    updated_at timestamp with time zone default now(),
+    read_at    timestamp with time zone
+);
+
+comment on table public.notifications is 'the notifications created for users, e.g. when a followed playlist is updated';
+
+comment on column public.notifications.status is 'the status of the notification. either unread or read';
+
+comment on column public.notifications.read_at is 'the timestamp the notification was marked as read';
+
+create index if not exists notifications_user_app_created_at_idx
+    on public.notifications ("user", app, created_at desc);
+
+alter table public.notifications
+    owner to postgres;
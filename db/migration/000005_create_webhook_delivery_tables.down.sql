@@ -0,0 +1,5 @@
+drop table if exists public.webhook_message_attempts;
+
+drop table if exists public.webhook_messages;
+
+drop table if exists public.webhook_endpoints;
@@ -0,0 +1,89 @@
+-- Webhook endpoints for the built-in (self-hosted) webhook sender. webhook_app_id is the same id saved
+-- on the app record (apps.webhook_app_id).
+create table if not exists public.webhook_endpoints
+(
+    id             integer generated always as identity
+        constraint webhook_endpoints_pk
+            primary key,
+    uuid           uuid
+        constraint webhook_endpoints_unique_key
+            unique,
+    webhook_app_id text not null,
+    uid            text not null,
+    url            text not null,
+    filter_types   text[],
+    disabled       boolean                  default false,
+    created_at     timestamp with time zone default now(),
+    updated_at     timestamp with time zone default now(),
+    constraint webhook_endpoints_app_uid_unique_key
+        unique (webhook_app_id, uid)
+);
+
+comment on column public.webhook_endpoints.filter_types is 'the event types sent to this endpoint. empty means all event types';
+
+alter table public.webhook_endpoints
+    owner to postgres;
+
+
+-- Webhook messages. a message is a single event to be delivered to an endpoint.
+create table if not exists public.webhook_messages
+(
+    id              integer generated always as identity
+        constraint webhook_messages_pk
+            primary key,
+    uuid            uuid
+        constraint webhook_messages_unique_key
+            unique,
+    event_id        uuid,
+    webhook_app_id  text not null,
+    endpoint        uuid
+        constraint webhook_messages_endpoint_fk
+            references public.webhook_endpoints (uuid)
+            on update cascade on delete cascade,
+    event_type      text not null,
+    payload         json,
+    status          text                     default 'pending'::text,
+    attempts        integer                  default 0,
+    last_error      text,
+    created_at      timestamp with time zone default now(),
+    updated_at      timestamp with time zone default now(),
+    delivered_at    timestamp with time zone
+);
+
+comment on column public.webhook_messages.event_id is 'the id of the event. shared by the messages created for each endpoint of an app';
+
+comment on column public.webhook_messages.status is 'pending, delivered or failed. failed messages have exhausted their retries (dead-lettered)';
+
+create index if not exists webhook_messages_app_created_at_idx
+    on public.webhook_messages (webhook_app_id, created_at desc);
+
+alter table public.webhook_messages
+    owner to postgres;
+
+
+-- Webhook message attempts. the log of every delivery attempt made for a message.
+create table if not exists public.webhook_message_attempts
+(
+    id               integer generated always as identity
+        constraint webhook_message_attempts_pk
+            primary key,
+    uuid             uuid
+        constraint webhook_message_attempts_unique_key
+            unique,
+    message          uuid
+        constraint webhook_message_attempts_message_fk
+            references public.webhook_messages (uuid)
+            on update cascade on delete cascade,
+    url              text,
+    response_status  integer,
+    response         text,
+    error            text,
+    duration_ms      bigint,
+    created_at       timestamp with time zone default now()
+);
+
+create index if not exists webhook_message_attempts_message_idx
+    on public.webhook_message_attempts (message);
+
+alter table public.webhook_message_attempts
+    owner to postgres;
@@ -0,0 +1,5 @@
+alter table public.apps
+    drop column if exists webhook_batch_interval_ms;
+
+alter table public.apps
+    drop column if exists webhook_batch_size;
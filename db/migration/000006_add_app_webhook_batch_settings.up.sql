@@ -0,0 +1,10 @@
+-- Per-app batching of the playlist conversion track webhook events. null (or 0) means the server default is used.
+alter table public.apps
+    add column if not exists webhook_batch_size integer;
+
+alter table public.apps
+    add column if not exists webhook_batch_interval_ms integer;
+
+comment on column public.apps.webhook_batch_size is 'the number of tracks sent in a single playlist conversion track webhook event';
+
+comment on column public.apps.webhook_batch_interval_ms is 'the max time (ms) tracks are held before a playlist conversion track webhook event is sent';
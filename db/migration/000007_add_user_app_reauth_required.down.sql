@@ -0,0 +1,2 @@
+alter table public.user_apps
+    drop column if exists reauth_required_at;
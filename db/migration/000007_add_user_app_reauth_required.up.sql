@@ -0,0 +1,6 @@
+-- Set when a platform rejects the tokens of a user app (e.g. the user removed the app from their platform account). The
+-- user has to connect the platform again; it is cleared when they do.
+alter table public.user_apps
+    add column if not exists reauth_required_at timestamptz;
+
+comment on column public.user_apps.reauth_required_at is 'the time the platform rejected the tokens of this user app. null if the tokens are valid';
@@ -0,0 +1,6 @@
+-- the keys are only stored hashed and cannot be moved back to the apps. apps need new keys after this migration is
+-- reverted.
+alter table public.apps
+    add column if not exists secret_key varchar;
+
+drop table if exists public.app_keys;
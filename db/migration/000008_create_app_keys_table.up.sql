@@ -0,0 +1,48 @@
+-- Secret keys of the developer apps. An app can have many keys; only the SHA-256 hash of a key is stored, the key itself
+-- is shown once, when it is created.
+create table if not exists public.app_keys
+(
+    id           integer generated always as identity
+        constraint app_keys_pk
+            primary key,
+    uuid         uuid
+        constraint app_keys_unique_key
+            unique,
+    app          uuid
+        constraint app_keys_app_fk
+            references public.apps (uuid)
+            on update cascade on delete cascade,
+    name         text,
+    hint         text,
+    hash         text
+        constraint app_keys_hash_key
+            unique,
+    created_at   timestamp with time zone default now(),
+    last_used_at timestamp with time zone,
+    expires_at   timestamp with time zone,
+    revoked_at   timestamp with time zone
+);
+
+create index if not exists app_keys_app_idx on public.app_keys (app);
+
+comment on table public.app_keys is 'the secret keys of the developer apps';
+
+comment on column public.app_keys.hint is 'the prefix and last characters of the key, to recognize it';
+
+comment on column public.app_keys.hash is 'the hex SHA-256 hash of the key';
+
+comment on column public.app_keys.expires_at is 'the time the key stops working. set when the key is rotated, to the end of the grace period';
+
+-- the existing keys (bare uuids) keep working: they are moved to app_keys, hashed.
+insert into public.app_keys (uuid, app, name, hint, hash)
+select gen_random_uuid(),
+       uuid,
+       'default',
+       '...' || right(secret_key, 4),
+       encode(sha256(convert_to(secret_key, 'UTF8')), 'hex')
+from public.apps
+where secret_key is not null
+  and secret_key <> '';
+
+alter table public.apps
+    drop column if exists secret_key;
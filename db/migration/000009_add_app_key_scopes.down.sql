@@ -0,0 +1,2 @@
+alter table public.app_keys
+    drop column if exists scopes;
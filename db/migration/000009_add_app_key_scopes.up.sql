@@ -0,0 +1,6 @@
+-- Secret keys can be restricted to permission scopes, e.g. convert:track. Keys with no scope (all the existing keys) are
+-- unrestricted.
+alter table public.app_keys
+    add column if not exists scopes text[] default '{}'::text[];
+
+comment on column public.app_keys.scopes is 'the permission scopes of the key. empty means unrestricted';
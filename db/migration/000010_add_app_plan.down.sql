@@ -0,0 +1,2 @@
+alter table public.apps
+    drop column if exists plan;
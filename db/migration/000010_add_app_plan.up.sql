@@ -0,0 +1,5 @@
+-- The plan of an app sets its rate limits and quotas, see services/ratelimit.
+alter table public.apps
+    add column if not exists plan varchar default 'free';
+
+comment on column public.apps.plan is 'the rate limit plan of the app';
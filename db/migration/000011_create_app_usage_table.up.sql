@@ -0,0 +1,30 @@
+-- The usage of the apps, aggregated by day: how many times each event (e.g. a track conversion) happened, on which
+-- platform and endpoint, and how many tracks it involved.
+create table if not exists public.app_usage
+(
+    id         integer generated always as identity
+        constraint app_usage_pk
+            primary key,
+    app        uuid not null
+        constraint app_usage_app_fk
+            references public.apps (uuid)
+            on update cascade on delete cascade,
+    day        date not null,
+    event      text not null,
+    platform   text not null default '',
+    endpoint   text not null default '',
+    count      bigint not null default 0,
+    tracks     bigint not null default 0,
+    created_at timestamp with time zone default now(),
+    updated_at timestamp with time zone default now(),
+    constraint app_usage_unique_key
+        unique (app, day, event, platform, endpoint)
+);
+
+create index if not exists app_usage_day_idx on public.app_usage (day);
+
+comment on table public.app_usage is 'the usage of the apps, aggregated by day';
+
+comment on column public.app_usage.count is 'how many times the event happened';
+
+comment on column public.app_usage.tracks is 'how many tracks the events involved, e.g. the tracks converted in playlist conversions';
@@ -0,0 +1,2 @@
+drop table if exists public.org_invites;
+drop table if exists public.org_members;
@@ -0,0 +1,67 @@
+-- The members of an organization and their role: owner, admin, developer or viewer. The owners of the existing
+-- organizations are added as their owner.
+create table if not exists public.org_members
+(
+    id         integer generated always as identity
+        constraint org_members_pk
+            primary key,
+    org        uuid not null
+        constraint org_members_org_fk
+            references public.organizations (uuid)
+            on update cascade on delete cascade,
+    "user"     uuid not null
+        constraint org_members_user_fk
+            references public.users (uuid)
+            on update cascade on delete cascade,
+    role       varchar not null,
+    created_at timestamp with time zone default now(),
+    updated_at timestamp with time zone default now(),
+    constraint org_members_unique_key
+        unique (org, "user")
+);
+
+create index if not exists org_members_user_idx on public.org_members ("user");
+
+comment on table public.org_members is 'the members of the organizations';
+
+comment on column public.org_members.role is 'the role of the member in the org: owner, admin, developer or viewer';
+
+insert into public.org_members (org, "user", role)
+select uuid, owner, 'owner'
+from public.organizations
+where uuid is not null
+  and owner is not null
+on conflict do nothing;
+
+-- Invitations to join an organization, sent by email. Only the hash of the invitation token is stored.
+create table if not exists public.org_invites
+(
+    id          integer generated always as identity
+        constraint org_invites_pk
+            primary key,
+    uuid        uuid not null
+        constraint org_invites_unique_key
+            unique,
+    org         uuid not null
+        constraint org_invites_org_fk
+            references public.organizations (uuid)
+            on update cascade on delete cascade,
+    email       varchar not null,
+    role        varchar not null,
+    token_hash  varchar not null
+        constraint org_invites_token_hash_key
+            unique,
+    invited_by  uuid
+        constraint org_invites_invited_by_fk
+            references public.users (uuid)
+            on update cascade on delete set null,
+    expires_at  timestamp with time zone not null,
+    accepted_at timestamp with time zone,
+    created_at  timestamp with time zone default now()
+);
+
+create index if not exists org_invites_org_idx on public.org_invites (org);
+
+comment on table public.org_invites is 'the invitations to join an organization';
+
+comment on column public.org_invites.token_hash is 'the SHA-256 hash of the invitation token';
@@ -0,0 +1,3 @@
+drop trigger if exists audit_log_append_only on public.audit_log;
+drop function if exists public.audit_log_append_only();
+drop table if exists public.audit_log;
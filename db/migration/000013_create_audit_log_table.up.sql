@@ -0,0 +1,43 @@
+-- The audit log of the changes made to the organizations and their apps: who did what, to what, and what changed
+-- (with the secrets redacted). It is append-only: entries can not be updated or deleted, and they are kept when the
+-- organization or the app is deleted.
+create table if not exists public.audit_log
+(
+    id          bigint generated always as identity
+        constraint audit_log_pk
+            primary key,
+    uuid        uuid not null
+        constraint audit_log_unique_key
+            unique,
+    org         uuid not null,
+    actor       uuid,
+    action      text not null,
+    target_type text not null,
+    target_id   text not null default '',
+    before      jsonb,
+    after       jsonb,
+    ip          text not null default '',
+    request_id  text not null default '',
+    created_at  timestamp with time zone not null default now()
+);
+
+create index if not exists audit_log_org_idx on public.audit_log (org, id desc);
+
+comment on table public.audit_log is 'the append-only audit log of the changes made to the organizations and their apps';
+
+comment on column public.audit_log.before is 'the changed fields before the change, with the secrets redacted';
+
+comment on column public.audit_log.after is 'the changed fields after the change, with the secrets redacted';
+
+create or replace function public.audit_log_append_only() returns trigger as
+$$
+begin
+    raise exception 'audit_log is append-only';
+end;
+$$ language plpgsql;
+
+create trigger audit_log_append_only
+    before update or delete
+    on public.audit_log
+    for each row
+execute function public.audit_log_append_only();
@@ -0,0 +1,8 @@
+-- Secret keys are live or test keys. The requests made with a test key run in the sandbox, against fake platforms.
+-- All the existing keys are live keys.
+alter table public.app_keys
+    add column if not exists mode text not null default 'live'
+        constraint app_keys_mode_check
+            check (mode in ('live', 'test'));
+
+comment on column public.app_keys.mode is 'live, or test for the keys of the sandbox';
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AddOrgMember adds a user to an org with the role. If they are already a member, they keep their role.
+func (d *NewDB) AddOrgMember(orgId, userId, role string) error {
+	_, err := d.DB.Exec(queries.AddOrgMember, orgId, userId, role)
+	if err != nil {
+		log.Printf("[db][AddOrgMember] error - could not add user %s to org %s: %v\n", userId, orgId, err)
+		return err
+	}
+	return nil
+}
+
+// FetchOrgMember fetches a member of an org. It returns sql.ErrNoRows if the user is not a member.
+func (d *NewDB) FetchOrgMember(orgId, userId string) (*blueprint.OrgMember, error) {
+	var member blueprint.OrgMember
+	err := d.DB.Get(&member, queries.FetchOrgMember, orgId, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &member, nil
+}
+
+// FetchOrgMembers fetches the members of an org, in the order they joined.
+func (d *NewDB) FetchOrgMembers(orgId string) ([]blueprint.OrgMember, error) {
+	members := make([]blueprint.OrgMember, 0)
+	err := d.DB.Select(&members, queries.FetchOrgMembers, orgId)
+	if err != nil {
+		log.Printf("[db][FetchOrgMembers] error - could not fetch members of org %s: %v\n", orgId, err)
+		return nil, err
+	}
+	return members, nil
+}
+
+// UpdateOrgMemberRole changes the role of a member of an org.
+func (d *NewDB) UpdateOrgMemberRole(orgId, userId, role string) error {
+	_, err := d.DB.Exec(queries.UpdateOrgMemberRole, orgId, userId, role)
+	if err != nil {
+		log.Printf("[db][UpdateOrgMemberRole] error - could not update the role of user %s in org %s: %v\n", userId, orgId, err)
+		return err
+	}
+	return nil
+}
+
+// RemoveOrgMember removes a user from an org. The apps they created stay in the org.
+func (d *NewDB) RemoveOrgMember(orgId, userId string) error {
+	_, err := d.DB.Exec(queries.RemoveOrgMember, orgId, userId)
+	if err != nil {
+		log.Printf("[db][RemoveOrgMember] error - could not remove user %s from org %s: %v\n", userId, orgId, err)
+		return err
+	}
+	return nil
+}
+
+// FetchUserOrgs fetches the orgs a user is a member of, with their role, the ones they own first.
+func (d *NewDB) FetchUserOrgs(userId string) ([]blueprint.UserOrganization, error) {
+	orgs := make([]blueprint.UserOrganization, 0)
+	err := d.DB.Select(&orgs, queries.FetchUserOrgs, userId)
+	if err != nil {
+		log.Printf("[db][FetchUserOrgs] error - could not fetch orgs of user %s: %v\n", userId, err)
+		return nil, err
+	}
+	return orgs, nil
+}
+
+// FetchUserOrgByID fetches an org, with the role of the user in it. It returns sql.ErrNoRows if the user is not a
+// member of the org.
+func (d *NewDB) FetchUserOrgByID(userId, orgId string) (*blueprint.UserOrganization, error) {
+	var org blueprint.UserOrganization
+	err := d.DB.Get(&org, queries.FetchUserOrgByID, userId, orgId)
+	if err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// TransferOrg makes the member the owner of the org. The previous owner stays in the org as an admin.
+func (d *NewDB) TransferOrg(orgId, previousOwnerId, newOwnerId string) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][TransferOrg] error - could not start transaction: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec(queries.TransferOrg, orgId, newOwnerId); err != nil {
+		log.Printf("[db][TransferOrg] error - could not update the owner of org %s: %v\n", orgId, err)
+		return err
+	}
+	if _, err = tx.Exec(queries.UpdateOrgMemberRole, orgId, newOwnerId, blueprint.OrgRoleOwner); err != nil {
+		log.Printf("[db][TransferOrg] error - could not make user %s the owner of org %s: %v\n", newOwnerId, orgId, err)
+		return err
+	}
+	if _, err = tx.Exec(queries.UpdateOrgMemberRole, orgId, previousOwnerId, blueprint.OrgRoleAdmin); err != nil {
+		log.Printf("[db][TransferOrg] error - could not make user %s an admin of org %s: %v\n", previousOwnerId, orgId, err)
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		log.Printf("[db][TransferOrg] error - could not commit the transfer of org %s: %v\n", orgId, err)
+		return err
+	}
+	log.Printf("[db][TransferOrg] org %s transferred from %s to %s\n", orgId, previousOwnerId, newOwnerId)
+	return nil
+}
+
+// CreateOrgInvite creates an invitation to join the org. tokenHash is the hash of the token sent by email.
+func (d *NewDB) CreateOrgInvite(orgId, email, role, tokenHash, invitedBy string, expiresAt time.Time) (*blueprint.OrgInvite, error) {
+	var invite blueprint.OrgInvite
+	err := d.DB.Get(&invite, queries.CreateOrgInvite, uuid.NewString(), orgId, email, role, tokenHash, invitedBy, expiresAt)
+	if err != nil {
+		log.Printf("[db][CreateOrgInvite] error - could not create invite to org %s: %v\n", orgId, err)
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// FetchPendingOrgInvites fetches the invitations to the org that were neither accepted nor expired.
+func (d *NewDB) FetchPendingOrgInvites(orgId string) ([]blueprint.OrgInvite, error) {
+	invites := make([]blueprint.OrgInvite, 0)
+	err := d.DB.Select(&invites, queries.FetchPendingOrgInvites, orgId)
+	if err != nil {
+		log.Printf("[db][FetchPendingOrgInvites] error - could not fetch invites to org %s: %v\n", orgId, err)
+		return nil, err
+	}
+	return invites, nil
+}
+
+// FetchPendingOrgInviteByToken fetches the invitation with the token hash. It returns sql.ErrNoRows if there is none,
+// or if it was accepted or has expired.
+func (d *NewDB) FetchPendingOrgInviteByToken(tokenHash string) (*blueprint.OrgInvite, error) {
+	var invite blueprint.OrgInvite
+	err := d.DB.Get(&invite, queries.FetchPendingOrgInviteByToken, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	return &invite, nil
+}
+
+// AcceptOrgInvite marks the invitation as accepted and adds the user to the org with the role of the invitation. If
+// passwordHash is set, it is the password of the user, who is created first if newUser is set. It is all done in one
+// transaction: blueprint.ErrInviteNotPending is returned if the invitation is not pending anymore, and
+// blueprint.ErrUserExists if the new user was created in the meantime.
+func (d *NewDB) AcceptOrgInvite(invite *blueprint.OrgInvite, userId, email, passwordHash string, newUser bool) error {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][AcceptOrgInvite] error - could not start transaction: %v\n", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	switch {
+	case newUser:
+		res, cErr := tx.Exec(queries.CreateNewOrgUser, email, userId, passwordHash)
+		if cErr != nil {
+			log.Printf("[db][AcceptOrgInvite] error - could not create user %s: %v\n", email, cErr)
+			return cErr
+		}
+		if n, rErr := res.RowsAffected(); rErr != nil || n != 1 {
+			log.Printf("[db][AcceptOrgInvite] error - user %s was created in the meantime\n", email)
+			return blueprint.ErrUserExists
+		}
+	case passwordHash != "":
+		if _, err = tx.Exec(queries.UpdateUserPassword, passwordHash, userId); err != nil {
+			log.Printf("[db][AcceptOrgInvite] error - could not set the password of user %s: %v\n", userId, err)
+			return err
+		}
+	}
+
+	res, err := tx.Exec(queries.AcceptOrgInvite, invite.UID.String())
+	if err != nil {
+		log.Printf("[db][AcceptOrgInvite] error - could not accept invite %s: %v\n", invite.UID.String(), err)
+		return err
+	}
+	if n, rErr := res.RowsAffected(); rErr != nil || n != 1 {
+		log.Printf("[db][AcceptOrgInvite] error - invite %s is not pending anymore\n", invite.UID.String())
+		return blueprint.ErrInviteNotPending
+	}
+	if _, err = tx.Exec(queries.AddOrgMember, invite.Org.String(), userId, invite.Role); err != nil {
+		log.Printf("[db][AcceptOrgInvite] error - could not add user %s to org %s: %v\n", userId, invite.Org.String(), err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteOrgInvite deletes an invitation to the org that was not accepted yet.
+func (d *NewDB) DeleteOrgInvite(inviteId, orgId string) (bool, error) {
+	res, err := d.DB.Exec(queries.DeleteOrgInvite, inviteId, orgId)
+	if err != nil {
+		log.Printf("[db][DeleteOrgInvite] error - could not delete invite %s: %v\n", inviteId, err)
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,17 @@
+package queries
+
+const CreateAuditLogEntry = `INSERT INTO audit_log (uuid, org, actor, action, target_type, target_id, before, after, ip, request_id)
+	VALUES ($1, $2, $3, $4, $5, $6, $7::jsonb, $8::jsonb, $9, $10)`
+
+// FetchAuditLog fetches a page of the audit log of an org, the most recent entries first. The filters are ignored when
+// empty; the action filter ($3) matches the action or the actions it prefixes, e.g. app.secret_key. $8 is the id of the
+// last entry of the previous page.
+const FetchAuditLog = `SELECT l.id, l.uuid, l.org, l.actor, coalesce(u.email, '') AS actor_email, l.action, l.target_type,
+	l.target_id, coalesce(l.before, 'null'::jsonb) AS before, coalesce(l.after, 'null'::jsonb) AS after, l.ip, l.request_id,
+	l.created_at
+	FROM audit_log l LEFT JOIN users u ON u.uuid = l.actor
+	WHERE l.org = $1 AND ($2::text = '' OR l.actor::text = $2) AND ($3::text = '' OR l.action = $3 OR l.action LIKE $3 || '.%')
+	AND ($4::text = '' OR l.target_type = $4) AND ($5::text = '' OR l.target_id = $5)
+	AND ($6::timestamptz IS NULL OR l.created_at >= $6) AND ($7::timestamptz IS NULL OR l.created_at < $7)
+	AND ($8::bigint = 0 OR l.id < $8)
+	ORDER BY l.id DESC LIMIT $9`
@@ -2,9 +2,9 @@ package queries
 
 const CreateNewApp = `INSERT INTO apps (uuid, name, description, redirect_url,
                   webhook_url, public_key, developer,
-                  secret_key, verify_token, organization, deezer_state, created_at,
+                  verify_token, organization, deezer_state, created_at,
                   updated_at)
-	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, now(), now()) RETURNING uuid`
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, now(), now()) RETURNING uuid`
 
 const UpdateAppIntegrationCredentials = `UPDATE apps SET
 deezer_credentials = (CASE WHEN $3 = 'deezer' AND length($1::bytea) > 0
@@ -22,19 +22,24 @@ webhook_url = $4, redirect_url = $5, authorized = true, webhook_app_id = $6, upd
 //deezer_redirect_url = (CASE WHEN $2 = 'deezer' THEN $2 END),
 //applemusic_redirect_url = (CASE WHEN $2 = 'applemusic' THEN $2 END) WHERE uuid = $1`
 
-const FetchAppByAppID = `SELECT Id, uuid, name, description, developer, secret_key, public_key,  coalesce(webhook_url, '') as webhook_url,
+const FetchAppByAppID = `SELECT Id, uuid, name, description, developer, public_key,  coalesce(webhook_url, '') as webhook_url,
        coalesce(redirect_url, '') as redirect_url, coalesce(verify_token, '') as verify_token, created_at, updated_at, coalesce(authorized, false) as authorized, organization,
        COALESCE(spotify_credentials, '') AS spotify_credentials, COALESCE(applemusic_credentials, '') AS applemusic_credentials, COALESCE(deezer_credentials, '') AS deezer_credentials, COALESCE(tidal_credentials, '') AS tidal_credentials,
-		coalesce(deezer_state, '') AS deezer_state, coalesce(webhook_app_id, '') as webhook_app_id FROM apps WHERE uuid = $1`
+		coalesce(deezer_state, '') AS deezer_state, coalesce(webhook_app_id, '') as webhook_app_id,
+		coalesce(webhook_batch_size, 0) as webhook_batch_size, coalesce(webhook_batch_interval_ms, 0) as webhook_batch_interval_ms,
+		coalesce(plan, 'free') as plan FROM apps WHERE uuid = $1`
+
+// UpdateAppWebhookBatchSettings updates the batching of the playlist conversion track webhook events of an app. 0 means the server default is used.
+const UpdateAppWebhookBatchSettings = `UPDATE apps SET webhook_batch_size = nullif($2, 0), webhook_batch_interval_ms = nullif($3, 0), updated_at = now() WHERE uuid = $1 AND developer = $4;`
 
 const FetchAppByAppIDWithoutDev = `SELECT Id, uuid, name, description,
-       developer, secret_key, public_key,
+       developer, public_key,
        spotify_credentials, applemusic_credentials, deezer_credentials, tidal_credentials,
 --            COALESCE(spotify_redirect_url, '') AS spotify_redirect_url, COALESCE(applemusic_redirect_url, '') AS applemusic_redirect_url, COALESCE(deezer_redirect_url, '') AS deezer_redirect_url, COALESCE(tidal_redirect_url, '') AS tidal_redirect_url,
        coalesce(redirect_url, '') as redirect_url, coalesce(webhook_url, '') as webhook_url, coalesce(verify_token, '') as verify_token,
     created_at, updated_at, coalesce(authorized, false) as authorized, organization, coalesce(deezer_state, '') AS deezer_state FROM apps WHERE uuid = $1;`
 
-const FetchAppByPubKeyWithoutDev = `SELECT Id, uuid, name, description, developer, secret_key, public_key,
+const FetchAppByPubKeyWithoutDev = `SELECT Id, uuid, name, description, developer, public_key,
 --        COALESCE(spotify_redirect_url, '') AS spotify_redirect_url, COALESCE(applemusic_redirect_url, '') AS applemusic_redirect_url, COALESCE(deezer_redirect_url, '') AS deezer_redirect_url, COALESCE(tidal_redirect_url, '') AS tidal_redirect_url,
        coalesce(redirect_url, '') as redirect_url, coalesce(webhook_url, '') as webhook_url, coalesce(verify_token, '') as verify_token,
        COALESCE(spotify_credentials, '') AS spotify_credentials, COALESCE(applemusic_credentials, '') AS applemusic_credentials,
@@ -42,16 +47,12 @@ const FetchAppByPubKeyWithoutDev = `SELECT Id, uuid, name, description, develope
        created_at, updated_at, coalesce(authorized, false) as authorized, organization,
        coalesce(deezer_state, '') AS deezer_state FROM apps WHERE public_key = $1`
 
-const FetchAppByPubKey = `SELECT Id, uuid, name, description, developer, secret_key, public_key,
-       coalesce(redirect_url, '') as redirect_url, coalesce(verify_token, '') as verify_token, coalesce(webhook_url, '') as webhook_url,
-       COALESCE(spotify_credentials, '') AS spotify_credentials, COALESCE(applemusic_credentials, '') AS applemusic_credentials, COALESCE(deezer_credentials, '') AS deezer_credentials, COALESCE(tidal_credentials, '') AS tidal_credentials, created_at, updated_at, authorized, organization, coalesce(deezer_state, '') AS deezer_state FROM apps WHERE public_key = $1 AND developer = $2`
-
-const FetchAppBySecretKey = `SELECT Id, uuid, name, description, developer, secret_key, public_key,
+const FetchAppByPubKey = `SELECT Id, uuid, name, description, developer, public_key,
        coalesce(redirect_url, '') as redirect_url, coalesce(verify_token, '') as verify_token, coalesce(webhook_url, '') as webhook_url,
-       COALESCE(spotify_credentials, '') AS spotify_credentials, COALESCE(applemusic_credentials, '') AS applemusic_credentials, COALESCE(deezer_credentials, '') AS deezer_credentials, COALESCE(tidal_credentials, '') AS tidal_credentials, created_at, updated_at, authorized, organization, coalesce(deezer_state, '') AS deezer_state FROM apps WHERE secret_key = $1`
+       COALESCE(spotify_credentials, '') AS spotify_credentials, COALESCE(applemusic_credentials, '') AS applemusic_credentials, COALESCE(deezer_credentials, '') AS deezer_credentials, COALESCE(tidal_credentials, '') AS tidal_credentials, created_at, updated_at, authorized, organization, coalesce(deezer_state, '') AS deezer_state, coalesce(plan, 'free') as plan FROM apps WHERE public_key = $1 AND developer = $2`
 
 const FetchAuthorizedAppDeveloperByPublicKey = `SELECT u.email, u.id, u.uuid, u.created_at, u.updated_at FROM apps a JOIN users u on a.developer = u.uuid WHERE a.public_key = $1 AND a.authorized = true`
-const FetchAuthorizedAppDeveloperBySecretKey = `SELECT u.email, u.id, u.uuid, u.created_at, u.updated_at FROM apps a JOIN users u on a.developer = u.uuid WHERE a.secret_key = $1 AND a.authorized = true`
+const FetchAuthorizedAppDeveloperByAppID = `SELECT u.email, u.id, u.uuid, u.created_at, u.updated_at FROM apps a JOIN users u on a.developer = u.uuid WHERE a.uuid = $1 AND a.authorized = true`
 
 // UpdateApp updates the developer app with data passed. If the values are empty, it falls back to what the original value of the column is
 const UpdateApp = `UPDATE apps SET  description = (CASE WHEN $1 = '' THEN description ELSE $1 END),
@@ -64,7 +65,7 @@ applemusic_credentials = (CASE WHEN $8 = 'applemusic' AND length($7::bytea) > 0
 spotify_credentials = (CASE WHEN $8 = 'spotify' AND length($7::bytea) > 0 THEN $7::bytea ELSE spotify_credentials END),
 tidal_credentials = (CASE WHEN $8 = 'tidal' AND length($7::bytea) > 0 THEN $7::bytea ELSE tidal_credentials END),
 
-updated_at = now() WHERE uuid = $5 AND developer = $6 returning Id, uuid, name, description, developer, secret_key, public_key,  coalesce(webhook_url, '') as webhook_url,
+updated_at = now() WHERE uuid = $5 AND developer = $6 returning Id, uuid, name, description, developer, public_key,  coalesce(webhook_url, '') as webhook_url,
        coalesce(redirect_url, '') as redirect_url, coalesce(verify_token, '') as verify_token, created_at, updated_at, coalesce(authorized, false) as authorized, organization,
        COALESCE(spotify_credentials, '') AS spotify_credentials, COALESCE(applemusic_credentials, '') AS applemusic_credentials, COALESCE(deezer_credentials, '') AS deezer_credentials, COALESCE(tidal_credentials, '') AS tidal_credentials,
 		coalesce(deezer_state, '') AS deezer_state, coalesce(webhook_app_id, '') as webhook_app_id;`
@@ -75,17 +76,16 @@ const DeleteApp = `DELETE FROM apps WHERE uuid = $1 AND developer = $2`
 
 const DisableApp = `UPDATE apps SET authorized = false WHERE uuid = $1 AND developer = $2;`
 const EnableApp = `UPDATE apps SET authorized = true WHERE uuid = $1 AND developer = $2;`
-const FetchAppKeysByID = `SELECT public_key, secret_key, verify_token FROM apps WHERE uuid = $1 AND developer = $2;`
+const FetchAppKeysByID = `SELECT public_key, verify_token FROM apps WHERE uuid = $1 AND developer = $2;`
 
-const FetchAppsByDeveloper = `SELECT
- id, uuid, name, description, developer, secret_key, public_key,
+const FetchAppsByOrganization = `SELECT
+ id, uuid, name, description, developer, public_key,
  redirect_url, webhook_url, verify_token, spotify_credentials,
  applemusic_credentials, tidal_credentials, deezer_credentials,
  created_at, updated_at, authorized, organization, coalesce(deezer_state, '') as deezer_state
-FROM apps WHERE developer = $1 and organization = $2`
+FROM apps WHERE organization = $1`
 
-const UpdateAppKeys = `UPDATE apps SET public_key = $1, secret_key = $2, verify_token = $3, deezer_state = $4 WHERE uuid = $5`
-const RevokeSecretKey = `update apps set secret_key = $2 where uuid = $1`
+const UpdateAppKeys = `UPDATE apps SET public_key = $1, verify_token = $2, deezer_state = $3 WHERE uuid = $4`
 const RevokeVerifySecret = `update apps set verify_token = $2 where uuid = $1`
 const RevokeDeezerState = `update apps set deezer_state = $2 where uuid = $1`
 const RevokePublicKey = `update apps set public_key = $2 where uuid = $1`
@@ -95,8 +95,11 @@ const CreateNewOrg = `INSERT INTO
 VALUES ($1, $2, $3, now(), now(), $4) RETURNING uuid`
 
 const DeleteOrg = `DELETE FROM organizations WHERE uuid = $1 AND owner = $2`
-const UpdateOrg = `UPDATE organizations SET description = (CASE WHEN $1 = '' THEN description ELSE $1 END), name = (CASE WHEN $2 = '' THEN name ELSE $2 END), updated_at = now() WHERE uuid = $3 AND owner = $4 `
-const FetchUserOrg = `SELECT * FROM organizations WHERE owner = $1 order by updated_at desc limit 1`
+const UpdateOrg = `UPDATE organizations SET description = (CASE WHEN $1 = '' THEN description ELSE $1 END), name = (CASE WHEN $2 = '' THEN name ELSE $2 END), updated_at = now() WHERE uuid = $3`
+
+// FetchUserOrg fetches the organization of a user: the one they own or, if they own none, the last one they joined.
+const FetchUserOrg = `SELECT o.* FROM organizations o JOIN org_members m ON m.org = o.uuid
+	WHERE m."user" = $1 ORDER BY m.role = 'owner' DESC, m.created_at DESC limit 1`
 
 const FetchUserApp = `SELECT * FROM user_apps WHERE uuid = $1 AND "user" = $2`
 const FetchUserAppByPlatform = `SELECT uuid, refresh_token, "user", coalesce(authed_at, now()) as authed_at,
@@ -114,7 +117,8 @@ const UpdateUserAppTokensAndScopes = `UPDATE user_apps SET
                            ELSE $7::timestamptz
                          END,
                      access_token = $8,
-                     scopes = (CASE WHEN $2 = '' THEN scopes ELSE ARRAY[$2] END)
+                     scopes = (CASE WHEN $2 = '' THEN scopes ELSE ARRAY[$2] END),
+                     reauth_required_at = NULL
                  where app = $3 AND "user" = $4 AND platform = $5 and uuid = $6 returning uuid`
 
 const UpdateDeezerState = `UPDATE apps SET deezer_state = $1 WHERE uuid = $2;`
@@ -122,7 +126,7 @@ const UpdateDeezerState = `UPDATE apps SET deezer_state = $1 WHERE uuid = $2;`
 const FetchUserAppByPlatformAndApp = `SELECT uuid, scopes FROM user_apps WHERE platform = $1 AND app = $2`
 
 const FetchAppByDeezerState = `SELECT Id, uuid, name, description,
-       developer, secret_key, public_key,
+       developer, public_key,
        coalesce(redirect_url, '') as redirect_url, coalesce(verify_token, '') as verify_token, coalesce(webhook_url, '') as webhook_url,
 --        spotify_credentials, applemusic_credentials, deezer_credentials, tidal_credentials,
 --            COALESCE(spotify_redirect_url, '') AS spotify_redirect_url, COALESCE(applemusic_redirect_url, '') AS applemusic_redirect_url, COALESCE(deezer_redirect_url, '') AS deezer_redirect_url, COALESCE(tidal_redirect_url, '') AS tidal_redirect_url,
@@ -169,7 +173,35 @@ platform_id = $2, refresh_token = $3, last_authed_at = now(), expires_in = CASE
       ELSE $7::timestamptz
     END, access_token = $8 WHERE "user" = $4 AND platform = $5 AND uuid = $6`
 
-const UpdateOAuthTokens = `UPDATE user_apps SET refresh_token = $1, expires_in = $2, access_token = $3 WHERE "user" = $4 AND platform = $5`
+const FetchUserAppTokens = `SELECT uuid, refresh_token, coalesce(access_token, '') AS access_token, expires_in, reauth_required_at
+FROM user_apps WHERE "user" = $1 AND app = $2 AND platform = $3`
+
+// RotateUserAppTokens only updates the tokens if the refresh token has not changed since it was read, so that a
+// rotated refresh token is never overwritten by an older one.
+const RotateUserAppTokens = `UPDATE user_apps SET refresh_token = $1, access_token = $2, expires_in = $3
+WHERE uuid = $4 AND refresh_token = $5`
+
+// MarkUserAppReauthRequired marks the user app as needing the user to connect the platform again. It returns the scopes
+// of the user app and whether it was already marked.
+const MarkUserAppReauthRequired = `UPDATE user_apps u SET reauth_required_at = coalesce(prev.reauth_required_at, now())
+FROM (SELECT uuid, reauth_required_at FROM user_apps WHERE "user" = $1 AND app = $2 AND platform = $3 FOR UPDATE) prev
+WHERE u.uuid = prev.uuid RETURNING coalesce(u.scopes, '{}') AS scopes, prev.reauth_required_at IS NOT NULL AS already_marked`
+
+// DisconnectUserApp wipes the tokens and scopes of the user on the platform, for the app. The user app is kept so that
+// the user can connect the platform again.
+const DisconnectUserApp = `UPDATE user_apps SET refresh_token = NULL, access_token = NULL, expires_in = NULL, scopes = '{}', reauth_required_at = NULL
+WHERE "user" = $1 AND app = $2 AND platform = $3 AND refresh_token IS NOT NULL RETURNING uuid`
+
+// The queries below erase the data of a user for an app. They are run in a single transaction by DeleteUserAppData.
+const DeleteUserAppTasks = `DELETE FROM tasks WHERE "user" = $1 AND app = $2`
+const RemoveUserFromAppFollows = `UPDATE follows SET subscribers = array_remove(subscribers, $1::uuid), updated_at = now()
+WHERE app = $2 AND $1::text = ANY (subscribers::text[])`
+const DeleteUnsubscribedAppFollows = `DELETE FROM follows WHERE app = $1 AND cardinality(subscribers) = 0`
+const DeleteUserAppNotifications = `DELETE FROM notifications WHERE "user" = $1 AND app = $2`
+const DeleteUserApps = `DELETE FROM user_apps WHERE "user" = $1 AND app = $2`
+
+// DeleteOrphanUser deletes the user if they have no app left. Their remaining records are deleted with them.
+const DeleteOrphanUser = `DELETE FROM users WHERE uuid = $1 AND NOT EXISTS (SELECT 1 FROM user_apps WHERE "user" = $1)`
 
 const DeletePlatformIntegrationCredentials = `UPDATE apps SET
 deezer_credentials = ( CASE WHEN $2 = 'deezer' THEN NULL ELSE deezer_credentials END ),
@@ -0,0 +1,26 @@
+package queries
+
+// FetchAppsCredentialsPage returns the encrypted credentials of the apps after the app $1, ordered by id, for the
+// re-encryption of the credentials with the current master key.
+const FetchAppsCredentialsPage = `SELECT uuid, coalesce(spotify_credentials, '') AS spotify_credentials,
+       coalesce(applemusic_credentials, '') AS applemusic_credentials, coalesce(deezer_credentials, '') AS deezer_credentials,
+       coalesce(tidal_credentials, '') AS tidal_credentials
+FROM apps WHERE uuid > $1 ORDER BY uuid LIMIT $2`
+
+// ReencryptAppCredentials replaces the credentials of the app on the platform $2, only if they have not changed since
+// they were read, so that credentials updated in the meantime are not overwritten.
+const ReencryptAppCredentials = `UPDATE apps SET
+spotify_credentials = (CASE WHEN $2 = 'spotify' THEN $3::bytea ELSE spotify_credentials END),
+applemusic_credentials = (CASE WHEN $2 = 'applemusic' THEN $3::bytea ELSE applemusic_credentials END),
+deezer_credentials = (CASE WHEN $2 = 'deezer' THEN $3::bytea ELSE deezer_credentials END),
+tidal_credentials = (CASE WHEN $2 = 'tidal' THEN $3::bytea ELSE tidal_credentials END)
+WHERE uuid = $1 AND (CASE $2 WHEN 'spotify' THEN spotify_credentials WHEN 'applemusic' THEN applemusic_credentials
+    WHEN 'deezer' THEN deezer_credentials WHEN 'tidal' THEN tidal_credentials END) = $4::bytea`
+
+// FetchUserAppRefreshTokensPage returns the encrypted refresh tokens of the user apps after the user app $1, ordered by
+// id.
+const FetchUserAppRefreshTokensPage = `SELECT uuid, refresh_token FROM user_apps
+WHERE uuid > $1 AND refresh_token IS NOT NULL ORDER BY uuid LIMIT $2`
+
+// ReencryptUserAppRefreshToken replaces the refresh token of the user app, only if it has not changed since it was read.
+const ReencryptUserAppRefreshToken = `UPDATE user_apps SET refresh_token = $2 WHERE uuid = $1 AND refresh_token = $3`
@@ -0,0 +1,35 @@
+package queries
+
+const appKeyColumns = `uuid, app, coalesce(name, '') AS name, coalesce(hint, '') AS hint, created_at, last_used_at, expires_at, revoked_at, coalesce(scopes, '{}') AS scopes, coalesce(mode, 'live') AS mode`
+
+const CreateAppKey = `INSERT INTO app_keys (uuid, app, name, hint, hash, expires_at, scopes, mode, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, now()) RETURNING ` + appKeyColumns
+
+// FetchAppKeys fetches the secret keys of an app, the active ones first.
+const FetchAppKeys = `SELECT ` + appKeyColumns + ` FROM app_keys WHERE app = $1
+	ORDER BY (revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())) DESC, created_at DESC`
+
+const FetchAppKey = `SELECT ` + appKeyColumns + ` FROM app_keys WHERE uuid = $1 AND app = $2`
+
+// FetchActiveAppKey fetches the key of the app, if it has not been revoked and has not expired.
+const FetchActiveAppKey = `SELECT ` + appKeyColumns + ` FROM app_keys WHERE uuid = $1 AND app = $2
+	AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`
+
+// FetchActiveAppKeyByHash fetches the key with the hash, if it has not been revoked and has not expired.
+const FetchActiveAppKeyByHash = `SELECT ` + appKeyColumns + ` FROM app_keys WHERE hash = $1
+	AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`
+
+// TouchAppKey updates the last time a key was used, at most once a minute so that requests do not all write.
+const TouchAppKey = `UPDATE app_keys SET last_used_at = now() WHERE uuid = $1
+	AND (last_used_at IS NULL OR last_used_at < now() - interval '1 minute')`
+
+const RevokeAppKey = `UPDATE app_keys SET revoked_at = now() WHERE uuid = $1 AND app = $2 AND revoked_at IS NULL RETURNING ` + appKeyColumns
+
+// ExpireAppKey makes the key stop working at $3, unless it already expires before then.
+const ExpireAppKey = `UPDATE app_keys SET expires_at = least(coalesce(expires_at, $3), $3) WHERE uuid = $1 AND app = $2
+	AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now()) RETURNING ` + appKeyColumns
+
+// ExpireActiveAppKeys makes all the active keys of the app of mode $3 stop working at $2, unless they already expire
+// before then.
+const ExpireActiveAppKeys = `UPDATE app_keys SET expires_at = least(coalesce(expires_at, $2), $2) WHERE app = $1
+	AND mode = $3 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now())`
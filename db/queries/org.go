@@ -0,0 +1,47 @@
+package queries
+
+// AddOrgMember adds a member to an org. Users that are already members keep their role.
+const AddOrgMember = `INSERT INTO org_members (org, "user", role) VALUES ($1, $2, $3) ON CONFLICT (org, "user") DO NOTHING`
+
+const orgMemberColumns = `m.org, m."user", coalesce(u.email, '') AS email, m.role, m.created_at, m.updated_at`
+
+const FetchOrgMember = `SELECT ` + orgMemberColumns + ` FROM org_members m JOIN users u ON u.uuid = m."user"
+	WHERE m.org = $1 AND m."user" = $2`
+
+const FetchOrgMembers = `SELECT ` + orgMemberColumns + ` FROM org_members m JOIN users u ON u.uuid = m."user"
+	WHERE m.org = $1 ORDER BY m.created_at`
+
+const UpdateOrgMemberRole = `UPDATE org_members SET role = $3, updated_at = now() WHERE org = $1 AND "user" = $2`
+
+const RemoveOrgMember = `DELETE FROM org_members WHERE org = $1 AND "user" = $2`
+
+// FetchUserOrgs fetches the organizations a user is a member of, the ones they own first.
+const FetchUserOrgs = `SELECT o.*, m.role FROM organizations o JOIN org_members m ON m.org = o.uuid
+	WHERE m."user" = $1 ORDER BY m.role = 'owner' DESC, o.updated_at DESC`
+
+// FetchUserOrgByID fetches an organization, if the user is a member of it.
+const FetchUserOrgByID = `SELECT o.*, m.role FROM organizations o JOIN org_members m ON m.org = o.uuid
+	WHERE m."user" = $1 AND o.uuid = $2`
+
+// FetchOrgUserByEmail fetches a user and their password, which is empty if they never set one (e.g. users created by
+// authorizing an app).
+const FetchOrgUserByEmail = `SELECT id, email, coalesce(password, '') AS password, uuid FROM users WHERE email = $1`
+
+const TransferOrg = `UPDATE organizations SET owner = $2, updated_at = now() WHERE uuid = $1`
+
+const orgInviteColumns = `uuid, org, email, role, invited_by, expires_at, accepted_at, created_at`
+
+const CreateOrgInvite = `INSERT INTO org_invites (uuid, org, email, role, token_hash, invited_by, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING ` + orgInviteColumns
+
+// FetchPendingOrgInvites fetches the invitations of an organization that were neither accepted nor expired.
+const FetchPendingOrgInvites = `SELECT ` + orgInviteColumns + ` FROM org_invites
+	WHERE org = $1 AND accepted_at IS NULL AND expires_at > now() ORDER BY created_at DESC`
+
+// FetchPendingOrgInviteByToken fetches the invitation with the token hash, if it was neither accepted nor expired.
+const FetchPendingOrgInviteByToken = `SELECT ` + orgInviteColumns + ` FROM org_invites
+	WHERE token_hash = $1 AND accepted_at IS NULL AND expires_at > now()`
+
+const AcceptOrgInvite = `UPDATE org_invites SET accepted_at = now() WHERE uuid = $1 AND accepted_at IS NULL`
+
+const DeleteOrgInvite = `DELETE FROM org_invites WHERE uuid = $1 AND org = $2 AND accepted_at IS NULL`
@@ -83,7 +83,24 @@ as subscribers FROM follows follow JOIN users "user"
 
 // FetchFollowByEntityId query is used to fetch a follow and the subscribers to it.2
 const FetchFollowByEntityId = `SELECT DISTINCT on(follow.id) follow.id, follow.created_at, follow.updated_at, follow.developer, follow.entity_id, follow.entity_url, json_agg("user".*) as subscribers FROM follows follow JOIN users "user" ON "user".uuid::text = ANY (subscribers::text[]) WHERE entity_id = $1 GROUP BY follow.id`
-const CreateFollowNotification = `INSERT INTO notifications(created_at, updated_at, "user", UUID, status, "data") VALUES (now(), now(), :subscriber, :notification_id, 'unread', :data)`
+const CreateFollowNotification = `INSERT INTO notifications(created_at, updated_at, "user", UUID, app, status, "data") VALUES (now(), now(), :subscriber, :notification_id, :app, 'unread', :data)`
+
+// FetchUserNotifications fetches the notifications for a user of an app, newest first. the status ($3) and since ($4) filters are
+// optional; an empty status or a null since matches every notification.
+const FetchUserNotifications = `SELECT uuid, "user", app, status, coalesce("data", '{}') as data, created_at, updated_at, read_at FROM notifications
+WHERE "user" = $1 AND app = $2 AND ($3 = '' OR status = $3) AND ($4::timestamptz IS NULL OR created_at >= $4)
+ORDER BY created_at DESC LIMIT $5 OFFSET $6;`
+
+const CountUserNotifications = `SELECT count(*) FROM notifications WHERE "user" = $1 AND app = $2 AND ($3 = '' OR status = $3) AND ($4::timestamptz IS NULL OR created_at >= $4);`
+
+const MarkNotificationAsRead = `UPDATE notifications SET status = 'read', read_at = coalesce(read_at, now()), updated_at = now() WHERE uuid = $1 AND "user" = $2 AND app = $3 RETURNING uuid;`
+
+// MarkNotificationsAsRead marks the passed notifications ($3) of a user as read. if no notification ids are passed, every unread notification is marked.
+const MarkNotificationsAsRead = `UPDATE notifications SET status = 'read', read_at = now(), updated_at = now() WHERE "user" = $1 AND app = $2 AND status = 'unread'
+AND (cardinality($3::uuid[]) = 0 OR uuid = ANY ($3::uuid[]));`
+
+// DeleteStaleNotifications deletes read notifications older than $1 and every notification older than $2
+const DeleteStaleNotifications = `DELETE FROM notifications WHERE (status = 'read' AND created_at < $1) OR created_at < $2;`
 
 const UpdateFollowLatUpdated = `UPDATE follows SET updated_at = now() where entity_id = $1;`
 
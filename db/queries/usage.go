@@ -0,0 +1,15 @@
+package queries
+
+// RecordAppUsage adds to the usage of an app for the day (in UTC).
+const RecordAppUsage = `INSERT INTO app_usage (app, day, event, platform, endpoint, count, tracks)
+	VALUES ($1, (now() AT TIME ZONE 'utc')::date, $2, $3, $4, $5, $6)
+	ON CONFLICT (app, day, event, platform, endpoint) DO UPDATE SET count = app_usage.count + excluded.count,
+	tracks = app_usage.tracks + excluded.tracks, updated_at = now()`
+
+// FetchOrgUsage fetches the usage of the apps of an organization, between two days (inclusive), by day or month ($4).
+const FetchOrgUsage = `SELECT to_char(date_trunc($4::text, u.day::timestamp), CASE WHEN $4::text = 'month' THEN 'YYYY-MM' ELSE 'YYYY-MM-DD' END) AS period,
+	a.uuid AS app, a.name AS app_name, u.event, u.platform, u.endpoint, sum(u.count) AS count, sum(u.tracks) AS tracks
+	FROM app_usage u JOIN apps a ON a.uuid = u.app
+	WHERE a.organization = $1 AND u.day >= $2::date AND u.day <= $3::date
+	GROUP BY 1, a.uuid, a.name, u.event, u.platform, u.endpoint
+	ORDER BY 1, a.name, u.event, u.platform, u.endpoint`
@@ -0,0 +1,72 @@
+package queries
+
+const CreateWebhookEndpoint = `INSERT INTO webhook_endpoints(uuid, webhook_app_id, uid, url, filter_types, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, now(), now()) RETURNING uuid, webhook_app_id, uid, url, coalesce(filter_types, '{}') as filter_types, coalesce(disabled, false) as disabled, created_at, updated_at;`
+
+const FetchWebhookEndpoint = `SELECT uuid, webhook_app_id, uid, url, coalesce(filter_types, '{}') as filter_types, coalesce(disabled, false) as disabled, created_at, updated_at
+	FROM webhook_endpoints WHERE webhook_app_id = $1 AND uid = $2;`
+
+const UpdateWebhookEndpointURL = `UPDATE webhook_endpoints SET url = $3, updated_at = now() WHERE webhook_app_id = $1 AND uid = $2
+	RETURNING uuid, webhook_app_id, uid, url, coalesce(filter_types, '{}') as filter_types, coalesce(disabled, false) as disabled, created_at, updated_at;`
+
+// UpdateWebhookEndpointFilterTypes sets the event types ($3) an endpoint is subscribed to. an empty list subscribes the endpoint to all event types.
+const UpdateWebhookEndpointFilterTypes = `UPDATE webhook_endpoints SET filter_types = $3, updated_at = now() WHERE webhook_app_id = $1 AND uid = $2
+	RETURNING uuid, webhook_app_id, uid, url, coalesce(filter_types, '{}') as filter_types, coalesce(disabled, false) as disabled, created_at, updated_at;`
+
+// FetchWebhookEndpointsForEvent fetches the enabled endpoints of an app that should receive an event type ($2).
+// endpoints without filter types receive every event type.
+const FetchWebhookEndpointsForEvent = `SELECT uuid, webhook_app_id, uid, url, coalesce(filter_types, '{}') as filter_types, coalesce(disabled, false) as disabled, created_at, updated_at
+	FROM webhook_endpoints WHERE webhook_app_id = $1 AND coalesce(disabled, false) = false
+	AND (filter_types IS NULL OR cardinality(filter_types) = 0 OR $2 = ANY (filter_types));`
+
+const CreateWebhookMessage = `INSERT INTO webhook_messages(uuid, event_id, webhook_app_id, endpoint, event_type, payload, status, created_at, updated_at)
+	VALUES ($1, $2, $3, $4, $5, $6, 'pending', now(), now());`
+
+// FetchWebhookMessageForDelivery fetches a message alongside its endpoint url and the verify token of the app that owns it.
+const FetchWebhookMessageForDelivery = `SELECT message.uuid, message.event_id, message.webhook_app_id, message.endpoint, message.event_type, message.payload, message.status,
+	message.attempts, coalesce(message.last_error, '') as last_error, message.created_at, message.updated_at, message.delivered_at,
+	endpoint.url, coalesce(endpoint.disabled, false) as endpoint_disabled, coalesce(convert_from(app.verify_token, 'UTF8'), '') as verify_token
+	FROM webhook_messages message
+	JOIN webhook_endpoints endpoint ON endpoint.uuid = message.endpoint
+	LEFT JOIN apps app ON app.webhook_app_id = message.webhook_app_id
+	WHERE message.uuid = $1;`
+
+const CreateWebhookMessageAttempt = `INSERT INTO webhook_message_attempts(uuid, message, url, response_status, response, error, duration_ms, created_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, now());`
+
+const MarkWebhookMessageDelivered = `UPDATE webhook_messages SET status = 'delivered', attempts = attempts + 1, last_error = NULL, delivered_at = now(), updated_at = now() WHERE uuid = $1;`
+
+// UpdateWebhookMessageFailedAttempt records a failed attempt for a message. The status ($3) is pending if the message will
+// be retried and failed if it has exhausted its retries.
+const UpdateWebhookMessageFailedAttempt = `UPDATE webhook_messages SET status = $3, attempts = attempts + 1, last_error = $2, updated_at = now() WHERE uuid = $1;`
+
+// FetchWebhookMessages fetches the messages of a webhook app, most recent first. before ($2), after ($3), the event types ($4,
+// empty means all) and the iterator ($5, the id of the last message of the previous page) are optional.
+const FetchWebhookMessages = `SELECT uuid, event_id, webhook_app_id, endpoint, event_type, payload, status, attempts, coalesce(last_error, '') as last_error,
+	created_at, updated_at, delivered_at
+	FROM webhook_messages WHERE webhook_app_id = $1
+	AND ($2::timestamptz IS NULL OR created_at < $2)
+	AND ($3::timestamptz IS NULL OR created_at > $3)
+	AND (cardinality($4::text[]) = 0 OR event_type = ANY ($4))
+	AND ($5::uuid IS NULL OR (created_at, id) < (SELECT created_at, id FROM webhook_messages WHERE uuid = $5))
+	ORDER BY created_at DESC, id DESC LIMIT $6;`
+
+const FetchWebhookMessage = `SELECT uuid, event_id, webhook_app_id, endpoint, event_type, payload, status, attempts, coalesce(last_error, '') as last_error,
+	created_at, updated_at, delivered_at
+	FROM webhook_messages WHERE uuid = $1 AND webhook_app_id = $2;`
+
+const FetchWebhookMessageAttempts = `SELECT uuid, message, coalesce(url, '') as url, coalesce(response_status, 0) as response_status, coalesce(response, '') as response,
+	coalesce(error, '') as error, coalesce(duration_ms, 0) as duration_ms, created_at
+	FROM webhook_message_attempts WHERE message = $1 ORDER BY created_at DESC;`
+
+// ResetWebhookMessage sets a message of an endpoint ($3 is the uid of the endpoint) back to pending, so that it can be delivered again.
+const ResetWebhookMessage = `UPDATE webhook_messages SET status = 'pending', last_error = NULL, updated_at = now()
+	WHERE uuid = $1 AND webhook_app_id = $2 AND endpoint = (SELECT uuid FROM webhook_endpoints WHERE webhook_app_id = $2 AND uid = $3)
+	RETURNING uuid;`
+
+// ResetFailedWebhookMessages sets the failed messages of an endpoint ($2 is the uid of the endpoint) created since $3 (and until $4,
+// if passed) back to pending, so that they can be delivered again.
+const ResetFailedWebhookMessages = `UPDATE webhook_messages SET status = 'pending', last_error = NULL, updated_at = now()
+	WHERE webhook_app_id = $1 AND endpoint = (SELECT uuid FROM webhook_endpoints WHERE webhook_app_id = $1 AND uid = $2)
+	AND status = 'failed' AND created_at >= $3 AND ($4::timestamptz IS NULL OR created_at <= $4)
+	RETURNING uuid;`
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+	"time"
+)
+
+// RecordAppUsage adds the usage event to the usage of the app for the day.
+func (d *NewDB) RecordAppUsage(event *blueprint.UsageEvent) error {
+	_, err := d.DB.Exec(queries.RecordAppUsage, event.App, event.Event, event.Platform, event.Endpoint, event.Count, event.Tracks)
+	if err != nil {
+		log.Printf("[db][RecordAppUsage] error - could not record %s usage of app %s: %v\n", event.Event, event.App, err)
+		return err
+	}
+	return nil
+}
+
+// FetchOrgUsage fetches the usage of the apps of the organization, between the days from and to (inclusive), by day or
+// by month.
+func (d *NewDB) FetchOrgUsage(orgId string, from, to time.Time, granularity string) ([]blueprint.AppUsage, error) {
+	usage := make([]blueprint.AppUsage, 0)
+	err := d.DB.Select(&usage, queries.FetchOrgUsage, orgId, from.Format(time.DateOnly), to.Format(time.DateOnly), granularity)
+	if err != nil {
+		log.Printf("[db][FetchOrgUsage] error - could not fetch usage of organization %s: %v\n", orgId, err)
+		return nil, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// CreateWebhookEndpoint creates a new endpoint for the built-in webhook sender.
+func (d *NewDB) CreateWebhookEndpoint(webhookAppId, uid, url string, filterTypes []string) (*blueprint.WebhookEndpoint, error) {
+	var endpoint blueprint.WebhookEndpoint
+	err := d.DB.QueryRowx(queries.CreateWebhookEndpoint, uuid.NewString(), webhookAppId, uid, url, pq.Array(filterTypes)).StructScan(&endpoint)
+	if err != nil {
+		log.Printf("[db][CreateWebhookEndpoint] error creating webhook endpoint. %v\n", err)
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// FetchWebhookEndpoint fetches an endpoint of a webhook app by its uid.
+func (d *NewDB) FetchWebhookEndpoint(webhookAppId, uid string) (*blueprint.WebhookEndpoint, error) {
+	var endpoint blueprint.WebhookEndpoint
+	err := d.DB.QueryRowx(queries.FetchWebhookEndpoint, webhookAppId, uid).StructScan(&endpoint)
+	if err != nil {
+		log.Printf("[db][FetchWebhookEndpoint] error fetching webhook endpoint. %v\n", err)
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// UpdateWebhookEndpointURL updates the url of an endpoint of a webhook app.
+func (d *NewDB) UpdateWebhookEndpointURL(webhookAppId, uid, url string) (*blueprint.WebhookEndpoint, error) {
+	var endpoint blueprint.WebhookEndpoint
+	err := d.DB.QueryRowx(queries.UpdateWebhookEndpointURL, webhookAppId, uid, url).StructScan(&endpoint)
+	if err != nil {
+		log.Printf("[db][UpdateWebhookEndpointURL] error updating webhook endpoint. %v\n", err)
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// UpdateWebhookEndpointFilterTypes updates the event types an endpoint of a webhook app is subscribed to.
+func (d *NewDB) UpdateWebhookEndpointFilterTypes(webhookAppId, uid string, filterTypes []string) (*blueprint.WebhookEndpoint, error) {
+	var endpoint blueprint.WebhookEndpoint
+	err := d.DB.QueryRowx(queries.UpdateWebhookEndpointFilterTypes, webhookAppId, uid, pq.StringArray(filterTypes)).StructScan(&endpoint)
+	if err != nil {
+		log.Printf("[db][UpdateWebhookEndpointFilterTypes] error updating webhook endpoint filter types. %v\n", err)
+		return nil, err
+	}
+	return &endpoint, nil
+}
+
+// FetchWebhookEndpointsForEvent fetches the enabled endpoints of a webhook app that are subscribed to the event type.
+func (d *NewDB) FetchWebhookEndpointsForEvent(webhookAppId, eventType string) ([]blueprint.WebhookEndpoint, error) {
+	endpoints := make([]blueprint.WebhookEndpoint, 0)
+	err := d.DB.Select(&endpoints, queries.FetchWebhookEndpointsForEvent, webhookAppId, eventType)
+	if err != nil {
+		log.Printf("[db][FetchWebhookEndpointsForEvent] error fetching webhook endpoints. %v\n", err)
+		return nil, err
+	}
+	return endpoints, nil
+}
+
+// CreateWebhookMessages creates a new pending message of the event for each of the endpoints, in one transaction, and
+// returns their ids in the order of the endpoints. Either all the messages are created or none is.
+func (d *NewDB) CreateWebhookMessages(eventId, webhookAppId, eventType string, payload []byte, endpoints []string) ([]string, error) {
+	tx, err := d.DB.Beginx()
+	if err != nil {
+		log.Printf("[db][CreateWebhookMessages] error - could not start transaction: %v\n", err)
+		return nil, err
+	}
+	// rollback is a no-op after the transaction is committed.
+	defer tx.Rollback()
+
+	messageIds := make([]string, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		messageId := uuid.NewString()
+		if _, err = tx.Exec(queries.CreateWebhookMessage, messageId, eventId, webhookAppId, endpoint, eventType, string(payload)); err != nil {
+			log.Printf("[db][CreateWebhookMessages] error creating webhook message for endpoint %s. %v\n", endpoint, err)
+			return nil, err
+		}
+		messageIds = append(messageIds, messageId)
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Printf("[db][CreateWebhookMessages] error - could not commit webhook messages of event %s: %v\n", eventId, err)
+		return nil, err
+	}
+	return messageIds, nil
+}
+
+// FetchWebhookMessageForDelivery fetches a webhook message with the information needed to deliver it.
+func (d *NewDB) FetchWebhookMessageForDelivery(uid string) (*blueprint.WebhookMessageDelivery, error) {
+	var message blueprint.WebhookMessageDelivery
+	err := d.DB.QueryRowx(queries.FetchWebhookMessageForDelivery, uid).StructScan(&message)
+	if err != nil {
+		log.Printf("[db][FetchWebhookMessageForDelivery] error fetching webhook message. %v\n", err)
+		return nil, err
+	}
+	return &message, nil
+}
+
+// CreateWebhookMessageAttempt logs a delivery attempt of a webhook message.
+func (d *NewDB) CreateWebhookMessageAttempt(attempt *blueprint.WebhookMessageAttempt) error {
+	_, err := d.DB.Exec(queries.CreateWebhookMessageAttempt, uuid.NewString(), attempt.Message, attempt.URL, attempt.ResponseStatus,
+		attempt.Response, attempt.Error, attempt.DurationMs)
+	if err != nil {
+		log.Printf("[db][CreateWebhookMessageAttempt] error creating webhook message attempt. %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// MarkWebhookMessageDelivered marks a webhook message as delivered.
+func (d *NewDB) MarkWebhookMessageDelivered(uid string) error {
+	_, err := d.DB.Exec(queries.MarkWebhookMessageDelivered, uid)
+	if err != nil {
+		log.Printf("[db][MarkWebhookMessageDelivered] error updating webhook message. %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// UpdateWebhookMessageFailedAttempt records a failed delivery of a webhook message. If deadLetter is true, the message has
+// exhausted its retries and is marked as failed.
+func (d *NewDB) UpdateWebhookMessageFailedAttempt(uid, lastError string, deadLetter bool) error {
+	status := blueprint.WebhookMessageStatusPending
+	if deadLetter {
+		status = blueprint.WebhookMessageStatusFailed
+	}
+	_, err := d.DB.Exec(queries.UpdateWebhookMessageFailedAttempt, uid, lastError, status)
+	if err != nil {
+		log.Printf("[db][UpdateWebhookMessageFailedAttempt] error updating webhook message. %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// FetchWebhookMessages fetches the messages of a webhook app, most recent first.
+func (d *NewDB) FetchWebhookMessages(webhookAppId string, filter *blueprint.WebhookMessageFilter) ([]blueprint.WebhookMessageRecord, error) {
+	var iterator *string
+	if filter.Iterator != "" {
+		iterator = &filter.Iterator
+	}
+
+	messages := make([]blueprint.WebhookMessageRecord, 0)
+	err := d.DB.Select(&messages, queries.FetchWebhookMessages, webhookAppId, filter.Before, filter.After,
+		pq.StringArray(filter.EventTypes), iterator, filter.Limit)
+	if err != nil {
+		log.Printf("[db][FetchWebhookMessages] error fetching webhook messages. %v\n", err)
+		return nil, err
+	}
+	return messages, nil
+}
+
+// FetchWebhookMessage fetches a message of a webhook app.
+func (d *NewDB) FetchWebhookMessage(uid, webhookAppId string) (*blueprint.WebhookMessageRecord, error) {
+	var message blueprint.WebhookMessageRecord
+	err := d.DB.QueryRowx(queries.FetchWebhookMessage, uid, webhookAppId).StructScan(&message)
+	if err != nil {
+		log.Printf("[db][FetchWebhookMessage] error fetching webhook message. %v\n", err)
+		return nil, err
+	}
+	return &message, nil
+}
+
+// FetchWebhookMessageAttempts fetches the delivery attempts of a message, most recent first.
+func (d *NewDB) FetchWebhookMessageAttempts(message string) ([]blueprint.WebhookMessageAttempt, error) {
+	attempts := make([]blueprint.WebhookMessageAttempt, 0)
+	err := d.DB.Select(&attempts, queries.FetchWebhookMessageAttempts, message)
+	if err != nil {
+		log.Printf("[db][FetchWebhookMessageAttempts] error fetching webhook message attempts. %v\n", err)
+		return nil, err
+	}
+	return attempts, nil
+}
+
+// ResetWebhookMessage sets a message of an endpoint back to pending. It returns sql.ErrNoRows if the message does not exist.
+func (d *NewDB) ResetWebhookMessage(uid, webhookAppId, endpointUid string) error {
+	var id string
+	err := d.DB.QueryRowx(queries.ResetWebhookMessage, uid, webhookAppId, endpointUid).Scan(&id)
+	if err != nil {
+		log.Printf("[db][ResetWebhookMessage] error resetting webhook message. %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// ResetFailedWebhookMessages sets the failed messages of an endpoint created within the window back to pending and
+// returns their ids.
+func (d *NewDB) ResetFailedWebhookMessages(webhookAppId, endpointUid string, since time.Time, until *time.Time) ([]string, error) {
+	ids := make([]string, 0)
+	err := d.DB.Select(&ids, queries.ResetFailedWebhookMessages, webhookAppId, endpointUid, since, until)
+	if err != nil {
+		log.Printf("[db][ResetFailedWebhookMessages] error resetting failed webhook messages. %v\n", err)
+		return nil, err
+	}
+	return ids, nil
+}
@@ -0,0 +1,182 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"orchdio/config"
+	"orchdio/constants"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"github.com/jmoiron/sqlx"
+)
+
+// Postgres checks that the database answers.
+func Postgres(db *sqlx.DB) Check {
+	return Check{Name: "postgres", Run: func(ctx context.Context) (interface{}, error) {
+		if err := db.PingContext(ctx); err != nil {
+			return nil, err
+		}
+		stats := db.Stats()
+		return map[string]int{"open_connections": stats.OpenConnections, "in_use": stats.InUse}, nil
+	}}
+}
+
+// Redis checks that redis answers.
+func Redis(client *redis.Client) Check {
+	return Check{Name: "redis", Run: func(ctx context.Context) (interface{}, error) {
+		return nil, client.Ping(ctx).Err()
+	}}
+}
+
+// QueueServer checks that the queue server of this process is running: its connection to redis, and its state as
+// reported in its heartbeats.
+func QueueServer(server *asynq.Server, inspector *asynq.Inspector) Check {
+	return Check{Name: "queue", Run: func(ctx context.Context) (interface{}, error) {
+		if err := server.Ping(); err != nil {
+			return nil, err
+		}
+		servers, err := inspector.Servers()
+		if err != nil {
+			return nil, err
+		}
+		host, hErr := os.Hostname()
+		if hErr != nil {
+			host = "unknown-host"
+		}
+		for _, s := range servers {
+			if s.Host != host || s.PID != os.Getpid() {
+				continue
+			}
+			detail := map[string]interface{}{
+				"state":          s.Status,
+				"active_workers": len(s.ActiveWorkers),
+				"concurrency":    s.Concurrency,
+			}
+			if s.Status != "active" {
+				return detail, fmt.Errorf("the queue server is %s", s.Status)
+			}
+			return detail, nil
+		}
+		// the heartbeat of the server is written a few seconds after it starts.
+		return nil, errors.New("the queue server of this instance is not running")
+	}}
+}
+
+// Migrations checks that the database is migrated to at least the latest migration of the instance (see LatestMigration),
+// and not left dirty by a failed migration. A database ahead of the instance is not a failure: the migrations run
+// before the new instances are deployed (see cmd/migrate), and the running instances stay ready until they are replaced.
+func Migrations(db *sqlx.DB, latest uint) Check {
+	return Check{Name: "migrations", Run: func(ctx context.Context) (interface{}, error) {
+		var version uint
+		var dirty bool
+		err := db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+		if errors.Is(err, sql.ErrNoRows) {
+			return map[string]interface{}{"latest": latest}, errors.New("the database is not migrated")
+		}
+		if err != nil {
+			return nil, err
+		}
+		detail := map[string]interface{}{"version": version, "latest": latest, "dirty": dirty}
+		if dirty {
+			return detail, fmt.Errorf("the migration %d failed and must be fixed manually", version)
+		}
+		if version < latest {
+			return detail, fmt.Errorf("the database is at the migration %d, expected %d", version, latest)
+		}
+		detail["ahead"] = version > latest
+		return detail, nil
+	}}
+}
+
+// LatestMigration returns the version of the latest migration in dir, the directory of the migrations (e.g.
+// ./db/migration), named like 000014_add_app_key_mode.up.sql.
+func LatestMigration(dir string) (uint, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.up.sql"))
+	if err != nil {
+		return 0, err
+	}
+	var latest uint
+	for _, file := range files {
+		prefix, _, _ := strings.Cut(filepath.Base(file), "_")
+		version, pErr := strconv.ParseUint(prefix, 10, 64)
+		if pErr != nil {
+			return 0, fmt.Errorf("invalid migration file name %s", filepath.Base(file))
+		}
+		if uint(version) > latest {
+			latest = uint(version)
+		}
+	}
+	if latest == 0 {
+		return 0, fmt.Errorf("no migration found in %s", dir)
+	}
+	return latest, nil
+}
+
+// Platforms returns the optional checks of the configuration of the platforms: their API bases, and the developer
+// token of Apple Music and its expiry. The credentials of the apps are checked when they are saved, not here.
+func Platforms(cfg config.Platforms) []Check {
+	return []Check{
+		apiBase(constants.DeezerIdentifier, "DEEZER_API_BASE", cfg.DeezerAPIBase),
+		apiBase(constants.SpotifyIdentifier, "SPOTIFY_API_BASE", cfg.SpotifyAPIBase),
+		apiBase(constants.TidalIdentifier, "TIDAL_API_BASE", cfg.TidalAPIBase),
+		apiBase("ytmusic", "YTMUSIC_API_BASE", cfg.YTMusicAPIBase),
+		{Name: constants.AppleMusicIdentifier, Optional: true, Run: func(ctx context.Context) (interface{}, error) {
+			return developerTokenExpiry(cfg.AppleMusicAPIKey)
+		}},
+	}
+}
+
+func apiBase(platform, key, base string) Check {
+	return Check{Name: platform, Optional: true, Run: func(ctx context.Context) (interface{}, error) {
+		if base == "" {
+			return nil, fmt.Errorf("%s is not set", key)
+		}
+		u, err := url.Parse(base)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("%s is not a valid URL", key)
+		}
+		return nil, nil
+	}}
+}
+
+// developerTokenExpiry returns the expiry of the developer token of Apple Music, a JWT. Its signature is checked by
+// Apple, only its claims are read here.
+func developerTokenExpiry(token string) (interface{}, error) {
+	if token == "" {
+		return nil, errors.New("APPLE_MUSIC_API_KEY is not set")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("APPLE_MUSIC_API_KEY is not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("APPLE_MUSIC_API_KEY is not a JWT")
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("APPLE_MUSIC_API_KEY is not a JWT")
+	}
+	if claims.Exp == 0 {
+		return nil, nil
+	}
+	expiry := time.Unix(claims.Exp, 0).UTC()
+	detail := map[string]interface{}{"expires_at": expiry}
+	if time.Now().After(expiry) {
+		return detail, errors.New("APPLE_MUSIC_API_KEY has expired")
+	}
+	return detail, nil
+}
@@ -0,0 +1,133 @@
+// Package health is the liveness and readiness of Orchdio, for the probes of the deployments. The liveness only tells
+// that the process is serving requests; the readiness checks the dependencies (Postgres, Redis, the queue server and
+// the migrations) and reports each of them, so that an instance whose dependencies are down is taken out of the load
+// balancer instead of crashing.
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Status is the status of a check, or of the instance.
+type Status string
+
+const (
+	StatusUp   Status = "up"
+	StatusDown Status = "down"
+)
+
+// CheckFunc checks a dependency. The detail returned, if any (e.g. the version of the migrations), is reported with
+// the status of the check.
+type CheckFunc func(ctx context.Context) (detail interface{}, err error)
+
+// Check is a check of a dependency.
+type Check struct {
+	Name string
+	// Optional checks (e.g. the credentials of the platforms) are only run when asked for, and do not make the
+	// instance not ready when they fail.
+	Optional bool
+	Run      CheckFunc
+}
+
+// CheckResult is the result of a check.
+type CheckResult struct {
+	Status     Status      `json:"status"`
+	Optional   bool        `json:"optional,omitempty"`
+	Detail     interface{} `json:"detail,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// Report is the result of the checks. The instance is down if any of the checks that are not optional is down.
+type Report struct {
+	Status Status                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+// Checker runs the checks of the readiness.
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+	started time.Time
+}
+
+// NewChecker returns a new checker of the checks passed. Each check is cancelled after timeout.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout, started: time.Now()}
+}
+
+// Run runs the checks concurrently, the optional ones too if optional is true.
+func (c *Checker) Run(ctx context.Context, optional bool) *Report {
+	report := &Report{Status: StatusUp, Checks: make(map[string]CheckResult, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		if check.Optional && !optional {
+			continue
+		}
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			result := c.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if result.Status == StatusDown && !check.Optional {
+				report.Status = StatusDown
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	// a check that does not honour the context must not hold the probe past the timeout.
+	done := make(chan CheckResult, 1)
+	go func() {
+		result := CheckResult{Status: StatusUp, Optional: check.Optional}
+		detail, err := check.Run(ctx)
+		result.Detail = detail
+		if err != nil {
+			result.Status = StatusDown
+			result.Error = err.Error()
+		}
+		done <- result
+	}()
+	var result CheckResult
+	select {
+	case result = <-done:
+	case <-ctx.Done():
+		result = CheckResult{Status: StatusDown, Optional: check.Optional, Error: "timed out"}
+	}
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// Liveness responds to the liveness probes. It does not check the dependencies: an instance whose dependencies are
+// down must not be restarted, it is not ready (see Readiness).
+func (c *Checker) Liveness(ctx *fiber.Ctx) error {
+	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{
+		"status":         StatusUp,
+		"uptime_seconds": int64(time.Since(c.started).Seconds()),
+	})
+}
+
+// Readiness responds to the readiness probes with the report of the checks, with the status 503 if the instance is
+// not ready. The optional checks are run if the query parameter optional is true.
+func (c *Checker) Readiness(ctx *fiber.Ctx) error {
+	report := c.Run(ctx.UserContext(), ctx.QueryBool("optional"))
+	status := fiber.StatusOK
+	if report.Status == StatusDown {
+		status = fiber.StatusServiceUnavailable
+	}
+	return ctx.Status(status).JSON(report)
+}
@@ -0,0 +1,109 @@
+package health
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func check(name string, optional bool, err error) Check {
+	return Check{Name: name, Optional: optional, Run: func(ctx context.Context) (interface{}, error) {
+		return nil, err
+	}}
+}
+
+func TestCheckerRun(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		checker := NewChecker(time.Second, check("postgres", false, nil), check("deezer", true, errors.New("DEEZER_API_BASE is not set")))
+		report := checker.Run(context.Background(), false)
+		assert.Equal(t, StatusUp, report.Status)
+		assert.Len(t, report.Checks, 1)
+
+		// the optional checks are reported, but do not make the instance not ready.
+		report = checker.Run(context.Background(), true)
+		assert.Equal(t, StatusUp, report.Status)
+		assert.Equal(t, StatusDown, report.Checks["deezer"].Status)
+		assert.Equal(t, "DEEZER_API_BASE is not set", report.Checks["deezer"].Error)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		checker := NewChecker(time.Second, check("postgres", false, nil), check("redis", false, errors.New("connection refused")))
+		report := checker.Run(context.Background(), false)
+		assert.Equal(t, StatusDown, report.Status)
+		assert.Equal(t, StatusUp, report.Checks["postgres"].Status)
+		assert.Equal(t, StatusDown, report.Checks["redis"].Status)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		hang := Check{Name: "queue", Run: func(ctx context.Context) (interface{}, error) {
+			time.Sleep(time.Second)
+			return nil, nil
+		}}
+		report := NewChecker(10*time.Millisecond, hang).Run(context.Background(), false)
+		assert.Equal(t, StatusDown, report.Status)
+		assert.Equal(t, "timed out", report.Checks["queue"].Error)
+	})
+}
+
+func TestLatestMigration(t *testing.T) {
+	latest, err := LatestMigration("../db/migration")
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, latest, uint(14))
+
+	_, err = LatestMigration(t.TempDir())
+	assert.Error(t, err)
+}
+
+func TestMigrations(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version uint
+		dirty   bool
+		ready   bool
+		ahead   bool
+	}{
+		{name: "latest", version: 14, ready: true},
+		// the migrations of a new release run before its instances replace the running ones.
+		{name: "ahead", version: 15, ready: true, ahead: true},
+		{name: "behind", version: 13},
+		{name: "dirty", version: 14, dirty: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer conn.Close()
+			mock.ExpectQuery("SELECT version, dirty FROM schema_migrations").
+				WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(tc.version, tc.dirty))
+
+			detail, err := Migrations(sqlx.NewDb(conn, "postgres"), 14).Run(context.Background())
+			assert.Equal(t, tc.ready, err == nil)
+			if tc.ready {
+				assert.Equal(t, tc.ahead, detail.(map[string]interface{})["ahead"])
+			}
+		})
+	}
+}
+
+func TestDeveloperTokenExpiry(t *testing.T) {
+	token := func(exp time.Time) string {
+		payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf(`{"iss":"team","exp":%d}`, exp.Unix())))
+		return "header." + payload + ".signature"
+	}
+
+	_, err := developerTokenExpiry(token(time.Now().Add(time.Hour)))
+	assert.NoError(t, err)
+	_, err = developerTokenExpiry(token(time.Now().Add(-time.Hour)))
+	assert.EqualError(t, err, "APPLE_MUSIC_API_KEY has expired")
+	_, err = developerTokenExpiry("not-a-token")
+	assert.Error(t, err)
+	_, err = developerTokenExpiry("")
+	assert.Error(t, err)
+}
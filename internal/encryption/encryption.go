@@ -0,0 +1,171 @@
+// Package encryption encrypts the secrets stored in the database, such as the platform credentials of the apps and the
+// refresh tokens of the users, with envelope encryption: each record is encrypted with its own data key, which is
+// wrapped with a master key of the KMS. The ID of the master key is stored with the record, so that the master keys can
+// be rotated by adding a new key to the KMS and re-encrypting the records with it, while the older ones are still read.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"orchdio/config"
+)
+
+// DefaultKeyID is the ID of the master key used when there is no key file, ENCRYPTION_SECRET. When moving to a key
+// file, ENCRYPTION_SECRET must be kept in it under this ID until the records are re-encrypted.
+const DefaultKeyID = "default"
+
+// magic starts the records encrypted by Envelope. Records without it were encrypted with ENCRYPTION_SECRET directly,
+// before the envelope encryption.
+var magic = []byte("ORE1")
+
+var (
+	ErrMalformedCiphertext = errors.New("malformed ciphertext")
+	// ErrNoLegacySecret is returned when decrypting a record encrypted before the envelope encryption without
+	// ENCRYPTION_SECRET.
+	ErrNoLegacySecret = errors.New("no secret to decrypt the legacy record")
+)
+
+// Envelope encrypts and decrypts records with data keys wrapped by the KMS. A record is
+//
+//	magic | key id length (1 byte) | key id | wrapped data key length (2 bytes) | wrapped data key | nonce | ciphertext
+//
+// LegacySecret, if set, decrypts the records encrypted with it directly, before the envelope encryption.
+type Envelope struct {
+	KMS          KMS
+	LegacySecret []byte
+}
+
+// Encrypt encrypts the plaintext with a new data key, wrapped with the current master key.
+func (e *Envelope) Encrypt(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	keyID := e.KMS.CurrentKeyID()
+	wrapped, err := e.KMS.WrapKey(keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyID) > 255 || len(wrapped) > 65535 {
+		return nil, errors.New("key id or wrapped key too long")
+	}
+
+	header := bytes.NewBuffer(make([]byte, 0, len(magic)+3+len(keyID)+len(wrapped)))
+	header.Write(magic)
+	header.WriteByte(byte(len(keyID)))
+	header.WriteString(keyID)
+	_ = binary.Write(header, binary.BigEndian, uint16(len(wrapped)))
+	header.Write(wrapped)
+
+	ciphertext, err := seal(dataKey, plaintext, nil)
+	if err != nil {
+		return nil, err
+	}
+	return append(header.Bytes(), ciphertext...), nil
+}
+
+// Decrypt decrypts the record, unwrapping its data key with the master key it was encrypted with.
+func (e *Envelope) Decrypt(record []byte) ([]byte, error) {
+	if !bytes.HasPrefix(record, magic) {
+		if len(e.LegacySecret) == 0 {
+			return nil, ErrNoLegacySecret
+		}
+		return open(e.LegacySecret, record, nil)
+	}
+	keyID, wrapped, ciphertext, err := parse(record)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.KMS.UnwrapKey(keyID, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	return open(dataKey, ciphertext, nil)
+}
+
+// NeedsReencryption returns true if the record is not encrypted with the current master key.
+func (e *Envelope) NeedsReencryption(record []byte) bool {
+	return len(record) > 0 && KeyID(record) != e.KMS.CurrentKeyID()
+}
+
+// KeyID returns the ID of the master key the record is encrypted with, or an empty string for the records encrypted
+// before the envelope encryption.
+func KeyID(record []byte) string {
+	if !bytes.HasPrefix(record, magic) {
+		return ""
+	}
+	keyID, _, _, err := parse(record)
+	if err != nil {
+		return ""
+	}
+	return keyID
+}
+
+func parse(record []byte) (keyID string, wrapped, ciphertext []byte, err error) {
+	rest := record[len(magic):]
+	if len(rest) < 1 || len(rest) < 1+int(rest[0])+2 {
+		return "", nil, nil, ErrMalformedCiphertext
+	}
+	keyID, rest = string(rest[1:1+int(rest[0])]), rest[1+int(rest[0]):]
+	wrappedLen := int(binary.BigEndian.Uint16(rest))
+	if len(rest) < 2+wrappedLen {
+		return "", nil, nil, ErrMalformedCiphertext
+	}
+	return keyID, rest[2 : 2+wrappedLen], rest[2+wrappedLen:], nil
+}
+
+// seal encrypts with AES-GCM, returning nonce|ciphertext|tag.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// open decrypts the output of seal.
+func open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, ErrMalformedCiphertext
+	}
+	return gcm.Open(nil, ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():], additionalData)
+}
+
+// FromConfig returns the Envelope of the configuration: the master keys are loaded from the key file (see LoadLocalKMS)
+// or, without one, the secret is the only master key, DefaultKeyID. The secret also decrypts the records encrypted
+// before the envelope encryption.
+func FromConfig(cfg config.Encryption) (*Envelope, error) {
+	secret := []byte(cfg.Secret)
+	var kms KMS
+	var err error
+	if cfg.KeysFile != "" {
+		kms, err = LoadLocalKMS(cfg.KeysFile)
+	} else {
+		kms, err = NewLocalKMS(DefaultKeyID, map[string][]byte{DefaultKeyID: secret})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Envelope{KMS: kms, LegacySecret: secret}, nil
+}
@@ -0,0 +1,106 @@
+package encryption
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	legacySecret = []byte("super-secure-secret-something-ff")
+	oldKey       = bytes.Repeat([]byte{1}, 32)
+	newKey       = bytes.Repeat([]byte{2}, 32)
+)
+
+func testEnvelope(t *testing.T, current string) *Envelope {
+	kms, err := NewLocalKMS(current, map[string][]byte{"k1": oldKey, "k2": newKey})
+	require.NoError(t, err)
+	return &Envelope{KMS: kms, LegacySecret: legacySecret}
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	e := testEnvelope(t, "k1")
+	record, err := e.Encrypt([]byte(`{"app_id": "id", "app_secret": "secret"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "k1", KeyID(record))
+	assert.False(t, e.NeedsReencryption(record))
+
+	plaintext, err := e.Decrypt(record)
+	require.NoError(t, err)
+	assert.Equal(t, `{"app_id": "id", "app_secret": "secret"}`, string(plaintext))
+
+	// each record has its own data key.
+	other, err := e.Encrypt(plaintext)
+	require.NoError(t, err)
+	assert.NotEqual(t, record, other)
+}
+
+func TestEnvelopeRotation(t *testing.T) {
+	record, err := testEnvelope(t, "k1").Encrypt([]byte("refresh-token"))
+	require.NoError(t, err)
+
+	rotated := testEnvelope(t, "k2")
+	assert.True(t, rotated.NeedsReencryption(record))
+	plaintext, err := rotated.Decrypt(record)
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-token", string(plaintext))
+
+	// once k1 is retired, its records can not be read anymore.
+	kms, err := NewLocalKMS("k2", map[string][]byte{"k2": newKey})
+	require.NoError(t, err)
+	_, err = (&Envelope{KMS: kms}).Decrypt(record)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestEnvelopeLegacyRecords(t *testing.T) {
+	legacy, err := seal(legacySecret, []byte("refresh-token"), nil)
+	require.NoError(t, err)
+
+	e := testEnvelope(t, "k2")
+	assert.Equal(t, "", KeyID(legacy))
+	assert.True(t, e.NeedsReencryption(legacy))
+	plaintext, err := e.Decrypt(legacy)
+	require.NoError(t, err)
+	assert.Equal(t, "refresh-token", string(plaintext))
+
+	e.LegacySecret = nil
+	_, err = e.Decrypt(legacy)
+	assert.ErrorIs(t, err, ErrNoLegacySecret)
+}
+
+func TestEnvelopeTamperedRecord(t *testing.T) {
+	e := testEnvelope(t, "k1")
+	record, err := e.Encrypt([]byte("refresh-token"))
+	require.NoError(t, err)
+
+	record[len(record)-1] ^= 1
+	_, err = e.Decrypt(record)
+	assert.Error(t, err)
+
+	_, err = e.Decrypt(append([]byte{}, magic...))
+	assert.ErrorIs(t, err, ErrMalformedCiphertext)
+}
+
+func TestLoadLocalKMS(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	content := `{"current": "k2", "keys": {"k1": "` + base64.StdEncoding.EncodeToString(oldKey) + `", "k2": "` +
+		base64.StdEncoding.EncodeToString(newKey) + `"}}`
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	kms, err := LoadLocalKMS(path)
+	require.NoError(t, err)
+	assert.Equal(t, "k2", kms.CurrentKeyID())
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"current": "k3", "keys": {"k1": "`+base64.StdEncoding.EncodeToString(oldKey)+`"}}`), 0600))
+	_, err = LoadLocalKMS(path)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"current": "k1", "keys": {"k1": "c2hvcnQ="}}`), 0600))
+	_, err = LoadLocalKMS(path)
+	assert.Error(t, err)
+}
@@ -0,0 +1,98 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUnknownKey is returned when a data key is wrapped with a master key the KMS does not have.
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KMS holds the master keys, identified by their key ID, that wrap the data keys of the records. The master keys never
+// leave the KMS, so it can be backed by a cloud KMS as well as the local key file.
+type KMS interface {
+	// CurrentKeyID returns the ID of the master key the new data keys are wrapped with.
+	CurrentKeyID() string
+	// WrapKey encrypts the data key with the master key keyID.
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts the data key wrapped with the master key keyID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// LocalKMS is a KMS with the master keys in memory, loaded from a local key file.
+type LocalKMS struct {
+	current string
+	keys    map[string][]byte
+}
+
+// localKeyFile is the format of the key file of the LocalKMS, e.g.
+//
+//	{"current": "2024-06", "keys": {"2024-01": "<base64>", "2024-06": "<base64>"}}
+//
+// where the keys are 16, 24 or 32 random bytes (AES-128, 192 or 256), base64 encoded.
+type localKeyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+}
+
+// NewLocalKMS returns a LocalKMS with the master keys, current being the ID of the one new data keys are wrapped with.
+func NewLocalKMS(current string, keys map[string][]byte) (*LocalKMS, error) {
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keys", current)
+	}
+	for id, key := range keys {
+		if id == "" || len(id) > 255 {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, err)
+		}
+	}
+	return &LocalKMS{current: current, keys: keys}, nil
+}
+
+// LoadLocalKMS returns the LocalKMS with the master keys of the key file at path.
+func LoadLocalKMS(path string) (*LocalKMS, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file localKeyFile
+	if err = json.Unmarshal(content, &file); err != nil {
+		return nil, fmt.Errorf("invalid key file: %w", err)
+	}
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, dErr := base64.StdEncoding.DecodeString(encoded)
+		if dErr != nil {
+			return nil, fmt.Errorf("invalid key %q: %w", id, dErr)
+		}
+		keys[id] = key
+	}
+	return NewLocalKMS(file.Current, keys)
+}
+
+func (l *LocalKMS) CurrentKeyID() string {
+	return l.current
+}
+
+func (l *LocalKMS) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	key, ok := l.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	// the key id is authenticated with the data key, so that a wrapped key can not be passed off as another's.
+	return seal(key, dataKey, []byte(keyID))
+}
+
+func (l *LocalKMS) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := l.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return open(key, wrapped, []byte(keyID))
+}
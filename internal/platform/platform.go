@@ -1,32 +1,35 @@
 package platform_internal
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/internal/encryption"
 	"orchdio/services/applemusic"
 	"orchdio/services/deezer"
+	"orchdio/services/sandbox"
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
 	"orchdio/services/ytmusic"
-	"orchdio/util"
 	svixwebhook "orchdio/webhooks/svix"
-	"os"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
+	"github.com/samber/lo"
 )
 
 type PlatformService interface {
-	SearchTrackWithTitle(searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error)
-	SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error)
-	FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error)
-	FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error
-	FetchLibraryAlbums(refreshToken string) ([]blueprint.LibraryAlbum, error)
-	FetchListeningHistory(refreshToken string) ([]blueprint.TrackSearchResult, error)
-	FetchUserArtists(refreshToken string) (*blueprint.UserLibraryArtists, error)
-	FetchLibraryPlaylists(refreshToken string) ([]blueprint.UserPlaylist, error)
+	SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error)
+	SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error)
+	FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error)
+	FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error
+	FetchLibraryAlbums(accessToken string) ([]blueprint.LibraryAlbum, error)
+	FetchListeningHistory(accessToken string) ([]blueprint.TrackSearchResult, error)
+	FetchUserArtists(accessToken string) (*blueprint.UserLibraryArtists, error)
+	FetchLibraryPlaylists(accessToken string) ([]blueprint.UserPlaylist, error)
 	FetchUserInfo(authInfo blueprint.UserAuthInfoForRequests) (*blueprint.UserPlatformInfo, error)
 }
 
@@ -35,13 +38,25 @@ type PlatformServiceFactory struct {
 	Red           *redis.Client
 	App           *blueprint.DeveloperApp
 	WebhookSender svixwebhook.SvixInterface
+	// Envelope decrypts the integration credentials of the app.
+	Envelope *encryption.Envelope
+	// Config is the configuration of the process, passed to the platform services.
+	Config *config.Config
 }
 
-func NewPlatformServiceFactory(pg *sqlx.DB, red *redis.Client, app *blueprint.DeveloperApp, webhookSender svixwebhook.SvixInterface) *PlatformServiceFactory {
-	return &PlatformServiceFactory{pg, red, app, webhookSender}
+func NewPlatformServiceFactory(pg *sqlx.DB, red *redis.Client, app *blueprint.DeveloperApp, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) *PlatformServiceFactory {
+	return &PlatformServiceFactory{pg, red, app, webhookSender, envelope, cfg}
 }
 
 func (pf *PlatformServiceFactory) GetPlatformService(platform string) (PlatformService, error) {
+	// the requests made with a test key of the app use the fake platforms of the sandbox, which need no credentials.
+	if pf.App != nil && pf.App.Sandbox {
+		if !lo.Contains([]string{spotify.IDENTIFIER, deezer.IDENTIFIER, applemusic.IDENTIFIER, tidal.IDENTIFIER, ytmusic.IDENTIFIER}, platform) {
+			return nil, fmt.Errorf("platform service not found in platform service: %s", platform)
+		}
+		return sandbox.NewService(platform, pf.App), nil
+	}
+
 	credentials, err := pf.getCredentials(platform)
 	if err != nil {
 		log.Printf("%v\n", err)
@@ -51,20 +66,20 @@ func (pf *PlatformServiceFactory) GetPlatformService(platform string) (PlatformS
 
 	switch platform {
 	case spotify.IDENTIFIER:
-		return spotify.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.WebhookSender), nil
+		return spotify.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.WebhookSender, pf.Config), nil
 
 	case deezer.IDENTIFIER:
-		return deezer.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.WebhookSender), nil
+		return deezer.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.WebhookSender, pf.Config), nil
 
 	case applemusic.IDENTIFIER:
-		return applemusic.NewService(credentials, pf.Pg, pf.Red, pf.App), nil
+		return applemusic.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.Config), nil
 
 	case tidal.IDENTIFIER:
-		return tidal.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.WebhookSender), nil
+		return tidal.NewService(credentials, pf.Pg, pf.Red, pf.App, pf.WebhookSender, pf.Config), nil
 
 	case ytmusic.IDENTIFIER:
 		// note: ytmusic does not require credentials (yet)
-		return ytmusic.NewService(pf.Red, pf.App), nil
+		return ytmusic.NewService(pf.Red, pf.App, pf.Config), nil
 	default:
 		return nil, fmt.Errorf("platform service not found in platform service: %s", platform)
 	}
@@ -118,7 +133,7 @@ func (pf *PlatformServiceFactory) getCredentials(platform string) (*blueprint.In
 		return nil, fmt.Errorf("unsupported platform %s", platform)
 	}
 
-	credentialBytes, err := util.Decrypt(encryptedCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
+	credentialBytes, err := pf.Envelope.Decrypt(encryptedCredentials)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
 	}
@@ -0,0 +1,188 @@
+package service
+
+import (
+	"context"
+	"orchdio/blueprint"
+	"orchdio/logger"
+	svixwebhook "orchdio/webhooks/svix"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// webhookBatchSettings returns the batching of the playlist conversion track events for the app. The app settings take
+// precedence over the server defaults (see config.Webhooks).
+func webhookBatchSettings(app *blueprint.DeveloperApp, defaults blueprint.WebhookBatchSettings) blueprint.WebhookBatchSettings {
+	settings := defaults
+	if app != nil {
+		if app.WebhookBatchSize > 0 {
+			settings.Size = app.WebhookBatchSize
+		}
+		if app.WebhookBatchIntervalMs > 0 {
+			settings.IntervalMs = app.WebhookBatchIntervalMs
+		}
+	}
+
+	settings.Size = min(settings.Size, blueprint.MaxWebhookBatchSize)
+	settings.IntervalMs = min(settings.IntervalMs, blueprint.MaxWebhookBatchIntervalMs)
+	return settings
+}
+
+// trackEventBatcher accumulates the converted (and missing) tracks of a playlist conversion and sends them in batched
+// webhook events, every size tracks or every interval, whichever comes first. Close must be called once all the tracks
+// have been added: it sends the remaining tracks, so that events sent after it (i.e. the done event) come after the last batch.
+// Discard is called instead if the conversion is interrupted.
+type trackEventBatcher struct {
+	// ctx is the context of the conversion, for the spans and the logs of the events.
+	ctx          context.Context
+	sender       svixwebhook.SvixInterface
+	webhookAppId string
+	app          string
+	taskId       string
+	size         int
+
+	mu            sync.Mutex
+	tracks        []blueprint.PlaylistTrackConversionEventPayload
+	trackCount    int
+	missingTracks []blueprint.MissingTrackMeta
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func newTrackEventBatcher(ctx context.Context, sender svixwebhook.SvixInterface, webhookAppId, app, taskId string, settings blueprint.WebhookBatchSettings) *trackEventBatcher {
+	b := &trackEventBatcher{
+		ctx:          ctx,
+		sender:       sender,
+		webhookAppId: webhookAppId,
+		app:          app,
+		taskId:       taskId,
+		size:         max(settings.Size, 1),
+		stop:         make(chan struct{}),
+	}
+
+	if settings.IntervalMs > 0 && b.size > 1 {
+		b.wg.Add(1)
+		go b.flushEvery(time.Duration(settings.IntervalMs) * time.Millisecond)
+	}
+	return b
+}
+
+func (b *trackEventBatcher) flushEvery(interval time.Duration) {
+	defer b.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			b.flush()
+		}
+	}
+}
+
+// AddTrack adds a converted track. source and target are the track on the source and the target platforms.
+func (b *trackEventBatcher) AddTrack(source, target blueprint.PlaylistTrackConversionEventPayload) {
+	b.mu.Lock()
+	b.tracks = append(b.tracks, source, target)
+	b.trackCount++
+	var tracks []blueprint.PlaylistTrackConversionEventPayload
+	var count int
+	if b.trackCount >= b.size {
+		tracks, count = b.takeTracks()
+	}
+	b.mu.Unlock()
+	b.sendTracks(tracks, count)
+}
+
+// AddMissingTrack adds a track that could not be found on the target platform.
+func (b *trackEventBatcher) AddMissingTrack(meta blueprint.MissingTrackMeta) {
+	b.mu.Lock()
+	b.missingTracks = append(b.missingTracks, meta)
+	var missingTracks []blueprint.MissingTrackMeta
+	if len(b.missingTracks) >= b.size {
+		missingTracks = b.takeMissingTracks()
+	}
+	b.mu.Unlock()
+	b.sendMissingTracks(missingTracks)
+}
+
+// Close stops the periodic flush and sends the remaining tracks.
+func (b *trackEventBatcher) Close() {
+	close(b.stop)
+	b.wg.Wait()
+	b.flush()
+}
+
+// Discard stops the periodic flush and drops the remaining tracks, for conversions that are interrupted: their tracks
+// are converted (and sent) again when the conversion is retried.
+func (b *trackEventBatcher) Discard() {
+	close(b.stop)
+	b.wg.Wait()
+	b.mu.Lock()
+	b.takeTracks()
+	b.takeMissingTracks()
+	b.mu.Unlock()
+}
+
+// flush sends the accumulated tracks and missing tracks. the buffers are swapped out under b.mu and sent after it is
+// released, so that adding tracks is not blocked by the delivery of the events.
+func (b *trackEventBatcher) flush() {
+	b.mu.Lock()
+	tracks, count := b.takeTracks()
+	missingTracks := b.takeMissingTracks()
+	b.mu.Unlock()
+
+	b.sendTracks(tracks, count)
+	b.sendMissingTracks(missingTracks)
+}
+
+// takeTracks empties the accumulated tracks and returns them with the number of track pairs. b.mu must be held.
+func (b *trackEventBatcher) takeTracks() ([]blueprint.PlaylistTrackConversionEventPayload, int) {
+	tracks, count := b.tracks, b.trackCount
+	b.tracks = nil
+	b.trackCount = 0
+	return tracks, count
+}
+
+// takeMissingTracks empties the accumulated missing tracks and returns them. b.mu must be held.
+func (b *trackEventBatcher) takeMissingTracks() []blueprint.MissingTrackMeta {
+	missingTracks := b.missingTracks
+	b.missingTracks = nil
+	return missingTracks
+}
+
+// sendTracks sends the tracks in a single event. b.mu must not be held.
+func (b *trackEventBatcher) sendTracks(tracks []blueprint.PlaylistTrackConversionEventPayload, count int) {
+	if len(tracks) == 0 {
+		return
+	}
+	data := &blueprint.PlaylistTrackConversionEventResponse{
+		TaskID: b.taskId,
+		Tracks: tracks,
+	}
+	err := sendEvent(b.ctx, b.sender, b.webhookAppId, blueprint.PlaylistConversionTrackEvent,
+		blueprint.NewWebhookEvent(blueprint.PlaylistConversionTrackEvent, b.app, b.taskId, data))
+	if err != nil {
+		logger.FromContext(b.ctx).Error("[service][trackEventBatcher] - error sending playlist track conversion webhook", zap.Int("tracks", count), zap.Error(err))
+	}
+}
+
+// sendMissingTracks sends the missing tracks in a single event. b.mu must not be held.
+func (b *trackEventBatcher) sendMissingTracks(missingTracks []blueprint.MissingTrackMeta) {
+	if len(missingTracks) == 0 {
+		return
+	}
+	data := &blueprint.MissingTrackEventPayload{
+		TaskID:    b.taskId,
+		TrackMeta: missingTracks[0],
+		Tracks:    missingTracks,
+	}
+	err := sendEvent(b.ctx, b.sender, b.webhookAppId, blueprint.PlaylistConversionMissingTrackEvent,
+		blueprint.NewWebhookEvent(blueprint.PlaylistConversionMissingTrackEvent, b.app, b.taskId, data))
+	if err != nil {
+		logger.FromContext(b.ctx).Error("[service][trackEventBatcher] - error sending missing track webhook", zap.Int("tracks", len(missingTracks)), zap.Error(err))
+	}
+}
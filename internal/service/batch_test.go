@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"orchdio/blueprint"
+	"orchdio/logger"
+	svixwebhook "orchdio/webhooks/svix"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	svix "github.com/svix/svix-webhooks/go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// recordingSender records the events sent through it.
+type recordingSender struct {
+	svixwebhook.SvixInterface
+	mu     sync.Mutex
+	events []*blueprint.WebhookEvent
+}
+
+func (r *recordingSender) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, blueprint.ToWebhookEvent(eventType, payload))
+	return &svix.MessageOut{}, nil
+}
+
+func (r *recordingSender) Events() []*blueprint.WebhookEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]*blueprint.WebhookEvent{}, r.events...)
+}
+
+func testTrackPair(title string) (blueprint.PlaylistTrackConversionEventPayload, blueprint.PlaylistTrackConversionEventPayload) {
+	return blueprint.PlaylistTrackConversionEventPayload{Platform: "spotify", Track: &blueprint.TrackSearchResult{Title: title}},
+		blueprint.PlaylistTrackConversionEventPayload{Platform: "deezer", Track: &blueprint.TrackSearchResult{Title: title}}
+}
+
+func TestTrackEventBatcherSize(t *testing.T) {
+	sender := &recordingSender{}
+	batcher := newTrackEventBatcher(context.Background(), sender, "wh_app", "app", "task", blueprint.WebhookBatchSettings{Size: 2})
+
+	for _, title := range []string{"one", "two", "three"} {
+		batcher.AddTrack(testTrackPair(title))
+	}
+	batcher.AddMissingTrack(blueprint.MissingTrackMeta{Platform: "spotify", MissingPlatform: "deezer"})
+
+	// the first two tracks are sent as soon as the batch is full.
+	events := sender.Events()
+	assert.Len(t, events, 1)
+	assert.Len(t, events[0].Data.(*blueprint.PlaylistTrackConversionEventResponse).Tracks, 4)
+
+	batcher.Close()
+	events = sender.Events()
+	assert.Len(t, events, 3)
+	assert.Equal(t, blueprint.PlaylistConversionTrackEvent, events[1].Type)
+	assert.Len(t, events[1].Data.(*blueprint.PlaylistTrackConversionEventResponse).Tracks, 2)
+	assert.Equal(t, blueprint.PlaylistConversionMissingTrackEvent, events[2].Type)
+	assert.Len(t, events[2].Data.(*blueprint.MissingTrackEventPayload).Tracks, 1)
+	assert.Equal(t, "task", events[2].TaskID)
+}
+
+func TestTrackEventBatcherInterval(t *testing.T) {
+	sender := &recordingSender{}
+	batcher := newTrackEventBatcher(context.Background(), sender, "wh_app", "app", "task", blueprint.WebhookBatchSettings{Size: 100, IntervalMs: 10})
+
+	batcher.AddTrack(testTrackPair("one"))
+	assert.Eventually(t, func() bool {
+		return len(sender.Events()) == 1
+	}, time.Second, 5*time.Millisecond)
+
+	batcher.Close()
+	assert.Len(t, sender.Events(), 1)
+}
+
+func TestTrackEventBatcherDiscard(t *testing.T) {
+	sender := &recordingSender{}
+	batcher := newTrackEventBatcher(context.Background(), sender, "wh_app", "app", "task", blueprint.WebhookBatchSettings{Size: 100, IntervalMs: 10})
+
+	batcher.AddTrack(testTrackPair("one"))
+	batcher.AddMissingTrack(blueprint.MissingTrackMeta{Platform: "spotify", MissingPlatform: "deezer"})
+	batcher.Discard()
+
+	// the pending tracks are not sent, now or by the periodic flush.
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, sender.Events())
+}
+
+// blockingSender signals sending and blocks every event until release is closed.
+type blockingSender struct {
+	recordingSender
+	sending chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingSender) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
+	b.sending <- struct{}{}
+	<-b.release
+	return b.recordingSender.SendEvent(appId, eventType, payload)
+}
+
+func TestTrackEventBatcherSendsOutsideLock(t *testing.T) {
+	sender := &blockingSender{sending: make(chan struct{}, 2), release: make(chan struct{})}
+	batcher := newTrackEventBatcher(context.Background(), sender, "wh_app", "app", "task", blueprint.WebhookBatchSettings{Size: 1})
+
+	sent := make(chan struct{})
+	go func() {
+		batcher.AddTrack(testTrackPair("one"))
+		close(sent)
+	}()
+
+	// the missing track is added while the track event is being delivered.
+	<-sender.sending
+	added := make(chan struct{})
+	go func() {
+		batcher.mu.Lock()
+		batcher.missingTracks = append(batcher.missingTracks, blueprint.MissingTrackMeta{Platform: "spotify"})
+		batcher.mu.Unlock()
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("the batcher lock is held while sending an event")
+	}
+
+	close(sender.release)
+	<-sent
+	batcher.Close()
+	assert.Len(t, sender.Events(), 2)
+}
+
+func TestWebhookBatchSettings(t *testing.T) {
+	defaults := blueprint.WebhookBatchSettings{Size: 10, IntervalMs: 0}
+
+	assert.Equal(t, blueprint.WebhookBatchSettings{Size: 10, IntervalMs: 0}, webhookBatchSettings(&blueprint.DeveloperApp{}, defaults))
+	assert.Equal(t, blueprint.WebhookBatchSettings{Size: blueprint.MaxWebhookBatchSize, IntervalMs: 500},
+		webhookBatchSettings(&blueprint.DeveloperApp{WebhookBatchSize: 1000, WebhookBatchIntervalMs: 500}, defaults))
+}
+
+// failingSender fails to send every event.
+type failingSender struct {
+	svixwebhook.SvixInterface
+}
+
+func (failingSender) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
+	return nil, errors.New("unavailable")
+}
+
+func TestTrackEventBatcherLogsWithConversionLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	ctx := logger.WithRequest(context.Background(), "req-1", zap.New(core).With(zap.String(logger.FieldRequestID, "req-1")))
+	batcher := newTrackEventBatcher(ctx, failingSender{}, "wh_app", "app", "task", blueprint.WebhookBatchSettings{Size: 1})
+
+	batcher.AddTrack(testTrackPair("one"))
+
+	entries := logs.All()
+	require.Len(t, entries, 1)
+	assert.Equal(t, "req-1", entries[0].ContextMap()[logger.FieldRequestID])
+	assert.Equal(t, int64(1), entries[0].ContextMap()["tracks"])
+}
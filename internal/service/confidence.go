@@ -0,0 +1,61 @@
+package service
+
+import (
+	"orchdio/blueprint"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// matchConfidence returns how close the track found on the target platform is to the track searched, from 0 to 1: the
+// mean of the similarity of their titles and of their artists. The platforms return their best result for the search,
+// so a low confidence usually means that the track is not on the target platform and another one was returned.
+func matchConfidence(searched *blueprint.TrackSearchData, found *blueprint.TrackSearchResult) float64 {
+	if searched == nil || found == nil {
+		return 0
+	}
+	title := similarity(searched.Title, found.Title)
+	artists := 0.0
+	for _, a := range searched.Artists {
+		for _, b := range found.Artists {
+			if s := similarity(a, b); s > artists {
+				artists = s
+			}
+		}
+	}
+	return (title + artists) / 2
+}
+
+// similarity is the Jaccard index of the words of a and b, ignoring case, accents and punctuation.
+func similarity(a, b string) float64 {
+	wordsA, wordsB := words(a), words(b)
+	if len(wordsA) == 0 || len(wordsB) == 0 {
+		return 0
+	}
+	common := 0
+	for w := range wordsA {
+		if wordsB[w] {
+			common++
+		}
+	}
+	return float64(common) / float64(len(wordsA)+len(wordsB)-common)
+}
+
+func words(s string) map[string]bool {
+	s = strings.ToLower(norm.NFD.String(s))
+	set := map[string]bool{}
+	for _, w := range strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.Is(unicode.Mn, r)
+	}) {
+		// the accents are separate runes once decomposed.
+		w = strings.Map(func(r rune) rune {
+			if unicode.Is(unicode.Mn, r) {
+				return -1
+			}
+			return r
+		}, w)
+		set[w] = true
+	}
+	return set
+}
@@ -0,0 +1,26 @@
+package service
+
+import (
+	"orchdio/blueprint"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchConfidence(t *testing.T) {
+	searched := &blueprint.TrackSearchData{Title: "Lose Yourself", Artists: []string{"Eminem"}}
+
+	exact := &blueprint.TrackSearchResult{Title: "Lose Yourself", Artists: []string{"Eminem"}}
+	assert.Equal(t, 1.0, matchConfidence(searched, exact))
+
+	// case, accents and punctuation are ignored, and the best artist is used.
+	accents := &blueprint.TrackSearchResult{Title: "LOSE yourself!", Artists: []string{"Dr. Dre", "Éminem"}}
+	assert.Equal(t, 1.0, matchConfidence(searched, accents))
+
+	version := &blueprint.TrackSearchResult{Title: "Lose Yourself (Live)", Artists: []string{"Eminem"}}
+	assert.InDelta(t, 0.83, matchConfidence(searched, version), 0.01)
+
+	other := &blueprint.TrackSearchResult{Title: "Stan", Artists: []string{"Dido"}}
+	assert.Equal(t, 0.0, matchConfidence(searched, other))
+	assert.Equal(t, 0.0, matchConfidence(searched, nil))
+}
@@ -1,20 +1,27 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/db"
 	platforminternal "orchdio/internal/platform"
+	"orchdio/logger"
+	"orchdio/metrics"
 	"orchdio/services/applemusic"
 	"orchdio/services/deezer"
+	"orchdio/services/ratelimit"
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
 	"orchdio/services/ytmusic"
+	"orchdio/tracing"
 	"orchdio/util"
 	"sync"
 
 	"github.com/samber/lo"
+	"go.uber.org/zap"
 )
 
 type Service struct {
@@ -53,14 +60,14 @@ func (pc *Service) FetchUserInfo(authInfo blueprint.UserAuthInfoForRequests) (*b
 	return user, nil
 }
 
-func (pc *Service) FetchLibraryPlaylists(platform, refreshToken string) ([]blueprint.UserPlaylist, error) {
+func (pc *Service) FetchLibraryPlaylists(platform, accessToken string) ([]blueprint.UserPlaylist, error) {
 	platformService, sErr := pc.factory.GetPlatformService(platform)
 	if sErr != nil {
 		log.Println(sErr)
 		return nil, sErr
 	}
 
-	history, err := platformService.FetchLibraryPlaylists(refreshToken)
+	history, err := platformService.FetchLibraryPlaylists(accessToken)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -68,14 +75,14 @@ func (pc *Service) FetchLibraryPlaylists(platform, refreshToken string) ([]bluep
 	return history, nil
 }
 
-func (pc *Service) FetchLibraryArtists(platform, refreshToken string) (*blueprint.UserLibraryArtists, error) {
+func (pc *Service) FetchLibraryArtists(platform, accessToken string) (*blueprint.UserLibraryArtists, error) {
 	platformService, sErr := pc.factory.GetPlatformService(platform)
 	if sErr != nil {
 		log.Println(sErr)
 		return nil, sErr
 	}
 
-	history, err := platformService.FetchUserArtists(refreshToken)
+	history, err := platformService.FetchUserArtists(accessToken)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -85,14 +92,14 @@ func (pc *Service) FetchLibraryArtists(platform, refreshToken string) (*blueprin
 
 }
 
-func (pc *Service) FetchListeningHistory(platform, refreshToken string) ([]blueprint.TrackSearchResult, error) {
+func (pc *Service) FetchListeningHistory(platform, accessToken string) ([]blueprint.TrackSearchResult, error) {
 	platformService, sErr := pc.factory.GetPlatformService(platform)
 	if sErr != nil {
 		log.Println(sErr)
 		return nil, sErr
 	}
 
-	history, err := platformService.FetchListeningHistory(refreshToken)
+	history, err := platformService.FetchListeningHistory(accessToken)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -101,7 +108,7 @@ func (pc *Service) FetchListeningHistory(platform, refreshToken string) ([]bluep
 	return history, nil
 
 }
-func (pc *Service) FetchLibraryAlbums(platform, refreshToken string) ([]blueprint.LibraryAlbum, error) {
+func (pc *Service) FetchLibraryAlbums(platform, accessToken string) ([]blueprint.LibraryAlbum, error) {
 
 	platformService, sErr := pc.factory.GetPlatformService(platform)
 	if sErr != nil {
@@ -109,7 +116,7 @@ func (pc *Service) FetchLibraryAlbums(platform, refreshToken string) ([]blueprin
 		return nil, sErr
 	}
 
-	libAlbums, err := platformService.FetchLibraryAlbums(refreshToken)
+	libAlbums, err := platformService.FetchLibraryAlbums(accessToken)
 	if err != nil {
 		log.Println(err)
 		return nil, err
@@ -118,16 +125,26 @@ func (pc *Service) FetchLibraryAlbums(platform, refreshToken string) ([]blueprin
 	return libAlbums, nil
 }
 
-func (pc *Service) ConvertTrack(info *blueprint.LinkInfo) (*blueprint.TrackConversion, error) {
+func (pc *Service) ConvertTrack(ctx context.Context, info *blueprint.LinkInfo) (conversion *blueprint.TrackConversion, err error) {
+	ctx, span := tracing.Start(ctx, "service.ConvertTrack", tracing.AttrEntity.String("track"),
+		tracing.AttrPlatform.String(info.Platform), tracing.AttrTargetPlatform.String(info.TargetPlatform))
+	defer func() {
+		metrics.Conversion("track", info.Platform, info.TargetPlatform, err)
+		tracing.End(span, err)
+	}()
+	l := logger.FromContext(ctx)
+
 	srcPlatformService, sErr := pc.factory.GetPlatformService(info.Platform)
 	if sErr != nil {
-		log.Println(sErr)
+		l.Error("[service][ConvertTrack] - error getting the source platform service", zap.Error(sErr))
 		return nil, sErr
 	}
 
-	srcTrackResult, stErr := srcPlatformService.SearchTrackWithID(info)
+	idCtx, idSpan := tracing.Start(ctx, "platform.SearchTrackWithID", tracing.AttrPlatform.String(info.Platform))
+	srcTrackResult, stErr := srcPlatformService.SearchTrackWithID(idCtx, info)
+	tracing.End(idSpan, stErr)
 	if stErr != nil {
-		log.Println(stErr)
+		l.Error("[service][ConvertTrack] - error fetching the source track", zap.Error(stErr))
 		return nil, stErr
 	}
 
@@ -141,7 +158,7 @@ func (pc *Service) ConvertTrack(info *blueprint.LinkInfo) (*blueprint.TrackConve
 
 	uErr := pc.updatePlatformTracks(info.Platform, trackConversion, srcTrackResult)
 	if uErr != nil {
-		log.Println(uErr)
+		l.Error("[service][ConvertTrack] - error adding the source track to the conversion", zap.Error(uErr))
 		return nil, uErr
 	}
 
@@ -181,7 +198,7 @@ func (pc *Service) ConvertTrack(info *blueprint.LinkInfo) (*blueprint.TrackConve
 
 		allTargetPlatformServiceFactories, pErr := pc.factory.GetPlatformServices(targetPlats)
 		if pErr != nil {
-			log.Println(pErr)
+			l.Error("[service][ConvertTrack] - error getting the target platform services", zap.Error(pErr))
 			return nil, pErr
 		}
 
@@ -191,7 +208,7 @@ func (pc *Service) ConvertTrack(info *blueprint.LinkInfo) (*blueprint.TrackConve
 		for i := range targetPlats {
 			instance := allTargetPlatformServiceFactories[i]
 
-			platformSearchResult, spErr := instance.SearchTrackWithTitle(searchData, authInfo)
+			platformSearchResult, spErr := searchTrackWithTitle(ctx, targetPlats[i], instance, searchData, authInfo)
 			if spErr != nil {
 				// note: for some reason, the platform could not convert the track.
 				// in the final result, this will be nil and the platform would simply be
@@ -199,10 +216,11 @@ func (pc *Service) ConvertTrack(info *blueprint.LinkInfo) (*blueprint.TrackConve
 				continue
 			}
 
+			metrics.MatchConfidence.WithLabelValues(info.Platform, targetPlats[i]).Observe(matchConfidence(searchData, platformSearchResult))
 			toResult = append(toResult, *platformSearchResult)
 			upErr := pc.updatePlatformTracks(targetPlats[i], trackConversion, platformSearchResult)
 			if upErr != nil {
-				log.Println(upErr)
+				l.Error("[service][ConvertTrack] - error adding the target track to the conversion", zap.Error(upErr))
 				return nil, upErr
 			}
 		}
@@ -213,26 +231,36 @@ func (pc *Service) ConvertTrack(info *blueprint.LinkInfo) (*blueprint.TrackConve
 	// now do for non-all target platform
 	targetPlatformService, tErr := pc.factory.GetPlatformService(info.TargetPlatform)
 	if tErr != nil {
-		log.Println(tErr)
+		l.Error("[service][ConvertTrack] - error getting the target platform service", zap.Error(tErr))
 		return nil, tErr
 	}
 
-	targetTrackResult, ssErr := targetPlatformService.SearchTrackWithTitle(searchData, authInfo)
+	targetTrackResult, ssErr := searchTrackWithTitle(ctx, info.TargetPlatform, targetPlatformService, searchData, authInfo)
 	if ssErr != nil {
-		log.Println(ssErr)
+		l.Info("[service][ConvertTrack] - could not find the track on the target platform", zap.Error(ssErr))
 		return nil, ssErr
 	}
 
+	metrics.MatchConfidence.WithLabelValues(info.Platform, info.TargetPlatform).Observe(matchConfidence(searchData, targetTrackResult))
+
 	ppErr := pc.updatePlatformTracks(info.TargetPlatform, trackConversion, targetTrackResult)
 	if ppErr != nil {
-		log.Println(ppErr)
+		l.Error("[service][ConvertTrack] - error adding the target track to the conversion", zap.Error(ppErr))
 		return nil, ppErr
 	}
 	return trackConversion, nil
 }
 
 // AsynqConvertPlaylist
-func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.PlaylistConversion, error) {
+func (pc *Service) AsynqConvertPlaylist(ctx context.Context, info *blueprint.LinkInfo) (conversion *blueprint.PlaylistConversion, err error) {
+	l := logger.FromContext(ctx)
+	ctx, span := tracing.Start(ctx, "service.AsynqConvertPlaylist", tracing.AttrEntity.String("playlist"),
+		tracing.AttrPlatform.String(info.Platform), tracing.AttrTargetPlatform.String(info.TargetPlatform), tracing.AttrTaskID.String(info.TaskID))
+	defer func() {
+		metrics.Conversion("playlist", info.Platform, info.TargetPlatform, err)
+		tracing.End(span, err)
+	}()
+
 	if info.TargetPlatform == "" {
 		return nil, errors.New("target platform is required")
 	}
@@ -241,38 +269,37 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 
 	fromService, fErr := pc.factory.GetPlatformService(info.Platform)
 	if fErr != nil {
-		log.Printf("DEBUG: error getting platform service: %v", fErr)
+		l.Error("[service][AsynqConvertPlaylist] - error getting the source platform service", zap.Error(fErr))
 		return nil, fErr
 	}
 
 	toService, tErr := pc.factory.GetPlatformService(info.TargetPlatform)
 	if tErr != nil {
-		log.Printf("DEBUG: error getting platform service: %v", tErr)
+		l.Error("[service][AsynqConvertPlaylist] - error getting the target platform service", zap.Error(tErr))
 	}
 
 	// idSearchResult, sErr := fromService.SearchPlaylistWithID(info)
-	playlistMeta, sErr := fromService.FetchPlaylistMetaInfo(info)
+	metaCtx, metaSpan := tracing.Start(ctx, "platform.FetchPlaylistMetaInfo", tracing.AttrPlatform.String(info.Platform))
+	playlistMeta, sErr := fromService.FetchPlaylistMetaInfo(metaCtx, info)
+	tracing.End(metaSpan, sErr)
 	if sErr != nil {
-		log.Printf("[internal][platforms][platform_factory]: %v", sErr)
+		l.Error("[service][AsynqConvertPlaylist] - error fetching the playlist metadata", zap.Error(sErr))
 		return nil, fmt.Errorf("error searching playlist: %v", sErr)
 	}
 
-	ok := pc.factory.WebhookSender.SendPlaylistMetadataEvent(&blueprint.LinkInfo{
-		Platform: info.Platform,
-		EntityID: info.EntityID,
-		App:      pc.factory.App.WebhookAppID,
-	}, &blueprint.PlaylistConversionEventMetadata{
-		Platform:  info.Platform,
-		Meta:      playlistMeta,
-		EventType: blueprint.PlaylistConversionMetadataEvent,
-		TaskId:    info.TaskID,
-		UniqueID:  info.UniqueID,
-	})
-
-	if !ok {
-		log.Printf("[internal][platforms][platform_factory]: Could not send playlist conversion metadata event")
+	appId := pc.factory.App.UID.String()
+	metaWhErr := sendEvent(ctx, pc.factory.WebhookSender, pc.factory.App.WebhookAppID, blueprint.PlaylistConversionMetadataEvent,
+		blueprint.NewWebhookEvent(blueprint.PlaylistConversionMetadataEvent, appId, info.TaskID, &blueprint.PlaylistConversionEventMetadata{
+			Platform: info.Platform,
+			Meta:     playlistMeta,
+			TaskId:   info.TaskID,
+			UniqueID: info.UniqueID,
+		}))
+
+	if metaWhErr != nil {
+		l.Error("[service][AsynqConvertPlaylist] - could not send playlist conversion metadata event", zap.Error(metaWhErr))
 	} else {
-		log.Printf("Sent playlist metadata conversion event to webhook provider for %s", info.Platform)
+		l.Info("[service][AsynqConvertPlaylist] - sent playlist conversion metadata event")
 	}
 
 	resultChan := make(chan blueprint.TrackSearchResult)
@@ -283,6 +310,11 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 	var omittedTracksMeta []blueprint.MissingTrackEventPayload
 	var omittedTracks []blueprint.OmittedTracks
 
+	// track and missing track events are batched (see webhookBatchSettings) and the batcher is closed before the done
+	// event is sent, so that the done event always comes after the last batch.
+	eventBatcher := newTrackEventBatcher(ctx, pc.factory.WebhookSender, pc.factory.App.WebhookAppID, appId, info.TaskID,
+		webhookBatchSettings(pc.factory.App, pc.factory.Config.Webhooks.TrackBatch))
+
 	var wg sync.WaitGroup
 	wg.Add(1)
 
@@ -290,25 +322,35 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 		defer wg.Done()
 		defer close(resultChan)
 
-		fErr := fromService.FetchTracksForSourcePlatform(info, playlistMeta, resultChan)
+		fetchCtx, fetchSpan := tracing.Start(ctx, "platform.FetchTracksForSourcePlatform", tracing.AttrPlatform.String(info.Platform))
+		fErr := fromService.FetchTracksForSourcePlatform(fetchCtx, info, playlistMeta, resultChan)
+		tracing.End(fetchSpan, fErr)
 		if fErr != nil {
-			log.Printf("Error fetching tracks... %v\n\n", fErr)
+			l.Error("[service][AsynqConvertPlaylist] - error fetching the tracks of the source playlist", zap.Error(fErr))
 		}
-		log.Printf("Fetched tracks from source platform: %s", info.Platform)
+		l.Info("[service][AsynqConvertPlaylist] - fetched the tracks of the source playlist")
 	}()
 
 	wg.Add(1)
 	go func() {
 		for result := range resultChan {
-			// cache source track
-			ok := util.CacheTrackByArtistTitle(&result, pc.factory.Red, info.Platform)
-			if !ok {
-				log.Printf("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching source playlist track")
+			// the conversion is interrupted (the worker is shutting down), the tracks left are drained so that the fetch of
+			// the source tracks returns.
+			if ctx.Err() != nil {
+				continue
 			}
+			// cache source track. the tracks of the sandbox are not cached, so that they are never returned for the real
+			// platforms.
+			if !pc.factory.App.Sandbox {
+				ok := util.CacheTrackByArtistTitle(&result, pc.factory.Red, info.Platform)
+				if !ok {
+					l.Error("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching source playlist track")
+				}
 
-			ok2 := util.CacheTrackByID(&result, pc.factory.Red, info.Platform)
-			if !ok2 {
-				log.Printf("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching source playlist track")
+				ok2 := util.CacheTrackByID(&result, pc.factory.Red, info.Platform)
+				if !ok2 {
+					l.Error("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching source playlist track")
+				}
 			}
 
 			searchData := &blueprint.TrackSearchData{
@@ -337,14 +379,12 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 				// UserID:   user.UserID,
 			}
 
-			targetPlatformTrack, sErr := toService.SearchTrackWithTitle(searchData, authInfo)
+			targetPlatformTrack, sErr := searchTrackWithTitle(ctx, info.TargetPlatform, toService, searchData, authInfo)
 			if sErr == blueprint.EnoResult {
-				log.Printf("Error searching track... %v\n\n", sErr)
-				log.Printf("Should add to omitted track here, send omitted track event & then send webhook event for the available track")
+				l.Info("[service][AsynqConvertPlaylist] - track not found on the target platform, adding it to the omitted tracks", zap.String("title", result.Title))
 
 				meta := &blueprint.MissingTrackEventPayload{
-					EventType: blueprint.PlaylistConversionMissingTrackEvent,
-					TaskID:    info.TaskID,
+					TaskID: info.TaskID,
 					TrackMeta: blueprint.MissingTrackMeta{
 						Platform:        info.Platform,
 						MissingPlatform: info.TargetPlatform,
@@ -352,13 +392,7 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 					},
 				}
 
-				mRes, missingWhErr := pc.factory.WebhookSender.SendEvent(pc.factory.App.WebhookAppID, blueprint.PlaylistConversionMissingTrackEvent, meta)
-
-				if missingWhErr != nil {
-					log.Printf("Error sending missing track webhook event... %v\n\n", missingWhErr)
-				}
-
-				log.Printf("Missing playlist track webhook event response is: %v", mRes)
+				eventBatcher.AddMissingTrack(meta.TrackMeta)
 				omittedTracksMeta = append(omittedTracksMeta, *meta)
 
 				omittedTrack := &blueprint.OmittedTracks{
@@ -375,36 +409,27 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 			}
 
 			// cache target track result
-			ok3 := util.CacheTrackByArtistTitle(targetPlatformTrack, pc.factory.Red, info.TargetPlatform)
-			if !ok3 {
-				log.Printf("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching target playlist track")
-			}
+			if !pc.factory.App.Sandbox {
+				ok3 := util.CacheTrackByArtistTitle(targetPlatformTrack, pc.factory.Red, info.TargetPlatform)
+				if !ok3 {
+					l.Error("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching target playlist track")
+				}
 
-			ok4 := util.CacheTrackByID(targetPlatformTrack, pc.factory.Red, info.TargetPlatform)
-			if !ok4 {
-				log.Printf("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching target playlist track")
+				ok4 := util.CacheTrackByID(targetPlatformTrack, pc.factory.Red, info.TargetPlatform)
+				if !ok4 {
+					l.Error("[service][AsynqConvertPlaylist][track-result-cache-error] Error caching target playlist track")
+				}
 			}
 
+			metrics.MatchConfidence.WithLabelValues(info.Platform, info.TargetPlatform).Observe(matchConfidence(searchData, targetPlatformTrack))
 			targetPlaylistTracks = append(targetPlaylistTracks, *targetPlatformTrack)
-			playlistTrackConversionEventData := &blueprint.PlaylistTrackConversionEventResponse{
-				EventType: blueprint.PlaylistConversionTrackEvent,
-				TaskID:    info.TaskID,
-				Tracks: []blueprint.PlaylistTrackConversionEventPayload{
-					{
-						Platform: info.Platform,
-						Track:    &result,
-					},
-					{
-						Platform: info.TargetPlatform,
-						Track:    targetPlatformTrack,
-					},
-				},
-			}
-
-			_, whErr := pc.factory.WebhookSender.SendEvent(pc.factory.App.WebhookAppID, blueprint.PlaylistConversionTrackEvent, playlistTrackConversionEventData)
-			if whErr != nil {
-				log.Printf("Error sending playlist track conversion webhook: %v", whErr)
-			}
+			eventBatcher.AddTrack(blueprint.PlaylistTrackConversionEventPayload{
+				Platform: info.Platform,
+				Track:    &result,
+			}, blueprint.PlaylistTrackConversionEventPayload{
+				Platform: info.TargetPlatform,
+				Track:    targetPlatformTrack,
+			})
 
 			srcPlaylistTracks = append(srcPlaylistTracks, result)
 		}
@@ -412,18 +437,45 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 	}()
 
 	wg.Wait()
+	// the pending events of an interrupted conversion are dropped rather than sent, as the conversion is requeued and
+	// its tracks sent again. It is neither charged nor done.
+	if ctx.Err() != nil {
+		eventBatcher.Discard()
+		l.Info("[service][AsynqConvertPlaylist] - conversion of task interrupted", zap.String("task_id", info.TaskID), zap.Int("tracks", len(srcPlaylistTracks)))
+		return nil, ctx.Err()
+	}
+	eventBatcher.Close()
 
-	_, whErr := pc.factory.WebhookSender.SendEvent(pc.factory.App.WebhookAppID, blueprint.PlaylistConversionDoneEvent, &blueprint.PlaylistConversionDoneEventMetadata{
-		EventType:      blueprint.PlaylistConversionDoneEvent,
-		TaskID:         info.TaskID,
-		PlaylistID:     info.EntityID,
-		SourcePlatform: info.Platform,
-		TargetPlatform: info.TargetPlatform,
-		UniqueID:       info.UniqueID,
-	})
+	// the tracks converted are charged to the quota of the app once the conversion is done. The conversions in the
+	// sandbox are charged to the budgets of the sandbox (see ratelimit.SandboxID), and are not usage of the app.
+	if chErr := ratelimit.Charge(context.Background(), pc.factory.Red, ratelimit.SandboxID(appId, pc.factory.App.Sandbox),
+		ratelimit.BudgetTracksConverted, int64(len(targetPlaylistTracks))); chErr != nil {
+		l.Error("[service][AsynqConvertPlaylist] - error charging converted tracks to app", zap.String("app_id", appId), zap.Error(chErr))
+	}
+	// playlist conversions are recorded once done, with the tracks converted. They are not tied to an endpoint, as they
+	// can be started from the API or the portal.
+	if !pc.factory.App.Sandbox {
+		database := db.NewDB{DB: pc.factory.Pg}
+		_ = database.RecordAppUsage(&blueprint.UsageEvent{
+			App:      appId,
+			Event:    blueprint.UsagePlaylistConversion,
+			Platform: info.TargetPlatform,
+			Count:    1,
+			Tracks:   int64(len(targetPlaylistTracks)),
+		})
+	}
+
+	whErr := sendEvent(ctx, pc.factory.WebhookSender, pc.factory.App.WebhookAppID, blueprint.PlaylistConversionDoneEvent,
+		blueprint.NewWebhookEvent(blueprint.PlaylistConversionDoneEvent, appId, info.TaskID, &blueprint.PlaylistConversionDoneEventMetadata{
+			TaskID:         info.TaskID,
+			PlaylistID:     info.EntityID,
+			SourcePlatform: info.Platform,
+			TargetPlatform: info.TargetPlatform,
+			UniqueID:       info.UniqueID,
+		}))
 
 	if whErr != nil {
-		log.Printf("[service][AsynqConvertPlaylist] - Error sending playlist conversion done webhook: %v", whErr)
+		l.Error("[service][AsynqConvertPlaylist] - Error sending playlist conversion done webhook", zap.Error(whErr))
 	}
 
 	srcPlatformResultsErr := pc.updatePlatformPlaylistTracks(info.Platform, finalResult, &blueprint.PlatformPlaylistTrackResult{
@@ -432,7 +484,7 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 	})
 
 	if srcPlatformResultsErr != nil {
-		log.Printf("[service][AsynqConvertPlaylist] - FATAL: could not build src platform result struct")
+		l.Error("[service][AsynqConvertPlaylist] - FATAL: could not build src platform result struct")
 		return nil, srcPlatformResultsErr
 	}
 
@@ -442,7 +494,7 @@ func (pc *Service) AsynqConvertPlaylist(info *blueprint.LinkInfo) (*blueprint.Pl
 	})
 
 	if targetPlatformResultsErr != nil {
-		log.Printf("[service][AsynqConvertPlaylist] - FATAL: could not build target platform result struct")
+		l.Error("[service][AsynqConvertPlaylist] - FATAL: could not build target platform result struct")
 		return nil, targetPlatformResultsErr
 	}
 	finalResult.OmittedTracks = &omittedTracks
@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"orchdio/blueprint"
+	platforminternal "orchdio/internal/platform"
+	"orchdio/tracing"
+	svixwebhook "orchdio/webhooks/svix"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// the calls to the platforms and to the webhook sender made during the conversions are wrapped in spans, as they are
+// where the time of a conversion goes.
+
+// searchTrackWithTitle searches the track on the platform, in a span. Not finding the track is not an error of the span.
+func searchTrackWithTitle(ctx context.Context, platform string, service platforminternal.PlatformService,
+	searchData *blueprint.TrackSearchData, authInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	ctx, span := tracing.Start(ctx, "platform.SearchTrackWithTitle", tracing.AttrPlatform.String(platform))
+	result, err := service.SearchTrackWithTitle(ctx, searchData, authInfo)
+	span.SetAttributes(attribute.Bool("orchdio.found", err == nil && result != nil))
+	if errors.Is(err, blueprint.EnoResult) {
+		tracing.End(span, nil)
+	} else {
+		tracing.End(span, err)
+	}
+	return result, err
+}
+
+// sendEvent sends the webhook event, in a span.
+func sendEvent(ctx context.Context, sender svixwebhook.SvixInterface, webhookAppId, eventType string, payload interface{}) error {
+	_, span := tracing.Start(ctx, "webhook.SendEvent", tracing.AttrEventType.String(eventType))
+	_, err := sender.SendEvent(webhookAppId, eventType, payload)
+	tracing.End(span, err)
+	return err
+}
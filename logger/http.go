@@ -0,0 +1,94 @@
+package logger
+
+import (
+	"context"
+	"orchdio/blueprint"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"go.uber.org/zap"
+)
+
+// RequestIDKey is the key of the request id in the locals of the requests, set by the requestid middleware.
+const RequestIDKey = "orchdio-request-id"
+
+// RequestID returns the id of the request.
+func RequestID(ctx *fiber.Ctx) string {
+	if id, ok := ctx.Locals(RequestIDKey).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// FromFiber returns the logger of the request, with its request id, its trace id if it is traced and, once the app and
+// platform of the request are known (i.e. after the auth middlewares), its app id and platform.
+func FromFiber(ctx *fiber.Ctx) *zap.Logger {
+	l := withTrace(ctx.UserContext(), L().With(zap.String(FieldRequestID, RequestID(ctx))))
+	if appId := requestAppID(ctx); appId != "" {
+		l = l.With(zap.String(FieldAppID, appId))
+	}
+	if platform := requestPlatform(ctx); platform != "" {
+		l = l.With(zap.String(FieldPlatform, platform))
+	}
+	return l
+}
+
+// Context returns the context of the request, with its id and logger, for the functions called by the handlers that
+// take a context.
+func Context(ctx *fiber.Ctx) context.Context {
+	return WithRequest(ctx.UserContext(), RequestID(ctx), FromFiber(ctx))
+}
+
+// Middleware logs each request once it is handled, with its status and latency. It must run after the requestid
+// middleware.
+func Middleware(ctx *fiber.Ctx) error {
+	start := time.Now()
+	err := ctx.Next()
+	status := ctx.Response().StatusCode()
+	if err != nil {
+		// the status is set by the error handler of the app, after the middlewares.
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+	fields := []zap.Field{
+		zap.String("method", ctx.Method()),
+		zap.String("path", ctx.Path()),
+		zap.Int("status", status),
+		zap.Duration("latency", time.Since(start)),
+		zap.String("ip", ctx.IP()),
+	}
+	l := FromFiber(ctx)
+	switch {
+	case status >= fiber.StatusInternalServerError:
+		l.Error("request", append(fields, zap.Error(err))...)
+	case status >= fiber.StatusBadRequest:
+		l.Warn("request", fields...)
+	default:
+		l.Info("request", fields...)
+	}
+	return err
+}
+
+func requestAppID(ctx *fiber.Ctx) string {
+	for _, key := range []string{"app", "developer_app"} {
+		if app, ok := ctx.Locals(key).(*blueprint.DeveloperApp); ok && app != nil {
+			return app.UID.String()
+		}
+	}
+	if appId := ctx.Params("appId"); appId != "" {
+		return appId
+	}
+	return ""
+}
+
+func requestPlatform(ctx *fiber.Ctx) string {
+	if info, ok := ctx.Locals("linkInfo").(*blueprint.LinkInfo); ok && info != nil {
+		return info.Platform
+	}
+	if platform, ok := ctx.Locals("platform").(string); ok {
+		return platform
+	}
+	return ctx.Params("platform")
+}
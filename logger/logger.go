@@ -1,163 +1,160 @@
+// Package logger is the structured logger of Orchdio. A single logger is built at startup by Init, from LOG_LEVEL and
+// LOG_FORMAT, and handed to the HTTP handlers and queue handlers with the fields of the request or task they handle:
+// request_id, app_id, task_id and platform. The request id is also carried in the payload of the tasks enqueued by a
+// request, so that the logs of the worker can be joined with the logs of the request.
+//
+// The standard log package is redirected to the logger, so the log.Printf calls not yet migrated are structured records
+// too, without the fields.
 package logger
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"orchdio/blueprint"
-	"os"
-	"time"
+	"log"
+	"strings"
 
 	"github.com/TheZeroSlave/zapsentry"
-	"github.com/getsentry/sentry-go"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-// NewLogger returns a new zap logger
-func NewLogger() *zap.Logger {
-	logger, _ := zap.NewProduction()
-	defer func(logger *zap.Logger) {
-		err := logger.Sync()
-		if err != nil {
-			panic(err)
-		}
-	}(logger)
+// The fields of the records, set on the loggers of the requests and tasks.
+const (
+	FieldRequestID = "request_id"
+	FieldAppID     = "app_id"
+	FieldTaskID    = "task_id"
+	FieldPlatform  = "platform"
+	FieldTraceID   = "trace_id"
+)
 
-	return logger
+// Config is the configuration of the logger.
+type Config struct {
+	// Level is the minimum level of the records: debug, info, warn or error.
+	Level string
+	// Format is json, or console for human-readable records.
+	Format string
+	// SentryDSN, if set, sends the records at warn level and above to Sentry.
+	SentryDSN string
 }
 
-// NewZapSentryLogger returns a new zap logger with sentry integration
-func NewZapSentryLogger(orchdioLoggerOpts *blueprint.OrchdioLoggerOptions) *zap.Logger {
-
-	if orchdioLoggerOpts == nil {
-		orchdioLoggerOpts = &blueprint.OrchdioLoggerOptions{
-			ApplicationPublicKey: "NOT_SET",
-			RequestID:            "NOT_SET",
-		}
+// New returns a logger with the configuration.
+func New(cfg Config) (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
 	}
 
-	if orchdioLoggerOpts.ApplicationPublicKey == "" {
-		orchdioLoggerOpts.ApplicationPublicKey = "NOT_SET"
+	var zapConfig zap.Config
+	switch cfg.Format {
+	case "json":
+		zapConfig = zap.NewProductionConfig()
+	case "console":
+		zapConfig = zap.NewDevelopmentConfig()
+		zapConfig.Development = false
+	default:
+		return nil, fmt.Errorf("invalid log format %q. Please use json or console", cfg.Format)
 	}
-
-	if orchdioLoggerOpts.RequestID == "" {
-		orchdioLoggerOpts.RequestID = "not_set"
+	zapConfig.Level = zap.NewAtomicLevelAt(level)
+	zapConfig.DisableStacktrace = level > zapcore.DebugLevel
+	l, err := zapConfig.Build()
+	if err != nil {
+		return nil, err
 	}
 
-	sentryTrace := false
-	if orchdioLoggerOpts.AddTrace == true {
-		sentryTrace = true
+	if cfg.SentryDSN == "" {
+		return l, nil
 	}
-
-	cfg := zapsentry.Configuration{
+	core, err := zapsentry.NewCore(zapsentry.Configuration{
 		Level:             zapcore.WarnLevel,
-		BreadcrumbLevel:   zapcore.WarnLevel,
+		BreadcrumbLevel:   zapcore.InfoLevel,
 		EnableBreadcrumbs: true,
-		DisableStacktrace: !sentryTrace,
-		Tags: map[string]string{
-			"component":  "system",
-			"when":       time.Now().String(),
-			"public_key": orchdioLoggerOpts.ApplicationPublicKey,
-			"request_id": orchdioLoggerOpts.RequestID,
-		},
-	}
-
-	log, err := zap.NewProduction()
+		Tags:              map[string]string{"component": "system"},
+	}, zapsentry.NewSentryClientFromDSN(cfg.SentryDSN))
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not create the sentry core: %w", err)
 	}
+	return zapsentry.AttachCoreToLogger(core, l), nil
+}
 
-	sentryClient, sErr := sentry.NewClient(sentry.ClientOptions{
-		Dsn:              os.Getenv("SENTRY_DSN"),
-		TracesSampleRate: 1.0,
-		AttachStacktrace: sentryTrace,
-	})
-
-	defer sentryClient.Flush(2)
-
-	if sErr != nil {
-		fmt.Println("error creating sentry client")
-		panic(err)
+// Init builds the logger with the configuration (see config.Config) and makes it the logger of the process: it is returned by L and by
+// FromContext without a logger, and the standard log package writes to it.
+func Init(cfg Config) (*zap.Logger, error) {
+	l, err := New(cfg)
+	if err != nil {
+		return nil, err
 	}
+	zap.ReplaceGlobals(l)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(&stdLogWriter{logger: l.WithOptions(zap.AddCallerSkip(3))})
+	return l, nil
+}
 
-	core, zErr := zapsentry.NewCore(cfg, zapsentry.NewSentryClientFromDSN(os.Getenv("SENTRY_DSN")))
-	if zErr != nil {
-		fmt.Println("error creating zap core")
-	}
+// L returns the logger of the process.
+func L() *zap.Logger {
+	return zap.L()
+}
 
-	log = zapsentry.AttachCoreToLogger(core, log)
-	sentryScope := sentry.NewScope()
+type contextKey struct{}
 
-	if orchdioLoggerOpts.ApplicationPublicKey != "" {
-		sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-			Category:  "Application (public key)",
-			Message:   "Request made by application",
-			Data:      map[string]interface{}{"application": orchdioLoggerOpts.ApplicationPublicKey},
-			Timestamp: time.Time{},
-		}, 1)
-	}
+type requestIDKey struct{}
 
-	if orchdioLoggerOpts.RequestID != "" {
-		sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-			Category:  "Request ID",
-			Data:      map[string]interface{}{"request_id": orchdioLoggerOpts.RequestID},
-			Timestamp: time.Time{},
-		}, 1)
-	}
+// WithContext returns a copy of ctx carrying the logger.
+func WithContext(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
 
-	if orchdioLoggerOpts.AppID != "" {
-		sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-			Category:  "App ID",
-			Message:   "ID of application making the request",
-			Data:      map[string]interface{}{"app_id": orchdioLoggerOpts.AppID},
-			Timestamp: time.Time{},
-		}, 1)
+// FromContext returns the logger carried by ctx, or the logger of the process.
+func FromContext(ctx context.Context) *zap.Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*zap.Logger); ok {
+			return l
+		}
 	}
+	return L()
+}
 
-	if orchdioLoggerOpts.Entity != "" {
-		sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-			Category:  "Entity",
-			Message:   "Entity that the user wants to interact with",
-			Data:      map[string]interface{}{"entity_id": orchdioLoggerOpts.AppID},
-			Timestamp: time.Time{},
-		}, 1)
-	}
+// WithRequest returns a copy of ctx carrying the id of the request and its logger, for the functions called by the
+// handlers of the request.
+func WithRequest(ctx context.Context, requestId string, l *zap.Logger) context.Context {
+	return WithContext(context.WithValue(ctx, requestIDKey{}, requestId), l)
+}
 
-	if orchdioLoggerOpts.Error != nil {
-		sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-			Category:  "Error",
-			Message:   "Error encountered while making the request",
-			Data:      map[string]interface{}{"error": orchdioLoggerOpts.Error},
-			Timestamp: time.Time{},
-		}, 1)
+// withTrace adds the id of the trace of ctx, if any, to the logger.
+func withTrace(ctx context.Context, l *zap.Logger) *zap.Logger {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		return l.With(zap.String(FieldTraceID, sc.TraceID().String()))
 	}
+	return l
+}
 
-	if orchdioLoggerOpts.Platform != "" {
-		sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-			Category:  "Platform",
-			Message:   "Platform that the user is interacting with",
-			Data:      map[string]interface{}{"platform": orchdioLoggerOpts.Platform},
-			Timestamp: time.Time{},
-		}, 1)
+// RequestIDFromContext returns the id of the request carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
 	}
-
-	sentryScope.AddBreadcrumb(&sentry.Breadcrumb{
-		Message:   "",
-		Data:      nil,
-		Level:     "",
-		Timestamp: time.Time{},
-	}, 1)
-
-	return log.With(zapsentry.NewScopeFromScope(sentryScope))
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
 }
 
-func NewLoggerWithConfig(config zap.Config) *zap.Logger {
-	logger, _ := config.Build()
-	defer func(logger *zap.Logger) {
-		err := logger.Sync()
-		if err != nil {
-			panic(err)
-		}
-	}(logger)
+// stdLogWriter writes the lines of the standard log package to the logger. The level is guessed from the line, as the
+// lines usually say when they are errors, e.g. "[db][FetchUser] - error: ...".
+type stdLogWriter struct {
+	logger *zap.Logger
+}
 
-	return logger
+func (w *stdLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSpace(string(bytes.TrimRight(p, "\n")))
+	lower := strings.ToLower(msg)
+	switch {
+	case strings.Contains(lower, "error") || strings.Contains(msg, "⛔"):
+		w.logger.Error(msg)
+	case strings.Contains(lower, "warn"):
+		w.logger.Warn(msg)
+	default:
+		w.logger.Info(msg)
+	}
+	return len(p), nil
 }
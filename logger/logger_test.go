@@ -0,0 +1,62 @@
+package logger
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func observe(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	restore := zap.ReplaceGlobals(zap.New(core))
+	t.Cleanup(restore)
+	return logs
+}
+
+func TestTaskMiddleware(t *testing.T) {
+	logs := observe(t)
+	handler := TaskMiddleware(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		FromContext(ctx).Info("converted")
+		return nil
+	}))
+
+	task := asynq.NewTask("playlist:conversion:1", []byte(`{"request_id": "req-1", "link_info": {}}`))
+	require.NoError(t, handler.ProcessTask(context.Background(), task))
+
+	entries := logs.FilterMessage("converted").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "req-1", fields[FieldRequestID])
+	assert.Equal(t, "playlist:conversion:1", fields["task_type"])
+}
+
+func TestStdLogWriter(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	w := &stdLogWriter{logger: zap.New(core)}
+
+	_, _ = w.Write([]byte("[db][FetchUser] - error fetching user: no rows\n"))
+	_, _ = w.Write([]byte("[queue][CheckForOrphanedTasksMiddleware][warning] - handler not found\n"))
+	_, _ = w.Write([]byte("[main] - server started\n"))
+
+	entries := logs.All()
+	require.Len(t, entries, 3)
+	assert.Equal(t, zapcore.ErrorLevel, entries[0].Level)
+	assert.Equal(t, zapcore.WarnLevel, entries[1].Level)
+	assert.Equal(t, zapcore.InfoLevel, entries[2].Level)
+	assert.Equal(t, "[main] - server started", entries[2].Message)
+}
+
+func TestConfig(t *testing.T) {
+	_, err := New(Config{Level: "loud", Format: "json"})
+	assert.Error(t, err)
+	_, err = New(Config{Level: "info", Format: "xml"})
+	assert.Error(t, err)
+	_, err = New(Config{Level: "debug", Format: "console"})
+	assert.NoError(t, err)
+}
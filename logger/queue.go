@@ -0,0 +1,31 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/hibiken/asynq"
+	"go.uber.org/zap"
+)
+
+// taskPayload has the fields of the task payloads that are logged with the records of the task.
+type taskPayload struct {
+	RequestID string `json:"request_id"`
+}
+
+// TaskMiddleware passes the logger of the task to the task handlers, in their context, with the id and type of the task,
+// the id of the request that enqueued it, if any, and the id of its trace (see tracing.TaskMiddleware, which must run
+// before).
+func TaskMiddleware(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		l := withTrace(ctx, L().With(zap.String("task_type", task.Type())))
+		if taskId, ok := asynq.GetTaskID(ctx); ok {
+			l = l.With(zap.String(FieldTaskID, taskId))
+		}
+		var payload taskPayload
+		if json.Unmarshal(task.Payload(), &payload) == nil && payload.RequestID != "" {
+			l = l.With(zap.String(FieldRequestID, payload.RequestID))
+		}
+		return h.ProcessTask(WithContext(ctx, l), task)
+	})
+}
@@ -0,0 +1,47 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler serves the metrics in the Prometheus format. If token is set, the scrapes must send it as a bearer token.
+func Handler(token string) fiber.Handler {
+	serve := adaptor.HTTPHandler(promhttp.Handler())
+	return func(ctx *fiber.Ctx) error {
+		if token != "" && subtle.ConstantTimeCompare([]byte(ctx.Get(fiber.HeaderAuthorization)), []byte("Bearer "+token)) != 1 {
+			return ctx.SendStatus(fiber.StatusUnauthorized)
+		}
+		return serve(ctx)
+	}
+}
+
+// Middleware records the latency of the requests. The route is the path the request matched (e.g.
+// /api/v1/playlist/:playlistId), so that the requests for different entities are recorded together; the requests that
+// matched no route are recorded as "unmatched".
+func Middleware(ctx *fiber.Ctx) error {
+	start := time.Now()
+	err := ctx.Next()
+	status := ctx.Response().StatusCode()
+	if err != nil {
+		// the status is set by the error handler of the app, after the middlewares.
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+
+	route := ctx.Route()
+	path := route.Path
+	// a request that matched no route ends in the last middleware it went through, mounted on a prefix of the path.
+	if status == fiber.StatusNotFound && len(route.Params) == 0 && path != ctx.Path() {
+		path = "unmatched"
+	}
+	RequestDuration.WithLabelValues(path, ctx.Method(), strconv.Itoa(status)).Observe(time.Since(start).Seconds())
+	return err
+}
@@ -0,0 +1,132 @@
+// Package metrics has the Prometheus metrics of Orchdio, served on /metrics: the latency of the requests, the
+// conversions, the calls made to the platforms, the cache of the tracks, the confidence of the matches, the queues and
+// the webhook deliveries.
+//
+// The labels only take values from small sets (platforms, routes, queues, outcomes), never ids.
+package metrics
+
+import (
+	"errors"
+	"orchdio/blueprint"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "orchdio"
+
+// The outcomes of the conversions, tasks and webhook deliveries.
+const (
+	OutcomeSuccess  = "success"
+	OutcomeNotFound = "not_found"
+	OutcomeError    = "error"
+)
+
+var (
+	// RequestDuration is the latency of the requests, by route, method and status.
+	RequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of the HTTP requests, by route, method and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// Conversions counts the conversions, by entity (track or playlist), source and target platform and outcome.
+	Conversions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "conversions_total",
+		Help:      "Conversions, by entity, source and target platform and outcome.",
+	}, []string{"entity", "source", "target", "outcome"})
+
+	// PlatformRequestDuration is the latency of the calls made to the platforms, by platform and status class (2xx,
+	// 4xx, 5xx, or error when no response was received).
+	PlatformRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "platform_request_duration_seconds",
+		Help:      "Latency of the calls made to the platforms, by platform and status class.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"platform", "status"})
+
+	// PlatformRequestErrors counts the calls to the platforms that failed: no response, or a 5xx status.
+	PlatformRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "platform_request_errors_total",
+		Help:      "Calls to the platforms without a response or with a 5xx status, by platform.",
+	}, []string{"platform"})
+
+	// PlatformRateLimited counts the calls to the platforms rejected because of their rate limits (429).
+	PlatformRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "platform_rate_limited_total",
+		Help:      "Calls to the platforms rejected with a 429 status, by platform.",
+	}, []string{"platform"})
+
+	// CacheLookups counts the lookups of tracks in the cache, by platform, kind (id or title) and result (hit or miss).
+	CacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Lookups of tracks in the cache, by platform, kind and result.",
+	}, []string{"platform", "kind", "result"})
+
+	// MatchConfidence is the confidence of the tracks matched on the target platforms, from 0 to 1.
+	MatchConfidence = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "match_confidence",
+		Help:      "Confidence of the tracks matched on the target platform, from 0 to 1, by source and target platform.",
+		Buckets:   prometheus.LinearBuckets(0.1, 0.1, 10),
+	}, []string{"source", "target"})
+
+	// TaskDuration is the processing time of the tasks, by queue and outcome.
+	TaskDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "task_duration_seconds",
+		Help:      "Processing time of the queue tasks, by queue and outcome.",
+		Buckets:   []float64{.1, .5, 1, 5, 15, 30, 60, 120, 300, 600},
+	}, []string{"queue", "outcome"})
+
+	// TaskRetries counts the tasks processed again after a failure, by queue.
+	TaskRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "task_retries_total",
+		Help:      "Tasks processed again after a failure, by queue.",
+	}, []string{"queue"})
+
+	// WebhookDeliveries counts the webhook events, by sender and outcome. For Svix, which delivers the events itself, it
+	// is whether Svix accepted the event; for the built-in sender, whether the endpoint accepted the delivery attempt.
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook events sent, by sender and outcome.",
+	}, []string{"sender", "outcome"})
+)
+
+// CacheLookup records a lookup of a track in the cache of the platform. kind is "id" or "title".
+func CacheLookup(platform, kind string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	CacheLookups.WithLabelValues(platform, kind, result).Inc()
+}
+
+// Conversion records a conversion and its outcome, from the error it returned, if any.
+func Conversion(entity, source, target string, err error) {
+	outcome := OutcomeSuccess
+	switch {
+	case err == nil:
+	case errors.Is(err, blueprint.EnoResult):
+		outcome = OutcomeNotFound
+	default:
+		outcome = OutcomeError
+	}
+	Conversions.WithLabelValues(entity, source, target, outcome).Inc()
+}
+
+// WebhookDelivery records a webhook event sent by the sender.
+func WebhookDelivery(sender string, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	WebhookDeliveries.WithLabelValues(sender, outcome).Inc()
+}
@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestPlatformFromHost(t *testing.T) {
+	assert.Equal(t, "spotify", PlatformFromHost("api.spotify.com"))
+	assert.Equal(t, "spotify", PlatformFromHost("accounts.spotify.com:443"))
+	assert.Equal(t, "deezer", PlatformFromHost("api.deezer.com"))
+	assert.Equal(t, "applemusic", PlatformFromHost("api.music.apple.com"))
+	assert.Equal(t, "tidal", PlatformFromHost("openapi.tidal.com"))
+	assert.Equal(t, "ytmusic", PlatformFromHost("music.youtube.com"))
+	assert.Equal(t, "", PlatformFromHost("api.svix.com"))
+	assert.Equal(t, "", PlatformFromHost("notspotify.com"))
+}
+
+func TestTransport(t *testing.T) {
+	status := http.StatusTooManyRequests
+	transport := Transport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Body: http.NoBody, Request: req}, nil
+	}))
+	limited := testutil.ToFloat64(PlatformRateLimited.WithLabelValues("deezer"))
+	errored := testutil.ToFloat64(PlatformRequestErrors.WithLabelValues("deezer"))
+
+	req := httptest.NewRequest(http.MethodGet, "https://api.deezer.com/track/1", nil)
+	_, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, limited+1, testutil.ToFloat64(PlatformRateLimited.WithLabelValues("deezer")))
+
+	status = http.StatusBadGateway
+	_, err = transport.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, errored+1, testutil.ToFloat64(PlatformRequestErrors.WithLabelValues("deezer")))
+
+	// the requests to other hosts are not recorded.
+	before := testutil.CollectAndCount(PlatformRequestDuration)
+	_, err = transport.RoundTrip(httptest.NewRequest(http.MethodGet, "https://api.svix.com/api/v1/app", nil))
+	require.NoError(t, err)
+	assert.Equal(t, before, testutil.CollectAndCount(PlatformRequestDuration))
+}
+
+func TestMiddlewareRoutes(t *testing.T) {
+	app := fiber.New()
+	app.Use(Middleware)
+	app.Get("/v1/track/:trackId", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(http.StatusOK)
+	})
+
+	for _, path := range []string{"/v1/track/1", "/v1/track/2", "/v1/nothing-here"} {
+		res, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err)
+		_ = res.Body.Close()
+	}
+
+	assert.Equal(t, 2, testutil.CollectAndCount(RequestDuration))
+	assert.Equal(t, uint64(2), histogramCount(t, "/v1/track/:trackId", "200"))
+	assert.Equal(t, uint64(1), histogramCount(t, "unmatched", "404"))
+}
+
+func histogramCount(t *testing.T, route, status string) uint64 {
+	t.Helper()
+	metrics := make(chan prometheus.Metric, 10)
+	RequestDuration.WithLabelValues(route, http.MethodGet, status).(prometheus.Histogram).Collect(metrics)
+	close(metrics)
+	var m dto.Metric
+	require.NoError(t, (<-metrics).Write(&m))
+	return m.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// TaskMiddleware records the processing time and the retries of the tasks, by queue.
+func TaskMiddleware(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		queue, _ := asynq.GetQueueName(ctx)
+		if retried, _ := asynq.GetRetryCount(ctx); retried > 0 {
+			TaskRetries.WithLabelValues(queue).Inc()
+		}
+
+		start := time.Now()
+		err := h.ProcessTask(ctx, task)
+		outcome := OutcomeSuccess
+		if err != nil {
+			outcome = OutcomeError
+		}
+		TaskDuration.WithLabelValues(queue, outcome).Observe(time.Since(start).Seconds())
+		return err
+	})
+}
+
+var queueSizeDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "queue", "tasks"),
+	"Tasks in the queues, by queue and state.", []string{"queue", "state"}, nil)
+
+// QueueCollector collects the number of tasks in each queue and state (pending, active, scheduled, retry and
+// archived) from asynq when the metrics are scraped.
+type QueueCollector struct {
+	Inspector *asynq.Inspector
+}
+
+// NewQueueCollector returns the collector of the queues of the inspector. It must be registered with
+// prometheus.MustRegister.
+func NewQueueCollector(inspector *asynq.Inspector) *QueueCollector {
+	return &QueueCollector{Inspector: inspector}
+}
+
+func (c *QueueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueSizeDesc
+}
+
+func (c *QueueCollector) Collect(ch chan<- prometheus.Metric) {
+	queues, err := c.Inspector.Queues()
+	if err != nil {
+		log.Printf("[metrics][QueueCollector] - error listing queues: %v", err)
+		return
+	}
+	for _, q := range queues {
+		info, err := c.Inspector.GetQueueInfo(q)
+		if err != nil {
+			log.Printf("[metrics][QueueCollector] - error fetching queue %s: %v", q, err)
+			continue
+		}
+		for state, size := range map[string]int{
+			"pending":   info.Pending,
+			"active":    info.Active,
+			"scheduled": info.Scheduled,
+			"retry":     info.Retry,
+			"archived":  info.Archived,
+		} {
+			ch <- prometheus.MustNewConstMetric(queueSizeDesc, prometheus.GaugeValue, float64(size), q, state)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// platformHosts maps the domains of the APIs of the platforms to the platforms. The hosts are matched on their suffix,
+// e.g. api.spotify.com and accounts.spotify.com are both spotify.
+var platformHosts = []struct {
+	domain   string
+	platform string
+}{
+	{"spotify.com", "spotify"},
+	{"deezer.com", "deezer"},
+	{"apple.com", "applemusic"},
+	{"tidal.com", "tidal"},
+	{"youtube.com", "ytmusic"},
+	{"soundcloud.com", "soundcloud"},
+}
+
+// PlatformFromHost returns the platform of the host of a request, or an empty string if the host is not a platform.
+func PlatformFromHost(host string) string {
+	host = strings.ToLower(host)
+	if i := strings.LastIndexByte(host, ':'); i != -1 {
+		host = host[:i]
+	}
+	for _, h := range platformHosts {
+		if host == h.domain || strings.HasSuffix(host, "."+h.domain) {
+			return h.platform
+		}
+	}
+	return ""
+}
+
+// Transport returns a http.RoundTripper that records the latency, errors and rate-limit hits of the requests made to
+// the platforms through base. The requests to other hosts are not recorded.
+//
+// It is set as http.DefaultTransport at startup, as the clients of the platforms (axios, the oauth2 clients of the
+// spotify SDK, etc.) all use it.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &platformTransport{base: base}
+}
+
+type platformTransport struct {
+	base http.RoundTripper
+}
+
+func (t *platformTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	platform := PlatformFromHost(req.URL.Host)
+	if platform == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	start := time.Now()
+	res, err := t.base.RoundTrip(req)
+	status := "error"
+	switch {
+	case err != nil:
+		PlatformRequestErrors.WithLabelValues(platform).Inc()
+	default:
+		status = strconv.Itoa(res.StatusCode/100) + "xx"
+		if res.StatusCode == http.StatusTooManyRequests {
+			PlatformRateLimited.WithLabelValues(platform).Inc()
+		}
+		if res.StatusCode >= http.StatusInternalServerError {
+			PlatformRequestErrors.WithLabelValues(platform).Inc()
+		}
+	}
+	PlatformRequestDuration.WithLabelValues(platform, status).Observe(time.Since(start).Seconds())
+	return res, err
+}
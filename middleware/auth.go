@@ -8,21 +8,18 @@ import (
 	"net/http"
 	"orchdio/blueprint"
 	"orchdio/db"
-	"orchdio/db/queries"
 	logger2 "orchdio/logger"
 	"orchdio/services/applemusic"
 	"orchdio/services/deezer"
 	"orchdio/services/spotify"
 	"orchdio/services/ytmusic"
 	"orchdio/util"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/jmoiron/sqlx"
 	"github.com/samber/lo"
-	"go.uber.org/zap"
 )
 
 type AuthMiddleware struct {
@@ -69,19 +66,23 @@ func (a *AuthMiddleware) ValidateKey(ctx *fiber.Ctx) error {
 	return ctx.Next()
 }
 
+// LogIncomingRequest logs the requests once they are handled, with their request id, app and platform.
 func (a *AuthMiddleware) LogIncomingRequest(ctx *fiber.Ctx) error {
 	// in order to suppress the health monitor from logging the request, we check if the path is /health
 	if ctx.Path() == "/vermont/info" {
 		return ctx.Next()
 	}
-	log.Printf("[middleware][LogIncomingRequest] incoming request: %s  %s: %s\n", ctx.IP(), ctx.Method(), ctx.Path())
-	return ctx.Next()
+	return logger2.Middleware(ctx)
 }
 
 // AddReadOnlyDeveloperToContext gets the developer using the public key which is read only and attach the developer to context.
+// A secret key can be passed instead, so that keys restricted to some scopes (e.g. convert:track) can be used.
 func (a *AuthMiddleware) AddReadOnlyDeveloperToContext(ctx *fiber.Ctx) error {
 	log.Printf("[db][middleware][AddReadOnlyDevAccessToContext] developer -  fetching app developer with public key\n")
 	pubKey := ctx.Get("x-orchdio-public-key")
+	if pubKey == "" && ctx.Get("x-orchdio-key") != "" {
+		return a.AddReadWriteDeveloperToContext(ctx)
+	}
 	if pubKey == "" {
 		log.Printf("[db][AddReadOnlyDevAccessToContext] developer -  error: could not fetch app developer with public key. No header passed")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "missing x-orchdio-public-key header")
@@ -119,6 +120,7 @@ func (a *AuthMiddleware) AddReadOnlyDeveloperToContext(ctx *fiber.Ctx) error {
 }
 
 // AddReadWriteDeveloperToContext gets the developer using the secret key which is read and write and attach the developer to context.
+// Only the hash of secret keys is stored, so the key is hashed and looked up among the active keys of all apps.
 func (a *AuthMiddleware) AddReadWriteDeveloperToContext(ctx *fiber.Ctx) error {
 	log.Printf("[db][middleware][FetchAppDeveloperWithSecretKey] developer -  fetching app developer with secret key\n")
 	key := ctx.Get("x-orchdio-key")
@@ -128,25 +130,33 @@ func (a *AuthMiddleware) AddReadWriteDeveloperToContext(ctx *fiber.Ctx) error {
 	}
 
 	// check if the key is valid
-	isValid := util.IsValidUUID(key)
-	if !isValid {
+	if !util.IsSecretKeyFormat(key) {
 		log.Printf("[db][FetchAppDeveloperWithSecretKey] developer -  error: could not fetch app developer with secret")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "invalid x-orchdio-key header")
 	}
 
-	var developer blueprint.User
-	err := a.DB.QueryRowx(queries.FetchAuthorizedAppDeveloperBySecretKey, key).StructScan(&developer)
+	database := db.NewDB{DB: a.DB}
+	appKey, err := database.FetchActiveAppSecretKey(util.HashSecretKey(key))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[db][FetchAppDeveloperWithSecretKey] developer -  error: secret key %s is unknown, revoked or expired\n", util.SecretKeyHint(key))
+			return util.ErrorResponse(ctx, fiber.StatusUnauthorized, "unauthorized", "invalid x-orchdio-key header. The key does not exist, was revoked or has expired")
+		}
+		log.Printf("[db][FetchAppDeveloperWithSecretKey] developer -  error: could not fetch secret key: %v\n", err)
+		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred")
+	}
+
+	developer, err := database.FetchAuthorizedAppDeveloper(appKey.App.String())
 	if err != nil {
-		log.Printf("[db][FetchAppDeveloperWithSecretKey] developer -  error: could not fetch app developer with the secret: %s. Error is %v\n", key, err)
+		log.Printf("[db][FetchAppDeveloperWithSecretKey] developer -  error: could not fetch app developer with the secret key %s. Error is %v\n", appKey.UID, err)
 		if errors.Is(err, sql.ErrNoRows) {
 			return util.ErrorResponse(ctx, fiber.StatusNotFound, "not found", "app not found")
 		}
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "invalid x-orchdio-key header")
 	}
-	ctx.Locals("developer", &developer)
+	ctx.Locals("developer", developer)
 
-	database := db.NewDB{DB: a.DB}
-	app, err := database.FetchAppBySecretKey([]byte(key))
+	app, err := database.FetchAppByAppId(appKey.App.String())
 	if err != nil {
 		log.Printf("[db][AddReadWriteDevAccessToContext] developer -  error: could not fetch app with private key")
 		if errors.Is(err, sql.ErrNoRows) {
@@ -154,8 +164,16 @@ func (a *AuthMiddleware) AddReadWriteDeveloperToContext(ctx *fiber.Ctx) error {
 		}
 		return util.ErrorResponse(ctx, fiber.StatusInternalServerError, err, "An internal error occurred")
 	}
+	// the requests made with a test key run in the sandbox.
+	app.Sandbox = appKey.Mode == blueprint.AppKeyModeTest
 	// set the app to the context
 	ctx.Locals("app", app)
+	ctx.Locals("app_key", appKey)
+
+	// recording the last use of the key should not slow the request down.
+	go func(keyId string) {
+		_ = database.TouchAppSecretKey(keyId)
+	}(appKey.UID.String())
 	return ctx.Next()
 }
 
@@ -182,7 +200,6 @@ func (a *AuthMiddleware) VerifyUserActionApp(ctx *fiber.Ctx) error {
 	app := ctx.Locals("app").(*blueprint.DeveloperApp)
 	userId := ctx.Params("userId")
 	platform := ctx.Params("platform")
-	refreshToken := ""
 	if userId == "" {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Missing user id")
 	}
@@ -195,6 +212,12 @@ func (a *AuthMiddleware) VerifyUserActionApp(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Missing app")
 	}
 
+	// the apps in the sandbox have no platform users, the controllers return the library of the sandbox user.
+	if app.Sandbox {
+		ctx.Locals("userCtx", &blueprint.AuthMiddlewareUserInfo{Platform: platform})
+		return ctx.Next()
+	}
+
 	database := db.NewDB{DB: a.DB}
 	user, err := database.FetchPlatformAndUserInfoByIdentifier(userId, app.UID.String(), platform)
 	if err != nil {
@@ -208,19 +231,9 @@ func (a *AuthMiddleware) VerifyUserActionApp(ctx *fiber.Ctx) error {
 		return util.ErrorResponse(ctx, http.StatusUnauthorized, "unauthorized", "User not authorized")
 	}
 
-	if user.RefreshToken != nil {
-		r, err := util.Decrypt(user.RefreshToken, []byte(os.Getenv("ENCRYPTION_SECRET")))
-		if err != nil {
-			log.Printf("[middleware][auth][AuthDeveloperApp] - could not decrypt refresh token")
-			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An internal error occurred")
-		}
-		refreshToken = string(r)
-	}
-
 	userMiddlewareInfo := blueprint.AuthMiddlewareUserInfo{
-		Platform:     platform,
-		PlatformID:   user.PlatformID,
-		RefreshToken: refreshToken,
+		Platform:   platform,
+		PlatformID: user.PlatformID,
 	}
 
 	ctx.Locals("userCtx", &userMiddlewareInfo)
@@ -239,15 +252,9 @@ func FetcPlatformNameByIdentifier(identifier string) string {
 
 func (a *AuthMiddleware) AddRequestPlatformWithPrivateKeyToCtx(ctx *fiber.Ctx) error {
 	platform := ctx.Params("platform")
-	reqId := ctx.Get("x-orchdio-request-id")
 	// todo: rename this to x-orchdio-private-key
 	privKey := ctx.Get("x-orchdio-key")
-	loggerOpts := &blueprint.OrchdioLoggerOptions{
-		RequestID: reqId,
-		Platform:  zap.String("platform", platform).String,
-	}
-	orchdioLogger := logger2.NewZapSentryLogger(loggerOpts)
-	orchdioLogger.Info("Request ID", zap.String("request_id", reqId))
+	orchdioLogger := logger2.FromFiber(ctx)
 	if platform == "" {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Missing platform")
 	}
@@ -257,9 +264,6 @@ func (a *AuthMiddleware) AddRequestPlatformWithPrivateKeyToCtx(ctx *fiber.Ctx) e
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid platform")
 	}
 
-	path := ctx.Path()
-	orchdioLogger.Info("Request path", zap.String("path", path))
-
 	if privKey == "" {
 		orchdioLogger.Error("[middleware][AddRequestPlatformToCtx] developer -  error: could not fetch app developer with public key. No public key passed")
 		return util.ErrorResponse(ctx, fiber.StatusBadRequest, "bad request", "missing x-orchdio-public-key header")
@@ -271,15 +275,7 @@ func (a *AuthMiddleware) AddRequestPlatformWithPrivateKeyToCtx(ctx *fiber.Ctx) e
 
 func (a *AuthMiddleware) AddRequestPlatformWithPubKeyToCtx(ctx *fiber.Ctx) error {
 	platform := ctx.Params("platform")
-	reqId := ctx.Get("x-orchdio-request-id")
-	pubKey := ctx.Get("x-orchdio-public-key")
-	loggerOpts := &blueprint.OrchdioLoggerOptions{
-		RequestID:            reqId,
-		ApplicationPublicKey: zap.String("app_public_key", pubKey).String,
-		Platform:             zap.String("platform", platform).String,
-	}
-	orchdioLogger := logger2.NewZapSentryLogger(loggerOpts)
-	orchdioLogger.Info("Request ID", zap.String("request_id", reqId))
+	orchdioLogger := logger2.FromFiber(ctx)
 	if platform == "" {
 		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Missing platform")
 	}
@@ -291,7 +287,6 @@ func (a *AuthMiddleware) AddRequestPlatformWithPubKeyToCtx(ctx *fiber.Ctx) error
 
 	appPubKey := ctx.Get("x-orchdio-public-key")
 	path := ctx.Path()
-	orchdioLogger.Info("Request path", zap.String("path", path))
 
 	// due to the fact that during auth, deezer doesn't make the request with the pubkey
 	// we make sure to skip for auth paths generally
@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"math"
+	"orchdio/blueprint"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// IPLimiter limits the requests of each IP address that are not authenticated with the keys of an app. Requests made
+// with app keys are limited per app on their plan instead (see RateLimitMiddleware): many users of an app can share the
+// IP of its backend. Requests are counted in fixed windows, in memory.
+type IPLimiter struct {
+	Max    int
+	Window time.Duration
+	// LimitReached responds to the requests over the limit.
+	LimitReached fiber.Handler
+
+	mu        sync.Mutex
+	windows   map[string]*ipWindow
+	lastSweep time.Time
+}
+
+type ipWindow struct {
+	start time.Time
+	count int
+}
+
+func NewIPLimiter(max int, window time.Duration, limitReached fiber.Handler) *IPLimiter {
+	return &IPLimiter{Max: max, Window: window, LimitReached: limitReached, windows: map[string]*ipWindow{}}
+}
+
+// Limit counts the requests without app keys against the limit of their IP. Requests with app keys are let through
+// while their IP is under the limit, and are only counted if the auth middlewares did not authenticate them (i.e. the
+// app of the request is not set once they are handled), so that requests with invalid keys cannot bypass the limit.
+func (l *IPLimiter) Limit(ctx *fiber.Ctx) error {
+	ip := ctx.IP()
+	if !hasAppKey(ctx) {
+		if !l.hit(ip, true) {
+			return l.refuse(ctx, ip)
+		}
+		return ctx.Next()
+	}
+
+	if !l.hit(ip, false) {
+		return l.refuse(ctx, ip)
+	}
+	err := ctx.Next()
+	if !isAuthenticated(ctx) {
+		l.hit(ip, true)
+	}
+	return err
+}
+
+// hit returns true if the IP is under the limit, counting the request if count is true.
+func (l *IPLimiter) hit(ip string, count bool) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+	w, ok := l.windows[ip]
+	if !ok || now.Sub(w.start) >= l.Window {
+		w = &ipWindow{start: now}
+		l.windows[ip] = w
+	}
+	if w.count >= l.Max {
+		return false
+	}
+	if count {
+		w.count++
+	}
+	return true
+}
+
+// sweep removes the windows that have ended, once per window. l.mu must be held.
+func (l *IPLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.Window {
+		return
+	}
+	l.lastSweep = now
+	for ip, w := range l.windows {
+		if now.Sub(w.start) >= l.Window {
+			delete(l.windows, ip)
+		}
+	}
+}
+
+func (l *IPLimiter) refuse(ctx *fiber.Ctx, ip string) error {
+	l.mu.Lock()
+	retryAfter := l.Window
+	if w, ok := l.windows[ip]; ok {
+		retryAfter = l.Window - time.Since(w.start)
+	}
+	l.mu.Unlock()
+	ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(max(retryAfter, 0).Seconds()))))
+	return l.LimitReached(ctx)
+}
+
+// hasAppKey returns true if the request carries the keys of an app, in the headers or in the public_key query param
+// (see PublicKeyFromQuery).
+func hasAppKey(ctx *fiber.Ctx) bool {
+	return ctx.Get("x-orchdio-key") != "" || ctx.Get("x-orchdio-public-key") != "" || ctx.Query("public_key") != ""
+}
+
+// isAuthenticated returns true if the app of the request was set by the auth middlewares.
+func isAuthenticated(ctx *fiber.Ctx) bool {
+	app, ok := ctx.Locals("app").(*blueprint.DeveloperApp)
+	return ok && app != nil
+}
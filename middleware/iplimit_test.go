@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIPLimiter(t *testing.T) {
+	limiter := NewIPLimiter(2, time.Minute, func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(http.StatusTooManyRequests)
+	})
+	app := fiber.New()
+	app.Use(limiter.Limit)
+	// stands in for the auth middlewares: only the "valid" key authenticates the request.
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		if ctx.Get("x-orchdio-key") != "valid" {
+			return ctx.SendStatus(http.StatusUnauthorized)
+		}
+		ctx.Locals("app", &blueprint.DeveloperApp{})
+		return ctx.SendStatus(http.StatusOK)
+	})
+	request := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if key != "" {
+			req.Header.Set("x-orchdio-key", key)
+		}
+		res, err := app.Test(req)
+		require.NoError(t, err)
+		return res.StatusCode
+	}
+
+	// authenticated requests are not counted.
+	for range 5 {
+		assert.Equal(t, http.StatusOK, request("valid"))
+	}
+	// requests with invalid keys are counted like requests without keys.
+	assert.Equal(t, http.StatusUnauthorized, request("invalid"))
+	assert.Equal(t, http.StatusUnauthorized, request(""))
+	assert.Equal(t, http.StatusTooManyRequests, request("invalid"))
+	// once the IP is over the limit, authenticated requests are refused too.
+	assert.Equal(t, http.StatusTooManyRequests, request("valid"))
+}
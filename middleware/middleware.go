@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/services"
 	"orchdio/services/applemusic"
 	"orchdio/services/deezer"
@@ -47,138 +48,177 @@ func VerifyAppJWT(ctx *fiber.Ctx) error {
 	return ctx.Next()
 }
 
-func ExtractLinkInfoFromBody(ctx *fiber.Ctx) error {
-	// adding all in order to support wildcard. when the option is empty, we can presume they want to convert
-	// to all platforms (that they have added their credentials for and the user has authed, that is)
-	platforms := []string{ytmusic.IDENTIFIER, spotify.IDENTIFIER, deezer.IDENTIFIER, applemusic.IDENTIFIER, tidal.IDENTIFIER, "all"}
-	app := ctx.Locals("app").(*blueprint.DeveloperApp)
-	linkBody := ctx.Body()
+// PublicKeyFromQuery sets the x-orchdio-public-key header from the public_key query param, if the header is not passed.
+// It is used for the websocket of the portal, since browsers cannot set the headers of websocket requests.
+func PublicKeyFromQuery(ctx *fiber.Ctx) error {
+	if ctx.Get("x-orchdio-public-key") == "" && ctx.Query("public_key") != "" {
+		ctx.Request().Header.Set("x-orchdio-public-key", ctx.Query("public_key"))
+	}
+	return ctx.Next()
+}
 
-	conversionBody := blueprint.ConversionBody{}
+// ConversionBodyError is the error returned when the link info of a conversion body could not be extracted. Status is
+// the http status to respond with, Err and Message are the error and the message of the error response.
+type ConversionBodyError struct {
+	Status  int
+	Err     interface{}
+	Message string
+}
 
-	err := json.Unmarshal(linkBody, &conversionBody)
-	if err != nil {
-		log.Printf("[middleware][ExtractLinkInfoFromBody] error - Could not unmarshal conversionBody body: %v\n", err)
-		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred")
+func (e *ConversionBodyError) Error() string {
+	return e.Message
+}
+
+// ExtractLinkInfoFromBody extracts the info of the link in the conversion body and saves it into local context called
+// "linkInfo". platforms are the platforms of the configuration, see services.ExtractLinkInfo.
+func ExtractLinkInfoFromBody(platforms config.Platforms) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		app := ctx.Locals("app").(*blueprint.DeveloperApp)
+		linkBody := ctx.Body()
+
+		conversionBody := blueprint.ConversionBody{}
+
+		err := json.Unmarshal(linkBody, &conversionBody)
+		if err != nil {
+			log.Printf("[middleware][ExtractLinkInfoFromBody] error - Could not unmarshal conversionBody body: %v\n", err)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred")
+		}
+
+		linkInfo, bodyErr := LinkInfoFromConversionBody(app, &conversionBody, platforms)
+		if bodyErr != nil {
+			return util.ErrorResponse(ctx, bodyErr.Status, bodyErr.Err, bodyErr.Message)
+		}
+
+		// set ctx local called "linkInfo" to the linkInfo type.
+		// for a track, it looks like: {TargetLink: "https://music.youtube.com/watch?v=Z2X4uZL2o8Q", TargetPlatform: "spotify"}
+		// for a playlist, it looks like: {TargetLink: "https://music.youtube.com/playlist?list=OLAK5uy_m8ZQZ4Z1Z2X4uZL2o8Q", TargetPlatform: "spotify", Entity: "playlist"}
+		ctx.Locals("linkInfo", linkInfo)
+		return ctx.Next()
 	}
+}
+
+// LinkInfoFromConversionBody extracts the info of the link in a conversion body and checks that the app can convert it
+// to the target platform in the body. It is used by ExtractLinkInfoFromBody and by the portal websocket.
+func LinkInfoFromConversionBody(app *blueprint.DeveloperApp, conversionBody *blueprint.ConversionBody, platformsConfig config.Platforms) (*blueprint.LinkInfo, *ConversionBodyError) {
+	// adding all in order to support wildcard. when the option is empty, we can presume they want to convert
+	// to all platforms (that they have added their credentials for and the user has authed, that is)
+	platforms := []string{ytmusic.IDENTIFIER, spotify.IDENTIFIER, deezer.IDENTIFIER, applemusic.IDENTIFIER, tidal.IDENTIFIER, "all"}
 
 	if conversionBody.URL == "" {
-		log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - URL not detected. Skipping...\n")
-		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Request body must contain a URL or is sent with the wrong key")
+		log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - URL not detected. Skipping...\n")
+		return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request. Request body must contain a URL or is sent with the wrong key"}
 	}
-	linkInfo, err := services.ExtractLinkInfo(conversionBody.URL)
-	linkInfo.App = app.UID.String()
-	linkInfo.Developer = app.Developer.String()
-
+	linkInfo, err := services.ExtractLinkInfo(conversionBody.URL, platformsConfig)
 	if err != nil {
 		if errors.Is(err, blueprint.ErrHostUnsupported) {
-			return util.ErrorResponse(ctx, http.StatusNotImplemented, "not supported", "Not implemented.")
+			return nil, &ConversionBodyError{Status: http.StatusNotImplemented, Err: "not supported", Message: "Not implemented."}
 		}
 
 		if errors.Is(err, blueprint.ErrInvalidLink) {
-			log.Printf("[middleware][ExtractLinkInfoFromBody][warning] invalid conversionBody. are you sure its a url? %s\n", conversionBody)
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request body. Please make sure you pass a valid conversionBody")
+			log.Printf("[middleware][LinkInfoFromConversionBody][warning] invalid conversionBody. are you sure its a url? %s\n", conversionBody)
+			return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request body. Please make sure you pass a valid conversionBody"}
 		}
 
-		log.Printf("\n[middleware][ExtractLinkInfoFromBody] error - Could not extract conversionBody info: %v: for conversionBody: %v\n", err, conversionBody)
-		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred")
+		log.Printf("\n[middleware][LinkInfoFromConversionBody] error - Could not extract conversionBody info: %v: for conversionBody: %v\n", err, conversionBody)
+		return nil, &ConversionBodyError{Status: http.StatusInternalServerError, Err: err, Message: "An internal error occurred"}
 	}
 
 	if linkInfo == nil {
-		log.Printf("\n[middleware][ExtractLinkInfoFromBody] error - No linkInfo retrieved for conversionBody: %v: \n", conversionBody)
-		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "URL info not found.")
+		log.Printf("\n[middleware][LinkInfoFromConversionBody] error - No linkInfo retrieved for conversionBody: %v: \n", conversionBody)
+		return nil, &ConversionBodyError{Status: http.StatusNotFound, Err: "not found", Message: "URL info not found."}
 	}
+	linkInfo.App = app.UID.String()
+	linkInfo.Developer = app.Developer.String()
+	linkInfo.Sandbox = app.Sandbox
 
 	// fixme: is this really needed?
 	if conversionBody.TargetPlatform == "" || conversionBody.TargetPlatform == "all" {
-		log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - Track conversion but no target platform specified. \n")
+		log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - Track conversion but no target platform specified. \n")
 		conversionBody.TargetPlatform = "all"
 	}
 
 	if !lo.Contains(platforms, conversionBody.TargetPlatform) {
-		log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - track platform is invalid. please pass a valid platform value. \n")
-		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request body. Please make sure you pass a valid target platform")
+		log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - track platform is invalid. please pass a valid platform value. \n")
+		return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request body. Please make sure you pass a valid target platform"}
 	}
 	linkInfo.TargetPlatform = conversionBody.TargetPlatform
-	// set ctx local called "linkInfo" to the linkInfo type. this is for a track conversion.
-	// it looks like: {TargetLink: "https://music.youtube.com/watch?v=Z2X4uZL2o8Q", TargetPlatform: "spotify"}
-	ctx.Locals("linkInfo", linkInfo)
-
-	// prevent entity conversion if the source platform is not supported
-	switch linkInfo.Platform {
-	case deezer.IDENTIFIER:
-		if len(app.DeezerCredentials) == 0 {
-			log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - Deezer credentials not found. Exiting entity conversion\n")
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Deezer credentials not found")
-		}
-
-	case spotify.IDENTIFIER:
-		if len(app.SpotifyCredentials) == 0 {
-			log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - Spotify credentials not found. Exiting entity conversion\n")
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Spotify credentials not found")
-		}
 
-	case applemusic.IDENTIFIER:
-		if len(app.AppleMusicCredentials) == 0 {
-			log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - Apple Music credentials not found. Exiting entity conversion\n")
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Apple Music credentials not found")
-		}
-	case tidal.IDENTIFIER:
-		if len(app.TidalCredentials) == 0 {
-			log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - Tidal credentials not found. Exiting entity conversion\n")
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Tidal credentials not found")
+	// prevent entity conversion if the source platform is not supported. The sandbox needs no credentials.
+	if !app.Sandbox {
+		switch linkInfo.Platform {
+		case deezer.IDENTIFIER:
+			if len(app.DeezerCredentials) == 0 {
+				log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - Deezer credentials not found. Exiting entity conversion\n")
+				return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request. Deezer credentials not found"}
+			}
+
+		case spotify.IDENTIFIER:
+			if len(app.SpotifyCredentials) == 0 {
+				log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - Spotify credentials not found. Exiting entity conversion\n")
+				return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request. Spotify credentials not found"}
+			}
+
+		case applemusic.IDENTIFIER:
+			if len(app.AppleMusicCredentials) == 0 {
+				log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - Apple Music credentials not found. Exiting entity conversion\n")
+				return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request. Apple Music credentials not found"}
+			}
+		case tidal.IDENTIFIER:
+			if len(app.TidalCredentials) == 0 {
+				log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - Tidal credentials not found. Exiting entity conversion\n")
+				return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request. Tidal credentials not found"}
+			}
 		}
 	}
 
 	if strings.Contains(linkInfo.TargetLink, "playlist") {
 		// if the target platform is not set, we'll exit here. keep in mind in case of testing and it doesnt work as before.
 		if conversionBody.TargetPlatform == "" {
-			log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - Target platform not detected. Skipping...\n")
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "You are trying to convert a playlist. Please specify a target platform.")
+			log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - Target platform not detected. Skipping...\n")
+			return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "You are trying to convert a playlist. Please specify a target platform."}
 		}
 
 		// if the target platform is set, we'll check if it's valid. if it's not, we'll exit here.
 		playlistPlatforms := []string{"spotify", "deezer", "applemusic", "tidal"}
 		if !lo.Contains(playlistPlatforms, conversionBody.TargetPlatform) {
-			log.Printf("\n[middleware][ExtractLinkInfoFromBody] warning - track platform is invalid. please pass a valid platform value. \n")
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request body. Please make sure you pass a valid target platform")
+			log.Printf("\n[middleware][LinkInfoFromConversionBody] warning - track platform is invalid. please pass a valid platform value. \n")
+			return nil, &ConversionBodyError{Status: http.StatusBadRequest, Err: "bad request", Message: "Bad request body. Please make sure you pass a valid target platform"}
 		}
-		linkInfo.TargetPlatform = conversionBody.TargetPlatform
-		// set ctx local called "linkInfo" to the linkInfo type. this is for a playlist conversion.
-		// it looks like: {TargetLink: "https://music.youtube.com/playlist?list=OLAK5uy_m8ZQZ4Z1Z2X4uZL2o8Q", TargetPlatform: "spotify", Entity: "playlist"}
-		ctx.Locals("linkInfo", linkInfo)
 	}
 
-	return ctx.Next()
+	return linkInfo, nil
 }
 
-// ExtractLinkInfo fetches the extracted info about a link and save it into local context called "linkInfo"
-func ExtractLinkInfo(ctx *fiber.Ctx) error {
-	link := ctx.Query("link")
-	if link == "" {
-		log.Printf("\n[middleware][ExtractLinkInfo] warning - URL not detected. Skipping...\n")
-		return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Check you're using the '?link' query string")
-	}
-	linkInfo, err := services.ExtractLinkInfo(link)
-	if err != nil {
-		if err == blueprint.ErrHostUnsupported {
-			return util.ErrorResponse(ctx, http.StatusNotImplemented, "not supported", "Not implemented")
+// ExtractLinkInfo fetches the extracted info about a link and save it into local context called "linkInfo". platforms
+// are the platforms of the configuration, see services.ExtractLinkInfo.
+func ExtractLinkInfo(platforms config.Platforms) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		link := ctx.Query("link")
+		if link == "" {
+			log.Printf("\n[middleware][ExtractLinkInfo] warning - URL not detected. Skipping...\n")
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Bad request. Check you're using the '?link' query string")
 		}
-
-		if err == blueprint.ErrInvalidLink {
-			log.Printf("[middleware][ExtractLinkInfo][warning] invalid link. are you sure its a url? %s\n", link)
-			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid request body. The link is invalid")
+		linkInfo, err := services.ExtractLinkInfo(link, platforms)
+		if err != nil {
+			if err == blueprint.ErrHostUnsupported {
+				return util.ErrorResponse(ctx, http.StatusNotImplemented, "not supported", "Not implemented")
+			}
+
+			if err == blueprint.ErrInvalidLink {
+				log.Printf("[middleware][ExtractLinkInfo][warning] invalid link. are you sure its a url? %s\n", link)
+				return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid request body. The link is invalid")
+			}
+
+			log.Printf("\n[middleware][ExtractLinkInfo] error - Could not extract link info: %v: for link: %v\n", err, link)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred")
 		}
 
-		log.Printf("\n[middleware][ExtractLinkInfo] error - Could not extract link info: %v: for link: %v\n", err, link)
-		return util.ErrorResponse(ctx, http.StatusInternalServerError, err, "An internal error occurred")
-	}
+		if linkInfo == nil {
+			log.Printf("\n[middleware][ExtractLinkInfo] error - No linkInfo retrieved for link: %v: \n", link)
+			return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "URL info not found.")
+		}
 
-	if linkInfo == nil {
-		log.Printf("\n[middleware][ExtractLinkInfo] error - No linkInfo retrieved for link: %v: \n", link)
-		return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "URL info not found.")
+		ctx.Locals("linkInfo", linkInfo)
+		return ctx.Next()
 	}
-
-	ctx.Locals("linkInfo", linkInfo)
-	return ctx.Next()
 }
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/services/ratelimit"
+	"orchdio/util"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type RateLimitMiddleware struct {
+	Limiter *ratelimit.Limiter
+}
+
+func NewRateLimitMiddleware(limiter *ratelimit.Limiter) *RateLimitMiddleware {
+	return &RateLimitMiddleware{Limiter: limiter}
+}
+
+// Limit limits the requests of the app making the request, on its plan. The requests budget is always counted, along
+// with the budgets passed, e.g. the playlist conversions for the playlist conversion endpoint. Requests with a userId
+// param are also counted against the per-user budgets. It must come after the middleware that authenticates the app.
+//
+// The RateLimit-Limit, RateLimit-Remaining and RateLimit-Reset headers are set for the budget with the least left, and
+// requests over a budget are refused with a 429 and a Retry-After header. If Redis fails, requests are let through. The
+// requests made with a test key are counted against budgets of their own, see ratelimit.SandboxID.
+func (r *RateLimitMiddleware) Limit(budgets ...string) fiber.Handler {
+	budgets = append([]string{ratelimit.BudgetRequests, ratelimit.BudgetUserRequests}, budgets...)
+	return func(ctx *fiber.Ctx) error {
+		app, ok := ctx.Locals("app").(*blueprint.DeveloperApp)
+		if !ok || app == nil {
+			return ctx.Next()
+		}
+		appId := app.UID.String()
+		userId := ctx.Params("userId")
+
+		// the budgets are checked and counted at once: a request refused by a budget does not use the others up.
+		results, err := r.Limiter.Allow(ctx.Context(), ratelimit.SandboxID(appId, app.Sandbox), app.Plan, budgets, userId)
+		if err != nil {
+			log.Printf("[middleware][RateLimit] error - could not check the budgets of app %s: %v\n", appId, err)
+			return ctx.Next()
+		}
+		var tightest *ratelimit.Result
+		for _, result := range results {
+			if !result.Allowed {
+				setRateLimitHeaders(ctx, result)
+				retryAfter := int(math.Ceil(result.RetryAfter(time.Now()).Seconds()))
+				ctx.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+				log.Printf("[middleware][RateLimit] app %s is over its %s budget of %d. It resets at %s\n", appId, result.Budget, result.Limit, result.Reset.Format(time.RFC3339))
+				return util.ErrorResponse(ctx, http.StatusTooManyRequests, result,
+					fmt.Sprintf("Rate limit exceeded for %s. Try again after %s", result.Budget, result.Reset.Format(time.RFC3339)))
+			}
+			if tightest == nil || result.Remaining < tightest.Remaining {
+				tightest = result
+			}
+		}
+		if tightest != nil {
+			setRateLimitHeaders(ctx, tightest)
+		}
+		return ctx.Next()
+	}
+}
+
+func setRateLimitHeaders(ctx *fiber.Ctx, result *ratelimit.Result) {
+	reset := int(math.Ceil(result.RetryAfter(time.Now()).Seconds()))
+	ctx.Set("RateLimit-Limit", strconv.FormatInt(result.Limit, 10))
+	ctx.Set("RateLimit-Remaining", strconv.FormatInt(result.Remaining, 10))
+	ctx.Set("RateLimit-Reset", strconv.Itoa(reset))
+	ctx.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d;name=%q", result.Limit, windowSeconds(result), result.Budget))
+}
+
+// windowSeconds is the length of the window of the budget of the result, for the RateLimit-Policy header.
+func windowSeconds(result *ratelimit.Result) int {
+	b := ratelimit.Budgets[result.Budget]
+	start := ratelimit.WindowStart(b.Window, time.Now())
+	return int(ratelimit.WindowEnd(b.Window, start).Sub(start).Seconds())
+}
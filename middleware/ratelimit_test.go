@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/services/ratelimit"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitSandbox(t *testing.T) {
+	server := miniredis.RunT(t)
+	limiter := ratelimit.NewLimiter(redis.NewClient(&redis.Options{Addr: server.Addr()}), map[string]ratelimit.Plan{
+		ratelimit.PlanFree: {ratelimit.BudgetRequests: 1},
+	})
+	appId := uuid.New()
+	app := fiber.New()
+	// stands in for the auth middlewares: the "test" key is a test key of the app.
+	app.Use(func(ctx *fiber.Ctx) error {
+		ctx.Locals("app", &blueprint.DeveloperApp{UID: appId, Plan: ratelimit.PlanFree, Sandbox: ctx.Get("x-orchdio-key") == "test"})
+		return ctx.Next()
+	})
+	app.Get("/", NewRateLimitMiddleware(limiter).Limit(), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(http.StatusOK)
+	})
+	request := func(key string) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("x-orchdio-key", key)
+		res, err := app.Test(req)
+		require.NoError(t, err)
+		return res.StatusCode
+	}
+
+	// the requests in the sandbox have budgets of their own: they do not use up the budgets of the live requests.
+	assert.Equal(t, http.StatusOK, request("test"))
+	assert.Equal(t, http.StatusTooManyRequests, request("test"))
+	assert.Equal(t, http.StatusOK, request("live"))
+	assert.Equal(t, http.StatusTooManyRequests, request("live"))
+}
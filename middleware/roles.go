@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/util"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// RequireOrgRole makes sure the user of the JWT is a member of the org of the orgId param, with the role or a more
+// privileged one. The member is set in the "org_member" local. It must come after VerifyAppJWT.
+func (a *AuthMiddleware) RequireOrgRole(role string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		orgId := ctx.Params("orgId")
+		if !util.IsValidUUID(orgId) {
+			log.Printf("[middleware][RequireOrgRole] error - invalid org id %s\n", orgId)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Org ID is invalid. Please pass a valid Org ID")
+		}
+		member, rErr := a.requireMemberRole(ctx, orgId, role)
+		if member == nil {
+			return rErr
+		}
+		return ctx.Next()
+	}
+}
+
+// RequireAppRole makes sure the user of the JWT is a member of the org of the app of the appId param, with the role or
+// a more privileged one. The app is set in the "developer_app" local and the member in the "org_member" local. It must
+// come after VerifyAppJWT.
+func (a *AuthMiddleware) RequireAppRole(role string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		appId := ctx.Params("appId")
+		if !util.IsValidUUID(appId) {
+			log.Printf("[middleware][RequireAppRole] error - invalid app id %s\n", appId)
+			return util.ErrorResponse(ctx, http.StatusBadRequest, "bad request", "Invalid app id")
+		}
+
+		database := db.NewDB{DB: a.DB}
+		app, err := database.FetchAppByAppId(appId)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return util.ErrorResponse(ctx, http.StatusNotFound, "not found", "App not found")
+			}
+			log.Printf("[middleware][RequireAppRole] error - could not fetch app %s: %v\n", appId, err)
+			return util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+		}
+
+		member, rErr := a.requireMemberRole(ctx, app.Organization, role)
+		if member == nil {
+			return rErr
+		}
+		ctx.Locals("developer_app", app)
+		return ctx.Next()
+	}
+}
+
+// requireMemberRole fetches the membership of the user of the JWT in the org and checks their role. It returns a nil
+// member and the error response if the user is not a member or does not have the role.
+func (a *AuthMiddleware) requireMemberRole(ctx *fiber.Ctx, orgId, role string) (*blueprint.OrgMember, error) {
+	claims := ctx.Locals("app_jwt").(*blueprint.AppJWT)
+	database := db.NewDB{DB: a.DB}
+	member, err := database.FetchOrgMember(orgId, claims.DeveloperID)
+	if err != nil {
+		// users that are not members of the org are told it does not exist, so that orgs cannot be probed.
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[middleware][RequireOrgRole] user %s is not a member of org %s\n", claims.DeveloperID, orgId)
+			return nil, util.ErrorResponse(ctx, http.StatusNotFound, "not found", "Organization not found")
+		}
+		log.Printf("[middleware][RequireOrgRole] error - could not fetch member %s of org %s: %v\n", claims.DeveloperID, orgId, err)
+		return nil, util.ErrorResponse(ctx, http.StatusInternalServerError, "internal error", "An unexpected error occurred")
+	}
+
+	if !blueprint.OrgRoleAtLeast(member.Role, role) {
+		log.Printf("[middleware][RequireOrgRole] user %s is a %s of org %s, %s %s needs %s\n", claims.DeveloperID, member.Role, orgId, ctx.Method(), ctx.Path(), role)
+		return nil, util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", fmt.Sprintf("This action needs the %s role in the organization", role))
+	}
+	ctx.Locals("org_member", member)
+	return member, nil
+}
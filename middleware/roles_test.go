@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDeveloperId = "0d3e1c52-5b8e-4f7e-9d1a-3c2b1a0f9e8d"
+
+// roleApp returns the org and app routes that need the role, called by testDeveloperId, on a mock database. The routes
+// reply with the locals the middlewares set.
+func roleApp(t *testing.T, role string) (*fiber.App, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	a := NewAuthMiddleware(sqlx.NewDb(conn, "postgres"))
+
+	app := fiber.New()
+	jwt := func(ctx *fiber.Ctx) error {
+		ctx.Locals("app_jwt", &blueprint.AppJWT{DeveloperID: testDeveloperId})
+		return ctx.Next()
+	}
+	locals := func(ctx *fiber.Ctx) error {
+		member, _ := ctx.Locals("org_member").(*blueprint.OrgMember)
+		developerApp, _ := ctx.Locals("developer_app").(*blueprint.DeveloperApp)
+		body := fiber.Map{}
+		if member != nil {
+			body["role"] = member.Role
+		}
+		if developerApp != nil {
+			body["app"] = developerApp.UID.String()
+		}
+		return ctx.JSON(body)
+	}
+	app.Get("/org/:orgId", jwt, a.RequireOrgRole(role), locals)
+	app.Get("/app/:appId", jwt, a.RequireAppRole(role), locals)
+	return app, mock
+}
+
+func expectOrgMember(mock sqlmock.Sqlmock, orgId, role string) {
+	query := mock.ExpectQuery(regexp.QuoteMeta(queries.FetchOrgMember)).WithArgs(orgId, testDeveloperId)
+	if role == "" {
+		query.WillReturnError(sql.ErrNoRows)
+		return
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"org", "user", "role"}).AddRow(orgId, testDeveloperId, role))
+}
+
+func get(t *testing.T, app *fiber.App, path string) (int, string) {
+	res, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	return res.StatusCode, string(body)
+}
+
+func TestRequireOrgRole(t *testing.T) {
+	orgId := uuid.NewString()
+
+	t.Run("an invalid org id", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		status, _ := get(t, app, "/org/not-a-uuid")
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a user that is not a member", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		expectOrgMember(mock, orgId, "")
+		status, _ := get(t, app, "/org/"+orgId)
+		assert.Equal(t, http.StatusNotFound, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a member with a lower role", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleAdmin)
+		expectOrgMember(mock, orgId, blueprint.OrgRoleDeveloper)
+		status, _ := get(t, app, "/org/"+orgId)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a member with a higher role", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		expectOrgMember(mock, orgId, blueprint.OrgRoleOwner)
+		status, body := get(t, app, "/org/"+orgId)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"role":"owner"}`, body)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestRequireAppRole(t *testing.T) {
+	orgId := uuid.NewString()
+	appId := uuid.NewString()
+	expectApp := func(mock sqlmock.Sqlmock) {
+		mock.ExpectQuery(regexp.QuoteMeta(queries.FetchAppByAppID)).WithArgs(appId).
+			WillReturnRows(sqlmock.NewRows([]string{"uuid", "organization"}).AddRow(appId, orgId))
+	}
+
+	t.Run("an invalid app id", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		status, _ := get(t, app, "/app/not-a-uuid")
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("an app that does not exist", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		mock.ExpectQuery(regexp.QuoteMeta(queries.FetchAppByAppID)).WithArgs(appId).WillReturnError(sql.ErrNoRows)
+		status, _ := get(t, app, "/app/"+appId)
+		assert.Equal(t, http.StatusNotFound, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a user that is not a member of the org of the app", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleViewer)
+		expectApp(mock)
+		expectOrgMember(mock, orgId, "")
+		status, _ := get(t, app, "/app/"+appId)
+		assert.Equal(t, http.StatusNotFound, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a member with a lower role", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		expectApp(mock)
+		expectOrgMember(mock, orgId, blueprint.OrgRoleViewer)
+		status, _ := get(t, app, "/app/"+appId)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("a member with the role", func(t *testing.T) {
+		app, mock := roleApp(t, blueprint.OrgRoleDeveloper)
+		expectApp(mock)
+		expectOrgMember(mock, orgId, blueprint.OrgRoleDeveloper)
+		status, body := get(t, app, "/app/"+appId)
+		assert.Equal(t, http.StatusOK, status)
+		assert.JSONEq(t, `{"role":"developer","app":"`+appId+`"}`, body)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
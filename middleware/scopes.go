@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/util"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/samber/lo"
+)
+
+// RequireScopes makes sure the secret key of the request has all the scopes. It must come after the middleware that
+// authenticates the app. Requests made with the public key are not checked: what the public key can do is decided by
+// the routes that accept it.
+func RequireScopes(scopes ...string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		key, ok := ctx.Locals("app_key").(*blueprint.AppKey)
+		if !ok || key == nil {
+			return ctx.Next()
+		}
+		if missing := MissingScopes(key, scopes...); len(missing) > 0 {
+			log.Printf("[middleware][RequireScopes] key %s is missing scopes %v for %s %s\n", key.UID, missing, ctx.Method(), ctx.Path())
+			return util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", fmt.Sprintf("This key is missing the scopes: %s", strings.Join(missing, ", ")))
+		}
+		return ctx.Next()
+	}
+}
+
+// MissingScopes returns the scopes the secret key does not have. Requests made with the public key (a nil key) have
+// every scope.
+func MissingScopes(key *blueprint.AppKey, scopes ...string) []string {
+	if key == nil || key.HasScopes(scopes...) {
+		return nil
+	}
+	return lo.Without(scopes, key.Scopes...)
+}
+
+// RequireLiveKey refuses the requests made with a test key on the routes that have no sandbox: they read or change the
+// real users of the app and their platform accounts. It must come after the middleware that authenticates the app.
+func RequireLiveKey(ctx *fiber.Ctx) error {
+	key, ok := ctx.Locals("app_key").(*blueprint.AppKey)
+	if ok && key != nil && key.Mode == blueprint.AppKeyModeTest {
+		log.Printf("[middleware][RequireLiveKey] test key %s can not be used for %s %s\n", key.UID, ctx.Method(), ctx.Path())
+		return util.ErrorResponse(ctx, http.StatusForbidden, "forbidden", "This route is not available in the sandbox. Please use a live key")
+	}
+	return ctx.Next()
+}
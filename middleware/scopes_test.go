@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// scopedApp returns an app with a route that needs the scopes, called with the key (nil for a public key request).
+func scopedApp(key *blueprint.AppKey, scopes ...string) *fiber.App {
+	app := fiber.New()
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		if key != nil {
+			ctx.Locals("app_key", key)
+		}
+		return ctx.Next()
+	}, RequireScopes(scopes...), func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(http.StatusOK)
+	})
+	return app
+}
+
+func TestRequireScopes(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    *blueprint.AppKey
+		status int
+	}{
+		{name: "public key", key: nil, status: http.StatusOK},
+		{name: "unrestricted key", key: &blueprint.AppKey{}, status: http.StatusOK},
+		{name: "key with the scopes", key: &blueprint.AppKey{Scopes: []string{blueprint.ScopeConvertTrack, blueprint.ScopeTasksRead}}, status: http.StatusOK},
+		{name: "key missing a scope", key: &blueprint.AppKey{Scopes: []string{blueprint.ScopeConvertTrack}}, status: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := scopedApp(tt.key, blueprint.ScopeConvertTrack, blueprint.ScopeTasksRead)
+			res, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
+
+func TestMissingScopes(t *testing.T) {
+	assert.Empty(t, MissingScopes(nil, blueprint.ScopeConvertPlaylist))
+	assert.Empty(t, MissingScopes(&blueprint.AppKey{}, blueprint.ScopeConvertPlaylist))
+	key := &blueprint.AppKey{Scopes: []string{blueprint.ScopeConvertTrack}}
+	assert.Empty(t, MissingScopes(key, blueprint.ScopeConvertTrack))
+	assert.Equal(t, []string{blueprint.ScopeConvertPlaylist, blueprint.ScopeTasksRead}, MissingScopes(key, blueprint.ScopeConvertPlaylist, blueprint.ScopeTasksRead))
+}
+
+func TestRequireLiveKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    *blueprint.AppKey
+		status int
+	}{
+		{"live key", &blueprint.AppKey{Mode: blueprint.AppKeyModeLive}, http.StatusOK},
+		{"test key", &blueprint.AppKey{Mode: blueprint.AppKeyModeTest}, http.StatusForbidden},
+		{"public key", nil, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Get("/", func(ctx *fiber.Ctx) error {
+				if tt.key != nil {
+					ctx.Locals("app_key", tt.key)
+				}
+				return ctx.Next()
+			}, RequireLiveKey, func(ctx *fiber.Ctx) error {
+				return ctx.SendStatus(http.StatusOK)
+			})
+			res, err := app.Test(httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.status, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"orchdio/blueprint"
+	"orchdio/db"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/jmoiron/sqlx"
+)
+
+type UsageMiddleware struct {
+	DB *sqlx.DB
+}
+
+func NewUsageMiddleware(db *sqlx.DB) *UsageMiddleware {
+	return &UsageMiddleware{DB: db}
+}
+
+// Record records the usage event for the app making the request, once the request has succeeded. The platform is the
+// platform param of the route or, for conversions, the target platform. It must come after the middleware that
+// authenticates the app. The requests made with a test key (in the sandbox) are not recorded.
+func (u *UsageMiddleware) Record(event string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		err := ctx.Next()
+		if err != nil || ctx.Response().StatusCode() >= fiber.StatusBadRequest {
+			return err
+		}
+		app, ok := ctx.Locals("app").(*blueprint.DeveloperApp)
+		if !ok || app == nil || app.Sandbox {
+			return nil
+		}
+
+		platform := ctx.Params("platform")
+		if linkInfo, ok := ctx.Locals("linkInfo").(*blueprint.LinkInfo); ok && linkInfo != nil && platform == "" {
+			platform = linkInfo.TargetPlatform
+		}
+		// the values of the context are reused by fiber once the handler returns, so they are copied for the goroutine.
+		usage := &blueprint.UsageEvent{
+			App:      app.UID.String(),
+			Event:    event,
+			Platform: utils.CopyString(platform),
+			Endpoint: utils.CopyString(ctx.Route().Path),
+			Count:    1,
+		}
+		// usage is recorded on a best effort basis, it does not slow the request down and errors are only logged.
+		go func() {
+			database := db.NewDB{DB: u.DB}
+			_ = database.RecordAppUsage(usage)
+		}()
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hibiken/asynq"
+)
+
+// InFlight tracks the tasks being processed by the handlers. When the queue server shuts down (see
+// asynq.Server.Shutdown), the tasks that are not done after its shutdown timeout are put back in their queue and the
+// context of their handler is cancelled, but the server does not wait for the handlers to return. Wait lets the
+// process wait for them, so that they can stop cleanly (e.g. drop the webhook events pending rather than send them).
+type InFlight struct {
+	wg sync.WaitGroup
+}
+
+// NewInFlight returns a new tracker of the tasks in flight.
+func NewInFlight() *InFlight {
+	return &InFlight{}
+}
+
+// Middleware tracks the tasks processed by the handler.
+func (f *InFlight) Middleware(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		f.wg.Add(1)
+		defer f.wg.Done()
+		return h.ProcessTask(ctx, task)
+	})
+}
+
+// Wait waits for the handlers of the tasks in flight to return, for up to timeout. It returns false if some have not
+// returned in time.
+func (f *InFlight) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInFlightWait(t *testing.T) {
+	inFlight := NewInFlight()
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := inFlight.Middleware(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		close(started)
+		<-release
+		return nil
+	}))
+
+	go func() { _ = handler.ProcessTask(context.Background(), asynq.NewTask("playlist:conversion", nil)) }()
+	<-started
+	assert.False(t, inFlight.Wait(10*time.Millisecond))
+
+	close(release)
+	assert.True(t, inFlight.Wait(time.Second))
+}
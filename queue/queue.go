@@ -6,20 +6,26 @@ import (
 	"errors"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
+	"orchdio/internal/encryption"
+	"orchdio/logger"
+	"orchdio/taskevents"
+	"orchdio/tracing"
 	"orchdio/universal"
-	"os"
+	"orchdio/webhooks"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/hibiken/asynq"
 	"github.com/jmoiron/sqlx"
 	sendinblue "github.com/sendinblue/APIv3-go-library/v2/lib"
+	"go.uber.org/zap"
 )
 
 type QueueService interface {
-	EnqueueTask(task *asynq.Task, q, taskId string, processIn time.Duration) error
-	NewTask(taskType, queue string, retry int, payload []byte) (*asynq.Task, error)
+	EnqueueTask(ctx context.Context, task *asynq.Task, q, taskId string, processIn time.Duration) error
+	NewTask(ctx context.Context, taskType, queue string, retry int, payload []byte) (*asynq.Task, error)
 }
 
 type OrchdioQueue struct {
@@ -27,46 +33,56 @@ type OrchdioQueue struct {
 	AsynqRouter *asynq.ServeMux
 	DB          *sqlx.DB
 	Red         *redis.Client
+	// Envelope decrypts the integration credentials of the apps whose playlists are converted.
+	Envelope *encryption.Envelope
+	Config   *config.Config
 }
 
-func NewOrchdioQueue(asynqClient *asynq.Client, db *sqlx.DB, red *redis.Client, router *asynq.ServeMux) *OrchdioQueue {
+func NewOrchdioQueue(asynqClient *asynq.Client, db *sqlx.DB, red *redis.Client, router *asynq.ServeMux, envelope *encryption.Envelope, cfg *config.Config) *OrchdioQueue {
 	return &OrchdioQueue{
 		AsynqClient: asynqClient,
 		DB:          db,
 		Red:         red,
 		AsynqRouter: router,
+		Envelope:    envelope,
+		Config:      cfg,
 	}
 }
 
 // PlaylistTaskHandler is the handler method for processing playlist conversion tasks.
 func (o *OrchdioQueue) PlaylistTaskHandler(ctx context.Context, task *asynq.Task) error {
-	log.Printf("[queue][PlaylistTaskHandler] - processing task")
+	l := logger.FromContext(ctx)
+	l.Info("[queue][PlaylistTaskHandler] - processing task")
 
 	// deserialize the task payload and get the PlaylistTaskData struct
 	var data blueprint.PlaylistTaskData
 	err := json.Unmarshal(task.Payload(), &data)
 	if err != nil {
-		log.Printf("[queue][PlaylistConversionHandler][conversion] - error unmarshalling task payload: %v", err)
+		l.Error("[queue][PlaylistConversionHandler][conversion] - error unmarshalling task payload", zap.Error(err))
 		return err
 	}
+	l = l.With(zap.String(logger.FieldAppID, data.App.UID.String()), zap.String(logger.FieldPlatform, data.LinkInfo.Platform))
 	data.LinkInfo.TaskID = task.ResultWriter().TaskID()
-	cErr := o.PlaylistHandler(task.ResultWriter().TaskID(), data.ShortURL, data.LinkInfo, data.App.UID.String())
+	cErr := o.PlaylistHandler(ctx, task.ResultWriter().TaskID(), data.ShortURL, data.LinkInfo, data.App.UID.String())
 	if cErr != nil {
-		log.Printf("[queue][PlaylistConversionHandler][conversion] - error processing task in queue handler: %v", cErr)
+		l.Error("[queue][PlaylistConversionHandler][conversion] - error processing task in queue handler", zap.Error(cErr))
 		if errors.Is(err, blueprint.ErrPhantomErr) {
-			log.Printf("[queue][PlaylistConversionHandler][conversion] - phantom error, skipping but marking as done")
+			l.Warn("[queue][PlaylistConversionHandler][conversion] - phantom error, skipping but marking as done")
 			return nil
 		}
 		return cErr
 	}
+	l.Info("[queue][PlaylistTaskHandler] - processed task")
 	return nil
 }
 
 // EnqueueTask enqueues the task passed in.
-func (o *OrchdioQueue) EnqueueTask(task *asynq.Task, queue, taskId string, processIn time.Duration) error {
+func (o *OrchdioQueue) EnqueueTask(ctx context.Context, task *asynq.Task, queue, taskId string, processIn time.Duration) error {
 	log.Printf("[queue][EnqueueTask] - enqueuing task: %v", taskId)
-	_, err := o.AsynqClient.Enqueue(task, asynq.Queue(queue), asynq.TaskID(taskId), asynq.Unique(time.Second*60),
+	ctx, span := tracing.StartEnqueue(ctx, queue, taskId)
+	_, err := o.AsynqClient.EnqueueContext(ctx, task, asynq.Queue(queue), asynq.TaskID(taskId), asynq.Unique(time.Second*60),
 		asynq.ProcessIn(processIn))
+	tracing.End(span, err)
 	if err != nil {
 		log.Printf("[queue][EnqueueTask] - error enqueuing task: %v", err)
 		return err
@@ -75,17 +91,19 @@ func (o *OrchdioQueue) EnqueueTask(task *asynq.Task, queue, taskId string, proce
 	return nil
 }
 
-// NewTask creates a new task and returns it.
-func (o *OrchdioQueue) NewTask(taskType, queue string, retry int, payload []byte) (*asynq.Task, error) {
-	return asynq.NewTask(taskType, payload, asynq.Queue(queue), asynq.Retention(time.Hour*24), asynq.MaxRetry(retry)), nil
+// NewTask creates a new task and returns it. The trace context of ctx is set in the headers of the task, so that its
+// processing is part of the trace.
+func (o *OrchdioQueue) NewTask(ctx context.Context, taskType, queue string, retry int, payload []byte) (*asynq.Task, error) {
+	return asynq.NewTaskWithHeaders(taskType, payload, tracing.TaskHeaders(ctx), asynq.Queue(queue), asynq.Retention(time.Hour*24),
+		asynq.MaxRetry(retry)), nil
 }
 
 // SendEmail sends the email using sendinblue.
 func (o *OrchdioQueue) SendEmail(emailData *blueprint.EmailTaskData) error {
 	// create new sendinblue config
-	config := sendinblue.NewConfiguration()
-	config.AddDefaultHeader("api-key", os.Getenv("SENDINBLUE_API_KEY"))
-	client := sendinblue.NewAPIClient(config)
+	sibConfig := sendinblue.NewConfiguration()
+	sibConfig.AddDefaultHeader("api-key", o.Config.Email.SendinblueAPIKey)
+	client := sendinblue.NewAPIClient(sibConfig)
 
 	subject := "App Access"
 	if emailData.Subject != "" {
@@ -119,40 +137,54 @@ func (o *OrchdioQueue) SendEmail(emailData *blueprint.EmailTaskData) error {
 	return nil
 }
 
+// publishTaskFailed adds the failed event to the event stream of the task, so that the clients following it are notified.
+func (o *OrchdioQueue) publishTaskFailed(appId, taskId string, payload *blueprint.TaskErrorPayload) {
+	err := taskevents.PublishFailed(o.Red, appId, taskId, payload)
+	if err != nil {
+		log.Printf("[queue][publishTaskFailed] - could not publish task failed event: %v", err)
+	}
+}
+
 // SendEmailHandler is the handler for sending emails in queues.
-func (o *OrchdioQueue) SendEmailHandler(_ context.Context, task *asynq.Task) error {
-	log.Printf("[queue][SendEmailHandler][send-email] sending email in queue")
+func (o *OrchdioQueue) SendEmailHandler(ctx context.Context, task *asynq.Task) error {
+	l := logger.FromContext(ctx)
+	l.Info("[queue][SendEmailHandler][send-email] sending email in queue")
 	var emailData blueprint.EmailTaskData
 	err := json.Unmarshal(task.Payload(), &emailData)
 	if err != nil {
-		log.Printf("[queue][SendEmailHandler][send-email] error unmarshalling task payload: %v", err)
+		l.Error("[queue][SendEmailHandler][send-email] error unmarshalling task payload", zap.Error(err))
 		return err
 	}
 
 	err = o.SendEmail(&emailData)
 	if err != nil {
-		log.Printf("[queue][SendEmailHandler][send-email] error sending email: %v", err)
+		l.Error("[queue][SendEmailHandler][send-email] error sending email", zap.Error(err), zap.Int("template_id", emailData.TemplateID))
 		return err
 	}
-	log.Printf("[queue][SendEmailHandler][send-email] email sent to %v", emailData.To)
+	l.Info("[queue][SendEmailHandler][send-email] email sent", zap.Int("template_id", emailData.TemplateID))
 	return nil
 }
 
 // PlaylistHandler converts a playlist immediately.
-func (o *OrchdioQueue) PlaylistHandler(uid, shorturl string, info *blueprint.LinkInfo, appId string) error {
-	log.Printf("[queue][PlaylistHandler] - processing task: %v", uid)
+func (o *OrchdioQueue) PlaylistHandler(ctx context.Context, uid, shorturl string, info *blueprint.LinkInfo, appId string) (err error) {
+	l := logger.FromContext(ctx).With(zap.String(logger.FieldTaskID, uid))
+	l.Info("[queue][PlaylistHandler] - processing task")
+	ctx, span := tracing.Start(ctx, "queue.PlaylistHandler", tracing.AttrTaskID.String(uid), tracing.AttrAppID.String(appId),
+		tracing.AttrPlatform.String(info.Platform), tracing.AttrTargetPlatform.String(info.TargetPlatform))
+	defer func() { tracing.End(span, err) }()
+
 	database := db.NewDB{DB: o.DB}
 	// fetch app from db
-	_, err := database.FetchAppByAppIdWithoutDevId(appId)
+	_, err = database.FetchAppByAppIdWithoutDevId(appId)
 	if err != nil {
-		log.Printf("[queue][PlaylistHandler] - could not find user: %v", err)
+		l.Error("[queue][PlaylistHandler] - could not find app", zap.Error(err))
 		return err
 	}
 
 	// get task from db
 	task, dbErr := database.FetchTask(uid)
 	if dbErr != nil {
-		log.Printf("[queue][PlaylistHandler] - could not find task: %v", dbErr)
+		l.Error("[queue][PlaylistHandler] - could not find task", zap.Error(dbErr))
 		return dbErr
 	}
 	taskId := task.UID.String()
@@ -161,14 +193,20 @@ func (o *OrchdioQueue) PlaylistHandler(uid, shorturl string, info *blueprint.Lin
 	// to a client (via webhook) after a playlist has been converted.
 	info.UniqueID = task.UniqueID
 
-	playlist, cErr := universal.ConvertPlaylist(info, o.Red, o.DB)
+	playlist, cErr := universal.ConvertPlaylist(ctx, info, o.Red, o.DB, webhooks.NewWebhookSender(o.DB, o.AsynqClient, o.Config.Webhooks), o.Envelope, o.Config)
+	// the conversion is interrupted when the worker shuts down before it is done (see InFlight). The task is put back in
+	// the queue and converted again by the next worker, so it is not marked as failed.
+	if cErr != nil && ctx.Err() != nil {
+		l.Info("[queue][PlaylistHandler] - conversion of task interrupted, the task is requeued", zap.Error(cErr))
+		return ctx.Err()
+	}
 	var status string
 	// for now, we don't want to bother about retrying and all of that. we're simply going to mark a task as failed if it fails
 	// the reason is that it's hard handling the retry for it to worth it. In the future, we might add a proper retry system
 	// but for now, if a playlist conversion fails, it fails. In the frontend, the user will most likely retry anyway and that means
 	// calling the endpoint again, which will create a new task.
 	if cErr != nil {
-		log.Printf("[queue][EnqueueTask] - error converting playlist: %v", cErr)
+		l.Error("[queue][EnqueueTask] - error converting playlist", zap.Error(cErr))
 		status = blueprint.TaskStatusFailed
 		// this is for when for example, apple music returns Not Found for a playlist thats visible but not public. (needs citation)
 		if errors.Is(cErr, blueprint.EnoResult) {
@@ -183,29 +221,30 @@ func (o *OrchdioQueue) PlaylistHandler(uid, shorturl string, info *blueprint.Lin
 			// serialize the payload
 			serializedPayload, jErr := json.Marshal(&payload)
 			if jErr != nil {
-				log.Printf("[queue][EnqueueTask] - error marshalling task 'result not found' payload: %v", jErr)
+				l.Error("[queue][EnqueueTask] - error marshalling task 'result not found' payload", zap.Error(jErr))
 			}
 			taskErr := database.UpdateTaskStatus(taskId, status)
 			if taskErr != nil {
-				log.Printf("[queue][EnqueueTask] - could not update task status in DB when updating not found conversion: %v", taskErr)
+				l.Error("[queue][EnqueueTask] - could not update task status in DB when updating not found conversion", zap.Error(taskErr))
 				return taskErr
 			}
 
 			// update task result to payload
 			_, updateErr := database.UpdateTaskResult(taskId, string(serializedPayload))
 			if updateErr != nil {
-				log.Printf("[queue][EnqueueTask] - could not update task result in DB when updating not found conversion: %v", updateErr)
+				l.Error("[queue][EnqueueTask] - could not update task result in DB when updating not found conversion", zap.Error(updateErr))
 				return updateErr
 			}
 
-			log.Printf("[queue][EnqueueTask] could not fetch the playlist. skipping but marking as done")
+			o.publishTaskFailed(appId, taskId, &payload)
+			l.Warn("[queue][EnqueueTask] could not fetch the playlist. skipping but marking as done")
 			return nil
 		}
 
 		// update the task status to failed
 		taskErr := database.UpdateTaskStatus(taskId, status)
 		if taskErr != nil {
-			log.Printf("[queue][EnqueueTask] - error updating task status: %v", taskErr)
+			l.Error("[queue][EnqueueTask] - error updating task status", zap.Error(taskErr))
 			return taskErr
 		}
 
@@ -220,23 +259,24 @@ func (o *OrchdioQueue) PlaylistHandler(uid, shorturl string, info *blueprint.Lin
 		// serialize the payload
 		ser, jErr := json.Marshal(&payload)
 		if jErr != nil {
-			log.Printf("[queue][EnqueueTask] - error marshalling task error payload: %v", jErr)
+			l.Error("[queue][EnqueueTask] - error marshalling task error payload", zap.Error(jErr))
 			return jErr
 		}
 
 		// update task result to payload
 		_, updateErr := database.UpdateTaskResult(taskId, string(ser))
 		if updateErr != nil {
-			log.Printf("[queue][EnqueueTask] - failed to process playlist task and could not update 'task error payload' in database %v", updateErr)
+			l.Error("[queue][EnqueueTask] - failed to process playlist task and could not update 'task error payload' in database", zap.Error(updateErr))
 			return updateErr
 		}
-		log.Printf("[queue][EnqueueTask] - error converting playlist: For some reason, we couldnt convert this playlist but we will mark as done.%v", err)
+		o.publishTaskFailed(appId, taskId, &payload)
+		l.Error("[queue][EnqueueTask] - error converting playlist: For some reason, we couldnt convert this playlist but we will mark as done", zap.Error(err))
 
 		return nil
 	}
 
 	if playlist == nil {
-		log.Printf("Playlist is nil, what to do???")
+		l.Warn("Playlist is nil, what to do???")
 		return nil
 	}
 
@@ -248,24 +288,24 @@ func (o *OrchdioQueue) PlaylistHandler(uid, shorturl string, info *blueprint.Lin
 	// serialize playlist
 	ser, mErr := json.Marshal(playlist)
 	if mErr != nil {
-		log.Printf("[queue][EnqueueTask] - error marshalling playlist conversion: %v", mErr)
+		l.Error("[queue][EnqueueTask] - error marshalling playlist conversion", zap.Error(mErr))
 		return mErr
 	}
-	log.Printf("[queue][PlaylistHandler] - serialized conversion data")
+	l.Info("[queue][PlaylistHandler] - serialized conversion data")
 	_, rErr := database.UpdateTaskResult(taskId, string(ser))
 	if rErr != nil {
-		log.Printf("[queue][EnqueueTask] - error updating task status: %v", rErr)
+		l.Error("[queue][EnqueueTask] - error updating task status", zap.Error(rErr))
 		return rErr
 	}
 
 	// update the task status to completed
 	taskErr := database.UpdateTaskStatus(taskId, blueprint.TaskStatusCompleted)
 	if taskErr != nil {
-		log.Printf("[queue][EnqueueTask] - error updating task status: %v", taskErr)
+		l.Error("[queue][EnqueueTask] - error updating task status", zap.Error(taskErr))
 		return taskErr
 	}
 
-	log.Printf("[queue][EnqueueTask] - successfully processed task: %v", taskId)
+	l.Info("[queue][EnqueueTask] - successfully processed task")
 	// NOTE: In the case of a "follow", instead of just exiting here, we reschedule the task to  like 2 mins later.
 	return nil
 }
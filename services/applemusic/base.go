@@ -7,17 +7,19 @@ import (
 	"log"
 	"net/http"
 	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/logger"
+	"orchdio/metrics"
 	"orchdio/util"
-	"os"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
 	applemusic "github.com/minchao/go-apple-music"
 	"github.com/samber/lo"
 	"github.com/vicanso/go-axios"
+	"go.uber.org/zap"
 )
 
 type Service struct {
@@ -27,9 +29,10 @@ type Service struct {
 	App               *blueprint.DeveloperApp
 	RedisClient       *redis.Client
 	PgClient          *sqlx.DB
+	Config            *config.Config
 }
 
-func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB, redisClient *redis.Client, devApp *blueprint.DeveloperApp) *Service {
+func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB, redisClient *redis.Client, devApp *blueprint.DeveloperApp, cfg *config.Config) *Service {
 	return &Service{
 		// this is the equivalent of the team id
 		IntegrationTeamID: credentials.AppID,
@@ -40,41 +43,43 @@ func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB
 		RedisClient:       redisClient,
 		PgClient:          pgClient,
 		App:               devApp,
+		Config:            cfg,
 	}
 }
 
 // SearchTrackWithID fetches a track from the ID using the link.
-func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	cacheKey := "applemusic:track:" + info.EntityID
-	_, err := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	_, err := s.RedisClient.Get(ctx, cacheKey).Result()
 	if err != nil && err != redis.Nil {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Error fetching track from cache: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithLink] Error fetching track from cache", zap.Error(err))
 		return nil, err
 	}
 
-	log.Printf("[services][applemusic][SearchTrackWithLink] Track not found in cache, fetching from Apple Music: %v\n", info.EntityID)
+	l.Info("[services][applemusic][SearchTrackWithLink] Track not found in cache, fetching from Apple Music", zap.String("entity_id", info.EntityID))
 
 	// just for dev, log if the credentials are empty
 	if s.IntegrationAPIKey != "" {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Apple music API key is empty on decoded credentials\n")
+		l.Info("[services][applemusic][SearchTrackWithLink] Apple music API key is empty on decoded credentials")
 	} else {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Apple music API key is not empty on decoded credentials\n")
+		l.Info("[services][applemusic][SearchTrackWithLink] Apple music API key is not empty on decoded credentials")
 	}
 
 	tp := applemusic.Transport{Token: s.IntegrationAPIKey}
 	client := applemusic.NewClient(tp.Client())
-	tracks, response, err := client.Catalog.GetSong(context.Background(), "us", info.EntityID, nil)
+	tracks, response, err := client.Catalog.GetSong(ctx, "us", info.EntityID, nil)
 	if err != nil {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Error fetching track from Apple Music: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithLink] Error fetching track from Apple Music", zap.Error(err))
 		return nil, err
 	}
 	if response.StatusCode != 200 {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Error fetching track from Apple Music: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithLink] Error fetching track from Apple Music", zap.Error(err))
 		return nil, err
 	}
 
 	if len(tracks.Data) == 0 {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Error fetching track from Apple Music: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithLink] Error fetching track from Apple Music", zap.Error(err))
 		return nil, blueprint.EnoResult
 	}
 
@@ -114,12 +119,12 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 
 	serializeTrack, err := json.Marshal(track)
 	if err != nil {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Error serializing track: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithLink] Error serializing track", zap.Error(err))
 		return nil, err
 	}
-	err = s.RedisClient.Set(context.Background(), cacheKey, serializeTrack, time.Hour*24).Err()
+	err = s.RedisClient.Set(ctx, cacheKey, serializeTrack, s.Config.Cache.TrackTTL).Err()
 	if err != nil {
-		log.Printf("[services][applemusic][SearchTrackWithLink] Error caching track: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithLink] Error caching track", zap.Error(err))
 		return nil, err
 	}
 	return track, nil
@@ -127,58 +132,61 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 }
 
 // SearchTrackWithTitle searches for a track using the query.
-func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	strippedTitleInfo := util.ExtractTitle(searchData.Title)
 	// if the title is in the format of "title (feat. artiste)" then we search for the title without the feat. artiste
-	log.Printf("Apple music: Searching with stripped artiste: %s. Original artiste: %s", strippedTitleInfo.Title, searchData.Artists)
-	if s.RedisClient.Exists(context.Background(), searchData.Artists[0]).Val() == 1 {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] Track found in cache: %v\n", searchData.Artists[0])
-		track, err := s.RedisClient.Get(context.Background(), util.NormalizeString(searchData.Artists[0])).Result()
+	l.Info("Apple music: Searching with stripped artiste", zap.String("title", strippedTitleInfo.Title), zap.Strings("artists", searchData.Artists))
+	cached := s.RedisClient.Exists(ctx, searchData.Artists[0]).Val() == 1
+	metrics.CacheLookup(IDENTIFIER, "title", cached)
+	if cached {
+		l.Info("[services][applemusic][SearchTrackWithTitle] Track found in cache", zap.String("artist", searchData.Artists[0]))
+		track, err := s.RedisClient.Get(ctx, util.NormalizeString(searchData.Artists[0])).Result()
 		if err != nil {
-			log.Printf("[services][applemusic][SearchTrackWithTitle] Error fetching track from cache: %v\n", err)
+			l.Error("[services][applemusic][SearchTrackWithTitle] Error fetching track from cache", zap.Error(err))
 			return nil, err
 		}
 		var result *blueprint.TrackSearchResult
 		err = json.Unmarshal([]byte(track), &result)
 		if err != nil {
-			log.Printf("[services][applemusic][SearchTrackWithTitle] Error unmarshalling track from cache: %v\n", err)
+			l.Error("[services][applemusic][SearchTrackWithTitle] Error unmarshalling track from cache", zap.Error(err))
 			return nil, err
 		}
 		return result, nil
 	}
 
-	log.Printf("[services][applemusic][SearchTrackWithTitle] Track not found in cache, fetching track %s from Apple Music with artist %s\n", strippedTitleInfo.Title, util.NormalizeString(searchData.Artists[0]))
+	l.Info("[services][applemusic][SearchTrackWithTitle] Track not found in cache, fetching track from Apple Music", zap.String("title", strippedTitleInfo.Title), zap.String("artist", util.NormalizeString(searchData.Artists[0])))
 
 	if s.IntegrationAPIKey != "" {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] Apple music API key is empty on decoded credentials\n")
+		l.Info("[services][applemusic][SearchTrackWithTitle] Apple music API key is empty on decoded credentials")
 	}
 
 	tp := applemusic.Transport{Token: s.IntegrationAPIKey}
 	client := applemusic.NewClient(tp.Client())
 
 	searchTerm := fmt.Sprintf("%s %s", searchData.Title, strings.Join(searchData.Artists, " "))
-	results, response, err := client.Catalog.Search(context.Background(), "us", &applemusic.SearchOptions{
+	results, response, err := client.Catalog.Search(ctx, "us", &applemusic.SearchOptions{
 		Term:  searchTerm,
 		Types: "songs",
 	})
 
 	if err != nil {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] Error fetching track from Apple Music: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithTitle] Error fetching track from Apple Music", zap.Error(err))
 		return nil, err
 	}
 
 	if response.StatusCode != 200 {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] Error fetching track from Apple Music: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithTitle] Error fetching track from Apple Music", zap.Error(err))
 		return nil, err
 	}
 
 	if results.Results.Songs == nil {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] No result found for track %s by %s. \n", strippedTitleInfo.Title, searchData.Artists)
+		l.Info("[services][applemusic][SearchTrackWithTitle] No result found for track", zap.String("title", strippedTitleInfo.Title), zap.Strings("artists", searchData.Artists))
 		return nil, blueprint.EnoResult
 	}
 
 	if len(results.Results.Songs.Data) == 0 {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] Error fetching track from Apple Music: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithTitle] Error fetching track from Apple Music", zap.Error(err))
 		return nil, blueprint.EnoResult
 	}
 
@@ -210,18 +218,18 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 	}
 	serializedTrack, err := json.Marshal(track)
 	if err != nil {
-		log.Printf("[services][applemusic][SearchTrackWithTitle] Error serializing track: %v\n", err)
+		l.Error("[services][applemusic][SearchTrackWithTitle] Error serializing track", zap.Error(err))
 		return nil, err
 	}
 
 	if lo.Contains(track.Artists, searchData.Artists[0]) {
-		err = s.RedisClient.MSet(context.Background(), map[string]interface{}{
+		err = s.RedisClient.MSet(ctx, map[string]interface{}{
 			util.NormalizeString(searchData.Artists[0]): string(serializedTrack),
 		}).Err()
 		if err != nil {
-			log.Printf("\n[controllers][platforms][deezer][SearchTrackWithTitle] error caching track - %v\n", err)
+			l.Error("[controllers][platforms][deezer][SearchTrackWithTitle] error caching track", zap.Error(err))
 		} else {
-			log.Printf("\n[controllers][platforms][applemusic][SearchTrackWithTitle] Track %s has been cached\n", track.Title)
+			l.Info("[controllers][platforms][applemusic][SearchTrackWithTitle] Track has been cached", zap.String("title", track.Title))
 		}
 	}
 
@@ -231,7 +239,7 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 // SearchTrackWithTitleChan searches for tracks using title and artistes but do so asynchronously.
 func (s *Service) SearchTrackWithTitleChan(searchData *blueprint.TrackSearchData, c chan *blueprint.TrackSearchResult, wg *sync.WaitGroup) {
 	// todo: pass the real value here when refactoring.
-	track, err := s.SearchTrackWithTitle(searchData, blueprint.UserAuthInfoForRequests{})
+	track, err := s.SearchTrackWithTitle(context.Background(), searchData, blueprint.UserAuthInfoForRequests{})
 	if err != nil {
 		log.Printf("[services][applemusic][SearchTrackWithTitleChan] Error fetching track: %v\n", err)
 		defer wg.Done()
@@ -288,14 +296,14 @@ func (s *Service) FetchTracks(tracks []blueprint.PlatformSearchTrack, red *redis
 	return &results, &omittedTracks, nil
 }
 
-func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error {
-	log.Println("Apple music not yet implemented...")
+func (s *Service) FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error {
+	logger.FromContext(ctx).Info("Apple music not yet implemented...")
 	return blueprint.ErrNotImplemented
 }
 
-func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+func (s *Service) FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
 
-	log.Print("Apple music support is disabled for now..")
+	logger.FromContext(ctx).Info("Apple music support is disabled for now..")
 	return nil, blueprint.EnoResult
 	// tp := applemusic.Transport{Token: s.IntegrationAPIKey}
 	// client := applemusic.NewClient(tp.Client())
@@ -332,7 +340,7 @@ func (s *Service) SearchPlaylistWithTracks(p *blueprint.PlaylistSearchResult) (*
 func (s *Service) CreateNewPlaylist(title, description, musicToken string, tracks []string) ([]byte, error) {
 	log.Printf("[services][applemusic][CreateNewPlaylist] Creating new playlist: %v\n", title)
 	log.Printf("App Applemusic token is: %v\n", musicToken)
-	tp := applemusic.Transport{Token: os.Getenv("APPLE_MUSIC_API_KEY"), MusicUserToken: musicToken}
+	tp := applemusic.Transport{Token: s.Config.Platforms.AppleMusicAPIKey, MusicUserToken: musicToken}
 
 	defer func() {
 		if r := recover(); r != nil {
@@ -0,0 +1,180 @@
+// Package audit records the changes made to the organizations and their apps in the append-only audit log: who made
+// the change, from where, and the fields that changed, with the secrets redacted.
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/logger"
+	"reflect"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/jmoiron/sqlx/types"
+)
+
+// Redacted is recorded in place of the value of the secret fields.
+const Redacted = "[REDACTED]"
+
+// secretFieldParts are the parts of the names of the fields whose values are never recorded, e.g. client_secret or
+// spotify_credentials. "key" is the secret key of a new key.
+var secretFieldParts = []string{"secret", "token", "password", "credentials", "state"}
+
+// Entry is a change to record. Before and After are the target before and after the change (nil for creations and
+// deletions), as anything that marshals to a JSON object; only the fields that changed are recorded. Actor is the
+// user of the JWT of the request when it is not set.
+type Entry struct {
+	Org        string
+	Actor      string
+	Action     string
+	TargetType string
+	TargetID   string
+	Before     interface{}
+	After      interface{}
+}
+
+// Record appends the change to the audit log, with the IP and the request id of the request. Failing to record it is
+// logged and does not fail the request, the change has already been made.
+func Record(ctx *fiber.Ctx, dbase *sqlx.DB, entry *Entry) {
+	actor := entry.Actor
+	if actor == "" {
+		if claims, ok := ctx.Locals("app_jwt").(*blueprint.AppJWT); ok && claims != nil {
+			actor = claims.DeveloperID
+		}
+	}
+	orgId, err := uuid.Parse(entry.Org)
+	if err != nil {
+		log.Printf("[services][audit][Record] error - invalid org %q for %s of %s %s\n", entry.Org, entry.Action, entry.TargetType, entry.TargetID)
+		return
+	}
+	before, after, err := Diff(entry.Before, entry.After)
+	if err != nil {
+		log.Printf("[services][audit][Record] error - could not diff %s of %s %s: %v\n", entry.Action, entry.TargetType, entry.TargetID, err)
+		return
+	}
+
+	logEntry := &blueprint.AuditLogEntry{
+		UID:        uuid.New(),
+		Org:        orgId,
+		Action:     entry.Action,
+		TargetType: entry.TargetType,
+		TargetID:   entry.TargetID,
+		Before:     before,
+		After:      after,
+		IP:         ctx.IP(),
+		RequestID:  logger.RequestID(ctx),
+	}
+	if actorId, pErr := uuid.Parse(actor); pErr == nil {
+		logEntry.Actor = &actorId
+	}
+
+	database := db.NewDB{DB: dbase}
+	if err = database.CreateAuditLogEntry(logEntry); err != nil {
+		log.Printf("[services][audit][Record] error - could not record %s of %s %s by %s: %v\n", entry.Action, entry.TargetType, entry.TargetID, actor, err)
+	}
+}
+
+// Diff returns the fields of before and after that are different, as JSON objects, with the values of the secret
+// fields redacted. When before or after is nil (a creation or a deletion), all the fields of the other are returned.
+func Diff(before, after interface{}) (types.JSONText, types.JSONText, error) {
+	beforeFields, err := fields(before)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterFields, err := fields(after)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if beforeFields != nil && afterFields != nil {
+		// the update time changes with every change, it is already the time of the entry.
+		delete(beforeFields, "updated_at")
+		delete(afterFields, "updated_at")
+		for key, value := range beforeFields {
+			if other, ok := afterFields[key]; ok && reflect.DeepEqual(value, other) {
+				delete(beforeFields, key)
+				delete(afterFields, key)
+			}
+		}
+	}
+
+	beforeJSON, err := redactedJSON(beforeFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	afterJSON, err := redactedJSON(afterFields)
+	if err != nil {
+		return nil, nil, err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+// IsSecretField returns true if the value of the field must not be recorded.
+func IsSecretField(name string) bool {
+	name = strings.ToLower(name)
+	if name == "key" || (strings.HasSuffix(name, "_key") && name != "public_key") {
+		return true
+	}
+	for _, part := range secretFieldParts {
+		if strings.Contains(name, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// fields returns the fields of the value, as it marshals to JSON. It returns nil for a nil value.
+func fields(v interface{}) (map[string]interface{}, error) {
+	if v == nil || (reflect.ValueOf(v).Kind() == reflect.Pointer && reflect.ValueOf(v).IsNil()) {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var res map[string]interface{}
+	if err = json.Unmarshal(raw, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// redactedJSON marshals the fields with the values of the secret fields redacted, including in nested objects. It
+// returns nil for nil fields.
+func redactedJSON(fields map[string]interface{}) (types.JSONText, error) {
+	if fields == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(redact(fields))
+	if err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+func redact(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if IsSecretField(key) && field != nil && field != "" {
+				res[key] = Redacted
+				continue
+			}
+			res[key] = redact(field)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = redact(item)
+		}
+		return res
+	default:
+		return v
+	}
+}
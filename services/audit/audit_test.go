@@ -0,0 +1,32 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+	before := map[string]interface{}{"name": "app", "description": "old", "spotify_credentials": "abc", "public_key": "pk"}
+	after := map[string]interface{}{"name": "app", "description": "new", "spotify_credentials": "def", "public_key": "pk"}
+
+	b, a, err := Diff(before, after)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"description": "old", "spotify_credentials": "[REDACTED]"}`, string(b))
+	assert.JSONEq(t, `{"description": "new", "spotify_credentials": "[REDACTED]"}`, string(a))
+
+	// creations have no before, and every field of the target is recorded.
+	b, a, err = Diff(nil, map[string]interface{}{"name": "ci", "key": "orch_sk_live_123", "nested": map[string]interface{}{"verify_secret": "s"}})
+	assert.NoError(t, err)
+	assert.Nil(t, b)
+	assert.JSONEq(t, `{"name": "ci", "key": "[REDACTED]", "nested": {"verify_secret": "[REDACTED]"}}`, string(a))
+}
+
+func TestIsSecretField(t *testing.T) {
+	for _, name := range []string{"secret_key", "verify_token", "password", "deezer_credentials", "deezer_state", "key", "api_key"} {
+		assert.True(t, IsSecretField(name), name)
+	}
+	for _, name := range []string{"public_key", "name", "hint", "scopes", "role"} {
+		assert.False(t, IsSecretField(name), name)
+	}
+}
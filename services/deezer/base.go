@@ -9,17 +9,19 @@ import (
 	"net/http"
 	"net/url"
 	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/logger"
+	"orchdio/metrics"
 	"orchdio/util"
-	"os"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/jmoiron/sqlx"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/samber/lo"
 	"github.com/vicanso/go-axios"
+	"go.uber.org/zap"
 )
 
 type Service struct {
@@ -28,6 +30,7 @@ type Service struct {
 	RedisClient       *redis.Client
 	App               *blueprint.DeveloperApp
 	WebhookSender     WebhookSender
+	Config            *config.Config
 }
 
 type WebhookSender interface {
@@ -35,19 +38,20 @@ type WebhookSender interface {
 }
 
 // NewService creates a new deezer service
-func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB, redisClient *redis.Client, devApp *blueprint.DeveloperApp, webhookSender WebhookSender) *Service {
+func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB, redisClient *redis.Client, devApp *blueprint.DeveloperApp, webhookSender WebhookSender, cfg *config.Config) *Service {
 	return &Service{
 		IntegrationID:     credentials.AppID,
 		IntegrationSecret: credentials.AppSecret,
 		RedisClient:       redisClient,
 		App:               devApp,
 		WebhookSender:     webhookSender,
+		Config:            cfg,
 	}
 }
 
 // fetchSingleTrack fetches a single deezer track from the URL
-func (s *Service) fetchSingleTrack(link string) (*Track, error) {
-	response, err := axios.Get(link)
+func (s *Service) fetchSingleTrack(ctx context.Context, link string) (*Track, error) {
+	response, err := axios.Request(&axios.Config{URL: link, Method: http.MethodGet, Context: ctx})
 	if err != nil {
 		log.Printf("\n[services][deezer][playlist][SearchTrackWithID] error - Could not fetch single track from deezer %v\n", err)
 		return nil, err
@@ -63,29 +67,32 @@ func (s *Service) fetchSingleTrack(link string) (*Track, error) {
 }
 
 // SearchTrackWithID fetches the deezer result for the track being searched using the URL
-func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	// first, get the cached track
 	cacheKey := util.FormatPlaylistTrackByCacheKeyID(IDENTIFIER, info.EntityID)
 
-	log.Printf("\n[services][deezer][SearchTrackWithID] cachedKey %v\n", cacheKey)
-	if s.RedisClient.Exists(context.Background(), cacheKey).Val() == 1 {
-		log.Printf("[services][deezer][SearchTrackWithID] found cached value %v\n", cacheKey)
-		cachedTrack, err := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	l.Info("[services][deezer][SearchTrackWithID] cachedKey", zap.String("key", cacheKey))
+	cached := s.RedisClient.Exists(ctx, cacheKey).Val() == 1
+	metrics.CacheLookup(IDENTIFIER, "id", cached)
+	if cached {
+		l.Info("[services][deezer][SearchTrackWithID] found cached value", zap.String("key", cacheKey))
+		cachedTrack, err := s.RedisClient.Get(ctx, cacheKey).Result()
 		if err != nil && !errors.Is(err, redis.Nil) {
-			log.Printf("[services][deezer][SearchTrackWithID] Error getting cached value %v\n", err)
+			l.Error("[services][deezer][SearchTrackWithID] Error getting cached value", zap.Error(err))
 			return nil, err
 		}
 
 		var deserializedTrack *blueprint.TrackSearchResult
 		err = json.Unmarshal([]byte(cachedTrack), &deserializedTrack)
 		if err != nil {
-			log.Printf("[services][deezer][SearchTrackWithID] Error unmarshalling cached value %v\n", err)
+			l.Error("[services][deezer][SearchTrackWithID] Error unmarshalling cached value", zap.Error(err))
 			return nil, err
 		}
 		return deserializedTrack, nil
 	}
 
-	dzSingleTrack, err := s.fetchSingleTrack(info.TargetLink)
+	dzSingleTrack, err := s.fetchSingleTrack(ctx, info.TargetLink)
 	var dzTrackContributors []string
 	for _, contributor := range dzSingleTrack.Contributors {
 		if contributor.Type == "artist" {
@@ -110,34 +117,37 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 	// serialize the result
 	serializedTrack, err := json.Marshal(fetchedDeezerTrack)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][deezer][ConvertPlaylist] error serializing track - %v\n", err)
+		l.Error("[controllers][platforms][deezer][ConvertPlaylist] error serializing track", zap.Error(err))
 	}
 
 	// cache the result
-	_ = s.RedisClient.Set(context.Background(), cacheKey, string(serializedTrack), time.Hour*24).Err()
-	log.Printf("\n[platforms][base][SearchTrackWithID] Track %s has been cached\n", dzSingleTrack.Title)
+	_ = s.RedisClient.Set(ctx, cacheKey, string(serializedTrack), s.Config.Cache.TrackTTL).Err()
+	l.Info("[platforms][base][SearchTrackWithID] Track has been cached", zap.String("title", dzSingleTrack.Title))
 	return &fetchedDeezerTrack, nil
 }
 
 // SearchTrackWithTitle searches for a track using the title (and artiste) on deezer
 // This is typically expected to be used when the track we want to fetch is the one we just
 // want to search on. That is, the other platforms that the user is trying to convert to.
-func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	cacheKey := util.FormatTargetPlaylistTrackByCacheKeyTitle(IDENTIFIER, util.NormalizeString(searchData.Artists[0]), searchData.Title)
 
 	// get the cached track if track with title and belongs to artist has been searched before
 	// we send webhook event for playlist track and return cached result.
-	if s.RedisClient.Exists(context.Background(), cacheKey).Val() == 1 {
-		cachedTrack, err := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	cached := s.RedisClient.Exists(ctx, cacheKey).Val() == 1
+	metrics.CacheLookup(IDENTIFIER, "title", cached)
+	if cached {
+		cachedTrack, err := s.RedisClient.Get(ctx, cacheKey).Result()
 		if err != nil && !errors.Is(err, redis.Nil) {
-			log.Printf("[platforms][deezer][searchTrackWithID] Error getting cached track %v\n", err)
+			l.Error("[platforms][deezer][searchTrackWithID] Error getting cached track", zap.Error(err))
 			return nil, err
 		}
 
 		var deserializedTrack *blueprint.TrackSearchResult
 		err = json.Unmarshal([]byte(cachedTrack), &deserializedTrack)
 		if err != nil {
-			log.Printf("[platforms][base][SearchTrackWithID] Error deserializing cached result %v\n", err)
+			l.Error("[platforms][base][SearchTrackWithID] Error deserializing cached result", zap.Error(err))
 			return nil, err
 		}
 
@@ -151,18 +161,18 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 	// deezer has second to the lowest accuracy in terms of search results (youtube being the lowest)
 	// however, just like others, we're caching the result under the normalized string, which contains trimmed artiste name
 	// like so: "deezer-artistename-title". For example: "deezer-flatbushzombies-reelgirls
-	link := fmt.Sprintf("%s/search?q=%s", os.Getenv("DEEZER_API_BASE"), url.QueryEscape(fmt.Sprintf("track:\"%s\" artist:\"%s\"", strings.Trim(searchTitle, " "), searchData.Artists[0])))
+	link := fmt.Sprintf("%s/search?q=%s", s.Config.Platforms.DeezerAPIBase, url.QueryEscape(fmt.Sprintf("track:\"%s\" artist:\"%s\"", strings.Trim(searchTitle, " "), searchData.Artists[0])))
 
-	response, err := axios.Get(link)
+	response, err := axios.Request(&axios.Config{URL: link, Method: http.MethodGet, Context: ctx})
 	if err != nil {
-		log.Printf("\n[services][deezer][base][SearchTrackWithTitle] error - Could not search the track on deezer: %v\n", err)
+		l.Error("[services][deezer][base][SearchTrackWithTitle] error - Could not search the track on deezer", zap.Error(err))
 		return nil, err
 	}
 	fullTrack := FullTrack{}
 	err = json.Unmarshal(response.Data, &fullTrack)
 	if err != nil {
 		// todo: handle Forbidden/rate limit errors.
-		log.Printf("\n[services][deezer][base][SearchTrackWithTitle] error - Could not deserialize the body into the out response, Error: \n%v, Body is: %v\n", err, string(response.Data))
+		l.Error("[services][deezer][base][SearchTrackWithTitle] error - Could not deserialize the body into the out response", zap.Error(err), zap.ByteString("body", response.Data))
 		return nil, err
 	}
 
@@ -191,27 +201,28 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 		return &out, nil
 	}
 
-	log.Printf("\n[services][deezer][base][SearchTrackWithTitle] Deezer search for track done but no results. Searched with %s \n", link)
+	l.Info("[services][deezer][base][SearchTrackWithTitle] Deezer search for track done but no results. Searched with", zap.String("link", link))
 	return nil, blueprint.EnoResult
 }
 
 // FetchTracksForSourcePlatform fetches tracks for a given source platform and sends them to the result channel.
-func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, resultChan chan blueprint.TrackSearchResult) error {
-	cachedSnapshot, cacheErr := s.RedisClient.Get(context.Background(), util.FormatPlatformConversionCacheKey(info.EntityID, IDENTIFIER)).Result()
+func (s *Service) FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, resultChan chan blueprint.TrackSearchResult) error {
+	l := logger.FromContext(ctx)
+	cachedSnapshot, cacheErr := s.RedisClient.Get(ctx, util.FormatPlatformConversionCacheKey(info.EntityID, IDENTIFIER)).Result()
 	if cacheErr != nil && !errors.Is(cacheErr, redis.Nil) {
-		log.Printf("\n[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot for playlist %v\n", info.EntityID)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot for playlist", zap.String("entity_id", info.EntityID))
 		return cacheErr
 	}
 
-	cachedSnapshotID, idErr := s.RedisClient.Get(context.Background(), util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
+	cachedSnapshotID, idErr := s.RedisClient.Get(ctx, util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
 	if idErr != nil && !errors.Is(idErr, redis.Nil) {
-		log.Printf("\n[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot id for playlist %v\n", info.EntityID)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot id for playlist", zap.String("entity_id", info.EntityID))
 		return idErr
 	}
 
-	tracks, gErr := axios.Get("https://api.deezer.com/playlist/" + info.EntityID)
+	tracks, gErr := axios.Request(&axios.Config{URL: "https://api.deezer.com/playlist/" + info.EntityID, Method: http.MethodGet, Context: ctx})
 	if gErr != nil {
-		log.Printf("[services][deezer][SearchPlaylistWithID] error - Could not fetch playlist info — Axio error: %v\n", gErr)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not fetch playlist info — Axio error", zap.Error(gErr))
 		return gErr
 	}
 
@@ -222,7 +233,7 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 			var trackList PlaylistTracksSearch
 			err := json.Unmarshal(tracks.Data, &trackList)
 			if err != nil {
-				log.Println("Error deserializing result of playlist tracks search")
+				l.Error("Error deserializing result of playlist tracks search")
 				return err
 			}
 
@@ -253,7 +264,7 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 	playlistResult := &blueprint.PlaylistSearchResult{}
 	err := json.Unmarshal([]byte(cachedSnapshot), playlistResult)
 	if err != nil {
-		log.Printf("\n[services][deezer][SearchPlaylistWithID] error - Could not deserialize the body into the out response: %v\n", err)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not deserialize the body into the out response", zap.Error(err))
 		return err
 	}
 
@@ -263,32 +274,33 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 	return nil
 }
 
-func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
-	log.Printf("\n[services][deezer][SearchPlaylistWithID] Fetching playlist %v\n", info.EntityID)
+func (s *Service) FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+	l := logger.FromContext(ctx)
+	l.Info("[services][deezer][SearchPlaylistWithID] Fetching playlist", zap.String("entity_id", info.EntityID))
 	// todo: implement fetching more pages. test if this covers cases with more than 100, 250, 500, 1000 tracks.
 	infoLink := "https://api.deezer.com/playlist/" + info.EntityID + "?limit=1"
 	var playlistInfo PlaylistTracksSearch
-	err := s.MakeRequest(infoLink, &playlistInfo)
+	err := s.request(ctx, infoLink, &playlistInfo)
 	if err != nil {
-		log.Printf("\n[services][deezer][SearchPlaylistWithID] error - Could not fetch playlist info: %v\n", err)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not fetch playlist info", zap.Error(err))
 		return nil, err
 	}
 
-	_, gErr := axios.Get("https://api.deezer.com/playlist/" + info.EntityID)
+	_, gErr := axios.Request(&axios.Config{URL: "https://api.deezer.com/playlist/" + info.EntityID, Method: http.MethodGet, Context: ctx})
 	if gErr != nil {
-		log.Printf("[services][deezer][SearchPlaylistWithID] error - Could not fetch playlist info — Axio error: %v\n", err)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not fetch playlist info — Axio error", zap.Error(err))
 		return nil, gErr
 	}
 
-	_, cacheErr := s.RedisClient.Get(context.Background(), util.FormatPlatformConversionCacheKey(info.EntityID, IDENTIFIER)).Result()
+	_, cacheErr := s.RedisClient.Get(ctx, util.FormatPlatformConversionCacheKey(info.EntityID, IDENTIFIER)).Result()
 	if cacheErr != nil && !errors.Is(cacheErr, redis.Nil) {
-		log.Printf("\n[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot for playlist %v\n", info.EntityID)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot for playlist", zap.String("entity_id", info.EntityID))
 		return nil, cacheErr
 	}
 
-	_, idErr := s.RedisClient.Get(context.Background(), util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
+	_, idErr := s.RedisClient.Get(ctx, util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
 	if idErr != nil && !errors.Is(idErr, redis.Nil) {
-		log.Printf("\n[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot id for playlist %v\n", info.EntityID)
+		l.Error("[services][deezer][SearchPlaylistWithID] error - Could not get cached snapshot id for playlist", zap.String("entity_id", info.EntityID))
 		return nil, idErr
 	}
 
@@ -314,7 +326,7 @@ func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.Pl
 
 // CreateNewPlaylist creates a new playlist for a user on their deezer account
 func (s *Service) CreateNewPlaylist(title, userDeezerId, token string, tracks []string) ([]byte, error) {
-	deezerAPIBase := os.Getenv("DEEZER_API_BASE")
+	deezerAPIBase := s.Config.Platforms.DeezerAPIBase
 	reqURL := fmt.Sprintf("%s/user/%s/playlists?access_token=%s&request_method=post", deezerAPIBase, userDeezerId, token)
 	p := url.Values{}
 	p.Add("title", title)
@@ -331,6 +343,11 @@ func (s *Service) CreateNewPlaylist(title, userDeezerId, token string, tracks []
 		return nil, errors.New("bad request")
 	}
 
+	if authErr := authError(resp.Data); authErr != nil {
+		log.Printf("\n[services][deezer][CreateNewPlaylist] error - access token rejected: %v\n", authErr)
+		return nil, authErr
+	}
+
 	log.Printf("\n[services][deezer][CreateNewPlaylist] response: %v\n", string(resp.Data))
 
 	err = json.Unmarshal(resp.Data, out)
@@ -388,7 +405,7 @@ func (s *Service) CreateNewPlaylist(title, userDeezerId, token string, tracks []
 func (s *Service) FetchUserArtists(token string) (*blueprint.UserLibraryArtists, error) {
 	// DEEZER ARTIST LIMIT IS 250 FOR NOW. THIS IS ORCHDIO IMPOSED AND IT IS to make implementation easier
 	// plus not as much deezer users and even so, we could make it premium in the future
-	deezerApiBase := os.Getenv("DEEZER_API_BASE")
+	deezerApiBase := s.Config.Platforms.DeezerAPIBase
 	reqURL := fmt.Sprintf("%s/user/me/artists?access_token=%s", deezerApiBase, token)
 	var artistsResponse UserArtistsResponse
 	err := s.MakeRequest(reqURL, &artistsResponse)
@@ -415,15 +432,42 @@ func (s *Service) FetchUserArtists(token string) (*blueprint.UserLibraryArtists,
 	return &response, nil
 }
 
+// invalidTokenErrorCode is the code of the OAuthException deezer returns (with a 200 status) for access tokens that are
+// not valid anymore, e.g. because the user removed the app from their account.
+const invalidTokenErrorCode = 300
+
+// authError returns blueprint.ErrUserAuthRevoked if the body of a deezer response is an invalid access token error.
+func authError(body []byte) error {
+	var res struct {
+		Error *struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+			Code    int    `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &res); err != nil || res.Error == nil {
+		return nil
+	}
+	if res.Error.Type == "OAuthException" && res.Error.Code == invalidTokenErrorCode {
+		return fmt.Errorf("%w: %s", blueprint.ErrUserAuthRevoked, res.Error.Message)
+	}
+	return nil
+}
+
 func (s *Service) MakeRequest(url string, result interface{}) error {
-	deezerApiBase := os.Getenv("DEEZER_API_BASE")
+	return s.request(context.Background(), url, result)
+}
+
+// request makes a GET request to the deezer API in ctx and deserializes the response into result.
+func (s *Service) request(ctx context.Context, url string, result interface{}) error {
+	deezerApiBase := s.Config.Platforms.DeezerAPIBase
 	instance := axios.NewInstance(&axios.InstanceConfig{
 		BaseURL: deezerApiBase,
 		Headers: map[string][]string{
 			"Content-Type": {"application/json"},
 		},
 	})
-	resp, err := instance.Get(url, nil)
+	resp, err := instance.GetX(ctx, url, nil)
 	if err != nil {
 		log.Printf("\n[services][deezer][MakeRequest] error - Could not fetch result: %v\n", err)
 		return err
@@ -434,6 +478,10 @@ func (s *Service) MakeRequest(url string, result interface{}) error {
 	if resp.Status == 200 && containsFreeErr {
 		return errors.New(blueprint.ErrFreeServiceClosed)
 	}
+	if authErr := authError(resp.Data); authErr != nil {
+		log.Printf("\n[services][deezer][MakeRequest] error - access token rejected: %v\n", authErr)
+		return authErr
+	}
 	if resp.Status >= 201 {
 		log.Printf("\n[services][deezer][MakeRequest] error - Could not fetch result. Bad request: %v\n", err)
 		return err
@@ -0,0 +1,40 @@
+package deezer
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthError(t *testing.T) {
+	err := authError([]byte(`{"error":{"type":"OAuthException","message":"Invalid OAuth access token.","code":300}}`))
+	assert.ErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+
+	// missing permissions are not a revoked authorization.
+	assert.NoError(t, authError([]byte(`{"error":{"type":"OAuthException","message":"You must enter a valid permission.","code":200}}`)))
+	assert.NoError(t, authError([]byte(`{"error":{"type":"DataException","message":"no data","code":800}}`)))
+	assert.NoError(t, authError([]byte(`{"data":[],"total":0}`)))
+}
+
+func TestRequestUsesContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"id": 1, "title": "Playlist"}`))
+	}))
+	defer server.Close()
+	s := &Service{Config: &config.Config{Platforms: config.Platforms{DeezerAPIBase: server.URL}}}
+
+	var out PlaylistTracksSearch
+	require.NoError(t, s.request(context.Background(), "/playlist/1", &out))
+	assert.Equal(t, "Playlist", out.Title)
+
+	// the requests are made in the context of the conversion, and stop with it.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, s.request(ctx, "/playlist/1", &out), context.Canceled)
+}
@@ -5,13 +5,12 @@ import (
 	"log"
 	"orchdio/blueprint"
 	"orchdio/util"
-	"os"
 	"strconv"
 )
 
 // FetchUserPlaylists fetches all the playlists for a user
 func (s *Service) FetchLibraryPlaylists(token string) ([]blueprint.UserPlaylist, error) {
-	deezerAPIBase := os.Getenv("DEEZER_API_BASE")
+	deezerAPIBase := s.Config.Platforms.DeezerAPIBase
 	// DEEZER PLAYLIST LIMIT IS 250 FOR NOW. THIS IS ORCHDIO IMPOSED AND IT IS
 	// 1. TO EASE IMPLEMENTATION
 	// 2. TO MAKE IT "PREMIUM" IN THE FUTURE  (i.e. if we want to charge for more playlists), makes it easier to enforce/assimilate from now
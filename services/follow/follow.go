@@ -7,13 +7,16 @@ import (
 	"fmt"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
 	"orchdio/db/queries"
+	"orchdio/internal/encryption"
 	"orchdio/services"
 	"orchdio/services/deezer"
 	"orchdio/services/spotify"
 	"orchdio/services/tidal"
 	"orchdio/universal"
+	svixwebhook "orchdio/webhooks/svix"
 	"time"
 
 	"github.com/go-redis/redis/v8"
@@ -24,15 +27,17 @@ import (
 )
 
 type Follow struct {
-	DB  *sqlx.DB
-	Red *redis.Client
+	DB     *sqlx.DB
+	Red    *redis.Client
+	Config *config.Config
 }
 
 // NewFollow returns a new follow struct.
-func NewFollow(db *sqlx.DB, red *redis.Client) *Follow {
+func NewFollow(db *sqlx.DB, red *redis.Client, cfg *config.Config) *Follow {
 	return &Follow{
-		DB:  db,
-		Red: red,
+		DB:     db,
+		Red:    red,
+		Config: cfg,
 	}
 }
 
@@ -41,8 +46,8 @@ func NewFollow(db *sqlx.DB, red *redis.Client) *Follow {
 // then we do nothing. If it doesn't exist, then we create a new follow and add the subscriber.
 func (f *Follow) FollowPlaylist(developer, app, originalURL string, info *blueprint.LinkInfo, subscribers []string) ([]byte, error) {
 	log.Printf("[follow][FollowPlaylist] - Running follow playlist")
-	if len(subscribers) > 20 {
-		log.Printf("[follow][FollowPlaylist] - too many subscribers. Max is 20")
+	if limit := f.Config.Follow.SubscriberLimit; len(subscribers) > limit {
+		log.Printf("[follow][FollowPlaylist] - too many subscribers. Max is %d", limit)
 		return nil, blueprint.ErrTooMany
 	}
 	// this function takes the playlist id and the user id and checks if  the user has
@@ -103,14 +108,21 @@ func (f *Follow) FollowPlaylist(developer, app, originalURL string, info *bluepr
 }
 
 type TaskCronHandler struct {
-	DB  *sqlx.DB
-	Red *redis.Client
+	DB            *sqlx.DB
+	Red           *redis.Client
+	Config        *config.Config
+	WebhookSender svixwebhook.SvixInterface
+	// Envelope decrypts the integration credentials of the apps whose playlists are converted.
+	Envelope *encryption.Envelope
 }
 
-func NewTaskCronHandler(db *sqlx.DB, red *redis.Client) *TaskCronHandler {
+func NewTaskCronHandler(db *sqlx.DB, red *redis.Client, cfg *config.Config, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope) *TaskCronHandler {
 	return &TaskCronHandler{
-		DB:  db,
-		Red: red,
+		DB:            db,
+		Red:           red,
+		Config:        cfg,
+		WebhookSender: webhookSender,
+		Envelope:      envelope,
 	}
 }
 
@@ -153,13 +165,13 @@ func (s *TaskCronHandler) ProcessFollowTaskHandler(ctx context.Context, task *as
 	}
 
 	// fetch the link info from the url passed in the task payload
-	linkInfo, err := services.ExtractLinkInfo(data.Url)
+	linkInfo, err := services.ExtractLinkInfo(data.Url, s.Config.Platforms)
 	if err != nil {
 		log.Printf("[queue][ProcessFollowTaskHandler][conversion] - error extracting link info: %v", err)
 		return err
 	}
 
-	followService := services.NewFollowTask(s.DB, s.Red)
+	followService := services.NewFollowTask(s.DB, s.Red, s.Config, s.WebhookSender)
 	// NOTE: for tidal, the update hash is a timestamp (in string format) for tidal
 	updatedHash, ok, _, err := followService.HasPlaylistBeenUpdated(linkInfo.Platform, linkInfo.Entity, linkInfo.EntityID, data.App)
 
@@ -169,7 +181,7 @@ func (s *TaskCronHandler) ProcessFollowTaskHandler(ctx context.Context, task *as
 		if err == redis.Nil {
 			log.Printf("[queue][ProcessFollowTaskHandler] - playlist hasnt been cached")
 			// todo: watch out for this
-			convertedPlaylist, err := universal.ConvertPlaylist(linkInfo, s.Red, s.DB)
+			convertedPlaylist, err := universal.ConvertPlaylist(ctx, linkInfo, s.Red, s.DB, s.WebhookSender, s.Envelope, s.Config)
 			if err != nil {
 				log.Printf("[queue][ProcessFollowTaskHandler][conversion] - error converting playlist: %v", err)
 				return err
@@ -200,7 +212,7 @@ func (s *TaskCronHandler) ProcessFollowTaskHandler(ctx context.Context, task *as
 	if ok {
 		log.Println("[queue][ProcessFollowTaskHandler] - playlist has been updated. Converting again to fetch new tracks")
 		// todo: watch out for this
-		updatedPlaylist, err := universal.ConvertPlaylist(linkInfo, s.Red, s.DB)
+		updatedPlaylist, err := universal.ConvertPlaylist(ctx, linkInfo, s.Red, s.DB, s.WebhookSender, s.Envelope, s.Config)
 		if err != nil {
 			log.Printf("[queue][ProcessFollowTaskHandler][conversion] - error converting playlist: %v", err)
 			return err
@@ -231,6 +243,7 @@ func (s *TaskCronHandler) ProcessFollowTaskHandler(ctx context.Context, task *as
 			var subscriberData = map[string]interface{}{
 				"subscriber":      subscriber.UUID.String(),
 				"notification_id": uniqueID.String(),
+				"app":             data.App,
 				"data":            string(updatedPlaylistByte),
 			}
 
@@ -273,8 +286,9 @@ func (s *TaskCronHandler) ProcessFollowTaskHandler(ctx context.Context, task *as
 	return nil
 }
 
-// SyncFollowsHandler fetches follow tasks that can be processed and processes them. This is called from a cron job. (in main)
-func SyncFollowsHandler(DB *sqlx.DB, red *redis.Client, asynqClient *asynq.Client, asynqMux *asynq.ServeMux) {
+// SyncFollowsHandler fetches follow tasks that can be processed and enqueues them. This is called from a cron job (see
+// wiring.NewScheduler), and the tasks are processed by ProcessFollowTaskHandler on the worker.
+func SyncFollowsHandler(DB *sqlx.DB, asynqClient *asynq.Client, cfg *config.Config) {
 	database := db.NewDB{DB: DB}
 	follows, err := database.FetchFollowsToProcess()
 	if err != nil {
@@ -290,7 +304,7 @@ func SyncFollowsHandler(DB *sqlx.DB, red *redis.Client, asynqClient *asynq.Clien
 	// type of same task
 	for _, follow := range *follows {
 		log.Printf("[follow][SyncFollowsHandler] - Entity URL with link to be extracted: %v", follow.EntityID)
-		extractLinkInfo, err := services.ExtractLinkInfo(follow.EntityURL)
+		extractLinkInfo, err := services.ExtractLinkInfo(follow.EntityURL, cfg.Platforms)
 		if err != nil {
 			log.Printf("[follow][SyncFollowsHandler] - error extracting link info: %v", err)
 			err := database.UpdateFollowStatus(follow.UID.String(), "failed")
@@ -319,20 +333,31 @@ func SyncFollowsHandler(DB *sqlx.DB, red *redis.Client, asynqClient *asynq.Clien
 
 		// make the task "unique" for the next 1hr. this is to make sure that whenever the cronjob runs
 		// we dont have multiple tasks of the same type
-		followTask := asynq.NewTask(taskTypeID.String(), followTaskDataBytes, asynq.Retention(time.Hour))
+		followTask := asynq.NewTask(blueprint.FollowTaskTypePattern+taskTypeID.String(), followTaskDataBytes, asynq.Retention(time.Hour))
 		// enqueue the task
 		_, err = asynqClient.Enqueue(followTask)
 		if err != nil {
 			log.Printf("[follow][SyncFollowsHandler] - error enqueuing follow task: %v", err)
 			return
 		}
-		sync := NewTaskCronHandler(DB, red)
-		asynqMux.HandleFunc(taskTypeID.String(), sync.ProcessFollowTaskHandler)
 	}
 	log.Printf("[follow][SyncFollowsHandler] - fetched %d follow tasks to process", len(*follows))
 	return
 }
 
+// CleanupNotificationsHandler deletes notifications that are past their retention. Read notifications are kept for
+// readRetention and unread notifications for unreadRetention. This is called from a cron job (see wiring.NewScheduler).
+func CleanupNotificationsHandler(DB *sqlx.DB, readRetention, unreadRetention time.Duration) {
+	database := db.NewDB{DB: DB}
+	now := time.Now()
+	count, err := database.DeleteStaleNotifications(now.Add(-readRetention), now.Add(-unreadRetention))
+	if err != nil {
+		log.Printf("[follow][CleanupNotificationsHandler] - error cleaning up notifications: %v", err)
+		return
+	}
+	log.Printf("[follow][CleanupNotificationsHandler] - deleted %d stale notifications", count)
+}
+
 //var ConfigDefault = fiber.Config{
 //	ErrorHandler: func(ctx *fiber.Ctx, err error) error {
 //		return nil
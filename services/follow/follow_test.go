@@ -0,0 +1,39 @@
+package follow
+
+import (
+	"database/sql/driver"
+	"orchdio/db/queries"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/require"
+)
+
+// cutoff matches a time argument retention ago, give or take a minute.
+type cutoff time.Duration
+
+func (c cutoff) Match(v driver.Value) bool {
+	at, ok := v.(time.Time)
+	if !ok {
+		return false
+	}
+	want := time.Now().Add(-time.Duration(c))
+	return at.After(want.Add(-time.Minute)) && at.Before(want.Add(time.Minute))
+}
+
+func TestCleanupNotificationsHandler(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	readRetention, unreadRetention := 30*24*time.Hour, 90*24*time.Hour
+	mock.ExpectExec(regexp.QuoteMeta(queries.DeleteStaleNotifications)).
+		WithArgs(cutoff(readRetention), cutoff(unreadRetention)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	CleanupNotificationsHandler(sqlx.NewDb(conn, "postgres"), readRetention, unreadRetention)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,295 @@
+// Package ratelimit limits the requests and quotas of developer apps. Each app is on a plan that sets a limit for every
+// budget (e.g. playlist conversions per day); the usage of a budget is counted in Redis, in fixed windows.
+package ratelimit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// The budgets of the plans.
+const (
+	// BudgetRequests are the requests of an app to the API.
+	BudgetRequests = "requests"
+	// BudgetUserRequests are the requests of an app for one of its users.
+	BudgetUserRequests = "user_requests"
+	// BudgetPlaylistConversions are the playlist conversions started by an app.
+	BudgetPlaylistConversions = "playlist_conversions"
+	// BudgetTrackConversions are the track conversions of an app.
+	BudgetTrackConversions = "track_conversions"
+	// BudgetTracksConverted are the tracks converted in the playlist conversions of an app. They are charged by the
+	// conversion once it is done, requests only check that some are left.
+	BudgetTracksConverted = "tracks_converted"
+)
+
+// The plans apps can be on.
+const (
+	PlanFree       = "free"
+	PlanPro        = "pro"
+	PlanEnterprise = "enterprise"
+)
+
+// Window is the period in which the usage of a budget is counted. Windows are aligned on the calendar, in UTC.
+type Window string
+
+const (
+	WindowMinute Window = "minute"
+	WindowDay    Window = "day"
+	WindowMonth  Window = "month"
+)
+
+// Budget is something the usage of is limited.
+type Budget struct {
+	Name   string
+	Window Window
+	// PerUser budgets are counted for each end user of the app, they only apply to requests for a user.
+	PerUser bool
+	// Metered budgets are charged with Charge, after the fact. Requests are only refused once none is left.
+	Metered bool
+}
+
+// Budgets are all the budgets, by name.
+var Budgets = map[string]Budget{
+	BudgetRequests:            {Name: BudgetRequests, Window: WindowMinute},
+	BudgetUserRequests:        {Name: BudgetUserRequests, Window: WindowMinute, PerUser: true},
+	BudgetPlaylistConversions: {Name: BudgetPlaylistConversions, Window: WindowDay},
+	BudgetTrackConversions:    {Name: BudgetTrackConversions, Window: WindowDay},
+	BudgetTracksConverted:     {Name: BudgetTracksConverted, Window: WindowMonth, Metered: true},
+}
+
+// Plan is the limit of each budget. A budget missing from the plan (or with a limit of 0 or less) is unlimited.
+type Plan map[string]int64
+
+// DefaultPlans are the plans used when RATE_LIMIT_PLANS is not set.
+var DefaultPlans = map[string]Plan{
+	PlanFree: {
+		BudgetRequests:            120,
+		BudgetUserRequests:        30,
+		BudgetPlaylistConversions: 50,
+		BudgetTrackConversions:    2000,
+		BudgetTracksConverted:     10000,
+	},
+	PlanPro: {
+		BudgetRequests:            1200,
+		BudgetUserRequests:        120,
+		BudgetPlaylistConversions: 2000,
+		BudgetTrackConversions:    100000,
+		BudgetTracksConverted:     500000,
+	},
+	PlanEnterprise: {
+		BudgetRequests:     6000,
+		BudgetUserRequests: 600,
+	},
+}
+
+// Result is the state of a budget after a request.
+type Result struct {
+	Budget    string    `json:"budget"`
+	Limit     int64     `json:"limit"`
+	Remaining int64     `json:"remaining"`
+	Reset     time.Time `json:"reset_at"`
+	Allowed   bool      `json:"-"`
+}
+
+// RetryAfter is how long until the budget is reset.
+func (r *Result) RetryAfter(now time.Time) time.Duration {
+	return max(r.Reset.Sub(now), 0)
+}
+
+type Limiter struct {
+	Redis *redis.Client
+	Plans map[string]Plan
+}
+
+// NewLimiter returns a limiter with the plans, see config.RateLimit.
+func NewLimiter(red *redis.Client, plans map[string]Plan) *Limiter {
+	return &Limiter{Redis: red, Plans: plans}
+}
+
+// LoadPlans parses plans from JSON. The default plans are returned if raw is empty.
+func LoadPlans(raw string) (map[string]Plan, error) {
+	if raw == "" {
+		return DefaultPlans, nil
+	}
+	var plans map[string]Plan
+	if err := json.Unmarshal([]byte(raw), &plans); err != nil {
+		return nil, err
+	}
+	for name, plan := range plans {
+		for budget := range plan {
+			if _, ok := Budgets[budget]; !ok {
+				return nil, fmt.Errorf("unknown budget %q in plan %q", budget, name)
+			}
+		}
+	}
+	if _, ok := plans[PlanFree]; !ok {
+		return nil, fmt.Errorf("missing the %q plan", PlanFree)
+	}
+	return plans, nil
+}
+
+// Limit returns the limit of the budget on the plan, 0 if it is unlimited. Apps on an unknown plan are on the free plan.
+func (l *Limiter) Limit(plan, budget string) int64 {
+	p, ok := l.Plans[plan]
+	if !ok {
+		p = l.Plans[PlanFree]
+	}
+	return max(p[budget], 0)
+}
+
+// allowScript counts a request against the counters in KEYS only if all of them allow it. ARGV has the limit, 1 for
+// a metered budget (only checked) and the reset time of each counter. It returns {0, used...} with the usage of each
+// budget once the request is counted, or {i, used} if the i-th budget refused the request.
+var allowScript = redis.NewScript(`
+for i = 1, #KEYS do
+	local used = tonumber(redis.call("get", KEYS[i]) or "0")
+	local limit = tonumber(ARGV[i * 3 - 2])
+	if (ARGV[i * 3 - 1] == "1" and used >= limit) or (ARGV[i * 3 - 1] ~= "1" and used + 1 > limit) then
+		return {i, used}
+	end
+end
+local usage = {0}
+for i = 1, #KEYS do
+	if ARGV[i * 3 - 1] == "1" then
+		usage[i + 1] = tonumber(redis.call("get", KEYS[i]) or "0")
+	else
+		usage[i + 1] = redis.call("incr", KEYS[i])
+		redis.call("expireat", KEYS[i], ARGV[i * 3])
+	end
+end
+return usage`)
+
+// Allow counts a request of the app against the budgets and returns whether each allows it. subject is the end user for
+// per-user budgets. The request is only counted if every budget allows it, so that a budget refusing it does not use the
+// others up. Metered budgets are only checked, not counted. It returns the result of each budget that applies, in order,
+// and stops at the first budget that refuses the request.
+func (l *Limiter) Allow(ctx context.Context, appId, plan string, budgets []string, subject string) ([]*Result, error) {
+	now := time.Now()
+	var results []*Result
+	var keys []string
+	var args []interface{}
+	for _, budget := range budgets {
+		b, ok := Budgets[budget]
+		if !ok {
+			return nil, fmt.Errorf("unknown budget %q", budget)
+		}
+		limit := l.Limit(plan, budget)
+		// per-user budgets do not apply to requests that are not for a user.
+		if limit == 0 || (b.PerUser && subject == "") {
+			continue
+		}
+		results = append(results, &Result{Budget: budget, Limit: limit, Reset: WindowEnd(b.Window, now), Allowed: true})
+		metered := 0
+		if b.Metered {
+			metered = 1
+		}
+		keys = append(keys, counterKey(appId, b, subject, now))
+		args = append(args, limit, metered, WindowEnd(b.Window, now).Unix())
+	}
+	if len(keys) == 0 {
+		return results, nil
+	}
+
+	usage, err := allowScript.Run(ctx, l.Redis, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+	if refused := usage[0]; refused > 0 {
+		result := results[refused-1]
+		result.Allowed = false
+		result.Remaining = max(result.Limit-usage[1], 0)
+		return results[:refused], nil
+	}
+	for i, result := range results {
+		result.Remaining = max(result.Limit-usage[i+1], 0)
+	}
+	return results, nil
+}
+
+// Charge uses n of a metered budget of the app up, e.g. the tracks converted in a playlist conversion. It does not need
+// the plans, so it can be called from the workers.
+func Charge(ctx context.Context, red *redis.Client, appId, budget string, n int64) error {
+	b, ok := Budgets[budget]
+	if !ok {
+		return fmt.Errorf("unknown budget %q", budget)
+	}
+	if n <= 0 {
+		return nil
+	}
+	now := time.Now()
+	key := counterKey(appId, b, "", now)
+	_, err := red.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.IncrBy(ctx, key, n)
+		pipe.ExpireAt(ctx, key, WindowEnd(b.Window, now))
+		return nil
+	})
+	return err
+}
+
+// WindowStart returns the start of the window the time is in.
+func WindowStart(window Window, t time.Time) time.Time {
+	t = t.UTC()
+	switch window {
+	case WindowDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case WindowMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return t.Truncate(time.Minute)
+	}
+}
+
+// WindowEnd returns the end of the window the time is in, when the budgets counted in it are reset.
+func WindowEnd(window Window, t time.Time) time.Time {
+	start := WindowStart(window, t)
+	switch window {
+	case WindowDay:
+		return start.AddDate(0, 0, 1)
+	case WindowMonth:
+		return start.AddDate(0, 1, 0)
+	default:
+		return start.Add(time.Minute)
+	}
+}
+
+// SandboxID returns the id the budgets of the app are counted under: the requests made with a test key (in the sandbox)
+// have budgets of their own, so that they do not use up the budgets of the live requests.
+func SandboxID(appId string, sandbox bool) string {
+	if sandbox {
+		return appId + ":sandbox"
+	}
+	return appId
+}
+
+func counterKey(appId string, budget Budget, subject string, now time.Time) string {
+	key := fmt.Sprintf("ratelimit:%s:%s:%d", appId, budget.Name, WindowStart(budget.Window, now).Unix())
+	if budget.PerUser && subject != "" {
+		key = fmt.Sprintf("%s:%s", key, subject)
+	}
+	return key
+}
+
+// Usage returns the state of the budgets of the app that are not per user, without counting anything.
+func (l *Limiter) Usage(ctx context.Context, appId, plan string) ([]Result, error) {
+	now := time.Now()
+	results := make([]Result, 0, len(Budgets))
+	for _, name := range []string{BudgetRequests, BudgetPlaylistConversions, BudgetTrackConversions, BudgetTracksConverted} {
+		b := Budgets[name]
+		limit := l.Limit(plan, name)
+		result := Result{Budget: name, Limit: limit, Reset: WindowEnd(b.Window, now), Allowed: true}
+		if limit > 0 {
+			used, err := l.Redis.Get(ctx, counterKey(appId, b, "", now)).Int64()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			result.Remaining = max(limit-used, 0)
+			result.Allowed = result.Remaining > 0
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
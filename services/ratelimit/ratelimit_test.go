@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWindows(t *testing.T) {
+	now := time.Date(2024, time.December, 31, 23, 59, 30, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2024, time.December, 31, 23, 59, 0, 0, time.UTC), WindowStart(WindowMinute, now))
+	assert.Equal(t, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), WindowEnd(WindowMinute, now))
+	assert.Equal(t, time.Date(2024, time.December, 31, 0, 0, 0, 0, time.UTC), WindowStart(WindowDay, now))
+	assert.Equal(t, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), WindowEnd(WindowDay, now))
+	assert.Equal(t, time.Date(2024, time.December, 1, 0, 0, 0, 0, time.UTC), WindowStart(WindowMonth, now))
+	assert.Equal(t, time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC), WindowEnd(WindowMonth, now))
+}
+
+func TestLoadPlans(t *testing.T) {
+	plans, err := LoadPlans("")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultPlans, plans)
+
+	plans, err = LoadPlans(`{"free": {"requests": 10}, "partner": {"requests": 100, "tracks_converted": 0}}`)
+	assert.NoError(t, err)
+	limiter := &Limiter{Plans: plans}
+	assert.Equal(t, int64(100), limiter.Limit("partner", BudgetRequests))
+	assert.Equal(t, int64(0), limiter.Limit("partner", BudgetTracksConverted))
+	// apps on an unknown plan are on the free plan.
+	assert.Equal(t, int64(10), limiter.Limit("unknown", BudgetRequests))
+
+	_, err = LoadPlans(`{"free": {"downloads": 10}}`)
+	assert.Error(t, err)
+	_, err = LoadPlans(`{"pro": {"requests": 10}}`)
+	assert.Error(t, err)
+}
+
+func TestCounterKey(t *testing.T) {
+	now := time.Date(2024, time.June, 15, 10, 0, 0, 0, time.UTC)
+	assert.Equal(t, "ratelimit:app:requests:1718445600", counterKey("app", Budgets[BudgetRequests], "user", now))
+	assert.Equal(t, "ratelimit:app:user_requests:1718445600:user", counterKey("app", Budgets[BudgetUserRequests], "user", now))
+}
+
+func TestAllowDoesNotCountRefusedRequests(t *testing.T) {
+	server := miniredis.RunT(t)
+	limiter := NewLimiter(redis.NewClient(&redis.Options{Addr: server.Addr()}), map[string]Plan{
+		"free": {BudgetRequests: 3, BudgetUserRequests: 1},
+	})
+	ctx := context.Background()
+	budgets := []string{BudgetRequests, BudgetUserRequests}
+
+	results, err := limiter.Allow(ctx, "app", "free", budgets, "user")
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, int64(2), results[0].Remaining)
+	assert.True(t, results[1].Allowed)
+	assert.Equal(t, int64(0), results[1].Remaining)
+
+	// the user budget refuses the next requests of the user, which do not use the app budget up.
+	for range 3 {
+		results, err = limiter.Allow(ctx, "app", "free", budgets, "user")
+		require.NoError(t, err)
+		require.Len(t, results, 2)
+		assert.True(t, results[0].Allowed)
+		assert.False(t, results[1].Allowed)
+	}
+	results, err = limiter.Allow(ctx, "app", "free", budgets, "")
+	require.NoError(t, err)
+	// the user budget does not apply to requests that are not for a user.
+	require.Len(t, results, 1)
+	assert.True(t, results[0].Allowed)
+	assert.Equal(t, int64(1), results[0].Remaining)
+}
@@ -0,0 +1,147 @@
+// Package reencrypt re-encrypts the secrets stored in the database (the platform credentials of the apps and the refresh
+// tokens of the users) with the current master key of the KMS, after a key rotation, so that the older master keys
+// can be retired.
+package reencrypt
+
+import (
+	"context"
+	"log"
+	"orchdio/db"
+	"orchdio/internal/encryption"
+	"orchdio/services/applemusic"
+	"orchdio/services/deezer"
+	"orchdio/services/spotify"
+	"orchdio/services/tidal"
+	"orchdio/services/tokens"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+)
+
+// batchSize is the number of rows read at a time.
+const batchSize = 100
+
+// Result is the number of records re-encrypted by a run, and of those that could not be.
+type Result struct {
+	Reencrypted int
+	Failed      int
+}
+
+// Handler re-encrypts the records that are not encrypted with the current master key. This is called from a cron job.
+// (see wiring.NewScheduler)
+func Handler(DB *sqlx.DB, red *redis.Client, envelope *encryption.Envelope) {
+	database := &db.NewDB{DB: DB}
+	apps, err := ReencryptAppsCredentials(database, envelope)
+	if err != nil {
+		log.Printf("[reencrypt][Handler] - error re-encrypting the credentials of the apps: %v", err)
+	}
+	refreshTokens, err := ReencryptRefreshTokens(context.Background(), database, red, envelope)
+	if err != nil {
+		log.Printf("[reencrypt][Handler] - error re-encrypting the refresh tokens: %v", err)
+	}
+	if apps.Reencrypted+apps.Failed+refreshTokens.Reencrypted+refreshTokens.Failed > 0 {
+		log.Printf("[reencrypt][Handler] - re-encrypted %d credentials (%d failed) and %d refresh tokens (%d failed) with key %s",
+			apps.Reencrypted, apps.Failed, refreshTokens.Reencrypted, refreshTokens.Failed, envelope.KMS.CurrentKeyID())
+	}
+}
+
+// ReencryptAppsCredentials re-encrypts the platform credentials of the apps that are not encrypted with the current
+// master key.
+func ReencryptAppsCredentials(database *db.NewDB, envelope *encryption.Envelope) (Result, error) {
+	var result Result
+	after := ""
+	for {
+		apps, err := database.FetchAppsCredentialsPage(after, batchSize)
+		if err != nil {
+			return result, err
+		}
+		for _, app := range apps {
+			credentials := map[string][]byte{
+				spotify.IDENTIFIER:    app.SpotifyCredentials,
+				applemusic.IDENTIFIER: app.AppleMusicCredentials,
+				deezer.IDENTIFIER:     app.DeezerCredentials,
+				tidal.IDENTIFIER:      app.TidalCredentials,
+			}
+			for platform, previous := range credentials {
+				if !envelope.NeedsReencryption(previous) {
+					continue
+				}
+				updated, rErr := reencrypt(envelope, previous, func(record []byte) (bool, error) {
+					return database.ReencryptAppCredentials(app.UID.String(), platform, previous, record)
+				})
+				if rErr != nil {
+					log.Printf("[reencrypt][ReencryptAppsCredentials] - error re-encrypting the %s credentials of app %s: %v", platform, app.UID, rErr)
+					result.Failed++
+					continue
+				}
+				if updated {
+					result.Reencrypted++
+				}
+			}
+		}
+		if len(apps) < batchSize {
+			return result, nil
+		}
+		after = apps[len(apps)-1].UID.String()
+	}
+}
+
+// ReencryptRefreshTokens re-encrypts the refresh tokens of the users that are not encrypted with the current master key.
+// Each token is re-encrypted under the refresh lock of its user app (see tokens.TryLock): a refresh saves the rotated
+// token only if the stored one did not change, so re-encrypting it mid-refresh would lose the rotated token. The tokens
+// being refreshed are skipped, the refresh saves them with the current master key.
+func ReencryptRefreshTokens(ctx context.Context, database *db.NewDB, red *redis.Client, envelope *encryption.Envelope) (Result, error) {
+	var result Result
+	after := ""
+	for {
+		page, err := database.FetchUserAppRefreshTokensPage(after, batchSize)
+		if err != nil {
+			return result, err
+		}
+		for _, t := range page {
+			if !envelope.NeedsReencryption(t.RefreshToken) {
+				continue
+			}
+			unlock, acquired, lErr := tokens.TryLock(ctx, red, t.UUID)
+			if lErr != nil {
+				log.Printf("[reencrypt][ReencryptRefreshTokens] - error taking the refresh lock of user app %s: %v", t.UUID, lErr)
+				result.Failed++
+				continue
+			}
+			if !acquired {
+				log.Printf("[reencrypt][ReencryptRefreshTokens] - skipping the refresh token of user app %s, it is being refreshed", t.UUID)
+				continue
+			}
+			updated, rErr := reencrypt(envelope, t.RefreshToken, func(record []byte) (bool, error) {
+				return database.ReencryptUserAppRefreshToken(t.UUID, t.RefreshToken, record)
+			})
+			unlock()
+			if rErr != nil {
+				log.Printf("[reencrypt][ReencryptRefreshTokens] - error re-encrypting the refresh token of user app %s: %v", t.UUID, rErr)
+				result.Failed++
+				continue
+			}
+			if updated {
+				result.Reencrypted++
+			}
+		}
+		if len(page) < batchSize {
+			return result, nil
+		}
+		after = page[len(page)-1].UUID
+	}
+}
+
+// reencrypt decrypts the record and saves it encrypted with the current master key. save returns false if the record
+// changed in the meantime, in which case it was saved with the current master key already.
+func reencrypt(envelope *encryption.Envelope, previous []byte, save func(record []byte) (bool, error)) (bool, error) {
+	plaintext, err := envelope.Decrypt(previous)
+	if err != nil {
+		return false, err
+	}
+	record, err := envelope.Encrypt(plaintext)
+	if err != nil {
+		return false, err
+	}
+	return save(record)
+}
@@ -0,0 +1,56 @@
+package reencrypt
+
+import (
+	"bytes"
+	"context"
+	"orchdio/db"
+	"orchdio/db/queries"
+	"orchdio/internal/encryption"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReencryptRefreshTokensSkipsLockedTokens(t *testing.T) {
+	keys := map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32), "k2": bytes.Repeat([]byte{2}, 32)}
+	kms, err := encryption.NewLocalKMS("k1", keys)
+	require.NoError(t, err)
+	old := &encryption.Envelope{KMS: kms}
+	locked, err := old.Encrypt([]byte("locked-refresh-token"))
+	require.NoError(t, err)
+	free, err := old.Encrypt([]byte("free-refresh-token"))
+	require.NoError(t, err)
+	kms, err = encryption.NewLocalKMS("k2", keys)
+	require.NoError(t, err)
+	envelope := &encryption.Envelope{KMS: kms}
+
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+	server := miniredis.RunT(t)
+	red := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	// the first token is being refreshed.
+	require.NoError(t, server.Set("token_refresh:user-app-1", "refresh"))
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchUserAppRefreshTokensPage)).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "refresh_token"}).
+			AddRow("user-app-1", locked).
+			AddRow("user-app-2", free))
+	mock.ExpectExec(regexp.QuoteMeta(queries.ReencryptUserAppRefreshToken)).
+		WithArgs("user-app-2", sqlmock.AnyArg(), free).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	result, err := ReencryptRefreshTokens(context.Background(), &db.NewDB{DB: sqlx.NewDb(conn, "postgres")}, red, envelope)
+	require.NoError(t, err)
+	assert.Equal(t, Result{Reencrypted: 1}, result)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// the lock of the refresh is left alone, and the lock of the re-encrypted token is released.
+	assert.True(t, server.Exists("token_refresh:user-app-1"))
+	assert.False(t, server.Exists("token_refresh:user-app-2"))
+}
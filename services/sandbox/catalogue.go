@@ -0,0 +1,182 @@
+package sandbox
+
+import (
+	"orchdio/services/applemusic"
+	"orchdio/services/deezer"
+	"orchdio/services/spotify"
+	"orchdio/services/tidal"
+	"orchdio/services/ytmusic"
+)
+
+// track is a track of the catalogue. IDs are its ids on the platforms it is available on; it can not be found on the
+// others, so that the conversions also have missing tracks.
+type track struct {
+	Title         string
+	Artists       []string
+	Album         string
+	Released      string
+	DurationMilli int
+	Explicit      bool
+	IDs           map[string]string
+}
+
+// playlist is a playlist of the catalogue, available on all the platforms. Tracks are indexes in tracks.
+type playlist struct {
+	Title       string
+	Description string
+	Owner       string
+	Checksum    string
+	IDs         map[string]string
+	Tracks      []int
+}
+
+var tracks = []track{
+	{
+		Title:         "Midnight Drive",
+		Artists:       []string{"Luna Park"},
+		Album:         "Night Lines",
+		Released:      "2021-03-12",
+		DurationMilli: 221000,
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0track000000000001",
+			deezer.IDENTIFIER:     "900000001",
+			tidal.IDENTIFIER:      "800000001",
+			applemusic.IDENTIFIER: "700000001",
+			ytmusic.IDENTIFIER:    "sbxTrack001",
+		},
+	},
+	{
+		Title:         "Paper Planes Over Lagos",
+		Artists:       []string{"Ada Obi"},
+		Album:         "Harmattan",
+		Released:      "2022-11-04",
+		DurationMilli: 198000,
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0track000000000002",
+			deezer.IDENTIFIER:     "900000002",
+			tidal.IDENTIFIER:      "800000002",
+			applemusic.IDENTIFIER: "700000002",
+			ytmusic.IDENTIFIER:    "sbxTrack002",
+		},
+	},
+	{
+		Title:         "Glass Houses",
+		Artists:       []string{"The Cartographers"},
+		Album:         "Glass Houses",
+		Released:      "2019-06-21",
+		DurationMilli: 254000,
+		Explicit:      true,
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0track000000000003",
+			deezer.IDENTIFIER:     "900000003",
+			tidal.IDENTIFIER:      "800000003",
+			applemusic.IDENTIFIER: "700000003",
+			ytmusic.IDENTIFIER:    "sbxTrack003",
+		},
+	},
+	{
+		Title:         "Slow Burn",
+		Artists:       []string{"Ada Obi", "Kofi Mensah"},
+		Album:         "Harmattan",
+		Released:      "2022-11-04",
+		DurationMilli: 236000,
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0track000000000004",
+			deezer.IDENTIFIER:     "900000004",
+			tidal.IDENTIFIER:      "800000004",
+			applemusic.IDENTIFIER: "700000004",
+			ytmusic.IDENTIFIER:    "sbxTrack004",
+		},
+	},
+	{
+		Title:         "Northern Lights",
+		Artists:       []string{"Luna Park"},
+		Album:         "Night Lines",
+		Released:      "2021-03-12",
+		DurationMilli: 187000,
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0track000000000005",
+			deezer.IDENTIFIER:     "900000005",
+			applemusic.IDENTIFIER: "700000005",
+			ytmusic.IDENTIFIER:    "sbxTrack005",
+		},
+	},
+	{
+		Title:         "Static Bloom",
+		Artists:       []string{"The Cartographers"},
+		Album:         "Static Bloom",
+		Released:      "2023-08-18",
+		DurationMilli: 205000,
+		IDs: map[string]string{
+			spotify.IDENTIFIER: "0sbx0track000000000006",
+			deezer.IDENTIFIER:  "900000006",
+		},
+	},
+}
+
+var playlists = []playlist{
+	{
+		Title:       "Orchdio Sandbox Mix",
+		Description: "Every track of the sandbox catalogue",
+		Owner:       "Orchdio Sandbox",
+		Checksum:    "sandbox-mix-v1",
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0playlist000000001",
+			deezer.IDENTIFIER:     "910000001",
+			tidal.IDENTIFIER:      "0b5c0000-0000-4000-8000-000000000001",
+			applemusic.IDENTIFIER: "pl.sandbox0001",
+			ytmusic.IDENTIFIER:    "PLsandbox0001",
+		},
+		Tracks: []int{0, 1, 2, 3, 4, 5},
+	},
+	{
+		Title:       "Sandbox Favourites",
+		Description: "A short playlist of the sandbox catalogue",
+		Owner:       "Orchdio Sandbox",
+		Checksum:    "sandbox-favourites-v1",
+		IDs: map[string]string{
+			spotify.IDENTIFIER:    "0sbx0playlist000000002",
+			deezer.IDENTIFIER:     "910000002",
+			tidal.IDENTIFIER:      "0b5c0000-0000-4000-8000-000000000002",
+			applemusic.IDENTIFIER: "pl.sandbox0002",
+			ytmusic.IDENTIFIER:    "PLsandbox0002",
+		},
+		Tracks: []int{1, 3, 4},
+	},
+}
+
+// trackURL returns the URL of the track on the platform, in the format of the links of the platform.
+func trackURL(platform string, t *track) string {
+	id := t.IDs[platform]
+	switch platform {
+	case spotify.IDENTIFIER:
+		return "https://open.spotify.com/track/" + id
+	case deezer.IDENTIFIER:
+		return "https://www.deezer.com/track/" + id
+	case tidal.IDENTIFIER:
+		return "https://tidal.com/browse/track/" + id
+	case applemusic.IDENTIFIER:
+		return "https://music.apple.com/us/album/" + slug(t.Album) + "/" + id + "?i=" + id
+	case ytmusic.IDENTIFIER:
+		return "https://music.youtube.com/watch?v=" + id
+	}
+	return ""
+}
+
+// playlistURL returns the URL of the playlist on the platform, in the format of the links of the platform.
+func playlistURL(platform string, p *playlist) string {
+	id := p.IDs[platform]
+	switch platform {
+	case spotify.IDENTIFIER:
+		return "https://open.spotify.com/playlist/" + id
+	case deezer.IDENTIFIER:
+		return "https://www.deezer.com/playlist/" + id
+	case tidal.IDENTIFIER:
+		return "https://tidal.com/browse/playlist/" + id
+	case applemusic.IDENTIFIER:
+		return "https://music.apple.com/us/playlist/" + slug(p.Title) + "/" + id
+	case ytmusic.IDENTIFIER:
+		return "https://music.youtube.com/playlist?list=" + id
+	}
+	return ""
+}
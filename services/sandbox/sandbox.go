@@ -0,0 +1,231 @@
+// Package sandbox is the fake platform used for the requests made with the test keys of the apps. It has a fixed
+// catalogue of tracks and playlists, available under the same links as on the real platforms, so that conversions,
+// webhooks and library calls behave as they would in production without calling any platform or needing credentials.
+package sandbox
+
+import (
+	"context"
+	"orchdio/blueprint"
+	"orchdio/logger"
+	"orchdio/util"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/samber/lo"
+	"go.uber.org/zap"
+)
+
+// AccessToken is the access token of the sandbox user, used for the library calls made with a test key.
+const AccessToken = "sandbox"
+
+// Username is the name of the sandbox user, whose library is returned for all the users in the sandbox.
+const Username = "sandbox-user"
+
+// Service is the sandbox implementation of the platform service of a platform.
+type Service struct {
+	Platform string
+	App      *blueprint.DeveloperApp
+}
+
+// NewService returns the sandbox service of the platform.
+func NewService(platform string, devApp *blueprint.DeveloperApp) *Service {
+	return &Service{Platform: platform, App: devApp}
+}
+
+// SearchTrackWithID returns the track of the catalogue with the id on the platform.
+func (s *Service) SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
+	for i := range tracks {
+		if id, ok := tracks[i].IDs[s.Platform]; ok && id == info.EntityID {
+			return s.trackResult(&tracks[i]), nil
+		}
+	}
+	l.Info("[services][sandbox][SearchTrackWithID] no track with id", zap.String("platform", s.Platform), zap.String("entity_id", info.EntityID))
+	return nil, blueprint.EnoResult
+}
+
+// SearchTrackWithTitle returns the track of the catalogue available on the platform with the title and one of the
+// artists.
+func (s *Service) SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	title := util.NormalizeString(searchData.Title)
+	for i := range tracks {
+		t := &tracks[i]
+		if _, ok := t.IDs[s.Platform]; !ok || util.NormalizeString(t.Title) != title {
+			continue
+		}
+		if len(searchData.Artists) == 0 || lo.SomeBy(searchData.Artists, func(artist string) bool {
+			return lo.ContainsBy(t.Artists, func(a string) bool { return util.NormalizeString(a) == util.NormalizeString(artist) })
+		}) {
+			return s.trackResult(t), nil
+		}
+	}
+	return nil, blueprint.EnoResult
+}
+
+// FetchPlaylistMetaInfo returns the playlist of the catalogue with the id on the platform.
+func (s *Service) FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+	l := logger.FromContext(ctx)
+	p := s.findPlaylist(info.EntityID)
+	if p == nil {
+		l.Info("[services][sandbox][FetchPlaylistMetaInfo] no playlist with id", zap.String("platform", s.Platform), zap.String("entity_id", info.EntityID))
+		return nil, blueprint.EnoResult
+	}
+	return &blueprint.PlaylistMetadata{
+		Length:      util.GetFormattedDuration(playlistDuration(p) / 1000),
+		Title:       p.Title,
+		Owner:       p.Owner,
+		Cover:       cover(p.Title),
+		Entity:      "playlist",
+		URL:         playlistURL(s.Platform, p),
+		NBTracks:    len(p.Tracks),
+		Description: p.Description,
+		Checksum:    p.Checksum,
+		ID:          p.IDs[s.Platform],
+	}, nil
+}
+
+// FetchTracksForSourcePlatform sends the tracks of the playlist of the catalogue to the result channel. The channel is
+// closed by the caller.
+func (s *Service) FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error {
+	p := s.findPlaylist(info.EntityID)
+	if p == nil {
+		return blueprint.EnoResult
+	}
+	for _, i := range p.Tracks {
+		if _, ok := tracks[i].IDs[s.Platform]; ok {
+			result <- *s.trackResult(&tracks[i])
+		}
+	}
+	return nil
+}
+
+// FetchLibraryAlbums returns the albums of the tracks of the catalogue available on the platform.
+func (s *Service) FetchLibraryAlbums(accessToken string) ([]blueprint.LibraryAlbum, error) {
+	albums := make([]blueprint.LibraryAlbum, 0)
+	for _, t := range s.tracks() {
+		if _, i, ok := lo.FindIndexOf(albums, func(a blueprint.LibraryAlbum) bool { return a.Title == t.Album }); ok {
+			albums[i].TrackCount++
+			continue
+		}
+		albums = append(albums, blueprint.LibraryAlbum{
+			ID:          "album-" + slug(t.Album),
+			Title:       t.Album,
+			URL:         trackURL(s.Platform, t),
+			ReleaseDate: t.Released,
+			Explicit:    t.Explicit,
+			TrackCount:  1,
+			Artists:     t.Artists[:1],
+			Cover:       cover(t.Album),
+		})
+	}
+	return albums, nil
+}
+
+// FetchListeningHistory returns the tracks of the catalogue available on the platform.
+func (s *Service) FetchListeningHistory(accessToken string) ([]blueprint.TrackSearchResult, error) {
+	return lo.Map(s.tracks(), func(t *track, _ int) blueprint.TrackSearchResult { return *s.trackResult(t) }), nil
+}
+
+// FetchUserArtists returns the artists of the tracks of the catalogue available on the platform.
+func (s *Service) FetchUserArtists(accessToken string) (*blueprint.UserLibraryArtists, error) {
+	artists := make([]blueprint.UserArtist, 0)
+	for _, t := range s.tracks() {
+		for _, name := range t.Artists {
+			if lo.ContainsBy(artists, func(a blueprint.UserArtist) bool { return a.Name == name }) {
+				continue
+			}
+			artists = append(artists, blueprint.UserArtist{
+				ID:    "artist-" + slug(name),
+				Name:  name,
+				Cover: cover(name),
+			})
+		}
+	}
+	return &blueprint.UserLibraryArtists{Payload: artists, Total: len(artists)}, nil
+}
+
+// FetchLibraryPlaylists returns the playlists of the catalogue.
+func (s *Service) FetchLibraryPlaylists(accessToken string) ([]blueprint.UserPlaylist, error) {
+	return lo.Map(playlists, func(p playlist, _ int) blueprint.UserPlaylist {
+		duration := playlistDuration(&p)
+		return blueprint.UserPlaylist{
+			ID:            p.IDs[s.Platform],
+			Title:         p.Title,
+			Description:   p.Description,
+			Duration:      util.GetFormattedDuration(duration / 1000),
+			DurationMilis: duration,
+			Public:        true,
+			NbTracks:      len(p.Tracks),
+			URL:           playlistURL(s.Platform, &p),
+			Cover:         cover(p.Title),
+			CreatedAt:     time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC).Format(time.RFC3339),
+			Checksum:      p.Checksum,
+			Owner:         p.Owner,
+		}
+	}), nil
+}
+
+// FetchUserInfo returns the profile of the sandbox user.
+func (s *Service) FetchUserInfo(authInfo blueprint.UserAuthInfoForRequests) (*blueprint.UserPlatformInfo, error) {
+	return &blueprint.UserPlatformInfo{
+		Platform:   s.Platform,
+		Username:   Username,
+		PlatformID: Username,
+		Followers:  42,
+		Following:  7,
+	}, nil
+}
+
+// tracks returns the tracks of the catalogue available on the platform.
+func (s *Service) tracks() []*track {
+	var res []*track
+	for i := range tracks {
+		if _, ok := tracks[i].IDs[s.Platform]; ok {
+			res = append(res, &tracks[i])
+		}
+	}
+	return res
+}
+
+func (s *Service) findPlaylist(id string) *playlist {
+	// apple music playlist ids are extracted from the link with the leading slash.
+	id = strings.TrimPrefix(id, "/")
+	for i := range playlists {
+		if playlists[i].IDs[s.Platform] == id {
+			return &playlists[i]
+		}
+	}
+	return nil
+}
+
+func (s *Service) trackResult(t *track) *blueprint.TrackSearchResult {
+	return &blueprint.TrackSearchResult{
+		URL:           trackURL(s.Platform, t),
+		Artists:       t.Artists,
+		Released:      t.Released,
+		Duration:      util.GetFormattedDuration(t.DurationMilli / 1000),
+		DurationMilli: t.DurationMilli,
+		Explicit:      t.Explicit,
+		Title:         t.Title,
+		Album:         t.Album,
+		ID:            t.IDs[s.Platform],
+		Cover:         cover(t.Album),
+	}
+}
+
+func playlistDuration(p *playlist) int {
+	return lo.SumBy(p.Tracks, func(i int) int { return tracks[i].DurationMilli })
+}
+
+// cover returns a placeholder image, the same for the same name.
+func cover(name string) string {
+	return "https://picsum.photos/seed/orchdio-" + slug(name) + "/640"
+}
+
+// slug returns the name in lower case with the words joined by hyphens, e.g. paper-planes-over-lagos.
+func slug(name string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), "-")
+}
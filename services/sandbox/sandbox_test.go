@@ -0,0 +1,93 @@
+package sandbox
+
+import (
+	"context"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/services"
+	"orchdio/services/deezer"
+	"orchdio/services/spotify"
+	"orchdio/services/tidal"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalogueTrackLinks(t *testing.T) {
+	for i := range tracks {
+		for platform := range tracks[i].IDs {
+			link := trackURL(platform, &tracks[i])
+			info, err := services.ExtractLinkInfo(link, config.Get().Platforms)
+			require.NoError(t, err, link)
+			assert.Equal(t, platform, info.Platform, link)
+
+			result, err := NewService(info.Platform, nil).SearchTrackWithID(context.Background(), info)
+			require.NoError(t, err, link)
+			assert.Equal(t, tracks[i].Title, result.Title)
+			assert.Equal(t, link, result.URL)
+		}
+	}
+}
+
+func TestCataloguePlaylistLinks(t *testing.T) {
+	for i := range playlists {
+		for platform := range playlists[i].IDs {
+			link := playlistURL(platform, &playlists[i])
+			info, err := services.ExtractLinkInfo(link, config.Get().Platforms)
+			require.NoError(t, err, link)
+			assert.Equal(t, platform, info.Platform, link)
+
+			meta, err := NewService(info.Platform, nil).FetchPlaylistMetaInfo(context.Background(), info)
+			require.NoError(t, err, link)
+			assert.Equal(t, playlists[i].Title, meta.Title)
+			assert.Equal(t, len(playlists[i].Tracks), meta.NBTracks)
+		}
+	}
+}
+
+func TestSearchTrackWithTitle(t *testing.T) {
+	search := &blueprint.TrackSearchData{Title: "slow burn", Artists: []string{"Kofi Mensah"}}
+	result, err := NewService(deezer.IDENTIFIER, nil).SearchTrackWithTitle(context.Background(), search, blueprint.UserAuthInfoForRequests{})
+	require.NoError(t, err)
+	assert.Equal(t, "900000004", result.ID)
+
+	search = &blueprint.TrackSearchData{Title: "Slow Burn", Artists: []string{"Luna Park"}}
+	_, err = NewService(deezer.IDENTIFIER, nil).SearchTrackWithTitle(context.Background(), search, blueprint.UserAuthInfoForRequests{})
+	assert.ErrorIs(t, err, blueprint.EnoResult)
+
+	// Static Bloom is not on tidal.
+	search = &blueprint.TrackSearchData{Title: "Static Bloom", Artists: []string{"The Cartographers"}}
+	_, err = NewService(tidal.IDENTIFIER, nil).SearchTrackWithTitle(context.Background(), search, blueprint.UserAuthInfoForRequests{})
+	assert.ErrorIs(t, err, blueprint.EnoResult)
+}
+
+func TestFetchTracksForSourcePlatform(t *testing.T) {
+	info := &blueprint.LinkInfo{Platform: tidal.IDENTIFIER, EntityID: playlists[0].IDs[tidal.IDENTIFIER]}
+	result := make(chan blueprint.TrackSearchResult, len(tracks))
+	require.NoError(t, NewService(tidal.IDENTIFIER, nil).FetchTracksForSourcePlatform(context.Background(), info, nil, result))
+	close(result)
+
+	var titles []string
+	for r := range result {
+		titles = append(titles, r.Title)
+	}
+	// the tracks missing on tidal are skipped.
+	assert.Len(t, titles, 4)
+	assert.NotContains(t, titles, "Northern Lights")
+	assert.NotContains(t, titles, "Static Bloom")
+}
+
+func TestLibrary(t *testing.T) {
+	albums, err := NewService(spotify.IDENTIFIER, nil).FetchLibraryAlbums(AccessToken)
+	require.NoError(t, err)
+	assert.Len(t, albums, 4)
+
+	artists, err := NewService(spotify.IDENTIFIER, nil).FetchUserArtists(AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, 4, artists.Total)
+
+	userPlaylists, err := NewService(spotify.IDENTIFIER, nil).FetchLibraryPlaylists(AccessToken)
+	require.NoError(t, err)
+	assert.Len(t, userPlaylists, len(playlists))
+}
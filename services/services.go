@@ -8,6 +8,7 @@ import (
 	"log"
 	"net/url"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
 	"orchdio/services/deezer"
 	"orchdio/services/spotify"
@@ -15,7 +16,6 @@ import (
 	"orchdio/services/ytmusic"
 	"orchdio/util"
 	svixwebhook "orchdio/webhooks/svix"
-	"os"
 	"strings"
 
 	"github.com/badoux/goscraper"
@@ -25,8 +25,8 @@ import (
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
 )
 
-// ExtractLinkInfo extracts a URL from a URL.
-func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
+// ExtractLinkInfo extracts a URL from a URL. The target links are on the API bases of the platforms.
+func ExtractLinkInfo(t string, platforms config.Platforms) (*blueprint.LinkInfo, error) {
 	// first, check if the link is a shortlink
 
 	/**
@@ -124,7 +124,7 @@ func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
 		// then we want to return the real URL.
 		linkInfo := &blueprint.LinkInfo{
 			Platform:   deezer.IDENTIFIER,
-			TargetLink: fmt.Sprintf("%s/%s/%s", os.Getenv("DEEZER_API_BASE"), entity, entityID),
+			TargetLink: fmt.Sprintf("%s/%s/%s", platforms.DeezerAPIBase, entity, entityID),
 			Entity:     entity,
 			EntityID:   entityID,
 		}
@@ -143,7 +143,7 @@ func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
 		// then we want to return the real URL.
 		linkInfo := &blueprint.LinkInfo{
 			Platform:   spotify.IDENTIFIER,
-			TargetLink: fmt.Sprintf("%s/%s/%s", os.Getenv("SPOTIFY_API_BASE"), entity, entityID),
+			TargetLink: fmt.Sprintf("%s/%s/%s", platforms.SpotifyAPIBase, entity, entityID),
 			Entity:     entity,
 			EntityID:   entityID,
 		}
@@ -160,7 +160,7 @@ func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
 
 		linkInfo := blueprint.LinkInfo{
 			Platform:   tidal.IDENTIFIER,
-			TargetLink: fmt.Sprintf("%s/%s/%s", os.Getenv("TIDAL_API_BASE"), entity, entityID),
+			TargetLink: fmt.Sprintf("%s/%s/%s", platforms.TidalAPIBase, entity, entityID),
 			Entity:     entity,
 			EntityID:   entityID,
 		}
@@ -186,7 +186,7 @@ func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
 			linkInfo := blueprint.LinkInfo{
 				Platform: ytmusic.IDENTIFIER,
 				// we're doing sprintf manually instead of the original url because the original url might have tracking links attached.cd ..
-				TargetLink: fmt.Sprintf("%s/watch?=%s", os.Getenv("YTMUSIC_API_BASE"), trackParam),
+				TargetLink: fmt.Sprintf("%s/watch?=%s", platforms.YTMusicAPIBase, trackParam),
 				Entity:     "track",
 				EntityID:   trackParam,
 			}
@@ -198,7 +198,7 @@ func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
 			log.Printf("[services][ExtractLinkInfo][info] Youtube link is a playlist.")
 			linkInfo := blueprint.LinkInfo{
 				Platform:   ytmusic.IDENTIFIER,
-				TargetLink: fmt.Sprintf("%s/playlist?list=%s", os.Getenv("YTMUSIC_API_BASE"), playlistParam),
+				TargetLink: fmt.Sprintf("%s/playlist?list=%s", platforms.YTMusicAPIBase, playlistParam),
 				Entity:     "playlist",
 				EntityID:   playlistParam,
 			}
@@ -238,21 +238,25 @@ func ExtractLinkInfo(t string) (*blueprint.LinkInfo, error) {
 		return linkInfo, nil
 	default:
 		log.Printf("\n[servies][s: Track][error] URL info could not be processed. Might be an invalid link")
-		log.Printf(host)
+		log.Print(host)
 		return nil, blueprint.ErrHostUnsupported
 	}
 	return nil, nil
 }
 
 type SyncFollowTask struct {
-	DB  *sqlx.DB
-	Red *redis.Client
+	DB            *sqlx.DB
+	Red           *redis.Client
+	Config        *config.Config
+	WebhookSender svixwebhook.SvixInterface
 }
 
-func NewFollowTask(db *sqlx.DB, red *redis.Client) *SyncFollowTask {
+func NewFollowTask(db *sqlx.DB, red *redis.Client, cfg *config.Config, webhookSender svixwebhook.SvixInterface) *SyncFollowTask {
 	return &SyncFollowTask{
-		DB:  db,
-		Red: red,
+		DB:            db,
+		Red:           red,
+		Config:        cfg,
+		WebhookSender: webhookSender,
 	}
 }
 
@@ -315,13 +319,12 @@ func (s *SyncFollowTask) HasPlaylistBeenUpdated(platform, entity, entityId, appI
 	var entitySnapshot string
 	var platformBytes []byte
 
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
 	if lo.Contains(supportedEntities, entity) {
 		switch platform {
 		// TODO: implement other platforms
 		case "spotify":
 			log.Printf("[follow][FetchPlaylistHash] - checking if playlist has been updated")
-			_ = spotify.NewService(&creds, s.DB, s.Red, app, webhookSender)
+			_ = spotify.NewService(&creds, s.DB, s.Red, app, s.WebhookSender, s.Config)
 			// fixme: there is a bug here. we need to pass the user's auth token to the fetchplaylisthash function
 			// 		question is: how do we get the user's auth token in this case? unless whenever we run this function,
 			// 		we let it run in the context of an authed user request, so that way we can always get the user's auth token
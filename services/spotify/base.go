@@ -6,12 +6,15 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/logger"
+	"orchdio/metrics"
 	"orchdio/util"
 	svixwebhook "orchdio/webhooks/svix"
 	"strings"
-	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/jmoiron/sqlx"
@@ -21,6 +24,7 @@ import (
 	"golang.org/x/oauth2/clientcredentials"
 
 	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"go.uber.org/zap"
 )
 
 type Service struct {
@@ -30,13 +34,14 @@ type Service struct {
 	PgClient             *sqlx.DB
 	App                  *blueprint.DeveloperApp
 	WebhookSender        svixwebhook.SvixInterface
+	Config               *config.Config
 }
 
 type WebhookSender interface {
 	SendTrackEvent(appID string, event *blueprint.PlaylistConversionEventTrack) bool
 }
 
-func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB, redisClient *redis.Client, devApp *blueprint.DeveloperApp, webhookSender svixwebhook.SvixInterface) *Service {
+func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB, redisClient *redis.Client, devApp *blueprint.DeveloperApp, webhookSender svixwebhook.SvixInterface, cfg *config.Config) *Service {
 	return &Service{
 		IntegrationAppID:     credentials.AppID,
 		IntegrationAppSecret: credentials.AppSecret,
@@ -45,6 +50,7 @@ func NewService(credentials *blueprint.IntegrationCredentials, pgClient *sqlx.DB
 		PgClient:      pgClient,
 		App:           devApp,
 		WebhookSender: webhookSender,
+		Config:        cfg,
 	}
 }
 
@@ -87,23 +93,23 @@ func extractArtiste(artiste string) string {
 
 // fetchSingleTrack returns a single track by searching with the title. This method is used when fetching a track
 // using the SearchData from another service.
-func (s *Service) fetchSingleTrack(searchData *blueprint.TrackSearchData) *spotify.SearchResult {
+func (s *Service) fetchSingleTrack(ctx context.Context, searchData *blueprint.TrackSearchData) *spotify.SearchResult {
 	config := &clientcredentials.Config{
 		ClientID:     s.IntegrationAppID,
 		ClientSecret: s.IntegrationAppSecret,
 		TokenURL:     spotifyauth.TokenURL,
 	}
 
-	token, err := config.Token(context.Background())
+	token, err := config.Token(ctx)
 	if err != nil {
 		log.Printf("\n[services][spotify][base][SearchTrackWithID] error  - could not fetch spotify token: %v\n", err)
 		return nil
 	}
 
-	httpClient := spotifyauth.New(spotifyauth.WithClientID(s.IntegrationAppID), spotifyauth.WithClientSecret(s.IntegrationAppSecret)).Client(context.Background(), token)
+	httpClient := spotifyauth.New(spotifyauth.WithClientID(s.IntegrationAppID), spotifyauth.WithClientSecret(s.IntegrationAppSecret)).Client(ctx, token)
 	client := spotify.New(httpClient)
 
-	results, err := client.Search(context.Background(), fmt.Sprintf("%s %s", searchData.Artists[0], searchData.Title), spotify.SearchTypeTrack)
+	results, err := client.Search(ctx, fmt.Sprintf("%s %s", searchData.Artists[0], searchData.Title), spotify.SearchTypeTrack)
 	if err != nil {
 		log.Printf("\n[services][spotify][base][FetchingSingleTrack] error - could not search for track: %v\n", err)
 		return nil
@@ -115,35 +121,38 @@ func (s *Service) fetchSingleTrack(searchData *blueprint.TrackSearchData) *spoti
 // SearchTrackWithTitle searches spotify using the title of a track
 // This is typically expected to be used when the track we want to fetch is the one we just
 // want to search on. That is, the other platforms that the user is trying to convert to.
-func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	searchData.Artists[0] = extractArtiste(searchData.Artists[0])
 	cleanedArtiste := fmt.Sprintf("spotify-%s-%s", util.NormalizeString(searchData.Artists[0]), searchData.Title)
 
-	log.Printf("Spotify: Searching with stripped artiste: %s. Original artiste: %s", cleanedArtiste, searchData.Artists[0])
+	l.Info("Spotify: Searching with stripped artiste", zap.String("key", cleanedArtiste), zap.String("artist", searchData.Artists[0]))
 	// if we have searched for this specific track before, we return the cached result
 	// And how do we know if we have cached it before?
 	// We store the hash of the title and artiste of the track in redis. we check if the hash of the
 	// track we want to search exist.
-	if s.RedisClient.Exists(context.Background(), cleanedArtiste).Val() == 1 {
-		log.Printf("Spotify: Found cached result for %s", cleanedArtiste)
+	cached := s.RedisClient.Exists(ctx, cleanedArtiste).Val() == 1
+	metrics.CacheLookup(IDENTIFIER, "title", cached)
+	if cached {
+		l.Info("Spotify: Found cached result", zap.String("key", cleanedArtiste))
 		// deserialize the result from redis
 		var result *blueprint.TrackSearchResult
-		cachedResult, err := s.RedisClient.Get(context.Background(), cleanedArtiste).Result()
+		cachedResult, err := s.RedisClient.Get(ctx, cleanedArtiste).Result()
 		if err != nil {
-			log.Printf("\n[services][spotify][base][SearchTrackWithTitle] error - could not get cached result for track. This is an unexpected error: %v\n", err)
+			l.Error("[services][spotify][base][SearchTrackWithTitle] error - could not get cached result for track. This is an unexpected error", zap.Error(err))
 			return nil, err
 		}
 		err = json.Unmarshal([]byte(cachedResult), &result)
 		if err != nil {
-			log.Printf("\n[services][spotify][base][SearchTrackWithTitle] error - could not unmarshal cached result: %v\n", err)
+			l.Error("[services][spotify][base][SearchTrackWithTitle] error - could not unmarshal cached result", zap.Error(err))
 			return nil, err
 		}
 		return result, nil
 	}
 
-	spotifySearch := s.fetchSingleTrack(searchData)
+	spotifySearch := s.fetchSingleTrack(ctx, searchData)
 	if spotifySearch == nil {
-		log.Printf("\n[controllers][platforms][spotify][ConvertPlaylist] error - error fetching single track on spotify\n")
+		l.Error("[controllers][platforms][spotify][ConvertPlaylist] error - error fetching single track on spotify")
 		// panic for now.. at least until i figure out how to handle it if it can fail at all or not or can fail but be taken care of
 		return nil, blueprint.EnoResult
 	}
@@ -153,11 +162,11 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 	// a similar problem where a result is empty but not detected as omittedTrack comes up again for spotify,
 	// then we should check here and do the former.
 	if len(spotifySearch.Tracks.Tracks) == 0 {
-		log.Printf("\n[controllers][platforms][spotify][ConvertPlaylist] error - error fetching single track on spotify\n")
+		l.Error("[controllers][platforms][spotify][ConvertPlaylist] error - error fetching single track on spotify")
 		// panic for now.. at least until i figure out how to handle it if it can fail at all or not or can fail but be taken care of
 		return nil, blueprint.EnoResult
 	}
-	log.Printf("\n[controllers][platforms][spotify][ConvertPlaylist] info - found %v tracks on spotify\n", len(spotifySearch.Tracks.Tracks))
+	l.Info("[controllers][platforms][spotify][ConvertPlaylist] info - found tracks on spotify", zap.Int("tracks", len(spotifySearch.Tracks.Tracks)))
 
 	var fullSpotifyTrack spotify.FullTrack
 
@@ -197,7 +206,7 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 
 	ok := util.CacheTrackByID(&fetchedSpotifyTrack, s.RedisClient, IDENTIFIER)
 	if !ok {
-		log.Printf("[services][platforms][spotify][ConvertPlaylist] error - could not save cached result")
+		l.Error("[services][platforms][spotify][ConvertPlaylist] error - could not save cached result")
 	}
 	return &fetchedSpotifyTrack, nil
 }
@@ -207,24 +216,26 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 // the user wants to convert. i.e the track is what the user wants to convert
 // and from the link, we can get the trackID.
 // Basically, the platform the user is trying to convert from.
-func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	// the cacheKey. scheme is "spotify:track_id"
 	cacheKey := "spotify:track:" + info.EntityID
-	cachedTrack, err := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	cachedTrack, err := s.RedisClient.Get(ctx, cacheKey).Result()
 
 	if err != nil && !errors.Is(err, redis.Nil) {
-		log.Printf("\n[services][SearchTrackWithID] error - Could not fetch record from cache. This is an unexpected error\n")
+		l.Error("[services][SearchTrackWithID] error - Could not fetch record from cache. This is an unexpected error")
 		return nil, err
 	}
+	metrics.CacheLookup(IDENTIFIER, "id", err == nil)
 
 	// we have not cached this track before
 	if err != nil && errors.Is(err, redis.Nil) {
-		log.Printf("\n[services][SearchTrackWithID] function track has not been cached")
+		l.Info("[services][SearchTrackWithID] function track has not been cached")
 		token := s.NewAuthToken()
-		client := s.NewClient(context.Background(), token)
-		results, err := client.GetTrack(context.Background(), spotify.ID(info.EntityID))
+		client := s.NewClient(ctx, token)
+		results, err := client.GetTrack(ctx, spotify.ID(info.EntityID))
 		if err != nil {
-			log.Printf("\n[services][spotify][base][FetchingSingleTrack] error - could not search for track: %v\n", err)
+			l.Error("[services][spotify][base][FetchingSingleTrack] error - could not search for track", zap.Error(err))
 			return nil, err
 		}
 
@@ -255,26 +266,26 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 		}
 
 		ok := s.WebhookSender.SendTrackEvent(s.App.WebhookAppID, &blueprint.PlaylistConversionEventTrack{
-			EventType: blueprint.PlaylistConversionTrackEvent,
-			Platform:  IDENTIFIER,
-			TaskId:    info.EntityID,
-			Track:     &out,
+			Platform: IDENTIFIER,
+			TaskId:   info.EntityID,
+			Track:    &out,
+			App:      s.App.UID.String(),
 		})
 
 		if !ok {
-			log.Print("[services][platforms][spotify][base][SearchTrackWithTitle] error - could not send track webhook event")
+			l.Error("[services][platforms][spotify][base][SearchTrackWithTitle] error - could not send track webhook event")
 		}
 
 		serialized, err := json.Marshal(out)
 		if err != nil {
-			log.Printf("\n[services][spotify][base][SearchTrackWithID] error - could not serialize track: %v\n", err)
+			l.Error("[services][spotify][base][SearchTrackWithID] error - could not serialize track", zap.Error(err))
 		}
 
-		err = s.RedisClient.Set(context.Background(), cacheKey, serialized, time.Hour*24).Err()
+		err = s.RedisClient.Set(ctx, cacheKey, serialized, s.Config.Cache.TrackTTL).Err()
 		if err != nil {
-			log.Printf("\n[services][spotify][base][SearchTrackWithID] error - could not cache track: %v\n", err)
+			l.Error("[services][spotify][base][SearchTrackWithID] error - could not cache track", zap.Error(err))
 		} else {
-			log.Printf("\n[services][spotify][base][SearchTrackWithID] success - track cached\n")
+			l.Info("[services][spotify][base][SearchTrackWithID] success - track cached")
 		}
 		return &out, nil
 	}
@@ -282,39 +293,39 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 	var deserializedTrack blueprint.TrackSearchResult
 	err = json.Unmarshal([]byte(cachedTrack), &deserializedTrack)
 	if err != nil {
-		log.Printf("\n[services][SearchTrackWithID] error - Could not deserialize track from cache\n")
+		l.Error("[services][SearchTrackWithID] error - Could not deserialize track from cache")
 		return nil, err
 	}
 	return &deserializedTrack, nil
 }
 
 // FetchPlaylistMetaInfo fetches metadata for a playlist. It'll always return the latest metadata as we hit the Spotify API.
-func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+func (s *Service) FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+	l := logger.FromContext(ctx)
 	token := s.NewAuthToken()
 	if token == nil {
-		log.Printf("\n[services][spotify][base][SearchPlaylistWithID] error - could not fetch token\n")
+		l.Error("[services][spotify][base][SearchPlaylistWithID] error - could not fetch token")
 		return nil, errors.New("could not fetch token")
 	}
 
-	ctx := context.Background()
 	client := s.NewClient(ctx, token)
 	options := spotify.Fields("description,uri,external_urls,snapshot_id,name,images,owner,tracks(total,items(track))")
 
 	cacheKey := util.FormatPlatformConversionCacheKey(info.EntityID, IDENTIFIER)
-	_, cacheErr := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	_, cacheErr := s.RedisClient.Get(ctx, cacheKey).Result()
 
 	if cacheErr != nil && !errors.Is(cacheErr, redis.Nil) {
-		log.Printf("\n[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache\n")
+		l.Error("[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache")
 		return nil, cacheErr
 	}
 
-	_, snapshotErr := s.RedisClient.Get(context.Background(), util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
+	_, snapshotErr := s.RedisClient.Get(ctx, util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
 	if snapshotErr != nil && !errors.Is(snapshotErr, redis.Nil) {
-		log.Printf("\n[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache\n")
+		l.Error("[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache")
 		return nil, snapshotErr
 	}
 
-	playlistInfo, err := client.GetPlaylist(context.Background(), spotify.ID(info.EntityID), options)
+	playlistInfo, err := client.GetPlaylist(ctx, spotify.ID(info.EntityID), options)
 	if err != nil {
 		return nil, err
 	}
@@ -348,27 +359,27 @@ func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.Pl
 
 // FetchTracksForSourcePlatform fetches the tracks for a given playlist (with playlistID). Its the method used
 // to fetch the tracks in a playlist if the user is trying to convert from spotify to another platform.
-func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, resultChan chan blueprint.TrackSearchResult) error {
+func (s *Service) FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, resultChan chan blueprint.TrackSearchResult) error {
+	l := logger.FromContext(ctx)
 	token := s.NewAuthToken()
 	if token == nil {
-		log.Printf("\n[services][spotify][base][SearchPlaylistWithID] error - could not fetch token\n")
+		l.Error("[services][spotify][base][SearchPlaylistWithID] error - could not fetch token")
 		return errors.New("could not fetch token")
 	}
 
-	ctx := context.Background()
 	client := s.NewClient(ctx, token)
 
 	cacheKey := util.FormatPlatformConversionCacheKey(info.EntityID, IDENTIFIER)
-	cachedSnapshot, cacheErr := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	cachedSnapshot, cacheErr := s.RedisClient.Get(ctx, cacheKey).Result()
 
 	if cacheErr != nil && !errors.Is(cacheErr, redis.Nil) {
-		log.Printf("\n[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache\n")
+		l.Error("[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache")
 		return cacheErr
 	}
 
-	cachedSnapshotID, snapshotErr := s.RedisClient.Get(context.Background(), util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
+	cachedSnapshotID, snapshotErr := s.RedisClient.Get(ctx, util.FormatPlatformPlaylistSnapshotID(IDENTIFIER, info.EntityID)).Result()
 	if snapshotErr != nil && !errors.Is(snapshotErr, redis.Nil) {
-		log.Printf("\n[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache\n")
+		l.Error("[services][SearchPlaylistWithID] error - Could not fetch snapshot id from cache")
 		return snapshotErr
 	}
 
@@ -378,7 +389,7 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 
 		playlist, cErr := client.GetPlaylistItems(ctx, spotify.ID(info.EntityID))
 		if cErr != nil {
-			log.Printf("\n[services][spotify][base][FetchPlaylistWithID] - Could not fetch playlist from spotify: %v\n", cErr)
+			l.Error("[services][spotify][base][FetchPlaylistWithID] - Could not fetch playlist from spotify", zap.Error(cErr))
 			return cErr
 		}
 
@@ -388,7 +399,7 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 		out := *playlist
 		// fetch all pages and their tracks, we'll later append, loop over and send each track to the channel
 		for {
-			log.Printf("Page has %d tracks", len(out.Items))
+			l.Info("Page has tracks", zap.Int("tracks", len(out.Items)))
 			err := client.NextPage(ctx, &out)
 			if err == spotify.ErrNoMorePages {
 				break
@@ -436,7 +447,7 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 	playlistResult := blueprint.PlaylistSearchResult{}
 	err := json.Unmarshal([]byte(cachedSnapshot), &playlistResult)
 	if err != nil {
-		log.Printf("\n[services][spotify][base][FetchPlaylistWithID] error - could not unmarshal playlist: %v\n", err)
+		l.Error("[services][spotify][base][FetchPlaylistWithID] error - could not unmarshal playlist", zap.Error(err))
 		return err
 	}
 
@@ -462,15 +473,15 @@ func (s *Service) FetchPlaylistHash(token, playlistId string) []byte {
 	return []byte(info.SnapshotID)
 }
 
-func (s *Service) FetchUserArtists(refreshToken string) (*blueprint.UserLibraryArtists, error) {
+func (s *Service) FetchUserArtists(accessToken string) (*blueprint.UserLibraryArtists, error) {
 	log.Printf("\n[services][spotify][base][FetchUserArtists] - fetching user's libraryArtists\n")
-	client := s.NewClient(context.Background(), &oauth2.Token{RefreshToken: refreshToken})
+	client := s.NewClient(context.Background(), &oauth2.Token{AccessToken: accessToken, TokenType: "Bearer"})
 	values := url.Values{}
 	values.Set("limit", "50")
 	libraryArtists, err := client.CurrentUsersFollowedArtists(context.Background(), spotify.Limit(50))
 	if err != nil {
 		log.Printf("\n[services][spotify][base][FetchUserArtists] error - could not fetch libraryArtists: %v\n", err)
-		return nil, err
+		return nil, AuthError(err)
 	}
 	for {
 		if libraryArtists.Next == "" {
@@ -485,7 +496,7 @@ func (s *Service) FetchUserArtists(refreshToken string) (*blueprint.UserLibraryA
 		}
 		if paginationErr != nil {
 			log.Printf("\n[services][spotify][base][FetchUserArtists] error - could not fetch libraryArtists: %v\n", err)
-			return nil, paginationErr
+			return nil, AuthError(paginationErr)
 		}
 		libraryArtists.Artists = append(libraryArtists.Artists, out.Artists...)
 		libraryArtists.Next = out.Next
@@ -512,15 +523,27 @@ func (s *Service) FetchUserArtists(refreshToken string) (*blueprint.UserLibraryA
 	return &response, nil
 }
 
+// AuthError returns blueprint.ErrAccessTokenRejected, wrapping err, if err is the error of a request Spotify rejected
+// because the access token of the user is not valid anymore. The token may have been revoked before its expiry, so the
+// caller refreshes it and retries before deciding the user removed the app from their account. Other errors are
+// returned as is.
+func AuthError(err error) error {
+	var spotifyErr spotify.Error
+	if errors.As(err, &spotifyErr) && spotifyErr.Status == http.StatusUnauthorized {
+		return fmt.Errorf("%w: %v", blueprint.ErrAccessTokenRejected, err)
+	}
+	return err
+}
+
 func (s *Service) FetchListeningHistory(token string) ([]blueprint.TrackSearchResult, error) {
-	client := s.NewClient(context.Background(), &oauth2.Token{RefreshToken: token})
+	client := s.NewClient(context.Background(), &oauth2.Token{AccessToken: token, TokenType: "Bearer"})
 	recentlyPlayed, err := client.PlayerRecentlyPlayedOpt(context.Background(), &spotify.RecentlyPlayedOptions{
 		Limit: 50,
 	})
 	if err != nil {
 		log.Printf("\n[services][spotify][base][FetchUserArtists] error - could not fetch libraryArtists: %v\n", err)
 		if strings.Contains(err.Error(), "401") {
-			return nil, errors.New("unauthorized")
+			return nil, AuthError(err)
 		}
 
 		if strings.Contains(err.Error(), "403") {
@@ -569,8 +592,8 @@ func (s *Service) FetchUserInfo(authInfo blueprint.UserAuthInfoForRequests) (*bl
 	//client := spotify.New(httpClient)
 	//
 	//
-	// todo: use refreshing accessToken using the refreshToken if its expired or use accessToken if it hasnt.
-	client := s.NewClient(context.Background(), &oauth2.Token{RefreshToken: authInfo.RefreshToken})
+	// the access token is refreshed by the token manager (services/tokens) before the request.
+	client := s.NewClient(context.Background(), &oauth2.Token{AccessToken: authInfo.AccessToken, TokenType: "Bearer"})
 	user, err := client.CurrentUser(context.Background())
 	if err != nil {
 		log.Printf("\n[services][spotify][base][FetchUserInfo] error - could not fetch user info: %v\n", err)
@@ -11,13 +11,13 @@ import (
 
 // FetchUserPlaylist fetches the user's playlist
 func (s *Service) FetchLibraryPlaylists(token string) ([]blueprint.UserPlaylist, error) {
-	client := s.NewClient(context.Background(), &oauth2.Token{RefreshToken: token})
+	client := s.NewClient(context.Background(), &oauth2.Token{AccessToken: token, TokenType: "Bearer"})
 	//httpClient := spotifyauth.New(spotifyauth.WithClientID(s.IntegrationAppID), spotifyauth.WithClientSecret(s.IntegrationAppSecret)).Client(context.Background(), &oauth2.MusicToken{RefreshToken: token})
 	//client := spotify.New(httpClient)
 	playlists, err := client.CurrentUsersPlaylists(context.Background())
 	if err != nil {
 		log.Printf("\n[services][spotify][base][FetchUserPlaylist] error - could not fetch playlist: %v\n", err)
-		return nil, err
+		return nil, AuthError(err)
 	}
 	for {
 		out := spotify.SimplePlaylistPage{}
@@ -28,7 +28,7 @@ func (s *Service) FetchLibraryPlaylists(token string) ([]blueprint.UserPlaylist,
 		}
 		if paginationErr != nil {
 			log.Printf("\n[services][spotify][base][FetchUserPlaylist] error - could not fetch playlist: %v\n", err)
-			return nil, paginationErr
+			return nil, AuthError(paginationErr)
 		}
 		playlists.Playlists = append(playlists.Playlists, out.Playlists...)
 	}
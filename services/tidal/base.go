@@ -8,6 +8,9 @@ import (
 	"log"
 	"net/url"
 	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/logger"
+	"orchdio/metrics"
 	"orchdio/services/tidal/tidal_v2"
 	tidal_auth "orchdio/services/tidal/tidal_v2/auth"
 	"orchdio/util"
@@ -22,6 +25,7 @@ import (
 	"github.com/go-redis/redis/v8"
 	"github.com/nleeper/goment"
 	"github.com/vicanso/go-axios"
+	"go.uber.org/zap"
 )
 
 const ApiUrl = "https://listen.tidal.com/v1"
@@ -34,13 +38,14 @@ type Service struct {
 	Base                   string
 	App                    *blueprint.DeveloperApp
 	WebhookSender          WebhookSender
+	Config                 *config.Config
 }
 
 type WebhookSender interface {
 	SendTrackEvent(appID string, event *blueprint.PlaylistConversionEventTrack) bool
 }
 
-func NewService(credentials *blueprint.IntegrationCredentials, DB *sqlx.DB, red *redis.Client, devApp *blueprint.DeveloperApp, webhookSender WebhookSender) *Service {
+func NewService(credentials *blueprint.IntegrationCredentials, DB *sqlx.DB, red *redis.Client, devApp *blueprint.DeveloperApp, webhookSender WebhookSender, cfg *config.Config) *Service {
 	return &Service{
 		DB:                     DB,
 		Redis:                  red,
@@ -48,30 +53,33 @@ func NewService(credentials *blueprint.IntegrationCredentials, DB *sqlx.DB, red
 		Base:                   ApiUrl,
 		App:                    devApp,
 		WebhookSender:          webhookSender,
+		Config:                 cfg,
 	}
 }
 
 // SearchTrackWithID searches for a track on tidal using the tidal ID
-func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	cacheKey := "tidal:track:" + info.EntityID
-	log.Println("\n[services][tidal][SearchWithID] - cacheKey - ", cacheKey)
-	cachedTrack, err := s.Redis.Get(context.Background(), cacheKey).Result()
+	l.Info("[services][tidal][SearchWithID] - cacheKey", zap.String("key", cacheKey))
+	cachedTrack, err := s.Redis.Get(ctx, cacheKey).Result()
 	if err != nil && !errors.Is(err, redis.Nil) {
-		log.Printf("\n[services][tidal][SearchWithID] - error - Could not fetch record from the cache. This is an unexpected error %v\n", err)
+		l.Error("[services][tidal][SearchWithID] - error - Could not fetch record from the cache. This is an unexpected error", zap.Error(err))
 		return nil, err
 	}
+	metrics.CacheLookup(IDENTIFIER, "id", err == nil)
 
 	if err != nil && errors.Is(err, redis.Nil) {
-		log.Printf("\n[services][tidal][SearchWithID] - this track has not been cached before %v\n", err)
+		l.Info("[services][tidal][SearchWithID] - this track has not been cached before")
 
-		tracks, rErr := s.FetchTrackWithID(info.EntityID)
+		tracks, rErr := s.FetchTrackWithID(ctx, info.EntityID)
 
 		if rErr != nil {
 			if errors.Is(rErr, blueprint.ErrBadRequest) {
-				log.Printf("\n[services][tidal][SearchWithID] - Error fetching track conversion from TIDAL %v\n", rErr)
+				l.Error("[services][tidal][SearchWithID] - Error fetching track conversion from TIDAL", zap.Error(rErr))
 			}
 			if errors.Is(rErr, blueprint.ErrBadCredentials) {
-				log.Printf("\n[services][tidal][SearchWithID] - Error fetching track conversion from TIDAL %v\n", rErr)
+				l.Error("[services][tidal][SearchWithID] - Error fetching track conversion from TIDAL", zap.Error(rErr))
 			}
 			return nil, rErr
 		}
@@ -96,15 +104,15 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 		}
 		serialized, sErr := json.Marshal(searchResult)
 		if sErr != nil {
-			log.Printf("\n[services][tidal][SearchWithID] - could not serialize track result - %v\n", sErr)
+			l.Error("[services][tidal][SearchWithID] - could not serialize track result", zap.Error(sErr))
 			return nil, sErr
 		}
 
-		err = s.Redis.Set(context.Background(), cacheKey, serialized, time.Hour*24).Err()
+		err = s.Redis.Set(ctx, cacheKey, serialized, s.Config.Cache.TrackTTL).Err()
 		if err != nil {
-			log.Printf("\n[services][tidal][SearchWithID] - could not cache track - %v\n", err)
+			l.Error("[services][tidal][SearchWithID] - could not cache track", zap.Error(err))
 		} else {
-			log.Printf("\n[services][tidal][SearchWithID] - track cached successfully\n")
+			l.Info("[services][tidal][SearchWithID] - track cached successfully")
 		}
 		return &searchResult, nil
 	}
@@ -112,14 +120,14 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 	var deserialized blueprint.TrackSearchResult
 	err = json.Unmarshal([]byte(cachedTrack), &deserialized)
 	if err != nil {
-		log.Printf("\n[services][tidal][SearchWithID] - error - %v\n", err)
+		l.Error("[services][tidal][SearchWithID] - error", zap.Error(err))
 		return nil, err
 	}
 	return &deserialized, nil
 }
 
 // FetchTrackWithID fetches a track from tidal
-func (s *Service) FetchTrackWithID(id string) (*Track, error) {
+func (s *Service) FetchTrackWithID(ctx context.Context, id string) (*Track, error) {
 	// TODO: implement refresh token fetching the access token (if expired)
 	// TODO: find a way to add access token securely since i need to store somewhere (tidal auth api limitation)
 	// TODO: update the access token (probably store in redis)
@@ -144,7 +152,7 @@ func (s *Service) FetchTrackWithID(id string) (*Track, error) {
 		},
 	})
 	// make a request to the tidal API
-	response, err := instance.Get(fmt.Sprintf("/tracks/%s?countryCode=US", id))
+	response, err := instance.GetX(ctx, fmt.Sprintf("/tracks/%s?countryCode=US", id))
 	if err != nil {
 		return nil, err
 	}
@@ -164,12 +172,15 @@ func (s *Service) FetchTrackWithID(id string) (*Track, error) {
 }
 
 // SearchTrackWithTitle will perform a search on tidal for the track we want
-func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	cleanedArtiste := strings.ToLower(fmt.Sprintf("tidal-%s-%s", util.NormalizeString(searchData.Artists[0]), searchData.Title))
 	cacheKey := util.FormatTargetPlaylistTrackByCacheKeyTitle(IDENTIFIER, cleanedArtiste, searchData.Title)
 
-	if s.Redis.Exists(context.Background(), cacheKey).Val() == 1 {
-		cachedTrack, err := s.Redis.Get(context.Background(), cacheKey).Result()
+	cached := s.Redis.Exists(ctx, cacheKey).Val() == 1
+	metrics.CacheLookup(IDENTIFIER, "title", cached)
+	if cached {
+		cachedTrack, err := s.Redis.Get(ctx, cacheKey).Result()
 		if err != nil && !errors.Is(err, redis.Nil) {
 			return nil, err
 		}
@@ -177,16 +188,16 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 		var deserialized blueprint.TrackSearchResult
 		err = json.Unmarshal([]byte(cachedTrack), &deserialized)
 		if err != nil {
-			log.Printf("[services][platforms][tidal][SearchTrackWithID] - could not deserialiaze cached track - %v\n", err)
+			l.Error("[services][platforms][tidal][SearchTrackWithID] - could not deserialiaze cached track", zap.Error(err))
 			return nil, err
 		}
 
 		return &deserialized, nil
 	}
 
-	result, err := s.FetchSingleTrackByTitle(*searchData, requestAuthInfo)
+	result, err := s.FetchSingleTrackByTitle(ctx, *searchData, requestAuthInfo)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][tidal][SearchTrackWithTitle] - could not search track with title '%s' on tidal - %v\n", searchData.Title, err)
+		l.Error("[controllers][platforms][tidal][SearchTrackWithTitle] - could not search track with title on tidal", zap.String("title", searchData.Title), zap.Error(err))
 		return nil, err
 	}
 
@@ -194,16 +205,15 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 }
 
 // FetchSingleTrackByTitle fetches a track from tidal by title and artist
-func (s *Service) FetchSingleTrackByTitle(searchData blueprint.TrackSearchData, authInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+func (s *Service) FetchSingleTrackByTitle(ctx context.Context, searchData blueprint.TrackSearchData, authInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
 	log.Printf("[controllers][platforms][tidal][FetchSingleTrackByTitle] - searching single track by title: %s %s\n", searchData.Title, strings.Join(searchData.Artists, ","))
-	ctx := context.TODO()
 
 	config := &clientcredentials.Config{
 		ClientID:     s.IntegrationCredentials.AppID,
 		ClientSecret: s.IntegrationCredentials.AppSecret,
 		TokenURL:     tidal_auth.TokenURL,
 	}
-	token, err := config.Token(context.Background())
+	token, err := config.Token(ctx)
 	if err != nil {
 		log.Println("Could not fetch token URL for client credentials....")
 		return nil, err
@@ -302,7 +312,7 @@ func (s *Service) FetchSingleTrackByTitle(searchData blueprint.TrackSearchData,
 }
 
 // fetchPlaylistInfo returns a playlist info. An internal method called in FetchPlaylistMetaInfo.
-func (s *Service) fetchPlaylistInfo(id string) (*PlaylistInfo, error) {
+func (s *Service) fetchPlaylistInfo(ctx context.Context, id string) (*PlaylistInfo, error) {
 	accessToken, err := s.FetchNewAuthToken(s.IntegrationCredentials.AppID, s.IntegrationCredentials.AppSecret, s.IntegrationCredentials.AppRefreshToken)
 	if err != nil {
 		log.Printf("\n[controllers][platforms][tidal][FetchPlaylistInfo] - could not fetch auth token - %v\n", err)
@@ -317,7 +327,7 @@ func (s *Service) fetchPlaylistInfo(id string) (*PlaylistInfo, error) {
 		},
 	},
 	)
-	response, err := instance.Get(fmt.Sprintf("/playlists/%s?countryCode=US", id))
+	response, err := instance.GetX(ctx, fmt.Sprintf("/playlists/%s?countryCode=US", id))
 	if err != nil {
 		log.Printf("\n[controllers][platforms][tidal][FetchPlaylistInfo] - could not fetch the playlist info for %s - %v\n", err, id)
 		return nil, err
@@ -332,16 +342,17 @@ func (s *Service) fetchPlaylistInfo(id string) (*PlaylistInfo, error) {
 }
 
 // FetchTracksForSourcePlatform fetches the tracks from the source platform and sends each result to the channel as they come in.
-func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, resultChan chan blueprint.TrackSearchResult) error {
-	identifierHash := fmt.Sprintf("tidal:playlist:%s", info)
-	infoHash := fmt.Sprintf("tidal:snapshot:%s", info)
+func (s *Service) FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, resultChan chan blueprint.TrackSearchResult) error {
+	l := logger.FromContext(ctx)
+	identifierHash := fmt.Sprintf("tidal:playlist:%s", info.EntityID)
+	infoHash := fmt.Sprintf("tidal:snapshot:%s", info.EntityID)
 
-	if s.Redis.Exists(context.Background(), identifierHash).Val() == 1 {
-		log.Println("Could not find tidal track from cache")
+	if s.Redis.Exists(ctx, identifierHash).Val() == 1 {
+		l.Info("Could not find tidal track from cache")
 		// fetch the playlist playlistInfo from redis
-		cachedInfo, gErr := s.Redis.Get(context.Background(), infoHash).Result()
+		cachedInfo, gErr := s.Redis.Get(ctx, infoHash).Result()
 		if gErr != nil && !errors.Is(gErr, redis.Nil) {
-			log.Printf("\n[controllers][platforms][tidal][SearchPlaylistWithID] - could not fetch cached playlist playlistInfo - %v\n", gErr)
+			l.Error("[controllers][platforms][tidal][SearchPlaylistWithID] - could not fetch cached playlist playlistInfo", zap.Error(gErr))
 			return gErr
 		}
 
@@ -354,21 +365,21 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 		infoLastUpdated, gmErr2 := goment.New(playlistMeta.LastUpdated)
 
 		if gmErr != nil || gmErr2 != nil {
-			log.Printf("\n[controllers][platforms][tidal][SearchPlaylistWithID] - could not parse last updated time - %v - %v\n", gmErr, gmErr2)
+			l.Error("[controllers][platforms][tidal][SearchPlaylistWithID] - could not parse last updated time", zap.Error(gmErr), zap.NamedError("snapshot_error", gmErr2))
 			return gmErr2
 		}
 
 		var result *blueprint.PlaylistSearchResult
 		// fetch the cached tracks from redis.
-		cachedResult, sErr := s.Redis.Get(context.Background(), identifierHash).Result()
+		cachedResult, sErr := s.Redis.Get(ctx, identifierHash).Result()
 		if sErr != nil {
-			log.Printf("\n[services][tidal][FetchPlaylistTracksInfo] - ⚠️ error fetching key from redis. - %v\n", sErr)
+			l.Error("[services][tidal][FetchPlaylistTracksInfo] - ⚠️ error fetching key from redis", zap.Error(sErr))
 			return sErr
 		}
 		// deserialize the tracks we fetched from redis
 		jErr := json.Unmarshal([]byte(cachedResult), &result)
 		if jErr != nil {
-			log.Printf("\n[services][tidal][FetchPlaylistTracksInfo] - ⚠️ error deserializimng cache result - %v\n", jErr)
+			l.Error("[services][tidal][FetchPlaylistTracksInfo] - ⚠️ error deserializimng cache result", zap.Error(jErr))
 			return jErr
 		}
 
@@ -389,7 +400,7 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 	// playlist has not been cached... here we do fresh tracklist fetching & processing...
 	accessToken, sErr := s.FetchNewAuthToken(s.IntegrationCredentials.AppID, s.IntegrationCredentials.AppSecret, s.IntegrationCredentials.AppRefreshToken)
 	if sErr != nil {
-		log.Printf("\n[controllers][platforms][tidal][FetchPlaylistTracksInfo] - error - %v\n", sErr)
+		l.Error("[controllers][platforms][tidal][FetchPlaylistTracksInfo] - error", zap.Error(sErr))
 		return sErr
 	}
 
@@ -410,28 +421,28 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 
 	// implement pagination fetching
 	for page := 0; page <= pages; page++ {
-		response, err := instance.Get(fmt.Sprintf("/playlists/%s/items?offset=%d&limit=100&countryCode=US", info, page*100))
+		response, err := instance.GetX(ctx, fmt.Sprintf("/playlists/%s/items?offset=%d&limit=100&countryCode=US", info.EntityID, page*100))
 		if err != nil {
-			log.Printf("\n[controllers][platforms][tidal][FetchPlaylistTracksInfo] - error - %v\n", err)
+			l.Error("[controllers][platforms][tidal][FetchPlaylistTracksInfo] - error", zap.Error(err))
 			return err
 		}
 
-		log.Println("Tried to get something here")
+		l.Info("Tried to get something here")
 
 		res := &PlaylistTracks{}
 		err = json.Unmarshal(response.Data, res)
 		if err != nil {
-			log.Printf("\n[controllers][platforms][tidal][FetchPlaylistTracksInfo] - could not deserialize playlist result from tidal - %v\n", err)
+			l.Error("[controllers][platforms][tidal][FetchPlaylistTracksInfo] - could not deserialize playlist result from tidal", zap.Error(err))
 			return err
 		}
 
-		log.Println("Body response from tidal")
+		l.Info("Body response from tidal")
 		spew.Dump(string(response.Data))
 		if len(res.Items) == 0 {
 			break
 		}
 
-		log.Printf("The tidal pages are: %v", playlistResult)
+		l.Debug("The tidal pages are", zap.Any("playlist_result", playlistResult))
 		for _, item := range playlistResult.Items {
 			var artistes []string
 			for _, artist := range item.Item.Artists {
@@ -458,17 +469,18 @@ func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlis
 }
 
 // FetchPlaylistMetaInfo returns a playlist metadata. It'll always return the latest playlist metadata infoa as we hit the tidal API.
-func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
-	_ = fmt.Sprintf("tidal:playlist:%s", info)
-	log.Printf("Converting playlist with ID %s on TIDAL\n", info)
+func (s *Service) FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+	l := logger.FromContext(ctx)
+	_ = fmt.Sprintf("tidal:playlist:%s", info.EntityID)
+	l.Info("Converting playlist with ID on TIDAL", zap.String("entity_id", info.EntityID))
 
 	// infoHash represents the key for the snapshot of the playlist playlistInfo, in this case
 	// just a lasUpdated timestamp in string format.
-	_ = fmt.Sprintf("tidal:snapshot:%s", info)
+	_ = fmt.Sprintf("tidal:snapshot:%s", info.EntityID)
 
-	playlistInfo, err := s.fetchPlaylistInfo(info.EntityID)
+	playlistInfo, err := s.fetchPlaylistInfo(ctx, info.EntityID)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][tidal][FetchPlaylistTracksInfo] - could not fetch playlist playlistInfo - %v\n", err)
+		l.Error("[controllers][platforms][tidal][FetchPlaylistTracksInfo] - could not fetch playlist playlistInfo", zap.Error(err))
 		return nil, err
 	}
 
@@ -658,7 +670,7 @@ func (s *Service) CreateNewPlaylist(title, description, musicToken string, track
 }
 
 // FetchUserPlaylists - fetches the user's playlists
-func (s *Service) FetchLibraryPlaylists(refreshToken string) ([]blueprint.UserPlaylist, error) {
+func (s *Service) FetchLibraryPlaylists(accessToken string) ([]blueprint.UserPlaylist, error) {
 	log.Printf("\n[services][tidal][FetchUserPlaylists] - fetching user playlists\n")
 
 	accessToken, err := s.FetchNewAuthToken(s.IntegrationCredentials.AppID, s.IntegrationCredentials.AppSecret, s.IntegrationCredentials.AppRefreshToken)
@@ -795,7 +807,7 @@ func (s *Service) FetchUserArtists(userId string) (*blueprint.UserLibraryArtists
 	return &response, nil
 }
 
-func (s *Service) FetchListeningHistory(refreshToken string) ([]blueprint.TrackSearchResult, error) {
+func (s *Service) FetchListeningHistory(accessToken string) ([]blueprint.TrackSearchResult, error) {
 
 	return nil, blueprint.ErrNotImplemented
 }
@@ -5,12 +5,8 @@ import (
 	"fmt"
 	"log"
 	"orchdio/blueprint"
-	"orchdio/db"
 	"orchdio/services/tidal/tidal_v2"
 	tidal_auth "orchdio/services/tidal/tidal_v2/auth"
-	"orchdio/util"
-	"os"
-	"time"
 
 	"golang.org/x/oauth2"
 )
@@ -27,39 +23,10 @@ func (s *Service) Tokens(authInfo blueprint.UserAuthInfoForRequests) (*tidal_aut
 		return nil, nil, err
 	}
 
-	hasExpired, err := util.HasTokenExpired(authInfo.ExpiresIn)
-	if err != nil {
-		return nil, nil, err
-	}
-
-	var tokens *oauth2.Token
-
-	if hasExpired {
-		// refresh the token
-		refreshedTokens, err := auth.RefreshToken(context.TODO(), &oauth2.Token{
-			RefreshToken: authInfo.RefreshToken,
-		})
-		if err != nil {
-			return nil, nil, err
-		}
-		tokens = refreshedTokens
-		dbN := db.NewDB{DB: s.DB}
-		expiresIn := time.Now().Add(time.Hour).Format(time.RFC3339)
-
-		encryptedRefreshToken, err := util.Encrypt([]byte(tokens.RefreshToken), []byte(os.Getenv("ENCRYPTION_SECRET")))
-		if err != nil {
-			return nil, nil, err
-		}
-
-		updateErr := dbN.UpdateUserAppAuthTokens(authInfo.UserID, tokens.AccessToken, expiresIn, IDENTIFIER, encryptedRefreshToken)
-		if updateErr != nil {
-			return nil, nil, err
-		}
-	} else {
-		tokens = &oauth2.Token{
-			AccessToken:  authInfo.AccessToken,
-			RefreshToken: authInfo.RefreshToken,
-		}
+	// the tokens are refreshed by the token manager (services/tokens) before the request.
+	tokens := &oauth2.Token{
+		AccessToken:  authInfo.AccessToken,
+		RefreshToken: authInfo.RefreshToken,
 	}
 
 	return auth, tokens, nil
@@ -0,0 +1,422 @@
+// Package tokens manages the OAuth tokens of users on the platforms they connected to an app. It returns valid access
+// tokens, refreshing them when they have expired and saving the rotated refresh tokens.
+package tokens
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/internal/encryption"
+	"orchdio/services/spotify"
+	"orchdio/services/tidal"
+	tidal_auth "orchdio/services/tidal/tidal_v2/auth"
+	"orchdio/services/ytmusic"
+	"orchdio/util"
+	svixwebhook "orchdio/webhooks/svix"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	spotifyauth "github.com/zmb3/spotify/v2/auth"
+	"golang.org/x/oauth2"
+)
+
+var (
+	// ErrNotConnected is returned when the user has not connected the platform to the app.
+	ErrNotConnected = errors.New("user has not connected the platform")
+	// ErrRefreshTimeout is returned when the token is being refreshed by another request for too long.
+	ErrRefreshTimeout = errors.New("timed out waiting for the token to be refreshed")
+	// ErrRevokeUnsupported is returned when the platform has no way for apps to revoke a token.
+	ErrRevokeUnsupported = errors.New("platform does not support revoking tokens")
+)
+
+const (
+	// expiryMargin is how long before it expires an access token is refreshed, so that it does not expire mid-request.
+	expiryMargin = time.Minute
+	// lockTTL is how long the refresh lock of a user app is held at most.
+	lockTTL = 30 * time.Second
+	// lockWait is how long a request waits for another request refreshing the same token.
+	lockWait           = 10 * time.Second
+	lockRetryInterval  = 200 * time.Millisecond
+	defaultTokenExpiry = time.Hour
+)
+
+// unlockScript deletes the lock only if it is still held by the caller, i.e. it has not expired and been taken by
+// another request.
+var unlockScript = redis.NewScript(`if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+
+type Manager struct {
+	DB    *sqlx.DB
+	Redis *redis.Client
+	// WebhookSender sends the user_platform_auth_revoked events.
+	WebhookSender svixwebhook.SvixInterface
+	// Envelope encrypts the refresh tokens and decrypts them and the credentials of the apps.
+	Envelope *encryption.Envelope
+	// refreshToken refreshes a token on its platform, see refreshToken.
+	refreshToken func(ctx context.Context, app *blueprint.DeveloperApp, platform string, token *oauth2.Token) (*oauth2.Token, error)
+}
+
+func NewManager(db *sqlx.DB, red *redis.Client, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope) *Manager {
+	return &Manager{DB: db, Redis: red, WebhookSender: webhookSender, Envelope: envelope,
+		refreshToken: func(ctx context.Context, app *blueprint.DeveloperApp, platform string, token *oauth2.Token) (*oauth2.Token, error) {
+			return refreshToken(ctx, envelope, app, platform, token)
+		}}
+}
+
+// Token returns a valid token of the user on the platform, for the app. Expired tokens are refreshed once across all the
+// instances: the refresh is done under a redis lock, and the rotated tokens are only saved if the refresh token has not
+// changed in the meantime. Deezer and Apple Music tokens do not expire; their stored token is the access token.
+// blueprint.ErrUserAuthRevoked is returned if the platform rejected the tokens of the user before.
+func (m *Manager) Token(ctx context.Context, userId string, app *blueprint.DeveloperApp, platform string) (*oauth2.Token, error) {
+	record, token, err := m.fetch(userId, app, platform)
+	if err != nil {
+		return nil, err
+	}
+	if record.ReauthRequiredAt.Valid {
+		return nil, blueprint.ErrUserAuthRevoked
+	}
+	if !Refreshable(platform) || isFresh(token) {
+		return token, nil
+	}
+	return m.refresh(ctx, userId, app, platform, record, "")
+}
+
+// Refresh refreshes the token of the user on the platform after the platform rejected its access token before its expiry
+// (e.g. the token was revoked and the user authorized the app again on the platform). rejected is the access token that
+// was rejected: if another request has refreshed it in the meantime, the new token is returned. blueprint.ErrUserAuthRevoked
+// is returned if the platform rejects the refresh token too.
+func (m *Manager) Refresh(ctx context.Context, userId string, app *blueprint.DeveloperApp, platform, rejected string) (*oauth2.Token, error) {
+	if !Refreshable(platform) {
+		return nil, fmt.Errorf("tokens of platform %s cannot be refreshed", platform)
+	}
+	record, token, err := m.fetch(userId, app, platform)
+	if err != nil {
+		return nil, err
+	}
+	if record.ReauthRequiredAt.Valid {
+		return nil, blueprint.ErrUserAuthRevoked
+	}
+	if isFresh(token) && token.AccessToken != rejected {
+		return token, nil
+	}
+	return m.refresh(ctx, userId, app, platform, record, rejected)
+}
+
+// AccessToken returns a valid access token of the user on the platform, for the app.
+func (m *Manager) AccessToken(ctx context.Context, userId string, app *blueprint.DeveloperApp, platform string) (string, error) {
+	token, err := m.Token(ctx, userId, app, platform)
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// Disconnect revokes the token of the user on the platform where the platform supports it and wipes the tokens and
+// scopes of the user app. It returns true if the token was revoked on the platform.
+func (m *Manager) Disconnect(ctx context.Context, userId string, app *blueprint.DeveloperApp, platform string) (bool, error) {
+	_, token, err := m.fetch(userId, app, platform)
+	if err != nil {
+		return false, err
+	}
+
+	revoked := false
+	err = revokeToken(ctx, app, platform, token)
+	switch {
+	case err == nil:
+		revoked = true
+	case errors.Is(err, ErrRevokeUnsupported):
+		log.Printf("[services][tokens][Disconnect] - %s does not support revoking tokens. Only wiping the tokens of user %s\n", platform, userId)
+	default:
+		// the tokens are wiped anyway, the user asked to disconnect the platform.
+		log.Printf("[services][tokens][Disconnect] warning - could not revoke %s token of user %s: %v\n", platform, userId, err)
+	}
+
+	database := db.NewDB{DB: m.DB}
+	if err = database.DisconnectUserApp(userId, app.UID.String(), platform); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return false, ErrNotConnected
+		}
+		return false, err
+	}
+	return revoked, nil
+}
+
+// ReportAuthRevoked marks the user app as needing the user to connect the platform again, after the platform rejected
+// its tokens. The first time, a user_platform_auth_revoked event is sent to the app. It returns the payload of the event,
+// with the URL of the connect endpoint of the platform on the host passed.
+func (m *Manager) ReportAuthRevoked(userId string, app *blueprint.DeveloperApp, platform, hostname string) (*blueprint.UserPlatformAuthRevokedEventPayload, error) {
+	database := db.NewDB{DB: m.DB}
+	scopes, alreadyMarked, err := database.MarkUserAppReauthRequired(userId, app.UID.String(), platform)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotConnected
+		}
+		return nil, err
+	}
+
+	payload := &blueprint.UserPlatformAuthRevokedEventPayload{
+		UserID:       userId,
+		Platform:     platform,
+		ReconnectURL: util.AppAuthConnectURL(hostname, platform, scopes),
+		DetectedAt:   time.Now().UTC(),
+	}
+	if alreadyMarked {
+		return payload, nil
+	}
+
+	log.Printf("[services][tokens][ReportAuthRevoked] - %s authorization of user %s was revoked. Notifying app %s\n", platform, userId, app.UID.String())
+	event := blueprint.NewWebhookEvent(blueprint.UserPlatformAuthRevokedEvent, app.UID.String(), "", payload)
+	if _, whErr := m.WebhookSender.SendEvent(app.WebhookAppID, blueprint.UserPlatformAuthRevokedEvent, event); whErr != nil {
+		log.Printf("[services][tokens][ReportAuthRevoked] error - could not send auth revoked event of user %s: %v\n", userId, whErr)
+	}
+	return payload, nil
+}
+
+// refresh refreshes the token under the refresh lock of the user app. The token saved by another request in the meantime
+// is used instead, unless it is the rejected access token.
+func (m *Manager) refresh(ctx context.Context, userId string, app *blueprint.DeveloperApp, platform string, record *blueprint.UserAppTokens, rejected string) (*oauth2.Token, error) {
+	usable := func(token *oauth2.Token) bool {
+		return isFresh(token) && token.AccessToken != rejected
+	}
+	deadline := time.Now().Add(lockWait)
+	var unlock func()
+	for {
+		var acquired bool
+		var err error
+		unlock, acquired, err = TryLock(ctx, m.Redis, record.UUID)
+		if err != nil {
+			log.Printf("[services][tokens][refresh] error - could not take refresh lock of user app %s: %v\n", record.UUID, err)
+			return nil, err
+		}
+		if acquired {
+			break
+		}
+
+		// another request is refreshing the token. wait for it to save the new token and use it.
+		if time.Now().After(deadline) {
+			log.Printf("[services][tokens][refresh] error - timed out waiting for the refresh of user app %s\n", record.UUID)
+			return nil, ErrRefreshTimeout
+		}
+		time.Sleep(lockRetryInterval)
+		_, token, err := m.fetch(userId, app, platform)
+		if err != nil {
+			return nil, err
+		}
+		if usable(token) {
+			return token, nil
+		}
+	}
+	defer unlock()
+
+	// the token may have been refreshed between the first read and taking the lock.
+	record, token, err := m.fetch(userId, app, platform)
+	if err != nil {
+		return nil, err
+	}
+	if usable(token) {
+		return token, nil
+	}
+
+	refreshed, err := m.refreshToken(ctx, app, platform, token)
+	if err != nil {
+		log.Printf("[services][tokens][refresh] error - could not refresh %s token of user %s: %v\n", platform, userId, err)
+		// the refresh token is rejected once the user removed the app from their platform account.
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && retrieveErr.ErrorCode == "invalid_grant" {
+			return nil, fmt.Errorf("%w: %v", blueprint.ErrUserAuthRevoked, err)
+		}
+		return nil, err
+	}
+	// platforms that do not rotate refresh tokens do not return one.
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	if refreshed.Expiry.IsZero() {
+		refreshed.Expiry = time.Now().Add(defaultTokenExpiry)
+	}
+
+	encryptedRefreshToken, err := m.Envelope.Encrypt([]byte(refreshed.RefreshToken))
+	if err != nil {
+		log.Printf("[services][tokens][refresh] error - could not encrypt refresh token: %v\n", err)
+		return nil, err
+	}
+
+	database := db.NewDB{DB: m.DB}
+	saved, err := database.RotateUserAppTokens(record.UUID, record.RefreshToken, encryptedRefreshToken, refreshed.AccessToken, refreshed.Expiry)
+	if err != nil {
+		return nil, err
+	}
+	if !saved {
+		// the tokens were replaced while refreshing, e.g. the user authorized the app again. the new tokens win.
+		log.Printf("[services][tokens][refresh] warning - tokens of user app %s changed while refreshing. Using the new tokens\n", record.UUID)
+		_, token, err = m.fetch(userId, app, platform)
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+
+	log.Printf("[services][tokens][refresh] - refreshed %s token of user %s\n", platform, userId)
+	return refreshed, nil
+}
+
+// TryLock takes the refresh lock of the user app without waiting, and returns false if it is held by another request.
+// The jobs that replace the encrypted refresh token outside of a refresh (see services/reencrypt) take it, so that the
+// rotated token of a refresh is not refused for a token that changed in the meantime. unlock releases the lock.
+func TryLock(ctx context.Context, red *redis.Client, userAppId string) (unlock func(), acquired bool, err error) {
+	lockKey := fmt.Sprintf("token_refresh:%s", userAppId)
+	lockValue := uuid.NewString()
+	acquired, err = red.SetNX(ctx, lockKey, lockValue, lockTTL).Result()
+	if err != nil || !acquired {
+		return nil, acquired, err
+	}
+	return func() {
+		if err := unlockScript.Run(context.Background(), red, []string{lockKey}, lockValue).Err(); err != nil {
+			log.Printf("[services][tokens][TryLock] error - could not release refresh lock of user app %s: %v\n", userAppId, err)
+		}
+	}, true, nil
+}
+
+// fetch returns the stored tokens of the user app and the decrypted token.
+func (m *Manager) fetch(userId string, app *blueprint.DeveloperApp, platform string) (*blueprint.UserAppTokens, *oauth2.Token, error) {
+	database := db.NewDB{DB: m.DB}
+	record, err := database.FetchUserAppTokens(userId, app.UID.String(), platform)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil, ErrNotConnected
+		}
+		return nil, nil, err
+	}
+	// the refresh token is set everytime the user connects the platform, so a user app without one is not connected.
+	if record.RefreshToken == nil {
+		return nil, nil, ErrNotConnected
+	}
+
+	decrypted, err := m.Envelope.Decrypt(record.RefreshToken)
+	if err != nil {
+		log.Printf("[services][tokens][fetch] error - could not decrypt %s refresh token of user %s: %v\n", platform, userId, err)
+		return nil, nil, err
+	}
+
+	token := &oauth2.Token{AccessToken: record.AccessToken, RefreshToken: string(decrypted), TokenType: "Bearer"}
+	if record.ExpiresIn.Valid {
+		token.Expiry = record.ExpiresIn.Time
+	}
+	if !Refreshable(platform) {
+		token.AccessToken = string(decrypted)
+	}
+	return record, token, nil
+}
+
+// Refreshable returns true if the tokens of the platform expire and are refreshed with a refresh token.
+func Refreshable(platform string) bool {
+	return platform == spotify.IDENTIFIER || platform == tidal.IDENTIFIER
+}
+
+// isFresh returns true if the access token is set and does not expire within expiryMargin.
+func isFresh(token *oauth2.Token) bool {
+	return token.AccessToken != "" && !token.Expiry.IsZero() && time.Until(token.Expiry) > expiryMargin
+}
+
+// refreshToken refreshes the token with the app's credentials on the platform.
+func refreshToken(ctx context.Context, envelope *encryption.Envelope, app *blueprint.DeveloperApp, platform string, token *oauth2.Token) (*oauth2.Token, error) {
+	credentials, err := appCredentials(envelope, app, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	switch platform {
+	case spotify.IDENTIFIER:
+		authClient := spotifyauth.New(
+			spotifyauth.WithClientID(credentials.AppID),
+			spotifyauth.WithClientSecret(credentials.AppSecret),
+		)
+		return authClient.RefreshToken(ctx, &oauth2.Token{RefreshToken: token.RefreshToken})
+	case tidal.IDENTIFIER:
+		authClient, err := tidal_auth.NewTidalAuthClient(credentials.AppID, credentials.AppSecret, "")
+		if err != nil {
+			return nil, err
+		}
+		return authClient.RefreshToken(ctx, &oauth2.Token{RefreshToken: token.RefreshToken})
+	default:
+		return nil, fmt.Errorf("tokens of platform %s cannot be refreshed", platform)
+	}
+}
+
+// revokeToken revokes the token on the platform. YouTube Music tokens are Google tokens, revoked with the Google OAuth
+// revocation endpoint (revoking the refresh token revokes its access tokens too). Spotify, Deezer, Apple Music and TIDAL
+// do not have a token revocation endpoint for third party apps: their users remove the access of an app from their
+// account settings.
+func revokeToken(ctx context.Context, app *blueprint.DeveloperApp, platform string, token *oauth2.Token) error {
+	switch platform {
+	case ytmusic.IDENTIFIER:
+		return revokeGoogleToken(ctx, token.RefreshToken)
+	default:
+		return ErrRevokeUnsupported
+	}
+}
+
+// GoogleRevokeURL is the Google OAuth token revocation endpoint.
+var GoogleRevokeURL = "https://oauth2.googleapis.com/revoke"
+
+// revokeGoogleToken revokes the Google token. A token that is already invalid (e.g. the user removed the access of the
+// app from their Google account) is revoked already.
+func revokeGoogleToken(ctx context.Context, token string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, GoogleRevokeURL, strings.NewReader(url.Values{"token": {token}}.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+	var revokeErr struct {
+		Error string `json:"error"`
+	}
+	if res.StatusCode == http.StatusBadRequest && json.Unmarshal(body, &revokeErr) == nil && revokeErr.Error == "invalid_token" {
+		return nil
+	}
+	return fmt.Errorf("google token revocation failed with status %d: %s", res.StatusCode, body)
+}
+
+// appCredentials returns the decrypted integration credentials of the app on the platform.
+func appCredentials(envelope *encryption.Envelope, app *blueprint.DeveloperApp, platform string) (*blueprint.IntegrationCredentials, error) {
+	var encryptedCredentials []byte
+	switch platform {
+	case spotify.IDENTIFIER:
+		encryptedCredentials = app.SpotifyCredentials
+	case tidal.IDENTIFIER:
+		encryptedCredentials = app.TidalCredentials
+	}
+	if len(encryptedCredentials) == 0 {
+		return nil, fmt.Errorf("%s credentials not provided", platform)
+	}
+
+	credentialBytes, err := envelope.Decrypt(encryptedCredentials)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+	var credentials blueprint.IntegrationCredentials
+	if err = json.Unmarshal(credentialBytes, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal credentials: %w", err)
+	}
+	return &credentials, nil
+}
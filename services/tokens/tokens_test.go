@@ -0,0 +1,197 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/db/queries"
+	"orchdio/internal/encryption"
+	"orchdio/services/spotify"
+	"orchdio/services/ytmusic"
+	"os"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+var testEnvelope *encryption.Envelope
+
+func TestMain(m *testing.M) {
+	config.Set(&config.Config{Encryption: config.Encryption{Secret: "super-secure-secret-something-ff"}})
+	envelope, err := encryption.FromConfig(config.Get().Encryption)
+	if err != nil {
+		panic(err)
+	}
+	testEnvelope = envelope
+	os.Exit(m.Run())
+}
+
+const testUserAppId = "6f1c1d7e-2f4b-4d5e-9a77-1b2c3d4e5f60"
+
+// newTestManager returns a manager on a mock database and a miniredis server. refreshed counts the refreshes made on the
+// platform, which return the token or the error passed.
+func newTestManager(t *testing.T, token *oauth2.Token, refreshErr error) (*Manager, sqlmock.Sqlmock, *miniredis.Miniredis, *atomic.Int32) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	server := miniredis.RunT(t)
+
+	manager := NewManager(sqlx.NewDb(conn, "postgres"), redis.NewClient(&redis.Options{Addr: server.Addr()}), nil, testEnvelope)
+	refreshed := &atomic.Int32{}
+	manager.refreshToken = func(ctx context.Context, app *blueprint.DeveloperApp, platform string, _ *oauth2.Token) (*oauth2.Token, error) {
+		refreshed.Add(1)
+		return token, refreshErr
+	}
+	return manager, mock, server, refreshed
+}
+
+// expectTokens expects the tokens of the user app to be fetched, and returns them.
+func expectTokens(t *testing.T, mock sqlmock.Sqlmock, refreshToken []byte, accessToken string, expiry time.Time) {
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchUserAppTokens)).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid", "refresh_token", "access_token", "expires_in", "reauth_required_at"}).
+			AddRow(testUserAppId, refreshToken, accessToken, expiry, nil))
+}
+
+func encrypt(t *testing.T, plaintext string) []byte {
+	encrypted, err := testEnvelope.Encrypt([]byte(plaintext))
+	require.NoError(t, err)
+	return encrypted
+}
+
+func TestIsFresh(t *testing.T) {
+	assert.True(t, isFresh(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}))
+	// tokens expiring within the margin are refreshed before they are used.
+	assert.False(t, isFresh(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(expiryMargin / 2)}))
+	assert.False(t, isFresh(&oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(-time.Minute)}))
+	// tokens without an expiry were saved before the expiry was, their validity is unknown.
+	assert.False(t, isFresh(&oauth2.Token{AccessToken: "token"}))
+	assert.False(t, isFresh(&oauth2.Token{Expiry: time.Now().Add(time.Hour)}))
+}
+
+func TestTokenWaitsForConcurrentRefresh(t *testing.T) {
+	manager, mock, server, refreshed := newTestManager(t, nil, nil)
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+	refreshToken := encrypt(t, "refresh-token")
+	// another request holds the refresh lock of the user app.
+	require.NoError(t, server.Set("token_refresh:"+testUserAppId, "other-request"))
+
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+	// the other request has saved the refreshed token once the lock is retried.
+	expectTokens(t, mock, refreshToken, "refreshed", time.Now().Add(time.Hour))
+
+	token, err := manager.Token(context.Background(), "user", app, spotify.IDENTIFIER)
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", token.AccessToken)
+	assert.Zero(t, refreshed.Load())
+	assert.NoError(t, mock.ExpectationsWereMet())
+	// the lock of the other request is left alone.
+	value, _ := server.Get("token_refresh:" + testUserAppId)
+	assert.Equal(t, "other-request", value)
+}
+
+func TestTokenRefetchesWhenRotatedConcurrently(t *testing.T) {
+	manager, mock, server, refreshed := newTestManager(t, &oauth2.Token{AccessToken: "ours", RefreshToken: "rotated", Expiry: time.Now().Add(time.Hour)}, nil)
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+	refreshToken := encrypt(t, "refresh-token")
+
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+	// the refresh token was replaced while refreshing (e.g. the user authorized the app again): nothing is updated.
+	mock.ExpectExec(regexp.QuoteMeta(queries.RotateUserAppTokens)).
+		WithArgs(sqlmock.AnyArg(), "ours", sqlmock.AnyArg(), testUserAppId, refreshToken).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectTokens(t, mock, encrypt(t, "reauthorized-refresh-token"), "theirs", time.Now().Add(time.Hour))
+
+	token, err := manager.Token(context.Background(), "user", app, spotify.IDENTIFIER)
+	require.NoError(t, err)
+	assert.Equal(t, "theirs", token.AccessToken)
+	assert.Equal(t, "reauthorized-refresh-token", token.RefreshToken)
+	assert.Equal(t, int32(1), refreshed.Load())
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.False(t, server.Exists("token_refresh:"+testUserAppId), "the refresh lock is released")
+}
+
+func TestTokenInvalidGrant(t *testing.T) {
+	manager, mock, server, _ := newTestManager(t, nil, &oauth2.RetrieveError{ErrorCode: "invalid_grant"})
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+	refreshToken := encrypt(t, "refresh-token")
+
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+
+	_, err := manager.Token(context.Background(), "user", app, spotify.IDENTIFIER)
+	assert.ErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.False(t, server.Exists("token_refresh:"+testUserAppId))
+
+	// other refresh errors do not mean the user revoked the access of the app.
+	manager, mock, _, _ = newTestManager(t, nil, errors.New("connection reset"))
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+	expectTokens(t, mock, refreshToken, "expired", time.Now().Add(-time.Minute))
+	_, err = manager.Token(context.Background(), "user", app, spotify.IDENTIFIER)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, blueprint.ErrUserAuthRevoked)
+}
+
+func TestRevokeGoogleToken(t *testing.T) {
+	status, body := http.StatusOK, ""
+	var revoked string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		revoked = r.PostForm.Get("token")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	defer func(revokeURL string) { GoogleRevokeURL = revokeURL }(GoogleRevokeURL)
+	GoogleRevokeURL = server.URL
+
+	assert.NoError(t, revokeToken(context.Background(), nil, ytmusic.IDENTIFIER, &oauth2.Token{RefreshToken: "refresh-token"}))
+	assert.Equal(t, "refresh-token", revoked)
+
+	// the user removed the access of the app from their account already.
+	status, body = http.StatusBadRequest, `{"error": "invalid_token", "error_description": "Token expired or revoked"}`
+	assert.NoError(t, revokeToken(context.Background(), nil, ytmusic.IDENTIFIER, &oauth2.Token{RefreshToken: "refresh-token"}))
+
+	status, body = http.StatusServiceUnavailable, ""
+	assert.Error(t, revokeToken(context.Background(), nil, ytmusic.IDENTIFIER, &oauth2.Token{RefreshToken: "refresh-token"}))
+
+	assert.ErrorIs(t, revokeToken(context.Background(), nil, spotify.IDENTIFIER, &oauth2.Token{RefreshToken: "refresh-token"}), ErrRevokeUnsupported)
+}
+
+func TestRefreshRejectedToken(t *testing.T) {
+	manager, mock, _, refreshed := newTestManager(t, &oauth2.Token{AccessToken: "refreshed", Expiry: time.Now().Add(time.Hour)}, nil)
+	app := &blueprint.DeveloperApp{UID: uuid.New()}
+	refreshToken := encrypt(t, "refresh-token")
+
+	// the access token has not expired, but the platform rejected it.
+	expectTokens(t, mock, refreshToken, "rejected", time.Now().Add(time.Hour))
+	expectTokens(t, mock, refreshToken, "rejected", time.Now().Add(time.Hour))
+	mock.ExpectExec(regexp.QuoteMeta(queries.RotateUserAppTokens)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	token, err := manager.Refresh(context.Background(), "user", app, spotify.IDENTIFIER, "rejected")
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", token.AccessToken)
+	assert.Equal(t, int32(1), refreshed.Load())
+
+	// another request has refreshed the rejected token already.
+	expectTokens(t, mock, refreshToken, "refreshed", time.Now().Add(time.Hour))
+	token, err = manager.Refresh(context.Background(), "user", app, spotify.IDENTIFIER, "rejected")
+	require.NoError(t, err)
+	assert.Equal(t, "refreshed", token.AccessToken)
+	assert.Equal(t, int32(1), refreshed.Load())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
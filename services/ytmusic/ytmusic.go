@@ -7,12 +7,15 @@ import (
 	"fmt"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
+	"orchdio/logger"
+	"orchdio/metrics"
 	"orchdio/util"
 	"strings"
-	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/raitonoberu/ytmusic"
+	"go.uber.org/zap"
 )
 
 const IDENTIFIER = "ytmusic"
@@ -33,53 +36,58 @@ type Service struct {
 	IntegrationAppSecret string
 	IntegrationAppID     string
 	App                  *blueprint.DeveloperApp
+	Config               *config.Config
 }
 
-func NewService(redisClient *redis.Client, devApp *blueprint.DeveloperApp) *Service {
+func NewService(redisClient *redis.Client, devApp *blueprint.DeveloperApp, cfg *config.Config) *Service {
 	return &Service{
 		RedisClient: redisClient,
 		App:         devApp,
+		Config:      cfg,
 		// fixme(note): we dont need this for now.
 		//IntegrationAppID:     integrationAppID,
 		//IntegrationAppSecret: integrationAppSecret,
 	}
 }
 
-func (s *Service) FetchTracksForSourcePlatform(info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error {
-	log.Println("YTmusic not implemented yet...")
+func (s *Service) FetchTracksForSourcePlatform(ctx context.Context, info *blueprint.LinkInfo, playlistMeta *blueprint.PlaylistMetadata, result chan blueprint.TrackSearchResult) error {
+	logger.FromContext(ctx).Info("YTmusic not implemented yet...")
 	return blueprint.ErrNotImplemented
 }
 
-func (s *Service) FetchPlaylistMetaInfo(info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
+func (s *Service) FetchPlaylistMetaInfo(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.PlaylistMetadata, error) {
 	// todo: implement playlist meta info fetching
 	return nil, nil
 }
 
-func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithTitle(ctx context.Context, searchData *blueprint.TrackSearchData, requestAuthInfo blueprint.UserAuthInfoForRequests) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 
 	cleanedArtiste := fmt.Sprintf("ytmusic-%s-%s", util.NormalizeString(searchData.Artists[0]), searchData.Title)
 
-	if s.RedisClient.Exists(context.Background(), cleanedArtiste).Val() == 1 {
-		log.Printf("[services][ytmusic][SearchTrackWithTitle] Track found in cache: %v\n", cleanedArtiste)
-		cachedTrack, err := s.RedisClient.Get(context.Background(), cleanedArtiste).Result()
+	cached := s.RedisClient.Exists(ctx, cleanedArtiste).Val() == 1
+	metrics.CacheLookup(IDENTIFIER, "title", cached)
+	if cached {
+		l.Info("[services][ytmusic][SearchTrackWithTitle] Track found in cache", zap.String("key", cleanedArtiste))
+		cachedTrack, err := s.RedisClient.Get(ctx, cleanedArtiste).Result()
 		if err != nil {
-			log.Printf("[services][ytmusic][SearchTrackWithTitle] Error fetching track from cache: %v\n", err)
+			l.Error("[services][ytmusic][SearchTrackWithTitle] Error fetching track from cache", zap.Error(err))
 			return nil, err
 		}
 		var result blueprint.TrackSearchResult
 		err = json.Unmarshal([]byte(cachedTrack), &result)
 		if err != nil {
-			log.Printf("[services][ytmusic][SearchTrackWithTitle] Error unmarshalling cached track: %v\n", err)
+			l.Error("[services][ytmusic][SearchTrackWithTitle] Error unmarshalling cached track", zap.Error(err))
 			return nil, err
 		}
 		return &result, nil
 	}
 
-	log.Printf("[services][ytmusic][SearchTrackWithTitle] Track not found in cache, fetching from YT Music: %v\n", cleanedArtiste)
+	l.Info("[services][ytmusic][SearchTrackWithTitle] Track not found in cache, fetching from YT Music", zap.String("key", cleanedArtiste))
 	search := ytmusic.Search(fmt.Sprintf("%s %s", searchData.Artists[0], searchData.Title))
 	r, err := search.Next()
 	if err != nil {
-		log.Printf("[services][ytmusic][SearchTrackWithTitle] Error fetching track from YT Music: %v\n", err)
+		l.Error("[services][ytmusic][SearchTrackWithTitle] Error fetching track from YT Music", zap.Error(err))
 		return nil, err
 	}
 
@@ -124,13 +132,13 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 	}
 	serviceResult, err := json.Marshal(result)
 	if err != nil {
-		log.Printf("[services][ytmusic][SearchTrackWithTitle] Error marshalling track: %v\n", err)
+		l.Error("[services][ytmusic][SearchTrackWithTitle] Error marshalling track", zap.Error(err))
 		return nil, err
 	}
 	newHashIdentifier := util.HashIdentifier(fmt.Sprintf("ytmusic-%s-%s", artistes[0], track.Title))
 
 	trackResultIdentifier := util.HashIdentifier(fmt.Sprintf("ytmusic:track:%s", track.VideoID))
-	err = s.RedisClient.MSet(context.Background(), newHashIdentifier, serviceResult, trackResultIdentifier, serviceResult).Err()
+	err = s.RedisClient.MSet(ctx, newHashIdentifier, serviceResult, trackResultIdentifier, serviceResult).Err()
 	keys := map[string]interface{}{
 		newHashIdentifier:     serviceResult,
 		trackResultIdentifier: serviceResult,
@@ -140,9 +148,9 @@ func (s *Service) SearchTrackWithTitle(searchData *blueprint.TrackSearchData, re
 	// set the value to the serviceResult (which is the marshalled track result) and set the expiration to 24 hours
 	// the former is used to search for the track by its video id, and the latter is used to search for the track by its title and artiste
 	for k, v := range keys {
-		err = s.RedisClient.Set(context.Background(), k, v, time.Hour*24).Err()
+		err = s.RedisClient.Set(ctx, k, v, s.Config.Cache.TrackTTL).Err()
 		if err != nil {
-			log.Printf("[services][ytmusic][SearchTrackWithTitle] Error caching track: %v\n", err)
+			l.Error("[services][ytmusic][SearchTrackWithTitle] Error caching track", zap.Error(err))
 			return nil, err
 		}
 	}
@@ -165,20 +173,22 @@ func (s *Service) CachePlaylistTracksWithID(tracks *[]blueprint.TrackSearchResul
 }
 
 // SearchTrackWithID fetches a track from the ID using the link.
-func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+func (s *Service) SearchTrackWithID(ctx context.Context, info *blueprint.LinkInfo) (*blueprint.TrackSearchResult, error) {
+	l := logger.FromContext(ctx)
 	cacheKey := "ytmusic:track:" + info.EntityID
-	cachedTrack, err := s.RedisClient.Get(context.Background(), cacheKey).Result()
+	cachedTrack, err := s.RedisClient.Get(ctx, cacheKey).Result()
+	metrics.CacheLookup(IDENTIFIER, "id", err == nil)
 
 	if err != nil && errors.Is(err, redis.Nil) {
-		log.Printf("[services][ytmusic][SearchTrackWithLink] Track not found in cache, fetching from YT Music: %v\n", info.EntityID)
+		l.Info("[services][ytmusic][SearchTrackWithLink] Track not found in cache, fetching from YT Music", zap.String("entity_id", info.EntityID))
 		track, fErr := FetchSingleTrack(info.EntityID)
 		if fErr != nil {
-			log.Printf("[services][ytmusic][SearchTrackWithLink] Error fetching track from YT Music: %v\n", fErr)
+			l.Error("[services][ytmusic][SearchTrackWithLink] Error fetching track from YT Music", zap.Error(fErr))
 			return nil, fErr
 		}
 
 		if track == nil {
-			log.Printf("[services][ytmusic][SearchTrackWithLink] Track is nil: %v\n", info.EntityID)
+			l.Info("[services][ytmusic][SearchTrackWithLink] Track is nil", zap.String("entity_id", info.EntityID))
 			return nil, nil
 		}
 
@@ -189,7 +199,7 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 			artistes = append(artistes, artist.Name)
 		}
 
-		s.RedisClient.Set(context.Background(), cacheKey, track, time.Hour*24)
+		s.RedisClient.Set(ctx, cacheKey, track, s.Config.Cache.TrackTTL)
 		// TODO: add more fields to the result in the ytmusic library
 		thumbnail := ""
 		if len(track.Thumbnails) > 0 {
@@ -213,7 +223,7 @@ func (s *Service) SearchTrackWithID(info *blueprint.LinkInfo) (*blueprint.TrackS
 	var result blueprint.TrackSearchResult
 	err = json.Unmarshal([]byte(cachedTrack), &result)
 	if err != nil {
-		log.Printf("[services][ytmusic][SearchTrackWithLink] Error unmarshalling cached track: %v\n", err)
+		l.Error("[services][ytmusic][SearchTrackWithLink] Error unmarshalling cached track", zap.Error(err))
 		return nil, err
 	}
 	return &result, nil
@@ -224,20 +234,20 @@ func FetchPlaylistTracklist(id string, red *redis.Client) (*[]blueprint.TrackSea
 	return nil, nil
 }
 
-func (s *Service) FetchLibraryAlbums(refreshToken string) ([]blueprint.LibraryAlbum, error) {
+func (s *Service) FetchLibraryAlbums(accessToken string) ([]blueprint.LibraryAlbum, error) {
 	return nil, blueprint.ErrNotImplemented
 }
 
-func (s *Service) FetchListeningHistory(refreshToken string) ([]blueprint.TrackSearchResult, error) {
+func (s *Service) FetchListeningHistory(accessToken string) ([]blueprint.TrackSearchResult, error) {
 	return nil, blueprint.ErrNotImplemented
 }
 
-func (s *Service) FetchUserArtists(refreshToken string) (*blueprint.UserLibraryArtists, error) {
+func (s *Service) FetchUserArtists(accessToken string) (*blueprint.UserLibraryArtists, error) {
 
 	return nil, blueprint.ErrNotImplemented
 }
 
-func (s *Service) FetchLibraryPlaylists(refreshToken string) ([]blueprint.UserPlaylist, error) {
+func (s *Service) FetchLibraryPlaylists(accessToken string) ([]blueprint.UserPlaylist, error) {
 
 	return nil, blueprint.ErrNotImplemented
 }
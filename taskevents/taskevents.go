@@ -0,0 +1,180 @@
+// Package taskevents is the real time stream of the events of a task (e.g. a playlist conversion). The events are the
+// same as the webhook events sent for the task. Each event is appended to a redis list (so that a client can resume from
+// the last event it got) and published on a redis channel (so that clients get it as soon as it happens).
+package taskevents
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"orchdio/blueprint"
+	svixwebhook "orchdio/webhooks/svix"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	svix "github.com/svix/svix-webhooks/go"
+)
+
+// EventTaskFailed is the event sent on the stream (only) when a task fails. Its data is a blueprint.TaskErrorPayload.
+const EventTaskFailed = "task_failed"
+
+// Retention is how long the events of a task are kept after its last event.
+const Retention = 24 * time.Hour
+
+// Event is an event of a task stream. ID is the position of the event in the stream, starting at 1.
+type Event struct {
+	ID    int64                   `json:"id"`
+	Event *blueprint.WebhookEvent `json:"event"`
+}
+
+// IsFinal returns true if the event is the last event of the task.
+func (e *Event) IsFinal() bool {
+	return e.Event.Type == blueprint.PlaylistConversionDoneEvent || e.Event.Type == EventTaskFailed
+}
+
+func streamKey(taskId string) string {
+	return fmt.Sprintf("task_events:%s", taskId)
+}
+
+// Channel returns the redis channel the live events of the task are published on.
+func Channel(taskId string) string {
+	return fmt.Sprintf("task_events:%s:live", taskId)
+}
+
+// Publish adds the event to the stream of its task.
+func Publish(red *redis.Client, event *blueprint.WebhookEvent) error {
+	if event.TaskID == "" {
+		return fmt.Errorf("event %s has no task id", event.ID)
+	}
+	serializedEvent, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	key := streamKey(event.TaskID)
+	id, err := red.RPush(ctx, key, serializedEvent).Result()
+	if err != nil {
+		return err
+	}
+	red.Expire(ctx, key, Retention)
+
+	serializedStreamEvent, err := json.Marshal(&Event{ID: id, Event: event})
+	if err != nil {
+		return err
+	}
+	return red.Publish(ctx, Channel(event.TaskID), serializedStreamEvent).Err()
+}
+
+// PublishFailed adds the EventTaskFailed event to the stream of the task.
+func PublishFailed(red *redis.Client, app, taskId string, payload *blueprint.TaskErrorPayload) error {
+	return Publish(red, blueprint.NewWebhookEvent(EventTaskFailed, app, taskId, payload))
+}
+
+// History returns the events of the task after the event with the id passed (0 returns all the events).
+func History(ctx context.Context, red *redis.Client, taskId string, after int64) ([]Event, error) {
+	values, err := red.LRange(ctx, streamKey(taskId), after, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]Event, 0, len(values))
+	for i, value := range values {
+		var event blueprint.WebhookEvent
+		if err = json.Unmarshal([]byte(value), &event); err != nil {
+			log.Printf("[taskevents][History] error - could not deserialize event of task %s: %v\n", taskId, err)
+			continue
+		}
+		events = append(events, Event{ID: after + int64(i) + 1, Event: &event})
+	}
+	return events, nil
+}
+
+// Subscribe subscribes to the live events of the task. The caller must close the subscription.
+func Subscribe(ctx context.Context, red *redis.Client, taskId string) *redis.PubSub {
+	return red.Subscribe(ctx, Channel(taskId))
+}
+
+// ParseMessage returns the event in a message received on a subscription.
+func ParseMessage(message *redis.Message) (*Event, error) {
+	var event Event
+	err := json.Unmarshal([]byte(message.Payload), &event)
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// StreamTokenTTL is how long a stream token can be used to open (and reopen) the stream of a task.
+const StreamTokenTTL = time.Hour
+
+func streamTokenKey(taskId, token string) string {
+	return fmt.Sprintf("task_events:%s:token:%s", taskId, token)
+}
+
+// StreamToken is what a stream token was created with: the app and the key of the app. The key is checked again each
+// time the token is used, so that the token stops working once the key is revoked, expires or is rotated.
+type StreamToken struct {
+	App string `json:"app"`
+	// Key is the ID of the secret key the token was created with. It is empty if it was created with the public key.
+	Key string `json:"key,omitempty"`
+	// PublicKey is the public key the token was created with, if it was not created with a secret key.
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// NewStreamToken returns a token that opens the stream of the task for the app, for StreamTokenTTL. It is passed in the
+// query string by the clients that cannot set headers (e.g. EventSource) instead of the keys of the app, so that the
+// keys do not end up in the access logs of the proxies.
+func NewStreamToken(ctx context.Context, red *redis.Client, streamToken *StreamToken, taskId string) (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	value, err := json.Marshal(streamToken)
+	if err != nil {
+		return "", err
+	}
+	if err = red.Set(ctx, streamTokenKey(taskId, token), value, StreamTokenTTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// FetchStreamToken returns what the stream token of the task was created with. It returns redis.Nil if the token is
+// unknown, expired or was created for another task.
+func FetchStreamToken(ctx context.Context, red *redis.Client, taskId, token string) (*StreamToken, error) {
+	value, err := red.Get(ctx, streamTokenKey(taskId, token)).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	var streamToken StreamToken
+	if err = json.Unmarshal(value, &streamToken); err != nil {
+		return nil, err
+	}
+	return &streamToken, nil
+}
+
+// Sender is a webhook sender that also adds the events of tasks to their stream.
+type Sender struct {
+	svixwebhook.SvixInterface
+	Red *redis.Client
+}
+
+// NewSender returns a webhook sender that adds the events sent with the webhook sender passed to the task streams.
+func NewSender(sender svixwebhook.SvixInterface, red *redis.Client) *Sender {
+	return &Sender{SvixInterface: sender, Red: red}
+}
+
+func (s *Sender) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
+	event := blueprint.ToWebhookEvent(eventType, payload)
+	if event.TaskID != "" && !event.Test {
+		if err := Publish(s.Red, event); err != nil {
+			log.Printf("[taskevents][SendEvent] error - could not publish event %s of task %s: %v\n", event.Type, event.TaskID, err)
+		}
+	}
+	return s.SvixInterface.SendEvent(appId, eventType, event)
+}
@@ -0,0 +1,78 @@
+package taskevents
+
+import (
+	"context"
+	"orchdio/blueprint"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedis(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	red := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = red.Close() })
+	return red
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	red := newTestRedis(t)
+	ctx := context.Background()
+
+	subscription := Subscribe(ctx, red, "task")
+	defer subscription.Close()
+	_, err := subscription.Receive(ctx)
+	require.NoError(t, err)
+
+	require.NoError(t, Publish(red, blueprint.NewWebhookEvent(blueprint.PlaylistConversionMetadataEvent, "app", "task", nil)))
+	require.NoError(t, Publish(red, blueprint.NewWebhookEvent(blueprint.PlaylistConversionDoneEvent, "app", "task", nil)))
+	// the events of other tasks are neither in the stream nor published on the channel of the task.
+	require.NoError(t, Publish(red, blueprint.NewWebhookEvent(blueprint.PlaylistConversionDoneEvent, "app", "other", nil)))
+
+	for _, expected := range []struct {
+		id    int64
+		event string
+		final bool
+	}{{1, blueprint.PlaylistConversionMetadataEvent, false}, {2, blueprint.PlaylistConversionDoneEvent, true}} {
+		select {
+		case message := <-subscription.Channel():
+			event, err := ParseMessage(message)
+			require.NoError(t, err)
+			assert.Equal(t, expected.id, event.ID)
+			assert.Equal(t, expected.event, event.Event.Type)
+			assert.Equal(t, expected.final, event.IsFinal())
+		case <-time.After(time.Second):
+			t.Fatalf("event %d was not published", expected.id)
+		}
+	}
+
+	events, err := History(ctx, red, "task", 1)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, int64(2), events[0].ID)
+	assert.Equal(t, blueprint.PlaylistConversionDoneEvent, events[0].Event.Type)
+	assert.Equal(t, Retention, red.TTL(ctx, streamKey("task")).Val())
+
+	assert.Error(t, Publish(red, blueprint.NewWebhookEvent(blueprint.PlaylistConversionDoneEvent, "app", "", nil)))
+}
+
+func TestStreamToken(t *testing.T) {
+	red := newTestRedis(t)
+	ctx := context.Background()
+
+	token, err := NewStreamToken(ctx, red, &StreamToken{App: "app", Key: "key"}, "task")
+	require.NoError(t, err)
+	assert.Len(t, token, 48)
+
+	streamToken, err := FetchStreamToken(ctx, red, "task", token)
+	require.NoError(t, err)
+	assert.Equal(t, &StreamToken{App: "app", Key: "key"}, streamToken)
+	assert.Equal(t, StreamTokenTTL, red.TTL(ctx, streamTokenKey("task", token)).Val())
+
+	_, err = FetchStreamToken(ctx, red, "other", token)
+	assert.ErrorIs(t, err, redis.Nil)
+}
@@ -12,21 +12,21 @@ import (
 )
 
 type MockQueue struct {
-	mockNewTask          func(taskType, queue string, retry int, payload []byte) (*asynq.Task, error)
-	mockEnqueueTask      func(task *asynq.Task, queue, taskId string, processIn time.Duration) error
+	mockNewTask          func(ctx context.Context, taskType, queue string, retry int, payload []byte) (*asynq.Task, error)
+	mockEnqueueTask      func(ctx context.Context, task *asynq.Task, queue, taskId string, processIn time.Duration) error
 	mockRunTask          func(pattern string, handler func(context.Context, *asynq.Task) error)
 	mockNewPlaylistQueue func(entityID string, payload *blueprint.LinkInfo) (*asynq.Task, error)
-	mockPlaylistHandler  func(uid, shorturl string, info *blueprint.LinkInfo, appId string) error
+	mockPlaylistHandler  func(ctx context.Context, uid, shorturl string, info *blueprint.LinkInfo, appId string) error
 	mockSendEmail        func(emailData *blueprint.EmailTaskData) error
 }
 
-func (m *MockQueue) NewTask(taskType, queue string, retry int, payload []byte) (*asynq.Task, error) {
+func (m *MockQueue) NewTask(ctx context.Context, taskType, queue string, retry int, payload []byte) (*asynq.Task, error) {
 	return nil, nil
 }
 
-func (m *MockQueue) EnqueueTask(task *asynq.Task, queue, taskId string, processIn time.Duration) error {
+func (m *MockQueue) EnqueueTask(ctx context.Context, task *asynq.Task, queue, taskId string, processIn time.Duration) error {
 	// if m.mockEnqueueTask != nil {
-	// 	return m.mockEnqueueTask(task, queue, taskId, processIn)
+	// 	return m.mockEnqueueTask(ctx, task, queue, taskId, processIn)
 	// }
 
 	log.Println("Mocked enqueue task method")
@@ -44,7 +44,7 @@ func (m *MockQueue) PlaylistTaskHandler(ctx context.Context, task *asynq.Task) e
 	return nil
 }
 
-func (m *MockQueue) PlaylistHandler(uid, shorturl string, info *blueprint.LinkInfo, appId string) error {
+func (m *MockQueue) PlaylistHandler(ctx context.Context, uid, shorturl string, info *blueprint.LinkInfo, appId string) error {
 	return nil
 }
 
@@ -61,16 +61,16 @@ func NewMockQueue(asynqClient *asynq.Client, db *sqlx.DB, red *redis.Client, rou
 	return &MockQueue{}
 }
 
-func (m *MockQueue) WithMockNewTask(fn func(taskType, queue string, retry int, payload []byte) (*asynq.Task, error)) *MockQueue {
+func (m *MockQueue) WithMockNewTask(fn func(ctx context.Context, taskType, queue string, retry int, payload []byte) (*asynq.Task, error)) *MockQueue {
 	// m.mockNewTask = fn
 	// return m
 	return nil
 }
 
-func (m *MockQueue) WithMockEnqueueTask(fn func(task *asynq.Task, queue, taskId string, processIn time.Duration) error) *MockQueue {
+func (m *MockQueue) WithMockEnqueueTask(fn func(ctx context.Context, task *asynq.Task, queue, taskId string, processIn time.Duration) error) *MockQueue {
 	return nil
 }
 
-func (m *MockQueue) WithMockPlaylistHandler(fn func(uid, shorturl string, info *blueprint.LinkInfo, appId string) error) *MockQueue {
+func (m *MockQueue) WithMockPlaylistHandler(fn func(ctx context.Context, uid, shorturl string, info *blueprint.LinkInfo, appId string) error) *MockQueue {
 	return nil
 }
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/controllers/account"
 	"orchdio/controllers/developer"
 	"orchdio/controllers/platforms"
 	"orchdio/db"
+	"orchdio/internal/encryption"
 	"orchdio/middleware"
+	"orchdio/services/ratelimit"
 	"os"
 
 	"github.com/go-redis/redis/v8"
@@ -72,13 +75,18 @@ func SetupTestSuite() *TestSuite {
 
 	mockQueue := &MockQueue{}
 	svixInstance := &MockSvix{}
+	cfg := config.Get()
+	envelope, err := encryption.FromConfig(cfg.Encryption)
+	if err != nil {
+		panic(err)
+	}
 
 	app := fiber.New()
 	authMiddleware := middleware.NewAuthMiddleware(dbase)
-	platformsHandler := platforms.NewPlatform(redisClient, dbase, mockQueue, svixInstance)
-	userController := account.NewUserController(dbase, redisClient, mockQueue)
+	platformsHandler := platforms.NewPlatform(redisClient, dbase, mockQueue, svixInstance, envelope, cfg)
+	userController := account.NewUserController(dbase, redisClient, mockQueue, svixInstance, envelope, cfg)
 
-	devAppHandler := developer.NewDeveloperController(dbase, svixInstance)
+	devAppHandler := developer.NewDeveloperController(dbase, svixInstance, ratelimit.NewLimiter(redisClient, ratelimit.DefaultPlans), envelope, cfg)
 
 	// get JWT secret from environment or use test default
 	// todo: remove this when test .env is figured out
@@ -89,7 +97,7 @@ func SetupTestSuite() *TestSuite {
 	}
 
 	app.Post("/v1/org/new", userController.CreateOrg)
-	app.Post("/v1/track/convert", authMiddleware.AddReadOnlyDeveloperToContext, middleware.ExtractLinkInfoFromBody, platformsHandler.ConvertTrack)
+	app.Post("/v1/track/convert", authMiddleware.AddReadOnlyDeveloperToContext, middleware.ExtractLinkInfoFromBody(cfg.Platforms), platformsHandler.ConvertTrack)
 
 	orgRouter := app.Group("/v1/org")
 	orgRouter.Use(jwtware.New(jwtware.Config{
@@ -104,7 +112,7 @@ func SetupTestSuite() *TestSuite {
 		},
 	}), middleware.VerifyAppJWT)
 
-	orgRouter.Post("/:orgId/app/new", devAppHandler.CreateApp)
+	orgRouter.Post("/:orgId/app/new", authMiddleware.RequireOrgRole(blueprint.OrgRoleDeveloper), devAppHandler.CreateApp)
 	appRouter := app.Group("/v1/app")
 
 	appRouter.Use(jwtware.New(jwtware.Config{
@@ -119,7 +127,7 @@ func SetupTestSuite() *TestSuite {
 		},
 	}), middleware.VerifyAppJWT)
 
-	appRouter.Patch("/:appId", devAppHandler.UpdateApp)
+	appRouter.Patch("/:appId", authMiddleware.RequireAppRole(blueprint.OrgRoleDeveloper), devAppHandler.UpdateApp)
 
 	go func() {
 		app.Listen(":4200")
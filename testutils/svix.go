@@ -3,6 +3,7 @@ package testutils
 import (
 	"log"
 	"orchdio/blueprint"
+	"time"
 
 	svix "github.com/svix/svix-webhooks/go"
 )
@@ -47,3 +48,33 @@ func (m *MockSvix) UpdateEndpoint(appId, endpointId, endpoint string) (*svix.End
 	log.Println("Mocked UpdateEndpoint")
 	return nil, nil
 }
+
+func (m *MockSvix) UpdateEndpointEventTypes(appId, endpointId string, eventTypes []string) (*svix.EndpointOut, error) {
+	log.Println("Mocked UpdateEndpointEventTypes")
+	return nil, nil
+}
+
+func (m *MockSvix) CreateEventType(eventName, description string, schema map[string]interface{}) (*svix.EventTypeOut, error) {
+	log.Println("Mocked CreateEventType")
+	return nil, nil
+}
+
+func (m *MockSvix) ListMessages(appId string, filter *blueprint.WebhookMessageFilter) (*svix.ListResponseMessageOut, error) {
+	log.Println("Mocked ListMessages")
+	return &svix.ListResponseMessageOut{Done: true}, nil
+}
+
+func (m *MockSvix) ListMessageAttempts(appId, msgId string) (*svix.ListResponseMessageAttemptOut, error) {
+	log.Println("Mocked ListMessageAttempts")
+	return &svix.ListResponseMessageAttemptOut{Done: true}, nil
+}
+
+func (m *MockSvix) ResendMessage(appId, msgId, endpointId string) error {
+	log.Println("Mocked ResendMessage")
+	return nil
+}
+
+func (m *MockSvix) RecoverFailedMessages(appId, endpointId string, since time.Time, until *time.Time) (*svix.RecoverOut, error) {
+	log.Println("Mocked RecoverFailedMessages")
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+package tracing
+
+import (
+	"net/http"
+	"orchdio/metrics"
+
+	"github.com/gofiber/fiber/v2"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Middleware starts the span of each request, child of the trace context sent by the client if any, and passes it to
+// the handlers in the user context of the request (see logger.Context). The span is named after the route matched.
+func Middleware(ctx *fiber.Ctx) error {
+	headers := propagation.HeaderCarrier{}
+	ctx.Request().Header.VisitAll(func(key, value []byte) {
+		headers.Set(string(key), string(value))
+	})
+	parent := otel.GetTextMapPropagator().Extract(ctx.UserContext(), headers)
+	spanCtx, span := Tracer().Start(parent, ctx.Method()+" "+ctx.Path(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(ctx.Method()),
+			semconv.URLPath(ctx.Path()),
+			semconv.ClientAddress(ctx.IP()),
+		))
+	defer span.End()
+	ctx.SetUserContext(spanCtx)
+
+	err := ctx.Next()
+	status := ctx.Response().StatusCode()
+	if err != nil {
+		// the status is set by the error handler of the app, after the middlewares.
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+		span.RecordError(err)
+	}
+	route := ctx.Route().Path
+	span.SetName(ctx.Method() + " " + route)
+	span.SetAttributes(semconv.HTTPRoute(route), semconv.HTTPResponseStatusCode(status))
+	if status >= fiber.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	return err
+}
+
+// Transport returns a http.RoundTripper that traces the requests made through base, as children of the span of their
+// context. The requests made to the platforms have their platform.
+//
+// Like metrics.Transport, it is set as http.DefaultTransport at startup.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(&platformTransport{base: base},
+		otelhttp.WithSpanNameFormatter(func(_ string, req *http.Request) string {
+			return "HTTP " + req.Method + " " + req.URL.Host
+		}))
+}
+
+// platformTransport sets the platform of the requests on their span, started by otelhttp.
+type platformTransport struct {
+	base http.RoundTripper
+}
+
+func (t *platformTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if platform := metrics.PlatformFromHost(req.URL.Host); platform != "" {
+		trace.SpanFromContext(req.Context()).SetAttributes(AttrPlatform.String(platform))
+	}
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,60 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/hibiken/asynq"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TaskHeaders returns the headers carrying the trace context of ctx, to be set on the tasks enqueued, or nil if ctx
+// has no trace.
+func TaskHeaders(ctx context.Context) map[string]string {
+	if ctx == nil {
+		return nil
+	}
+	headers := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, headers)
+	if len(headers) == 0 {
+		return nil
+	}
+	return headers
+}
+
+// StartEnqueue starts the span of the enqueueing of a task in the queue. The span must be ended, usually with End.
+func StartEnqueue(ctx context.Context, queue, taskId string) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, "enqueue "+queue, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("asynq"),
+			semconv.MessagingDestinationName(queue),
+			AttrTaskID.String(taskId),
+		))
+}
+
+// TaskMiddleware starts the span of each task processed, child of the trace context in the headers of the task if
+// any, and passes it to the task handlers in their context.
+func TaskMiddleware(h asynq.Handler) asynq.Handler {
+	return asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		queue, _ := asynq.GetQueueName(ctx)
+		taskId, _ := asynq.GetTaskID(ctx)
+		retried, _ := asynq.GetRetryCount(ctx)
+
+		parent := otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(task.Headers()))
+		ctx, span := Tracer().Start(parent, "process "+queue, trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(
+				semconv.MessagingSystemKey.String("asynq"),
+				semconv.MessagingDestinationName(queue),
+				AttrTaskID.String(taskId),
+				AttrRetry.Int(retried),
+			))
+		err := h.ProcessTask(ctx, task)
+		End(span, err)
+		return err
+	})
+}
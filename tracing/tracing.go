@@ -0,0 +1,111 @@
+// Package tracing is the OpenTelemetry tracing of Orchdio. The requests, the tasks of the queues and the stages of the
+// conversions (the searches on the platforms, the webhook events, etc.) are spans, and the trace of a request is
+// carried in the headers of the tasks it enqueues, so that a playlist conversion is a single trace from the request to
+// the last webhook event.
+//
+// The spans are exported with OTLP over HTTP when OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT)
+// is set, and dropped otherwise.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "orchdio"
+
+// The attributes of the spans, in addition to the ones of the OpenTelemetry semantic conventions.
+const (
+	AttrAppID          = attribute.Key("orchdio.app_id")
+	AttrTaskID         = attribute.Key("orchdio.task_id")
+	AttrPlatform       = attribute.Key("orchdio.platform")
+	AttrTargetPlatform = attribute.Key("orchdio.target_platform")
+	AttrEntity         = attribute.Key("orchdio.entity")
+	AttrEventType      = attribute.Key("orchdio.event_type")
+	AttrRetry          = attribute.Key("orchdio.retry")
+)
+
+// Init sets up the tracer provider of the process and the propagation of the trace context (W3C trace context and
+// baggage). Without an OTLP endpoint, the tracer provider is a no-op. The function returned flushes the spans and
+// stops the exporter; it must be called before the process exits.
+//
+// The sampling ratio of the new traces is OTEL_TRACES_SAMPLER_RATIO, 1 by default. The spans of the traces started
+// by another service (e.g. the trace context sent by a client) follow its sampling decision.
+func Init(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		log.Printf("[tracing][Init] - no OTLP endpoint set, the spans are not exported")
+		return func(context.Context) error { return nil }, nil
+	}
+
+	ratio := 1.0
+	if r := os.Getenv("OTEL_TRACES_SAMPLER_RATIO"); r != "" {
+		parsed, err := strconv.ParseFloat(r, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			return nil, fmt.Errorf("invalid OTEL_TRACES_SAMPLER_RATIO %q. Please use a number between 0 and 1", r)
+		}
+		ratio = parsed
+	}
+
+	// the endpoint, headers, etc. of the exporter are read from the standard OTEL_EXPORTER_OTLP_* variables.
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not create the OTLP exporter: %w", err)
+	}
+
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "orchdio"
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.DeploymentEnvironment(os.Getenv("ORCHDIO_ENV")),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("could not create the tracing resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	log.Printf("[tracing][Init] - exporting spans of %s with OTLP, sampling %v of the traces", serviceName, ratio)
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of Orchdio.
+func Tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Start starts a span, child of the span in ctx if any. The span must be ended, usually with End.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span, with an error status if err is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
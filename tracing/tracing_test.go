@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/hibiken/asynq"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func record(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(previous) })
+	return recorder
+}
+
+func TestTaskTraceContext(t *testing.T) {
+	recorder := record(t)
+
+	ctx, span := StartEnqueue(context.Background(), "playlist_conversion", "task-1")
+	headers := TaskHeaders(ctx)
+	require.NotEmpty(t, headers)
+	task := asynq.NewTaskWithHeaders("playlist_conversion_task-1", []byte(`{}`), headers)
+	span.End()
+
+	var handlerSpan trace.SpanContext
+	handler := TaskMiddleware(asynq.HandlerFunc(func(ctx context.Context, task *asynq.Task) error {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+		return nil
+	}))
+	require.NoError(t, handler.ProcessTask(context.Background(), task))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, spans[1].SpanContext().SpanID(), handlerSpan.SpanID())
+	assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind())
+
+	// without a trace, the tasks have no headers.
+	assert.Nil(t, TaskHeaders(context.Background()))
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := record(t)
+
+	app := fiber.New()
+	app.Use(Middleware)
+	app.Get("/v1/track/:trackId", func(ctx *fiber.Ctx) error {
+		_, span := Start(ctx.UserContext(), "service.ConvertTrack")
+		span.End()
+		return ctx.SendStatus(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/track/1", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	res, err := app.Test(req)
+	require.NoError(t, err)
+	_ = res.Body.Close()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	child, server := spans[0], spans[1]
+	assert.Equal(t, "GET /v1/track/:trackId", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, server.SpanContext().SpanID(), child.Parent().SpanID())
+}
@@ -4,20 +4,25 @@
 package universal
 
 import (
+	"context"
 	"log"
 	"orchdio/blueprint"
+	"orchdio/config"
 	"orchdio/db"
+	"orchdio/internal/encryption"
 	platforminternal "orchdio/internal/platform"
 	serviceinternal "orchdio/internal/service"
+	"orchdio/logger"
+	"orchdio/taskevents"
 	svixwebhook "orchdio/webhooks/svix"
-	"os"
 
 	"github.com/jmoiron/sqlx"
 
 	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
 )
 
-func FetchUserPlatformsInfo(authInfo blueprint.UserAuthInfoForRequests, appId string, pg *sqlx.DB, red *redis.Client) (*blueprint.UserPlatformInfo, error) {
+func FetchUserPlatformsInfo(authInfo blueprint.UserAuthInfoForRequests, appId string, pg *sqlx.DB, red *redis.Client, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) (*blueprint.UserPlatformInfo, error) {
 	database := db.NewDB{DB: pg}
 	app, err := database.FetchAppByAppId(appId)
 
@@ -25,8 +30,7 @@ func FetchUserPlatformsInfo(authInfo blueprint.UserAuthInfoForRequests, appId st
 		log.Printf("\n[controllers][platforms][universal][FetchLibraryArtists] error - could not fetch app: %v\n", err)
 		return nil, err
 	}
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender, envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
 	userInfo, err := serviceFactory.FetchUserInfo(authInfo)
 
@@ -38,19 +42,10 @@ func FetchUserPlatformsInfo(authInfo blueprint.UserAuthInfoForRequests, appId st
 	return userInfo, nil
 }
 
-func FetchLibraryPlaylists(platform, refreshToken, appId string, pg *sqlx.DB, red *redis.Client) ([]blueprint.UserPlaylist, error) {
-
-	database := db.NewDB{DB: pg}
-	app, err := database.FetchAppByAppId(appId)
-
-	if err != nil {
-		log.Printf("\n[controllers][platforms][universal][FetchLibraryArtists] error - could not fetch library playlists: %v\n", err)
-		return nil, err
-	}
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+func FetchLibraryPlaylists(platform, accessToken string, app *blueprint.DeveloperApp, pg *sqlx.DB, red *redis.Client, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) ([]blueprint.UserPlaylist, error) {
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender, envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
-	playlists, err := serviceFactory.FetchLibraryPlaylists(platform, refreshToken)
+	playlists, err := serviceFactory.FetchLibraryPlaylists(platform, accessToken)
 
 	if err != nil {
 		log.Printf("[controllers][platforms][universal][ConvertTrack] error - could not fetch library artists: %v\n", err)
@@ -58,19 +53,10 @@ func FetchLibraryPlaylists(platform, refreshToken, appId string, pg *sqlx.DB, re
 	}
 	return playlists, nil
 }
-func FetchLibraryArtists(platform, refreshToken, appId string, pg *sqlx.DB, red *redis.Client) (*blueprint.UserLibraryArtists, error) {
-
-	database := db.NewDB{DB: pg}
-	app, err := database.FetchAppByAppId(appId)
-
-	if err != nil {
-		log.Printf("\n[controllers][platforms][universal][FetchLibraryArtists] error - could not fetch app: %v\n", err)
-		return nil, err
-	}
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+func FetchLibraryArtists(platform, accessToken string, app *blueprint.DeveloperApp, pg *sqlx.DB, red *redis.Client, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) (*blueprint.UserLibraryArtists, error) {
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender, envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
-	artists, err := serviceFactory.FetchLibraryArtists(platform, refreshToken)
+	artists, err := serviceFactory.FetchLibraryArtists(platform, accessToken)
 
 	if err != nil {
 		log.Printf("[controllers][platforms][universal][FetchLibraryArtists] error - could not fetch library artists: %v\n", err)
@@ -79,19 +65,10 @@ func FetchLibraryArtists(platform, refreshToken, appId string, pg *sqlx.DB, red
 	return artists, nil
 }
 
-func FetchListeningHistory(platform, refreshToken, appId string, pg *sqlx.DB, red *redis.Client) ([]blueprint.TrackSearchResult, error) {
-
-	database := db.NewDB{DB: pg}
-	app, err := database.FetchAppByAppId(appId)
-
-	if err != nil {
-		log.Printf("\n[controllers][platforms][universal][FetchListeningHistory] error - could not fetch app: %v\n", err)
-		return nil, err
-	}
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+func FetchListeningHistory(platform, accessToken string, app *blueprint.DeveloperApp, pg *sqlx.DB, red *redis.Client, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) ([]blueprint.TrackSearchResult, error) {
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender, envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
-	libraryAlbums, err := serviceFactory.FetchListeningHistory(platform, refreshToken)
+	libraryAlbums, err := serviceFactory.FetchListeningHistory(platform, accessToken)
 
 	if err != nil {
 		log.Printf("[controllers][platforms][universal][FetchListeningHistory] error - could not fetch library albums: %v\n", err)
@@ -100,19 +77,10 @@ func FetchListeningHistory(platform, refreshToken, appId string, pg *sqlx.DB, re
 	return libraryAlbums, nil
 }
 
-func FetchLibraryAlbums(platform, refreshToken, appId string, pg *sqlx.DB, red *redis.Client) ([]blueprint.LibraryAlbum, error) {
-
-	database := db.NewDB{DB: pg}
-	app, err := database.FetchAppByAppId(appId)
-
-	if err != nil {
-		log.Printf("\n[controllers][platforms][universal][FetchLibraryAlbums] error - could not fetch app: %v\n", err)
-		return nil, err
-	}
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+func FetchLibraryAlbums(platform, accessToken string, app *blueprint.DeveloperApp, pg *sqlx.DB, red *redis.Client, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) ([]blueprint.LibraryAlbum, error) {
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender, envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
-	libraryAlbums, err := serviceFactory.FetchLibraryAlbums(platform, refreshToken)
+	libraryAlbums, err := serviceFactory.FetchLibraryAlbums(platform, accessToken)
 
 	if err != nil {
 		log.Printf("[controllers][platforms][universal][FetchLibraryAlbums] error - could not fetch library albums: %v\n", err)
@@ -122,57 +90,61 @@ func FetchLibraryAlbums(platform, refreshToken, appId string, pg *sqlx.DB, red *
 }
 
 // ConvertTrack fetches all the tracks converted from all the supported platforms
-func ConvertTrack(info *blueprint.LinkInfo, red *redis.Client, pg *sqlx.DB, webhookSender svixwebhook.SvixInterface) (*blueprint.TrackConversion, error) {
+func ConvertTrack(ctx context.Context, info *blueprint.LinkInfo, red *redis.Client, pg *sqlx.DB, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) (*blueprint.TrackConversion, error) {
+	l := logger.FromContext(ctx)
 	database := db.NewDB{DB: pg}
 	app, err := database.FetchAppByAppId(info.App)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][universal][ConvertTrack] error - could not fetch app: %v\n", err)
+		l.Error("[controllers][platforms][universal][ConvertTrack] error - could not fetch app", zap.Error(err))
 		return nil, err
 	}
+	app.Sandbox = info.Sandbox
 
 	targetPlatform := info.TargetPlatform
 	if targetPlatform == "" {
-		log.Printf("\n[controllers][platforms][universal][ConvertTrack] warning - no target platform provided\n")
+		l.Warn("[controllers][platforms][universal][ConvertTrack] warning - no target platform provided")
 		targetPlatform = "all"
 	}
 
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender, envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
 
-	convertedTrack, pErr := serviceFactory.ConvertTrack(info)
+	convertedTrack, pErr := serviceFactory.ConvertTrack(ctx, info)
 	if pErr != nil {
-		log.Printf("[controllers][platforms][universal][ConvertTrack] error - could not convert track: %v\n", err)
-		return nil, err
+		l.Error("[controllers][platforms][universal][ConvertTrack] error - could not convert track", zap.Error(pErr))
+		return nil, pErr
 	}
 
 	return convertedTrack, nil
 }
 
 // ConvertPlaylist converts a playlist from one platform to another
-func ConvertPlaylist(info *blueprint.LinkInfo, red *redis.Client, pg *sqlx.DB) (*blueprint.PlaylistConversion, error) {
+func ConvertPlaylist(ctx context.Context, info *blueprint.LinkInfo, red *redis.Client, pg *sqlx.DB, webhookSender svixwebhook.SvixInterface, envelope *encryption.Envelope, cfg *config.Config) (*blueprint.PlaylistConversion, error) {
+	l := logger.FromContext(ctx)
 	var conversion blueprint.PlaylistConversion
 	conversion.Meta.Entity = "playlist"
 
 	database := db.NewDB{DB: pg}
 	app, err := database.FetchAppByAppId(info.App)
 	if err != nil {
-		log.Printf("\n[controllers][platforms][deezer][ConvertPlaylist] error - could not fetch app %s\n", err)
+		l.Error("[controllers][platforms][deezer][ConvertPlaylist] error - could not fetch app", zap.Error(err))
 		return nil, err
 	}
+	app.Sandbox = info.Sandbox
 
 	targetPlatform := info.TargetPlatform
 	if targetPlatform == "" {
-		log.Printf("\n[controllers][platforms][deezer][ConvertPlaylist] no target platform specified %s\n", info.EntityID)
+		l.Info("[controllers][platforms][deezer][ConvertPlaylist] no target platform specified", zap.String("entity_id", info.EntityID))
 		return nil, blueprint.ErrBadRequest
 	}
 
-	webhookSender := svixwebhook.New(os.Getenv("SVIX_API_KEY"), false)
-	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, webhookSender)
+	// the conversion events are also added to the task event stream, for the clients following the task in real time.
+	platformsServiceFactory := platforminternal.NewPlatformServiceFactory(pg, red, app, taskevents.NewSender(webhookSender, red), envelope, cfg)
 	serviceFactory := serviceinternal.NewServiceFactory(platformsServiceFactory)
 
-	xConversion, xErr := serviceFactory.AsynqConvertPlaylist(info)
+	xConversion, xErr := serviceFactory.AsynqConvertPlaylist(ctx, info)
 	if xErr != nil {
-		log.Printf("Error converting playlist here in universal")
+		l.Error("[controllers][platforms][universal][ConvertPlaylist] error - could not convert playlist", zap.Error(xErr))
 		return nil, xErr
 	}
 	return xConversion, nil
@@ -4,8 +4,6 @@ package util
 // https://github.com/gtank/cryptopasta/blob/master/encrypt.go
 import (
 	"context"
-	"crypto/aes"
-	"crypto/cipher"
 	"crypto/hmac"
 	"crypto/md5"
 	"crypto/rand"
@@ -15,11 +13,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/mail"
+	"net/url"
 	"orchdio/blueprint"
-	"os"
 	"reflect"
 	"regexp"
 	"strconv"
@@ -34,54 +31,6 @@ import (
 	"golang.org/x/text/unicode/norm"
 )
 
-// Encrypt encrypts data using 256-bit AES-GCM.  This both hides the content of
-// the data and provides a check that it hasn't been altered. Output takes the
-// form nonce|ciphertext|tag where '|' indicates concatenation.
-func Encrypt(plaintext []byte, key []byte) (ciphertext []byte, err error) {
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	nonce := make([]byte, gcm.NonceSize())
-	_, err = io.ReadFull(rand.Reader, nonce)
-	if err != nil {
-		return nil, err
-	}
-
-	return gcm.Seal(nonce, nonce, plaintext, nil), nil
-}
-
-// Decrypt decrypts data using 256-bit AES-GCM.  This both hides the content of
-// the data and provides a check that it hasn't been altered. Expects input
-// form nonce|ciphertext|tag where '|' indicates concatenation.
-func Decrypt(ciphertext []byte, key []byte) (plaintext []byte, err error) {
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(ciphertext) < gcm.NonceSize() {
-		return nil, errors.New("malformed ciphertext")
-	}
-
-	return gcm.Open(nil,
-		ciphertext[:gcm.NonceSize()],
-		ciphertext[gcm.NonceSize():],
-		nil,
-	)
-}
-
 // SuccessResponse sends back a success http response to the client.
 func SuccessResponse(ctx *fiber.Ctx, statusCode int, data interface{}) error {
 	if data == nil {
@@ -107,8 +56,8 @@ func ErrorResponse(ctx *fiber.Ctx, statusCode int, err interface{}, message stri
 	})
 }
 
-// SignOrgLoginJWT signs the org login jwt token with the passed params
-func SignOrgLoginJWT(claims *blueprint.AppJWT) ([]byte, error) {
+// SignOrgLoginJWT signs the org login jwt token with the passed params and secret
+func SignOrgLoginJWT(claims *blueprint.AppJWT, secret string) ([]byte, error) {
 	to := jwt.NewWithClaims(jwt.SigningMethodHS256, &blueprint.AppJWT{
 		OrgID:       claims.OrgID,
 		DeveloperID: claims.DeveloperID,
@@ -118,8 +67,7 @@ func SignOrgLoginJWT(claims *blueprint.AppJWT) ([]byte, error) {
 		},
 	})
 
-	log.Println("DEBUG:: SIGN LOGIN TOKEN", os.Getenv("JWT_SECRET"))
-	token, err := to.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	token, err := to.SignedString([]byte(secret))
 	if err != nil {
 		log.Printf("[util]: [SignOrgLoginJWT] error -  could not sign token %v", err)
 		return nil, err
@@ -128,8 +76,8 @@ func SignOrgLoginJWT(claims *blueprint.AppJWT) ([]byte, error) {
 	return []byte(token), nil
 }
 
-// SignJwt create a new jwt token
-func SignJwt(claims *blueprint.OrchdioUserToken) ([]byte, error) {
+// SignJwt create a new jwt token, signed with secret
+func SignJwt(claims *blueprint.OrchdioUserToken, secret string) ([]byte, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &blueprint.OrchdioUserToken{
 		UUID:               claims.UUID,
 		Email:              claims.Email,
@@ -141,7 +89,7 @@ func SignJwt(claims *blueprint.OrchdioUserToken) ([]byte, error) {
 		},
 	})
 
-	jToken, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	jToken, err := token.SignedString([]byte(secret))
 	if err != nil {
 		log.Printf("[util]: [SignJwt] - Error signing token %v", err)
 		return nil, err
@@ -149,8 +97,8 @@ func SignJwt(claims *blueprint.OrchdioUserToken) ([]byte, error) {
 	return []byte(jToken), nil
 }
 
-// SignAuthJwt signs the auth jwt token with the passed params
-func SignAuthJwt(claims *blueprint.AppAuthToken) ([]byte, error) {
+// SignAuthJwt signs the auth jwt token with the passed params and secret
+func SignAuthJwt(claims *blueprint.AppAuthToken, secret string) ([]byte, error) {
 	// this token will expire in 10 mins.
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, &blueprint.AppAuthToken{
 		RegisteredClaims: jwt.RegisteredClaims{
@@ -165,7 +113,7 @@ func SignAuthJwt(claims *blueprint.AppAuthToken) ([]byte, error) {
 		Scopes:      claims.Scopes,
 	})
 
-	signedToken, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	signedToken, err := token.SignedString([]byte(secret))
 	if err != nil {
 		log.Printf("[util]: [SignAuthJwt] error -  could not sign redirect token %v", err)
 		return nil, err
@@ -173,12 +121,12 @@ func SignAuthJwt(claims *blueprint.AppAuthToken) ([]byte, error) {
 	return []byte(signedToken), nil
 }
 
-// DecodeAuthJwt parses the auth jwt token into a ```blueprint.AppAuthToken```
-func DecodeAuthJwt(token string) (*blueprint.AppAuthToken, error) {
+// DecodeAuthJwt parses the auth jwt token into a ```blueprint.AppAuthToken```, signed with secret
+func DecodeAuthJwt(token, secret string) (*blueprint.AppAuthToken, error) {
 	// decode jwt
 	decodedToken, err := jwt.ParseWithClaims(token, &blueprint.AppAuthToken{}, func(token *jwt.Token) (interface{}, error) {
 		// TODO: check the alg and signature is intact
-		return []byte(os.Getenv("JWT_SECRET")), nil
+		return []byte(secret), nil
 	})
 	if err != nil {
 		log.Printf("[util]: [DecodeAuthJwt] error -  could not decode redirect token %v", err)
@@ -372,22 +320,6 @@ func IsTaskType(tasktype, task string) bool {
 	return strings.Contains(tasktype, task)
 }
 
-func DeserializeAppCredentials(data []byte) (*blueprint.IntegrationCredentials, error) {
-	var appCredentials blueprint.IntegrationCredentials
-	decr, err := Decrypt(data, []byte(os.Getenv("ENCRYPTION_SECRET")))
-	if err != nil {
-		log.Printf("[util]: [DeserializeAppCredentials] error -  could not decrypt app credentials %v", err)
-		return nil, err
-	}
-
-	err = json.Unmarshal(decr, &appCredentials)
-	if err != nil {
-		log.Printf("[util]: [DeserializeAppCredentials] error -  could not deserialize app credentials %v", err)
-		return nil, err
-	}
-	return &appCredentials, nil
-}
-
 func DeezerIsExplicitContent(explicitContent string) bool {
 	return explicitContent == "explicit_lyrics" || explicitContent == "explicit_display"
 }
@@ -545,22 +477,6 @@ func FetchMethodFromInterface(service interface{}, method string) (reflect.Value
 	return serviceMethod, true
 }
 
-func DecryptIntegrationCredentials(encryptedCredentials []byte) (*blueprint.IntegrationCredentials, error) {
-	if len(encryptedCredentials) == 0 {
-		return nil, blueprint.ErrNoCredentials
-	}
-	decrypted, err := Decrypt(encryptedCredentials, []byte(os.Getenv("ENCRYPTION_SECRET")))
-	if err != nil {
-		return nil, err
-	}
-	var credentials blueprint.IntegrationCredentials
-	err = json.Unmarshal(decrypted, &credentials)
-	if err != nil {
-		return nil, err
-	}
-	return &credentials, nil
-}
-
 // FetchIdentifierOption returns the type of identifier being used to fetch a user info. An identifier is either
 // email or id. id is the user's Orchdio id.
 // it returns two values — a boolean and a byte. if the identifier could be fetched, it returns a true and byte
@@ -698,3 +614,54 @@ func HasTokenExpired(expiresIn string) (bool, error) {
 	now := time.Now()
 	return now.After(expiryTime), nil
 }
+
+// AppAuthConnectURL returns the URL of the endpoint that starts the authorization of the platform for an app
+// (AppAuthRedirect), on the host passed and with the scopes passed. It is called with the app's public key header and
+// returns the platform authorization URL to send the user to.
+func AppAuthConnectURL(hostname, platform string, scopes []string) string {
+	if !strings.HasPrefix(hostname, "https://") && !strings.HasPrefix(hostname, "http://") {
+		hostname = fmt.Sprintf("https://%s", hostname)
+	}
+	connectURL := fmt.Sprintf("%s/v1/auth/%s/connect", hostname, platform)
+	if len(scopes) == 0 {
+		return connectURL
+	}
+	return fmt.Sprintf("%s?scopes=%s", connectURL, url.QueryEscape(strings.Join(scopes, ",")))
+}
+
+// GenerateSecretKey generates a new secret key for an app: SecretKeyPrefix (TestSecretKeyPrefix for test keys)
+// followed by 48 random hex characters.
+func GenerateSecretKey(mode string) (string, error) {
+	random := make([]byte, 24)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	prefix := blueprint.SecretKeyPrefix
+	if mode == blueprint.AppKeyModeTest {
+		prefix = blueprint.TestSecretKeyPrefix
+	}
+	return prefix + hex.EncodeToString(random), nil
+}
+
+// HashSecretKey returns the hex SHA-256 hash of a secret key, which is what is stored and looked up.
+func HashSecretKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+// SecretKeyHint returns the prefix and the last 4 characters of a secret key, to recognize it without storing it.
+func SecretKeyHint(key string) string {
+	prefix := ""
+	if strings.HasPrefix(key, blueprint.SecretKeyPrefix) {
+		prefix = blueprint.SecretKeyPrefix
+	} else if strings.HasPrefix(key, blueprint.TestSecretKeyPrefix) {
+		prefix = blueprint.TestSecretKeyPrefix
+	}
+	return fmt.Sprintf("%s...%s", prefix, key[max(len(key)-4, 0):])
+}
+
+// IsSecretKeyFormat returns true if the key looks like a secret key: a prefixed key, or a key issued before the keys
+// were prefixed (a bare uuid).
+func IsSecretKeyFormat(key string) bool {
+	return strings.HasPrefix(key, blueprint.SecretKeyPrefix) || strings.HasPrefix(key, blueprint.TestSecretKeyPrefix) || IsValidUUID(key)
+}
@@ -0,0 +1,50 @@
+package util
+
+import (
+	"orchdio/blueprint"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSecretKey(t *testing.T) {
+	live, err := GenerateSecretKey(blueprint.AppKeyModeLive)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(live, blueprint.SecretKeyPrefix))
+	assert.Len(t, live, len(blueprint.SecretKeyPrefix)+48)
+
+	test, err := GenerateSecretKey(blueprint.AppKeyModeTest)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(test, blueprint.TestSecretKeyPrefix))
+
+	other, err := GenerateSecretKey(blueprint.AppKeyModeLive)
+	require.NoError(t, err)
+	assert.NotEqual(t, live, other)
+}
+
+func TestHashSecretKey(t *testing.T) {
+	// the hash is the hex SHA-256 of the key, it is looked up as is.
+	assert.Equal(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", HashSecretKey(""))
+	assert.Equal(t, HashSecretKey("orch_sk_live_key"), HashSecretKey("orch_sk_live_key"))
+	assert.NotEqual(t, HashSecretKey("orch_sk_live_key"), HashSecretKey("orch_sk_test_key"))
+	assert.Len(t, HashSecretKey("orch_sk_live_key"), 64)
+}
+
+func TestSecretKeyHint(t *testing.T) {
+	assert.Equal(t, "orch_sk_live_...cdef", SecretKeyHint("orch_sk_live_0123456789abcdef"))
+	assert.Equal(t, "orch_sk_test_...cdef", SecretKeyHint("orch_sk_test_0123456789abcdef"))
+	// the keys issued before the keys were prefixed.
+	assert.Equal(t, "...0000", SecretKeyHint("9b2f3c4d-1e2f-4a5b-8c7d-6e5f4a3b0000"))
+	assert.Equal(t, "...ab", SecretKeyHint("ab"))
+}
+
+func TestIsSecretKeyFormat(t *testing.T) {
+	assert.True(t, IsSecretKeyFormat("orch_sk_live_0123456789abcdef"))
+	assert.True(t, IsSecretKeyFormat("orch_sk_test_0123456789abcdef"))
+	assert.True(t, IsSecretKeyFormat(uuid.NewString()))
+	assert.False(t, IsSecretKeyFormat("sk_live_0123456789abcdef"))
+	assert.False(t, IsSecretKeyFormat(""))
+}
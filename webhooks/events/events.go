@@ -0,0 +1,87 @@
+// Package events is the catalogue of the webhook events sent to developer apps. Each event type has a JSON Schema
+// (in schemas/) describing the event envelope and its data, for the current version of the event.
+package events
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"orchdio/blueprint"
+
+	"github.com/samber/lo"
+)
+
+//go:embed schemas/*.json
+var schemas embed.FS
+
+// EventType describes a webhook event type in the catalogue.
+type EventType struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Version     int    `json:"version"`
+}
+
+// Catalogue is the list of the webhook event types that can be sent to apps.
+var Catalogue = []EventType{
+	{
+		Name:        blueprint.PlaylistConversionMetadataEvent,
+		Description: "Sent when the metadata of the playlist being converted has been fetched.",
+		Version:     blueprint.WebhookEventVersion,
+	},
+	{
+		Name:        blueprint.PlaylistConversionTrackEvent,
+		Description: "Sent when a track in the playlist being converted has been converted.",
+		Version:     blueprint.WebhookEventVersion,
+	},
+	{
+		Name:        blueprint.PlaylistConversionMissingTrackEvent,
+		Description: "Sent when a track in the playlist being converted could not be found on the target platform.",
+		Version:     blueprint.WebhookEventVersion,
+	},
+	{
+		Name:        blueprint.PlaylistConversionDoneEvent,
+		Description: "Sent when the conversion of a playlist is done.",
+		Version:     blueprint.WebhookEventVersion,
+	},
+	{
+		Name:        blueprint.UserPlatformDisconnectedEvent,
+		Description: "Sent when a platform of a user has been disconnected from the app.",
+		Version:     blueprint.WebhookEventVersion,
+	},
+	{
+		Name:        blueprint.UserPlatformAuthRevokedEvent,
+		Description: "Sent when a platform rejects the authorization of a user, e.g. because the user removed the app from their platform account.",
+		Version:     blueprint.WebhookEventVersion,
+	},
+}
+
+// Names returns the names of all the event types in the catalogue.
+func Names() []string {
+	return lo.Map(Catalogue, func(e EventType, _ int) string {
+		return e.Name
+	})
+}
+
+// IsValid returns true if the event type is in the catalogue.
+func IsValid(name string) bool {
+	return lo.ContainsBy(Catalogue, func(e EventType) bool {
+		return e.Name == name
+	})
+}
+
+// Schema returns the JSON Schema of the event type.
+func Schema(name string) (map[string]interface{}, error) {
+	if !IsValid(name) {
+		return nil, fmt.Errorf("unknown event type %s", name)
+	}
+	content, err := schemas.ReadFile(fmt.Sprintf("schemas/%s.json", name))
+	if err != nil {
+		return nil, err
+	}
+	var schema map[string]interface{}
+	err = json.Unmarshal(content, &schema)
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
@@ -0,0 +1,23 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCatalogue(t *testing.T) {
+	for _, eventType := range Catalogue {
+		schema, err := Schema(eventType.Name)
+		assert.NoError(t, err, eventType.Name)
+		assert.Equal(t, eventType.Name, schema["title"])
+
+		example, err := Example(eventType.Name)
+		assert.NoError(t, err, eventType.Name)
+		assert.NotNil(t, example, eventType.Name)
+	}
+
+	assert.False(t, IsValid("unknown_event"))
+	_, err := Schema("unknown_event")
+	assert.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package events
+
+import (
+	"fmt"
+	"orchdio/blueprint"
+	"time"
+)
+
+const exampleTaskID = "00000000-0000-0000-0000-000000000000"
+
+var exampleTrack = blueprint.TrackSearchResult{
+	URL:           "https://open.spotify.com/track/4cOdK2wGLETKBW3PvgPWqT",
+	Artists:       []string{"Rick Astley"},
+	Released:      "1987-11-12",
+	Duration:      "3:33",
+	DurationMilli: 213573,
+	Explicit:      false,
+	Title:         "Never Gonna Give You Up",
+	Preview:       "",
+	Album:         "Whenever You Need Somebody",
+	ID:            "4cOdK2wGLETKBW3PvgPWqT",
+	Cover:         "https://i.scdn.co/image/ab67616d0000b27315ebbedaacef61af244262a8",
+}
+
+var exampleTargetTrack = blueprint.TrackSearchResult{
+	URL:           "https://www.deezer.com/track/781592622",
+	Artists:       []string{"Rick Astley"},
+	Released:      "1987-11-12",
+	Duration:      "3:33",
+	DurationMilli: 213000,
+	Explicit:      false,
+	Title:         "Never Gonna Give You Up",
+	Preview:       "",
+	Album:         "Whenever You Need Somebody",
+	ID:            "781592622",
+	Cover:         "https://e-cdns-images.dzcdn.net/images/cover/fe779e632872f7c6e9f1c84ddd8ff4ff/1000x1000-000000-80-0-0.jpg",
+}
+
+// Example returns an example of the data of the event type. It is used to fire test events.
+func Example(name string) (interface{}, error) {
+	switch name {
+	case blueprint.PlaylistConversionMetadataEvent:
+		return &blueprint.PlaylistConversionEventMetadata{
+			Platform: "spotify",
+			TaskId:   exampleTaskID,
+			UniqueID: exampleTaskID,
+			Meta: &blueprint.PlaylistMetadata{
+				Length:   "3:33",
+				Title:    "Orchdio test playlist",
+				Owner:    "orchdio",
+				Cover:    exampleTrack.Cover,
+				Entity:   "playlist",
+				URL:      "https://open.spotify.com/playlist/37i9dQZF1DXcBWIGoYBM5M",
+				NBTracks: 1,
+				ID:       "37i9dQZF1DXcBWIGoYBM5M",
+			},
+		}, nil
+	case blueprint.PlaylistConversionTrackEvent:
+		return &blueprint.PlaylistTrackConversionEventResponse{
+			TaskID: exampleTaskID,
+			Tracks: []blueprint.PlaylistTrackConversionEventPayload{
+				{Platform: "spotify", Track: &exampleTrack},
+				{Platform: "deezer", Track: &exampleTargetTrack},
+			},
+		}, nil
+	case blueprint.PlaylistConversionMissingTrackEvent:
+		missingTrack := blueprint.MissingTrackMeta{
+			Platform:        "spotify",
+			MissingPlatform: "deezer",
+			Item:            exampleTrack,
+		}
+		return &blueprint.MissingTrackEventPayload{
+			TaskID:    exampleTaskID,
+			TrackMeta: missingTrack,
+			Tracks:    []blueprint.MissingTrackMeta{missingTrack},
+		}, nil
+	case blueprint.PlaylistConversionDoneEvent:
+		return &blueprint.PlaylistConversionDoneEventMetadata{
+			TaskID:         exampleTaskID,
+			PlaylistID:     "37i9dQZF1DXcBWIGoYBM5M",
+			SourcePlatform: "spotify",
+			TargetPlatform: "deezer",
+			UniqueID:       exampleTaskID,
+		}, nil
+	case blueprint.UserPlatformDisconnectedEvent:
+		return &blueprint.UserPlatformDisconnectedEventPayload{
+			UserID:         exampleTaskID,
+			Platform:       "spotify",
+			Revoked:        false,
+			DisconnectedAt: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}, nil
+	case blueprint.UserPlatformAuthRevokedEvent:
+		return &blueprint.UserPlatformAuthRevokedEventPayload{
+			UserID:       exampleTaskID,
+			Platform:     "spotify",
+			ReconnectURL: "https://api.orchdio.com/v1/auth/spotify/connect?scopes=playlist-read-private",
+			DetectedAt:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown event type %s", name)
+}
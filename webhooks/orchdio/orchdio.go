@@ -0,0 +1,543 @@
+// Package orchdiowebhook is the built-in (self-hosted) webhook sender. It implements svixwebhook.SvixInterface and delivers
+// events directly from Orchdio, without Svix: endpoints and messages are stored in Postgres, payloads are signed
+// with HMAC-SHA256 using the app's verify token and delivery happens on the queue, with exponential retries.
+package orchdiowebhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/db"
+	"orchdio/metrics"
+	svixwebhook "orchdio/webhooks/svix"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/hibiken/asynq"
+	"github.com/jmoiron/sqlx"
+	svix "github.com/svix/svix-webhooks/go"
+	"github.com/svix/svix-webhooks/go/models"
+)
+
+const (
+	// DefaultMaxRetry is the number of times a message is retried before it is dead-lettered.
+	DefaultMaxRetry = 8
+	// the headers sent alongside each webhook delivery. the receiver verifies the signature using the app's verify token.
+	HeaderWebhookID        = "x-orchdio-webhook-id"
+	HeaderWebhookTimestamp = "x-orchdio-webhook-timestamp"
+	HeaderWebhookSignature = "x-orchdio-webhook-signature"
+	HeaderWebhookEvent     = "x-orchdio-webhook-event"
+
+	retryBaseDelay     = 30 * time.Second
+	retryMaxDelay      = 12 * time.Hour
+	deliveryTimeout    = 15 * time.Second
+	maxResponseLogSize = 1024
+)
+
+// ErrEndpointNotFound is returned when an endpoint does not exist, where Svix returns an error with the status 404.
+var ErrEndpointNotFound = errors.New("webhook endpoint not found")
+
+// ErrMessageNotFound is returned when a message does not exist, where Svix returns an error with the status 404.
+var ErrMessageNotFound = errors.New("webhook message not found")
+
+// ErrInvalidIterator is returned when the iterator of a page of messages is not a message of the app. It contains "400".
+var ErrInvalidIterator = errors.New("400: invalid iterator")
+
+var _ svixwebhook.SvixInterface = (*OrchdioWebhook)(nil)
+
+type OrchdioWebhook struct {
+	DB          *sqlx.DB
+	AsynqClient *asynq.Client
+	HTTPClient  *http.Client
+	MaxRetry    int
+}
+
+// New returns a new built-in webhook sender.
+func New(db *sqlx.DB, asynqClient *asynq.Client, maxRetry int) *OrchdioWebhook {
+	if maxRetry <= 0 {
+		maxRetry = DefaultMaxRetry
+	}
+	return &OrchdioWebhook{
+		DB:          db,
+		AsynqClient: asynqClient,
+		HTTPClient:  &http.Client{Timeout: deliveryTimeout},
+		MaxRetry:    maxRetry,
+	}
+}
+
+// CreateApp returns the webhook app for the uid passed. Apps do not need to be created for the built-in sender, so
+// the uid is used as the webhook app id. No app portal is available and the portal returned is always nil.
+func (o *OrchdioWebhook) CreateApp(name, uid string) (*svix.ApplicationOut, *svix.AppPortalAccessOut, error) {
+	now := time.Now()
+	return &svix.ApplicationOut{
+		Id:        uid,
+		Uid:       &uid,
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil, nil
+}
+
+// CreateEndpoint creates a new endpoint for the webhook app. The endpoint receives all event types.
+func (o *OrchdioWebhook) CreateEndpoint(appId, uid, endpoint string) (*svix.EndpointOut, error) {
+	database := db.NewDB{DB: o.DB}
+	whEndpoint, err := database.CreateWebhookEndpoint(appId, uid, endpoint, nil)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not create new endpoint: %v\n", err)
+		return nil, err
+	}
+	return toEndpointOut(whEndpoint), nil
+}
+
+func (o *OrchdioWebhook) GetEndpoint(appId, endpoint string) (*svix.EndpointOut, error) {
+	database := db.NewDB{DB: o.DB}
+	whEndpoint, err := database.FetchWebhookEndpoint(appId, endpoint)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEndpointNotFound
+		}
+		log.Printf("[webhooks][orchdio-webhook] error - could not get endpoint: %v\n", err)
+		return nil, err
+	}
+	return toEndpointOut(whEndpoint), nil
+}
+
+func (o *OrchdioWebhook) UpdateEndpoint(appId, endpointId, endpoint string) (*svix.EndpointOut, error) {
+	database := db.NewDB{DB: o.DB}
+	whEndpoint, err := database.UpdateWebhookEndpointURL(appId, endpointId, endpoint)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEndpointNotFound
+		}
+		log.Printf("[webhooks][orchdio-webhook] error - could not update endpoint: %v\n", err)
+		return nil, err
+	}
+	return toEndpointOut(whEndpoint), nil
+}
+
+// UpdateEndpointEventTypes sets the event types an endpoint is subscribed to. An empty list subscribes the endpoint to all event types.
+func (o *OrchdioWebhook) UpdateEndpointEventTypes(appId, endpointId string, eventTypes []string) (*svix.EndpointOut, error) {
+	database := db.NewDB{DB: o.DB}
+	whEndpoint, err := database.UpdateWebhookEndpointFilterTypes(appId, endpointId, eventTypes)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrEndpointNotFound
+		}
+		log.Printf("[webhooks][orchdio-webhook] error - could not update endpoint event types: %v\n", err)
+		return nil, err
+	}
+	return toEndpointOut(whEndpoint), nil
+}
+
+// CreateEventType is a no-op for the built-in sender. The event types are the ones in the catalogue (webhooks/events)
+// and there is nothing to register.
+func (o *OrchdioWebhook) CreateEventType(eventName, description string, schema map[string]interface{}) (*svix.EventTypeOut, error) {
+	return &svix.EventTypeOut{
+		Name:        eventName,
+		Description: description,
+	}, nil
+}
+
+// SendEvent creates a message for each endpoint of the app subscribed to the event type and enqueues it for delivery.
+// The messages are all created, or none is, before they are enqueued; a message that could not be enqueued does not
+// stop the others, and the errors are returned together. The payload is sent in a versioned event envelope (blueprint.WebhookEvent), the same way it is when sent through Svix.
+func (o *OrchdioWebhook) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
+	event := blueprint.ToWebhookEvent(eventType, payload)
+	serializedBody, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not serialize event payload: %v\n", err)
+		return nil, err
+	}
+
+	database := db.NewDB{DB: o.DB}
+	endpoints, err := database.FetchWebhookEndpointsForEvent(appId, eventType)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not fetch endpoints for event: %v\n", err)
+		return nil, err
+	}
+
+	eventId := event.ID
+	if len(endpoints) == 0 {
+		log.Printf("[webhooks][orchdio-webhook] warning - no endpoint subscribed to %s for app %s. event not sent\n", eventType, appId)
+	} else {
+		endpointIds := make([]string, 0, len(endpoints))
+		for _, endpoint := range endpoints {
+			endpointIds = append(endpointIds, endpoint.UID.String())
+		}
+		messageIds, cErr := database.CreateWebhookMessages(eventId, appId, eventType, serializedBody, endpointIds)
+		if cErr != nil {
+			log.Printf("[webhooks][orchdio-webhook] error - could not create messages: %v\n", cErr)
+			return nil, cErr
+		}
+
+		var enqueueErrs []error
+		for _, messageId := range messageIds {
+			if eErr := o.enqueueDelivery(messageId, false); eErr != nil {
+				log.Printf("[webhooks][orchdio-webhook] error - could not enqueue message %s for delivery: %v\n", messageId, eErr)
+				enqueueErrs = append(enqueueErrs, fmt.Errorf("message %s: %w", messageId, eErr))
+			}
+		}
+		if len(enqueueErrs) > 0 {
+			return nil, errors.Join(enqueueErrs...)
+		}
+	}
+
+	var messagePayload map[string]interface{}
+	_ = json.Unmarshal(serializedBody, &messagePayload)
+
+	return &svix.MessageOut{
+		Id:        eventId,
+		EventType: eventType,
+		Payload:   messagePayload,
+		Timestamp: time.Now(),
+	}, nil
+}
+
+func (o *OrchdioWebhook) SendTrackEvent(appId string, out *blueprint.PlaylistConversionEventTrack) bool {
+	_, whErr := o.SendEvent(appId, blueprint.PlaylistConversionTrackEvent, blueprint.NewTrackEvent(out.App, out))
+	if whErr != nil {
+		log.Printf("\n[webhooks][orchdio-webhook] error - Could not send webhook event: %v\n", whErr)
+		return false
+	}
+	return true
+}
+
+func (o *OrchdioWebhook) SendPlaylistMetadataEvent(info *blueprint.LinkInfo, result *blueprint.PlaylistConversionEventMetadata) bool {
+	_, whEventErr := o.SendEvent(info.App, blueprint.PlaylistConversionMetadataEvent,
+		blueprint.NewWebhookEvent(blueprint.PlaylistConversionMetadataEvent, info.App, result.TaskId, result))
+	if whEventErr != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - Could not send playlist conversion metadata event %v", whEventErr)
+		return false
+	}
+	return true
+}
+
+func (o *OrchdioWebhook) enqueueDelivery(messageId string, replay bool) error {
+	payload, err := json.Marshal(&blueprint.WebhookDeliveryTaskData{MessageID: messageId})
+	if err != nil {
+		return err
+	}
+	opts := []asynq.Option{asynq.Queue(blueprint.WebhookDeliveryQueueName), asynq.MaxRetry(o.MaxRetry), asynq.Retention(24 * time.Hour)}
+	// the task of the first delivery is retained (and its id can't be reused) for a while, so replays get a new task id.
+	if !replay {
+		opts = append(opts, asynq.TaskID(messageId))
+	}
+	task := asynq.NewTask(blueprint.WebhookDeliveryTaskTypePattern, payload)
+	_, err = o.AsynqClient.Enqueue(task, opts...)
+	return err
+}
+
+// ListMessages lists the messages sent to the app, most recent first. Each message is the event sent to one endpoint.
+func (o *OrchdioWebhook) ListMessages(appId string, filter *blueprint.WebhookMessageFilter) (*svix.ListResponseMessageOut, error) {
+	database := db.NewDB{DB: o.DB}
+	// the iterator is the id of the last message of the previous page. an unknown one would return an empty page.
+	if filter.Iterator != "" {
+		if _, err := uuid.Parse(filter.Iterator); err != nil {
+			return nil, ErrInvalidIterator
+		}
+		if _, err := database.FetchWebhookMessage(filter.Iterator, appId); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, ErrInvalidIterator
+			}
+			log.Printf("[webhooks][orchdio-webhook] error - could not fetch iterator message: %v\n", err)
+			return nil, err
+		}
+	}
+	// fetch one more message than the limit, to know if there is a next page.
+	pageFilter := *filter
+	pageFilter.Limit = filter.Limit + 1
+	messages, err := database.FetchWebhookMessages(appId, &pageFilter)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not list messages: %v\n", err)
+		return nil, err
+	}
+
+	done := len(messages) <= filter.Limit
+	if !done {
+		messages = messages[:filter.Limit]
+	}
+
+	out := &svix.ListResponseMessageOut{
+		Data: make([]svix.MessageOut, 0, len(messages)),
+		Done: done,
+	}
+	for _, message := range messages {
+		out.Data = append(out.Data, toMessageOut(&message))
+	}
+	if !done {
+		iterator := messages[len(messages)-1].UID.String()
+		out.Iterator = &iterator
+	}
+	return out, nil
+}
+
+// ListMessageAttempts lists the delivery attempts of a message, most recent first.
+func (o *OrchdioWebhook) ListMessageAttempts(appId, msgId string) (*svix.ListResponseMessageAttemptOut, error) {
+	database := db.NewDB{DB: o.DB}
+	message, err := database.FetchWebhookMessage(msgId, appId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMessageNotFound
+		}
+		log.Printf("[webhooks][orchdio-webhook] error - could not fetch message: %v\n", err)
+		return nil, err
+	}
+
+	attempts, err := database.FetchWebhookMessageAttempts(msgId)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not list message attempts: %v\n", err)
+		return nil, err
+	}
+
+	out := &svix.ListResponseMessageAttemptOut{
+		Data: make([]svix.MessageAttemptOut, 0, len(attempts)),
+		Done: true,
+	}
+	for _, attempt := range attempts {
+		status, statusText := models.MESSAGESTATUS_FAIL, models.MESSAGESTATUSTEXT_FAIL
+		if attempt.Error == "" && attempt.ResponseStatus >= 200 && attempt.ResponseStatus < 300 {
+			status, statusText = models.MESSAGESTATUS_SUCCESS, models.MESSAGESTATUSTEXT_SUCCESS
+		}
+		out.Data = append(out.Data, svix.MessageAttemptOut{
+			EndpointId:         message.Endpoint.String(),
+			Id:                 attempt.UID.String(),
+			MsgId:              msgId,
+			Response:           attempt.Response,
+			ResponseDurationMs: attempt.DurationMs,
+			ResponseStatusCode: int16(attempt.ResponseStatus),
+			Status:             status,
+			StatusText:         statusText,
+			Timestamp:          attempt.CreatedAt,
+			TriggerType:        models.MESSAGEATTEMPTTRIGGERTYPE_SCHEDULED,
+			Url:                attempt.URL,
+		})
+	}
+	return out, nil
+}
+
+// ResendMessage sets the message back to pending and enqueues it for delivery again.
+func (o *OrchdioWebhook) ResendMessage(appId, msgId, endpointId string) error {
+	database := db.NewDB{DB: o.DB}
+	err := database.ResetWebhookMessage(msgId, appId, endpointId)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrMessageNotFound
+		}
+		log.Printf("[webhooks][orchdio-webhook] error - could not reset message: %v\n", err)
+		return err
+	}
+
+	err = o.enqueueDelivery(msgId, true)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not enqueue message for delivery: %v\n", err)
+		return err
+	}
+	return nil
+}
+
+// RecoverFailedMessages sets the failed (dead-lettered) messages of the endpoint created within the window back to
+// pending and enqueues them for delivery again. The recovery is done right away and the task returned is finished.
+func (o *OrchdioWebhook) RecoverFailedMessages(appId, endpointId string, since time.Time, until *time.Time) (*svix.RecoverOut, error) {
+	database := db.NewDB{DB: o.DB}
+	messageIds, err := database.ResetFailedWebhookMessages(appId, endpointId, since, until)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook] error - could not reset failed messages: %v\n", err)
+		return nil, err
+	}
+
+	for _, messageId := range messageIds {
+		err = o.enqueueDelivery(messageId, true)
+		if err != nil {
+			log.Printf("[webhooks][orchdio-webhook] error - could not enqueue message %s for delivery: %v\n", messageId, err)
+			return nil, err
+		}
+	}
+	log.Printf("[webhooks][orchdio-webhook] recovered %d failed messages for app %s\n", len(messageIds), appId)
+
+	return &svix.RecoverOut{
+		Id:     uuid.NewString(),
+		Status: models.BACKGROUNDTASKSTATUS_FINISHED,
+		Task:   models.BACKGROUNDTASKTYPE_ENDPOINT_RECOVER,
+	}, nil
+}
+
+// DeliveryTaskHandler delivers a webhook message to its endpoint. Every attempt is logged. If the delivery fails, the task
+// is retried by the queue (see RetryDelay) until the retries are exhausted, after which the message is marked as failed.
+func (o *OrchdioWebhook) DeliveryTaskHandler(ctx context.Context, task *asynq.Task) error {
+	var data blueprint.WebhookDeliveryTaskData
+	err := json.Unmarshal(task.Payload(), &data)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] error - could not deserialize task payload: %v\n", err)
+		return fmt.Errorf("%v: %w", err, asynq.SkipRetry)
+	}
+
+	database := db.NewDB{DB: o.DB}
+	message, err := database.FetchWebhookMessageForDelivery(data.MessageID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] warning - message %s does not exist. skipping\n", data.MessageID)
+			return nil
+		}
+		log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] error - could not fetch message: %v\n", err)
+		return err
+	}
+
+	if message.Status == blueprint.WebhookMessageStatusDelivered {
+		log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] message %s already delivered. skipping\n", data.MessageID)
+		return nil
+	}
+
+	retryCount, _ := asynq.GetRetryCount(ctx)
+	maxRetry, ok := asynq.GetMaxRetry(ctx)
+	if !ok {
+		maxRetry = o.MaxRetry
+	}
+	isLastAttempt := retryCount >= maxRetry
+
+	if message.EndpointDisabled {
+		log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] endpoint for message %s is disabled. dead-lettering\n", data.MessageID)
+		_ = database.UpdateWebhookMessageFailedAttempt(data.MessageID, "endpoint disabled", true)
+		return fmt.Errorf("endpoint disabled: %w", asynq.SkipRetry)
+	}
+
+	attempt, deliveryErr := o.deliver(ctx, message)
+	metrics.WebhookDelivery("orchdio", deliveryErr)
+	if attempt != nil {
+		if lErr := database.CreateWebhookMessageAttempt(attempt); lErr != nil {
+			log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] error - could not log delivery attempt: %v\n", lErr)
+		}
+	}
+
+	if deliveryErr == nil {
+		err = database.MarkWebhookMessageDelivered(data.MessageID)
+		if err != nil {
+			log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] error - could not mark message as delivered: %v\n", err)
+		}
+		log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] delivered message %s to %s\n", data.MessageID, message.URL)
+		return nil
+	}
+
+	log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] error - could not deliver message %s (attempt %d of %d): %v\n",
+		data.MessageID, retryCount+1, maxRetry+1, deliveryErr)
+	err = database.UpdateWebhookMessageFailedAttempt(data.MessageID, deliveryErr.Error(), isLastAttempt)
+	if err != nil {
+		log.Printf("[webhooks][orchdio-webhook][DeliveryTaskHandler] error - could not update message: %v\n", err)
+	}
+
+	if isLastAttempt {
+		return fmt.Errorf("%v: %w", deliveryErr, asynq.SkipRetry)
+	}
+	return deliveryErr
+}
+
+// deliver sends the message to its endpoint. It returns the attempt made, to be logged, and an error if the delivery failed.
+func (o *OrchdioWebhook) deliver(ctx context.Context, message *blueprint.WebhookMessageDelivery) (*blueprint.WebhookMessageAttempt, error) {
+	attempt := &blueprint.WebhookMessageAttempt{
+		Message: message.UID,
+		URL:     message.URL,
+	}
+
+	if message.VerifyToken == "" {
+		attempt.Error = "app verify token not found"
+		return attempt, errors.New(attempt.Error)
+	}
+
+	timestamp := time.Now().Unix()
+	messageId := message.UID.String()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, message.URL, bytes.NewReader(message.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookID, messageId)
+	req.Header.Set(HeaderWebhookTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderWebhookSignature, Sign(message.VerifyToken, messageId, timestamp, message.Payload))
+	req.Header.Set(HeaderWebhookEvent, message.EventType)
+
+	start := time.Now()
+	res, err := o.HTTPClient.Do(req)
+	attempt.DurationMs = time.Since(start).Milliseconds()
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt, err
+	}
+	defer res.Body.Close()
+
+	responseBody, _ := io.ReadAll(io.LimitReader(res.Body, maxResponseLogSize))
+	attempt.ResponseStatus = res.StatusCode
+	attempt.Response = string(responseBody)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		attempt.Error = fmt.Sprintf("endpoint responded with status %d", res.StatusCode)
+		return attempt, errors.New(attempt.Error)
+	}
+	return attempt, nil
+}
+
+// Sign returns the signature of a webhook payload. The signed content is the message id, the unix timestamp and the payload,
+// separated by dots. The signature is the hex encoded HMAC-SHA256 of the signed content using the app's verify token, prefixed with the version.
+func Sign(secret, messageId string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%s.%d.", messageId, timestamp)))
+	mac.Write(payload)
+	return "v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature checks that the signature passed is valid for the webhook payload.
+func VerifySignature(secret, messageId string, timestamp int64, payload []byte, signature string) bool {
+	expected := Sign(secret, messageId, timestamp, payload)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// RetryDelay returns how long to wait before retrying a failed webhook delivery. The delay doubles with each
+// retry, starting at 30 seconds and capped at 12 hours, with up to 10% jitter.
+func RetryDelay(n int, _ error, _ *asynq.Task) time.Duration {
+	delay := time.Duration(float64(retryBaseDelay) * math.Pow(2, float64(n)))
+	if delay <= 0 || delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	jitter := time.Duration(rand.Int63n(int64(delay/10) + 1))
+	return delay + jitter
+}
+
+func toEndpointOut(endpoint *blueprint.WebhookEndpoint) *svix.EndpointOut {
+	uid := endpoint.EndpointUID
+	disabled := endpoint.Disabled
+	return &svix.EndpointOut{
+		Id:          endpoint.UID.String(),
+		Uid:         &uid,
+		Url:         endpoint.URL,
+		FilterTypes: endpoint.FilterTypes,
+		Disabled:    &disabled,
+		CreatedAt:   endpoint.CreatedAt,
+		UpdatedAt:   endpoint.UpdatedAt,
+		Version:     1,
+	}
+}
+
+func toMessageOut(message *blueprint.WebhookMessageRecord) svix.MessageOut {
+	eventId := message.EventID.String()
+	var payload map[string]interface{}
+	_ = json.Unmarshal(message.Payload, &payload)
+	return svix.MessageOut{
+		Id:        message.UID.String(),
+		EventId:   &eventId,
+		EventType: message.EventType,
+		Payload:   payload,
+		Timestamp: message.CreatedAt,
+	}
+}
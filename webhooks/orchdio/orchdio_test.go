@@ -0,0 +1,78 @@
+package orchdiowebhook
+
+import (
+	"database/sql"
+	"errors"
+	"orchdio/blueprint"
+	"orchdio/db/queries"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	payload := []byte(`{"data":{"task_id":"123"}}`)
+	signature := Sign("verify-token", "msg_1", 1700000000, payload)
+
+	assert.Equal(t, "v1=", signature[:3])
+	assert.True(t, VerifySignature("verify-token", "msg_1", 1700000000, payload, signature))
+	assert.False(t, VerifySignature("another-token", "msg_1", 1700000000, payload, signature))
+	assert.False(t, VerifySignature("verify-token", "msg_2", 1700000000, payload, signature))
+	assert.False(t, VerifySignature("verify-token", "msg_1", 1700000001, payload, signature))
+	assert.False(t, VerifySignature("verify-token", "msg_1", 1700000000, []byte(`{}`), signature))
+}
+
+func TestRetryDelay(t *testing.T) {
+	first := RetryDelay(0, nil, nil)
+	assert.GreaterOrEqual(t, first, retryBaseDelay)
+	assert.LessOrEqual(t, first, retryBaseDelay+retryBaseDelay/10)
+
+	third := RetryDelay(2, nil, nil)
+	assert.GreaterOrEqual(t, third, 4*retryBaseDelay)
+
+	// the delay is capped, even for very large retry counts.
+	assert.LessOrEqual(t, RetryDelay(100, nil, nil), retryMaxDelay+retryMaxDelay/10)
+	assert.GreaterOrEqual(t, RetryDelay(100, nil, nil), 12*time.Hour)
+}
+
+func TestListMessagesInvalidIterator(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+	sender := New(sqlx.NewDb(conn, "postgres"), nil, 0)
+
+	_, err = sender.ListMessages("wh_app", &blueprint.WebhookMessageFilter{Limit: 10, Iterator: "not-a-uuid"})
+	assert.ErrorIs(t, err, ErrInvalidIterator)
+
+	// an iterator that is not a message of the app.
+	iterator := uuid.NewString()
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchWebhookMessage)).WithArgs(iterator, "wh_app").WillReturnError(sql.ErrNoRows)
+	_, err = sender.ListMessages("wh_app", &blueprint.WebhookMessageFilter{Limit: 10, Iterator: iterator})
+	assert.ErrorIs(t, err, ErrInvalidIterator)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestSendEventCreatesMessagesTogether(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+	// the asynq client is nil: nothing is enqueued when a message could not be created.
+	sender := New(sqlx.NewDb(conn, "postgres"), nil, 0)
+
+	mock.ExpectQuery(regexp.QuoteMeta(queries.FetchWebhookEndpointsForEvent)).WithArgs("wh_app", blueprint.PlaylistConversionDoneEvent).
+		WillReturnRows(sqlmock.NewRows([]string{"uuid"}).AddRow(uuid.NewString()).AddRow(uuid.NewString()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(queries.CreateWebhookMessage)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta(queries.CreateWebhookMessage)).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	_, err = sender.SendEvent("wh_app", blueprint.PlaylistConversionDoneEvent, map[string]string{"task_id": "task"})
+	assert.Error(t, err)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
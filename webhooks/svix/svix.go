@@ -2,12 +2,18 @@ package svixwebhook
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log"
 	"orchdio/blueprint"
 	xlogger "orchdio/logger"
+	"orchdio/metrics"
+	"strconv"
+	"strings"
+	"time"
 
 	svix "github.com/svix/svix-webhooks/go"
+	"github.com/svix/svix-webhooks/go/utils"
 	"go.uber.org/zap"
 )
 
@@ -24,6 +30,9 @@ func intPtr64(v int64) *int64 {
 	return &v
 }
 
+// DefaultEndpointEventTypes are the event types new endpoints are subscribed to.
+var DefaultEndpointEventTypes = []string{blueprint.PlaylistConversionMetadataEvent, blueprint.PlaylistConversionTrackEvent, blueprint.PlaylistConversionDoneEvent}
+
 // for lack of better naming.
 type SvixInterface interface {
 	CreateApp(name, uid string) (*svix.ApplicationOut, *svix.AppPortalAccessOut, error)
@@ -33,13 +42,21 @@ type SvixInterface interface {
 	SendTrackEvent(appId string, out *blueprint.PlaylistConversionEventTrack) bool
 	GetEndpoint(appId, endpoint string) (*svix.EndpointOut, error)
 	UpdateEndpoint(appId, endpointId, endpoint string) (*svix.EndpointOut, error)
+	// UpdateEndpointEventTypes sets the event types sent to an endpoint. An empty list subscribes the endpoint to all event types.
+	UpdateEndpointEventTypes(appId, endpointId string, eventTypes []string) (*svix.EndpointOut, error)
+	CreateEventType(eventName, description string, schema map[string]interface{}) (*svix.EventTypeOut, error)
+	ListMessages(appId string, filter *blueprint.WebhookMessageFilter) (*svix.ListResponseMessageOut, error)
+	ListMessageAttempts(appId, msgId string) (*svix.ListResponseMessageAttemptOut, error)
+	// ResendMessage sends a message to the endpoint again.
+	ResendMessage(appId, msgId, endpointId string) error
+	// RecoverFailedMessages resends the messages that failed to be delivered to the endpoint since a given time (and until, if passed).
+	RecoverFailedMessages(appId, endpointId string, since time.Time, until *time.Time) (*svix.RecoverOut, error)
 
 	// CreateAppPortal(appId string) (*svix.AppPortalAccessOut, error)
 	// GetApp(appId string) (*svix.ApplicationOut, *svix.AppPortalAccessOut, error)
 	// DeleteApp(appId string) error
 	// ListEndpoints(appId string) (*svix.ListResponseEndpointOut, error)
 	// DeleteEndpoint(appId, endpointId string) error
-	// CreateEventType(eventName, description string) (*svix.EventTypeOut, error)
 }
 
 func New(authToken string, debug bool) *SvixWebhook {
@@ -59,8 +76,7 @@ func New(authToken string, debug bool) *SvixWebhook {
 }
 
 func (s *SvixWebhook) CreateApp(name, uid string) (*svix.ApplicationOut, *svix.AppPortalAccessOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	// todo: configure application options, including context argument (and other places using ctx)
 	whApp, err := s.Client.Application.Create(context.TODO(), svix.ApplicationIn{
@@ -86,8 +102,7 @@ func (s *SvixWebhook) CreateApp(name, uid string) (*svix.ApplicationOut, *svix.A
 }
 
 func (s *SvixWebhook) CreateAppPortal(appId string) (*svix.AppPortalAccessOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	appPortal, svErr := s.Client.Authentication.AppPortalAccess(context.TODO(), appId, svix.AppPortalAccessIn{
 		Capabilities: []svix.AppPortalCapability{"ViewBase", "ViewEndpointSecret"},
@@ -102,8 +117,7 @@ func (s *SvixWebhook) CreateAppPortal(appId string) (*svix.AppPortalAccessOut, e
 }
 
 func (s *SvixWebhook) GetApp(appId string) (*svix.ApplicationOut, *svix.AppPortalAccessOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	whApp, err := s.Client.Application.Get(context.TODO(), appId)
 	if err != nil {
@@ -125,8 +139,7 @@ func (s *SvixWebhook) GetApp(appId string) (*svix.ApplicationOut, *svix.AppPorta
 }
 
 func (s *SvixWebhook) DeleteApp(appId string) error {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	err := s.Client.Application.Delete(context.TODO(), appId)
 	if err != nil {
@@ -137,13 +150,12 @@ func (s *SvixWebhook) DeleteApp(appId string) error {
 }
 
 func (s *SvixWebhook) CreateEndpoint(appId, uid, endpoint string) (*svix.EndpointOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
+	// new endpoints are subscribed to DefaultEndpointEventTypes. apps can change them with UpdateEndpointEventTypes
 	whEnd, err := s.Client.Endpoint.Create(context.Background(), appId, svix.EndpointIn{
-		Url: endpoint,
-		// todo: add more events.
-		FilterTypes: []string{blueprint.PlaylistConversionMetadataEvent, blueprint.PlaylistConversionTrackEvent, blueprint.PlaylistConversionDoneEvent},
+		Url:         endpoint,
+		FilterTypes: DefaultEndpointEventTypes,
 		Uid:         &uid,
 	}, nil)
 
@@ -157,8 +169,7 @@ func (s *SvixWebhook) CreateEndpoint(appId, uid, endpoint string) (*svix.Endpoin
 }
 
 func (s *SvixWebhook) GetEndpoint(appId, endpoint string) (*svix.EndpointOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	whEnd, err := s.Client.Endpoint.Get(context.Background(), appId, endpoint)
 	if err != nil {
@@ -170,8 +181,7 @@ func (s *SvixWebhook) GetEndpoint(appId, endpoint string) (*svix.EndpointOut, er
 }
 
 func (s *SvixWebhook) UpdateEndpoint(appId, endpointId, endpoint string) (*svix.EndpointOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	whResponse, err := s.Client.Endpoint.Update(context.TODO(), appId, endpointId, svix.EndpointUpdate{
 		Url: endpoint,
@@ -186,9 +196,29 @@ func (s *SvixWebhook) UpdateEndpoint(appId, endpointId, endpoint string) (*svix.
 	return whResponse, err
 }
 
+func (s *SvixWebhook) UpdateEndpointEventTypes(appId, endpointId string, eventTypes []string) (*svix.EndpointOut, error) {
+	logger := xlogger.L()
+
+	// a null filter means the endpoint receives all event types.
+	filterTypes := utils.NewNullableFromPtr[[]string](nil)
+	if len(eventTypes) > 0 {
+		filterTypes = utils.NewNullable(eventTypes)
+	}
+
+	whResponse, err := s.Client.Endpoint.Patch(context.TODO(), appId, endpointId, svix.EndpointPatch{
+		FilterTypes: filterTypes,
+	})
+
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not update endpoint event types.", zap.Error(err))
+		return nil, err
+	}
+
+	return whResponse, nil
+}
+
 func (s *SvixWebhook) ListEndpoints(appId string) (*svix.ListResponseEndpointOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	whEndpoints, err := s.Client.Endpoint.List(context.Background(), appId, &svix.EndpointListOptions{
 		Limit: uintPtr(250),
@@ -203,8 +233,7 @@ func (s *SvixWebhook) ListEndpoints(appId string) (*svix.ListResponseEndpointOut
 }
 
 func (s *SvixWebhook) DeleteEndpoint(appId, endpointId string) error {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
 
 	err := s.Client.Endpoint.Delete(context.Background(), appId, endpointId)
 	if err != nil {
@@ -215,17 +244,31 @@ func (s *SvixWebhook) DeleteEndpoint(appId, endpointId string) error {
 	return nil
 }
 
+// SendEvent sends an event to the app. The payload is sent in a versioned event envelope (blueprint.WebhookEvent); if the
+// payload passed is not already an envelope, it is wrapped in one.
 func (s *SvixWebhook) SendEvent(appId, eventType string, payload interface{}) (*svix.MessageOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+	logger := xlogger.L()
+
+	event := blueprint.ToWebhookEvent(eventType, payload)
+	serializedEvent, err := json.Marshal(event)
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not serialize event.", zap.Error(err))
+		return nil, err
+	}
+
+	var eventPayload map[string]interface{}
+	err = json.Unmarshal(serializedEvent, &eventPayload)
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not deserialize event.", zap.Error(err))
+		return nil, err
+	}
 
 	whMsg, err := s.Client.Message.Create(context.TODO(), appId, svix.MessageIn{
-		// todo: use constant event types.
-		EventType: eventType,
-		Payload: map[string]interface{}{
-			"data": payload,
-		},
+		EventType: event.Type,
+		EventId:   &event.ID,
+		Payload:   eventPayload,
 	}, nil)
+	metrics.WebhookDelivery("svix", err)
 
 	if err != nil {
 		logger.Error("[webhooks][svix-webhook] error - could not send event.")
@@ -235,22 +278,105 @@ func (s *SvixWebhook) SendEvent(appId, eventType string, payload interface{}) (*
 	return whMsg, nil
 }
 
-func (s *SvixWebhook) CreateEventType(eventName, description string) (*svix.EventTypeOut, error) {
-	loggerOpts := &blueprint.OrchdioLoggerOptions{}
-	logger := xlogger.NewZapSentryLogger(loggerOpts)
+// CreateEventType registers an event type on Svix, with the JSON Schema of the current event version. If the event
+// type already exists, it is updated.
+func (s *SvixWebhook) CreateEventType(eventName, description string, schema map[string]interface{}) (*svix.EventTypeOut, error) {
+	logger := xlogger.L()
+
+	var schemas *map[string]any
+	if schema != nil {
+		schemas = &map[string]any{
+			strconv.Itoa(blueprint.WebhookEventVersion): schema,
+		}
+	}
 
 	whEType, err := s.Client.EventType.Create(context.TODO(), svix.EventTypeIn{
 		Name:        eventName,
 		Description: description,
+		Schemas:     schemas,
 	}, nil)
 
 	if err != nil {
-		logger.Error("[webhooks][svix-webhook] error - could not create new event type.")
+		// hack: like in other places, the svix error is checked by keyword. 409 means the event type already exists.
+		if strings.Contains(err.Error(), "409") {
+			whEType, err = s.Client.EventType.Update(context.TODO(), eventName, svix.EventTypeUpdate{
+				Description: description,
+				Schemas:     schemas,
+			})
+			if err == nil {
+				return whEType, nil
+			}
+		}
+		logger.Error("[webhooks][svix-webhook] error - could not create new event type.", zap.Error(err))
 		return nil, err
 	}
 	return whEType, nil
 }
 
+// ListMessages lists the messages sent to the app, most recent first.
+func (s *SvixWebhook) ListMessages(appId string, filter *blueprint.WebhookMessageFilter) (*svix.ListResponseMessageOut, error) {
+	logger := xlogger.L()
+
+	limit := uint64(filter.Limit)
+	withContent := true
+	opts := &svix.MessageListOptions{
+		Limit:       &limit,
+		Before:      filter.Before,
+		After:       filter.After,
+		WithContent: &withContent,
+	}
+	if filter.Iterator != "" {
+		opts.Iterator = &filter.Iterator
+	}
+	if len(filter.EventTypes) > 0 {
+		opts.EventTypes = &filter.EventTypes
+	}
+
+	messages, err := s.Client.Message.List(context.TODO(), appId, opts)
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not list messages.", zap.Error(err))
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListMessageAttempts lists the delivery attempts of a message, most recent first.
+func (s *SvixWebhook) ListMessageAttempts(appId, msgId string) (*svix.ListResponseMessageAttemptOut, error) {
+	logger := xlogger.L()
+
+	attempts, err := s.Client.MessageAttempt.ListByMsg(context.TODO(), appId, msgId, nil)
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not list message attempts.", zap.Error(err))
+		return nil, err
+	}
+	return attempts, nil
+}
+
+func (s *SvixWebhook) ResendMessage(appId, msgId, endpointId string) error {
+	logger := xlogger.L()
+
+	err := s.Client.MessageAttempt.Resend(context.TODO(), appId, msgId, endpointId, nil)
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not resend message.", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (s *SvixWebhook) RecoverFailedMessages(appId, endpointId string, since time.Time, until *time.Time) (*svix.RecoverOut, error) {
+	logger := xlogger.L()
+
+	recoverOut, err := s.Client.Endpoint.Recover(context.TODO(), appId, endpointId, svix.RecoverIn{
+		Since: since,
+		Until: until,
+	}, nil)
+	if err != nil {
+		logger.Error("[webhooks][svix-webhook] error - could not recover failed messages.", zap.Error(err))
+		return nil, err
+	}
+	return recoverOut, nil
+}
+
 func FormatSvixEndpointUID(devAppId string) string {
 	return fmt.Sprintf("endpoint_%s", devAppId)
 }
@@ -260,7 +386,7 @@ func FormatSvixAppUID(devAppId string) string {
 }
 
 func (s *SvixWebhook) SendTrackEvent(appId string, out *blueprint.PlaylistConversionEventTrack) bool {
-	_, whErr := s.SendEvent(appId, blueprint.PlaylistConversionTrackEvent, out)
+	_, whErr := s.SendEvent(appId, blueprint.PlaylistConversionTrackEvent, blueprint.NewTrackEvent(out.App, out))
 	if whErr != nil {
 		log.Printf("\n[services] error - Could not send webhook event: %v\n", whErr)
 		return false
@@ -269,7 +395,8 @@ func (s *SvixWebhook) SendTrackEvent(appId string, out *blueprint.PlaylistConver
 }
 
 func (s *SvixWebhook) SendPlaylistMetadataEvent(info *blueprint.LinkInfo, result *blueprint.PlaylistConversionEventMetadata) bool {
-	_, whEventErr := s.SendEvent(info.App, blueprint.PlaylistConversionMetadataEvent, &result)
+	_, whEventErr := s.SendEvent(info.App, blueprint.PlaylistConversionMetadataEvent,
+		blueprint.NewWebhookEvent(blueprint.PlaylistConversionMetadataEvent, info.App, result.TaskId, result))
 	if whEventErr != nil {
 		log.Printf("[internal][platforms][platform_factory]: Could not send playlist conversion metadata event %v", whEventErr)
 		return false
@@ -0,0 +1,85 @@
+package svixwebhook
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"orchdio/blueprint"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	svix "github.com/svix/svix-webhooks/go"
+)
+
+// svixServer records the bodies of the requests sent to the svix API, by path.
+type svixServer struct {
+	mu     sync.Mutex
+	bodies map[string]map[string]interface{}
+}
+
+func newTestSender(t *testing.T) (*SvixWebhook, *svixServer) {
+	recorder := &svixServer{bodies: map[string]map[string]interface{}{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		recorder.mu.Lock()
+		recorder.bodies[r.URL.Path] = body
+		recorder.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		if strings.HasSuffix(r.URL.Path, "/endpoint") {
+			_, _ = w.Write([]byte(`{"id": "ep_1", "url": "https://example.com", "version": 1, "description": "", "metadata": {},
+				"createdAt": "2024-01-01T00:00:00Z", "updatedAt": "2024-01-01T00:00:00Z"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id": "msg_1", "eventType": "event", "payload": {}, "timestamp": "2024-01-01T00:00:00Z"}`))
+	}))
+	t.Cleanup(server.Close)
+
+	serverURL, err := url.Parse(server.URL)
+	require.NoError(t, err)
+	client, err := svix.New("testsk_token", &svix.SvixOptions{ServerUrl: serverURL})
+	require.NoError(t, err)
+	return &SvixWebhook{AuthToken: "testsk_token", Client: client}, recorder
+}
+
+func (s *svixServer) body(path string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies[path]
+}
+
+func TestCreateEndpointFilterTypes(t *testing.T) {
+	sender, recorder := newTestSender(t)
+
+	_, err := sender.CreateEndpoint("app_1", "endpoint_1", "https://example.com")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []interface{}{blueprint.PlaylistConversionMetadataEvent, blueprint.PlaylistConversionTrackEvent,
+		blueprint.PlaylistConversionDoneEvent}, recorder.body("/api/v1/app/app_1/endpoint")["filterTypes"])
+}
+
+func TestSendEventsEnvelopeApp(t *testing.T) {
+	sender, recorder := newTestSender(t)
+
+	require.True(t, sender.SendTrackEvent("app_1", &blueprint.PlaylistConversionEventTrack{
+		Platform: "spotify",
+		TaskId:   "task",
+		Track:    &blueprint.TrackSearchResult{Title: "track"},
+		App:      "developer-app",
+	}))
+	payload := recorder.body("/api/v1/app/app_1/msg")["payload"].(map[string]interface{})
+	assert.Equal(t, blueprint.PlaylistConversionTrackEvent, payload["type"])
+	assert.Equal(t, "developer-app", payload["app"])
+	assert.Equal(t, "task", payload["task_id"])
+
+	require.True(t, sender.SendPlaylistMetadataEvent(&blueprint.LinkInfo{App: "developer-app"},
+		&blueprint.PlaylistConversionEventMetadata{TaskId: "task", Platform: "spotify"}))
+	payload = recorder.body("/api/v1/app/developer-app/msg")["payload"].(map[string]interface{})
+	assert.Equal(t, blueprint.PlaylistConversionMetadataEvent, payload["type"])
+	assert.Equal(t, "developer-app", payload["app"])
+}
@@ -0,0 +1,66 @@
+// Package webhooks selects the webhook sender used to deliver events to developer apps. Svix is used by default;
+// setting WEBHOOK_PROVIDER to "orchdio" uses the built-in sender instead.
+package webhooks
+
+import (
+	"log"
+	"orchdio/config"
+	"orchdio/webhooks/events"
+	orchdiowebhook "orchdio/webhooks/orchdio"
+	svixwebhook "orchdio/webhooks/svix"
+
+	"github.com/hibiken/asynq"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ProviderSvix    = config.WebhookProviderSvix
+	ProviderOrchdio = config.WebhookProviderOrchdio
+)
+
+// Provider returns the webhook provider of the configuration. It defaults to svix.
+func Provider(cfg config.Webhooks) string {
+	provider := cfg.Provider
+	if provider == "" {
+		return ProviderSvix
+	}
+	return provider
+}
+
+// IsBuiltIn returns true if the built-in webhook sender is configured.
+func IsBuiltIn(cfg config.Webhooks) bool {
+	return Provider(cfg) == ProviderOrchdio
+}
+
+// NewWebhookSender returns the webhook sender configured by WEBHOOK_PROVIDER. asynqClient is the client of the queues
+// of the worker, where the built-in sender enqueues its deliveries.
+func NewWebhookSender(pg *sqlx.DB, asynqClient *asynq.Client, cfg config.Webhooks) svixwebhook.SvixInterface {
+	if IsBuiltIn(cfg) {
+		return NewBuiltInSender(pg, asynqClient, cfg)
+	}
+
+	if Provider(cfg) != ProviderSvix {
+		log.Printf("[webhooks][NewWebhookSender] warning - unknown webhook provider '%s'. Using svix\n", Provider(cfg))
+	}
+	return svixwebhook.New(cfg.SvixAPIKey, false)
+}
+
+// NewBuiltInSender returns the built-in webhook sender. The max number of retries for a message can be set with WEBHOOK_MAX_RETRIES.
+func NewBuiltInSender(pg *sqlx.DB, asynqClient *asynq.Client, cfg config.Webhooks) *orchdiowebhook.OrchdioWebhook {
+	return orchdiowebhook.New(pg, asynqClient, cfg.MaxRetries)
+}
+
+// RegisterEventTypes registers (or updates) the event types in the catalogue, with their schema, on the webhook provider.
+func RegisterEventTypes(sender svixwebhook.SvixInterface) {
+	for _, eventType := range events.Catalogue {
+		schema, err := events.Schema(eventType.Name)
+		if err != nil {
+			log.Printf("[webhooks][RegisterEventTypes] error - could not read schema for event type %s: %v\n", eventType.Name, err)
+			continue
+		}
+		_, err = sender.CreateEventType(eventType.Name, eventType.Description, schema)
+		if err != nil {
+			log.Printf("[webhooks][RegisterEventTypes] error - could not register event type %s: %v\n", eventType.Name, err)
+		}
+	}
+}
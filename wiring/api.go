@@ -0,0 +1,287 @@
+package wiring
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"orchdio/blueprint"
+	"orchdio/controllers"
+	"orchdio/controllers/account"
+	"orchdio/controllers/auth"
+	"orchdio/controllers/conversion"
+	"orchdio/controllers/developer"
+	"orchdio/controllers/platforms"
+	"orchdio/controllers/portal"
+	"orchdio/health"
+	"orchdio/logger"
+	"orchdio/metrics"
+	"orchdio/middleware"
+	"orchdio/queue"
+	"orchdio/services/ratelimit"
+	"orchdio/tracing"
+	"orchdio/util"
+	"orchdio/webhooks"
+	"regexp"
+
+	"github.com/antoniodipinto/ikisocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/compress"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+	"github.com/gofiber/fiber/v2/middleware/monitor"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	jwtware "github.com/gofiber/jwt/v3"
+)
+
+// NewAPI returns the API, with its routes. The readiness of the API (/readyz) is reported by checker.
+func NewAPI(deps *Deps, checker *health.Checker) *fiber.App {
+	/// Go fiber server configuration
+	app := fiber.New(fiber.Config{
+		DisableStartupMessage: false,
+		AppName:               deps.Config.AppName,
+		DisableDefaultDate:    true,
+		ReadTimeout:           deps.Config.HTTP.ReadTimeout,
+		WriteTimeout:          deps.Config.HTTP.WriteTimeout,
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			var e *fiber.Error
+			if errors.As(err, &e) {
+				// e.Code will be the status code.
+				// e.Message will be the error message.
+				log.Print(err.Error())
+				return util.ErrorResponse(ctx, e.Code, "internal error", e.Message)
+			}
+			log.Printf("Error in next router %v", err)
+			return util.ErrorResponse(ctx, fiber.StatusInternalServerError, "internal error", "An internal error occurred")
+		},
+	})
+	// the webhook sender is svix or the built-in sender, depending on WEBHOOK_PROVIDER.
+	webhookSender := webhooks.NewWebhookSender(deps.DB, deps.AsynqClient, deps.Config.Webhooks)
+	log.Printf("[wiring] [info] - Using '%s' webhook provider", webhooks.Provider(deps.Config.Webhooks))
+	go webhooks.RegisterEventTypes(webhookSender)
+	// the API only enqueues the tasks, they are processed by the worker (see NewWorker).
+	orchdioQueue := queue.NewOrchdioQueue(deps.AsynqClient, deps.DB, deps.Redis, nil, deps.Envelope, deps.Config)
+	userController := account.NewUserController(deps.DB, deps.Redis, orchdioQueue, webhookSender, deps.Envelope, deps.Config)
+	rateLimiter := ratelimit.NewLimiter(deps.Redis, deps.Config.RateLimit.Plans)
+	authMiddleware := middleware.NewAuthMiddleware(deps.DB)
+	rateLimitMiddleware := middleware.NewRateLimitMiddleware(rateLimiter)
+	usageMiddleware := middleware.NewUsageMiddleware(deps.DB)
+	conversionController := conversion.NewConversionController(deps.DB, deps.Redis, deps.AsynqClient, nil, nil, deps.Config)
+	devAppController := developer.NewDeveloperController(deps.DB, webhookSender, rateLimiter, deps.Envelope, deps.Config)
+
+	platformsControllers := platforms.NewPlatform(deps.Redis, deps.DB, orchdioQueue, webhookSender, deps.Envelope, deps.Config)
+	/**
+	 ==================================================================
+	+
+	+
+	+	ROUTE DEFINITIONS GO HERE
+	+
+	+
+	 ==================================================================
+	*/
+
+	// the request id is set first, so that every log record of the request has it. see logger.FromFiber.
+	app.Use(requestid.New(requestid.Config{
+		Header:     "x-orchdio-request-id",
+		ContextKey: logger.RequestIDKey,
+	}), tracing.Middleware, metrics.Middleware)
+	// the probes are registered before the logging and the rate limit of the requests.
+	app.Get("/healthz", checker.Liveness)
+	app.Get("/readyz", checker.Readiness)
+	app.Use(cors.New(cors.Config{
+		AllowMethods: "GET,POST,HEAD,PUT,DELETE,PATCH",
+		AllowOrigins: "*",
+	}), authMiddleware.LogIncomingRequest, authMiddleware.HandleTrolls)
+	app.Use(compress.New(compress.Config{
+		Level: compress.LevelBestSpeed,
+		Next:  isEventStream,
+	}))
+	app.Use(etag.New(etag.Config{
+		Next: isEventStream,
+	}))
+	// requests made with app keys are limited per app on their plan instead, once they are authenticated, see
+	// middleware.IPLimiter.
+	ipLimiter := middleware.NewIPLimiter(deps.Config.RateLimit.IPMax, deps.Config.RateLimit.IPWindow, func(ctx *fiber.Ctx) error {
+		log.Printf("[wiring] [info] - Rate limit exceeded")
+		return util.ErrorResponse(ctx, fiber.StatusTooManyRequests, "rate limit error", "Rate limit exceeded")
+	})
+	app.Use(ipLimiter.Limit)
+	baseRouter := app.Group("/api/v1")
+	orchRouter := app.Group("/v1")
+
+	baseRouter.Get("/", func(ctx *fiber.Ctx) error {
+		return ctx.SendStatus(http.StatusOK)
+	})
+	app.Get("/vermont/info", monitor.New(monitor.Config{Title: "Orchdio-Core health info"}))
+	app.Get("/metrics", metrics.Handler(deps.Config.MetricsToken))
+
+	authController := auth.NewAuthController(deps.DB, deps.AsynqClient, nil, nil, deps.Redis, deps.Envelope, deps.Config)
+	// Auth related endpoints. full endpoint scheme is: "/v1/auth/..."
+	// connect endpoints
+	orchRouter.Get("/auth/:platform/connect", authMiddleware.AddRequestPlatformWithPubKeyToCtx, authController.AppAuthRedirect)
+	// the callback that the auth platform will redirect to and this is where we handle the redirect and generate an auth token for the user, as response
+	orchRouter.Get("/auth/:platform/callback", authMiddleware.AddRequestPlatformWithPubKeyToCtx, authController.HandleAppAuthRedirect)
+	// this is for the apple music auth. its a POST as it carries a body
+	orchRouter.Post("/auth/:platform/callback", authMiddleware.AddRequestPlatformWithPubKeyToCtx, authController.HandleAppAuthRedirect)
+
+	// entity and task related controllers. Secret keys can be restricted to scopes, the scopes each route needs are
+	// checked by middleware.RequireScopes.
+	// entity is the type of action the user is trying to do. for example. converting a deezer link to tidal
+	orchRouter.Post("/playlist/convert", authMiddleware.AddReadOnlyDeveloperToContext,
+		middleware.RequireScopes(blueprint.ScopeConvertPlaylist), rateLimitMiddleware.Limit(ratelimit.BudgetPlaylistConversions, ratelimit.BudgetTracksConverted), middleware.ExtractLinkInfoFromBody(deps.Config.Platforms), platformsControllers.ConvertPlaylist)
+
+	/// handler for track conversions.
+	// todo: move implementation of track only related code to the controller attached to this.
+	orchRouter.Post("/track/convert", authMiddleware.AddReadOnlyDeveloperToContext, middleware.RequireScopes(blueprint.ScopeConvertTrack), rateLimitMiddleware.Limit(ratelimit.BudgetTrackConversions), middleware.ExtractLinkInfoFromBody(deps.Config.Platforms), usageMiddleware.Record(blueprint.UsageTrackConversion), platformsControllers.ConvertTrack)
+	// a task is a single conversion job or a "self-contained instance" of a typical conversion.
+	// it includes information on what platform the user is converting from, to, and other necessary info.
+	orchRouter.Get("/task/:taskId", authMiddleware.AddReadOnlyDeveloperToContext, middleware.RequireScopes(blueprint.ScopeTasksRead), rateLimitMiddleware.Limit(), conversionController.GetPlaylistTask)
+	// browsers cannot set the headers of the stream requests (EventSource), they get a stream token for the task first.
+	orchRouter.Post("/task/:taskId/events/token", authMiddleware.AddReadOnlyDeveloperToContext, middleware.RequireScopes(blueprint.ScopeTasksRead), rateLimitMiddleware.Limit(), conversionController.CreateTaskStreamToken)
+	orchRouter.Get("/task/:taskId/events", conversionController.AuthenticateStream(authMiddleware.AddReadOnlyDeveloperToContext), middleware.RequireScopes(blueprint.ScopeTasksRead), rateLimitMiddleware.Limit(), conversionController.StreamPlaylistTaskEvents)
+
+	// user account action routes. they perform actions that require (previous) authorization from the user.
+	// Endpoint scheme is: "/v1/..."
+	orchRouter.Post("/playlist/:platform/add", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeLibraryWrite), rateLimitMiddleware.Limit(), usageMiddleware.Record(blueprint.UsageLibraryWrite), platformsControllers.AddPlaylistToAccount)
+	// this is the account of the *DEVELOPER* not the user,
+	orchRouter.Get("/account", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountRead), rateLimitMiddleware.Limit(), userController.FetchUserInfoByIdentifier)
+	orchRouter.Get("/me", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountRead), rateLimitMiddleware.Limit(), userController.FetchUserProfile)
+	orchRouter.Get("/account/:userId/:platform/playlists", authMiddleware.AddRequestPlatformWithPrivateKeyToCtx, authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireScopes(blueprint.ScopeLibraryRead), rateLimitMiddleware.Limit(), usageMiddleware.Record(blueprint.UsageLibraryRead), platformsControllers.FetchPlatformPlaylists)
+	// todo: add nb_artists to data response
+	orchRouter.Get("/account/:userId/:platform/artists", authMiddleware.AddRequestPlatformWithPrivateKeyToCtx, authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireScopes(blueprint.ScopeLibraryRead), rateLimitMiddleware.Limit(), usageMiddleware.Record(blueprint.UsageLibraryRead), platformsControllers.FetchPlatformArtists)
+	orchRouter.Get("/account/:userId/:platform/albums", authMiddleware.AddRequestPlatformWithPrivateKeyToCtx, authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireScopes(blueprint.ScopeLibraryRead), rateLimitMiddleware.Limit(), usageMiddleware.Record(blueprint.UsageLibraryRead), platformsControllers.FetchPlatformAlbums)
+	// TODO: implement for tidal
+	orchRouter.Get("/account/:userId/:platform/history/tracks", authMiddleware.AddRequestPlatformWithPrivateKeyToCtx, authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireScopes(blueprint.ScopeLibraryRead), rateLimitMiddleware.Limit(), authMiddleware.VerifyUserActionApp, usageMiddleware.Record(blueprint.UsageLibraryRead), platformsControllers.FetchTrackListeningHistory)
+
+	orchRouter.Post("/follow", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeFollowWrite), rateLimitMiddleware.Limit(), usageMiddleware.Record(blueprint.UsageLibraryWrite), userController.FollowPlaylist)
+	// notifications inbox for the users of an app. e.g. the updates of the playlists they follow.
+	orchRouter.Get("/account/:userId/notifications", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountRead), rateLimitMiddleware.Limit(), userController.FetchUserNotifications)
+	orchRouter.Post("/account/:userId/notifications/read", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountWrite), rateLimitMiddleware.Limit(), userController.MarkNotificationsAsRead)
+	orchRouter.Post("/account/:userId/notifications/:notificationId/read", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountWrite), rateLimitMiddleware.Limit(), userController.MarkNotificationAsRead)
+	// disconnecting a platform of a user and erasing all the data of a user for the app.
+	orchRouter.Delete("/account/:userId/:platform", authMiddleware.AddRequestPlatformWithPrivateKeyToCtx, authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountWrite), rateLimitMiddleware.Limit(), userController.DisconnectUserPlatform)
+	orchRouter.Delete("/account/:userId", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountWrite), rateLimitMiddleware.Limit(), userController.DeleteUserData)
+	orchRouter.Post("/waitlist/add", authMiddleware.AddReadWriteDeveloperToContext, middleware.RequireLiveKey, middleware.RequireScopes(blueprint.ScopeAccountWrite), rateLimitMiddleware.Limit(), userController.AddToWaitlist)
+
+	// Org related endpoints. Endpoint scheme is: "/v1/org/..."
+	orgRouter := app.Group("/v1/org")
+	orgRouter.Post("/new", userController.CreateOrg)
+	orgRouter.Post("/login", userController.LoginUserToOrg)
+	orgRouter.Post("/reset-password", userController.ResetPassword)
+	orgRouter.Get("/reset-password", userController.ResetPassword)
+	orgRouter.Post("/change-password", userController.ChangePassword)
+	// invitations are accepted by users that may not have an account yet, so before the JWT middleware.
+	orgRouter.Post("/invites/accept", userController.AcceptOrgInvite)
+
+	orgRouter.Use(jwtware.New(jwtware.Config{
+		SigningKey: []byte(deps.Config.Auth.JWTSecret),
+		Claims:     &blueprint.AppJWT{},
+		ContextKey: "appToken",
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			log.Printf("Error validating auth token %v:\n", err)
+			return util.ErrorResponse(ctx, http.StatusUnauthorized, "Authorization error", "Invalid or Expired token")
+		},
+	}), middleware.VerifyAppJWT)
+
+	// endpoints that require jwt tokens to be authenticated and authorized. The org endpoints need a role in the org,
+	// see blueprint.OrgRoles.
+	orgRouter.Get("/all", userController.FetchUserOrgs)
+	orgRouter.Post("/:orgId/app/new", authMiddleware.RequireOrgRole(blueprint.OrgRoleDeveloper), devAppController.CreateApp)
+	orgRouter.Get("/:orgId/apps", authMiddleware.RequireOrgRole(blueprint.OrgRoleViewer), devAppController.FetchAllDeveloperApps)
+	orgRouter.Get("/:orgId/usage", authMiddleware.RequireOrgRole(blueprint.OrgRoleViewer), devAppController.FetchOrgUsage)
+	orgRouter.Get("/:orgId/audit-log", authMiddleware.RequireOrgRole(blueprint.OrgRoleAdmin), devAppController.FetchOrgAuditLog)
+	orgRouter.Delete("/:orgId", authMiddleware.RequireOrgRole(blueprint.OrgRoleOwner), userController.DeleteOrg)
+	orgRouter.Patch("/:orgId", authMiddleware.RequireOrgRole(blueprint.OrgRoleAdmin), userController.UpdateOrg)
+	orgRouter.Post("/:orgId/transfer", authMiddleware.RequireOrgRole(blueprint.OrgRoleOwner), userController.TransferOrg)
+	orgRouter.Get("/:orgId/members", authMiddleware.RequireOrgRole(blueprint.OrgRoleViewer), userController.FetchOrgMembers)
+	orgRouter.Patch("/:orgId/members/:userId", authMiddleware.RequireOrgRole(blueprint.OrgRoleAdmin), userController.UpdateOrgMember)
+	// members can remove themselves from the org, removing others needs the admin role (checked in the handler).
+	orgRouter.Delete("/:orgId/members/:userId", authMiddleware.RequireOrgRole(blueprint.OrgRoleViewer), userController.RemoveOrgMember)
+	orgRouter.Get("/:orgId/invites", authMiddleware.RequireOrgRole(blueprint.OrgRoleAdmin), userController.FetchOrgInvites)
+	orgRouter.Post("/:orgId/invites", authMiddleware.RequireOrgRole(blueprint.OrgRoleAdmin), userController.InviteOrgMember)
+	orgRouter.Delete("/:orgId/invites/:inviteId", authMiddleware.RequireOrgRole(blueprint.OrgRoleAdmin), userController.DeleteOrgInvite)
+
+	// apps endpoints are mostly for the developers, accessible by an api endpoint
+	// they are a little different from the org endpoints, even though orgs call app.
+	// this is for the internal orchdio dev dashboard/apps. therefore some endpoints
+	// are essentially available to orgs and also developers (using their api keys)
+	// note: perhpas this could be resolved to avoid confusion. Sync with @marvin
+	appRouter := app.Group("/v1/app")
+	appRouter.Use(jwtware.New(jwtware.Config{
+		SigningKey: []byte(deps.Config.Auth.JWTSecret),
+		Claims:     &blueprint.AppJWT{},
+		ContextKey: "appToken",
+		ErrorHandler: func(ctx *fiber.Ctx, err error) error {
+			log.Printf("Error validating auth token %v:\n", err)
+			return util.ErrorResponse(ctx, http.StatusUnauthorized, "Authorization error", "Invalid or Expired token")
+		},
+	}), middleware.VerifyAppJWT)
+	orchRouter.Get("/app/:appId", devAppController.FetchApp)
+	orchRouter.Get("/webhooks/event-types", devAppController.FetchWebhookEventTypes)
+	orchRouter.Get("/webhooks/event-types/:eventType/schema", devAppController.FetchWebhookEventTypeSchema)
+	appRouter.Get("/me", userController.FetchProfile)
+	// the app endpoints need a role in the org of the app: viewers can read, developers manage the app and its webhooks,
+	// admins its secret keys and credentials, and can disable or delete it.
+	appViewer := authMiddleware.RequireAppRole(blueprint.OrgRoleViewer)
+	appDeveloper := authMiddleware.RequireAppRole(blueprint.OrgRoleDeveloper)
+	appAdmin := authMiddleware.RequireAppRole(blueprint.OrgRoleAdmin)
+	appRouter.Get("/:appId/keys", appDeveloper, devAppController.FetchKeys)
+	appRouter.Get("/:appId/webhook/event-types", appViewer, devAppController.FetchAppWebhookEventTypes)
+	appRouter.Put("/:appId/webhook/event-types", appDeveloper, devAppController.UpdateAppWebhookEventTypes)
+	appRouter.Get("/:appId/webhook/messages", appViewer, devAppController.FetchAppWebhookMessages)
+	appRouter.Post("/:appId/webhook/messages/replay", appDeveloper, devAppController.ReplayAppWebhookMessages)
+	appRouter.Get("/:appId/webhook/messages/:messageId/attempts", appViewer, devAppController.FetchAppWebhookMessageAttempts)
+	appRouter.Post("/:appId/webhook/messages/:messageId/replay", appDeveloper, devAppController.ReplayAppWebhookMessage)
+	appRouter.Post("/:appId/webhook/test", appDeveloper, devAppController.FireTestWebhookEvent)
+	appRouter.Get("/:appId/webhook/batching", appViewer, devAppController.FetchAppWebhookBatchSettings)
+	appRouter.Get("/:appId/limits", appViewer, devAppController.FetchAppRateLimits)
+	appRouter.Put("/:appId/webhook/batching", appDeveloper, devAppController.UpdateAppWebhookBatchSettings)
+	appRouter.Post("/:appId/disable", appAdmin, devAppController.DisableApp)
+	appRouter.Post("/:appId/enable", appAdmin, devAppController.EnableApp)
+	appRouter.Delete("/:appId", appAdmin, devAppController.DeleteApp)
+	appRouter.Patch("/:appId", appDeveloper, devAppController.UpdateApp)
+	appRouter.Delete("/:appId/credentials/:platform", appAdmin, devAppController.DeletePlatformIntegrationCredentials)
+
+	appRouter.Post("/:appId/keys/revoke", appAdmin, devAppController.RevokeAppKeys)
+	appRouter.Get("/:appId/secret-keys", appAdmin, devAppController.FetchAppSecretKeys)
+	appRouter.Post("/:appId/secret-keys", appAdmin, devAppController.CreateAppSecretKey)
+	appRouter.Delete("/:appId/secret-keys/:keyId", appAdmin, devAppController.RevokeAppSecretKey)
+	appRouter.Post("/:appId/secret-keys/:keyId/rotate", appAdmin, devAppController.RotateAppSecretKey)
+
+	// ==========================================
+	// NEXT ROUTES
+	nextRouter := baseRouter.Group("/next", authMiddleware.ValidateKey)
+
+	// TODO: implement checking for superuser access in middleware before deleting then remove kanye prefix
+	nextRouter.Delete("/kanye/task/:taskId", conversionController.DeletePlaylistTask)
+
+	// FIXME: move this endpoint thats fetching link info from the `controllers` package
+	baseRouter.Get("/info", middleware.ExtractLinkInfo(deps.Config.Platforms), controllers.LinkInfo)
+
+	// now to the WS endpoint to connect to when they visit the website and want to "convert". clients authenticate with
+	// the public key of their app, in the public_key query param since browsers cannot set websocket headers. The
+	// conversion messages are limited on the plan of the app, the same as the http routes (see portal.Portal.withinLimits).
+	portalController := portal.NewPortal(deps.DB, deps.Redis, platformsControllers, rateLimiter)
+	portalController.Listen()
+	app.Get("/portal", middleware.PublicKeyFromQuery, authMiddleware.AddReadOnlyDeveloperToContext, ikisocket.New(portalController.Connect))
+
+	return app
+}
+
+// NewProbes returns the HTTP server of the components without an API (the worker and the scheduler), for the probes
+// of the deployments and the scrapes of the metrics.
+func NewProbes(deps *Deps, checker *health.Checker) *fiber.App {
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/healthz", checker.Liveness)
+	app.Get("/readyz", checker.Readiness)
+	app.Get("/metrics", metrics.Handler(deps.Config.MetricsToken))
+	return app
+}
+
+// eventStreamPath matches the paths of the Server-Sent Events endpoints.
+var eventStreamPath = regexp.MustCompile(`^/v1/task/[^/]+/events/?$`)
+
+// isEventStream returns true for the Server-Sent Events endpoints. Their response is streamed, so it must not be buffered
+// by the compress and etag middlewares.
+func isEventStream(ctx *fiber.Ctx) bool {
+	return ctx.Method() == fiber.MethodGet && eventStreamPath.MatchString(ctx.Path())
+}
@@ -0,0 +1,38 @@
+package wiring
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsEventStream(t *testing.T) {
+	app := fiber.New()
+	app.Use(func(ctx *fiber.Ctx) error {
+		if isEventStream(ctx) {
+			return ctx.SendString("stream")
+		}
+		return ctx.SendString("buffered")
+	})
+
+	for _, tc := range []struct {
+		method, path, expected string
+	}{
+		{http.MethodGet, "/v1/task/8b0a2c0e-3c1f-4a57-9d7b-8f0e1c2d3a4b/events", "stream"},
+		{http.MethodGet, "/v1/task/8b0a2c0e-3c1f-4a57-9d7b-8f0e1c2d3a4b/events/", "stream"},
+		{http.MethodPost, "/v1/task/8b0a2c0e-3c1f-4a57-9d7b-8f0e1c2d3a4b/events/token", "buffered"},
+		{http.MethodGet, "/v1/account/notifications/events", "buffered"},
+		{http.MethodGet, "/v1/task/a/b/events", "buffered"},
+		{http.MethodGet, "/api/v1/task/8b0a2c0e/events", "buffered"},
+	} {
+		res, err := app.Test(httptest.NewRequest(tc.method, tc.path, nil))
+		require.NoError(t, err)
+		body := make([]byte, len(tc.expected))
+		_, _ = res.Body.Read(body)
+		assert.Equal(t, tc.expected, string(body), tc.path)
+	}
+}
@@ -0,0 +1,59 @@
+package wiring
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jmoiron/sqlx"
+)
+
+// Migrate runs the migrations of the database that were not run yet.
+func Migrate(dbase *sqlx.DB) error {
+	drver, err := postgres.WithInstance(dbase.DB, &postgres.Config{})
+	if err != nil {
+		log.Printf("Error instantiating db driver: %v", err)
+		return err
+	}
+
+	dbDriver, err := migrate.NewWithDatabaseInstance("file://"+MigrationsDir, "postgres", drver)
+	if err != nil {
+		log.Printf("Error instantiating db driver: %v", err)
+		return err
+	}
+
+	err = dbDriver.Up()
+	if errors.Is(err, migrate.ErrNoChange) {
+		log.Printf("✅ Database migration already up to date. No migration to run")
+		return nil
+	}
+	if err != nil {
+		log.Printf("⛔ Error migrating database: %v", err)
+		return err
+	}
+	log.Printf("✅ Database migration successful")
+	return nil
+}
+
+// MigrateWithRetry runs the migrations, and runs them again every 30 seconds in the background until they succeed if
+// they fail (e.g. the database did not answer). A dirty database must be fixed manually, the migrations are not run
+// again.
+func MigrateWithRetry(dbase *sqlx.DB) {
+	err := Migrate(dbase)
+	var dirty migrate.ErrDirty
+	if err == nil || errors.As(err, &dirty) {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(30 * time.Second)
+		defer ticker.Stop()
+		for range ticker.C {
+			if Migrate(dbase) == nil {
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package wiring
+
+import (
+	"log"
+	"orchdio/services/follow"
+	"orchdio/services/reencrypt"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+const (
+	// notificationsCleanupSchedule is the schedule of the cleanup of the notifications.
+	notificationsCleanupSchedule = "@every 1h"
+	// reencryptSchedule is the schedule of the re-encryption of the secrets.
+	reencryptSchedule = "@every 1h"
+)
+
+// Scheduler runs the background jobs: the cleanup of the notifications and the re-encryption of the secrets. The jobs
+// run on every scheduler, so there must be a single scheduler deployed.
+type Scheduler struct {
+	cron *cron.Cron
+}
+
+// NewScheduler returns the scheduler of the background jobs.
+func NewScheduler(deps *Deps) (*Scheduler, error) {
+	c := cron.New()
+
+	// hERE WE WANT TO SETUP A CRONJOB THAT RUNS EVERY 2 MINS TO PROCESS THE FOLLOWS
+	// update: todo - consider refactoring this, revist this part of the architecture. the follows are enqueued here
+	// and processed by the worker, the job is not scheduled yet:
+	//
+	// c.AddFunc("@every 1m", func() { follow.SyncFollowsHandler(deps.DB, deps.AsynqClient, deps.Config) })
+
+	retention := deps.Config.Notifications
+	_, err := c.AddFunc(notificationsCleanupSchedule, func() {
+		log.Printf("\n[wiring] [info] - :🚂 ⏲️ Cleaning up stale notifications")
+		follow.CleanupNotificationsHandler(deps.DB, retention.ReadRetention, retention.UnreadRetention)
+	})
+	if err != nil {
+		log.Printf("\n[wiring] [error] - Could not schedule the notifications cleanup job.")
+		return nil, err
+	}
+
+	// re-encrypts the secrets stored with an older master key, after a key rotation. see internal/encryption.
+	_, err = c.AddFunc(reencryptSchedule, func() {
+		reencrypt.Handler(deps.DB, deps.Redis, deps.Envelope)
+	})
+	if err != nil {
+		log.Printf("\n[wiring] [error] - Could not schedule the re-encryption job.")
+		return nil, err
+	}
+	return &Scheduler{cron: c}, nil
+}
+
+// Start starts running the jobs.
+func (s *Scheduler) Start() {
+	s.cron.Start()
+}
+
+// Stop stops running the jobs, and waits for up to timeout for the jobs running to finish.
+func (s *Scheduler) Stop(timeout time.Duration) {
+	select {
+	case <-s.cron.Stop().Done():
+	case <-time.After(timeout):
+		log.Printf("[wiring] [warning] - ⛔ Scheduled job still running after %v", timeout)
+	}
+}
@@ -0,0 +1,35 @@
+package wiring
+
+import (
+	"orchdio/config"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/robfig/cron/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedules(t *testing.T) {
+	now := time.Now()
+	for _, spec := range []string{notificationsCleanupSchedule, reencryptSchedule} {
+		schedule, err := cron.ParseStandard(spec)
+		require.NoError(t, err, spec)
+		assert.WithinDuration(t, now.Add(time.Hour), schedule.Next(now), time.Second, spec)
+	}
+}
+
+func TestNewScheduler(t *testing.T) {
+	conn, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	scheduler, err := NewScheduler(&Deps{
+		Config: &config.Config{Notifications: config.Notifications{ReadRetention: time.Hour, UnreadRetention: 2 * time.Hour}},
+		DB:     sqlx.NewDb(conn, "postgres"),
+	})
+	require.NoError(t, err)
+	assert.Len(t, scheduler.cron.Entries(), 2)
+}
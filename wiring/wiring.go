@@ -0,0 +1,153 @@
+// Package wiring sets up Orchdio: its dependencies (the database, redis, the queue client, the logger, etc.) and its
+// components, the API, the queue worker, the scheduler of the background jobs and the migrations. Each component has
+// its command in cmd/, so that they can be deployed and scaled separately; main.go runs them all in one process.
+package wiring
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"orchdio/config"
+	"orchdio/db"
+	"orchdio/health"
+	"orchdio/internal/encryption"
+	"orchdio/logger"
+	"orchdio/metrics"
+	"orchdio/tracing"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/hibiken/asynq"
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// MigrationsDir is the directory of the migrations of the database, relative to the working directory.
+const MigrationsDir = "./db/migration"
+
+// Deps are the dependencies shared by the components.
+type Deps struct {
+	Config          *config.Config
+	Envelope        *encryption.Envelope
+	DB              *sqlx.DB
+	Redis           *redis.Client
+	RedisOpts       asynq.RedisClientOpt
+	AsynqClient     *asynq.Client
+	Inspector       *asynq.Inspector
+	Logger          *zap.Logger
+	LatestMigration uint
+
+	shutdownTracing func(context.Context) error
+}
+
+// Setup loads the configuration and sets up the dependencies. A configuration with values missing or invalid is an
+// error, with the report of the values (see config.Error). A database or redis that does not answer is not an error:
+// the components start and are not ready until they do (see Deps.Checks). Close must be called before the process exits.
+func Setup() (*Deps, error) {
+	cfg, err := config.Load()
+	if err != nil {
+		return nil, err
+	}
+	// the logger is set up once the configuration is loaded, and before anything else as the standard log package writes
+	// to it from now on. see logger.Init.
+	appLogger, err := logger.Init(cfg.Log)
+	if err != nil {
+		return nil, fmt.Errorf("could not set up the logger: %w", err)
+	}
+	// the clients of the platforms use the default transport, so the calls they make are recorded in the metrics and traced.
+	http.DefaultTransport = tracing.Transport(metrics.Transport(http.DefaultTransport))
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("could not set up the tracing: %w", err)
+	}
+
+	dbase, dErr := db.ConnectDB(cfg.DatabaseURL)
+	if dbase == nil {
+		return nil, fmt.Errorf("could not connect to the database: %w", dErr)
+	}
+	if dErr != nil {
+		log.Printf("⛔ Could not connect to the Postgresql database. The instance is not ready until it answers: %v", dErr)
+	} else {
+		log.Println("✅ Connected to Postgresql database")
+	}
+
+	// the master keys are loaded here so that a missing or invalid key file fails at startup rather than on the first
+	// encrypted record.
+	envelope, err := encryption.FromConfig(cfg.Encryption)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the encryption keys: %w", err)
+	}
+	log.Printf("✅ Encrypting with master key %s", envelope.KMS.CurrentKeyID())
+
+	redisOpts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing redis url: %w", err)
+	}
+	redisClient := redis.NewClient(redisOpts)
+	if pErr := redisClient.Ping(context.Background()).Err(); pErr != nil {
+		log.Printf("\n[wiring] [error] - ⛔ Could not connect to redis. Are you sure redis is configured correctly? The instance is not ready until it answers: %v", pErr)
+	}
+	if cfg.Env == config.EnvProduction {
+		log.Printf("\n[wiring] [info] - Running in production mode. Connecting to authenticated redis")
+	}
+
+	latestMigration, err := health.LatestMigration(MigrationsDir)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the migrations: %w", err)
+	}
+
+	asynqRedis := asynq.RedisClientOpt{Addr: redisOpts.Addr, Password: redisOpts.Password}
+	return &Deps{
+		Config:          cfg,
+		Envelope:        envelope,
+		DB:              dbase,
+		Redis:           redisClient,
+		RedisOpts:       asynqRedis,
+		AsynqClient:     asynq.NewClient(asynqRedis),
+		Inspector:       asynq.NewInspector(asynqRedis),
+		Logger:          appLogger,
+		LatestMigration: latestMigration,
+		shutdownTracing: shutdownTracing,
+	}, nil
+}
+
+// Checks returns the checks of the readiness shared by the components: the database, redis and the migrations.
+func (d *Deps) Checks() []health.Check {
+	return []health.Check{
+		health.Postgres(d.DB),
+		health.Redis(d.Redis),
+		health.Migrations(d.DB, d.LatestMigration),
+	}
+}
+
+// Close closes the connections and flushes the spans and the logs.
+func (d *Deps) Close() {
+	_ = d.AsynqClient.Close()
+	_ = d.Inspector.Close()
+	_ = d.Redis.Close()
+	_ = d.DB.Close()
+	_ = d.shutdownTracing(context.Background())
+	_ = d.Logger.Sync()
+}
+
+// Address returns the address the components listen on, on the port of the configuration.
+func (d *Deps) Address() string {
+	log.Printf("✅🔱 Port: %v", d.Config.Port)
+	return fmt.Sprintf(":%s", d.Config.Port)
+}
+
+// NewChecker returns the checker of the readiness with the checks, see health.NewChecker.
+func (d *Deps) NewChecker(checks ...health.Check) *health.Checker {
+	return health.NewChecker(d.Config.HTTP.HealthCheckTimeout, checks...)
+}
+
+// WaitForSignal blocks until the process receives SIGINT or SIGTERM.
+func WaitForSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+	signal.Stop(signals)
+}
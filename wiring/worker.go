@@ -0,0 +1,214 @@
+package wiring
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"orchdio/blueprint"
+	"orchdio/health"
+	"orchdio/logger"
+	"orchdio/metrics"
+	"orchdio/queue"
+	"orchdio/services/follow"
+	"orchdio/tracing"
+	"orchdio/util"
+	"orchdio/webhooks"
+	orchdiowebhook "orchdio/webhooks/orchdio"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Worker is the queue worker: it processes the tasks of the queues (the playlist conversions, the emails, the webhook
+// deliveries and the follows).
+type Worker struct {
+	Server   *asynq.Server
+	Mux      *asynq.ServeMux
+	InFlight *queue.InFlight
+	// DrainTimeout is how long the tasks in flight have to finish on shutdown, before they are put back in their queue.
+	DrainTimeout time.Duration
+	// HandlerGracePeriod is how long the handlers of the tasks put back in their queue have to stop once cancelled.
+	HandlerGracePeriod time.Duration
+}
+
+// NewWorker returns the queue worker, with its handlers. The metrics of the queues are registered with it, so there
+// must be a single worker per process.
+func NewWorker(deps *Deps) *Worker {
+	prometheus.MustRegister(metrics.NewQueueCollector(deps.Inspector))
+	inFlight := queue.NewInFlight()
+	mux := asynq.NewServeMux()
+	server := asynq.NewServer(deps.RedisOpts,
+		asynq.Config{Concurrency: deps.Config.Queue.Concurrency,
+			ShutdownTimeout: deps.Config.Queue.DrainTimeout,
+			Logger:          deps.Logger.Named("asynq").Sugar(),
+			Queues:          deps.Config.Queue.Weights,
+			// webhook deliveries (built-in webhook sender) are retried with an exponential backoff. other tasks use the default delay.
+			RetryDelayFunc: func(n int, e error, task *asynq.Task) time.Duration {
+				if task.Type() == blueprint.WebhookDeliveryTaskTypePattern {
+					return orchdiowebhook.RetryDelay(n, e, task)
+				}
+				return asynq.DefaultRetryDelayFunc(n, e, task)
+			},
+			// NB: from the queue CheckForOrphanedTasksMiddleware, when we handle orphaned task and we return a blueprint.EnoResult error, the execution
+			// jumps here, so when the middleware runs and we return a blueprint.EnoResult error, it'll run this block and reprocess the task
+			// if the handler has successfully been attached or do nothing (and let the queue retry later) if there was an error
+			ErrorHandler: asynq.ErrorHandlerFunc(func(ctx context.Context, task *asynq.Task, err error) {
+				log.Printf("[wiring][QueueErrorHandler] Running queue server error handler...")
+				// handle for each task here
+
+				// check if the task is an email task
+				isEmailQueue := util.IsTaskType(task.Type(), "send:appauth")
+				if isEmailQueue {
+					queueInfo, qErr := deps.Inspector.GetQueueInfo(blueprint.EmailQueueName)
+					if qErr != nil {
+						log.Printf("[wiring] [QueueErrorHandler] Error getting queue info %v", qErr)
+						return
+					}
+					if queueInfo.Paused {
+						log.Printf("[wiring] [QueueErrorHandler] Email queue is paused.. Unpausing")
+						err = deps.Inspector.UnpauseQueue(blueprint.EmailQueueName)
+						return
+					}
+					notFound := asynq.NotFound(context.Background(), task)
+
+					if notFound != nil {
+						log.Printf("[wiring] [QueueErrorHandler] Going to retry the handler needed to be run")
+						emailQueue := queue.NewOrchdioQueue(deps.AsynqClient, deps.DB, deps.Redis, mux, deps.Envelope, deps.Config)
+						var emailData blueprint.EmailTaskData
+						err = json.Unmarshal(task.Payload(), &emailData)
+						if err != nil {
+							log.Printf("[wiring][QueueErrorHandler] error - could not unmarshal email task data %v", err)
+							return
+						}
+						// schedule the email
+						err = emailQueue.SendEmail(&emailData)
+						if err != nil {
+							log.Printf("[wiring][QueueErrorHandler] error - could not schedule email %v", err)
+							return
+						}
+						log.Printf("[wiring][QueueErrorHandler] info - successfully scheduled email")
+						return
+					}
+					// the task is found...
+					log.Printf("[wiring] [QueueErrorHandler] Task found in queue. Seems this task is not orphaned. Doing nothing")
+					return
+				}
+
+				// conversion queue
+				isConversionQueue := util.IsTaskType(task.Type(), blueprint.PlaylistConversionTaskTypePattern)
+				if isConversionQueue {
+					// check that the queue isnt paused
+					queueInfo, qErr := deps.Inspector.GetQueueInfo(blueprint.PlaylistConversionQueueName)
+					if qErr != nil {
+						log.Printf("[wiring] [QueueErrorHandler] Error getting queue info %v", qErr)
+						return
+					}
+
+					var taskData blueprint.PlaylistTaskData
+					err = json.Unmarshal(task.Payload(), &taskData)
+					if err != nil {
+						log.Printf("[wiring] [QueueErrorHandler] Error unmarshalling task payload %v", err)
+						return
+					}
+
+					taskData.LinkInfo.TaskID = taskData.TaskID
+
+					log.Printf("[wiring] [QueueErrorHandler] Queue info %v", queueInfo)
+					if queueInfo.Paused {
+						log.Printf("[wiring][QueueErrorHandler] Queue is paused")
+						err = deps.Inspector.UnpauseQueue(blueprint.PlaylistConversionQueueName)
+						return
+					}
+
+					// check if task has already been scheduled (has an handler), by fetching task from queue
+					notFound := asynq.NotFound(context.Background(), task)
+					if notFound != nil {
+						log.Printf("[wiring] [QueueErrorHandler] Going to retry the handler needed to be run")
+						playlistQueue := queue.NewOrchdioQueue(deps.AsynqClient, deps.DB, deps.Redis, mux, deps.Envelope, deps.Config)
+						// schedule the playlist conversion
+						var playlistData blueprint.PlaylistTaskData
+						err = json.Unmarshal(task.Payload(), &playlistData)
+						if err != nil {
+							log.Printf("[wiring][QueueErrorHandler] error - could not unmarshal playlist task data %v", err)
+							return
+						}
+						playlistData.LinkInfo.TaskID = playlistData.TaskID
+						err = playlistQueue.PlaylistHandler(ctx, playlistData.TaskID, playlistData.ShortURL, playlistData.LinkInfo, playlistData.App.UID.String())
+						if err != nil {
+							log.Printf("[wiring][QueueErrorHandler] error - could not retry playlist conversion.. %v", err)
+							return
+						}
+					}
+
+					taskHandler := queue.NewOrchdioQueue(deps.AsynqClient, deps.DB, deps.Redis, mux, deps.Envelope, deps.Config)
+					err = taskHandler.PlaylistHandler(ctx, task.ResultWriter().TaskID(), taskData.ShortURL, taskData.LinkInfo, taskData.App.UID.String())
+					if err != nil {
+						log.Printf("[wiring] [QueueErrorHandler] Error processing task %v", err)
+						return
+					}
+
+					log.Printf("[wiring] [QueueErrorHandler] Task already has a handler")
+				}
+			}),
+		})
+
+	mux.Use(inFlight.Middleware, tracing.TaskMiddleware, logger.TaskMiddleware, metrics.TaskMiddleware, queue.CheckForOrphanedTasksMiddleware)
+	orchdioQueue := queue.NewOrchdioQueue(deps.AsynqClient, deps.DB, deps.Redis, mux, deps.Envelope, deps.Config)
+	webhookSender := webhooks.NewWebhookSender(deps.DB, deps.AsynqClient, deps.Config.Webhooks)
+	mux.HandleFunc(blueprint.EmailQueueTaskTypePattern, orchdioQueue.SendEmailHandler)
+	mux.HandleFunc(blueprint.PlaylistConversionTaskTypePattern, orchdioQueue.PlaylistTaskHandler)
+	mux.HandleFunc(blueprint.SendResetPasswordTaskPattern, orchdioQueue.SendEmailHandler)
+	mux.HandleFunc(blueprint.SendWelcomeEmailTaskPattern, orchdioQueue.SendEmailHandler)
+	mux.HandleFunc(blueprint.SendOrgInviteEmailTaskPattern, orchdioQueue.SendEmailHandler)
+	mux.HandleFunc(blueprint.FollowTaskTypePattern, follow.NewTaskCronHandler(deps.DB, deps.Redis, deps.Config, webhookSender, deps.Envelope).ProcessFollowTaskHandler)
+	// the delivery handler for the built-in webhook sender is always attached so that pending deliveries are still
+	// processed after switching the webhook provider.
+	builtInWebhookSender := webhooks.NewBuiltInSender(deps.DB, deps.AsynqClient, deps.Config.Webhooks)
+	mux.HandleFunc(blueprint.WebhookDeliveryTaskTypePattern, builtInWebhookSender.DeliveryTaskHandler)
+
+	return &Worker{Server: server, Mux: mux, InFlight: inFlight, DrainTimeout: deps.Config.Queue.DrainTimeout,
+		HandlerGracePeriod: deps.Config.Queue.HandlerGracePeriod}
+}
+
+// Checks returns the checks of the readiness of the worker.
+func (w *Worker) Checks(deps *Deps) []health.Check {
+	return []health.Check{health.QueueServer(w.Server, deps.Inspector)}
+}
+
+// Start unpauses the queues and starts processing their tasks. The queues are unpaused as they could have been paused
+// by an instance that shut down, before the shutdown drained the tasks instead.
+func (w *Worker) Start(deps *Deps) error {
+	queues, err := deps.Inspector.Queues()
+	if err != nil {
+		log.Printf("[wiring][Worker] Error getting queues %v", err)
+		return err
+	}
+	for _, q := range queues {
+		queueInfo, qErr := deps.Inspector.GetQueueInfo(q)
+		if qErr != nil {
+			log.Printf("[wiring][Worker] Error getting queue info %v", qErr)
+			return qErr
+		}
+		if queueInfo.Paused {
+			log.Printf("[wiring][Worker] Queue %s is paused. Unpausing..", q)
+			if qErr = deps.Inspector.UnpauseQueue(q); qErr != nil {
+				log.Printf("[wiring][Worker] Error unpausing queue %v", qErr)
+				return qErr
+			}
+		}
+	}
+	return w.Server.Start(w.Mux)
+}
+
+// Shutdown drains the tasks in flight for up to DrainTimeout and stops the worker. The tasks that are not done by then
+// are put back in their queue by asynq, to be processed by the next worker.
+func (w *Worker) Shutdown() {
+	log.Printf("[wiring] [info] - ⏸️ 🏭 Draining the queues, for up to %v", w.DrainTimeout)
+	w.Server.Shutdown()
+	// the handlers of the tasks put back in their queue are cancelled, they drop the webhook events pending as they stop.
+	if !w.InFlight.Wait(w.HandlerGracePeriod) {
+		log.Printf("[wiring] [warning] - ⛔ Task handlers still running after the queue shut down")
+	}
+	log.Printf("[wiring] [info] - ✅ 🏭 Queues drained")
+}